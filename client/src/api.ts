@@ -10,6 +10,13 @@ const client = axios.create({
   },
 });
 
+// Every key exchange request carries a fresh nonce and the current time, so
+// the server can refuse one that is replayed or held back
+function dhSubmission(): { nonce: string; timestamp: number } {
+  const nonce = Array.from(crypto.getRandomValues(new Uint8Array(16)), (b) => b.toString(16).padStart(2, '0')).join('');
+  return { nonce, timestamp: Math.floor(Date.now() / 1000) };
+}
+
 // WebSocket connection
 let ws: WebSocket | null = null;
 let wsSubscribers: Map<string, Set<(data: any) => void>> = new Map();
@@ -48,6 +55,14 @@ export const wsService = {
             
             console.log('[WS] Received event:', type, message);
             
+            // Acknowledge new messages so their sender sees them as delivered
+            if (type === 'message_received' && message.data && message.data.id && connection.readyState === WebSocket.OPEN) {
+              connection.send(JSON.stringify({
+                type: 'message_ack',
+                data: { chat_id: message.data.chat_id, message_id: message.data.id },
+              }));
+            }
+            
             // For message_received events, also route by chat_id
             if (type === 'message_received' && message.data && message.data.chat_id) {
               const chatKey = `message_${message.data.chat_id}`;
@@ -134,6 +149,16 @@ export const wsService = {
   isConnected: (): boolean => {
     return ws !== null && ws.readyState === WebSocket.OPEN;
   },
+
+  // Acknowledge every message of a chat up to upToMessageId as delivered or read
+  sendReceipt: (chatId: number, upToMessageId: number, status: 'delivered' | 'read'): boolean => {
+    if (!ws || ws.readyState !== WebSocket.OPEN) return false;
+    ws.send(JSON.stringify({
+      type: 'message_receipt',
+      data: { chat_id: chatId, up_to_message_id: upToMessageId, status },
+    }));
+    return true;
+  },
 };
 
 export interface LoginResponse {
@@ -141,6 +166,34 @@ export interface LoginResponse {
   username: string;
   token: string;
   encrypted_private_key?: string;
+  // Set when logging in with a device ID
+  device_id?: number;
+  device_encrypted_private_key?: string;
+}
+
+export interface Device {
+  id: number;
+  user_id: number;
+  name: string;
+  public_key: string;
+  created_at: number;
+  last_seen_at: number;
+}
+
+export interface KeyShare {
+  chat_id?: number;
+  sender_device_id?: number;
+  recipient_device_id: number;
+  share: string;
+  created_at?: number;
+}
+
+// The key bundle sealed under a backup passphrase; backup is hex
+export interface KeyBackup {
+  backup?: string;
+  kdf: string;
+  created_at: number;
+  updated_at: number;
 }
 
 export interface RegisterResponse {
@@ -156,14 +209,46 @@ export interface ChatResponse {
   mode: string;
   padding: string;
   created_at: string;
+  key_agreement?: string;
+  dh_group?: string;
+  key_mode?: string;
+}
+
+// Crypto parameters the server accepts for chats
+export interface CryptoCapabilities {
+  algorithms: { name: string; block_size: number; key_size: number; key_sizes: number[] }[];
+  cipher_families: string[];
+  modes: { name: string; requires_iv: boolean; authenticated: boolean; block_size?: number }[];
+  paddings: string[];
+  dh_groups: { name: string; bits: number; generator: number; default?: boolean }[];
+  key_agreements: string[];
+  // 'server' and/or 'client_only'; client_only_keys means the server never
+  // stores session keys for any chat
+  key_modes: string[];
+  default_key_mode: string;
+  client_only_keys: boolean;
 }
 
 export interface MessageResponse {
   message_id: number;
   chat_id: number;
   sender_id: number;
+  seq: number;
   timestamp: string;
   status: string;
+  message_uuid?: string;
+  duplicate?: boolean;
+}
+
+export interface MessageFilters {
+  sender_id?: number;
+  has_file?: boolean;
+  media?: 'image' | 'video' | 'audio' | 'document';
+  since?: number; // unix seconds
+  until?: number; // unix seconds
+  // Sequence range (inclusive), e.g. to fill a gap after reconnecting
+  from_seq?: number;
+  to_seq?: number;
 }
 
 export const apiService = {
@@ -176,8 +261,10 @@ export const apiService = {
     return response.data;
   },
 
-  async login(username: string, password: string): Promise<LoginResponse> {
-    const response = await client.post('/auth/login', { username, password });
+  async login(username: string, password: string, deviceId?: number): Promise<LoginResponse> {
+    const body: any = { username, password };
+    if (deviceId) body.device_id = deviceId;
+    const response = await client.post('/auth/login', body);
     return response.data;
   },
 
@@ -186,6 +273,11 @@ export const apiService = {
     return response.data;
   },
 
+  async getCryptoCapabilities(): Promise<CryptoCapabilities> {
+    const response = await client.get('/crypto/capabilities');
+    return response.data;
+  },
+
   // Contacts
   async sendContactRequest(contactId: number, action: string = 'add'): Promise<any> {
     const response = await client.post('/contacts/request', {
@@ -226,13 +318,19 @@ export const apiService = {
     user2Id: number,
     algorithm: string,
     mode: string,
-    padding: string
+    padding: string,
+    keyAgreement?: string,
+    dhGroup?: string,
+    keyMode?: string
   ): Promise<ChatResponse> {
     const response = await client.post('/chats/create', {
       user2_id: user2Id,
       algorithm,
       mode,
       padding,
+      key_agreement: keyAgreement,
+      dh_group: dhGroup || undefined,
+      key_mode: keyMode || undefined,
     });
     
     // Check if server returned error in the response
@@ -271,35 +369,246 @@ export const apiService = {
     ciphertext: string,
     iv: string,
     fileName?: string,
-    mimeType?: string
+    mimeType?: string,
+    replyToMessageId?: number,
+    messageUuid?: string,
+    preview?: { ciphertext: string; iv: string }, // link preview encrypted like the message, with its own IV
+    urgent?: boolean, // alerts the recipient even if muted; rate-limited (HTTP 429)
+    expiresAt?: number, // unix seconds; the message disappears from history afterwards
+    ratchetHeader?: string // hex; the ciphertext is then a ratchet envelope and iv is empty
   ): Promise<MessageResponse> {
+    // Reuse the same messageUuid when retrying so the server does not store the message twice
     const body: any = {
       chat_id: chatId,
       ciphertext,
       iv,
+      message_uuid: messageUuid || crypto.randomUUID(),
     };
     if (fileName) body.file_name = fileName;
     if (mimeType) body.mime_type = mimeType;
+    if (replyToMessageId) body.reply_to_message_id = replyToMessageId;
+    if (preview) {
+      body.preview = preview.ciphertext;
+      body.preview_iv = preview.iv;
+    }
+    if (urgent) body.urgent = true;
+    if (expiresAt) body.expires_at = expiresAt;
+    if (ratchetHeader) body.ratchet_header = ratchetHeader;
     const response = await client.post('/messages/send', body);
     return response.data;
   },
 
-  async getMessages(chatId: number, limit: number = 100, offset: number = 0): Promise<any> {
-    const response = await client.get(`/chats/${chatId}/messages`, {
-      params: { limit, offset },
-    });
+  // Returns { messages, direction, next_cursor, has_more }. Pass next_cursor as
+  // beforeId to load the previous (older) page. Filters only use unencrypted
+  // metadata, e.g. { media: 'image' } for a media tab.
+  async getMessages(chatId: number, limit: number = 100, beforeId?: number, filters?: MessageFilters): Promise<any> {
+    const params: any = { limit, ...filters };
+    if (beforeId) params.before_id = beforeId;
+    const response = await client.get(`/chats/${chatId}/messages`, { params });
+    return response.data;
+  },
+
+  // Bulk receipt: marks all messages up to upToMessageId as delivered or read
+  async sendReceipt(chatId: number, upToMessageId: number, status: 'delivered' | 'read'): Promise<void> {
+    await client.post(`/chats/${chatId}/receipts`, { up_to_message_id: upToMessageId, status });
+  },
+
+  // Account-wide sync: returns { messages, chats, contacts, chat_ids, contact_ids, cursor, has_more }.
+  // Store cursor and pass it back as since; call again right away while has_more is set.
+  async sync(since?: string): Promise<any> {
+    const params: any = {};
+    if (since) params.since = since;
+    const response = await client.get('/sync', { params });
+    return response.data;
+  },
+
+  // Full history export as NDJSON text; pass the last exported ID as afterId to resume
+  async exportMessages(chatId: number, afterId?: number): Promise<string> {
+    const params: any = {};
+    if (afterId) params.after_id = afterId;
+    const response = await client.get(`/chats/${chatId}/messages/export`, { params, responseType: 'text' });
+    return response.data;
+  },
+
+  // Encrypted search: tokens are hex-encoded keyed hashes computed by the client
+  async searchMessages(chatId: number, tokensHex: string[], beforeId?: number, limit?: number): Promise<any> {
+    const params: any = { tokens: tokensHex.join(',') };
+    if (beforeId) params.before_id = beforeId;
+    if (limit) params.limit = limit;
+    const response = await client.get(`/chats/${chatId}/search`, { params });
+    return response.data;
+  },
+
+  async indexMessage(chatId: number, messageId: number, tokensHex: string[]): Promise<any> {
+    const response = await client.put(`/chats/${chatId}/messages/${messageId}/search-tokens`, { tokens: tokensHex });
+    return response.data;
+  },
+
+  // Pinned messages
+  async getPins(chatId: number): Promise<any> {
+    const response = await client.get(`/chats/${chatId}/pins`);
+    return response.data;
+  },
+
+  async pinMessage(chatId: number, messageId: number): Promise<any> {
+    const response = await client.put(`/chats/${chatId}/pins/${messageId}`);
+    return response.data;
+  },
+
+  async unpinMessage(chatId: number, messageId: number): Promise<any> {
+    const response = await client.delete(`/chats/${chatId}/pins/${messageId}`);
+    return response.data;
+  },
+
+  // Drafts (ciphertext and iv are hex encoded; an empty ciphertext clears the draft)
+  async getDraft(chatId: number): Promise<any> {
+    const response = await client.get(`/chats/${chatId}/draft`);
+    return response.data;
+  },
+
+  async saveDraft(chatId: number, ciphertextHex: string, ivHex: string): Promise<any> {
+    const response = await client.put(`/chats/${chatId}/draft`, { ciphertext: ciphertextHex, iv: ivHex });
+    return response.data;
+  },
+
+  async clearDraft(chatId: number): Promise<any> {
+    const response = await client.delete(`/chats/${chatId}/draft`);
     return response.data;
   },
 
+  // Double Ratchet: the latest ratchet key and message number of each sender
+  async getRatchet(chatId: number): Promise<any> {
+    const response = await client.get(`/chats/${chatId}/ratchet`);
+    return response.data;
+  },
+
+  // Starts the chat's ratchet over; the other participant gets ratchet_reset
+  async resetRatchet(chatId: number): Promise<any> {
+    const response = await client.delete(`/chats/${chatId}/ratchet`);
+    return response.data;
+  },
+
+  // Safety number of a chat: 60 digits made from both participants' identity keys
+  async getSafetyNumber(chatId: number): Promise<any> {
+    const response = await client.get(`/chats/${chatId}/safety-number`);
+    return response.data;
+  },
+
+  // SAS verification: { chat_id, verified, confirmed_by }. confirmSAS sends the
+  // short code this client showed; 409 if the keys changed in the meantime.
+  async getVerification(chatId: number): Promise<any> {
+    const response = await client.get(`/chats/${chatId}/verification`);
+    return response.data;
+  },
+
+  async confirmSAS(chatId: number, code: string): Promise<any> {
+    const response = await client.post(`/chats/${chatId}/verification`, { code });
+    return response.data;
+  },
+
+  async rejectSAS(chatId: number): Promise<any> {
+    const response = await client.delete(`/chats/${chatId}/verification`);
+    return response.data;
+  },
+
+  // Contact verification: { user_id, contact_id, identity_key, verified,
+  // verified_at }. verifyContact sends this user's signature over the
+  // contact's identity key.
+  async getContactVerification(contactId: number): Promise<any> {
+    const response = await client.get(`/contacts/${contactId}/verification`);
+    return response.data;
+  },
+
+  async verifyContact(contactId: number, signatureHex: string): Promise<any> {
+    const response = await client.post(`/contacts/${contactId}/verification`, { signature: signatureHex });
+    return response.data;
+  },
+
+  // Devices, each with its own X25519 key pair. Key shares carry chat key
+  // material sealed by one device to another; the server cannot open them.
+  async registerDevice(name: string, publicKeyHex: string, encryptedPrivateKeyHex?: string): Promise<Device> {
+    const body: any = { name, public_key: publicKeyHex };
+    if (encryptedPrivateKeyHex) body.encrypted_private_key = encryptedPrivateKeyHex;
+    const response = await client.post('/devices', body);
+    return response.data;
+  },
+
+  async listDevices(): Promise<Device[]> {
+    const response = await client.get('/devices');
+    return response.data || [];
+  },
+
+  async listUserDevices(userId: number): Promise<Device[]> {
+    const response = await client.get(`/users/${userId}/devices`);
+    return response.data || [];
+  },
+
+  async revokeDevice(deviceId: number): Promise<void> {
+    await client.delete(`/devices/${deviceId}`);
+  },
+
+  async shareChatKeys(chatId: number, senderDeviceId: number, shares: KeyShare[]): Promise<void> {
+    await client.post(`/chats/${chatId}/key-shares`, { sender_device_id: senderDeviceId, shares });
+  },
+
+  // Resolves null if no share was sealed to the device
+  async getKeyShare(chatId: number, deviceId: number): Promise<KeyShare | null> {
+    try {
+      const response = await client.get(`/chats/${chatId}/key-shares`, { params: { device_id: deviceId } });
+      return response.data;
+    } catch (err: any) {
+      if (err.response?.status === 404) return null;
+      throw err;
+    }
+  },
+
+  // Key backup, sealed on this device under a passphrase the server never sees
+  async saveKeyBackup(backupHex: string): Promise<KeyBackup> {
+    const response = await client.put('/me/key-backup', { backup: backupHex });
+    return response.data;
+  },
+
+  // Resolves null if the user has no backup
+  async getKeyBackup(): Promise<KeyBackup | null> {
+    try {
+      const response = await client.get('/me/key-backup');
+      return response.data;
+    } catch (err: any) {
+      if (err.response?.status === 404) return null;
+      throw err;
+    }
+  },
+
+  async deleteKeyBackup(): Promise<void> {
+    await client.delete('/me/key-backup');
+  },
+
   // Diffie-Hellman Key Exchange
+  // State of a chat's key exchange: awaiting_initiator, awaiting_peer,
+  // complete or stale; dh_state_changed events announce every change
+  async getDHStatus(chatId: number): Promise<{ chat_id: number; state: string; published_by: number[]; stale_user_ids?: number[]; changed_at: number }> {
+    const response = await client.get(`/chats/${chatId}/dh/status`);
+    return response.data;
+  },
+
   async initDHExchange(chatId: number): Promise<any> {
-    const response = await client.post(`/chats/${chatId}/dh/init`);
+    const response = await client.post(`/chats/${chatId}/dh/init`, dhSubmission());
     return response.data;
   },
 
-  async completeDHExchange(chatId: number, publicKeyHex: string): Promise<any> {
+  async completeDHExchange(chatId: number, publicKeyHex: string, epoch?: number, signatureHex?: string): Promise<any> {
     const response = await client.post(`/chats/${chatId}/dh/exchange`, {
       public_key: publicKeyHex,
+      epoch,
+      signature: signatureHex,
+      ...dhSubmission(),
+    });
+    return response.data;
+  },
+
+  async setIdentityKey(identityKeyHex: string): Promise<any> {
+    const response = await client.put('/me/identity-key', {
+      identity_key: identityKeyHex,
     });
     return response.data;
   },
@@ -1,6 +1,9 @@
 import React, { useState, useEffect } from 'react';
-import apiService, { wsService } from '../api';
+import apiService, { wsService, CryptoCapabilities } from '../api';
 import { Chat } from '../db';
+import { SUPPORTED_MODES, SUPPORTED_PADDINGS, wasmCapabilities, capabilityMismatches, restrictCapabilities } from '../wasm/cryptoWrapper';
+import { restartRatchet } from '../utils/ratchet';
+import { createKeyBackup, MIN_PASSPHRASE_LENGTH } from '../utils/keyBackup';
 
 interface ChatSelectorProps {
   userId: number;
@@ -8,9 +11,11 @@ interface ChatSelectorProps {
   onCreateChat: (chat: Chat) => void;
 }
 
-const ALGORITHMS = ['LOKI97', 'RC6'];
-const MODES = ['ECB', 'CBC', 'PCBC', 'CFB', 'OFB', 'CTR', 'RandomDelta'];
-const PADDINGS = ['ZEROS', 'PKCS7', 'ANSIX923', 'ISO10126'];
+// Used until GET /api/crypto/capabilities answers, and if it fails
+const ALGORITHMS = ['LOKI97', 'LOKI97-192', 'LOKI97-256', 'RC6', 'AES', 'MARS'];
+const MODES: string[] = [...SUPPORTED_MODES];
+const PADDINGS: string[] = [...SUPPORTED_PADDINGS];
+const RC6_KEY_SIZES = [16, 24, 32];
 
 export const ChatSelector: React.FC<ChatSelectorProps> = ({
   userId,
@@ -22,9 +27,50 @@ export const ChatSelector: React.FC<ChatSelectorProps> = ({
   const [selectedAlgorithm, setSelectedAlgorithm] = useState('LOKI97');
   const [selectedMode, setSelectedMode] = useState('CBC');
   const [selectedPadding, setSelectedPadding] = useState('PKCS7');
+  const [selectedKeyAgreement, setSelectedKeyAgreement] = useState('DH');
+  // Empty uses the global DH parameters, which the registration keys belong to
+  const [selectedDHGroup, setSelectedDHGroup] = useState('');
+  // Empty uses the server's default key mode
+  const [selectedKeyMode, setSelectedKeyMode] = useState('');
+  // RC6 variants for experiments, sent as "RC6-32/<rounds>/<key bytes>"
+  const [rc6Rounds, setRc6Rounds] = useState(20);
+  const [rc6KeySize, setRc6KeySize] = useState(16);
   const [targetUserId, setTargetUserId] = useState('');
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState('');
+  const [capabilities, setCapabilities] = useState<CryptoCapabilities | null>(null);
+
+  useEffect(() => {
+    apiService.getCryptoCapabilities()
+      .then(async (caps) => {
+        // Only offer what this client's WASM module can do as well
+        const local = await wasmCapabilities().catch(() => null);
+        if (local) {
+          const mismatches = capabilityMismatches(caps, local);
+          if (mismatches.length > 0) {
+            console.warn('[ChatSelector] Server and WASM crypto differ:', mismatches);
+          }
+          caps = restrictCapabilities(caps, local);
+        }
+        setCapabilities(caps);
+      })
+      .catch((err) => console.warn('[ChatSelector] Failed to load crypto capabilities:', err));
+  }, []);
+
+  const algorithms = capabilities?.algorithms.map((a) => a.name) ?? ALGORITHMS;
+  const paddings = capabilities?.paddings ?? PADDINGS;
+  const keyAgreements = capabilities?.key_agreements ?? ['DH'];
+  const dhGroups = capabilities?.dh_groups ?? [];
+  const keyModes = capabilities?.key_modes ?? [];
+  // Modes tied to a block size (GCM) only show up for ciphers that have it
+  const blockSize = capabilities?.algorithms.find((a) => a.name === selectedAlgorithm)?.block_size;
+  const modes = capabilities
+    ? capabilities.modes.filter((m) => !m.block_size || m.block_size === blockSize).map((m) => m.name)
+    : MODES;
+
+  useEffect(() => {
+    if (!modes.includes(selectedMode)) setSelectedMode('CBC');
+  }, [selectedAlgorithm, capabilities]);
 
   useEffect(() => {
     loadChats();
@@ -33,6 +79,11 @@ export const ChatSelector: React.FC<ChatSelectorProps> = ({
     const unsubscribe = wsService.subscribe('*', (event: any) => {
       console.log('[ChatSelector] Received event:', event.type, event);
       
+      // The other participant started the chat's ratchet over
+      if (event.type === 'ratchet_reset') {
+        restartRatchet(event.data?.chat_id).catch(err => console.warn('[ChatSelector] Failed to restart ratchet:', err));
+      }
+
       // Handle chat creation events
       if (event.type === 'chat_created') {
         console.log('[ChatSelector] New chat created, reloading chats...');
@@ -79,17 +130,41 @@ export const ChatSelector: React.FC<ChatSelectorProps> = ({
     }
   };
 
+  // Seals this device's keys under a passphrase of their own and uploads them
+  const handleBackupKeys = async () => {
+    const passphrase = window.prompt(`Backup passphrase (at least ${MIN_PASSPHRASE_LENGTH} characters, not your login password):`);
+    if (!passphrase) return;
+    if (window.prompt('Repeat the backup passphrase:') !== passphrase) {
+      setError('The backup passphrases do not match');
+      return;
+    }
+    try {
+      const count = await createKeyBackup(passphrase);
+      setError('');
+      window.alert(`Backed up ${count} keys. You need the passphrase to restore them on a new device.`);
+    } catch (err: any) {
+      console.error('[ChatSelector] Failed to back up keys:', err);
+      setError(err.message || 'Failed to back up keys');
+    }
+  };
+
   const handleCreateChat = async (e: React.FormEvent) => {
     e.preventDefault();
     if (!targetUserId.trim()) return;
 
     setLoading(true);
     try {
+      const algorithm = selectedAlgorithm === 'RC6' && (rc6Rounds !== 20 || rc6KeySize !== 16)
+        ? `RC6-32/${rc6Rounds}/${rc6KeySize}`
+        : selectedAlgorithm;
       const response = await apiService.createChat(
         parseInt(targetUserId),
-        selectedAlgorithm,
+        algorithm,
         selectedMode,
-        selectedPadding
+        selectedPadding,
+        selectedKeyAgreement,
+        selectedKeyAgreement === 'DH' ? selectedDHGroup : undefined,
+        selectedKeyMode
       );
 
       const newChat: Chat = {
@@ -130,12 +205,20 @@ export const ChatSelector: React.FC<ChatSelectorProps> = ({
     <div className="bg-white rounded-lg shadow p-6">
       <div className="flex justify-between items-center mb-4">
         <h2 className="text-2xl font-bold text-gray-800">Secret Chats</h2>
-        <button
-          onClick={() => setShowCreateForm(!showCreateForm)}
-          className="bg-blue-500 hover:bg-blue-600 text-white px-4 py-2 rounded-lg transition"
-        >
-          {showCreateForm ? 'Cancel' : '+ New Chat'}
-        </button>
+        <div className="flex gap-2">
+          <button
+            onClick={handleBackupKeys}
+            className="bg-gray-200 hover:bg-gray-300 text-gray-800 px-4 py-2 rounded-lg transition"
+          >
+            Back up keys
+          </button>
+          <button
+            onClick={() => setShowCreateForm(!showCreateForm)}
+            className="bg-blue-500 hover:bg-blue-600 text-white px-4 py-2 rounded-lg transition"
+          >
+            {showCreateForm ? 'Cancel' : '+ New Chat'}
+          </button>
+        </div>
       </div>
 
       {showCreateForm && (
@@ -164,7 +247,7 @@ export const ChatSelector: React.FC<ChatSelectorProps> = ({
                 onChange={(e) => setSelectedAlgorithm(e.target.value)}
                 className="w-full px-3 py-2 border border-gray-300 rounded-lg"
               >
-                {ALGORITHMS.map((algo) => (
+                {algorithms.map((algo) => (
                   <option key={algo} value={algo}>
                     {algo}
                   </option>
@@ -181,7 +264,7 @@ export const ChatSelector: React.FC<ChatSelectorProps> = ({
                 onChange={(e) => setSelectedMode(e.target.value)}
                 className="w-full px-3 py-2 border border-gray-300 rounded-lg"
               >
-                {MODES.map((mode) => (
+                {modes.map((mode) => (
                   <option key={mode} value={mode}>
                     {mode}
                   </option>
@@ -198,7 +281,7 @@ export const ChatSelector: React.FC<ChatSelectorProps> = ({
                 onChange={(e) => setSelectedPadding(e.target.value)}
                 className="w-full px-3 py-2 border border-gray-300 rounded-lg"
               >
-                {PADDINGS.map((pad) => (
+                {paddings.map((pad) => (
                   <option key={pad} value={pad}>
                     {pad}
                   </option>
@@ -207,6 +290,95 @@ export const ChatSelector: React.FC<ChatSelectorProps> = ({
             </div>
           </div>
 
+          <div>
+            <label className="block text-sm font-medium text-gray-700 mb-1">
+              Key agreement
+            </label>
+            <select
+              value={selectedKeyAgreement}
+              onChange={(e) => setSelectedKeyAgreement(e.target.value)}
+              className="w-full px-3 py-2 border border-gray-300 rounded-lg"
+            >
+              {keyAgreements.map((ka) => (
+                <option key={ka} value={ka}>
+                  {ka === 'X25519' ? 'X25519 (per-chat keys on this device)' : 'Diffie-Hellman'}
+                </option>
+              ))}
+            </select>
+          </div>
+
+          {selectedKeyAgreement === 'DH' && dhGroups.length > 0 && (
+            <div>
+              <label className="block text-sm font-medium text-gray-700 mb-1">
+                DH group
+              </label>
+              <select
+                value={selectedDHGroup}
+                onChange={(e) => setSelectedDHGroup(e.target.value)}
+                className="w-full px-3 py-2 border border-gray-300 rounded-lg"
+              >
+                <option value="">Global parameters (account keys)</option>
+                {dhGroups.map((group) => (
+                  <option key={group.name} value={group.name}>
+                    {group.name} ({group.bits}-bit, per-chat keys on this device)
+                  </option>
+                ))}
+              </select>
+            </div>
+          )}
+
+          {keyModes.length > 1 && (
+            <div>
+              <label className="block text-sm font-medium text-gray-700 mb-1">
+                Key storage
+              </label>
+              <select
+                value={selectedKeyMode}
+                onChange={(e) => setSelectedKeyMode(e.target.value)}
+                className="w-full px-3 py-2 border border-gray-300 rounded-lg"
+              >
+                <option value="">Server default ({capabilities?.default_key_mode})</option>
+                <option value="client_only">Client-only (keys never stored on the server)</option>
+                <option value="server">Server may keep a session key</option>
+              </select>
+            </div>
+          )}
+
+          {selectedAlgorithm === 'RC6' && (
+            <div className="grid grid-cols-2 gap-3">
+              <div>
+                <label className="block text-sm font-medium text-gray-700 mb-1">
+                  RC6 rounds (20 by default)
+                </label>
+                <input
+                  type="number"
+                  min={1}
+                  max={255}
+                  value={rc6Rounds}
+                  onChange={(e) => setRc6Rounds(Math.min(255, Math.max(1, parseInt(e.target.value) || 20)))}
+                  className="w-full px-3 py-2 border border-gray-300 rounded-lg"
+                />
+              </div>
+
+              <div>
+                <label className="block text-sm font-medium text-gray-700 mb-1">
+                  RC6 key size
+                </label>
+                <select
+                  value={rc6KeySize}
+                  onChange={(e) => setRc6KeySize(parseInt(e.target.value))}
+                  className="w-full px-3 py-2 border border-gray-300 rounded-lg"
+                >
+                  {RC6_KEY_SIZES.map((size) => (
+                    <option key={size} value={size}>
+                      {size * 8}-bit
+                    </option>
+                  ))}
+                </select>
+              </div>
+            </div>
+          )}
+
           {error && (
             <div className="bg-red-100 border border-red-400 text-red-700 px-3 py-2 rounded text-sm">
               {error}
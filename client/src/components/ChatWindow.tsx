@@ -2,7 +2,10 @@ import React, { useState, useEffect, useRef } from 'react';
 import apiService, { wsService } from '../api';
 import { db, Chat } from '../db';
 import { stringToBytes, bytesToString, bytesToHex, hexToBytes, generateIV, DiffieHellman } from '../crypto';
-import { encryptMessage as wasmEncryptMessage, decryptMessage as wasmDecryptMessage } from '../wasm/cryptoWrapper';
+import { encryptMessage as wasmEncryptMessage, decryptMessage as wasmDecryptMessage, wasmDeriveChatKeys, wasmDeriveSessionKeys, wasmX25519KeyPair, wasmX25519SharedSecret, wasmGenerateDHKeyPair, wasmComputeSharedSecret, wasmShortAuthString, requiresUniqueIV, nextCounterIV } from '../wasm/cryptoWrapper';
+import { PeerKey, publishSignedKey, verifyPeerKey, verifyContactIdentity, trustNewIdentity, IdentityChangedError } from '../utils/identity';
+import { openRatchet, hasRatchet, ratchetEncrypt, ratchetDecrypt } from '../utils/ratchet';
+import { restoreFromMyDevices, shareWithMyDevices } from '../utils/devices';
 
 interface ChatWindowProps {
   userId: number;
@@ -21,6 +24,21 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
   const [sessionIV, setSessionIV] = useState<Uint8Array | null>(null);
   const [dhInitialized, setDhInitialized] = useState(false);
   const [dhProgress, setDhProgress] = useState('');
+  // Whether the other participant's key is signed by their pinned identity key
+  const [peerKeyVerified, setPeerKeyVerified] = useState<boolean | null>(null);
+  const [identityChanged, setIdentityChanged] = useState(false);
+  // Digits to compare with the other participant, and whether their key changed since
+  const [safetyNumber, setSafetyNumber] = useState<string | null>(null);
+  const [keyChanged, setKeyChanged] = useState(false);
+  // Short code of the key exchange and whether both participants confirmed it
+  const [sasCode, setSasCode] = useState<string | null>(null);
+  const [verification, setVerification] = useState<{ verified: boolean; confirmed_by: number[] } | null>(null);
+  // The public keys of the key exchange, as this device published and received them
+  const sasKeysRef = useRef<{ mine?: { publicKey: string; epoch: number }; peer?: { publicKey: string; epoch: number } }>({});
+  // Bumped to run the key exchange again after trusting a new identity key
+  const [dhAttempt, setDhAttempt] = useState(0);
+  // Key exchange state as the server records it (dh_state_changed events)
+  const [dhState, setDhState] = useState<string | null>(null);
   const fileInputRef = useRef<HTMLInputElement>(null);
   const messagesEndRef = useRef<HTMLDivElement>(null);
 
@@ -41,6 +59,13 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
     setSessionKey(null);
     setSessionIV(null);
     setDhInitialized(false);
+    setPeerKeyVerified(null);
+    setIdentityChanged(false);
+    setSafetyNumber(null);
+    setKeyChanged(false);
+    setSasCode(null);
+    setVerification(null);
+    sasKeysRef.current = {};
     setMessageText('');
     setSelectedFile(null);
     setError('');
@@ -74,7 +99,104 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
         }
       })();
     };
-  }, [chat?.id]);
+  }, [chat?.id, dhAttempt]);
+
+  // Publishes this device's public key in the chat and remembers it for the SAS
+  const publishKey = async (publicKeyHex: string): Promise<void> => {
+    const epoch = await publishSignedKey(chat.id, publicKeyHex);
+    sasKeysRef.current.mine = { publicKey: publicKeyHex, epoch };
+  };
+
+  // X25519 chats use a key pair per chat, kept on this device and published
+  // through the DH exchange; the other participant may publish theirs later
+  const x25519SharedSecret = async (dhParams: any): Promise<string> => {
+    setDhProgress('Preparing X25519 key pair...');
+    const storageKey = `x25519_key_pair:${chat.id}`;
+    let pair = JSON.parse(localStorage.getItem(storageKey) || 'null');
+    if (!pair) {
+      // Another of this user's devices may already hold the chat's key pair
+      pair = await restoreFromMyDevices<{ privateKey: string; publicKey: string }>(chat.id).catch(() => null);
+      if (!pair) {
+        pair = await wasmX25519KeyPair();
+        shareWithMyDevices(chat.id, pair).catch((e) => console.warn('[DH] Failed to share the key pair with other devices:', e));
+      }
+      localStorage.setItem(storageKey, JSON.stringify(pair));
+    }
+    await publishKey(pair.publicKey);
+    console.log('[DH] X25519 public key published (first 16 chars):', pair.publicKey.substring(0, 16) + '...');
+
+    const otherKey = await otherPeerKey(dhParams);
+    setDhProgress('Computing shared secret...');
+    return wasmX25519SharedSecret(pair.privateKey, otherKey);
+  };
+
+  // DH chats in their own RFC 7919 group work the same way: the account key
+  // belongs to the global parameters, so the key pair is made per chat. The
+  // exponentiations run in WASM; pairs stored before the public key was kept
+  // with them recover it in JS.
+  const groupDHSharedSecret = async (dhParams: any): Promise<string> => {
+    setDhProgress(`Preparing ${dhParams.dh_group || 'DH'} key pair...`);
+    const storageKey = `dh_key_pair:${chat.id}`;
+    let stored = JSON.parse(localStorage.getItem(storageKey) || 'null');
+    if (!stored) {
+      stored = await restoreFromMyDevices<{ p: string; privateKey: string; publicKey?: string }>(chat.id).catch(() => null);
+    }
+    if (stored && stored.p === dhParams.p) {
+      if (!stored.publicKey) {
+        const dh = new DiffieHellman(dhParams.p, dhParams.g);
+        dh.importPrivateKeyHex(stored.privateKey);
+        stored.publicKey = dh.getPublicKeyHex();
+      }
+      localStorage.setItem(storageKey, JSON.stringify(stored));
+    } else {
+      const pair = await wasmGenerateDHKeyPair(dhParams.p, dhParams.g);
+      stored = { p: dhParams.p, privateKey: pair.privateKey, publicKey: pair.publicKey };
+      localStorage.setItem(storageKey, JSON.stringify(stored));
+      shareWithMyDevices(chat.id, stored).catch((e) => console.warn('[DH] Failed to share the key pair with other devices:', e));
+    }
+    const myPublicKeyHex: string = stored.publicKey;
+    await publishKey(myPublicKeyHex);
+    console.log('[DH] Per-chat public key published (first 16 chars):', myPublicKeyHex.substring(0, 16) + '...');
+
+    const otherKey = await otherPeerKey(dhParams);
+    setDhProgress('Computing shared secret...');
+    return wasmComputeSharedSecret(stored.privateKey, otherKey, dhParams.p);
+  };
+
+  // The other participant's public key from /dh/init, or once they publish
+  // it, checked against their identity key
+  const otherPeerKey = async (dhParams: any): Promise<string> => {
+    let key: PeerKey | undefined;
+    if (dhParams.other_user_public_key) {
+      key = {
+        public_key: dhParams.other_user_public_key,
+        epoch: dhParams.other_user_epoch,
+        signature: dhParams.other_user_signature,
+        identity_key: dhParams.other_user_identity_key,
+      };
+    } else {
+      key = await waitForOtherPublicKey();
+    }
+    const peerId = chat.user1Id === userId ? chat.user2Id : chat.user1Id;
+    setDhProgress('Checking the other participant\'s key signature...');
+    setPeerKeyVerified(await verifyPeerKey(chat.id, peerId, key));
+    sasKeysRef.current.peer = { publicKey: key.public_key, epoch: Number(key.epoch || 0) };
+    return key.public_key;
+  };
+
+  // Resolves with the other participant's public key once they publish it
+  const waitForOtherPublicKey = (): Promise<PeerKey> => {
+    setDhProgress('Waiting for the other participant to join...');
+    return new Promise<PeerKey>((resolve) => {
+      const unsubscribe = wsService.subscribe('dh_public_key_received', (event: any) => {
+        const data = event.data || event;
+        if (data.chat_id === chat.id && data.public_key) {
+          unsubscribe();
+          resolve(data);
+        }
+      });
+    });
+  };
 
   const initializeDHExchange = async (): Promise<() => void> => {
     try {
@@ -100,49 +222,87 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
         other_user_public_key: dhParams.other_user_public_key ? dhParams.other_user_public_key.substring(0, 20) + '...' : 'null'
       });
 
-      const dh = new DiffieHellman(dhParams.p, dhParams.g);
-      console.log('[DH] DH Parameters:');
-      console.log('[DH]   p (prime, first 40 chars):', dhParams.p.substring(0, 40) + '...');
-      console.log('[DH]   g (generator):', dhParams.g);
-
-      setDhProgress('Preparing client key pair...');
-      const storedPrivHex = localStorage.getItem('dh_private_key');
-      if (!storedPrivHex) {
-        throw new Error('No local private key found; please login to restore your encrypted private key from the server or register again');
-      }
+      const globalParams = dhParams.key_agreement === 'X25519' ? null : await apiService.getGlobalDHParams();
+
+      let sharedSecretHex: string;
+      if (dhParams.key_agreement === 'X25519') {
+        sharedSecretHex = await x25519SharedSecret(dhParams);
+      } else if (globalParams && globalParams.p !== dhParams.p) {
+        sharedSecretHex = await groupDHSharedSecret(dhParams);
+      } else {
+        const dh = new DiffieHellman(dhParams.p, dhParams.g);
+        console.log('[DH] DH Parameters:');
+        console.log('[DH]   p (prime, first 40 chars):', dhParams.p.substring(0, 40) + '...');
+        console.log('[DH]   g (generator):', dhParams.g);
+
+        setDhProgress('Preparing client key pair...');
+        const storedPrivHex = localStorage.getItem('dh_private_key');
+        if (!storedPrivHex) {
+          throw new Error('No local private key found; please login to restore your encrypted private key from the server or register again');
+        }
 
-      dh.importPrivateKeyHex(storedPrivHex);
-      const myPublicKeyHex = dh.getPublicKeyHex();
-      console.log('[DH] My private key length:', storedPrivHex.length);
-      console.log('[DH] My public key A (first 40 chars):', myPublicKeyHex.substring(0, 40) + '...');
-      console.log('[DH] My public key A (last 40 chars):', myPublicKeyHex.substring(myPublicKeyHex.length - 40));
+        dh.importPrivateKeyHex(storedPrivHex);
+        const myPublicKeyHex = dh.getPublicKeyHex();
+        console.log('[DH] My private key length:', storedPrivHex.length);
+        console.log('[DH] My public key A (first 40 chars):', myPublicKeyHex.substring(0, 40) + '...');
+        console.log('[DH] My public key A (last 40 chars):', myPublicKeyHex.substring(myPublicKeyHex.length - 40));
 
-      try {
-        const serverKeyResp = await apiService.getUserPublicKey(userId);
-        const serverPublicKeyHex = serverKeyResp?.public_key;
-        if (serverPublicKeyHex && serverPublicKeyHex !== myPublicKeyHex) {
-          console.warn('[DH] Public key mismatch!');
-          console.warn('[DH]   Server public key:', serverPublicKeyHex?.substring(0, 40) + '...');
-          console.warn('[DH]   Computed public key:', myPublicKeyHex.substring(0, 40) + '...');
-        } else {
-          console.log('[DH] ✓ Public keys match!');
+        try {
+          const serverKeyResp = await apiService.getUserPublicKey(userId);
+          const serverPublicKeyHex = serverKeyResp?.public_key;
+          if (serverPublicKeyHex && serverPublicKeyHex !== myPublicKeyHex) {
+            console.warn('[DH] Public key mismatch!');
+            console.warn('[DH]   Server public key:', serverPublicKeyHex?.substring(0, 40) + '...');
+            console.warn('[DH]   Computed public key:', myPublicKeyHex.substring(0, 40) + '...');
+          } else {
+            console.log('[DH] ✓ Public keys match!');
+          }
+        } catch (e) {
+          console.warn('[DH] Could not verify public key:', e);
         }
-      } catch (e) {
-        console.warn('[DH] Could not verify public key:', e);
-      }
 
-      console.log('[DH] Getting shared secret...');
-      setDhProgress('Computing shared secret...');
-      const sharedSecretBytes = dh.computeSharedSecret(dhParams.other_user_public_key);
-      const sharedSecretHex = bytesToHex(sharedSecretBytes);
-      console.log('[DH] Shared secret computed, first 40 chars:', sharedSecretHex.substring(0, 40) + '...');
+        // Vouch for the account key in this chat, and check the other's
+        await publishKey(myPublicKeyHex);
+        const otherPublicKeyHex = await otherPeerKey(dhParams);
 
-      const keyBytes = sharedSecretBytes;
+        console.log('[DH] Getting shared secret...');
+        setDhProgress('Computing shared secret...');
+        sharedSecretHex = await wasmComputeSharedSecret(storedPrivHex, otherPublicKeyHex, dhParams.p);
+        console.log('[DH] Shared secret computed, first 40 chars:', sharedSecretHex.substring(0, 40) + '...');
+      }
+
+      // The raw secret is never used as a key; HKDF derives the chat keys,
+      // bound to both public keys in chats made since the kdf was recorded
+      let chatKeys;
+      if (dhParams.kdf === 'HKDF-SHA256-TRANSCRIPT-V1') {
+        const { mine, peer } = sasKeysRef.current;
+        if (!mine || !peer) {
+          throw new Error('Both public keys are needed to derive the chat keys');
+        }
+        chatKeys = await wasmDeriveSessionKeys(sharedSecretHex, chat.id, chat.algorithm, mine.publicKey, peer.publicKey);
+      } else {
+        chatKeys = await wasmDeriveChatKeys(sharedSecretHex, chat.id, chat.algorithm);
+      }
+      const keyBytes = hexToBytes(chatKeys.messageKey);
       setSessionKey(keyBytes);
-      setSessionIV(new Uint8Array(8)); // Default empty IV for DH
+      setSessionIV(hexToBytes(chatKeys.ivSeed));
+
+      // Messages get keys of their own from the Double Ratchet; the chat key
+      // stays for older messages and for when the ratchet cannot start
+      if (chat.user1Id !== chat.user2Id) {
+        try {
+          await openRatchet(chat.id, userId, sharedSecretHex, chatKeys.ivSeed, chat.user1Id === userId);
+        } catch (e) {
+          console.warn('[Ratchet] Could not start the ratchet, using the chat key:', e);
+        }
+      }
 
       setDhProgress('');
       setDhInitialized(true);
+      apiService.getSafetyNumber(chat.id)
+        .then((resp) => setSafetyNumber(resp.safety_number))
+        .catch((e) => console.debug('[DH] No safety number yet:', e?.response?.status));
+      loadVerification();
       console.log('[DH] ✓ DH Exchange complete! Session key initialized.');
       console.log('[ChatWindow] Encryption parameters:', {
         algorithm: chat.algorithm,
@@ -161,6 +321,7 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
     } catch (err: any) {
       const errorMsg = err?.message || String(err);
       console.error('[DH] DH Exchange failed:', errorMsg);
+      setIdentityChanged(err instanceof IdentityChangedError);
       setError(`Encryption setup failed: ${errorMsg}`);
       setDhProgress('');
       return () => {}; // Return empty cleanup function on error
@@ -218,11 +379,17 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
       const senderId = m.sender_id || m.senderId;
       const ciphertext = m.ciphertext;
       const iv = m.iv;
+      const ratchetHeader = m.ratchet_header;
       
-      if (!messageId || !senderId || !ciphertext || !iv) {
+      if (!messageId || !senderId || !ciphertext || (!iv && !ratchetHeader)) {
         console.warn('[ChatWindow] Message missing required fields:', { messageId, senderId, ciphertext, iv });
         return;
       }
+
+      // Our own ratchet message is added by handleSendMessage, which knows its plaintext
+      if (ratchetHeader && senderId === userId && !(await db.messages.get(messageId))) {
+        return;
+      }
       
       const serverTimestamp = m.timestamp ? m.timestamp * 1000 : Date.now();
       const newMessage: any = {
@@ -231,27 +398,30 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
         senderId: senderId,
         ciphertext: ciphertext,
         iv: iv,
+        ratchetHeader,
         timestamp: new Date(serverTimestamp),
         type: 'text',
       };
 
       try {
-        if (!keyBytes || keyBytes.length === 0) {
+        if (ratchetHeader) {
+          newMessage.decrypted = await decryptRatchetMessage(messageId, senderId, ratchetHeader, ciphertext);
+        } else if (!keyBytes || keyBytes.length === 0) {
           console.error('[ChatWindow] Key bytes not available for decryption');
           newMessage.decrypted = '[No session key]';
         } else {
           newMessage.decrypted = await decryptMessageWithKey(ciphertext, iv, keyBytes);
-          
-          if (newMessage.decrypted && newMessage.decrypted.startsWith('data:')) {
-            newMessage.type = 'file';
-            if (m.file_name) {
-              newMessage.fileName = m.file_name;
-            } else {
-              const mimeMatch = newMessage.decrypted.match(/data:([^;]+)/);
-              const mimeType = mimeMatch ? mimeMatch[1] : 'application/octet-stream';
-              const ext = mimeType.split('/')[1] || 'bin';
-              newMessage.fileName = `file_${messageId}.${ext}`;
-            }
+        }
+
+        if (newMessage.decrypted && newMessage.decrypted.startsWith('data:')) {
+          newMessage.type = 'file';
+          if (m.file_name) {
+            newMessage.fileName = m.file_name;
+          } else {
+            const mimeMatch = newMessage.decrypted.match(/data:([^;]+)/);
+            const mimeType = mimeMatch ? mimeMatch[1] : 'application/octet-stream';
+            const ext = mimeType.split('/')[1] || 'bin';
+            newMessage.fileName = `file_${messageId}.${ext}`;
           }
         }
       } catch (err) {
@@ -314,6 +484,90 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
     };
   };
 
+  // The key exchange state; a key of ours gone stale (signed by an identity
+  // key this user since replaced) is published again
+  useEffect(() => {
+    apiService.getDHStatus(chat.id)
+      .then((status) => setDhState(status.state))
+      .catch((e) => console.warn('[DH] Could not load the key exchange state:', e));
+    return wsService.subscribe('dh_state_changed', (event: any) => {
+      const data = event.data || event;
+      if (data.chat_id !== chat.id) return;
+      console.log(`[DH] Key exchange of chat ${chat.id}: ${data.previous} -> ${data.state}`);
+      setDhState(data.state);
+      if (data.state === 'stale' && (data.stale_user_ids || []).includes(userId)) {
+        setDhAttempt((n) => n + 1);
+      }
+    });
+  }, [chat.id]);
+
+  // The other participant published a new identity key (another device, or
+  // a substituted key): their safety number with us changed
+  useEffect(() => {
+    return wsService.subscribe('key_changed', (event: any) => {
+      const data = event.data || event;
+      if (data.chat_id !== chat.id) return;
+      console.warn(`[ChatWindow] Identity key of user ${data.user_id} changed`);
+      setSafetyNumber(data.safety_number || null);
+      setKeyChanged(true);
+    });
+  }, [chat.id]);
+
+  // The code both participants read to each other, from the keys this device
+  // used; a MITM who swapped keys cannot make the two codes match
+  const loadVerification = async () => {
+    const { mine, peer } = sasKeysRef.current;
+    if (!mine || !peer) return;
+    try {
+      const otherId = chat.user1Id === userId ? chat.user2Id : chat.user1Id;
+      setSasCode(await wasmShortAuthString(
+        chat.id,
+        { userId, epoch: mine.epoch, publicKey: mine.publicKey },
+        { userId: otherId, epoch: peer.epoch, publicKey: peer.publicKey }
+      ));
+      setVerification(await apiService.getVerification(chat.id));
+    } catch (e) {
+      console.warn('[SAS] Could not load the verification status:', e);
+    }
+  };
+
+  const handleConfirmSAS = async () => {
+    if (!sasCode) return;
+    try {
+      setVerification(await apiService.confirmSAS(chat.id, sasCode));
+      // The matching code vouches for the keys signed by the pinned
+      // identity key, so the contact's identity is verified too
+      if (peerKeyVerified) {
+        const otherId = chat.user1Id === userId ? chat.user2Id : chat.user1Id;
+        verifyContactIdentity(otherId).catch((e) => console.warn('[SAS] Could not verify the contact:', e));
+      }
+    } catch (e: any) {
+      setError(e?.response?.status === 409
+        ? 'The keys changed since this code was shown; reopen the chat and compare again'
+        : 'Failed to confirm the code');
+    }
+  };
+
+  const handleRejectSAS = async () => {
+    try {
+      setVerification(await apiService.rejectSAS(chat.id));
+      setError('The codes differ: someone may be intercepting this chat. Do not trust it until you compare them again.');
+    } catch (e) {
+      setError('Failed to report the mismatch');
+    }
+  };
+
+  useEffect(() => {
+    return wsService.subscribe('chat_verification', (event: any) => {
+      const data = event.data || event;
+      if (data.chat_id !== chat.id) return;
+      setVerification({ verified: data.verified, confirmed_by: data.confirmed_by || [] });
+      if (data.mismatch && data.user_id !== userId) {
+        setError(`User ${data.user_id} saw a different code: someone may be intercepting this chat`);
+      }
+    });
+  }, [chat.id]);
+
   useEffect(() => {
     scrollToBottom();
   }, [messages]);
@@ -356,12 +610,15 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
             senderId: m.sender_id,
             ciphertext: m.ciphertext,
             iv: m.iv,
+            ratchetHeader: m.ratchet_header,
             timestamp: new Date(serverTimestamp),
             type: 'text',
           };
 
           try {
-            message.decrypted = await decryptMessageWithKey(m.ciphertext, m.iv, keyBytes);
+            message.decrypted = m.ratchet_header
+              ? await decryptRatchetMessage(m.id, m.sender_id, m.ratchet_header, m.ciphertext)
+              : await decryptMessageWithKey(m.ciphertext, m.iv, keyBytes);
             
             if (message.decrypted && message.decrypted.startsWith('data:')) {
               message.type = 'file';
@@ -415,6 +672,20 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
     }
   };
 
+  // A ratchet message opens only once, so later loads use the plaintext kept
+  // in IndexedDB. The sender keeps theirs there when sending; other devices
+  // of the sender cannot open it at all.
+  const decryptRatchetMessage = async (id: number, senderId: number, header: string, envelope: string): Promise<string> => {
+    const existing = await db.messages.get(id);
+    if (existing?.decrypted !== undefined) {
+      return existing.decrypted;
+    }
+    if (senderId === userId) {
+      return '[Sent from another device]';
+    }
+    return ratchetDecrypt(chat.id, header, envelope);
+  };
+
   const decryptMessageWithKey = async (ciphertext: string, iv: string, keyBytes: Uint8Array): Promise<string> => {
     console.debug('[ChatWindow.Decrypt] Using parameters:', {
       algorithm: chat.algorithm,
@@ -470,13 +741,20 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
 
     try {
       // Use cryptoWrapper with the selected mode and padding from chat
+      // Keystream modes must never repeat an IV under the chat key
+      let ivHex: string | undefined;
+      if (requiresUniqueIV(chat.mode)) {
+        ivHex = await nextCounterIV(chat.id, chat.user1Id === userId ? 0 : 1, bytesToHex(sessionIV), chat.algorithm);
+      }
+
       console.log('[ChatWindow.Encrypt] Calling wasmEncryptMessage...');
       const result = await wasmEncryptMessage(
         chat.algorithm,
         bytesToHex(sessionKey),
         plaintext,
         chat.mode,
-        chat.padding
+        chat.padding,
+        ivHex
       );
 
       console.debug('[ChatWindow.Encrypt] ✅ Successfully encrypted using mode:', {
@@ -537,7 +815,17 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
         fileName = selectedFile.name;
       }
 
-      const { ciphertext, iv } = await encryptMessage(contentToSend);
+      let ciphertext: string;
+      let iv: string;
+      let ratchetHeader: string | undefined;
+      if (hasRatchet(chat.id)) {
+        const sealed = await ratchetEncrypt(chat.id, chat.algorithm, chat.mode, chat.padding, contentToSend);
+        ciphertext = sealed.envelope;
+        iv = '';
+        ratchetHeader = sealed.header;
+      } else {
+        ({ ciphertext, iv } = await encryptMessage(contentToSend));
+      }
       setEncryptProgress(90);
 
       let mimeType: string | undefined;
@@ -553,6 +841,7 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
         senderId: userId,
         ciphertext,
         iv,
+        ratchetHeader,
         timestamp: new Date(),
         type: selectedFile ? 'file' : 'text',
         decrypted: contentToSend,
@@ -570,8 +859,20 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
       });
 
       // Send message to server
-      await apiService.sendMessage(chat.id, ciphertext, iv, fileName, mimeType);
-      console.debug('[ChatWindow] Message sent to server, waiting for WebSocket with real ID...');
+      const sent = await apiService.sendMessage(
+        chat.id, ciphertext, iv, fileName, mimeType,
+        undefined, undefined, undefined, undefined, undefined, ratchetHeader
+      );
+      if (ratchetHeader) {
+        // Only this copy of the plaintext is left; the ratchet key is gone
+        const confirmed = { ...tempMessage, id: sent.message_id, isPending: false };
+        await db.messages.put(confirmed);
+        setMessages(prev => prev.some(msg => msg.id === sent.message_id)
+          ? prev.filter(msg => msg.id !== tempId)
+          : prev.map(msg => msg.id === tempId ? confirmed : msg));
+      } else {
+        console.debug('[ChatWindow] Message sent to server, waiting for WebSocket with real ID...');
+      }
 
       // Clear form
       setMessageText('');
@@ -656,6 +957,26 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
           </p>
           {dhProgress && <p className="text-xs text-blue-600 mt-1">🔐 {dhProgress}</p>}
           {dhInitialized && <p className="text-xs text-green-600 mt-1">✓ Encryption ready</p>}
+          {dhState === 'awaiting_peer' && !dhInitialized && <p className="text-xs text-gray-500">Waiting for User {otherUserId} to publish a key</p>}
+          {dhState === 'stale' && <p className="text-xs text-red-600">⚠ A key of this chat no longer matches its owner's identity key; waiting for a new one</p>}
+          {dhInitialized && peerKeyVerified === true && <p className="text-xs text-green-600">✓ Key signed by User {otherUserId}</p>}
+          {dhInitialized && peerKeyVerified === false && <p className="text-xs text-yellow-600">⚠ Key of User {otherUserId} is not signed yet</p>}
+          {safetyNumber && <p className="text-xs text-gray-500 font-mono" title="Compare with the other participant">Safety number: {safetyNumber}</p>}
+          {keyChanged && <p className="text-xs text-red-600">⚠ User {otherUserId} has a new identity key; compare the safety number again</p>}
+          {verification?.verified && <p className="text-xs text-green-600">✓ Verified with User {otherUserId}</p>}
+          {sasCode && verification && !verification.verified && (
+            <p className="text-xs text-gray-700">
+              Code: <span className="font-mono">{sasCode.slice(0, 3)} {sasCode.slice(3)}</span>
+              {verification.confirmed_by.includes(userId) ? (
+                <span className="ml-2 text-gray-500">waiting for User {otherUserId} to confirm</span>
+              ) : (
+                <>
+                  <button type="button" onClick={handleConfirmSAS} className="ml-2 underline text-green-700">Codes match</button>
+                  <button type="button" onClick={handleRejectSAS} className="ml-2 underline text-red-700">Codes differ</button>
+                </>
+              )}
+            </p>
+          )}
         </div>
         <div className="flex items-center gap-2">
           <button
@@ -762,6 +1083,19 @@ export const ChatWindow: React.FC<ChatWindowProps> = ({ userId, chat, onClose })
         {error && (
           <div className="bg-red-100 border border-red-400 text-red-700 px-3 py-2 rounded text-sm">
             {error}
+            {identityChanged && (
+              <button
+                type="button"
+                onClick={() => {
+                  trustNewIdentity(otherUserId);
+                  setError('');
+                  setDhAttempt((n) => n + 1);
+                }}
+                className="ml-2 underline"
+              >
+                Trust the new identity key
+              </button>
+            )}
           </div>
         )}
 
@@ -1,6 +1,9 @@
 import React, { useState } from 'react';
 import apiService from '../api';
 import { encryptPrivateKeyWithPassword, decryptPrivateKeyWithPassword } from '../crypto';
+import { isWrappedKey } from '../wasm/cryptoWrapper';
+import { ensureDevice, getThisDevice } from '../utils/devices';
+import { restoreKeyBackup } from '../utils/keyBackup';
 
 interface LoginProps {
   onLoginSuccess: (userId: number, username: string, token: string) => void;
@@ -9,6 +12,8 @@ interface LoginProps {
 export const LoginPage: React.FC<LoginProps> = ({ onLoginSuccess }) => {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
+  // Optional, to restore keys from a backup when logging in on a new device
+  const [backupPassphrase, setBackupPassphrase] = useState('');
   const [isRegister, setIsRegister] = useState(false);
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
@@ -41,7 +46,7 @@ export const LoginPage: React.FC<LoginProps> = ({ onLoginSuccess }) => {
         console.log('  Encrypted hex length:', encPrivHex.length, 'chars');
         console.log('  First 40 chars:', encPrivHex.substring(0, 40));
         console.log('  Last 40 chars:', encPrivHex.substring(encPrivHex.length - 40));
-        console.log('  Format: salt(32) || iv(24) || ciphertext(rest)');
+        console.log('  Format:', isWrappedKey(encPrivHex) ? 'version || KDF params || 0x00 || AES-KWP' : 'salt(32) || iv(24) || ciphertext(rest)');
 
         // Verify encryption/decryption round-trip before sending to server
         console.log('[Register] Verifying encryption/decryption round-trip...');
@@ -128,6 +133,12 @@ export const LoginPage: React.FC<LoginProps> = ({ onLoginSuccess }) => {
             return;
           }
           
+          try {
+            await ensureDevice(username, password, loginResp);
+          } catch (e) {
+            console.error('[Register] ✗ Failed to set up this device:', e);
+          }
+
           // Success - call login callback
           onLoginSuccess(loginResp.user_id, loginResp.username || username, token);
           setError('');
@@ -144,7 +155,7 @@ export const LoginPage: React.FC<LoginProps> = ({ onLoginSuccess }) => {
         localStorage.removeItem('encrypted_private_key');
         console.log('[Login] ✓ Old DH keys cleared');
         
-        const response = await apiService.login(username, password);
+        const response = await apiService.login(username, password, getThisDevice(username)?.id);
         localStorage.setItem('token', response.token);
         localStorage.setItem('userId', response.user_id.toString());
         localStorage.setItem('username', response.username || username);
@@ -175,6 +186,27 @@ export const LoginPage: React.FC<LoginProps> = ({ onLoginSuccess }) => {
           console.warn('[Login] No encrypted_private_key returned from server');
         }
 
+        // This device's own key pair, for chat keys shared between devices
+        try {
+          await ensureDevice(username, password, response);
+        } catch (e) {
+          console.error('[Login] ✗ Failed to set up this device:', e);
+        }
+
+        if (backupPassphrase) {
+          try {
+            const restored = await restoreKeyBackup(backupPassphrase);
+            if (restored === null) {
+              setError('There is no key backup for this account.');
+              return;
+            }
+          } catch (e) {
+            console.error('[Login] ✗ Failed to restore the key backup:', e);
+            setError('Failed to restore the key backup. The backup passphrase may be incorrect.');
+            return;
+          }
+        }
+
         onLoginSuccess(response.user_id, response.username, response.token);
       }
     } catch (err: any) {
@@ -220,6 +252,21 @@ export const LoginPage: React.FC<LoginProps> = ({ onLoginSuccess }) => {
             />
           </div>
 
+          {!isRegister && (
+            <div>
+              <label className="block text-sm font-medium text-gray-700 mb-2">
+                Backup passphrase (optional)
+              </label>
+              <input
+                type="password"
+                value={backupPassphrase}
+                onChange={(e) => setBackupPassphrase(e.target.value)}
+                className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                placeholder="Restore keys from your backup on a new device"
+              />
+            </div>
+          )}
+
           {error && (
             <div className="bg-red-100 border border-red-400 text-red-700 px-4 py-3 rounded">
               {error}
@@ -221,7 +221,7 @@ export async function encryptWithAny(algorithm: string, key: Uint8Array, plainte
 
   // JS fallback: use simple XOR demo
   const pt = stringToBytes(plaintext);
-  const ivUsed = iv || generateIV(algorithm === 'RC6' ? 16 : 8);
+  const ivUsed = iv || generateIV(algorithm === 'LOKI97' ? 8 : 16);
   const ct = pt.map((b, i) => b ^ (key[i % key.length] ^ ivUsed[i % ivUsed.length]));
   return { ciphertext: ct instanceof Uint8Array ? ct : new Uint8Array(ct), iv: ivUsed };
 }
@@ -452,8 +452,16 @@ export async function deriveKeyFromSharedSecret(sharedSecretBytes: Uint8Array, k
   return key;
 }
 
-// Derive a symmetric key from password using PBKDF2-SHA256
+// Derive a symmetric key from password using PBKDF2-SHA256. WASM runs the
+// server's implementation; SubtleCrypto is the fallback.
 export async function deriveKeyFromPassword(password: string, salt?: Uint8Array, iterations: number = 100000, keyLength: number = 32): Promise<Uint8Array> {
+  const usedSalt = salt || generateIV(16);
+  try {
+    return hexToBytes(await wasmWrapper.wasmPBKDF2(password, bytesToHex(usedSalt), iterations, keyLength));
+  } catch (e) {
+    console.debug('[deriveKeyFromPassword] WASM PBKDF2 unavailable, using SubtleCrypto:', e);
+  }
+
   const enc = new TextEncoder();
   const subtleImport = (globalThis as any).crypto?.subtle as SubtleCrypto | undefined;
   if (!subtleImport) {
@@ -468,7 +476,6 @@ export async function deriveKeyFromPassword(password: string, salt?: Uint8Array,
     ['deriveBits', 'deriveKey']
   );
 
-  const usedSalt = salt || generateIV(16);
   const derived = await subtleImport.deriveBits(
     { name: 'PBKDF2', salt: new Uint8Array(usedSalt), iterations, hash: 'SHA-256' },
     pwKey,
@@ -478,8 +485,16 @@ export async function deriveKeyFromPassword(password: string, salt?: Uint8Array,
   return new Uint8Array(derived);
 }
 
-// Encrypt private key bytes with password-derived key using AES-GCM
+// Encrypt private key bytes with password-derived key using AES-GCM.
+// With WASM the key is wrapped with AES-KWP under Argon2id in the server's
+// versioned format; otherwise the legacy PBKDF2 format is used.
 export async function encryptPrivateKeyWithPassword(privateKeyHex: string, password: string): Promise<string> {
+  try {
+    return await wasmWrapper.wasmWrapKey(privateKeyHex, password, 'argon2id');
+  } catch (e) {
+    console.warn('[encryptPrivateKeyWithPassword] WASM key wrapping unavailable, using PBKDF2:', e);
+  }
+
   try {
     const privateKeyBytes = hexToBytes(privateKeyHex);
     console.debug('[encryptPrivateKeyWithPassword] Input privateKeyHex length:', privateKeyHex.length);
@@ -518,6 +533,10 @@ export async function encryptPrivateKeyWithPassword(privateKeyHex: string, passw
 }
 
 export async function decryptPrivateKeyWithPassword(encryptedHex: string, password: string): Promise<string> {
+  if (wasmWrapper.isWrappedKey(encryptedHex)) {
+    return wasmWrapper.wasmUnwrapKey(encryptedHex, password);
+  }
+
   try {
     console.debug('[decryptPrivateKeyWithPassword] Input encrypted hex length:', encryptedHex.length);
     
@@ -33,6 +33,7 @@ export interface Message {
   senderId: number;
   ciphertext: string;
   iv: string;
+  ratchetHeader?: string;
   timestamp: Date;
   decrypted?: string;
   type?: 'text' | 'file' | 'image';
@@ -0,0 +1,88 @@
+// Devices and key shares. Each device registers its own X25519 key pair;
+// the private key stays here and, wrapped under the password, with the
+// server. When this device holds the keys of a chat it seals them to the
+// user's other devices, so those can open the chat without a new key
+// exchange; a device missing a chat's keys asks for the share sealed to it.
+
+import apiService, { LoginResponse } from '../api';
+import { bytesToHex, hexToBytes, stringToBytes, bytesToString } from '../crypto';
+import { wasmX25519KeyPair, wasmWrapKey, wasmUnwrapKey, wasmSealKeyShare, wasmOpenKeyShare } from '../wasm/cryptoWrapper';
+import { getStoredUsername } from './storage';
+
+interface DeviceRecord {
+  id: number;
+  publicKey: string;
+  privateKey: string;
+}
+
+// Kept by username, so that the next login can name the device
+const deviceStorageKey = (username: string) => `device:${username.toLowerCase()}`;
+
+/**
+ * This device's registration for a user, if it has one
+ */
+export function getThisDevice(username: string | null = getStoredUsername()): DeviceRecord | null {
+  if (!username) return null;
+  return JSON.parse(localStorage.getItem(deviceStorageKey(username)) || 'null');
+}
+
+/**
+ * Sets up this device after logging in. A device the server still knows
+ * restores its private key from the login response; otherwise, on first
+ * login or after the device was revoked, a fresh key pair is registered with
+ * its private key wrapped under the password.
+ */
+export async function ensureDevice(username: string, password: string, login: LoginResponse): Promise<DeviceRecord> {
+  const existing = getThisDevice(username);
+  if (existing && login.device_id === existing.id && login.device_encrypted_private_key) {
+    const record = { ...existing, privateKey: await wasmUnwrapKey(login.device_encrypted_private_key, password) };
+    localStorage.setItem(deviceStorageKey(username), JSON.stringify(record));
+    return record;
+  }
+
+  const pair = await wasmX25519KeyPair();
+  const wrapped = await wasmWrapKey(pair.privateKey, password);
+  const device = await apiService.registerDevice(navigator.userAgent.substring(0, 64), pair.publicKey, wrapped);
+  const record = { id: device.id, publicKey: pair.publicKey, privateKey: pair.privateKey };
+  localStorage.setItem(deviceStorageKey(username), JSON.stringify(record));
+  console.log('[Devices] Registered this device as', device.id);
+  return record;
+}
+
+/**
+ * Seals a chat's key material to the user's other devices. Does nothing
+ * before this device is registered or if it is the only one.
+ */
+export async function shareWithMyDevices(chatId: number, keyMaterial: object): Promise<void> {
+  const me = getThisDevice();
+  if (!me) return;
+  const others = (await apiService.listDevices()).filter((d) => d.id !== me.id);
+  if (others.length === 0) return;
+
+  const materialHex = bytesToHex(stringToBytes(JSON.stringify(keyMaterial)));
+  const shares = await Promise.all(others.map(async (d) => ({
+    recipient_device_id: d.id,
+    share: await wasmSealKeyShare(d.public_key, materialHex, { chatId, senderDeviceId: me.id, recipientDeviceId: d.id }),
+  })));
+  await apiService.shareChatKeys(chatId, me.id, shares);
+  console.log(`[Devices] Shared the keys of chat ${chatId} with ${shares.length} other device(s)`);
+}
+
+/**
+ * The key material another of the user's devices shared for a chat, or null
+ * if none was shared with this device
+ */
+export async function restoreFromMyDevices<T>(chatId: number): Promise<T | null> {
+  const me = getThisDevice();
+  if (!me) return null;
+  const ks = await apiService.getKeyShare(chatId, me.id);
+  if (!ks || !ks.sender_device_id) return null;
+
+  const materialHex = await wasmOpenKeyShare(me.privateKey, ks.share, {
+    chatId,
+    senderDeviceId: ks.sender_device_id,
+    recipientDeviceId: me.id,
+  });
+  console.log(`[Devices] Restored the keys of chat ${chatId} shared by device ${ks.sender_device_id}`);
+  return JSON.parse(bytesToString(hexToBytes(materialHex)));
+}
@@ -0,0 +1,115 @@
+// Identity keys and signed key exchange. Every public key this device
+// publishes in a chat is signed with the user's Ed25519 identity key, over
+// the chat ID, an epoch and the key. The other participant's key is checked
+// against their identity key as first seen here, so a server cannot swap
+// keys without the client noticing.
+
+import apiService from '../api';
+import { wasmIdentityKeyPair, wasmSignContact, wasmSignKeyExchange, wasmVerifyKeyExchange } from '../wasm/cryptoWrapper';
+import { getStoredUserId } from './storage';
+
+// A public key as /dh/init and the dh_public_key_received event carry it
+export interface PeerKey {
+  public_key: string;
+  epoch?: number | string;
+  signature?: string;
+  identity_key?: string;
+}
+
+// Thrown when the other participant's identity key differs from the pinned one
+export class IdentityChangedError extends Error {
+  constructor(public peerId: number) {
+    super(`the identity key of user ${peerId} changed; confirm it with them before trusting it`);
+    this.name = 'IdentityChangedError';
+  }
+}
+
+/**
+ * This user's identity key pair, made and published on first use
+ */
+async function identityKeyPair(): Promise<{ privateKey: string; publicKey: string; published?: boolean }> {
+  const storageKey = `identity_key_pair:${getStoredUserId()}`;
+  let pair = JSON.parse(localStorage.getItem(storageKey) || 'null');
+  if (!pair) {
+    pair = await wasmIdentityKeyPair();
+    localStorage.setItem(storageKey, JSON.stringify(pair));
+  }
+  if (!pair.published) {
+    await apiService.setIdentityKey(pair.publicKey);
+    pair.published = true;
+    localStorage.setItem(storageKey, JSON.stringify(pair));
+  }
+  return pair;
+}
+
+/**
+ * Publishes a public key in a chat, signed at an epoch above any this device
+ * used there before. Resolves with the epoch.
+ */
+export async function publishSignedKey(chatId: number, publicKeyHex: string): Promise<number> {
+  const pair = await identityKeyPair();
+  const epochKey = `dh_epoch_sent:${chatId}`;
+  const epoch = Math.max(Date.now(), Number(localStorage.getItem(epochKey) || 0) + 1);
+  localStorage.setItem(epochKey, String(epoch));
+  const signature = await wasmSignKeyExchange(pair.privateKey, chatId, epoch, publicKeyHex);
+  await apiService.completeDHExchange(chatId, publicKeyHex, epoch, signature);
+  return epoch;
+}
+
+/**
+ * Checks the other participant's public key. Resolves true for a key signed
+ * by their pinned identity key and false for an unsigned one from a peer that
+ * has never signed in this chat; throws if the key was substituted, replayed
+ * from an earlier epoch or signed by a new identity key.
+ */
+export async function verifyPeerKey(chatId: number, peerId: number, key: PeerKey): Promise<boolean> {
+  const pinKey = `identity_key:${peerId}`;
+  const epochKey = `dh_epoch:${chatId}:${peerId}`;
+  const pinned = localStorage.getItem(pinKey);
+  const lastEpoch = Number(localStorage.getItem(epochKey) || 0);
+  const epoch = Number(key.epoch || 0);
+
+  if (!key.signature) {
+    if (lastEpoch > 0) {
+      throw new Error('the other participant\'s key is no longer signed; it may have been substituted');
+    }
+    console.warn('[DH] The other participant\'s key is unsigned (they have not opened this chat since identity keys were added)');
+    return false;
+  }
+  if (!key.identity_key) {
+    throw new Error('the other participant\'s key is signed but their identity key is missing');
+  }
+  if (pinned && pinned !== key.identity_key) {
+    throw new IdentityChangedError(peerId);
+  }
+  if (!(await wasmVerifyKeyExchange(key.identity_key, chatId, epoch, key.public_key, key.signature))) {
+    throw new Error('the signature over the other participant\'s key is invalid; it may have been substituted');
+  }
+  if (epoch < lastEpoch) {
+    throw new Error('the other participant\'s key is older than one seen before; it may have been replayed');
+  }
+
+  localStorage.setItem(pinKey, key.identity_key);
+  localStorage.setItem(epochKey, String(epoch));
+  return true;
+}
+
+/**
+ * Records with the server that this user checked the identity key pinned for
+ * a contact, signed with their own identity key. Does nothing if no key is
+ * pinned for them.
+ */
+export async function verifyContactIdentity(contactId: number): Promise<void> {
+  const pinned = localStorage.getItem(`identity_key:${contactId}`);
+  if (!pinned) return;
+  const pair = await identityKeyPair();
+  const signature = await wasmSignContact(pair.privateKey, getStoredUserId(), contactId, pinned);
+  await apiService.verifyContact(contactId, signature);
+}
+
+/**
+ * Forgets the pinned identity key of a user, after they confirmed the new one
+ */
+export function trustNewIdentity(peerId: number): void {
+  localStorage.removeItem(`identity_key:${peerId}`);
+}
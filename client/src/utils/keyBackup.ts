@@ -0,0 +1,86 @@
+// Key backup. The keys this device holds in localStorage are gathered into a
+// bundle, sealed under a backup passphrase with Argon2id and uploaded; on a
+// new device the backup is downloaded and opened with the same passphrase.
+// The passphrase is separate from the login password, so someone who only
+// knows the password (or the server) cannot open the backup.
+//
+// Ratchet state and IV counters are left out: restoring an older copy of
+// either would reuse message keys or IVs.
+
+import apiService from '../api';
+import { bytesToHex, hexToBytes, stringToBytes, bytesToString } from '../crypto';
+import { wasmSealKeyBackup, wasmOpenKeyBackup } from '../wasm/cryptoWrapper';
+
+const BUNDLE_VERSION = 1;
+
+// localStorage entries that make up the bundle: the account key, the
+// identity key pair, per-chat key pairs, pinned identity keys and epochs
+const BACKED_UP_PREFIXES = [
+  'dh_private_key',
+  'identity_key_pair:',
+  'x25519_key_pair:',
+  'dh_key_pair:',
+  'identity_key:',
+  'dh_epoch:',
+  'dh_epoch_sent:',
+];
+
+// Shortest backup passphrase accepted; it is the only secret protecting the bundle
+export const MIN_PASSPHRASE_LENGTH = 12;
+
+interface KeyBundle {
+  version: number;
+  created_at: number;
+  entries: Record<string, string>;
+}
+
+function collectBundle(): KeyBundle {
+  const entries: Record<string, string> = {};
+  for (let i = 0; i < localStorage.length; i++) {
+    const key = localStorage.key(i);
+    if (key && BACKED_UP_PREFIXES.some((prefix) => key === prefix || key.startsWith(prefix))) {
+      entries[key] = localStorage.getItem(key) ?? '';
+    }
+  }
+  return { version: BUNDLE_VERSION, created_at: Date.now(), entries };
+}
+
+/**
+ * Seals the keys on this device under passphrase and uploads them, replacing
+ * any earlier backup. Resolves with the number of entries backed up.
+ */
+export async function createKeyBackup(passphrase: string): Promise<number> {
+  if (passphrase.length < MIN_PASSPHRASE_LENGTH) {
+    throw new Error(`the backup passphrase needs at least ${MIN_PASSPHRASE_LENGTH} characters`);
+  }
+  const bundle = collectBundle();
+  const backup = await wasmSealKeyBackup(bytesToHex(stringToBytes(JSON.stringify(bundle))), passphrase);
+  await apiService.saveKeyBackup(backup);
+  const count = Object.keys(bundle.entries).length;
+  console.log(`[KeyBackup] Uploaded a backup of ${count} key entries`);
+  return count;
+}
+
+/**
+ * Downloads the backup and restores its keys onto this device. Keys already
+ * here are kept. Resolves with the number of entries restored, or null if the
+ * user has no backup; fails on a wrong passphrase.
+ */
+export async function restoreKeyBackup(passphrase: string): Promise<number | null> {
+  const stored = await apiService.getKeyBackup();
+  if (!stored?.backup) return null;
+
+  const bundle: KeyBundle = JSON.parse(bytesToString(hexToBytes(await wasmOpenKeyBackup(stored.backup, passphrase))));
+  if (bundle.version !== BUNDLE_VERSION) {
+    throw new Error(`unsupported key bundle version ${bundle.version}`);
+  }
+  let restored = 0;
+  for (const [key, value] of Object.entries(bundle.entries)) {
+    if (!BACKED_UP_PREFIXES.some((prefix) => key === prefix || key.startsWith(prefix))) continue;
+    if (localStorage.getItem(key) !== null) continue;
+    localStorage.setItem(key, value);
+    restored++;
+  }
+  console.log(`[KeyBackup] Restored ${restored} key entries from the backup of ${new Date(stored.updated_at * 1000).toISOString()}`);
+  return restored;
+}
@@ -0,0 +1,112 @@
+// Double Ratchet sessions. Every message of a chat is encrypted under a key
+// of its own, from a ratchet started on the chat's shared secret. The state
+// lives in localStorage and only moves forward, so a used key cannot be
+// derived again and a stolen state does not open earlier messages. The
+// sender cannot open their own ratchet messages either; the plaintext they
+// sent is kept in IndexedDB instead.
+
+import apiService from '../api';
+import { bytesToHex, hexToBytes, stringToBytes, bytesToString } from '../crypto';
+import { wasmRatchetInit, wasmRatchetEncrypt, wasmRatchetDecrypt } from '../wasm/cryptoWrapper';
+
+// The shared secret of each open chat, to start the ratchet over on ratchet_reset
+const sessions = new Map<number, { sharedSecretHex: string; initiator: boolean }>();
+
+// Ratchet calls of a chat run one at a time, so no two start from the same state
+const queues = new Map<number, Promise<unknown>>();
+
+function serialize<T>(chatId: number, fn: () => Promise<T>): Promise<T> {
+  const next = (queues.get(chatId) || Promise.resolve()).catch(() => undefined).then(fn);
+  queues.set(chatId, next);
+  return next;
+}
+
+function stateKey(chatId: number): string {
+  return `ratchet_state:${chatId}`;
+}
+
+function loadState(chatId: number): { seed: string; state: string } | null {
+  return JSON.parse(localStorage.getItem(stateKey(chatId)) || 'null');
+}
+
+function saveState(chatId: number, seed: string, state: string): void {
+  localStorage.setItem(stateKey(chatId), JSON.stringify({ seed, state }));
+}
+
+/**
+ * Prepares the ratchet of a chat after the key exchange. seed identifies the
+ * shared secret (the chat's IV seed will do); the stored state is kept while
+ * it stays the same. Otherwise the ratchet starts over, and if this user
+ * already sent ratchet messages the server and the other participant are
+ * told to start over too, since the fresh state would repeat their keys.
+ */
+export function openRatchet(chatId: number, userId: number, sharedSecretHex: string, seed: string, initiator: boolean): Promise<void> {
+  sessions.set(chatId, { sharedSecretHex, initiator });
+  return serialize(chatId, async () => {
+    const stored = loadState(chatId);
+    if (stored && stored.seed === seed) {
+      return;
+    }
+    saveState(chatId, seed, await wasmRatchetInit(sharedSecretHex, chatId, initiator));
+
+    const { chains } = await apiService.getRatchet(chatId);
+    if ((chains || []).some((c: any) => c.sender_id === userId)) {
+      console.warn(`[Ratchet] Local ratchet state of chat ${chatId} was lost; starting over`);
+      await apiService.resetRatchet(chatId);
+    }
+  });
+}
+
+/**
+ * Whether messages of a chat are sent through its ratchet
+ */
+export function hasRatchet(chatId: number): boolean {
+  return sessions.has(chatId) && loadState(chatId) !== null;
+}
+
+/**
+ * Starts the ratchet of a chat over after the other participant reset it
+ */
+export function restartRatchet(chatId: number): Promise<void> {
+  const session = sessions.get(chatId);
+  if (!session) {
+    localStorage.removeItem(stateKey(chatId));
+    return Promise.resolve();
+  }
+  return serialize(chatId, async () => {
+    const seed = loadState(chatId)?.seed || '';
+    saveState(chatId, seed, await wasmRatchetInit(session.sharedSecretHex, chatId, session.initiator));
+  });
+}
+
+/**
+ * Encrypts a message under the next ratchet key; send envelope as the
+ * ciphertext and header as ratchet_header
+ */
+export function ratchetEncrypt(chatId: number, algorithm: string, mode: string, padding: string, plaintext: string): Promise<{ header: string; envelope: string }> {
+  return serialize(chatId, async () => {
+    const stored = loadState(chatId);
+    if (!stored) {
+      throw new Error('ratchet not initialized');
+    }
+    const result = await wasmRatchetEncrypt(stored.state, chatId, algorithm, mode, padding, bytesToHex(stringToBytes(plaintext)));
+    saveState(chatId, stored.seed, result.state);
+    return { header: result.header, envelope: result.envelope };
+  });
+}
+
+/**
+ * Decrypts a ratchet message of the other participant. Each message opens
+ * once; after that only the copy in IndexedDB is left.
+ */
+export function ratchetDecrypt(chatId: number, headerHex: string, envelopeHex: string): Promise<string> {
+  return serialize(chatId, async () => {
+    const stored = loadState(chatId);
+    if (!stored) {
+      throw new Error('ratchet not initialized');
+    }
+    const result = await wasmRatchetDecrypt(stored.state, chatId, headerHex, envelopeHex);
+    saveState(chatId, stored.seed, result.state);
+    return bytesToString(hexToBytes(result.plaintext));
+  });
+}
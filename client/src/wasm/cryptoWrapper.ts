@@ -17,6 +17,20 @@ export async function initWasmCrypto(): Promise<boolean> {
 
   // Expect the Go code to attach a global `WasmCrypto` object with methods
   if (wasmAvailable && typeof (window as any).WasmCrypto === 'object') {
+    // Like the gateway, refuse a module whose primitives give wrong output
+    // rather than garble messages with it
+    if (typeof (window as any).WasmCrypto.SelfTest === 'function') {
+      const report = await wasmSelfTest();
+      if (!report.passed) {
+        for (const res of report.results.filter(r => r.error)) {
+          console.error('[WASM] ✗ Crypto self-test: %s: %s', res.name, res.error);
+        }
+        console.error('[WASM] ❌ Crypto self-test failed; not using the WASM module');
+        wasmAvailable = false;
+        return false;
+      }
+      console.log('[WASM] ✓ Crypto self-test passed (%d checks in %s ms)', report.results.length, report.durationMs.toFixed(1));
+    }
     console.log('[WASM] ✅ WASM Crypto initialization SUCCESS');
     console.log('[WASM] WasmCrypto object available with methods:', {
       hasEncrypt: typeof (window as any).WasmCrypto.Encrypt === 'function',
@@ -43,6 +57,50 @@ function hasWasm(): boolean {
   return isAvailable;
 }
 
+/**
+ * Codes WasmCrypto returns beside the message of a failed call
+ * (server/internal/pkg/encryption/wasm/errors.go)
+ */
+export type WasmErrorCode =
+  | 'INVALID_ARGUMENT'   // missing, mistyped or out of range argument
+  | 'UNKNOWN_ALGORITHM'
+  | 'UNKNOWN_MODE'
+  | 'UNKNOWN_PADDING'
+  | 'NOT_STREAMABLE'     // the mode cannot encrypt a file in chunks
+  | 'INVALID_KEY'        // wrong key size or a rejected public key
+  | 'INVALID_PARAMETERS' // DH group or KDF parameters refused
+  | 'AUTH_FAILED'        // tag, MAC, signature or key unwrap check failed
+  | 'MALFORMED'          // envelope, wrapped key or ratchet data unreadable
+  | 'IV_REUSED'          // IV already used under the key
+  | 'EXHAUSTED'          // message counter used up
+  | 'RATCHET_REJECTED'   // replayed message or too many skipped
+  | 'INTERNAL'           // bug in the module
+  | 'CRYPTO_FAILED';     // any other failure
+
+/**
+ * A failed WasmCrypto call. Check code rather than the message, which is
+ * meant for logs.
+ */
+export class WasmCryptoError extends Error {
+  readonly code: WasmErrorCode;
+
+  constructor(call: string, code: WasmErrorCode, message: string) {
+    super(call + ' failed: ' + message);
+    this.name = 'WasmCryptoError';
+    this.code = code;
+  }
+}
+
+/**
+ * The error for a WasmCrypto result that is {error, code} or not an object
+ */
+function wasmFailure(call: string, result: any): WasmCryptoError {
+  if (!result || typeof result !== 'object') {
+    return new WasmCryptoError(call, 'INTERNAL', 'unexpected result ' + typeof result);
+  }
+  return new WasmCryptoError(call, result.code || 'CRYPTO_FAILED', String(result.error));
+}
+
 /**
  * List of supported encryption modes
  */
@@ -53,7 +111,10 @@ export const SUPPORTED_MODES = [
   'CFB',           // Cipher Feedback
   'OFB',           // Output Feedback
   'CTR',           // Counter Mode
-  'RANDOM_DELTA'   // Custom stream mode
+  'RANDOM_DELTA',  // Custom stream mode
+  'GCM',           // Galois/Counter Mode, authenticated (128-bit block ciphers only)
+  'CBC+HMAC',      // CBC with an HMAC-SHA256 tag (encrypt-then-MAC)
+  'CTR+HMAC'       // CTR with an HMAC-SHA256 tag (encrypt-then-MAC)
 ] as const;
 
 export type EncryptionMode = typeof SUPPORTED_MODES[number];
@@ -113,28 +174,149 @@ export function modeRequiresIV(mode: EncryptionMode): boolean {
   return mode !== 'ECB';
 }
 
+/**
+ * Look up an algorithm in the cipher registry of the WASM module, so ciphers
+ * added on the Go side work without changes here
+ */
+function wasmCipherSpec(algorithm: string): { name: string; blockSize: number; keySize: number } | undefined {
+  const wc = (window as any).WasmCrypto;
+  if (!wc || typeof wc.Ciphers !== 'function') {
+    return undefined;
+  }
+  return wc.Ciphers().find((spec: { name: string }) => spec.name === algorithm.toUpperCase());
+}
+
+// Outcome of the known-answer tests of WasmCrypto.SelfTest, the same
+// checks the gateway runs at startup and reports at GET /readyz
+export interface WasmSelfTestReport {
+  passed: boolean;
+  results: { name: string; error?: string }[];
+  durationMs: number;
+}
+
+/**
+ * Run the known-answer vectors of every cipher, mode and padding in the
+ * WASM module
+ */
+export async function wasmSelfTest(): Promise<WasmSelfTestReport> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.SelfTest();
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('SelfTest', result);
+  }
+  return result;
+}
+
+// Crypto parameters a build supports, in the shape of GET
+// /api/crypto/capabilities without the server's configuration
+export interface WasmCapabilities {
+  algorithms: { name: string; block_size: number; key_size: number; key_sizes: number[] }[];
+  cipher_families: string[];
+  modes: { name: string; requires_iv: boolean; authenticated: boolean; block_size?: number }[];
+  paddings: string[];
+  dh_groups: { name: string; bits: number; generator: number }[];
+  key_agreements: string[];
+}
+
+/**
+ * List the algorithms, modes, paddings, DH groups and key agreements of the
+ * WASM module, read from the same registries as the server's
+ */
+export async function wasmCapabilities(): Promise<WasmCapabilities> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.Capabilities();
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('Capabilities', result);
+  }
+  return result;
+}
+
+/**
+ * Describe what only the server or only the WASM module supports, e.g. when
+ * one of them was deployed without the other
+ */
+export function capabilityMismatches(server: WasmCapabilities, local: WasmCapabilities): string[] {
+  const mismatches: string[] = [];
+  const compare = (kind: string, a: string[], b: string[]) => {
+    a.filter((name) => !b.includes(name)).forEach((name) => mismatches.push(`${kind} ${name} only on the server`));
+    b.filter((name) => !a.includes(name)).forEach((name) => mismatches.push(`${kind} ${name} only in WASM`));
+  };
+  const names = (list: { name: string }[]) => list.map((item) => item.name);
+  compare('algorithm', names(server.algorithms), names(local.algorithms));
+  compare('mode', names(server.modes), names(local.modes));
+  compare('padding', server.paddings, local.paddings);
+  compare('DH group', names(server.dh_groups), names(local.dh_groups));
+  compare('key agreement', server.key_agreements, local.key_agreements);
+  return mismatches;
+}
+
+/**
+ * Keep only the parameters of the server's capabilities that the WASM module
+ * supports too
+ */
+export function restrictCapabilities<T extends WasmCapabilities>(server: T, local: WasmCapabilities): T {
+  const has = (list: { name: string }[], name: string) => list.some((item) => item.name === name);
+  return {
+    ...server,
+    algorithms: server.algorithms.filter((a) => has(local.algorithms, a.name)),
+    modes: server.modes.filter((m) => has(local.modes, m.name)),
+    paddings: server.paddings.filter((p) => local.paddings.includes(p)),
+    dh_groups: server.dh_groups.filter((g) => has(local.dh_groups, g.name)),
+    key_agreements: server.key_agreements.filter((k) => local.key_agreements.includes(k)),
+  };
+}
+
 /**
  * Get block size for algorithm
- * RC6 = 16 bytes, LOKI97 = 8 bytes
+ * RC6 = 16 bytes, LOKI97 (all key sizes) = 8 bytes, AES = 16 bytes, MARS = 16 bytes,
+ * others as registered in the WASM module
  */
 export function getBlockSize(algorithm: string): number {
-  if (algorithm.toUpperCase() === 'RC6') {
+  if (algorithm.toUpperCase() === 'RC6' || algorithm.toUpperCase().startsWith('RC6-')) {
     return 16; // 128-bit blocks
-  } else if (algorithm.toUpperCase() === 'LOKI97') {
+  } else if (algorithm.toUpperCase() === 'AES' || algorithm.toUpperCase() === 'MARS') {
+    return 16; // 128-bit blocks
+  } else if (algorithm.toUpperCase().startsWith('LOKI97')) {
     return 8; // 64-bit blocks
   }
+  const spec = wasmCipherSpec(algorithm);
+  if (spec) {
+    return spec.blockSize;
+  }
   throw new Error(`Unknown algorithm: ${algorithm}`);
 }
 
 /**
  * Get required key size for algorithm
- * LOKI97 = 16 bytes, RC6 = 16 bytes
+ * LOKI97 = 16 bytes, LOKI97-192 = 24 bytes, LOKI97-256 = 32 bytes,
+ * RC6 = 16 bytes, RC6-32/r/b = b bytes, AES = 32 bytes, MARS = 32 bytes,
+ * others as registered in the WASM module
  */
 export function getKeySize(algorithm: string): number {
+  const rc6 = /^RC6-32\/\d+\/(\d+)$/.exec(algorithm.toUpperCase());
+  if (rc6) {
+    return parseInt(rc6[1]); // RC6-w/r/b names carry the key size
+  }
   if (algorithm.toUpperCase() === 'RC6') {
     return 16; // 128-bit key
+  } else if (algorithm.toUpperCase() === 'AES') {
+    return 32; // AES-256
+  } else if (algorithm.toUpperCase() === 'MARS') {
+    return 32; // 256-bit key
   } else if (algorithm.toUpperCase() === 'LOKI97') {
     return 16; // 128-bit key
+  } else if (algorithm.toUpperCase() === 'LOKI97-192') {
+    return 24; // 192-bit key
+  } else if (algorithm.toUpperCase() === 'LOKI97-256') {
+    return 32; // 256-bit key
+  }
+  const spec = wasmCipherSpec(algorithm);
+  if (spec) {
+    return spec.keySize;
   }
   throw new Error(`Unknown algorithm: ${algorithm}`);
 }
@@ -180,6 +362,36 @@ export function generateRandomHex(bytes: number): string {
   return bytesToHex(arr);
 }
 
+/**
+ * Generate a random key of the given size in bits from crypto/rand in WASM.
+ * With an algorithm the size must be one the cipher accepts. Returns hex.
+ */
+export async function wasmGenerateKey(bits: number, algorithm?: string): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.GenerateKey(bits, algorithm);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('GenerateKey', result);
+  }
+  return result.key;
+}
+
+/**
+ * Generate a random IV of blockSize bytes from crypto/rand in WASM. With an
+ * algorithm the size must be its block size. Returns hex.
+ */
+export async function wasmGenerateIV(blockSize: number, algorithm?: string): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.GenerateIV(blockSize, algorithm);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('GenerateIV', result);
+  }
+  return result.iv;
+}
+
 // Example high-level wrapper functions. These call into global WasmCrypto if present.
 export async function wasmEncrypt(algorithm: string, keyHex: string, plaintextHex: string, ivHex?: string): Promise<{ciphertext: string, iv: string}> {
   const wc = (window as any).WasmCrypto;
@@ -205,18 +417,31 @@ export async function wasmEncryptWithMode(
   keyHex: string,
   plaintextHex: string,
   ivHex: string,
+  mode: string = 'CBC',
+  padding: string = 'PKCS7'
 ): Promise<{ ciphertext: string; iv: string }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
 
   const wc = (window as any).WasmCrypto;
-  
-  
 
-  // Fallback to basic Encrypt
+  // Try to use EncryptWithMode if available (new API)
+  if (typeof wc.EncryptWithMode === 'function') {
+    const result = await wc.EncryptWithMode(algorithm, keyHex, plaintextHex, ivHex || '', mode, padding);
+
+    if (!result || typeof result !== 'object' || result.error) {
+      throw wasmFailure('EncryptWithMode', result);
+    }
+    return { ciphertext: result.ciphertext, iv: result.iv };
+  }
+
+  // Fallback to basic Encrypt (ECB + PKCS7 only)
   if (typeof wc.Encrypt === 'function') {
     const result = wc.Encrypt(algorithm, keyHex, plaintextHex, ivHex || '');
     console.log('[wasmEncrypt] Got result:', result);
-    if (!result || typeof result !== 'object') {
-      throw new Error('Encrypt returned invalid result: ' + typeof result);
+    if (!result || typeof result !== 'object' || result.error) {
+      throw wasmFailure('Encrypt', result);
     }
     return result;
   }
@@ -244,12 +469,12 @@ export async function wasmDecryptWithMode(
 
   // Try to use DecryptWithMode if available (new API)
   if (typeof wc.DecryptWithMode === 'function') {
-    const result = wc.DecryptWithMode(algorithm, keyHex, ciphertextHex, ivHex, mode, padding);
+    const result = await wc.DecryptWithMode(algorithm, keyHex, ciphertextHex, ivHex, mode, padding);
     
-    if (!result || typeof result !== 'object') {
-      throw new Error('DecryptWithMode returned invalid result: ' + typeof result);
+    if (!result || typeof result !== 'object' || result.error) {
+      throw wasmFailure('DecryptWithMode', result);
     }
-    if (!result.plaintext) {
+    if (typeof result.plaintext !== 'string') {
       throw new Error('DecryptWithMode result has no plaintext property');
     }
     return result.plaintext;
@@ -259,8 +484,8 @@ export async function wasmDecryptWithMode(
   if (typeof wc.Decrypt === 'function') {
     const result = wc.Decrypt(algorithm, keyHex, ciphertextHex, ivHex);
     console.log('[wasmDecrypt] Got result:', result);
-    if (!result || typeof result !== 'object') {
-      throw new Error('Decrypt returned invalid result: ' + typeof result);
+    if (!result || typeof result !== 'object' || result.error) {
+      throw wasmFailure('Decrypt', result);
     }
     if (!result.plaintext) {
       throw new Error('Decrypt result has no plaintext property');
@@ -271,6 +496,744 @@ export async function wasmDecryptWithMode(
   throw new Error('WasmCrypto.Decrypt not found');
 }
 
+/**
+ * XTS sector size used for attachments (must match modes.XTSSectorSize)
+ */
+export const XTS_SECTOR_SIZE = 4096;
+
+/**
+ * Encrypt attachment data in XTS mode. The data starts at sector firstSector
+ * of its file; keyHex is the data key followed by the tweak key. Any run of
+ * whole sectors can later be decrypted on its own, e.g. from a ranged download.
+ */
+export async function wasmEncryptSectors(
+  algorithm: string,
+  keyHex: string,
+  dataHex: string,
+  firstSector: number = 0
+): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.EncryptSectors(algorithm, keyHex, dataHex, firstSector);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('EncryptSectors', result);
+  }
+  return result.ciphertext;
+}
+
+/**
+ * Decrypt data encrypted with wasmEncryptSectors, starting at sector firstSector
+ */
+export async function wasmDecryptSectors(
+  algorithm: string,
+  keyHex: string,
+  dataHex: string,
+  firstSector: number = 0
+): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.DecryptSectors(algorithm, keyHex, dataHex, firstSector);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('DecryptSectors', result);
+  }
+  return result.plaintext;
+}
+
+/**
+ * Encrypt with specified mode and padding, passing Uint8Arrays to WASM
+ * instead of hex strings, which double the size and copying of large data.
+ * An empty iv draws a random one.
+ */
+export async function wasmEncryptWithModeBytes(
+  algorithm: string,
+  key: Uint8Array,
+  plaintext: Uint8Array,
+  iv: Uint8Array = new Uint8Array(0),
+  mode: string = 'CBC',
+  padding: string = 'PKCS7',
+  aad?: Uint8Array
+): Promise<{ ciphertext: Uint8Array; iv: Uint8Array }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = await (window as any).WasmCrypto.EncryptWithModeBytes(algorithm, key, plaintext, iv, mode, padding, aad);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('EncryptWithModeBytes', result);
+  }
+  return { ciphertext: result.ciphertext, iv: result.iv };
+}
+
+/**
+ * Decrypt with specified mode and padding, passing Uint8Arrays to WASM
+ */
+export async function wasmDecryptWithModeBytes(
+  algorithm: string,
+  key: Uint8Array,
+  ciphertext: Uint8Array,
+  iv: Uint8Array,
+  mode: string = 'CBC',
+  padding: string = 'PKCS7',
+  aad?: Uint8Array
+): Promise<Uint8Array> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = await (window as any).WasmCrypto.DecryptWithModeBytes(algorithm, key, ciphertext, iv, mode, padding, aad);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('DecryptWithModeBytes', result);
+  }
+  return result.plaintext;
+}
+
+/**
+ * wasmEncryptSectors on Uint8Arrays instead of hex strings
+ */
+export async function wasmEncryptSectorsBytes(
+  algorithm: string,
+  key: Uint8Array,
+  data: Uint8Array,
+  firstSector: number = 0
+): Promise<Uint8Array> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.EncryptSectorsBytes(algorithm, key, data, firstSector);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('EncryptSectorsBytes', result);
+  }
+  return result.ciphertext;
+}
+
+/**
+ * wasmDecryptSectors on Uint8Arrays instead of hex strings
+ */
+export async function wasmDecryptSectorsBytes(
+  algorithm: string,
+  key: Uint8Array,
+  data: Uint8Array,
+  firstSector: number = 0
+): Promise<Uint8Array> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.DecryptSectorsBytes(algorithm, key, data, firstSector);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('DecryptSectorsBytes', result);
+  }
+  return result.plaintext;
+}
+
+export interface FileStreamOptions {
+  // The IV to use; encryption draws a random one if it is left out
+  ivHex?: string;
+  // Called after every chunk with the bytes read so far and the total
+  onProgress?: (processedBytes: number, totalBytes: number) => void;
+}
+
+/**
+ * Encrypt a file chunk by chunk in WASM, giving the same ciphertext as
+ * EncryptWithMode over the whole file without holding it as hex. The tab
+ * stays responsive: control returns to the event loop between chunks.
+ * Authenticated modes and RANDOM_DELTA cannot be streamed.
+ */
+export async function wasmEncryptFileStream(
+  source: Blob | ReadableStream<Uint8Array>,
+  algorithm: string,
+  keyHex: string,
+  mode: string,
+  padding: string,
+  options: FileStreamOptions = {}
+): Promise<{ data: Blob; iv: string }> {
+  return runFileStream('EncryptFileStream', source, algorithm, keyHex, mode, padding, options);
+}
+
+/**
+ * Decrypt a file encrypted with wasmEncryptFileStream or EncryptWithMode,
+ * chunk by chunk
+ */
+export async function wasmDecryptFileStream(
+  source: Blob | ReadableStream<Uint8Array>,
+  algorithm: string,
+  keyHex: string,
+  ivHex: string,
+  mode: string,
+  padding: string,
+  onProgress?: (processedBytes: number, totalBytes: number) => void
+): Promise<Blob> {
+  const result = await runFileStream('DecryptFileStream', source, algorithm, keyHex, mode, padding, { ivHex, onProgress });
+  return result.data;
+}
+
+async function runFileStream(
+  name: string,
+  source: Blob | ReadableStream<Uint8Array>,
+  algorithm: string,
+  keyHex: string,
+  mode: string,
+  padding: string,
+  options: FileStreamOptions
+): Promise<{ data: Blob; iv: string }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const total = source instanceof Blob ? source.size : 0;
+  const stream = (window as any).WasmCrypto[name](algorithm, keyHex, options.ivHex || '', mode, padding, options.onProgress, total);
+  if (!stream || typeof stream !== 'object' || stream.error) {
+    throw wasmFailure(name, stream);
+  }
+
+  const parts: Uint8Array[] = [];
+  const reader = (source instanceof Blob ? source.stream() : source).getReader();
+  try {
+    for (;;) {
+      const { done, value } = await reader.read();
+      if (done) break;
+      const result = stream.update(value);
+      if (result.error) {
+        throw wasmFailure(name, result);
+      }
+      if (result.data.length > 0) parts.push(result.data);
+      // Let the page repaint and handle input before the next chunk
+      await new Promise((resolve) => setTimeout(resolve, 0));
+    }
+  } catch (e) {
+    stream.close();
+    throw e;
+  } finally {
+    reader.releaseLock();
+  }
+
+  const result = stream.final();
+  if (result.error) {
+    throw wasmFailure(name, result);
+  }
+  parts.push(result.data);
+  return { data: new Blob(parts), iv: stream.iv };
+}
+
+/**
+ * Derive the keys of a chat from the DH shared secret with HKDF-SHA256,
+ * salted with the chat ID. messageKey has the key size of the chat's
+ * algorithm; ivSeed and macKey are 16 and 32 bytes.
+ */
+export async function wasmDeriveChatKeys(
+  sharedSecretHex: string,
+  chatId: number,
+  algorithm: string
+): Promise<{ messageKey: string; ivSeed: string; macKey: string }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.DeriveChatKeys(sharedSecretHex, chatId, getKeySize(algorithm));
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('DeriveChatKeys', result);
+  }
+  return { messageKey: result.messageKey, ivSeed: result.ivSeed, macKey: result.macKey };
+}
+
+/**
+ * Derive the keys of a chat from its shared secret with HKDF-SHA256, bound to
+ * the key exchange: the chat ID, the chat's algorithm and both public keys,
+ * in either order. Chats whose key exchange reports the
+ * HKDF-SHA256-TRANSCRIPT-V1 kdf use this instead of wasmDeriveChatKeys.
+ */
+export async function wasmDeriveSessionKeys(
+  sharedSecretHex: string,
+  chatId: number,
+  algorithm: string,
+  myPublicKeyHex: string,
+  otherPublicKeyHex: string
+): Promise<{ messageKey: string; ivSeed: string; macKey: string }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.DeriveSessionKeys(sharedSecretHex, chatId, algorithm, myPublicKeyHex, otherPublicKeyHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('DeriveSessionKeys', result);
+  }
+  return { messageKey: result.messageKey, ivSeed: result.ivSeed, macKey: result.macKey };
+}
+
+/**
+ * A fresh X25519 key pair, for chats with the X25519 key agreement
+ */
+export async function wasmX25519KeyPair(): Promise<{ privateKey: string; publicKey: string }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.X25519KeyPair();
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('X25519KeyPair', result);
+  }
+  return { privateKey: result.privateKey, publicKey: result.publicKey };
+}
+
+/**
+ * The X25519 shared secret with the other participant, to be passed to
+ * wasmDeriveChatKeys like a DH one
+ */
+export async function wasmX25519SharedSecret(privateKeyHex: string, otherPublicKeyHex: string): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.X25519SharedSecret(privateKeyHex, otherPublicKeyHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('X25519SharedSecret', result);
+  }
+  return result.sharedSecret;
+}
+
+/**
+ * A fresh DH key pair over prime p and generator g (hex); both keys come
+ * padded to the length of p
+ */
+export async function wasmGenerateDHKeyPair(pHex: string, gHex: string): Promise<{ privateKey: string; publicKey: string }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.GenerateDHKeyPair(pHex, gHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('GenerateDHKeyPair', result);
+  }
+  return { privateKey: result.privateKey, publicKey: result.publicKey };
+}
+
+/**
+ * The DH shared secret with the other participant in the group of prime p,
+ * padded to the length of p, to be passed to wasmDeriveChatKeys
+ */
+export async function wasmComputeSharedSecret(privateKeyHex: string, peerPublicKeyHex: string, pHex: string): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.ComputeSharedSecret(privateKeyHex, peerPublicKeyHex, pHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('ComputeSharedSecret', result);
+  }
+  return result.sharedSecret;
+}
+
+// The chat and the devices a key share is bound to
+export interface KeyShareContext {
+  chatId: number;
+  senderDeviceId: number;
+  recipientDeviceId: number;
+}
+
+/**
+ * Seal chat key material (hex) to another device's X25519 public key
+ */
+export async function wasmSealKeyShare(recipientPublicKeyHex: string, keyMaterialHex: string, ctx: KeyShareContext): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.SealKeyShare(recipientPublicKeyHex, keyMaterialHex, ctx.chatId, ctx.senderDeviceId, ctx.recipientDeviceId);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('SealKeyShare', result);
+  }
+  return result.share;
+}
+
+/**
+ * Open a key share sealed to this device. Fails for a share sealed to
+ * another device or chat, or tampered with.
+ */
+export async function wasmOpenKeyShare(privateKeyHex: string, shareHex: string, ctx: KeyShareContext): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.OpenKeyShare(privateKeyHex, shareHex, ctx.chatId, ctx.senderDeviceId, ctx.recipientDeviceId);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('OpenKeyShare', result);
+  }
+  return result.keyMaterial;
+}
+
+/**
+ * A fresh Ed25519 identity key pair; the private key is the 32-byte seed
+ */
+export async function wasmIdentityKeyPair(): Promise<{ privateKey: string; publicKey: string }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.IdentityKeyPair();
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('IdentityKeyPair', result);
+  }
+  return { privateKey: result.privateKey, publicKey: result.publicKey };
+}
+
+/**
+ * Signs a public key published in a chat with the identity private key, over
+ * the chat ID, the epoch and the key
+ */
+export async function wasmSignKeyExchange(identityPrivateKeyHex: string, chatId: number, epoch: number, publicKeyHex: string): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.SignKeyExchange(identityPrivateKeyHex, chatId, epoch, publicKeyHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('SignKeyExchange', result);
+  }
+  return result.signature;
+}
+
+/**
+ * Checks the other participant's signature over the public key they published
+ */
+export async function wasmVerifyKeyExchange(identityKeyHex: string, chatId: number, epoch: number, publicKeyHex: string, signatureHex: string): Promise<boolean> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.VerifyKeyExchange(identityKeyHex, chatId, epoch, publicKeyHex, signatureHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('VerifyKeyExchange', result);
+  }
+  return result.valid === true;
+}
+
+/**
+ * Signs the identity key this user checked for a contact
+ */
+export async function wasmSignContact(identityPrivateKeyHex: string, userId: number, contactId: number, contactIdentityKeyHex: string): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.SignContact(identityPrivateKeyHex, userId, contactId, contactIdentityKeyHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('SignContact', result);
+  }
+  return result.signature;
+}
+
+/**
+ * The short code both participants of a chat compare after the key exchange,
+ * from the public key each published and its epoch (0 if unsigned)
+ */
+export async function wasmShortAuthString(
+  chatId: number,
+  a: { userId: number; epoch: number; publicKey: string },
+  b: { userId: number; epoch: number; publicKey: string }
+): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.ShortAuthString(chatId, a.userId, a.epoch, a.publicKey, b.userId, b.epoch, b.publicKey);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('ShortAuthString', result);
+  }
+  return result.code;
+}
+
+/**
+ * The Double Ratchet state of one side of a chat, started from the chat's
+ * shared secret. The state is JSON holding private keys; keep it local.
+ */
+export async function wasmRatchetInit(sharedSecretHex: string, chatId: number, initiator: boolean): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.RatchetInit(sharedSecretHex, chatId, initiator);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('RatchetInit', result);
+  }
+  return result.state;
+}
+
+/**
+ * Encrypts a message under the next ratchet key into an envelope whose MAC
+ * covers the ratchet header. Returns the advanced state with the header.
+ */
+export async function wasmRatchetEncrypt(
+  state: string,
+  chatId: number,
+  algorithm: string,
+  mode: string,
+  padding: string,
+  plaintextHex: string
+): Promise<{ state: string; header: string; envelope: string }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.RatchetEncrypt(state, chatId, algorithm, mode, padding, plaintextHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('RatchetEncrypt', result);
+  }
+  return { state: result.state, header: result.header, envelope: result.envelope };
+}
+
+/**
+ * Decrypts a message from the other participant. The state only advances
+ * when the message opens, so keep the old one on error.
+ */
+export async function wasmRatchetDecrypt(
+  state: string,
+  chatId: number,
+  headerHex: string,
+  envelopeHex: string
+): Promise<{ state: string; plaintext: string }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.RatchetDecrypt(state, chatId, headerHex, envelopeHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('RatchetDecrypt', result);
+  }
+  return { state: result.state, plaintext: result.plaintext };
+}
+
+/**
+ * Modes in which reusing an IV under the same key leaks plaintext. Messages
+ * in these modes get counter IVs from nextCounterIV.
+ */
+export function requiresUniqueIV(mode: string): boolean {
+  return ['CFB', 'OFB', 'CTR', 'RANDOM_DELTA', 'GCM'].includes(mode.replace(/\+HMAC$/, ''));
+}
+
+/**
+ * A random ID of this browser, so two devices of one user do not walk the
+ * same counters
+ */
+function deviceId(): number {
+  let id = localStorage.getItem('iv_device_id');
+  if (!id) {
+    id = String(crypto.getRandomValues(new Uint32Array(1))[0]);
+    localStorage.setItem('iv_device_id', id);
+  }
+  return Number(id);
+}
+
+/**
+ * Next IV for a message of this user in a chat: the IV seed of the chat
+ * XORed with the sender and a per-chat counter (see package nonce). slot is
+ * 0 for user1 of the chat and 1 for user2. The next counter is stored before
+ * the IV is returned, so a reload never reuses one.
+ */
+export async function nextCounterIV(
+  chatId: number,
+  slot: number,
+  ivSeedHex: string,
+  algorithm: string
+): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const size = getBlockSize(algorithm);
+  // The sender field is 4 bytes in 16-byte IVs and 1 byte in 8-byte ones
+  const sender = size >= 16
+    ? ((slot << 31) | (deviceId() & 0x7fffffff)) >>> 0
+    : (slot << 7) | (deviceId() & 0x7f);
+
+  const storageKey = `iv_counter:${chatId}:${sender}`;
+  const counter = Number(localStorage.getItem(storageKey) || '0');
+  localStorage.setItem(storageKey, String(counter + 1));
+
+  const result = (window as any).WasmCrypto.CounterIV(ivSeedHex, sender, counter, size);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('CounterIV', result);
+  }
+  return result.iv;
+}
+
+/**
+ * Password-based KDFs: 'argon2id' for new keys, 'pbkdf2-sha256' for
+ * compatibility. Parameters travel as encoded strings such as
+ * "$argon2id$v=19$m=19456,t=2,p=1,l=32$<salt>".
+ */
+export type KDFAlgorithm = 'argon2id' | 'pbkdf2-sha256';
+
+/**
+ * Default KDF parameters with a fresh random salt, encoded
+ */
+export async function wasmNewKDFParams(algorithm: KDFAlgorithm): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.NewKDFParams(algorithm);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('NewKDFParams', result);
+  }
+  return result.params;
+}
+
+/**
+ * Derive a key from a password with encoded KDF parameters. Returns hex.
+ */
+export async function wasmDeriveKey(password: string, params: string): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.DeriveKey(password, params);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('DeriveKey', result);
+  }
+  return result.key;
+}
+
+/**
+ * PBKDF2-SHA256 with explicit parameters, the server's code path. Returns hex.
+ */
+export async function wasmPBKDF2(password: string, saltHex: string, iterations: number, keyLength: number): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.PBKDF2(password, saltHex, iterations, keyLength);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('PBKDF2', result);
+  }
+  return result.key;
+}
+
+/**
+ * Argon2id with explicit parameters (memory in KiB). Returns hex.
+ */
+export async function wasmArgon2id(
+  password: string,
+  saltHex: string,
+  time: number,
+  memoryKiB: number,
+  threads: number,
+  keyLength: number
+): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.Argon2id(password, saltHex, time, memoryKiB, threads, keyLength);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('Argon2id', result);
+  }
+  return result.key;
+}
+
+/**
+ * HKDF-SHA256 (RFC 5869); an empty salt means HashLen zero bytes. Returns hex.
+ */
+export async function wasmHKDF(ikmHex: string, saltHex: string, infoHex: string, length: number): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.HKDF(ikmHex, saltHex, infoHex, length);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('HKDF', result);
+  }
+  return result.key;
+}
+
+/**
+ * Wrap a key with AES-KWP (RFC 5649) under a password-derived key. The
+ * result (hex) is versioned, carries its KDF parameters and opens with
+ * wasmUnwrapKey or the server's crypto package.
+ */
+export async function wasmWrapKey(keyHex: string, password: string, algorithm: KDFAlgorithm = 'argon2id'): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.WrapKey(keyHex, password, algorithm);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('WrapKey', result);
+  }
+  return result.wrapped;
+}
+
+/**
+ * Unwrap a key wrapped with wasmWrapKey. Returns hex; fails on a wrong
+ * password or tampered data.
+ */
+export async function wasmUnwrapKey(wrappedHex: string, password: string): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.UnwrapKey(wrappedHex, password);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('UnwrapKey', result);
+  }
+  return result.key;
+}
+
+/**
+ * Seal a key bundle (hex) under a backup passphrase with Argon2id, in the
+ * format of the server's crypto.SealKeyBackup. Returns hex.
+ */
+export async function wasmSealKeyBackup(bundleHex: string, passphrase: string): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.SealKeyBackup(bundleHex, passphrase);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('SealKeyBackup', result);
+  }
+  return result.backup;
+}
+
+/**
+ * Open a backup sealed with wasmSealKeyBackup. Returns the bundle as hex;
+ * fails on a wrong passphrase.
+ */
+export async function wasmOpenKeyBackup(backupHex: string, passphrase: string): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.OpenKeyBackup(backupHex, passphrase);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('OpenKeyBackup', result);
+  }
+  return result.bundle;
+}
+
+/**
+ * Whether hex data is a key wrapped with wasmWrapKey rather than the legacy
+ * salt || iv || ciphertext format: version 1, then the KDF parameters
+ */
+export function isWrappedKey(hex: string): boolean {
+  return ['\x01$argon2id$', '\x01$pbkdf2-sha256$'].some(prefix => hex.startsWith(bytesToHex(stringToBytes(prefix))));
+}
+
+/**
+ * Encrypt a message into a self-describing envelope (hex) that records the
+ * algorithm, mode, padding and IV next to the ciphertext. With macKeyHex the
+ * envelope also gets an HMAC-SHA256 covering aadHex.
+ */
+export async function wasmSealEnvelope(
+  algorithm: string,
+  mode: EncryptionMode,
+  padding: PaddingScheme,
+  keyHex: string,
+  plaintextHex: string,
+  macKeyHex: string = '',
+  aadHex?: string
+): Promise<string> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.SealEnvelope(algorithm, mode, padding, keyHex, macKeyHex, plaintextHex, aadHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('SealEnvelope', result);
+  }
+  return result.envelope;
+}
+
+/**
+ * Decrypt an envelope from wasmSealEnvelope. macKeyHex must be given exactly
+ * when the envelope was sealed with one.
+ */
+export async function wasmOpenEnvelope(
+  keyHex: string,
+  envelopeHex: string,
+  macKeyHex: string = '',
+  aadHex?: string
+): Promise<{ plaintext: string; algorithm: string; mode: string; padding: string }> {
+  if (!hasWasm()) {
+    throw new Error('WASM crypto not available');
+  }
+  const result = (window as any).WasmCrypto.OpenEnvelope(keyHex, macKeyHex, envelopeHex, aadHex);
+  if (!result || typeof result !== 'object' || result.error) {
+    throw wasmFailure('OpenEnvelope', result);
+  }
+  return { plaintext: result.plaintext, algorithm: result.algorithm, mode: result.mode, padding: result.padding };
+}
+
 /**
  * High-level API: Encrypt message with mode and padding
  * Returns hex-encoded ciphertext and IV
@@ -290,7 +1253,9 @@ export async function encryptMessage(
   const normalizedKeyHex = await normalizeKey(algorithm, keyHex);
 
   // Generate IV if not provided
-  const useIvHex = ivHex || generateRandomHex(getBlockSize(algorithm));
+  const useIvHex = ivHex || (hasWasm()
+    ? await wasmGenerateIV(getBlockSize(algorithm), algorithm)
+    : generateRandomHex(getBlockSize(algorithm)));
 
   
   if (hasWasm()) {
@@ -300,7 +1265,9 @@ export async function encryptMessage(
       algorithm,
       normalizedKeyHex,
       plaintextHex,
-      useIvHex
+      useIvHex,
+      validMode,
+      validPadding
     );
     console.debug('[Crypto] ✅ WASM encryption succeeded');
     return {
@@ -391,7 +1358,10 @@ export const ENCRYPTION_CONFIG = {
     CFB: 'Cipher Feedback - Stream mode for variable-length data',
     OFB: 'Output Feedback - Parallelizable stream mode',
     CTR: 'Counter Mode - High performance, fully parallelizable',
-    RANDOM_DELTA: 'Random Delta Stream - Custom stream cipher with random state evolution'
+    RANDOM_DELTA: 'Random Delta Stream - Custom stream mode, the state advances by a keyed delta derived from the IV',
+    GCM: 'Galois/Counter Mode - Authenticated, detects tampering (not for LOKI97)',
+    'CBC+HMAC': 'CBC with HMAC-SHA256 - Encrypt-then-MAC, detects tampering',
+    'CTR+HMAC': 'CTR with HMAC-SHA256 - Encrypt-then-MAC, detects tampering'
   } as Record<EncryptionMode, string>,
   paddingDescriptions: {
     ZEROS: 'Zero-byte padding - Simple but ambiguous',
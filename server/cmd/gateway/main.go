@@ -8,9 +8,14 @@ import (
 
 	"MinMsgr/server/internal/api/gateway"
 	"MinMsgr/server/internal/config"
+	"MinMsgr/server/internal/pkg/blobstore"
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption/selftest"
 	"MinMsgr/server/internal/services/auth"
 	"MinMsgr/server/internal/services/chat"
 	"MinMsgr/server/internal/services/contact"
+	"MinMsgr/server/internal/services/device"
+	"MinMsgr/server/internal/services/file"
 	"MinMsgr/server/internal/services/message"
 	"MinMsgr/server/internal/storage"
 )
@@ -23,12 +28,24 @@ func main() {
 
 	// Connect to database with retries
 	dbConfig := storage.Config{
+		Driver:   cfg.Database.Driver,
+		Path:     cfg.Database.Path,
 		Host:     cfg.Database.Host,
 		Port:     cfg.Database.Port,
 		User:     cfg.Database.User,
 		Password: cfg.Database.Password,
 		Database: cfg.Database.Database,
 		SSLMode:  cfg.Database.SSLMode,
+
+		ReplicaHosts:  cfg.Database.ReplicaHosts,
+		MessageShards: cfg.Database.MessageShards,
+
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(cfg.Database.ConnMaxLifetimeSeconds) * time.Second,
+		PingTimeout:     time.Duration(cfg.Database.PingTimeoutSeconds) * time.Second,
+
+		SlowQueryThreshold: time.Duration(cfg.Database.SlowQueryMillis) * time.Millisecond,
 	}
 
 	var db *storage.DB
@@ -54,18 +71,47 @@ func main() {
 	defer db.Close()
 
 	// Initialize database schema
-	if err := db.InitSchema(); err != nil {
+	if err := db.InitSchema(context.Background()); err != nil {
 		log.Fatalf("Failed to initialize database schema: %v", err)
 	}
 	fmt.Println("Database schema initialized")
 
 	// Create services
 	authService := auth.New(cfg.JWT.Secret, db)
+	authService.SetAdmins(cfg.Server.AdminUserIDs)
 	contactService := contact.NewService(db)
 	chatService := chat.NewService(db)
 	messageService := message.NewService(db)
+	deviceService := device.NewService(db)
+
+	// Attachment blobs live outside the database
+	blobs, err := blobstore.New(blobstore.Config{
+		Driver:      cfg.Files.Driver,
+		Root:        cfg.Files.Root,
+		S3Endpoint:  cfg.Files.S3Endpoint,
+		S3Region:    cfg.Files.S3Region,
+		S3Bucket:    cfg.Files.S3Bucket,
+		S3AccessKey: cfg.Files.S3AccessKey,
+		S3SecretKey: cfg.Files.S3SecretKey,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize file storage: %v", err)
+	}
+	fileService := file.NewService(db, blobs, file.Limits{
+		MaxFileSize:      cfg.Files.MaxFileSize,
+		MaxChunkSize:     cfg.Files.MaxChunkSize,
+		AllowedMimeTypes: cfg.Files.AllowedMimeTypes,
+	})
+	urlSecret := cfg.Files.URLSecret
+	if urlSecret == "" {
+		urlSecret = cfg.JWT.Secret
+	}
+	fileService.SetURLSigning([]byte(urlSecret), time.Duration(cfg.Files.URLTTLSeconds)*time.Second)
 
 	// Ensure global DH parameters exist (seed if necessary)
+	if err := chatService.SetDHGroup(cfg.Crypto.DHGroup); err != nil {
+		log.Fatalf("Invalid DH_GROUP: %v", err)
+	}
 	func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -76,9 +122,48 @@ func main() {
 			if p != nil && g != nil {
 				log.Printf("Global DH parameters initialized (p length=%d, g length=%d)", len(p), len(g))
 			}
+			// Parameters stored by older versions are kept, so registration
+			// keys stay valid, but they may not be a safe group
+			if group, ok := crypto.IdentifyDHGroup(p, g); ok {
+				log.Printf("Global DH parameters are the RFC 7919 group %s", group.Name)
+			} else if err := crypto.ValidateDHParameters(p, g); err != nil {
+				log.Printf("Warning: global DH parameters fail validation (%v); create chats with a dh_group to use an RFC 7919 group", err)
+			}
 		}
 	}()
 
+	// Keep session keys off the server entirely if configured to
+	if cfg.Crypto.ClientOnlyKeys {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := chatService.EnableClientOnlyKeys(ctx)
+		cancel()
+		if err != nil {
+			log.Fatalf("Failed to enable client-only keys: %v", err)
+		}
+	}
+
+	// Enforce per-chat and server-wide message retention and per-message expiry in the background
+	messageService.SetRetentionPolicy(storage.PurgePolicy{
+		MaxAgeDays:         cfg.Retention.MaxAgeDays,
+		MaxMessagesPerChat: cfg.Retention.MaxMessagesPerChat,
+		TombstoneDays:      cfg.Retention.TombstoneDays,
+		ArchiveAfterDays:   cfg.Retention.ArchiveAfterDays,
+		BatchSize:          cfg.Retention.PurgeBatchSize,
+		DryRun:             cfg.Retention.DryRun,
+	})
+	if cfg.Retention.PurgeIntervalSeconds > 0 {
+		go messageService.RunRetentionPurge(context.Background(), time.Duration(cfg.Retention.PurgeIntervalSeconds)*time.Second)
+	}
+
+	// Clean up abandoned uploads and unreferenced blobs in the background
+	if cfg.Files.JanitorIntervalSeconds > 0 {
+		go fileService.RunJanitor(context.Background(),
+			time.Duration(cfg.Files.JanitorIntervalSeconds)*time.Second,
+			time.Duration(cfg.Files.UploadTTLSeconds)*time.Second,
+			time.Duration(cfg.Files.OrphanTTLSeconds)*time.Second,
+		)
+	}
+
 	// Create gateway server with services
 	gatewayServer := gateway.New(
 		fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
@@ -86,8 +171,30 @@ func main() {
 		contactService,
 		chatService,
 		messageService,
+		fileService,
+		deviceService,
 	)
 
+	// Several gateway instances on one Postgres database share their events
+	if cfg.Server.EventRelay {
+		if err := gatewayServer.EnableEventRelay(context.Background(), db); err != nil {
+			log.Fatalf("Failed to enable event relay: %v", err)
+		}
+	}
+
+	// Known-answer tests of every cipher, mode and padding; a failure keeps
+	// the gateway up for /readyz but turns all API traffic away
+	report := selftest.Run()
+	gatewayServer.SetSelfTest(report)
+	if report.Passed {
+		fmt.Printf("✓ Crypto self-test passed (%d checks in %v)\n", len(report.Results), report.Duration)
+	} else {
+		for _, res := range report.Failures() {
+			log.Printf("✗ Crypto self-test: %s: %s", res.Name, res.Error)
+		}
+		log.Printf("Crypto self-test failed; refusing API traffic")
+	}
+
 	// Start gateway server
 	if err := gatewayServer.Start(); err != nil {
 		log.Fatalf("Gateway server failed: %v", err)
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"MinMsgr/server/internal/config"
+	"MinMsgr/server/internal/storage"
+)
+
+const usage = `Usage: migrate <command> [arg]
+
+Commands:
+  up [version]   apply pending migrations, up to version if given
+  down [steps]   revert the last applied migrations (default 1)
+  status         list migrations and whether they are applied
+
+The database is configured through the same environment as the gateway.`
+
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command := os.Args[1]
+	var arg int64
+	if len(os.Args) == 3 {
+		n, err := strconv.ParseInt(os.Args[2], 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid argument %q: expected a positive number", os.Args[2])
+		}
+		arg = n
+	}
+
+	cfg := config.Load()
+	db, err := storage.New(storage.Config{
+		Driver:   cfg.Database.Driver,
+		Path:     cfg.Database.Path,
+		Host:     cfg.Database.Host,
+		Port:     cfg.Database.Port,
+		User:     cfg.Database.User,
+		Password: cfg.Database.Password,
+		Database: cfg.Database.Database,
+		SSLMode:  cfg.Database.SSLMode,
+
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(cfg.Database.ConnMaxLifetimeSeconds) * time.Second,
+		PingTimeout:     time.Duration(cfg.Database.PingTimeoutSeconds) * time.Second,
+	})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		applied, err := db.MigrateUp(ctx, arg)
+		for _, m := range applied {
+			fmt.Printf("✓ Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+
+	case "down":
+		steps := int(arg)
+		if steps == 0 {
+			steps = 1
+		}
+		reverted, err := db.MigrateDown(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("✓ Reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No migrations to revert")
+		}
+
+	case "status":
+		statuses, err := db.MigrationStatuses(ctx)
+		for _, s := range statuses {
+			if s.AppliedAt != nil {
+				fmt.Printf("%04d_%-40s applied %s\n", s.Version, s.Name, time.Unix(*s.AppliedAt, 0).Format(time.RFC3339))
+			} else {
+				fmt.Printf("%04d_%-40s pending\n", s.Version, s.Name)
+			}
+		}
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
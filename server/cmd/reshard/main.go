@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"MinMsgr/server/internal/config"
+	"MinMsgr/server/internal/storage"
+)
+
+const usage = `Usage: reshard <command> [args]
+
+Commands:
+  status                  show the chats and messages on the primary and every shard
+  move <chatID> <shard>   move the messages of one chat to shard (0 for the primary)
+  drain <from> <to>       move every chat on shard from to shard to
+
+The shards are configured through DB_MESSAGE_SHARDS, like the rest of the
+database, in the same environment as the gateway. Chats can be moved while
+the gateway is running.`
+
+// drainBatch is how many chats drain looks up at a time
+const drainBatch = 100
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command, args := os.Args[1], os.Args[2:]
+	var nums []int64
+	for _, arg := range args {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 0 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		nums = append(nums, n)
+	}
+	switch {
+	case command == "status" && len(nums) == 0:
+	case (command == "move" || command == "drain") && len(nums) == 2:
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+	db, err := storage.New(storage.Config{
+		Driver:   cfg.Database.Driver,
+		Path:     cfg.Database.Path,
+		Host:     cfg.Database.Host,
+		Port:     cfg.Database.Port,
+		User:     cfg.Database.User,
+		Password: cfg.Database.Password,
+		Database: cfg.Database.Database,
+		SSLMode:  cfg.Database.SSLMode,
+
+		MessageShards: cfg.Database.MessageShards,
+
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(cfg.Database.ConnMaxLifetimeSeconds) * time.Second,
+		PingTimeout:     time.Duration(cfg.Database.PingTimeoutSeconds) * time.Second,
+	})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	// A shard added to DB_MESSAGE_SHARDS gets its schema here if the gateway
+	// has not started with it yet
+	if err := db.InitSchema(ctx); err != nil {
+		log.Fatalf("Failed to initialize schema: %v", err)
+	}
+
+	switch command {
+	case "status":
+		stats, err := db.ShardStats(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read shard statistics: %v", err)
+		}
+		for _, st := range stats {
+			addr := st.Addr
+			if st.Shard == 0 {
+				addr = "primary"
+			}
+			log.Printf("  shard %d %-25s %d chats, %d messages", st.Shard, addr, st.Chats, st.Messages)
+		}
+
+	case "move":
+		move, err := db.MoveChat(ctx, nums[0], int(nums[1]))
+		if err != nil {
+			log.Fatalf("Failed to move chat %d: %v", nums[0], err)
+		}
+		if move == nil {
+			log.Fatalf("Chat %d does not exist", nums[0])
+		}
+		report(move)
+
+	case "drain":
+		moved, err := drain(ctx, db, int(nums[0]), int(nums[1]))
+		if err != nil {
+			log.Fatalf("Drain failed after %d chats: %v", moved, err)
+		}
+		log.Printf("✓ Moved %d chats from shard %d to shard %d", moved, nums[0], nums[1])
+	}
+}
+
+// drain moves the chats of shard from to shard to, in ID order, and returns
+// how many were moved. Chats placed on from while it runs may stay there.
+func drain(ctx context.Context, db *storage.DB, from, to int) (int, error) {
+	var moved int
+	var after int64
+	for {
+		chatIDs, err := db.ListShardChats(ctx, from, after, drainBatch)
+		if err != nil {
+			return moved, err
+		}
+		if len(chatIDs) == 0 {
+			return moved, nil
+		}
+		for _, chatID := range chatIDs {
+			move, err := db.MoveChat(ctx, chatID, to)
+			if err != nil {
+				return moved, fmt.Errorf("chat %d: %w", chatID, err)
+			}
+			if move != nil {
+				report(move)
+				moved++
+			}
+			after = chatID
+		}
+	}
+}
+
+func report(move *storage.ChatMove) {
+	if move.From == move.To {
+		log.Printf("✓ Chat %d is on shard %d already", move.ChatID, move.To)
+		return
+	}
+	log.Printf("✓ Moved chat %d from shard %d to shard %d (%d rows)", move.ChatID, move.From, move.To, move.Rows)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"MinMsgr/server/internal/config"
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/services/auth"
+	"MinMsgr/server/internal/services/chat"
+	"MinMsgr/server/internal/storage"
+)
+
+const usage = `Usage: seed [flags]
+
+Fills the database with test data: users with DH key pairs, their private
+keys wrapped with their password as a client would wrap them, accepted
+contacts, a direct chat per contact pair and random ciphertext messages
+spread over the past days. The ciphertext cannot be decrypted; it only has
+the shape of real messages. The database is configured through the same
+environment as the gateway.
+
+Flags:`
+
+// blockSize is a multiple of the block size of every registered cipher
+const blockSize = 16
+
+var (
+	algorithms = encryption.Ciphers()
+	modes      = []protocol.EncryptionMode{protocol.ECB, protocol.CBC, protocol.PCBC, protocol.CFB, protocol.OFB, protocol.CTR, protocol.RandomDelta}
+	paddings   = []protocol.PaddingMode{protocol.Zeros, protocol.PKCS7, protocol.ANSI, protocol.ISO10126}
+)
+
+func main() {
+	users := flag.Int("users", 10, "number of users to create")
+	contacts := flag.Int("contacts", 3, "accepted contacts (and chats) per user")
+	messages := flag.Int("messages", 50, "messages per chat")
+	days := flag.Int("days", 30, "spread messages over this many past days")
+	prefix := flag.String("prefix", "seed", "username prefix; users are named <prefix>1, <prefix>2, ...")
+	password := flag.String("password", "password123", "password of every created user")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 || *users < 1 || *contacts < 0 || *messages < 0 || *days < 1 || *prefix == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// Pairing each user with the next ones around a ring gives every user the
+	// same number of distinct contacts as long as it reaches at most halfway
+	if limit := (*users - 1) / 2; *contacts > limit {
+		log.Printf("Limiting contacts per user to %d for %d users", limit, *users)
+		*contacts = limit
+	}
+
+	cfg := config.Load()
+	db, err := storage.New(storage.Config{
+		Driver:   cfg.Database.Driver,
+		Path:     cfg.Database.Path,
+		Host:     cfg.Database.Host,
+		Port:     cfg.Database.Port,
+		User:     cfg.Database.User,
+		Password: cfg.Database.Password,
+		Database: cfg.Database.Database,
+		SSLMode:  cfg.Database.SSLMode,
+
+		// Chats created here get their messages on the shards, as the
+		// gateway would place them
+		MessageShards: cfg.Database.MessageShards,
+
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(cfg.Database.ConnMaxLifetimeSeconds) * time.Second,
+		PingTimeout:     time.Duration(cfg.Database.PingTimeoutSeconds) * time.Second,
+	})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	authService := auth.New(cfg.JWT.Secret, db)
+	chatService := chat.NewService(db)
+	if err := chatService.SetDHGroup(cfg.Crypto.DHGroup); err != nil {
+		log.Fatalf("Invalid DH_GROUP: %v", err)
+	}
+
+	userIDs, err := seedUsers(ctx, authService, chatService, *prefix, *password, *users)
+	if err != nil {
+		log.Fatalf("Failed to create users: %v", err)
+	}
+	log.Printf("✓ Created %d users (%s1..%s%d, password %q)", len(userIDs), *prefix, *prefix, len(userIDs), *password)
+
+	var chats, total int
+	for i := range userIDs {
+		for offset := 1; offset <= *contacts; offset++ {
+			user1, user2 := userIDs[i], userIDs[(i+offset)%len(userIDs)]
+			if _, err := db.AddContact(ctx, user1, user2, "accepted"); err != nil {
+				log.Fatalf("Failed to add contact %d-%d: %v", user1, user2, err)
+			}
+
+			resp, err := chatService.CreateChat(ctx, &protocol.ChatCreateRequest{
+				User1ID:   user1,
+				User2ID:   user2,
+				ChatType:  protocol.ChatTypeDirect,
+				Algorithm: algorithms[chats%len(algorithms)].Name,
+				Mode:      string(modes[chats%len(modes)]),
+				Padding:   string(paddings[chats%len(paddings)]),
+			})
+			if err != nil {
+				log.Fatalf("Failed to create chat %d-%d: %v", user1, user2, err)
+			}
+			if !resp.Success {
+				log.Fatalf("Failed to create chat %d-%d: %s", user1, user2, resp.Error)
+			}
+			chats++
+
+			n, err := seedMessages(ctx, db, resp.ChatID, user1, user2, *messages, *days)
+			if err != nil {
+				log.Fatalf("Failed to add messages to chat %d: %v", resp.ChatID, err)
+			}
+			total += n
+		}
+	}
+	log.Printf("✓ Created %d accepted contacts and chats", chats)
+	log.Printf("✓ Created %d messages", total)
+}
+
+// seedUsers registers count users, each with a DH key pair derived from the
+// global DH parameters as a client would. The private keys are stored
+// wrapped with the password, so the users can log in from a client.
+func seedUsers(ctx context.Context, authService *auth.Service, chatService *chat.Service, prefix, password string, count int) ([]int64, error) {
+	pBytes, gBytes, err := chatService.GetGlobalDHParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p, g := new(big.Int).SetBytes(pBytes), new(big.Int).SetBytes(gBytes)
+
+	ids := make([]int64, 0, count)
+	for i := 1; i <= count; i++ {
+		private, err := rand.Int(rand.Reader, p)
+		if err != nil {
+			return nil, err
+		}
+		public := new(big.Int).Exp(g, private, p)
+
+		params, err := crypto.DefaultKDFParams(crypto.KDFArgon2id)
+		if err != nil {
+			return nil, err
+		}
+		privateBytes := private.Bytes()
+		wrapped, err := crypto.WrapKey([]byte(password), privateBytes, params)
+		crypto.Wipe(privateBytes)
+		crypto.WipeInt(private)
+		if err != nil {
+			return nil, err
+		}
+
+		username := fmt.Sprintf("%s%d", prefix, i)
+		id, _, err := authService.Register(ctx, username, password, hex.EncodeToString(public.Bytes()), hex.EncodeToString(wrapped))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", username, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// seedMessages stores count messages between the two users of a chat, at
+// even intervals over the last days days
+func seedMessages(ctx context.Context, db *storage.DB, chatID, user1, user2 int64, count, days int) (int, error) {
+	if count == 0 {
+		return 0, nil
+	}
+	now := time.Now().Unix()
+	start := now - int64(days)*86400
+	step := (now - start) / int64(count)
+
+	batch := make([]*storage.Message, count)
+	for i := range batch {
+		random := make([]byte, 2)
+		if _, err := rand.Read(random); err != nil {
+			return 0, err
+		}
+		sender := user1
+		if random[0]&1 == 1 {
+			sender = user2
+		}
+
+		ciphertext := make([]byte, blockSize*(1+int(random[1])%16))
+		iv := make([]byte, blockSize)
+		if _, err := rand.Read(ciphertext); err != nil {
+			return 0, err
+		}
+		if _, err := rand.Read(iv); err != nil {
+			return 0, err
+		}
+
+		batch[i] = &storage.Message{
+			ChatID:     chatID,
+			SenderID:   sender,
+			Ciphertext: ciphertext,
+			IV:         iv,
+			CreatedAt:  start + int64(i)*step,
+		}
+	}
+
+	ids, err := db.SaveMessages(ctx, batch)
+	return len(ids), err
+}
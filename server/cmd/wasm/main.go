@@ -7,14 +7,14 @@ import (
 	"fmt"
 	"syscall/js"
 
-	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/wasm"
 )
 
 func main() {
 	fmt.Println("WASM Crypto Module Initialized")
 
 	// Register all WASM functions
-	encryption.RegisterWasmFunctions()
+	wasm.RegisterFunctions()
 
 	// Export a ready flag to signal that WASM is ready
 	js.Global().Set("WasmReady", js.ValueOf(true))
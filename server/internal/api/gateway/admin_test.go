@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"MinMsgr/server/internal/services/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// TestAdminEndpointsRequireAdminID checks that a user outside ADMIN_USER_IDS
+// gets 403 from the admin endpoints, whatever their username
+func TestAdminEndpointsRequireAdminID(t *testing.T) {
+	authSvc := auth.New("test-secret", nil)
+	authSvc.SetAdmins([]int64{1})
+	s := &Server{authSvc: authSvc}
+
+	token, err := authSvc.CreateToken(2, "admin")
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/admin/storage", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	s.handleGetStorageStats(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("storage stats: expected 403, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/admin/users/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req = mux.SetURLVars(req, map[string]string{"userID": "1"})
+	rec = httptest.NewRecorder()
+	s.handlePurgeUser(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("purge: expected 403, got %d", rec.Code)
+	}
+
+	// An admin cannot purge an admin account, its own included
+	adminToken, err := authSvc.CreateToken(1, "root")
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	req = httptest.NewRequest("DELETE", "/api/admin/users/1", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	req = mux.SetURLVars(req, map[string]string{"userID": "1"})
+	rec = httptest.NewRecorder()
+	s.handlePurgeUser(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("purging an admin: expected 409, got %d", rec.Code)
+	}
+
+	if !authSvc.IsAdmin(1) || authSvc.IsAdmin(2) {
+		t.Error("IsAdmin does not follow the configured IDs")
+	}
+}
@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/services/auth"
+	"MinMsgr/server/internal/services/chat"
+	"MinMsgr/server/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// TestDeleteChatRequiresConfirmation checks that DELETE /api/chats/{id}
+// leaves the chat alone until ?confirm=true, and only for a participant
+func TestDeleteChatRequiresConfirmation(t *testing.T) {
+	ctx := context.Background()
+	db, err := storage.New(storage.Config{Driver: storage.DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if err := db.InitSchema(ctx); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+
+	var userIDs [3]int64
+	for i, name := range []string{"alice", "bob", "carol"} {
+		if userIDs[i], err = db.CreateUser(ctx, name, "hash"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alice, bob, carol := userIDs[0], userIDs[1], userIDs[2]
+	chatID, err := db.CreateChat(ctx, alice, bob, "direct", "AES", "CBC", "PKCS7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authSvc := auth.New("test-secret", db)
+	s := &Server{authSvc: authSvc, chatSvc: chat.NewService(db)}
+
+	deleteChat := func(userID int64, query string) (int, *protocol.ChatResponse) {
+		t.Helper()
+		token, err := authSvc.CreateToken(userID, "user")
+		if err != nil {
+			t.Fatalf("CreateToken failed: %v", err)
+		}
+		id := strconv.FormatInt(chatID, 10)
+		req := httptest.NewRequest("DELETE", "/api/chats/"+id+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req = mux.SetURLVars(req, map[string]string{"chatID": id})
+		rec := httptest.NewRecorder()
+		s.handleDeleteChat(rec, req)
+		var resp protocol.ChatResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return rec.Code, &resp
+	}
+	chatExists := func() bool {
+		t.Helper()
+		c, err := db.GetChat(ctx, chatID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c != nil
+	}
+
+	for _, query := range []string{"", "?confirm=false", "?confirm=1"} {
+		code, resp := deleteChat(alice, query)
+		if code != http.StatusPreconditionRequired || resp.Success {
+			t.Errorf("%q: expected 428, got %d %+v", query, code, resp)
+		}
+		if !chatExists() {
+			t.Fatalf("%q: chat deleted without confirmation", query)
+		}
+	}
+
+	if _, resp := deleteChat(carol, "?confirm=true"); resp.Success || !chatExists() {
+		t.Fatalf("a non-participant deleted the chat: %+v", resp)
+	}
+
+	code, resp := deleteChat(alice, "?confirm=true")
+	if code != http.StatusOK || !resp.Success {
+		t.Fatalf("confirmed delete: got %d %+v", code, resp)
+	}
+	if chatExists() {
+		t.Error("chat still exists after a confirmed delete")
+	}
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+// relayQueueSize is how many events may wait to be published to the other
+// gateway instances
+const relayQueueSize = 1024
+
+// relayedEvent is a WebSocket event as sent to the other gateway instances
+type relayedEvent struct {
+	// Origin is the instance that published the event; it has delivered the
+	// event to its own clients already
+	Origin string                   `json:"origin"`
+	Event  *protocol.WebSocketEvent `json:"event"`
+}
+
+// eventRelay shares the WebSocket events of several gateway instances through
+// Postgres LISTEN/NOTIFY, so an event reaches its user whichever instance the
+// user is connected to
+type eventRelay struct {
+	store    *storage.DB
+	instance string
+	outgoing chan *protocol.WebSocketEvent
+}
+
+// EnableEventRelay makes this instance publish every WebSocket event to the
+// other instances on the database and deliver theirs to its own clients, until
+// ctx is done. Requires PostgreSQL.
+func (s *Server) EnableEventRelay(ctx context.Context, store *storage.DB) error {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	relay := &eventRelay{
+		store:    store,
+		instance: hex.EncodeToString(id),
+		outgoing: make(chan *protocol.WebSocketEvent, relayQueueSize),
+	}
+
+	// Fail early on other drivers rather than in the background; listeners
+	// ignore the probe as it carries no event
+	if err := store.PublishEvent(ctx, []byte("{}")); err != nil {
+		return err
+	}
+
+	// A single publisher keeps this instance's events in order
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-relay.outgoing:
+				payload, err := json.Marshal(&relayedEvent{Origin: relay.instance, Event: event})
+				if err != nil {
+					log.Printf("[Relay] ERROR: Failed to encode %s event: %v", event.Type, err)
+					continue
+				}
+				if err := store.PublishEvent(ctx, payload); err != nil {
+					log.Printf("[Relay] ERROR: Failed to publish %s event for user %d: %v", event.Type, event.UserID, err)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		err := store.ListenEvents(ctx, func(payload []byte) {
+			var relayed relayedEvent
+			if err := json.Unmarshal(payload, &relayed); err != nil {
+				log.Printf("[Relay] ERROR: Failed to decode relayed event: %v", err)
+				return
+			}
+			if relayed.Event == nil || relayed.Origin == relay.instance {
+				return
+			}
+			s.deliver(relayed.Event)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[Relay] ERROR: Event listener stopped: %v", err)
+		}
+	}()
+
+	s.mu.Lock()
+	s.relay = relay
+	s.mu.Unlock()
+	log.Printf("[Relay] Relaying WebSocket events through LISTEN/NOTIFY as instance %s", relay.instance)
+	return nil
+}
+
+// publish queues an event for the other gateway instances
+func (r *eventRelay) publish(event *protocol.WebSocketEvent) {
+	select {
+	case r.outgoing <- event:
+	default:
+		log.Printf("[Relay] ERROR: Relay queue full, %s event for user %d is not relayed", event.Type, event.UserID)
+	}
+}
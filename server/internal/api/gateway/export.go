@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"MinMsgr/server/internal/protocol"
+)
+
+// handleExportMessages streams a chat's full history. The default format is
+// NDJSON (one message per line); ?format=json streams a single JSON array.
+// ?after_id= resumes an interrupted export after the last message received.
+func (s *Server) handleExportMessages(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+
+	if chatID == 0 {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "json" {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+	afterID := parseInt(query.Get("after_id"))
+
+	// Large histories take a while; the export stops when the client disconnects
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	written := 0
+
+	emit := func(m *protocol.ExportedMessage) error {
+		// Headers are sent with the first message so that access errors can
+		// still be reported with a proper status code
+		if written == 0 {
+			if format == "json" {
+				w.Header().Set("Content-Type", "application/json")
+			} else {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+			}
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"chat-%d.%s\"", chatID, format))
+			if format == "json" {
+				w.Write([]byte("[\n"))
+			}
+		} else if format == "json" {
+			w.Write([]byte(","))
+		}
+		if err := encoder.Encode(m); err != nil {
+			return err
+		}
+		written++
+		if flusher != nil && written%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	err = s.messageSvc.ExportMessages(ctx, chatID, claims.UserID, afterID, emit)
+	if err != nil && written == 0 {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+	if err != nil {
+		// Too late for an error status; the client resumes with after_id
+		log.Printf("[Export] Export of chat %d for user %d interrupted after %d messages: %v", chatID, claims.UserID, written, err)
+		return
+	}
+
+	if written == 0 {
+		if format == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("[]\n"))
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+		}
+		return
+	}
+	if format == "json" {
+		w.Write([]byte("]\n"))
+	}
+}
@@ -0,0 +1,427 @@
+package gateway
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// handleCreateUpload starts a chunked upload. The client then PUTs the file in
+// chunks to /api/files/uploads/{uploadID}?offset=N and finishes with
+// POST /api/files/uploads/{uploadID}/complete.
+func (s *Server) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ChatID   int64  `json:"chat_id"`
+		FileName string `json:"file_name"`
+		MimeType string `json:"mime_type"`
+		Size     int64  `json:"size"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.ChatID == 0 || req.FileName == "" || req.MimeType == "" || req.Size == 0 {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	status, err := s.fileSvc.CreateUpload(ctx, claims.UserID, req.ChatID, req.FileName, req.MimeType, req.Size)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleGetUpload reports how many bytes of an upload were received so the client can resume
+func (s *Server) handleGetUpload(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	uploadID := vars["uploadID"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	status, err := s.fileSvc.GetUpload(ctx, claims.UserID, uploadID)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleUploadChunk stores one chunk. The raw request body is the chunk and
+// ?offset= must equal the number of bytes already received.
+func (s *Server) handleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	uploadID := vars["uploadID"]
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	if r.ContentLength <= 0 {
+		http.Error(w, "Content-Length required", http.StatusLengthRequired)
+		return
+	}
+
+	// Chunks can be large, so allow more time than for JSON requests
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	status, err := s.fileSvc.UploadChunk(ctx, claims.UserID, uploadID, offset, r.Body, r.ContentLength)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleCompleteUpload assembles the chunks and returns the file to attach to a message
+func (s *Server) handleCompleteUpload(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	uploadID := vars["uploadID"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	fileInfo, err := s.fileSvc.CompleteUpload(ctx, claims.UserID, uploadID)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fileInfo)
+}
+
+// handleSetThumbnail stores the encrypted preview image of an uploaded file.
+// The body carries the hex-encoded thumbnail and its width and height.
+func (s *Server) handleSetThumbnail(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	fileID := parseInt(vars["fileID"])
+
+	if fileID == 0 {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Thumbnail string `json:"thumbnail"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	data, err := hex.DecodeString(req.Thumbnail)
+	if err != nil {
+		http.Error(w, "invalid thumbnail hex", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	fileInfo, err := s.fileSvc.SetThumbnail(ctx, claims.UserID, fileID, data, req.Width, req.Height)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fileInfo)
+}
+
+// handleSignFileURL returns a short-lived download URL for media players and
+// other clients that cannot send an Authorization header
+func (s *Server) handleSignFileURL(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	fileID := parseInt(vars["fileID"])
+
+	if fileID == 0 {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	signed, err := s.fileSvc.SignDownloadURL(ctx, claims.UserID, fileID)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signed)
+}
+
+// handleDownloadFile streams a file's (client-encrypted) contents. The caller
+// authenticates either with a bearer token or with the uid/expires/sig query
+// parameters of a signed URL. A single "Range: bytes=" range is supported so
+// media players can seek.
+func (s *Server) handleDownloadFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := parseInt(vars["fileID"])
+
+	if fileID == 0 {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var userID int64
+	query := r.URL.Query()
+	if sig := query.Get("sig"); sig != "" {
+		uid := parseInt(query.Get("uid"))
+		expires := parseInt(query.Get("expires"))
+		if err := s.fileSvc.VerifyDownloadSignature(fileID, uid, expires, sig); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		userID = uid
+	} else {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+			return
+		}
+
+		token := extractToken(authHeader)
+		if token == "" {
+			http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := s.authSvc.ValidateToken(token)
+		if err != nil {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		userID = claims.UserID
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	fileInfo, err := s.fileSvc.GetFile(ctx, userID, fileID)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	// The stored bytes are ciphertext; the original MIME type is passed separately
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileInfo.FileName))
+	w.Header().Set("X-File-Mime-Type", fileInfo.MimeType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition, X-File-Mime-Type, Content-Range, Accept-Ranges")
+
+	status := http.StatusOK
+	offset, length := int64(0), int64(-1)
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		var ok bool
+		offset, length, ok = parseRange(rangeHeader, fileInfo.Size)
+		if !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fileInfo.Size))
+			http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, fileInfo.Size))
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size, 10))
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+
+	body, err := s.fileSvc.OpenFile(ctx, userID, fileID, offset, length)
+	if err != nil {
+		w.Header().Del("Content-Range")
+		w.Header().Del("Content-Length")
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+	defer body.Close()
+
+	w.WriteHeader(status)
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("[Files] Download of file %d interrupted: %v", fileID, err)
+	}
+}
+
+// parseRange parses a single-range "bytes=" Range header against a file of the
+// given size and returns the offset and length to serve
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") || size == 0 {
+		return 0, 0, false
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	// "bytes=-N" requests the last N bytes
+	if startStr == "" {
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
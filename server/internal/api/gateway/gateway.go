@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -16,11 +17,18 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/identity"
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption/selftest"
 	"MinMsgr/server/internal/protocol"
 	"MinMsgr/server/internal/services/auth"
 	"MinMsgr/server/internal/services/chat"
 	"MinMsgr/server/internal/services/contact"
+	"MinMsgr/server/internal/services/device"
+	"MinMsgr/server/internal/services/file"
 	"MinMsgr/server/internal/services/message"
+	"MinMsgr/server/internal/storage"
 )
 
 // Server represents the API gateway
@@ -30,11 +38,19 @@ type Server struct {
 	contactSvc *contact.Service
 	chatSvc    *chat.Service
 	messageSvc *message.Service
+	fileSvc    *file.Service
+	deviceSvc  *device.Service
+	access     *authz.Checker
 	mu         sync.RWMutex
 	clients    map[*Client]bool
 	broadcast  chan interface{}
 	register   chan *Client
 	unregister chan *Client
+	// relay shares events with other gateway instances; nil unless
+	// EnableEventRelay was called
+	relay *eventRelay
+	// selfTest is the crypto self-test outcome; nil until SetSelfTest
+	selfTest *selftest.Report
 }
 
 // Client represents a connected WebSocket client
@@ -49,8 +65,8 @@ type Client struct {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range")
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -72,13 +88,16 @@ func extractToken(authHeader string) string {
 }
 
 // New creates a new gateway server
-func New(addr string, authSvc *auth.Service, contactSvc *contact.Service, chatSvc *chat.Service, messageSvc *message.Service) *Server {
+func New(addr string, authSvc *auth.Service, contactSvc *contact.Service, chatSvc *chat.Service, messageSvc *message.Service, fileSvc *file.Service, deviceSvc *device.Service) *Server {
 	server := &Server{
 		addr:       addr,
 		authSvc:    authSvc,
 		contactSvc: contactSvc,
 		chatSvc:    chatSvc,
 		messageSvc: messageSvc,
+		fileSvc:    fileSvc,
+		deviceSvc:  deviceSvc,
+		access:     authz.New(chatSvc.GetStore()),
 		clients:    make(map[*Client]bool),
 		broadcast:  make(chan interface{}, 1024), // Buffered channel to prevent blocking
 		register:   make(chan *Client),
@@ -92,6 +111,7 @@ func New(addr string, authSvc *auth.Service, contactSvc *contact.Service, chatSv
 	contactSvc.SetBroadcastHandler(broadcastHandler)
 	chatSvc.SetBroadcastHandler(broadcastHandler)
 	messageSvc.SetBroadcastHandler(broadcastHandler)
+	deviceSvc.SetBroadcastHandler(broadcastHandler)
 
 	return server
 }
@@ -107,6 +127,12 @@ func (s *Server) Start() error {
 		w.Write([]byte("MinMessanger API Server"))
 	}).Methods("GET", "OPTIONS")
 
+	// Readiness, with the crypto self-test results
+	router.HandleFunc("/readyz", s.handleReadyz).Methods("GET")
+
+	// Background worker metrics for monitoring
+	router.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+
 	// Auth endpoints
 	router.HandleFunc("/api/auth/register", s.handleRegister).Methods("POST", "OPTIONS")
 	router.HandleFunc("/api/auth/login", s.handleLogin).Methods("POST", "OPTIONS")
@@ -115,6 +141,8 @@ func (s *Server) Start() error {
 	router.HandleFunc("/api/contacts", s.handleGetContacts).Methods("GET", "OPTIONS")
 	router.HandleFunc("/api/contacts/request", s.handleContactRequest).Methods("POST", "OPTIONS")
 	router.HandleFunc("/api/contacts/pending", s.handleGetPendingRequests).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/contacts/{contactID}/verification", s.handleGetContactVerification).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/contacts/{contactID}/verification", s.handleVerifyContact).Methods("POST", "OPTIONS")
 
 	// Chat endpoints - more specific routes first
 	router.HandleFunc("/api/chats/create", s.handleCreateChat).Methods("POST", "OPTIONS")
@@ -122,21 +150,78 @@ func (s *Server) Start() error {
 
 	// Global DH params (public)
 	router.HandleFunc("/api/dh/global", s.handleGetGlobalDHParams).Methods("GET", "OPTIONS")
+	// Supported algorithms, modes, paddings and DH groups (public)
+	router.HandleFunc("/api/crypto/capabilities", s.handleGetCryptoCapabilities).Methods("GET", "OPTIONS")
 	// User public key (stored at registration)
 	router.HandleFunc("/api/users/{userID}/public-key", s.handleGetUserPublicKey).Methods("GET", "OPTIONS")
 	// Authenticated user's own public key
 	router.HandleFunc("/api/me/public-key", s.handleGetMyPublicKey).Methods("GET", "OPTIONS")
+	// Identity key the user signs their DH public keys with
+	router.HandleFunc("/api/me/identity-key", s.handleSetIdentityKey).Methods("PUT", "OPTIONS")
+	// Devices of the user, each with its own key pair
+	router.HandleFunc("/api/devices", s.handleListMyDevices).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/devices", s.handleRegisterDevice).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/devices/{deviceID}", s.handleRevokeDevice).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/users/{userID}/devices", s.handleListUserDevices).Methods("GET", "OPTIONS")
+	// Key bundle sealed under a backup passphrase, for moving to a new device
+	router.HandleFunc("/api/me/key-backup", s.handleGetKeyBackup).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/me/key-backup", s.handleSaveKeyBackup).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/me/key-backup", s.handleDeleteKeyBackup).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/me/notifications", s.handleGetMyNotificationPrefs).Methods("GET", "OPTIONS")
+	// Account-wide incremental sync of messages, chats and contacts
+	router.HandleFunc("/api/sync", s.handleSync).Methods("GET", "OPTIONS")
+
+	// Admin endpoints (ADMIN_USER_IDS)
+	router.HandleFunc("/api/admin/storage", s.handleGetStorageStats).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/admin/users/{userID}", s.handlePurgeUser).Methods("DELETE", "OPTIONS")
 
 	router.HandleFunc("/api/chats/{chatID}/dh/init", s.handleDHInit).Methods("POST", "OPTIONS")
 	router.HandleFunc("/api/chats/{chatID}/dh/exchange", s.handleDHExchange).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/dh/status", s.handleDHStatus).Methods("GET", "OPTIONS")
 	router.HandleFunc("/api/chats/{chatID}/messages", s.handleGetMessages).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/messages/export", s.handleExportMessages).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/read", s.handleMarkRead).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/receipts", s.handleReceipt).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/read-markers", s.handleGetReadMarkers).Methods("GET", "OPTIONS")
 	router.HandleFunc("/api/chats/{chatID}/close", s.handleCloseChat).Methods("POST", "OPTIONS")
 	router.HandleFunc("/api/chats/{chatID}/join", s.handleJoinChat).Methods("POST", "OPTIONS")
 	router.HandleFunc("/api/chats/{chatID}/leave", s.handleLeaveChat).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/retention", s.handleSetRetention).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/stats", s.handleGetChatStats).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/notifications", s.handleGetNotificationPrefs).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/notifications", s.handleSetNotificationPrefs).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/notifications", s.handleResetNotificationPrefs).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/search", s.handleSearchMessages).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/messages/{messageID}/search-tokens", s.handleIndexMessage).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/pins", s.handleListPins).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/pins/{messageID}", s.handlePinMessage).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/pins/{messageID}", s.handleUnpinMessage).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/draft", s.handleGetDraft).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/draft", s.handleSaveDraft).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/draft", s.handleClearDraft).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/ratchet", s.handleGetRatchet).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/ratchet", s.handleResetRatchet).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/key-shares", s.handleGetKeyShare).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/key-shares", s.handleShareChatKeys).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/safety-number", s.handleGetSafetyNumber).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/verification", s.handleGetVerification).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/verification", s.handleConfirmSAS).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}/verification", s.handleRejectSAS).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}", s.handleGetChatDetails).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/chats/{chatID}", s.handleDeleteChat).Methods("DELETE", "OPTIONS")
 
 	// Message endpoints
 	router.HandleFunc("/api/messages/send", s.handleSendMessage).Methods("POST", "OPTIONS")
 
+	// File upload endpoints (chunked, resumable)
+	router.HandleFunc("/api/files", s.handleCreateUpload).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/files/uploads/{uploadID}", s.handleGetUpload).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/files/uploads/{uploadID}", s.handleUploadChunk).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/files/uploads/{uploadID}/complete", s.handleCompleteUpload).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/files/{fileID:[0-9]+}", s.handleDownloadFile).Methods("GET", "HEAD", "OPTIONS")
+	router.HandleFunc("/api/files/{fileID:[0-9]+}/url", s.handleSignFileURL).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/files/{fileID:[0-9]+}/thumbnail", s.handleSetThumbnail).Methods("PUT", "OPTIONS")
+
 	// WebSocket endpoint
 	router.HandleFunc("/ws", s.handleWebSocket)
 
@@ -144,7 +229,7 @@ func (s *Server) Start() error {
 	go s.runHub()
 
 	fmt.Printf("Gateway server listening on %s\n", s.addr)
-	return http.ListenAndServe(s.addr, corsMiddleware(router))
+	return http.ListenAndServe(s.addr, corsMiddleware(s.readinessMiddleware(router)))
 }
 
 // handleRegister handles user registration
@@ -161,7 +246,7 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, encPrivHex, err := s.authSvc.Register(req.Username, req.Password, req.PublicKey, req.EncryptedPrivateKey)
+	userID, encPrivHex, err := s.authSvc.Register(r.Context(), req.Username, req.Password, req.PublicKey, req.EncryptedPrivateKey)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -210,6 +295,13 @@ func (s *Server) handleGetGlobalDHParams(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// handleGetCryptoCapabilities lists the crypto parameters chats can be
+// created with
+func (s *Server) handleGetCryptoCapabilities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.chatSvc.Capabilities())
+}
+
 // handleGetMyPublicKey retrieves the authenticated user's public key
 func (s *Server) handleGetMyPublicKey(w http.ResponseWriter, r *http.Request) {
 	authHeader := r.Header.Get("Authorization")
@@ -230,7 +322,7 @@ func (s *Server) handleGetMyPublicKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pub, err := s.authSvc.GetUserPublicKey(claims.UserID)
+	pub, err := s.authSvc.GetUserPublicKey(r.Context(), claims.UserID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -267,26 +359,86 @@ func (s *Server) handleGetUserPublicKey(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	uid := parseInt(vars["userID"])
 
-	pub, err := s.authSvc.GetUserPublicKey(int64(uid))
+	pub, err := s.authSvc.GetUserPublicKey(r.Context(), int64(uid))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	resp := map[string]string{"public_key": ""}
+	identityKey, err := s.authSvc.GetUserIdentityKey(r.Context(), int64(uid))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]string{"public_key": "", "identity_key": ""}
 	if pub != nil {
 		resp["public_key"] = hex.EncodeToString(pub)
 	}
+	if identityKey != nil {
+		resp["identity_key"] = hex.EncodeToString(identityKey)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// handleSetIdentityKey publishes the authenticated user's identity key
+func (s *Server) handleSetIdentityKey(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		IdentityKey string `json:"identity_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	changed, err := s.authSvc.SetIdentityKey(ctx, claims.UserID, req.IdentityKey)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+	if changed {
+		// Peers compare safety numbers again; the key is saved either way
+		if err := s.chatSvc.NotifyIdentityKeyChanged(ctx, claims.UserID); err != nil {
+			log.Printf("[Gateway] Failed to notify chats of user %d about the new identity key: %v", claims.UserID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+}
+
 // handleLogin handles user login
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		// DeviceID, if given, also returns that device's wrapped private key
+		DeviceID int64 `json:"device_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -294,7 +446,7 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, encPrivHex, err := s.authSvc.Login(req.Username, req.Password)
+	token, encPrivHex, err := s.authSvc.Login(r.Context(), req.Username, req.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
@@ -314,6 +466,21 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		"encrypted_private_key": encPrivHex,
 	}
 
+	if req.DeviceID != 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		// A revoked device gets no device fields and registers again
+		deviceKeyHex, err := s.deviceSvc.PrivateKey(ctx, claims.UserID, req.DeviceID)
+		if err != nil && !errors.Is(err, device.ErrDeviceNotFound) {
+			http.Error(w, err.Error(), statusForError(err))
+			return
+		}
+		if err == nil {
+			response["device_id"] = req.DeviceID
+			response["device_encrypted_private_key"] = deviceKeyHex
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -373,6 +540,15 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Start reading and writing goroutines
 	go client.readPump()
 	go client.writePump()
+
+	// Replay messages that arrived while the user was offline
+	go func(userID int64) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.messageSvc.FlushPendingDeliveries(ctx, userID); err != nil {
+			log.Printf("Failed to flush pending deliveries for user %d: %v", userID, err)
+		}
+	}(claims.UserID)
 }
 
 // runHub manages all connected clients
@@ -462,14 +638,38 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		var msg protocol.GatewayResponse
+		var msg protocol.ClientEvent
 		err := c.conn.ReadJSON(&msg)
 		if err != nil {
 			break
 		}
 
-		// TODO: Process message based on type if needed
-		// Currently, clients handle WebSocket messages on the client-side
+		switch msg.Type {
+		case "message_ack":
+			var ack protocol.MessageAck
+			if err := json.Unmarshal(msg.Data, &ack); err != nil {
+				log.Printf("[WS] Invalid message_ack from user %d: %v", c.userID, err)
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := c.server.messageSvc.AckDelivered(ctx, ack.ChatID, c.userID, ack.MessageID); err != nil {
+				log.Printf("[WS] Failed to record delivery of message %d for user %d: %v", ack.MessageID, c.userID, err)
+			}
+			cancel()
+		case "message_receipt":
+			var receipt protocol.MessageReceipt
+			if err := json.Unmarshal(msg.Data, &receipt); err != nil {
+				log.Printf("[WS] Invalid message_receipt from user %d: %v", c.userID, err)
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := c.server.messageSvc.AckReceipt(ctx, c.userID, &receipt); err != nil {
+				log.Printf("[WS] Failed to record %s receipt up to message %d for user %d: %v", receipt.Status, receipt.UpToMessageID, c.userID, err)
+			}
+			cancel()
+		default:
+			// Other client events are not handled by the server
+		}
 	}
 }
 
@@ -682,26 +882,49 @@ func (s *Server) handleCreateChat(w http.ResponseWriter, r *http.Request) {
 	// Parse JSON request body
 	var req struct {
 		User2ID   int64  `json:"user2_id"`
+		ChatType  string `json:"chat_type"`
 		Algorithm string `json:"algorithm"`
 		Mode      string `json:"mode"`
 		Padding   string `json:"padding"`
+		// KeyAgreement is optional and defaults to DH
+		KeyAgreement string `json:"key_agreement"`
+		// DHGroup is optional; DH chats without one use the global parameters
+		DHGroup string `json:"dh_group"`
+		// DHGroupBits is optional and picks the group by prime size
+		DHGroupBits int `json:"dh_group_bits"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	switch req.ChatType {
+	case "":
+		req.ChatType = protocol.ChatTypeDirect
+	case protocol.ChatTypeDirect:
+	case protocol.ChatTypeSelf:
+		// Saved Messages is always the caller's own chat
+		req.User2ID = claims.UserID
+	default:
+		http.Error(w, "Invalid chat type", http.StatusBadRequest)
+		return
+	}
+
 	if req.User2ID == 0 || req.Algorithm == "" || req.Mode == "" || req.Padding == "" {
 		http.Error(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
 
 	chatReq := &protocol.ChatCreateRequest{
-		User1ID:   claims.UserID,
-		User2ID:   req.User2ID,
-		Algorithm: req.Algorithm,
-		Mode:      req.Mode,
-		Padding:   req.Padding,
+		User1ID:      claims.UserID,
+		User2ID:      req.User2ID,
+		ChatType:     req.ChatType,
+		Algorithm:    req.Algorithm,
+		Mode:         req.Mode,
+		Padding:      req.Padding,
+		KeyAgreement: req.KeyAgreement,
+		DHGroup:      req.DHGroup,
+		DHGroupBits:  req.DHGroupBits,
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
@@ -709,7 +932,7 @@ func (s *Server) handleCreateChat(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := s.chatSvc.CreateChat(ctx, chatReq)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusForError(err))
 		return
 	}
 
@@ -742,7 +965,10 @@ func (s *Server) handleCloseChat(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	resp, err := s.chatSvc.CloseChat(ctx, chatID, claims.UserID)
+	// ?keep_history=true soft closes the chat: messages are hidden rather than deleted
+	keepHistory := r.URL.Query().Get("keep_history") == "true"
+
+	resp, err := s.chatSvc.CloseChat(ctx, chatID, claims.UserID, keepHistory)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -750,18 +976,12 @@ func (s *Server) handleCloseChat(w http.ResponseWriter, r *http.Request) {
 
 	// Broadcast chat closed event to the other participant
 	if resp.Success {
-		// Get the chat to find the other user
-		chatData, err := s.chatSvc.GetStore().GetChat(chatID)
+		// Look up the other participant of the chat
+		access, err := s.access.ChatAccess(ctx, claims.UserID, chatID)
 		if err != nil {
 			fmt.Printf("[Chat] ERROR: Failed to get chat after closing: %v\n", err)
-		} else if chatData != nil {
-			// Determine which user is the other participant
-			var otherUserID int64
-			if chatData.User1ID == claims.UserID {
-				otherUserID = chatData.User2ID
-			} else {
-				otherUserID = chatData.User1ID
-			}
+		} else {
+			otherUserID := access.OtherUserID
 
 			// Send targeted chat_closed event to the other participant
 			wsEvent := &protocol.WebSocketEvent{
@@ -769,25 +989,196 @@ func (s *Server) handleCloseChat(w http.ResponseWriter, r *http.Request) {
 				UserID:    otherUserID, // Targeted to the other user
 				Timestamp: time.Now().Unix(),
 				Data: map[string]interface{}{
-					"chat_id": chatID,
-					"user_id": claims.UserID, // The user who closed the chat
+					"chat_id":      chatID,
+					"user_id":      claims.UserID, // The user who closed the chat
+					"keep_history": keepHistory,
 				},
 			}
 			fmt.Printf("[Chat] Broadcasting chat_closed for chat %d to user %d (initiator: %d)\n", chatID, otherUserID, claims.UserID)
-			ctxTimeout, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-			select {
-			case s.broadcast <- wsEvent:
-				fmt.Printf("[Chat] ✓ chat_closed event queued for user %d\n", otherUserID)
-			case <-ctxTimeout.Done():
-				fmt.Printf("[Chat] ERROR: chat_closed broadcast timeout for user %d\n", otherUserID)
-			default:
-				fmt.Printf("[Chat] WARNING: chat_closed broadcast channel full for user %d\n", otherUserID)
-			}
-			cancel()
+			s.Broadcast(wsEvent)
 		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if !resp.Success && resp.Error == storage.ErrChatVersionConflict.Error() {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleGetChatDetails returns a chat together with participants' last-opened timestamps
+func (s *Server) handleGetChatDetails(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+
+	if chatID == 0 {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	details, err := s.chatSvc.GetChatDetails(ctx, chatID, claims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(details)
+}
+
+// handleGetChatStats returns message counts, ciphertext volume and key exchange state of a chat
+func (s *Server) handleGetChatStats(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+
+	if chatID == 0 {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	stats, err := s.chatSvc.GetChatStats(ctx, chatID, claims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleSetRetention updates how long a chat keeps its messages
+func (s *Server) handleSetRetention(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+
+	if chatID == 0 {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	var req protocol.RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	policy, err := s.chatSvc.SetRetention(ctx, chatID, claims.UserID, &req)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"retention": policy})
+}
+
+// handleDeleteChat permanently deletes a chat. Requires ?confirm=true because,
+// unlike closing, deletion also wipes the chat's key material and cannot be undone.
+func (s *Server) handleDeleteChat(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+
+	if chatID == 0 {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	confirmed := r.URL.Query().Get("confirm") == "true"
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	resp, err := s.chatSvc.DeleteChat(ctx, chatID, claims.UserID, confirmed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Success && resp.Error == chat.ErrDeleteNotConfirmed.Error() {
+		w.WriteHeader(http.StatusPreconditionRequired)
+	}
 	json.NewEncoder(w).Encode(resp)
 }
 
@@ -875,7 +1266,7 @@ func (s *Server) handleGetMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := s.authSvc.ValidateToken(token)
+	claims, err := s.authSvc.ValidateToken(token)
 	if err != nil {
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
@@ -887,34 +1278,255 @@ func (s *Server) handleGetMessages(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	messages, err := s.messageSvc.GetChatMessages(ctx, chatID, 50, 0)
+	// Keyset pagination: ?before_id=, ?after_id=, ?limit=, ?direction=backward|forward
+	// Metadata filters: ?sender_id=, ?has_file=true|false, ?media=image|video|audio|document,
+	// ?since= and ?until= (unix seconds), ?from_seq= and ?to_seq= (inclusive)
+	// Archived messages: ?include_archived=true
+	query := r.URL.Query()
+	pageReq := &protocol.MessagePageRequest{
+		BeforeID:   parseInt(query.Get("before_id")),
+		AfterID:    parseInt(query.Get("after_id")),
+		Limit:      int(parseInt(query.Get("limit"))),
+		Direction:  query.Get("direction"),
+		SenderID:   parseInt(query.Get("sender_id")),
+		MediaClass: query.Get("media"),
+		Since:      parseInt(query.Get("since")),
+		Until:      parseInt(query.Get("until")),
+		FromSeq:    parseInt(query.Get("from_seq")),
+		ToSeq:      parseInt(query.Get("to_seq")),
+	}
+	if hasFile := query.Get("has_file"); hasFile != "" {
+		b, err := strconv.ParseBool(hasFile)
+		if err != nil {
+			http.Error(w, "Invalid has_file", http.StatusBadRequest)
+			return
+		}
+		pageReq.HasFile = &b
+	}
+	if includeArchived := query.Get("include_archived"); includeArchived != "" {
+		b, err := strconv.ParseBool(includeArchived)
+		if err != nil {
+			http.Error(w, "Invalid include_archived", http.StatusBadRequest)
+			return
+		}
+		pageReq.IncludeArchived = b
+	}
+
+	page, err := s.messageSvc.GetChatMessages(ctx, chatID, claims.UserID, pageReq)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusForError(err))
 		return
 	}
 
-	// Convert []byte ciphertext/iv to hex strings to match client expectations
-	outMessages := make([]map[string]interface{}, 0, len(messages))
-	for _, m := range messages {
-		out := map[string]interface{}{
-			"id":         m.ID,
-			"chat_id":    m.ChatID,
-			"sender_id":  m.SenderID,
-			"ciphertext": hex.EncodeToString(m.Ciphertext),
-			"iv":         hex.EncodeToString(m.IV),
-			"timestamp":  m.Timestamp,
-		}
-		if m.FileName != "" {
-			out["file_name"] = m.FileName
-		}
-		if m.MimeType != "" {
-			out["mime_type"] = m.MimeType
-		}
-		outMessages = append(outMessages, out)
+	outMessages := make([]map[string]interface{}, 0, len(page.Messages))
+	for _, m := range page.Messages {
+		outMessages = append(outMessages, messageJSON(m))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages":    outMessages,
+		"direction":   page.Direction,
+		"next_cursor": page.NextCursor,
+		"has_more":    page.HasMore,
+	})
+}
+
+// messageJSON converts a history message to the JSON shape clients expect,
+// with ciphertext and IV as hex strings
+func messageJSON(m *protocol.EncryptedMessage) map[string]interface{} {
+	out := map[string]interface{}{
+		"id":         m.ID,
+		"chat_id":    m.ChatID,
+		"sender_id":  m.SenderID,
+		"seq":        m.Seq,
+		"ciphertext": hex.EncodeToString(m.Ciphertext),
+		"iv":         hex.EncodeToString(m.IV),
+		"timestamp":  m.Timestamp,
+		"status":     m.Status,
+	}
+	if m.MessageUUID != "" {
+		out["message_uuid"] = m.MessageUUID
+	}
+	if m.DeliveredAt != nil {
+		out["delivered_at"] = *m.DeliveredAt
+	}
+	if m.ReadAt != nil {
+		out["read_at"] = *m.ReadAt
+	}
+	if m.FileName != "" {
+		out["file_name"] = m.FileName
+	}
+	if m.MimeType != "" {
+		out["mime_type"] = m.MimeType
+	}
+	if m.ReplyToMessageID != nil {
+		out["reply_to_message_id"] = *m.ReplyToMessageID
+	}
+	if len(m.Preview) > 0 {
+		out["preview"] = hex.EncodeToString(m.Preview)
+		out["preview_iv"] = hex.EncodeToString(m.PreviewIV)
+	}
+	if len(m.RatchetHeader) > 0 {
+		out["ratchet_header"] = hex.EncodeToString(m.RatchetHeader)
+	}
+	if m.Urgent {
+		out["urgent"] = true
+	}
+	if m.ExpiresAt != nil {
+		out["expires_at"] = *m.ExpiresAt
+	}
+	if len(m.Attachments) > 0 {
+		out["attachments"] = m.Attachments
+	}
+	return out
+}
+
+// handleMarkRead records a read receipt: the caller has read the chat up to message_id
+func (s *Server) handleMarkRead(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+
+	if chatID == 0 {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.MessageID == 0 {
+		http.Error(w, "Missing message_id", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := s.messageSvc.MarkRead(ctx, chatID, claims.UserID, req.MessageID); err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"messages": outMessages})
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReceipt acknowledges all messages of a chat up to up_to_message_id as
+// delivered or read in one call
+func (s *Server) handleReceipt(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+
+	if chatID == 0 {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	var receipt protocol.MessageReceipt
+	if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	receipt.ChatID = chatID
+
+	if receipt.UpToMessageID == 0 {
+		http.Error(w, "Missing up_to_message_id", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := s.messageSvc.AckReceipt(ctx, claims.UserID, &receipt); err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleGetReadMarkers returns each participant's last-read message ID and timestamp
+func (s *Server) handleGetReadMarkers(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+
+	if chatID == 0 {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	markers, err := s.messageSvc.GetReadMarkers(ctx, chatID, claims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"read_markers": markers})
 }
 
 func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
@@ -942,6 +1554,25 @@ func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
 		IV         string `json:"iv"`
 		FileName   string `json:"file_name"`
 		MimeType   string `json:"mime_type"`
+		// Optional ID of the message being replied to
+		ReplyToMessageID *int64 `json:"reply_to_message_id"`
+		// Optional IDs of files uploaded via /api/files
+		AttachmentIDs []int64 `json:"attachment_ids"`
+		// Optional hex-encoded keyed search tokens
+		SearchTokens []string `json:"search_tokens"`
+		// Optional client-generated idempotency key; resending with the same
+		// UUID returns the stored message instead of creating a duplicate
+		MessageUUID string `json:"message_uuid"`
+		// Optional hex-encoded encrypted link preview and its IV
+		Preview   string `json:"preview"`
+		PreviewIV string `json:"preview_iv"`
+		// Optional hex-encoded Double Ratchet header of a message encrypted
+		// under its own ratchet key
+		RatchetHeader string `json:"ratchet_header"`
+		// Urgent messages alert the recipient even if the chat is muted
+		Urgent bool `json:"urgent"`
+		// Optional expiry (unix seconds) after which the message is removed
+		ExpiresAt *int64 `json:"expires_at"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -966,28 +1597,125 @@ func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
 		}
 		ivBytes = b
 	}
+	searchTokens, err := decodeHexList(req.SearchTokens)
+	if err != nil {
+		http.Error(w, "invalid search token hex", http.StatusBadRequest)
+		return
+	}
+	preview, err := hex.DecodeString(req.Preview)
+	if err != nil {
+		http.Error(w, "invalid preview hex", http.StatusBadRequest)
+		return
+	}
+	previewIV, err := hex.DecodeString(req.PreviewIV)
+	if err != nil {
+		http.Error(w, "invalid preview_iv hex", http.StatusBadRequest)
+		return
+	}
+	ratchetHeader, err := hex.DecodeString(req.RatchetHeader)
+	if err != nil {
+		http.Error(w, "invalid ratchet_header hex", http.StatusBadRequest)
+		return
+	}
 
 	msg := &protocol.EncryptedMessage{
-		ChatID:     req.ChatID,
-		SenderID:   claims.UserID,
-		Ciphertext: ctBytes,
-		IV:         ivBytes,
-		Timestamp:  time.Now().Unix(),
-		FileName:   req.FileName,
-		MimeType:   req.MimeType,
+		ChatID:           req.ChatID,
+		SenderID:         claims.UserID,
+		Ciphertext:       ctBytes,
+		IV:               ivBytes,
+		Timestamp:        time.Now().Unix(),
+		FileName:         req.FileName,
+		MimeType:         req.MimeType,
+		ReplyToMessageID: req.ReplyToMessageID,
+		AttachmentIDs:    req.AttachmentIDs,
+		SearchTokens:     searchTokens,
+		MessageUUID:      req.MessageUUID,
+		Preview:          preview,
+		PreviewIV:        previewIV,
+		RatchetHeader:    ratchetHeader,
+		Urgent:           req.Urgent,
+		ExpiresAt:        req.ExpiresAt,
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	if err := s.messageSvc.ProcessMessage(ctx, msg); err != nil {
+	duplicate, err := s.messageSvc.ProcessMessage(ctx, msg)
+	if err != nil {
 		log.Printf("Error processing message: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusForError(err))
 		return
 	}
 
+	resp := map[string]interface{}{
+		"status":     "ok",
+		"message_id": msg.ID,
+		"chat_id":    msg.ChatID,
+		"sender_id":  msg.SenderID,
+		"seq":        msg.Seq,
+		"timestamp":  msg.Timestamp,
+		"duplicate":  duplicate,
+	}
+	if msg.MessageUUID != "" {
+		resp["message_uuid"] = msg.MessageUUID
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(resp)
+}
+
+// statusForError maps service-level sentinel errors to HTTP status codes
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, authz.ErrChatNotFound), errors.Is(err, identity.ErrNoIdentityKey),
+		errors.Is(err, contact.ErrContactNotFound), errors.Is(err, device.ErrDeviceNotFound),
+		errors.Is(err, device.ErrKeyBackupNotFound),
+		errors.Is(err, file.ErrUploadNotFound), errors.Is(err, file.ErrFileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, authz.ErrUserNotInChat), errors.Is(err, authz.ErrForbidden),
+		errors.Is(err, device.ErrRecipientNotInChat),
+		errors.Is(err, message.ErrAttachmentForbidden), errors.Is(err, file.ErrInvalidSignature):
+		return http.StatusForbidden
+	case errors.Is(err, message.ErrMessageNotInChat), errors.Is(err, message.ErrInvalidCursor),
+		errors.Is(err, message.ErrInvalidFilter), errors.Is(err, message.ErrInvalidSearchTokens),
+		errors.Is(err, message.ErrInvalidSyncCursor), errors.Is(err, message.ErrInvalidReceipt),
+		errors.Is(err, message.ErrInvalidMessageUUID), errors.Is(err, message.ErrInvalidPreview),
+		errors.Is(err, message.ErrInvalidExpiry), errors.Is(err, message.ErrInvalidRatchetHeader),
+		errors.Is(err, message.ErrTooManyAttachments),
+		errors.Is(err, chat.ErrNoKeyExchange), errors.Is(err, crypto.ErrInvalidPublicKey),
+		errors.Is(err, crypto.ErrInvalidSignature), errors.Is(err, crypto.ErrInvalidIdentityKey),
+		errors.Is(err, chat.ErrInvalidRetention), errors.Is(err, chat.ErrInvalidKeyMode),
+		errors.Is(err, chat.ErrInvalidDHNonce),
+		errors.Is(err, chat.ErrInvalidNotificationPrefs),
+		errors.Is(err, file.ErrInvalidUpload), errors.Is(err, file.ErrUploadIncomplete),
+		errors.Is(err, file.ErrInvalidThumbnail),
+		errors.Is(err, device.ErrInvalidDevice), errors.Is(err, device.ErrInvalidKeyShare),
+		errors.Is(err, device.ErrInvalidKeyBackup):
+		return http.StatusBadRequest
+	case errors.Is(err, file.ErrOffsetMismatch), errors.Is(err, message.ErrTooManyPins),
+		errors.Is(err, message.ErrDuplicateMessageUUID), errors.Is(err, storage.ErrChatVersionConflict),
+		errors.Is(err, message.ErrStaleRatchet),
+		errors.Is(err, chat.ErrStaleKeyEpoch), errors.Is(err, chat.ErrKeyExchangeIncomplete),
+		errors.Is(err, chat.ErrStaleDHSubmission), errors.Is(err, chat.ErrReplayedDHSubmission),
+		errors.Is(err, chat.ErrSASMismatch), errors.Is(err, device.ErrTooManyDevices),
+		errors.Is(err, message.ErrKeysNotExchanged), errors.Is(err, storage.ErrClientOnlyKeys),
+		errors.Is(err, auth.ErrPurgeAdmin):
+		return http.StatusConflict
+	case errors.Is(err, file.ErrFileTooLarge), errors.Is(err, file.ErrChunkTooLarge),
+		errors.Is(err, message.ErrDraftTooLarge), errors.Is(err, file.ErrThumbnailTooLarge),
+		errors.Is(err, message.ErrPreviewTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, file.ErrInvalidRange):
+		return http.StatusRequestedRangeNotSatisfiable
+	case errors.Is(err, message.ErrUrgentRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, file.ErrMimeTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func parseInt(s string) int64 {
@@ -998,8 +1726,21 @@ func parseInt(s string) int64 {
 	return n
 }
 
-// Broadcast sends a message to all connected clients
+// Broadcast sends a message to all connected clients. With the event relay
+// enabled, WebSocket events also reach the clients of the other instances.
 func (s *Server) Broadcast(msg interface{}) {
+	s.deliver(msg)
+
+	s.mu.RLock()
+	relay := s.relay
+	s.mu.RUnlock()
+	if wsEvent, ok := msg.(*protocol.WebSocketEvent); ok && relay != nil {
+		relay.publish(wsEvent)
+	}
+}
+
+// deliver queues a message for the clients connected to this instance
+func (s *Server) deliver(msg interface{}) {
 	// Try to send broadcast message with small timeout
 	// This ensures messages are delivered even under load
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
@@ -1049,13 +1790,19 @@ func (s *Server) handleDHInit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var sub protocol.DHSubmission
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
 	// Initiate DH key exchange for this chat
-	dhParams, err := s.chatSvc.InitiateDHExchange(ctx, chatID, claims.UserID)
+	dhParams, err := s.chatSvc.InitiateDHExchange(ctx, chatID, claims.UserID, sub)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), statusForError(err))
 		return
 	}
 
@@ -1092,6 +1839,10 @@ func (s *Server) handleDHExchange(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		PublicKey string `json:"public_key"`
+		// Epoch and Signature are required once the user has an identity key
+		Epoch     int64  `json:"epoch"`
+		Signature string `json:"signature"`
+		protocol.DHSubmission
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -1107,8 +1858,8 @@ func (s *Server) handleDHExchange(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	// Complete DH key exchange and derive session key
-	if err := s.chatSvc.CompleteDHExchange(ctx, chatID, claims.UserID, req.PublicKey); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.chatSvc.CompleteDHExchange(ctx, chatID, claims.UserID, req.PublicKey, req.Epoch, req.Signature, req.DHSubmission); err != nil {
+		http.Error(w, err.Error(), statusForError(err))
 		return
 	}
 
@@ -1117,3 +1868,44 @@ func (s *Server) handleDHExchange(w http.ResponseWriter, r *http.Request) {
 		"status": "ok",
 	})
 }
+
+// handleDHStatus returns the key exchange state of a chat
+func (s *Server) handleDHStatus(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+
+	if chatID == 0 {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	status, err := s.chatSvc.GetDHStatus(ctx, chatID, claims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
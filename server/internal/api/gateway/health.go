@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"MinMsgr/server/internal/pkg/encryption/selftest"
+)
+
+// SetSelfTest records the outcome of the crypto self-test. If it failed,
+// the gateway answers every request but /, /readyz and /metrics with 503.
+func (s *Server) SetSelfTest(report selftest.Report) {
+	s.selfTest = &report
+}
+
+// ready reports whether the gateway may serve traffic
+func (s *Server) ready() bool {
+	return s.selfTest != nil && s.selfTest.Passed
+}
+
+// handleReadyz reports readiness with the self-test results, 200 when ready
+// and 503 otherwise
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{
+		"ready": s.ready(),
+	}
+	if s.selfTest != nil {
+		resp["self_test"] = s.selfTest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !s.ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// readinessMiddleware turns traffic away while the gateway is not ready
+func (s *Server) readinessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/", "/readyz", "/metrics":
+		default:
+			if !s.ready() {
+				http.Error(w, "Crypto self-test failed, see /readyz", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
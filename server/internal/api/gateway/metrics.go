@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+
+	"MinMsgr/server/internal/storage"
+)
+
+// handleMetrics exposes background worker and database metrics in the
+// Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	stats := s.messageSvc.RetentionStats()
+
+	dryRun := 0
+	if stats.DryRun {
+		dryRun = 1
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP minmsgr_retention_runs_total Retention purge runs.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_retention_runs_total counter\n")
+	fmt.Fprintf(w, "minmsgr_retention_runs_total %d\n", stats.Runs)
+	fmt.Fprintf(w, "# HELP minmsgr_retention_failures_total Retention purge runs that failed.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_retention_failures_total counter\n")
+	fmt.Fprintf(w, "minmsgr_retention_failures_total %d\n", stats.Failures)
+	fmt.Fprintf(w, "# HELP minmsgr_retention_purged_messages_total Messages purged (or, in dry-run mode, selected) by retention.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_retention_purged_messages_total counter\n")
+	fmt.Fprintf(w, "minmsgr_retention_purged_messages_total{reason=\"age\"} %d\n", stats.PurgedByAge)
+	fmt.Fprintf(w, "minmsgr_retention_purged_messages_total{reason=\"count\"} %d\n", stats.PurgedByCount)
+	fmt.Fprintf(w, "minmsgr_retention_purged_messages_total{reason=\"expiry\"} %d\n", stats.PurgedExpired)
+	fmt.Fprintf(w, "minmsgr_retention_purged_messages_total{reason=\"deleted\"} %d\n", stats.PurgedTombstones)
+	fmt.Fprintf(w, "# HELP minmsgr_retention_purged_users_total Soft-deleted users purged (or, in dry-run mode, selected) by retention.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_retention_purged_users_total counter\n")
+	fmt.Fprintf(w, "minmsgr_retention_purged_users_total %d\n", stats.PurgedUsers)
+	fmt.Fprintf(w, "# HELP minmsgr_retention_archived_messages_total Messages moved (or, in dry-run mode, selected) to the archive by retention.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_retention_archived_messages_total counter\n")
+	fmt.Fprintf(w, "minmsgr_retention_archived_messages_total %d\n", stats.Archived)
+	fmt.Fprintf(w, "# HELP minmsgr_retention_batches_total Delete batches executed by retention.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_retention_batches_total counter\n")
+	fmt.Fprintf(w, "minmsgr_retention_batches_total %d\n", stats.Batches)
+	fmt.Fprintf(w, "# HELP minmsgr_retention_last_run_timestamp_seconds Start of the last retention purge.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_retention_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(w, "minmsgr_retention_last_run_timestamp_seconds %d\n", stats.LastRunAt)
+	fmt.Fprintf(w, "# HELP minmsgr_retention_last_run_duration_seconds Duration of the last retention purge.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_retention_last_run_duration_seconds gauge\n")
+	fmt.Fprintf(w, "minmsgr_retention_last_run_duration_seconds %g\n", stats.LastDuration.Seconds())
+	fmt.Fprintf(w, "# HELP minmsgr_retention_last_run_purged_messages Messages purged by the last retention purge.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_retention_last_run_purged_messages gauge\n")
+	fmt.Fprintf(w, "minmsgr_retention_last_run_purged_messages %d\n", stats.LastPurged)
+	fmt.Fprintf(w, "# HELP minmsgr_retention_dry_run Whether retention runs in dry-run mode.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_retention_dry_run gauge\n")
+	fmt.Fprintf(w, "minmsgr_retention_dry_run %d\n", dryRun)
+
+	fmt.Fprintf(w, "# HELP minmsgr_db_query_duration_seconds Latency of database queries by storage method.\n")
+	fmt.Fprintf(w, "# TYPE minmsgr_db_query_duration_seconds histogram\n")
+	for _, m := range s.chatSvc.GetStore().QueryLatencies() {
+		for i, bound := range storage.LatencyBuckets {
+			fmt.Fprintf(w, "minmsgr_db_query_duration_seconds_bucket{method=%q,le=\"%g\"} %d\n", m.Method, bound.Seconds(), m.Buckets[i])
+		}
+		fmt.Fprintf(w, "minmsgr_db_query_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", m.Method, m.Count)
+		fmt.Fprintf(w, "minmsgr_db_query_duration_seconds_sum{method=%q} %g\n", m.Method, m.Sum.Seconds())
+		fmt.Fprintf(w, "minmsgr_db_query_duration_seconds_count{method=%q} %d\n", m.Method, m.Count)
+	}
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// handleSearchMessages finds messages by client-computed search tokens.
+// ?tokens= is a comma-separated list of hex tokens that must all match;
+// ?before_id= and ?limit= page through older results.
+func (s *Server) handleSearchMessages(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+
+	if chatID == 0 {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("tokens") == "" {
+		http.Error(w, "Missing tokens", http.StatusBadRequest)
+		return
+	}
+	tokens, err := decodeHexList(strings.Split(query.Get("tokens"), ","))
+	if err != nil {
+		http.Error(w, "invalid search token hex", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.messageSvc.SearchMessages(ctx, chatID, claims.UserID, tokens, parseInt(query.Get("before_id")), int(parseInt(query.Get("limit"))))
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleIndexMessage replaces the search tokens of an existing message
+func (s *Server) handleIndexMessage(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatID := parseInt(vars["chatID"])
+	messageID := parseInt(vars["messageID"])
+
+	if chatID == 0 || messageID == 0 {
+		http.Error(w, "Invalid chat or message ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Tokens []string `json:"tokens"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	tokens, err := decodeHexList(req.Tokens)
+	if err != nil {
+		http.Error(w, "invalid search token hex", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := s.messageSvc.IndexMessage(ctx, chatID, claims.UserID, messageID, tokens); err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message_id": messageID, "token_count": len(tokens)})
+}
+
+// decodeHexList decodes a list of hex strings
+func decodeHexList(values []string) ([][]byte, error) {
+	decoded := make([][]byte, 0, len(values))
+	for _, v := range values {
+		b, err := hex.DecodeString(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, b)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// handleSync returns all messages, chat changes and contact changes of the
+// account since ?since=<cursor> (empty for a full sync)
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		return
+	}
+
+	token := extractToken(authHeader)
+	if token == "" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := s.authSvc.ValidateToken(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	result, err := s.messageSvc.Sync(ctx, claims.UserID, r.URL.Query().Get("since"))
+	if err != nil {
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
+
+	messages := make([]map[string]interface{}, 0, len(result.Messages))
+	for _, m := range result.Messages {
+		messages = append(messages, messageJSON(m))
+	}
+
+	chats := make([]map[string]interface{}, 0, len(result.Chats))
+	for _, c := range result.Chats {
+		chat := map[string]interface{}{
+			"id":               c.ID,
+			"user1_id":         c.User1ID,
+			"user2_id":         c.User2ID,
+			"chat_type":        c.ChatType,
+			"algorithm":        c.Algorithm,
+			"mode":             c.Mode,
+			"padding":          c.Padding,
+			"status":           c.Status,
+			"created_at":       c.CreatedAt,
+			"last_activity_at": c.LastActivityAt,
+			"last_seq":         c.LastSeq,
+		}
+		if c.ClosedAt != nil {
+			chat["closed_at"] = *c.ClosedAt
+		}
+		chats = append(chats, chat)
+	}
+
+	contacts := make([]map[string]interface{}, 0, len(result.Contacts))
+	for _, c := range result.Contacts {
+		contacts = append(contacts, map[string]interface{}{
+			"id":           c.ID,
+			"user1_id":     c.User1ID,
+			"user2_id":     c.User2ID,
+			"requester_id": c.RequesterID,
+			"status":       c.Status,
+			"created_at":   c.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages":    messages,
+		"chats":       chats,
+		"contacts":    contacts,
+		"chat_ids":    result.ChatIDs,
+		"contact_ids": result.ContactIDs,
+		"cursor":      result.Cursor,
+		"has_more":    result.HasMore,
+	})
+}
@@ -0,0 +1,139 @@
+// Package authz centralizes chat access decisions so that services and HTTP
+// handlers apply the same participant and contact-state rules.
+package authz
+
+import (
+	"context"
+	"errors"
+
+	"MinMsgr/server/internal/storage"
+)
+
+var (
+	ErrChatNotFound  = errors.New("chat not found")
+	ErrUserNotInChat = errors.New("user not in chat")
+	ErrForbidden     = errors.New("operation not permitted in this chat")
+)
+
+// Store defines the persistence interface needed for access decisions
+type Store interface {
+	GetChat(ctx context.Context, chatID int64) (*storage.Chat, error)
+	GetContact(ctx context.Context, userID1, userID2 int64) (*storage.Contact, error)
+}
+
+// Role describes how a user relates to a chat
+type Role string
+
+const (
+	RoleNone        Role = ""
+	RoleParticipant Role = "participant"
+)
+
+// Permission is a bit set of operations a user may perform in a chat
+type Permission int
+
+const (
+	// PermRead allows reading history, read markers and chat details
+	PermRead Permission = 1 << iota
+	// PermWrite allows sending messages
+	PermWrite
+	// PermExchangeKeys allows submitting DH public keys
+	PermExchangeKeys
+	// PermManage allows closing, deleting and configuring the chat
+	PermManage
+)
+
+// Access is the result of an access check for one user and one chat
+type Access struct {
+	Chat        *storage.Chat
+	Role        Role
+	Permissions Permission
+	// OtherUserID is the other participant of the chat
+	OtherUserID int64
+	// ContactStatus is the status of the contact relationship between the
+	// participants ("" if the relationship no longer exists)
+	ContactStatus string
+}
+
+// Can reports whether the access grants all of the given permissions
+func (a *Access) Can(perm Permission) bool {
+	return a.Permissions&perm == perm
+}
+
+// Checker evaluates chat access against the store
+type Checker struct {
+	store Store
+}
+
+// New creates a new access checker
+func New(store Store) *Checker {
+	return &Checker{store: store}
+}
+
+// ChatAccess loads the chat and returns the user's role and permissions in it.
+// It returns ErrChatNotFound if the chat doesn't exist and ErrUserNotInChat if
+// the user is not one of its participants.
+//
+// Participants can always read and manage their chat. Sending messages and
+// exchanging keys additionally require the chat to be active and the two users
+// to be accepted contacts, so closed chats and chats whose contact relationship
+// was removed, is pending again, or is blocked become read-only. Self chats
+// ("Saved Messages") are writable while active and never exchange keys.
+func (c *Checker) ChatAccess(ctx context.Context, userID, chatID int64) (*Access, error) {
+	chat, err := c.store.GetChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if chat == nil {
+		return nil, ErrChatNotFound
+	}
+	if chat.User1ID != userID && chat.User2ID != userID {
+		return nil, ErrUserNotInChat
+	}
+
+	access := &Access{
+		Chat:        chat,
+		Role:        RoleParticipant,
+		Permissions: PermRead | PermManage,
+		OtherUserID: chat.User2ID,
+	}
+	if chat.User1ID != userID {
+		access.OtherUserID = chat.User1ID
+	}
+
+	// Saved Messages has no peer: there is no contact relationship to check
+	// and no key exchange, only the chat status matters
+	if chat.ChatType == "self" {
+		if chat.Status == "active" {
+			access.Permissions |= PermWrite
+		}
+		return access, nil
+	}
+
+	contact, err := c.store.GetContact(ctx, chat.User1ID, chat.User2ID)
+	if err != nil {
+		return nil, err
+	}
+	if contact != nil {
+		access.ContactStatus = contact.Status
+	}
+
+	if chat.Status == "active" && access.ContactStatus == "accepted" {
+		access.Permissions |= PermWrite | PermExchangeKeys
+	}
+
+	return access, nil
+}
+
+// Require is like ChatAccess but also returns ErrForbidden if the user lacks
+// any of the given permissions
+func (c *Checker) Require(ctx context.Context, userID, chatID int64, perm Permission) (*Access, error) {
+	access, err := c.ChatAccess(ctx, userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !access.Can(perm) {
+		return access, ErrForbidden
+	}
+	return access, nil
+}
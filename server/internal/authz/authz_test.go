@@ -0,0 +1,127 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"MinMsgr/server/internal/storage"
+)
+
+// fakeStore is an in-memory Store for access checks
+type fakeStore struct {
+	chats    map[int64]*storage.Chat
+	contacts map[[2]int64]*storage.Contact
+}
+
+func (f *fakeStore) GetChat(ctx context.Context, chatID int64) (*storage.Chat, error) {
+	return f.chats[chatID], nil
+}
+
+func (f *fakeStore) GetContact(ctx context.Context, userID1, userID2 int64) (*storage.Contact, error) {
+	if userID1 > userID2 {
+		userID1, userID2 = userID2, userID1
+	}
+	return f.contacts[[2]int64{userID1, userID2}], nil
+}
+
+func newFakeStore(chatStatus, contactStatus string) *fakeStore {
+	store := &fakeStore{
+		chats: map[int64]*storage.Chat{
+			10: {ID: 10, User1ID: 1, User2ID: 2, Status: chatStatus},
+		},
+		contacts: map[[2]int64]*storage.Contact{},
+	}
+	if contactStatus != "" {
+		store.contacts[[2]int64{1, 2}] = &storage.Contact{User1ID: 1, User2ID: 2, Status: contactStatus}
+	}
+	return store
+}
+
+func TestChatAccessPermissions(t *testing.T) {
+	tests := []struct {
+		name          string
+		chatStatus    string
+		contactStatus string
+		want          Permission
+	}{
+		{"active chat with accepted contact", "active", "accepted", PermRead | PermWrite | PermExchangeKeys | PermManage},
+		{"closed chat", "closed", "accepted", PermRead | PermManage},
+		{"pending contact", "active", "pending", PermRead | PermManage},
+		{"blocked contact", "active", "blocked", PermRead | PermManage},
+		{"removed contact", "active", "", PermRead | PermManage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := New(newFakeStore(tt.chatStatus, tt.contactStatus))
+
+			access, err := checker.ChatAccess(context.Background(), 1, 10)
+			if err != nil {
+				t.Fatalf("ChatAccess failed: %v", err)
+			}
+			if access.Role != RoleParticipant {
+				t.Fatalf("expected role %q, got %q", RoleParticipant, access.Role)
+			}
+			if access.Permissions != tt.want {
+				t.Fatalf("expected permissions %04b, got %04b", tt.want, access.Permissions)
+			}
+			if access.OtherUserID != 2 {
+				t.Fatalf("expected other user 2, got %d", access.OtherUserID)
+			}
+		})
+	}
+}
+
+func TestChatAccessRejectsNonParticipant(t *testing.T) {
+	checker := New(newFakeStore("active", "accepted"))
+
+	if _, err := checker.ChatAccess(context.Background(), 3, 10); !errors.Is(err, ErrUserNotInChat) {
+		t.Fatalf("expected ErrUserNotInChat, got %v", err)
+	}
+}
+
+func TestChatAccessMissingChat(t *testing.T) {
+	checker := New(newFakeStore("active", "accepted"))
+
+	if _, err := checker.ChatAccess(context.Background(), 1, 99); !errors.Is(err, ErrChatNotFound) {
+		t.Fatalf("expected ErrChatNotFound, got %v", err)
+	}
+}
+
+func TestChatAccessSelfChat(t *testing.T) {
+	store := newFakeStore("active", "")
+	store.chats[20] = &storage.Chat{ID: 20, User1ID: 1, User2ID: 1, ChatType: "self", Status: "active"}
+	checker := New(store)
+
+	access, err := checker.ChatAccess(context.Background(), 1, 20)
+	if err != nil {
+		t.Fatalf("ChatAccess failed: %v", err)
+	}
+	if want := PermRead | PermWrite | PermManage; access.Permissions != want {
+		t.Fatalf("expected permissions %04b, got %04b", want, access.Permissions)
+	}
+	if access.OtherUserID != 1 {
+		t.Fatalf("expected self chat peer to be the user, got %d", access.OtherUserID)
+	}
+
+	if _, err := checker.ChatAccess(context.Background(), 2, 20); !errors.Is(err, ErrUserNotInChat) {
+		t.Fatalf("expected ErrUserNotInChat for another user, got %v", err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	checker := New(newFakeStore("closed", "accepted"))
+
+	if _, err := checker.Require(context.Background(), 2, 10, PermRead); err != nil {
+		t.Fatalf("reading a closed chat should be allowed: %v", err)
+	}
+
+	access, err := checker.Require(context.Background(), 2, 10, PermWrite)
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden when writing to a closed chat, got %v", err)
+	}
+	if access == nil || access.OtherUserID != 1 {
+		t.Fatalf("expected access details alongside ErrForbidden")
+	}
+}
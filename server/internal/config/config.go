@@ -11,24 +11,51 @@ import (
 type Config struct {
 	Server   ServerConfig
 	Database DatabaseConfig
-	JWT      JWTConfig
-	Kafka    KafkaConfig
+	JWT       JWTConfig
+	Kafka     KafkaConfig
+	Retention RetentionConfig
+	Files     FilesConfig
+	Crypto    CryptoConfig
 }
 
 // ServerConfig holds server configuration
 type ServerConfig struct {
 	Port int
 	Host string
+	// AdminUserIDs are the users who may use the admin endpoints
+	AdminUserIDs []int64
+	// EventRelay shares WebSocket events between gateway instances through
+	// Postgres LISTEN/NOTIFY
+	EventRelay bool
 }
 
-// DatabaseConfig holds database configuration
+// DatabaseConfig holds database configuration. Driver is "postgres",
+// "mysql" or "sqlite"; Path is only used by SQLite.
 type DatabaseConfig struct {
+	Driver   string
+	Path     string
 	Host     string
 	Port     int
 	User     string
 	Password string
 	Database string
 	SSLMode  string
+	// ReplicaHosts are read replicas ("host" or "host:port") sharing the
+	// credentials of the primary
+	ReplicaHosts []string
+	// MessageShards are the message shard databases ("host" or "host:port"),
+	// in shard order, sharing the credentials and database name of the primary
+	MessageShards []string
+
+	// Connection pool; 0 max open connections means unlimited and 0 max
+	// lifetime keeps connections forever
+	MaxOpenConns           int
+	MaxIdleConns           int
+	ConnMaxLifetimeSeconds int
+	PingTimeoutSeconds     int
+
+	// Queries slower than this are logged (parameters redacted); 0 disables
+	SlowQueryMillis int
 }
 
 // JWTConfig holds JWT configuration
@@ -36,6 +63,63 @@ type JWTConfig struct {
 	Secret string
 }
 
+// RetentionConfig holds message retention configuration
+type RetentionConfig struct {
+	// PurgeIntervalSeconds is how often retention policies are enforced
+	PurgeIntervalSeconds int
+	// Server-wide limits applied on top of per-chat policies; 0 disables them
+	MaxAgeDays         int
+	MaxMessagesPerChat int
+	// TombstoneDays is how long soft-deleted messages and users are kept
+	// before they are purged; 0 keeps them (e.g. for a compliance hold)
+	TombstoneDays int
+	// ArchiveAfterDays is the age at which messages move to the
+	// messages_archive table; 0 turns archiving off
+	ArchiveAfterDays int
+	// PurgeBatchSize is the number of messages deleted per statement
+	PurgeBatchSize int
+	// DryRun only counts the messages that would be purged
+	DryRun bool
+}
+
+// FilesConfig holds attachment storage configuration
+type FilesConfig struct {
+	// Driver selects the blob store: "filesystem" or "s3"
+	Driver string
+	Root   string
+
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+
+	MaxFileSize  int64
+	MaxChunkSize int64
+	// AllowedMimeTypes lists accepted MIME types; entries ending in "/" match a family
+	AllowedMimeTypes []string
+
+	// Cleanup of abandoned uploads and unattached files
+	JanitorIntervalSeconds int
+	UploadTTLSeconds       int
+	OrphanTTLSeconds       int
+
+	// Signed download URLs; the JWT secret is used when URLSecret is empty
+	URLSecret     string
+	URLTTLSeconds int
+}
+
+// CryptoConfig holds key exchange configuration
+type CryptoConfig struct {
+	// DHGroup is the RFC 7919 group ("ffdhe2048", "ffdhe3072" or
+	// "ffdhe4096") that global DH parameters are made from and that chats
+	// use by default. Parameters already stored are kept.
+	DHGroup string
+	// ClientOnlyKeys guarantees that no session key material is persisted
+	// server-side: every chat is moved to client-only key mode
+	ClientOnlyKeys bool
+}
+
 // KafkaConfig holds Kafka configuration
 type KafkaConfig struct {
 	Brokers []string
@@ -43,18 +127,34 @@ type KafkaConfig struct {
 
 // Load loads configuration from environment variables
 func Load() *Config {
+	dbDriver := getEnv("DB_DRIVER", "postgres")
 	return &Config{
 		Server: ServerConfig{
 			Host: getEnv("SERVER_HOST", "0.0.0.0"),
 			Port: getEnvInt("SERVER_PORT", 8080),
+
+			AdminUserIDs: splitIDList(getEnv("ADMIN_USER_IDS", "")),
+			EventRelay:   getEnvBool("EVENTS_LISTEN_NOTIFY", false),
 		},
 		Database: DatabaseConfig{
+			Driver:   dbDriver,
+			Path:     getEnv("DB_PATH", "./data/minmsgr.db"),
 			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnvInt("DB_PORT", 5432),
+			Port:     getEnvInt("DB_PORT", defaultDBPort(dbDriver)),
 			User:     getEnv("DB_USER", "postgres"),
 			Password: getEnv("DB_PASSWORD", "postgres"),
 			Database: getEnv("DB_NAME", "minmsgr"),
 			SSLMode:  getEnv("DB_SSLMODE", "disable"),
+
+			ReplicaHosts:  splitList(getEnv("DB_REPLICA_HOSTS", "")),
+			MessageShards: splitList(getEnv("DB_MESSAGE_SHARDS", "")),
+
+			MaxOpenConns:           getEnvInt("DB_MAX_OPEN_CONNS", 0),
+			MaxIdleConns:           getEnvInt("DB_MAX_IDLE_CONNS", 2),
+			ConnMaxLifetimeSeconds: getEnvInt("DB_CONN_MAX_LIFETIME_SECONDS", 0),
+			PingTimeoutSeconds:     getEnvInt("DB_PING_TIMEOUT_SECONDS", 5),
+
+			SlowQueryMillis: getEnvInt("DB_SLOW_QUERY_MS", 200),
 		},
 		JWT: JWTConfig{
 			Secret: getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
@@ -62,9 +162,47 @@ func Load() *Config {
 		Kafka: KafkaConfig{
 			Brokers: strings.Split(getEnv("KAFKA_BROKERS", "localhost:9092"), ","),
 		},
+		Retention: RetentionConfig{
+			PurgeIntervalSeconds: getEnvInt("RETENTION_PURGE_INTERVAL_SECONDS", 3600),
+			MaxAgeDays:           getEnvInt("RETENTION_MAX_AGE_DAYS", 0),
+			MaxMessagesPerChat:   getEnvInt("RETENTION_MAX_MESSAGES_PER_CHAT", 0),
+			TombstoneDays:        getEnvInt("RETENTION_TOMBSTONE_DAYS", 30),
+			ArchiveAfterDays:     getEnvInt("RETENTION_ARCHIVE_AFTER_DAYS", 0),
+			PurgeBatchSize:       getEnvInt("RETENTION_PURGE_BATCH_SIZE", 1000),
+			DryRun:               getEnvBool("RETENTION_DRY_RUN", false),
+		},
+		Files: FilesConfig{
+			Driver:                 getEnv("FILES_DRIVER", "filesystem"),
+			Root:                   getEnv("FILES_ROOT", "./data/files"),
+			S3Endpoint:             getEnv("FILES_S3_ENDPOINT", ""),
+			S3Region:               getEnv("FILES_S3_REGION", "us-east-1"),
+			S3Bucket:               getEnv("FILES_S3_BUCKET", ""),
+			S3AccessKey:            getEnv("FILES_S3_ACCESS_KEY", ""),
+			S3SecretKey:            getEnv("FILES_S3_SECRET_KEY", ""),
+			MaxFileSize:            int64(getEnvInt("FILES_MAX_SIZE", 50*1024*1024)),
+			MaxChunkSize:           int64(getEnvInt("FILES_MAX_CHUNK_SIZE", 5*1024*1024)),
+			AllowedMimeTypes:       splitList(getEnv("FILES_ALLOWED_MIME_TYPES", "image/,video/,audio/,text/plain,application/pdf,application/zip,application/octet-stream")),
+			JanitorIntervalSeconds: getEnvInt("FILES_JANITOR_INTERVAL_SECONDS", 600),
+			UploadTTLSeconds:       getEnvInt("FILES_UPLOAD_TTL_SECONDS", 24*3600),
+			OrphanTTLSeconds:       getEnvInt("FILES_ORPHAN_TTL_SECONDS", 24*3600),
+			URLSecret:              getEnv("FILES_URL_SECRET", ""),
+			URLTTLSeconds:          getEnvInt("FILES_URL_TTL_SECONDS", 900),
+		},
+		Crypto: CryptoConfig{
+			DHGroup:        getEnv("DH_GROUP", "ffdhe2048"),
+			ClientOnlyKeys: getEnvBool("CLIENT_ONLY_KEYS", false),
+		},
 	}
 }
 
+// defaultDBPort returns the standard port of a database driver
+func defaultDBPort(driver string) int {
+	if driver == "mysql" {
+		return 3306
+	}
+	return 5432
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -83,15 +221,58 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvBool gets a boolean environment variable or returns a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolVal, err := strconv.ParseBool(value); err == nil {
+			return boolVal
+		}
+	}
+	return defaultValue
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitIDList splits a comma-separated list of IDs, skipping entries that
+// are not positive integers
+func splitIDList(value string) []int64 {
+	var ids []int64
+	for _, item := range splitList(value) {
+		if id, err := strconv.ParseInt(item, 10, 64); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
 // String returns a string representation of the config
 func (c *Config) String() string {
+	database := fmt.Sprintf("%s://%s@%s:%d/%s", c.Database.Driver, c.Database.User, c.Database.Host, c.Database.Port, c.Database.Database)
+	if c.Database.Driver == "sqlite" {
+		database = "sqlite://" + c.Database.Path
+	}
+	if len(c.Database.ReplicaHosts) > 0 {
+		database += fmt.Sprintf(" (read replicas: %s)", strings.Join(c.Database.ReplicaHosts, ", "))
+	}
+	if len(c.Database.MessageShards) > 0 {
+		database += fmt.Sprintf(" (message shards: %s)", strings.Join(c.Database.MessageShards, ", "))
+	}
 	return fmt.Sprintf(`
 Server: %s:%d
-Database: postgres://%s@%s:%d/%s
+Database: %s
 JWT Secret: ***
 Kafka Brokers: %v`,
 		c.Server.Host, c.Server.Port,
-		c.Database.User, c.Database.Host, c.Database.Port, c.Database.Database,
+		database,
 		c.Kafka.Brokers,
 	)
 }
\ No newline at end of file
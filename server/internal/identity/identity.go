@@ -0,0 +1,91 @@
+// Package identity checks signatures made with users' Ed25519 identity keys,
+// so that the key exchange, contact verification and anything that later
+// relays keys between servers trust the same keys the same way.
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"MinMsgr/server/internal/pkg/crypto"
+)
+
+// ErrNoIdentityKey is returned when a signature needs the identity key of a
+// user who has not published one
+var ErrNoIdentityKey = errors.New("identity key not published")
+
+// Store defines the persistence interface needed to look up identity keys
+type Store interface {
+	GetIdentityKey(ctx context.Context, userID int64) ([]byte, error)
+}
+
+// Verifier checks signatures against the identity keys in the store
+type Verifier struct {
+	store Store
+}
+
+// New creates a new identity verifier
+func New(store Store) *Verifier {
+	return &Verifier{store: store}
+}
+
+// IdentityKey returns a user's identity key, or nil if they have not
+// published one
+func (v *Verifier) IdentityKey(ctx context.Context, userID int64) ([]byte, error) {
+	return v.store.GetIdentityKey(ctx, userID)
+}
+
+// Verify checks that userID signed statement under sigContext (see
+// crypto.SignStatement) and returns the identity key that verified it
+func (v *Verifier) Verify(ctx context.Context, userID int64, sigContext string, statement, signature []byte) ([]byte, error) {
+	identityKey, err := v.store.GetIdentityKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if identityKey == nil {
+		return nil, ErrNoIdentityKey
+	}
+	if err := crypto.VerifyStatement(identityKey, sigContext, statement, signature); err != nil {
+		return nil, err
+	}
+	return identityKey, nil
+}
+
+// VerifyKeyExchange checks the signature of a public key userID publishes in
+// chat chatID at epoch and returns their identity key. A user who has not
+// published an identity key may only publish unsigned keys; the identity key
+// is then nil.
+func (v *Verifier) VerifyKeyExchange(ctx context.Context, userID, chatID, epoch int64, publicKey, signature []byte) ([]byte, error) {
+	identityKey, err := v.store.GetIdentityKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if identityKey == nil {
+		if len(signature) > 0 {
+			return nil, fmt.Errorf("%w: %v", crypto.ErrInvalidSignature, ErrNoIdentityKey)
+		}
+		return nil, nil
+	}
+	if err := crypto.VerifyPublicKey(identityKey, chatID, epoch, publicKey, signature); err != nil {
+		return nil, err
+	}
+	return identityKey, nil
+}
+
+// VerifyContact checks userID's signature over the current identity key of
+// contact contactID (see crypto.SignContact) and returns that key. Both users
+// need an identity key.
+func (v *Verifier) VerifyContact(ctx context.Context, userID, contactID int64, signature []byte) ([]byte, error) {
+	contactKey, err := v.store.GetIdentityKey(ctx, contactID)
+	if err != nil {
+		return nil, err
+	}
+	if contactKey == nil {
+		return nil, ErrNoIdentityKey
+	}
+	if _, err := v.Verify(ctx, userID, crypto.ContextContact, crypto.ContactStatement(userID, contactID, contactKey), signature); err != nil {
+		return nil, err
+	}
+	return contactKey, nil
+}
@@ -0,0 +1,77 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/crypto"
+)
+
+// fakeStore is an in-memory Store of identity keys
+type fakeStore map[int64][]byte
+
+func (f fakeStore) GetIdentityKey(ctx context.Context, userID int64) ([]byte, error) {
+	return f[userID], nil
+}
+
+func TestVerifyKeyExchange(t *testing.T) {
+	identityKey, identity, err := crypto.GenerateIdentityKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New(fakeStore{1: identityKey})
+	ctx := context.Background()
+	publicKey := []byte("ephemeral public key")
+
+	got, err := v.VerifyKeyExchange(ctx, 1, 10, 5, publicKey, crypto.SignPublicKey(identity, 10, 5, publicKey))
+	if err != nil || string(got) != string(identityKey) {
+		t.Fatalf("valid signature: key %x, err %v", got, err)
+	}
+	if _, err := v.VerifyKeyExchange(ctx, 1, 10, 5, publicKey, nil); !errors.Is(err, crypto.ErrInvalidSignature) {
+		t.Errorf("unsigned key of a user with an identity key: err = %v", err)
+	}
+
+	// Users without an identity key publish unsigned keys only
+	if got, err := v.VerifyKeyExchange(ctx, 2, 10, 0, publicKey, nil); got != nil || err != nil {
+		t.Errorf("unsigned key: key %x, err %v", got, err)
+	}
+	if _, err := v.VerifyKeyExchange(ctx, 2, 10, 0, publicKey, []byte("signature")); !errors.Is(err, crypto.ErrInvalidSignature) {
+		t.Errorf("signed key without identity key: err = %v", err)
+	}
+}
+
+func TestVerifyContact(t *testing.T) {
+	userKey, user, err := crypto.GenerateIdentityKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contactKey, _, err := crypto.GenerateIdentityKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := fakeStore{1: userKey, 2: contactKey}
+	v := New(store)
+	ctx := context.Background()
+	signature := crypto.SignContact(user, 1, 2, contactKey)
+
+	got, err := v.VerifyContact(ctx, 1, 2, signature)
+	if err != nil || string(got) != string(contactKey) {
+		t.Fatalf("valid signature: key %x, err %v", got, err)
+	}
+
+	// A new identity key of the contact voids the signature
+	newKey, _, _ := crypto.GenerateIdentityKey()
+	store[2] = newKey
+	if _, err := v.VerifyContact(ctx, 1, 2, signature); !errors.Is(err, crypto.ErrInvalidSignature) {
+		t.Errorf("signature over the old key: err = %v", err)
+	}
+
+	delete(store, 2)
+	if _, err := v.VerifyContact(ctx, 1, 2, signature); !errors.Is(err, ErrNoIdentityKey) {
+		t.Errorf("contact without identity key: err = %v", err)
+	}
+	if _, err := v.VerifyContact(ctx, 3, 1, signature); !errors.Is(err, ErrNoIdentityKey) {
+		t.Errorf("user without identity key: err = %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFilesystemPutGetDelete(t *testing.T) {
+	store, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystem failed: %v", err)
+	}
+	ctx := context.Background()
+	data := []byte("encrypted attachment bytes")
+
+	if err := store.Put(ctx, "uploads/abc/0", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	body, err := store.Get(ctx, "uploads/abc/0")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("reading blob failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("expected %q, got %q", data, got)
+	}
+
+	if err := store.Delete(ctx, "uploads/abc/0"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Get(ctx, "uploads/abc/0"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.Delete(ctx, "uploads/abc/0"); err != nil {
+		t.Fatalf("deleting a missing blob should succeed, got %v", err)
+	}
+}
+
+func TestFilesystemPutShortBody(t *testing.T) {
+	store, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystem failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "files/short", bytes.NewReader([]byte("abc")), 10); err == nil {
+		t.Fatalf("expected an error when the body is shorter than the declared size")
+	}
+	if _, err := store.Get(ctx, "files/short"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a failed Put must not leave a blob behind, got %v", err)
+	}
+}
+
+func TestValidKey(t *testing.T) {
+	for _, key := range []string{"files/abc", "uploads/abc/0"} {
+		if err := validKey(key); err != nil {
+			t.Errorf("expected %q to be valid: %v", key, err)
+		}
+	}
+	for _, key := range []string{"", "/etc/passwd", "files/../../etc", "files//x", "files\\x", "."} {
+		if err := validKey(key); err == nil {
+			t.Errorf("expected %q to be rejected", key)
+		}
+	}
+}
+
+func TestFilesystemGetRange(t *testing.T) {
+	store, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystem failed: %v", err)
+	}
+	ctx := context.Background()
+	data := []byte("0123456789")
+
+	if err := store.Put(ctx, "files/range", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	body, err := store.GetRange(ctx, "files/range", 3, 4)
+	if err != nil {
+		t.Fatalf("GetRange failed: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("reading range failed: %v", err)
+	}
+	if string(got) != "3456" {
+		t.Fatalf("expected %q, got %q", "3456", got)
+	}
+}
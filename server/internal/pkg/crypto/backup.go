@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Key backups. A user's key bundle, the private keys their client holds as
+// it serializes them, is sealed under a backup passphrase that is separate
+// from the login password, so that whoever learns the password (the server
+// included) still cannot open it. The sealed backup is a password-wrapped key
+// (see WrapKey) whose KDF must be Argon2id at no less than the default cost;
+// the server checks that on upload with KeyBackupParams without being able to
+// open the backup.
+
+// ErrWeakKeyBackup is returned for backups not sealed with Argon2id at the
+// default cost or above
+var ErrWeakKeyBackup = errors.New("key backup must be sealed with Argon2id at the default cost or above")
+
+// SealKeyBackup seals a key bundle under passphrase with the default Argon2id
+// parameters and a fresh salt
+func SealKeyBackup(passphrase, bundle []byte) ([]byte, error) {
+	params, err := DefaultKDFParams(KDFArgon2id)
+	if err != nil {
+		return nil, err
+	}
+	return WrapKey(passphrase, bundle, params)
+}
+
+// OpenKeyBackup reverses SealKeyBackup. It returns ErrUnwrapIntegrity for a
+// wrong passphrase and ErrWeakKeyBackup for a backup sealed with weaker
+// parameters than SealKeyBackup uses.
+func OpenKeyBackup(passphrase, backup []byte) ([]byte, error) {
+	if _, err := KeyBackupParams(backup); err != nil {
+		return nil, err
+	}
+	return UnwrapKey(passphrase, backup)
+}
+
+// KeyBackupParams returns the KDF parameters of a sealed key backup after
+// checking that they are Argon2id at the default cost or above
+func KeyBackupParams(backup []byte) (KDFParams, error) {
+	params, err := WrappedKeyParams(backup)
+	if err != nil {
+		return KDFParams{}, err
+	}
+	floor, err := DefaultKDFParams(KDFArgon2id)
+	if err != nil {
+		return KDFParams{}, err
+	}
+	if params.Algorithm != KDFArgon2id {
+		return KDFParams{}, fmt.Errorf("%w: sealed with %s", ErrWeakKeyBackup, params.Algorithm)
+	}
+	if params.Time < floor.Time || params.Memory < floor.Memory || params.KeyLen < floor.KeyLen || len(params.Salt) < KDFSaltSize {
+		return KDFParams{}, fmt.Errorf("%w: %s", ErrWeakKeyBackup, params.Encode())
+	}
+	return params, nil
+}
@@ -0,0 +1,62 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKeyBackupRoundTrip(t *testing.T) {
+	bundle := []byte(`{"dh_private_key":"00112233","identity_key_pair:1":"{}"}`)
+	backup, err := SealKeyBackup([]byte("correct horse battery staple"), bundle)
+	if err != nil {
+		t.Fatalf("SealKeyBackup failed: %v", err)
+	}
+
+	params, err := KeyBackupParams(backup)
+	if err != nil {
+		t.Fatalf("KeyBackupParams failed: %v", err)
+	}
+	if params.Algorithm != KDFArgon2id {
+		t.Fatalf("backup sealed with %s", params.Algorithm)
+	}
+
+	opened, err := OpenKeyBackup([]byte("correct horse battery staple"), backup)
+	if err != nil {
+		t.Fatalf("OpenKeyBackup failed: %v", err)
+	}
+	if !bytes.Equal(opened, bundle) {
+		t.Fatalf("opened %q, expected %q", opened, bundle)
+	}
+
+	if _, err := OpenKeyBackup([]byte("login password"), backup); !errors.Is(err, ErrUnwrapIntegrity) {
+		t.Fatalf("wrong passphrase: expected ErrUnwrapIntegrity, got %v", err)
+	}
+}
+
+func TestKeyBackupRejectsWeakParams(t *testing.T) {
+	pbkdf2, err := DefaultKDFParams(KDFPBKDF2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cheap, err := NewArgon2idParams(1, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, params := range []KDFParams{pbkdf2, cheap} {
+		backup, err := WrapKey([]byte("passphrase"), []byte("bundle"), params)
+		if err != nil {
+			t.Fatalf("WrapKey failed: %v", err)
+		}
+		if _, err := KeyBackupParams(backup); !errors.Is(err, ErrWeakKeyBackup) {
+			t.Fatalf("%s: expected ErrWeakKeyBackup, got %v", params.Encode(), err)
+		}
+		if _, err := OpenKeyBackup([]byte("passphrase"), backup); !errors.Is(err, ErrWeakKeyBackup) {
+			t.Fatalf("%s: OpenKeyBackup expected ErrWeakKeyBackup, got %v", params.Encode(), err)
+		}
+	}
+
+	if _, err := KeyBackupParams([]byte("not a backup")); !errors.Is(err, ErrUnsupportedKeyWrap) {
+		t.Fatalf("expected ErrUnsupportedKeyWrap, got %v", err)
+	}
+}
@@ -14,40 +14,101 @@ type DiffieHellman struct {
 	publicKey *big.Int // Public key (g^a mod p)
 }
 
-// StandardPrimes contains standard large primes for DH
-var StandardPrimes = map[string]string{
-	"2048": "32317006071311007300714876688669951960444102669715484032476718642208077927270919675726183480381655661245343293396374297865145287051385931674764604283831268402492847430639474124377767893424865485276302219601246094119453082952085005768838150682342462881473913110540827237163350510684586298239947671312301751751",
-	"1024": "179769313486231590772930519466302748567603895228499873636181257206420969034871862408894547503885626955961565867780667622669447862645141042050653017362278190337993529260869033400293513264135940736553263747264355074265425584788529331638813917571793533457644547111392391756803498693708657361331230430628711672835",
-}
-
-// NewDiffieHellman creates a new DH instance with a specific prime size (bits)
+// NewDiffieHellman creates a new DH instance with a specific prime size (bits).
+// Sizes of the RFC 7919 groups use that group; others get a fresh safe prime.
 func NewDiffieHellman(primeBits int) (*DiffieHellman, error) {
-	// Use a standard prime for common sizes
-	var primeStr string
-	var ok bool
-	if primeStr, ok = StandardPrimes[fmt.Sprintf("%d", primeBits)]; !ok {
-		// Generate a new safe prime
-		var err error
-		p, err := generateSafePrime(primeBits)
-		if err != nil {
-			return nil, err
+	for _, group := range dhGroups {
+		if group.Bits == primeBits {
+			return NewDiffieHellmanGroup(group), nil
 		}
-		primeStr = p.String()
 	}
 
-	p := new(big.Int)
-	p.SetString(primeStr, 10)
+	// Generate a new safe prime
+	p, err := generateSafePrime(primeBits)
+	if err != nil {
+		return nil, err
+	}
 
 	// Use g = 2 as the generator (commonly used)
-	g := big.NewInt(2)
-
 	return &DiffieHellman{
 		p: p,
-		g: g,
+		g: big.NewInt(2),
+	}, nil
+}
+
+// NewDiffieHellmanGroup creates a new DH instance in a named group
+func NewDiffieHellmanGroup(group DHGroup) *DiffieHellman {
+	return &DiffieHellman{
+		p: new(big.Int).Set(group.P),
+		g: new(big.Int).Set(group.G),
+	}
+}
+
+// NewDiffieHellmanParams creates a DH instance over prime p and generator g,
+// which must pass ValidateDHParameters
+func NewDiffieHellmanParams(p, g []byte) (*DiffieHellman, error) {
+	if err := ValidateDHParameters(p, g); err != nil {
+		return nil, err
+	}
+	return &DiffieHellman{
+		p: new(big.Int).SetBytes(p),
+		g: new(big.Int).SetBytes(g),
 	}, nil
 }
 
-// GeneratePrivateKey generates a random private key
+// DHSharedSecret computes the shared secret of privateKey with the other
+// party's public key in the group of prime p, for clients that only keep the
+// prime with their private key. The secret is left-padded to the length of
+// p, as DeriveChatKeys expects it.
+func DHSharedSecret(p, privateKey, otherPublicKey []byte) ([]byte, error) {
+	if err := checkDHPrime(p); err != nil {
+		return nil, err
+	}
+	dh := &DiffieHellman{p: new(big.Int).SetBytes(p)}
+	if err := dh.setPrivateKey(privateKey); err != nil {
+		return nil, err
+	}
+	defer dh.Close()
+
+	secret, err := dh.ComputeSharedSecret(otherPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(secret)
+	return dh.pad(secret), nil
+}
+
+// checkDHPrime checks that p is the prime of a named group, or a prime of
+// at least MinDHBits bits
+func checkDHPrime(p []byte) error {
+	pInt := new(big.Int).SetBytes(p)
+	for _, group := range dhGroups {
+		if group.P.Cmp(pInt) == 0 {
+			return nil
+		}
+	}
+	if pInt.BitLen() < MinDHBits {
+		return fmt.Errorf("%w: %d-bit prime, at least %d bits needed", ErrInvalidDHParameters, pInt.BitLen(), MinDHBits)
+	}
+	if !pInt.ProbablyPrime(20) {
+		return fmt.Errorf("%w: p is not prime", ErrInvalidDHParameters)
+	}
+	return nil
+}
+
+// setPrivateKey takes a private key in [2, p-2]; the key is copied
+func (dh *DiffieHellman) setPrivateKey(privateKey []byte) error {
+	a := new(big.Int).SetBytes(privateKey)
+	if a.Cmp(big.NewInt(2)) < 0 || a.Cmp(new(big.Int).Sub(dh.p, big.NewInt(2))) > 0 {
+		WipeInt(a)
+		return fmt.Errorf("DH private key out of range")
+	}
+	WipeInt(dh.a)
+	dh.a = a
+	return nil
+}
+
+// GeneratePrivateKey generates a random private key, wiping any previous one
 func (dh *DiffieHellman) GeneratePrivateKey() error {
 	// Generate a random number in range [2, p-2]
 	maxPrivateKey := new(big.Int)
@@ -59,15 +120,45 @@ func (dh *DiffieHellman) GeneratePrivateKey() error {
 	}
 	a.Add(a, big.NewInt(2))
 
+	WipeInt(dh.a)
 	dh.a = a
-	dh.computePublicKey()
-	return nil
+	return dh.computePublicKey()
 }
 
 // computePublicKey computes the public key from the private key
-func (dh *DiffieHellman) computePublicKey() {
-	dh.publicKey = new(big.Int)
-	dh.publicKey.Exp(dh.g, dh.a, dh.p)
+func (dh *DiffieHellman) computePublicKey() error {
+	publicKey, err := dh.exp(dh.g)
+	if err != nil {
+		return err
+	}
+	dh.publicKey = publicKey
+	return nil
+}
+
+// dhBlindingBits is the size of the random multiple of p-1 added to the
+// private exponent on every exponentiation
+const dhBlindingBits = 64
+
+// exp returns base^a mod p with a blinded exponent. big.Int.Exp is not
+// constant-time: its running time follows the bits of the exponent it is
+// given. Raising to a + r(p-1) instead, with a fresh random r whose top bit
+// is set, gives the same result, since base^(p-1) = 1 mod the prime p, but
+// each call works through different exponent bits of about the same length,
+// so timing a series of exponentiations no longer adds up to the bits of a.
+func (dh *DiffieHellman) exp(base *big.Int) (*big.Int, error) {
+	r, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), dhBlindingBits-1))
+	if err != nil {
+		return nil, err
+	}
+	r.SetBit(r, dhBlindingBits-1, 1)
+
+	blinded := new(big.Int).Sub(dh.p, big.NewInt(1))
+	blinded.Mul(blinded, r)
+	blinded.Add(blinded, dh.a)
+	defer WipeInt(blinded)
+	defer WipeInt(r)
+
+	return new(big.Int).Exp(base, blinded, dh.p), nil
 }
 
 // GetPublicKey returns the public key as a byte slice
@@ -78,6 +169,25 @@ func (dh *DiffieHellman) GetPublicKey() []byte {
 	return dh.publicKey.Bytes()
 }
 
+// GetPrivateKey returns the private key left-padded to the length of the
+// prime, for clients that store it
+func (dh *DiffieHellman) GetPrivateKey() []byte {
+	if dh.a == nil {
+		return nil
+	}
+	a := dh.a.Bytes()
+	defer Wipe(a)
+	return dh.pad(a)
+}
+
+// pad left-pads b to the length of the prime, the fixed-size encoding of
+// keys and secrets clients exchange
+func (dh *DiffieHellman) pad(b []byte) []byte {
+	padded := make([]byte, (dh.p.BitLen()+7)/8)
+	copy(padded[len(padded)-len(b):], b)
+	return padded
+}
+
 // GetPrime returns the prime modulus as a byte slice
 func (dh *DiffieHellman) GetPrime() []byte {
 	return dh.p.Bytes()
@@ -93,17 +203,44 @@ func (dh *DiffieHellman) ComputeSharedSecret(otherPublicKeyBytes []byte) ([]byte
 	if dh.a == nil {
 		return nil, fmt.Errorf("private key not generated")
 	}
+	if err := CheckDHPublicKey(dh.p.Bytes(), otherPublicKeyBytes); err != nil {
+		return nil, err
+	}
 
 	otherPublicKey := new(big.Int)
 	otherPublicKey.SetBytes(otherPublicKeyBytes)
 
 	// Compute: (otherPublicKey^a) mod p
-	sharedSecret := new(big.Int)
-	sharedSecret.Exp(otherPublicKey, dh.a, dh.p)
+	sharedSecret, err := dh.exp(otherPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	defer WipeInt(sharedSecret)
 
 	return sharedSecret.Bytes(), nil
 }
 
+// DeriveChatKeys computes the shared secret with the other party and derives
+// the keys of chat chatID from it with HKDF. The secret is left-padded to the
+// length of the prime, as clients encode it.
+func (dh *DiffieHellman) DeriveChatKeys(otherPublicKeyBytes []byte, chatID int64, keySize int) (*ChatKeys, error) {
+	secret, err := dh.ComputeSharedSecret(otherPublicKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(secret)
+	padded := dh.pad(secret)
+	defer Wipe(padded)
+	return DeriveChatKeys(padded, chatID, keySize)
+}
+
+// Close wipes the private key. The public key and the parameters stay
+// usable, but no further secrets can be computed.
+func (dh *DiffieHellman) Close() {
+	WipeInt(dh.a)
+	dh.a = nil
+}
+
 // generateSafePrime generates a safe prime for DH key exchange
 func generateSafePrime(bits int) (*big.Int, error) {
 	for {
@@ -123,7 +260,8 @@ func generateSafePrime(bits int) (*big.Int, error) {
 	}
 }
 
-// SetParameters sets the DH parameters manually (for testing or specific parameters)
+// SetParameters sets the DH parameters manually (for testing or specific
+// parameters). p must be prime, as exponent blinding relies on it.
 func (dh *DiffieHellman) SetParameters(p *big.Int, g *big.Int) {
 	dh.p = p
 	dh.g = g
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestDiffieHellmanBlindedExpMatchesExp(t *testing.T) {
+	for _, group := range DHGroups() {
+		alice, bob := NewDiffieHellmanGroup(group), NewDiffieHellmanGroup(group)
+		if err := alice.GeneratePrivateKey(); err != nil {
+			t.Fatalf("%s: GeneratePrivateKey failed: %v", group.Name, err)
+		}
+		if err := bob.GeneratePrivateKey(); err != nil {
+			t.Fatalf("%s: GeneratePrivateKey failed: %v", group.Name, err)
+		}
+
+		if want := new(big.Int).Exp(group.G, alice.a, group.P); alice.publicKey.Cmp(want) != 0 {
+			t.Errorf("%s: blinded public key differs from g^a mod p", group.Name)
+		}
+
+		secret, err := alice.ComputeSharedSecret(bob.GetPublicKey())
+		if err != nil {
+			t.Fatalf("%s: ComputeSharedSecret failed: %v", group.Name, err)
+		}
+		want := new(big.Int).Exp(bob.publicKey, alice.a, group.P).Bytes()
+		if !bytes.Equal(secret, want) {
+			t.Errorf("%s: blinded shared secret differs from y^a mod p", group.Name)
+		}
+		// A fresh blinding factor every call must not change the result
+		again, err := alice.ComputeSharedSecret(bob.GetPublicKey())
+		if err != nil {
+			t.Fatalf("%s: ComputeSharedSecret failed: %v", group.Name, err)
+		}
+		if !bytes.Equal(again, secret) {
+			t.Errorf("%s: shared secret changed between calls", group.Name)
+		}
+	}
+}
+
+func TestDHSharedSecretFromStoredKey(t *testing.T) {
+	group, _ := LookupDHGroup(DefaultDHGroup)
+	alice, err := NewDiffieHellmanParams(group.P.Bytes(), group.G.Bytes())
+	if err != nil {
+		t.Fatalf("NewDiffieHellmanParams failed: %v", err)
+	}
+	bob := NewDiffieHellmanGroup(group)
+	if err := alice.GeneratePrivateKey(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.GeneratePrivateKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	private := alice.GetPrivateKey()
+	if len(private) != group.Bits/8 {
+		t.Fatalf("private key is %d bytes, expected %d", len(private), group.Bits/8)
+	}
+	secret, err := DHSharedSecret(group.P.Bytes(), private, bob.GetPublicKey())
+	if err != nil {
+		t.Fatalf("DHSharedSecret failed: %v", err)
+	}
+	want, err := bob.ComputeSharedSecret(alice.GetPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != group.Bits/8 || !bytes.Equal(bytes.TrimLeft(secret, "\x00"), want) {
+		t.Fatalf("DHSharedSecret gave %x, expected %x padded to %d bytes", secret, want, group.Bits/8)
+	}
+
+	if _, err := DHSharedSecret(group.P.Bytes(), []byte{1}, bob.GetPublicKey()); err == nil {
+		t.Error("private key 1 accepted")
+	}
+	if _, err := DHSharedSecret(group.P.Bytes(), private, []byte{1}); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("public key 1: expected ErrInvalidPublicKey, got %v", err)
+	}
+	composite := new(big.Int).Add(group.P, big.NewInt(2)).Bytes()
+	if _, err := DHSharedSecret(composite, private, bob.GetPublicKey()); !errors.Is(err, ErrInvalidDHParameters) {
+		t.Errorf("composite p: expected ErrInvalidDHParameters, got %v", err)
+	}
+	if _, err := NewDiffieHellmanParams(group.P.Bytes(), []byte{1}); !errors.Is(err, ErrInvalidDHParameters) {
+		t.Errorf("g = 1: expected ErrInvalidDHParameters, got %v", err)
+	}
+}
+
+// BenchmarkComputeSharedSecret compares the blinded exponentiation with the
+// plain big.Int.Exp it replaced, per group
+func BenchmarkComputeSharedSecret(b *testing.B) {
+	for _, group := range DHGroups() {
+		alice, bob := NewDiffieHellmanGroup(group), NewDiffieHellmanGroup(group)
+		if err := alice.GeneratePrivateKey(); err != nil {
+			b.Fatal(err)
+		}
+		if err := bob.GeneratePrivateKey(); err != nil {
+			b.Fatal(err)
+		}
+		peer := bob.GetPublicKey()
+
+		b.Run(group.Name+"/unblinded", func(b *testing.B) {
+			y := new(big.Int).SetBytes(peer)
+			for i := 0; i < b.N; i++ {
+				new(big.Int).Exp(y, alice.a, alice.p)
+			}
+		})
+		b.Run(group.Name+"/blinded", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := alice.ComputeSharedSecret(peer); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
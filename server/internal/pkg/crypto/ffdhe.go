@@ -0,0 +1,158 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// DHGroup is a finite-field Diffie-Hellman group: a safe prime P and a
+// generator G of the subgroup of prime order (P-1)/2
+type DHGroup struct {
+	Name string
+	Bits int
+	P    *big.Int
+	G    *big.Int
+}
+
+// DefaultDHGroup is the group global DH parameters are made from unless the
+// server is configured with another one
+const DefaultDHGroup = "ffdhe2048"
+
+// MinDHBits is the smallest prime ValidateDHParameters accepts
+const MinDHBits = 2048
+
+// ErrInvalidDHParameters is returned for a prime or generator that does not
+// make a safe DH group
+var ErrInvalidDHParameters = errors.New("invalid DH parameters")
+
+// The primes of the RFC 7919 groups, Appendix A. All use generator 2.
+const (
+	ffdhe2048P = "FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B423861285C97FFFFFFFFFFFFFFFF"
+	ffdhe3072P = "FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B4238611FCFDCDE355B3B6519035B" +
+		"BC34F4DEF99C023861B46FC9D6E6C9077AD91D2691F7F7EE598CB0FAC186D91C" +
+		"AEFE130985139270B4130C93BC437944F4FD4452E2D74DD364F2E21E71F54BFF" +
+		"5CAE82AB9C9DF69EE86D2BC522363A0DABC521979B0DEADA1DBF9A42D5C4484E" +
+		"0ABCD06BFA53DDEF3C1B20EE3FD59D7C25E41D2B66C62E37FFFFFFFFFFFFFFFF"
+	ffdhe4096P = "FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B4238611FCFDCDE355B3B6519035B" +
+		"BC34F4DEF99C023861B46FC9D6E6C9077AD91D2691F7F7EE598CB0FAC186D91C" +
+		"AEFE130985139270B4130C93BC437944F4FD4452E2D74DD364F2E21E71F54BFF" +
+		"5CAE82AB9C9DF69EE86D2BC522363A0DABC521979B0DEADA1DBF9A42D5C4484E" +
+		"0ABCD06BFA53DDEF3C1B20EE3FD59D7C25E41D2B669E1EF16E6F52C3164DF4FB" +
+		"7930E9E4E58857B6AC7D5F42D69F6D187763CF1D5503400487F55BA57E31CC7A" +
+		"7135C886EFB4318AED6A1E012D9E6832A907600A918130C46DC778F971AD0038" +
+		"092999A333CB8B7A1A1DB93D7140003C2A4ECEA9F98D0ACC0A8291CDCEC97DCF" +
+		"8EC9B55A7F88A46B4DB5A851F44182E1C68A007E5E655F6AFFFFFFFFFFFFFFFF"
+)
+
+// dhGroups are the RFC 7919 groups, smallest first
+var dhGroups = []DHGroup{
+	newDHGroup("ffdhe2048", ffdhe2048P),
+	newDHGroup("ffdhe3072", ffdhe3072P),
+	newDHGroup("ffdhe4096", ffdhe4096P),
+}
+
+func newDHGroup(name, primeHex string) DHGroup {
+	p, ok := new(big.Int).SetString(primeHex, 16)
+	if !ok {
+		panic("crypto: bad prime for " + name)
+	}
+	return DHGroup{Name: name, Bits: p.BitLen(), P: p, G: big.NewInt(2)}
+}
+
+// DHGroups returns the named DH groups, smallest first
+func DHGroups() []DHGroup {
+	return append([]DHGroup(nil), dhGroups...)
+}
+
+// LookupDHGroup returns the named DH group
+func LookupDHGroup(name string) (DHGroup, bool) {
+	for _, group := range dhGroups {
+		if group.Name == name {
+			return group, true
+		}
+	}
+	return DHGroup{}, false
+}
+
+// LookupDHGroupBits returns the named DH group with a prime of bits bits
+func LookupDHGroupBits(bits int) (DHGroup, bool) {
+	for _, group := range dhGroups {
+		if group.Bits == bits {
+			return group, true
+		}
+	}
+	return DHGroup{}, false
+}
+
+// IdentifyDHGroup returns the named DH group with prime p and generator g
+func IdentifyDHGroup(p, g []byte) (DHGroup, bool) {
+	pInt, gInt := new(big.Int).SetBytes(p), new(big.Int).SetBytes(g)
+	for _, group := range dhGroups {
+		if group.P.Cmp(pInt) == 0 && group.G.Cmp(gInt) == 0 {
+			return group, true
+		}
+	}
+	return DHGroup{}, false
+}
+
+// ValidateDHParameters checks that p is a safe prime of at least MinDHBits
+// bits and that g generates its subgroup of order (p-1)/2, so public keys
+// cannot leak bits of the private key through a small subgroup. The named
+// groups are accepted without the primality tests.
+func ValidateDHParameters(p, g []byte) error {
+	if _, ok := IdentifyDHGroup(p, g); ok {
+		return nil
+	}
+	pInt, gInt := new(big.Int).SetBytes(p), new(big.Int).SetBytes(g)
+	if pInt.BitLen() < MinDHBits {
+		return fmt.Errorf("%w: %d-bit prime, at least %d bits needed", ErrInvalidDHParameters, pInt.BitLen(), MinDHBits)
+	}
+	if !pInt.ProbablyPrime(20) {
+		return fmt.Errorf("%w: p is not prime", ErrInvalidDHParameters)
+	}
+	q := new(big.Int).Rsh(pInt, 1)
+	if !q.ProbablyPrime(20) {
+		return fmt.Errorf("%w: p is not a safe prime", ErrInvalidDHParameters)
+	}
+	pMinus1 := new(big.Int).Sub(pInt, big.NewInt(1))
+	if gInt.Cmp(big.NewInt(1)) <= 0 || gInt.Cmp(pMinus1) >= 0 {
+		return fmt.Errorf("%w: g is out of range", ErrInvalidDHParameters)
+	}
+	if new(big.Int).Exp(gInt, q, pInt).Cmp(big.NewInt(1)) != 0 {
+		return fmt.Errorf("%w: g does not generate the subgroup of order (p-1)/2", ErrInvalidDHParameters)
+	}
+	return nil
+}
+
+// CheckDHPublicKey checks that a DH public key lies in 1 < y < p-1, as
+// RFC 7919, 5.1 requires of the peer's key
+func CheckDHPublicKey(p, publicKey []byte) error {
+	y := new(big.Int).SetBytes(publicKey)
+	pMinus1 := new(big.Int).Sub(new(big.Int).SetBytes(p), big.NewInt(1))
+	if y.Cmp(big.NewInt(1)) <= 0 || y.Cmp(pMinus1) >= 0 {
+		return fmt.Errorf("%w: DH public key out of range", ErrInvalidPublicKey)
+	}
+	return nil
+}
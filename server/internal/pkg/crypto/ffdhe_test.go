@@ -0,0 +1,118 @@
+package crypto
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// rfc7919Prime computes a group prime the way RFC 7919, Appendix A defines
+// it: p = 2^b - 2^{b-64} + {[2^{b-130} e] + X} * 2^64 - 1
+func rfc7919Prime(bits int, x int64) *big.Int {
+	// [2^{b-130} e] as the sum of 2^{b-130}/n!, with guard bits for the
+	// truncated terms
+	const guard = 64
+	term := new(big.Int).Lsh(big.NewInt(1), uint(bits-130+guard))
+	e := new(big.Int)
+	for n := int64(1); term.Sign() > 0; n++ {
+		e.Add(e, term)
+		term.Quo(term, big.NewInt(n))
+	}
+	e.Rsh(e, guard)
+
+	p := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	p.Sub(p, new(big.Int).Lsh(big.NewInt(1), uint(bits-64)))
+	p.Add(p, new(big.Int).Lsh(e.Add(e, big.NewInt(x)), 64))
+	return p.Sub(p, big.NewInt(1))
+}
+
+func TestDHGroupsMatchRFC7919(t *testing.T) {
+	xs := map[string]int64{"ffdhe2048": 560316, "ffdhe3072": 2625351, "ffdhe4096": 5736041}
+	groups := DHGroups()
+	if len(groups) != len(xs) {
+		t.Fatalf("got %d groups, expected %d", len(groups), len(xs))
+	}
+	for i, group := range groups {
+		x, ok := xs[group.Name]
+		if !ok {
+			t.Fatalf("unexpected group %s", group.Name)
+		}
+		if i > 0 && group.Bits <= groups[i-1].Bits {
+			t.Errorf("groups are not sorted by size: %s after %s", group.Name, groups[i-1].Name)
+		}
+		if group.P.Cmp(rfc7919Prime(group.Bits, x)) != 0 {
+			t.Errorf("%s: prime differs from RFC 7919", group.Name)
+		}
+		if group.G.Cmp(big.NewInt(2)) != 0 {
+			t.Errorf("%s: generator %v, expected 2", group.Name, group.G)
+		}
+		if found, ok := LookupDHGroup(group.Name); !ok || found.P.Cmp(group.P) != 0 {
+			t.Errorf("LookupDHGroup(%q) failed", group.Name)
+		}
+		if found, ok := IdentifyDHGroup(group.P.Bytes(), group.G.Bytes()); !ok || found.Name != group.Name {
+			t.Errorf("IdentifyDHGroup did not find %s", group.Name)
+		}
+		if found, ok := LookupDHGroupBits(group.Bits); !ok || found.Name != group.Name {
+			t.Errorf("LookupDHGroupBits(%d) did not find %s", group.Bits, group.Name)
+		}
+	}
+	if _, ok := LookupDHGroup(DefaultDHGroup); !ok {
+		t.Fatalf("default group %s is not a named group", DefaultDHGroup)
+	}
+	if _, ok := LookupDHGroupBits(1024); ok {
+		t.Error("LookupDHGroupBits found a 1024-bit group")
+	}
+}
+
+func TestValidateDHParameters(t *testing.T) {
+	group, _ := LookupDHGroup("ffdhe2048")
+	p := group.P.Bytes()
+	pMinus2 := new(big.Int).Sub(group.P, big.NewInt(2))
+	notPrime := new(big.Int).Add(group.P, big.NewInt(2))
+	small, _ := new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7EDEE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381FFFFFFFFFFFFFFFF", 16)
+
+	valid := map[string][2][]byte{
+		"ffdhe2048":   {p, group.G.Bytes()},
+		"generator 4": {p, big.NewInt(4).Bytes()},
+		"ffdhe4096":   {dhGroups[2].P.Bytes(), dhGroups[2].G.Bytes()},
+	}
+	for name, params := range valid {
+		if err := ValidateDHParameters(params[0], params[1]); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	invalid := map[string][2][]byte{
+		"1024-bit prime":      {small.Bytes(), []byte{2}},
+		"composite":           {notPrime.Bytes(), []byte{2}},
+		"generator 1":         {p, []byte{1}},
+		"generator p-1":       {p, new(big.Int).Sub(group.P, big.NewInt(1)).Bytes()},
+		"generator outside q": {p, pMinus2.Bytes()},
+	}
+	for name, params := range invalid {
+		if err := ValidateDHParameters(params[0], params[1]); !errors.Is(err, ErrInvalidDHParameters) {
+			t.Errorf("%s: err = %v, expected ErrInvalidDHParameters", name, err)
+		}
+	}
+}
+
+func TestCheckDHPublicKey(t *testing.T) {
+	group, _ := LookupDHGroup("ffdhe2048")
+	p := group.P.Bytes()
+	for _, y := range []*big.Int{big.NewInt(0), big.NewInt(1), new(big.Int).Sub(group.P, big.NewInt(1)), group.P} {
+		if err := CheckDHPublicKey(p, y.Bytes()); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("public key %x accepted", y.Bytes())
+		}
+	}
+	if err := CheckDHPublicKey(p, []byte{2}); err != nil {
+		t.Errorf("public key 2 refused: %v", err)
+	}
+
+	dh := NewDiffieHellmanGroup(group)
+	if err := dh.GeneratePrivateKey(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dh.ComputeSharedSecret([]byte{1}); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Fatalf("shared secret with public key 1: %v", err)
+	}
+}
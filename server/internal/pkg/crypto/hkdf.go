@@ -0,0 +1,121 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Labels passed as HKDF info when deriving the keys of a chat. Each key is
+// expanded with its own label, so they are independent of each other.
+const (
+	LabelMessageKey = "MinMsgr message key"
+	LabelIVSeed     = "MinMsgr iv seed"
+	LabelMACKey     = "MinMsgr mac key"
+)
+
+// IVSeedSize and MACKeySize are the lengths of the derived IV seed and MAC key
+const (
+	IVSeedSize = 16
+	MACKeySize = sha256.Size
+)
+
+// HKDFExtract derives a pseudorandom key from the input keying material
+// (RFC 5869, section 2.2). An empty salt is treated as HashLen zero bytes.
+func HKDFExtract(salt, ikm []byte) []byte {
+	if len(salt) == 0 {
+		salt = make([]byte, sha256.Size)
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+// HKDFExpand expands prk into length bytes of output keying material bound to
+// info (RFC 5869, section 2.3)
+func HKDFExpand(prk, info []byte, length int) ([]byte, error) {
+	if length < 0 || length > 255*sha256.Size {
+		return nil, fmt.Errorf("HKDF output length must be between 0 and %d, got %d", 255*sha256.Size, length)
+	}
+
+	mac := hmac.New(sha256.New, prk)
+	out := make([]byte, 0, length+sha256.Size)
+	var block []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		mac.Reset()
+		mac.Write(block)
+		mac.Write(info)
+		mac.Write([]byte{counter})
+		Wipe(block)
+		block = mac.Sum(block[:0])
+		out = append(out, block...)
+	}
+	Wipe(block)
+	// The tail beyond length is key material too
+	Wipe(out[length:cap(out)])
+	return out[:length], nil
+}
+
+// HKDF runs extract and expand in one go
+func HKDF(ikm, salt, info []byte, length int) ([]byte, error) {
+	return HKDFExpand(HKDFExtract(salt, ikm), info, length)
+}
+
+// ChatKeys holds the keys of a chat derived from its DH shared secret
+type ChatKeys struct {
+	MessageKey []byte // Key of the chat's cipher
+	IVSeed     []byte // Seed for deterministic IVs
+	MACKey     []byte // Key for message authentication
+}
+
+// ChatSalt returns the HKDF salt of a chat: its ID as 8 big-endian bytes.
+// Both participants know it, and it keeps the keys of two chats between the
+// same users apart.
+func ChatSalt(chatID int64) []byte {
+	salt := make([]byte, 8)
+	binary.BigEndian.PutUint64(salt, uint64(chatID))
+	return salt
+}
+
+// DeriveChatKeys derives the keys of a chat from the raw DH shared secret.
+// keySize is the key size of the chat's cipher.
+func DeriveChatKeys(sharedSecret []byte, chatID int64, keySize int) (*ChatKeys, error) {
+	if len(sharedSecret) == 0 {
+		return nil, fmt.Errorf("shared secret must not be empty")
+	}
+	if keySize <= 0 {
+		return nil, fmt.Errorf("key size must be positive, got %d", keySize)
+	}
+
+	prk := HKDFExtract(ChatSalt(chatID), sharedSecret)
+	defer Wipe(prk)
+	return expandChatKeys(prk, keySize)
+}
+
+// expandChatKeys expands the message key, IV seed and MAC key from prk
+func expandChatKeys(prk []byte, keySize int) (*ChatKeys, error) {
+	messageKey, err := HKDFExpand(prk, []byte(LabelMessageKey), keySize)
+	if err != nil {
+		return nil, err
+	}
+	ivSeed, err := HKDFExpand(prk, []byte(LabelIVSeed), IVSeedSize)
+	if err != nil {
+		Wipe(messageKey)
+		return nil, err
+	}
+	macKey, err := HKDFExpand(prk, []byte(LabelMACKey), MACKeySize)
+	if err != nil {
+		Wipe(messageKey)
+		Wipe(ivSeed)
+		return nil, err
+	}
+	return &ChatKeys{MessageKey: messageKey, IVSeed: ivSeed, MACKey: macKey}, nil
+}
+
+// Wipe zeros all the keys
+func (k *ChatKeys) Wipe() {
+	Wipe(k.MessageKey)
+	Wipe(k.IVSeed)
+	Wipe(k.MACKey)
+}
@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func seq(from, to int) []byte {
+	out := make([]byte, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		out = append(out, byte(i))
+	}
+	return out
+}
+
+// HKDF-SHA256 test cases 1-3 from RFC 5869, appendix A
+var hkdfVectors = []struct {
+	name     string
+	ikm      []byte
+	salt     []byte
+	info     []byte
+	length   int
+	prk, okm string
+}{
+	{
+		name:   "basic",
+		ikm:    bytes.Repeat([]byte{0x0b}, 22),
+		salt:   seq(0x00, 0x0c),
+		info:   seq(0xf0, 0xf9),
+		length: 42,
+		prk:    "077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5",
+		okm:    "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+	},
+	{
+		name:   "long inputs",
+		ikm:    seq(0x00, 0x4f),
+		salt:   seq(0x60, 0xaf),
+		info:   seq(0xb0, 0xff),
+		length: 82,
+		prk:    "06a6b88c5853361a06104c9ceb35b45cef760014904671014a193f40c15fc244",
+		okm:    "b11e398dc80327a1c8e7f78c596a49344f012eda2d4efad8a050cc4c19afa97c59045a99cac7827271cb41c65e590e09da3275600c2f09b8367793a9aca3db71cc30c58179ec3e87c14c01d5c1f3434f1d87",
+	},
+	{
+		name:   "empty salt and info",
+		ikm:    bytes.Repeat([]byte{0x0b}, 22),
+		length: 42,
+		prk:    "19ef24a32c717b167f33a91d6f648bdf96596776afdb6377ac434c1c293ccb04",
+		okm:    "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8",
+	},
+}
+
+func TestHKDFVectors(t *testing.T) {
+	for _, v := range hkdfVectors {
+		t.Run(v.name, func(t *testing.T) {
+			prk := HKDFExtract(v.salt, v.ikm)
+			if got := hex.EncodeToString(prk); got != v.prk {
+				t.Fatalf("PRK: expected %s, got %s", v.prk, got)
+			}
+			okm, err := HKDFExpand(prk, v.info, v.length)
+			if err != nil {
+				t.Fatalf("HKDFExpand failed: %v", err)
+			}
+			if got := hex.EncodeToString(okm); got != v.okm {
+				t.Fatalf("OKM: expected %s, got %s", v.okm, got)
+			}
+		})
+	}
+}
+
+func TestHKDFExpandLength(t *testing.T) {
+	prk := HKDFExtract(nil, []byte("secret"))
+	if _, err := HKDFExpand(prk, nil, 255*32); err != nil {
+		t.Fatalf("expected the maximum length to work, got %v", err)
+	}
+	if _, err := HKDFExpand(prk, nil, 255*32+1); err == nil {
+		t.Fatal("expected an error for more than 255 blocks")
+	}
+}
+
+func TestDeriveChatKeys(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 256)
+	keys, err := DeriveChatKeys(secret, 7, 32)
+	if err != nil {
+		t.Fatalf("DeriveChatKeys failed: %v", err)
+	}
+	if len(keys.MessageKey) != 32 || len(keys.IVSeed) != IVSeedSize || len(keys.MACKey) != MACKeySize {
+		t.Fatalf("unexpected key sizes %d/%d/%d", len(keys.MessageKey), len(keys.IVSeed), len(keys.MACKey))
+	}
+	if bytes.Equal(keys.MessageKey, keys.MACKey) || bytes.Equal(keys.MessageKey[:IVSeedSize], keys.IVSeed) {
+		t.Fatal("keys derived with different labels must differ")
+	}
+
+	other, err := DeriveChatKeys(secret, 8, 32)
+	if err != nil {
+		t.Fatalf("DeriveChatKeys failed: %v", err)
+	}
+	if bytes.Equal(keys.MessageKey, other.MessageKey) {
+		t.Fatal("two chats with the same secret must get different keys")
+	}
+}
+
+func TestDiffieHellmanDeriveChatKeys(t *testing.T) {
+	ka, err := NewKeyAgreement(2048)
+	if err != nil {
+		t.Fatalf("NewKeyAgreement failed: %v", err)
+	}
+	if _, _, err := ka.PerformKeyExchange(); err != nil {
+		t.Fatalf("PerformKeyExchange failed: %v", err)
+	}
+
+	keysA, err := ka.PartyA.DeriveChatKeys(ka.PartyB.GetPublicKey(), 1, 16)
+	if err != nil {
+		t.Fatalf("DeriveChatKeys failed: %v", err)
+	}
+	keysB, err := ka.PartyB.DeriveChatKeys(ka.PartyA.GetPublicKey(), 1, 16)
+	if err != nil {
+		t.Fatalf("DeriveChatKeys failed: %v", err)
+	}
+	if !bytes.Equal(keysA.MessageKey, keysB.MessageKey) || !bytes.Equal(keysA.IVSeed, keysB.IVSeed) || !bytes.Equal(keysA.MACKey, keysB.MACKey) {
+		t.Fatal("both parties must derive the same chat keys")
+	}
+}
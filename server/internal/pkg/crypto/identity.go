@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// IdentityKeySize is the length of an Ed25519 identity public key
+const IdentityKeySize = ed25519.PublicKeySize
+
+// Signature contexts. Every kind of statement an identity key signs has its
+// own, so a signature over one can never pass for another.
+const (
+	// ContextKeyExchange signs a DH public key published in a chat
+	ContextKeyExchange = "MinMsgr key exchange v1"
+	// ContextContact signs the identity key a user verified for a contact
+	ContextContact = "MinMsgr contact v1"
+	// ContextFederation is reserved for statements servers relay about
+	// their users' keys
+	ContextFederation = "MinMsgr federation v1"
+)
+
+var (
+	ErrInvalidIdentityKey = errors.New("invalid identity key")
+	ErrInvalidSignature   = errors.New("invalid identity signature")
+)
+
+// GenerateIdentityKey makes a new Ed25519 identity key pair
+func GenerateIdentityKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// CheckIdentityKey checks that key is an Ed25519 public key
+func CheckIdentityKey(key []byte) error {
+	if len(key) != IdentityKeySize {
+		return fmt.Errorf("%w: identity keys are %d bytes, got %d", ErrInvalidIdentityKey, IdentityKeySize, len(key))
+	}
+	return nil
+}
+
+// SignedMessage is what an identity key signs for a statement: the context,
+// a zero byte, then the statement
+func SignedMessage(context string, statement []byte) []byte {
+	msg := make([]byte, 0, len(context)+1+len(statement))
+	msg = append(msg, context...)
+	msg = append(msg, 0)
+	return append(msg, statement...)
+}
+
+// SignStatement signs statement under context with an Ed25519 identity
+// private key
+func SignStatement(identity ed25519.PrivateKey, context string, statement []byte) []byte {
+	return ed25519.Sign(identity, SignedMessage(context, statement))
+}
+
+// VerifyStatement checks a signature over statement under context against an
+// identity key
+func VerifyStatement(identityKey []byte, context string, statement, signature []byte) error {
+	if err := CheckIdentityKey(identityKey); err != nil {
+		return err
+	}
+	if !ed25519.Verify(identityKey, SignedMessage(context, statement), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// KeyExchangeMessage is what a participant signs to vouch for the public key
+// they publish in chat chatID. epoch grows with every key they publish there,
+// so an old signed key cannot be replayed in place of a newer one.
+func KeyExchangeMessage(chatID, epoch int64, publicKey []byte) []byte {
+	return SignedMessage(ContextKeyExchange, keyExchangeStatement(chatID, epoch, publicKey))
+}
+
+func keyExchangeStatement(chatID, epoch int64, publicKey []byte) []byte {
+	statement := make([]byte, 0, 16+len(publicKey))
+	statement = binary.BigEndian.AppendUint64(statement, uint64(chatID))
+	statement = binary.BigEndian.AppendUint64(statement, uint64(epoch))
+	return append(statement, publicKey...)
+}
+
+// SignPublicKey signs a public key published in chat chatID at epoch with an
+// Ed25519 identity private key
+func SignPublicKey(identity ed25519.PrivateKey, chatID, epoch int64, publicKey []byte) []byte {
+	return SignStatement(identity, ContextKeyExchange, keyExchangeStatement(chatID, epoch, publicKey))
+}
+
+// VerifyPublicKey checks the signature of a public key published in chat
+// chatID at epoch against the publisher's identity key
+func VerifyPublicKey(identityKey []byte, chatID, epoch int64, publicKey, signature []byte) error {
+	return VerifyStatement(identityKey, ContextKeyExchange, keyExchangeStatement(chatID, epoch, publicKey), signature)
+}
+
+// ContactStatement is what user userID signs after checking, e.g. by the
+// safety number, that contactIdentityKey belongs to contact contactID
+func ContactStatement(userID, contactID int64, contactIdentityKey []byte) []byte {
+	statement := make([]byte, 0, 16+len(contactIdentityKey))
+	statement = binary.BigEndian.AppendUint64(statement, uint64(userID))
+	statement = binary.BigEndian.AppendUint64(statement, uint64(contactID))
+	return append(statement, contactIdentityKey...)
+}
+
+// SignContact signs that contactIdentityKey is the identity key of contact
+// contactID of user userID
+func SignContact(identity ed25519.PrivateKey, userID, contactID int64, contactIdentityKey []byte) []byte {
+	return SignStatement(identity, ContextContact, ContactStatement(userID, contactID, contactIdentityKey))
+}
+
+// VerifyContact checks user userID's signature over the identity key they
+// verified for contact contactID
+func VerifyContact(identityKey []byte, userID, contactID int64, contactIdentityKey, signature []byte) error {
+	return VerifyStatement(identityKey, ContextContact, ContactStatement(userID, contactID, contactIdentityKey), signature)
+}
@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+func TestSignPublicKey(t *testing.T) {
+	identityKey, identity, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := []byte("ephemeral public key")
+	signature := SignPublicKey(identity, 7, 3, publicKey)
+
+	if err := VerifyPublicKey(identityKey, 7, 3, publicKey, signature); err != nil {
+		t.Fatalf("valid signature refused: %v", err)
+	}
+
+	// The signature covers the chat, the epoch and the key
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	for name, check := range map[string]error{
+		"other chat":     VerifyPublicKey(identityKey, 8, 3, publicKey, signature),
+		"older epoch":    VerifyPublicKey(identityKey, 7, 2, publicKey, signature),
+		"other key":      VerifyPublicKey(identityKey, 7, 3, []byte("substituted key"), signature),
+		"other identity": VerifyPublicKey(otherKey, 7, 3, publicKey, signature),
+		"no signature":   VerifyPublicKey(identityKey, 7, 3, publicKey, nil),
+	} {
+		if !errors.Is(check, ErrInvalidSignature) {
+			t.Errorf("%s: err = %v, expected ErrInvalidSignature", name, check)
+		}
+	}
+
+	if err := VerifyPublicKey(identityKey[:16], 7, 3, publicKey, signature); !errors.Is(err, ErrInvalidIdentityKey) {
+		t.Errorf("short identity key: err = %v", err)
+	}
+}
+
+func TestKeyExchangeMessageIsUnambiguous(t *testing.T) {
+	// Chat and epoch have fixed widths, so they cannot run into the key
+	a := KeyExchangeMessage(1, 0x0102, []byte{3})
+	b := KeyExchangeMessage(1, 0x01, []byte{2, 3})
+	if string(a) == string(b) {
+		t.Fatal("different chat, epoch and key gave the same message")
+	}
+	if len(a) != len(ContextKeyExchange)+1+16+1 {
+		t.Fatalf("message is %d bytes", len(a))
+	}
+}
+
+func TestSignContact(t *testing.T) {
+	identityKey, identity, err := GenerateIdentityKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contactKey, _, _ := GenerateIdentityKey()
+	signature := SignContact(identity, 3, 8, contactKey)
+
+	if err := VerifyContact(identityKey, 3, 8, contactKey, signature); err != nil {
+		t.Fatalf("valid signature refused: %v", err)
+	}
+	otherKey, _, _ := GenerateIdentityKey()
+	for name, check := range map[string]error{
+		"other contact":     VerifyContact(identityKey, 3, 9, contactKey, signature),
+		"other user":        VerifyContact(identityKey, 4, 8, contactKey, signature),
+		"other contact key": VerifyContact(identityKey, 3, 8, otherKey, signature),
+	} {
+		if !errors.Is(check, ErrInvalidSignature) {
+			t.Errorf("%s: err = %v, expected ErrInvalidSignature", name, check)
+		}
+	}
+}
+
+func TestSignatureContextsAreSeparate(t *testing.T) {
+	identityKey, identity, err := GenerateIdentityKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same bytes signed as a key exchange do not verify as a contact
+	statement := ContactStatement(3, 8, []byte("key"))
+	signature := SignStatement(identity, ContextKeyExchange, statement)
+	if err := VerifyStatement(identityKey, ContextContact, statement, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("key exchange signature passed as a contact one: %v", err)
+	}
+	if err := VerifyStatement(identityKey, ContextKeyExchange, statement, signature); err != nil {
+		t.Fatalf("valid signature refused: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Password-based KDFs. The parameters, salt included, are encoded in a
+// PHC-like string stored next to whatever the derived key protects, so the
+// key can be derived again later even after the defaults change:
+//
+//	$pbkdf2-sha256$i=100000,l=32$<salt>
+//	$argon2id$v=19$m=19456,t=2,p=1,l=32$<salt>
+//
+// The salt is unpadded standard base64.
+const (
+	KDFPBKDF2   = "pbkdf2-sha256"
+	KDFArgon2id = "argon2id"
+)
+
+// KDFSaltSize is the length of the random salt of new parameters
+const KDFSaltSize = 16
+
+// ErrInvalidKDFParams is returned for malformed encoded parameters or
+// parameters outside the accepted limits
+var ErrInvalidKDFParams = errors.New("invalid KDF parameters")
+
+// Limits on decoded parameters, so a stored string cannot make the client
+// spin or allocate without bound
+const (
+	maxPBKDF2Iterations = 10_000_000
+	maxArgon2Time       = 64
+	maxArgon2Memory     = 1 << 20 // KiB, 1 GiB
+	maxKDFKeyLen        = 1024
+)
+
+// KDFParams describes one password-based key derivation
+type KDFParams struct {
+	Algorithm string // KDFPBKDF2 or KDFArgon2id
+	Time      uint32 // PBKDF2 iterations or Argon2 passes
+	Memory    uint32 // Argon2 memory in KiB, unused by PBKDF2
+	Threads   uint8  // Argon2 parallelism, unused by PBKDF2
+	KeyLen    uint32 // Length of the derived key in bytes
+	Salt      []byte
+}
+
+// NewPBKDF2Params returns PBKDF2-SHA256 parameters with a fresh random salt
+func NewPBKDF2Params(iterations, keyLen uint32) (KDFParams, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return KDFParams{}, err
+	}
+	params := KDFParams{Algorithm: KDFPBKDF2, Time: iterations, KeyLen: keyLen, Salt: salt}
+	return params, params.validate()
+}
+
+// NewArgon2idParams returns Argon2id parameters with a fresh random salt.
+// memory is in KiB.
+func NewArgon2idParams(time, memory uint32, threads uint8, keyLen uint32) (KDFParams, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return KDFParams{}, err
+	}
+	params := KDFParams{Algorithm: KDFArgon2id, Time: time, Memory: memory, Threads: threads, KeyLen: keyLen, Salt: salt}
+	return params, params.validate()
+}
+
+// DefaultKDFParams returns the recommended parameters for algorithm: 100000
+// PBKDF2 iterations, the count clients have always used, or Argon2id with
+// 19 MiB and two passes (the OWASP minimum, cheap enough for the browser).
+// Both derive 32-byte keys.
+func DefaultKDFParams(algorithm string) (KDFParams, error) {
+	switch algorithm {
+	case KDFPBKDF2:
+		return NewPBKDF2Params(100000, 32)
+	case KDFArgon2id:
+		return NewArgon2idParams(2, 19456, 1, 32)
+	default:
+		return KDFParams{}, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidKDFParams, algorithm)
+	}
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, KDFSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func (p KDFParams) validate() error {
+	if p.KeyLen == 0 || p.KeyLen > maxKDFKeyLen {
+		return fmt.Errorf("%w: key length %d", ErrInvalidKDFParams, p.KeyLen)
+	}
+	if len(p.Salt) == 0 {
+		return fmt.Errorf("%w: empty salt", ErrInvalidKDFParams)
+	}
+	switch p.Algorithm {
+	case KDFPBKDF2:
+		if p.Time == 0 || p.Time > maxPBKDF2Iterations {
+			return fmt.Errorf("%w: %d iterations", ErrInvalidKDFParams, p.Time)
+		}
+	case KDFArgon2id:
+		if p.Time == 0 || p.Time > maxArgon2Time {
+			return fmt.Errorf("%w: time cost %d", ErrInvalidKDFParams, p.Time)
+		}
+		if p.Threads == 0 || p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Memory {
+			return fmt.Errorf("%w: memory %d KiB with %d threads", ErrInvalidKDFParams, p.Memory, p.Threads)
+		}
+	default:
+		return fmt.Errorf("%w: unknown algorithm %q", ErrInvalidKDFParams, p.Algorithm)
+	}
+	return nil
+}
+
+// Encode returns the parameters as a string for ParseKDFParams
+func (p KDFParams) Encode() string {
+	salt := base64.RawStdEncoding.EncodeToString(p.Salt)
+	if p.Algorithm == KDFArgon2id {
+		return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d,l=%d$%s", KDFArgon2id, argon2.Version, p.Memory, p.Time, p.Threads, p.KeyLen, salt)
+	}
+	return fmt.Sprintf("$%s$i=%d,l=%d$%s", p.Algorithm, p.Time, p.KeyLen, salt)
+}
+
+// ParseKDFParams decodes parameters produced by Encode
+func ParseKDFParams(encoded string) (KDFParams, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) < 4 || fields[0] != "" {
+		return KDFParams{}, fmt.Errorf("%w: %q", ErrInvalidKDFParams, encoded)
+	}
+
+	p := KDFParams{Algorithm: fields[1]}
+	var settings string
+	switch {
+	case p.Algorithm == KDFPBKDF2 && len(fields) == 4:
+		settings = fields[2]
+	case p.Algorithm == KDFArgon2id && len(fields) == 5:
+		if fields[2] != fmt.Sprintf("v=%d", argon2.Version) {
+			return KDFParams{}, fmt.Errorf("%w: unsupported Argon2 version %q", ErrInvalidKDFParams, fields[2])
+		}
+		settings = fields[3]
+	default:
+		return KDFParams{}, fmt.Errorf("%w: %q", ErrInvalidKDFParams, encoded)
+	}
+
+	for _, setting := range strings.Split(settings, ",") {
+		name, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return KDFParams{}, fmt.Errorf("%w: setting %q", ErrInvalidKDFParams, setting)
+		}
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return KDFParams{}, fmt.Errorf("%w: setting %q", ErrInvalidKDFParams, setting)
+		}
+		switch {
+		case name == "i" && p.Algorithm == KDFPBKDF2, name == "t" && p.Algorithm == KDFArgon2id:
+			p.Time = uint32(n)
+		case name == "m" && p.Algorithm == KDFArgon2id:
+			p.Memory = uint32(n)
+		case name == "p" && p.Algorithm == KDFArgon2id && n <= 255:
+			p.Threads = uint8(n)
+		case name == "l":
+			p.KeyLen = uint32(n)
+		default:
+			return KDFParams{}, fmt.Errorf("%w: setting %q", ErrInvalidKDFParams, setting)
+		}
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[len(fields)-1])
+	if err != nil {
+		return KDFParams{}, fmt.Errorf("%w: salt: %v", ErrInvalidKDFParams, err)
+	}
+	p.Salt = salt
+	return p, p.validate()
+}
+
+// DeriveKey derives a key from password with params
+func DeriveKey(password []byte, params KDFParams) ([]byte, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	if params.Algorithm == KDFArgon2id {
+		return argon2.IDKey(password, params.Salt, params.Time, params.Memory, params.Threads, params.KeyLen), nil
+	}
+	return pbkdf2.Key(password, params.Salt, int(params.Time), int(params.KeyLen), sha256.New), nil
+}
+
+// DeriveKeyEncoded derives a key from password with encoded parameters
+func DeriveKeyEncoded(password []byte, encoded string) ([]byte, error) {
+	params, err := ParseKDFParams(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return DeriveKey(password, params)
+}
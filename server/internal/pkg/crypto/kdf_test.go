@@ -0,0 +1,110 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// PBKDF2-SHA256 vectors from RFC 7914, section 11, and Argon2id vectors
+// generated with the reference implementation
+var kdfVectors = []struct {
+	name     string
+	password string
+	params   KDFParams
+	key      string
+}{
+	{
+		name:     "pbkdf2 one iteration",
+		password: "passwd",
+		params:   KDFParams{Algorithm: KDFPBKDF2, Time: 1, KeyLen: 64, Salt: []byte("salt")},
+		key:      "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+	},
+	{
+		name:     "pbkdf2 80000 iterations",
+		password: "Password",
+		params:   KDFParams{Algorithm: KDFPBKDF2, Time: 80000, KeyLen: 64, Salt: []byte("NaCl")},
+		key:      "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+	},
+	{
+		name:     "argon2id one pass",
+		password: "password",
+		params:   KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: 64, Threads: 1, KeyLen: 24, Salt: []byte("somesalt")},
+		key:      "655ad15eac652dc59f7170a7332bf49b8469be1fdb9c28bb",
+	},
+	{
+		name:     "argon2id two threads",
+		password: "password",
+		params:   KDFParams{Algorithm: KDFArgon2id, Time: 2, Memory: 64, Threads: 2, KeyLen: 24, Salt: []byte("somesalt")},
+		key:      "350ac37222f436ccb5c0972f1ebd3bf6b958bf2071841362",
+	},
+}
+
+func TestDeriveKeyVectors(t *testing.T) {
+	for _, v := range kdfVectors {
+		t.Run(v.name, func(t *testing.T) {
+			key, err := DeriveKey([]byte(v.password), v.params)
+			if err != nil {
+				t.Fatalf("DeriveKey failed: %v", err)
+			}
+			if got := hex.EncodeToString(key); got != v.key {
+				t.Fatalf("expected %s, got %s", v.key, got)
+			}
+
+			// The encoded parameters must reproduce the same key
+			key, err = DeriveKeyEncoded([]byte(v.password), v.params.Encode())
+			if err != nil {
+				t.Fatalf("DeriveKeyEncoded(%s) failed: %v", v.params.Encode(), err)
+			}
+			if got := hex.EncodeToString(key); got != v.key {
+				t.Fatalf("encoded parameters derived %s, expected %s", got, v.key)
+			}
+		})
+	}
+}
+
+func TestKDFParamsEncoding(t *testing.T) {
+	for _, algorithm := range []string{KDFPBKDF2, KDFArgon2id} {
+		params, err := DefaultKDFParams(algorithm)
+		if err != nil {
+			t.Fatalf("DefaultKDFParams(%s) failed: %v", algorithm, err)
+		}
+		parsed, err := ParseKDFParams(params.Encode())
+		if err != nil {
+			t.Fatalf("ParseKDFParams(%s) failed: %v", params.Encode(), err)
+		}
+		if parsed.Algorithm != params.Algorithm || parsed.Time != params.Time || parsed.Memory != params.Memory ||
+			parsed.Threads != params.Threads || parsed.KeyLen != params.KeyLen || !bytes.Equal(parsed.Salt, params.Salt) {
+			t.Fatalf("%s: parsed %+v, expected %+v", params.Encode(), parsed, params)
+		}
+	}
+
+	if got := (KDFParams{Algorithm: KDFArgon2id, Time: 2, Memory: 19456, Threads: 1, KeyLen: 32, Salt: []byte("somesalt")}).Encode(); got != "$argon2id$v=19$m=19456,t=2,p=1,l=32$c29tZXNhbHQ" {
+		t.Fatalf("unexpected encoding %s", got)
+	}
+}
+
+func TestParseKDFParamsRejects(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"pbkdf2-sha256$i=1,l=32$c2FsdA",
+		"$pbkdf2-sha256$i=1,l=32",
+		"$pbkdf2-sha256$i=0,l=32$c2FsdA",
+		"$pbkdf2-sha256$i=1,l=0$c2FsdA",
+		"$pbkdf2-sha256$i=1,l=32$",
+		"$pbkdf2-sha256$i=1,l=32,m=64$c2FsdA",
+		"$pbkdf2-sha256$i=99999999999,l=32$c2FsdA",
+		"$pbkdf2-sha1$i=1,l=32$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=1,l=32$c2FsdA",
+		"$argon2id$v=19$m=64,t=1,p=1,l=32$!!!",
+		"$argon2id$v=19$m=4,t=1,p=1,l=32$c2FsdA",
+		"$argon2id$v=19$m=4194304,t=1,p=1,l=32$c2FsdA",
+		"$argon2id$v=19$m=64,t=1,p=0,l=32$c2FsdA",
+		"$argon2i$v=19$m=64,t=1,p=1,l=32$c2FsdA",
+	} {
+		if _, err := ParseKDFParams(encoded); !errors.Is(err, ErrInvalidKDFParams) {
+			t.Errorf("%q: expected ErrInvalidKDFParams, got %v", encoded, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A key share carries chat key material from one device to another. It is
+//
+//	ephemeral X25519 public key (32 bytes) || AES-KWP(KEK, key material)
+//
+// where KEK is HKDF-SHA256 of the X25519 secret between the ephemeral key
+// and the recipient device's key, salted with both public keys and bound by
+// its info to the chat and both devices. A share replayed to another chat or
+// device fails the integrity check.
+
+// keyShareContext opens the HKDF info of every key share
+const keyShareContext = "MinMsgr key share v1"
+
+// KeyShareContext identifies a key share: the chat it belongs to and the
+// devices that sealed and may open it
+type KeyShareContext struct {
+	ChatID            int64
+	SenderDeviceID    int64
+	RecipientDeviceID int64
+}
+
+// Bytes encodes the context: the context string and a zero byte, then the
+// chat ID and both device IDs as 8 big-endian bytes each
+func (c *KeyShareContext) Bytes() []byte {
+	out := make([]byte, 0, len(keyShareContext)+1+24)
+	out = append(out, keyShareContext...)
+	out = append(out, 0)
+	out = binary.BigEndian.AppendUint64(out, uint64(c.ChatID))
+	out = binary.BigEndian.AppendUint64(out, uint64(c.SenderDeviceID))
+	out = binary.BigEndian.AppendUint64(out, uint64(c.RecipientDeviceID))
+	return out
+}
+
+// SealKeyShare seals keyMaterial to the X25519 public key of the recipient
+// device
+func SealKeyShare(recipientPublicKey, keyMaterial []byte, c *KeyShareContext) ([]byte, error) {
+	ephemeral, err := NewX25519()
+	if err != nil {
+		return nil, err
+	}
+	defer ephemeral.Close()
+
+	secret, err := ephemeral.ComputeSharedSecret(recipientPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := keyShareKEK(secret, ephemeral.GetPublicKey(), recipientPublicKey, c)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(kek)
+	wrapped, err := AESKeyWrap(kek, keyMaterial)
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeral.GetPublicKey(), wrapped...), nil
+}
+
+// OpenKeyShare opens a key share sealed to recipient, returning
+// ErrUnwrapIntegrity if it was sealed to another key or context or was
+// tampered with
+func OpenKeyShare(recipient *X25519, share []byte, c *KeyShareContext) ([]byte, error) {
+	if len(share) < X25519KeySize+16 {
+		return nil, fmt.Errorf("%w: key share too short", ErrUnwrapIntegrity)
+	}
+	ephemeralPublicKey := share[:X25519KeySize]
+	secret, err := recipient.ComputeSharedSecret(ephemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := keyShareKEK(secret, ephemeralPublicKey, recipient.GetPublicKey(), c)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(kek)
+	return AESKeyUnwrap(kek, share[X25519KeySize:])
+}
+
+// keyShareKEK derives the key encryption key of a share from the X25519
+// secret and wipes the secret
+func keyShareKEK(secret, ephemeralPublicKey, recipientPublicKey []byte, c *KeyShareContext) ([]byte, error) {
+	defer Wipe(secret)
+	salt := append(append([]byte(nil), ephemeralPublicKey...), recipientPublicKey...)
+	return HKDF(secret, salt, c.Bytes(), 32)
+}
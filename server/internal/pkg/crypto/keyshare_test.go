@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKeyShareRoundTrip(t *testing.T) {
+	device, err := NewX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+
+	material := bytes.Repeat([]byte{0x5a}, 32)
+	c := &KeyShareContext{ChatID: 7, SenderDeviceID: 1, RecipientDeviceID: 2}
+	share, err := SealKeyShare(device.GetPublicKey(), material, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(share) != X25519KeySize+len(material)+8 {
+		t.Fatalf("share is %d bytes", len(share))
+	}
+	got, err := OpenKeyShare(device, share, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, material) {
+		t.Fatalf("opened %x, want %x", got, material)
+	}
+
+	// Sealing again uses a fresh ephemeral key
+	again, err := SealKeyShare(device.GetPublicKey(), material, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, share) {
+		t.Fatal("two shares of the same material are equal")
+	}
+}
+
+func TestKeyShareRejectsOtherContext(t *testing.T) {
+	device, err := NewX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+	other, err := NewX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	c := &KeyShareContext{ChatID: 7, SenderDeviceID: 1, RecipientDeviceID: 2}
+	share, err := SealKeyShare(device.GetPublicKey(), []byte("chat private key"), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte(nil), share...)
+	tampered[len(tampered)-1] ^= 1
+	cases := map[string]struct {
+		recipient *X25519
+		share     []byte
+		context   *KeyShareContext
+	}{
+		"other chat":       {device, share, &KeyShareContext{ChatID: 8, SenderDeviceID: 1, RecipientDeviceID: 2}},
+		"other recipient":  {device, share, &KeyShareContext{ChatID: 7, SenderDeviceID: 1, RecipientDeviceID: 3}},
+		"other device key": {other, share, c},
+		"tampered":         {device, tampered, c},
+		"truncated":        {device, share[:X25519KeySize+8], c},
+	}
+	for name, tc := range cases {
+		if _, err := OpenKeyShare(tc.recipient, tc.share, tc.context); !errors.Is(err, ErrUnwrapIntegrity) {
+			t.Errorf("%s: err = %v, want ErrUnwrapIntegrity", name, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// A password-wrapped key is
+//
+//	version (1 byte) || encoded KDF parameters || 0x00 || AES-KWP(derived key, key)
+//
+// The wrapping is deterministic and needs no nonce: the salt in the
+// parameters is fresh for every wrap, and tampering with the parameters
+// yields another derived key and fails the integrity check.
+
+// KeyWrapVersion is the first byte of every password-wrapped key
+const KeyWrapVersion byte = 1
+
+var (
+	// ErrUnwrapIntegrity is returned when unwrapping finds a wrong key
+	// encryption key (e.g. a wrong password) or tampered data
+	ErrUnwrapIntegrity = errors.New("key unwrap integrity check failed")
+	// ErrUnsupportedKeyWrap is returned for wrapped keys of an unknown
+	// version or format
+	ErrUnsupportedKeyWrap = errors.New("unsupported wrapped key format")
+)
+
+// keyWrapPadIV is the constant half of the alternative initial value of
+// RFC 5649
+var keyWrapPadIV = [4]byte{0xa6, 0x59, 0x59, 0xa6}
+
+// WrapKey encrypts key with a key derived from password. params must derive
+// a 16, 24 or 32-byte key.
+func WrapKey(password, key []byte, params KDFParams) ([]byte, error) {
+	kek, err := DeriveKey(password, params)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(kek)
+	body, err := AESKeyWrap(kek, key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []byte{KeyWrapVersion}
+	out = append(out, params.Encode()...)
+	out = append(out, 0)
+	return append(out, body...), nil
+}
+
+// UnwrapKey reverses WrapKey. It returns ErrUnwrapIntegrity for a wrong
+// password.
+func UnwrapKey(password, wrapped []byte) ([]byte, error) {
+	params, body, err := parseWrappedKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := DeriveKey(password, params)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(kek)
+	return AESKeyUnwrap(kek, body)
+}
+
+// WrappedKeyParams returns the KDF parameters a password-wrapped key was
+// wrapped with, without unwrapping it
+func WrappedKeyParams(wrapped []byte) (KDFParams, error) {
+	params, _, err := parseWrappedKey(wrapped)
+	return params, err
+}
+
+// parseWrappedKey splits a password-wrapped key into its KDF parameters and
+// the AES-KWP body
+func parseWrappedKey(wrapped []byte) (KDFParams, []byte, error) {
+	if len(wrapped) == 0 || wrapped[0] != KeyWrapVersion {
+		return KDFParams{}, nil, ErrUnsupportedKeyWrap
+	}
+	end := bytes.IndexByte(wrapped, 0)
+	if end < 0 {
+		return KDFParams{}, nil, ErrUnsupportedKeyWrap
+	}
+	params, err := ParseKDFParams(string(wrapped[1:end]))
+	if err != nil {
+		return KDFParams{}, nil, err
+	}
+	return params, wrapped[end+1:], nil
+}
+
+// AESKeyWrap wraps key of any non-zero length under kek, an AES key, with
+// AES Key Wrap with Padding (RFC 5649). The output is 8 bytes longer than
+// key rounded up to a multiple of 8. It suits any secret held under a key
+// encryption key.
+func AESKeyWrap(kek, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 || uint64(len(key)) > 0xffffffff {
+		return nil, fmt.Errorf("cannot wrap a key of %d bytes", len(key))
+	}
+
+	var iv [8]byte
+	copy(iv[:], keyWrapPadIV[:])
+	binary.BigEndian.PutUint32(iv[4:], uint32(len(key)))
+	padded := make([]byte, (len(key)+7)/8*8)
+	defer Wipe(padded)
+	copy(padded, key)
+
+	if len(padded) == 8 {
+		// A single block is encrypted with the IV in one AES operation
+		out := make([]byte, 16)
+		copy(out, iv[:])
+		copy(out[8:], padded)
+		block.Encrypt(out, out)
+		return out, nil
+	}
+	return wrapBlocks(block, iv, padded), nil
+}
+
+// AESKeyUnwrap reverses AESKeyWrap, returning ErrUnwrapIntegrity if kek is
+// wrong or wrapped was tampered with
+func AESKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, ErrUnwrapIntegrity
+	}
+
+	var iv [8]byte
+	var padded []byte
+	if len(wrapped) == 16 {
+		out := make([]byte, 16)
+		block.Decrypt(out, wrapped)
+		copy(iv[:], out)
+		padded = out[8:]
+	} else {
+		iv, padded = unwrapBlocks(block, wrapped)
+	}
+
+	// Check the IV, the length and the zero padding without branching on
+	// secret data
+	n := int(binary.BigEndian.Uint32(iv[4:]))
+	ok := subtle.ConstantTimeCompare(iv[:4], keyWrapPadIV[:])
+	if n <= len(padded)-8 || n > len(padded) {
+		ok = 0
+		n = len(padded)
+	}
+	var pad byte
+	for _, b := range padded[n:] {
+		pad |= b
+	}
+	ok &= subtle.ConstantTimeByteEq(pad, 0)
+	if ok != 1 {
+		Wipe(padded)
+		return nil, ErrUnwrapIntegrity
+	}
+	return padded[:n], nil
+}
+
+// wrapBlocks is the wrapping process W of RFC 3394 over 64-bit blocks
+func wrapBlocks(block cipher.Block, iv [8]byte, plaintext []byte) []byte {
+	n := len(plaintext) / 8
+	out := make([]byte, 8+len(plaintext))
+	copy(out[8:], plaintext)
+	a := iv
+
+	var buf [16]byte
+	defer Wipe(buf[:])
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf[:8], a[:])
+			copy(buf[8:], out[i*8:i*8+8])
+			block.Encrypt(buf[:], buf[:])
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(a[:], binary.BigEndian.Uint64(buf[:8])^t)
+			copy(out[i*8:], buf[8:])
+		}
+	}
+	copy(out, a[:])
+	return out
+}
+
+// unwrapBlocks is the unwrapping process W⁻¹ of RFC 3394. The caller checks
+// the returned IV.
+func unwrapBlocks(block cipher.Block, ciphertext []byte) ([8]byte, []byte) {
+	n := len(ciphertext)/8 - 1
+	out := make([]byte, len(ciphertext)-8)
+	copy(out, ciphertext[8:])
+	var a [8]byte
+	copy(a[:], ciphertext[:8])
+
+	var buf [16]byte
+	defer Wipe(buf[:])
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(a[:])^t)
+			copy(buf[8:], out[(i-1)*8:i*8])
+			block.Decrypt(buf[:], buf[:])
+			copy(a[:], buf[:8])
+			copy(out[(i-1)*8:], buf[8:])
+		}
+	}
+	return a, out
+}
@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+// TestWrapBlocksRFC3394 checks the core wrapping function against the
+// 128-bit KEK, 128-bit key vector of RFC 3394, section 4.1
+func TestWrapBlocksRFC3394(t *testing.T) {
+	block, err := aes.NewCipher(mustHex(t, "000102030405060708090a0b0c0d0e0f"))
+	if err != nil {
+		t.Fatalf("aes.NewCipher failed: %v", err)
+	}
+	iv := [8]byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+	key := mustHex(t, "00112233445566778899aabbccddeeff")
+
+	wrapped := wrapBlocks(block, iv, key)
+	if got, want := hex.EncodeToString(wrapped), "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5"; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	gotIV, unwrapped := unwrapBlocks(block, wrapped)
+	if gotIV != iv || !bytes.Equal(unwrapped, key) {
+		t.Fatalf("unwrap returned IV %x and key %x", gotIV, unwrapped)
+	}
+}
+
+// TestAESKeyWrapRFC5649 uses the vectors of RFC 5649, section 6
+func TestAESKeyWrapRFC5649(t *testing.T) {
+	kek := mustHex(t, "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+	for _, v := range []struct{ key, wrapped string }{
+		{"c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+		{"466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f"},
+	} {
+		wrapped, err := AESKeyWrap(kek, mustHex(t, v.key))
+		if err != nil {
+			t.Fatalf("AESKeyWrap failed: %v", err)
+		}
+		if got := hex.EncodeToString(wrapped); got != v.wrapped {
+			t.Fatalf("expected %s, got %s", v.wrapped, got)
+		}
+		key, err := AESKeyUnwrap(kek, wrapped)
+		if err != nil {
+			t.Fatalf("AESKeyUnwrap failed: %v", err)
+		}
+		if got := hex.EncodeToString(key); got != v.key {
+			t.Fatalf("unwrapped %s, expected %s", got, v.key)
+		}
+	}
+}
+
+func TestAESKeyUnwrapIntegrity(t *testing.T) {
+	kek := bytes.Repeat([]byte{0x11}, 32)
+	for _, size := range []int{1, 8, 9, 16, 255, 256} {
+		key := bytes.Repeat([]byte{0x5a}, size)
+		wrapped, err := AESKeyWrap(kek, key)
+		if err != nil {
+			t.Fatalf("AESKeyWrap(%d bytes) failed: %v", size, err)
+		}
+		if got, err := AESKeyUnwrap(kek, wrapped); err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("%d bytes: unwrap returned %x, %v", size, got, err)
+		}
+
+		for i := range wrapped {
+			tampered := append([]byte(nil), wrapped...)
+			tampered[i] ^= 0x80
+			if _, err := AESKeyUnwrap(kek, tampered); !errors.Is(err, ErrUnwrapIntegrity) {
+				t.Fatalf("%d bytes, byte %d flipped: expected ErrUnwrapIntegrity, got %v", size, i, err)
+			}
+		}
+		if _, err := AESKeyUnwrap(bytes.Repeat([]byte{0x12}, 32), wrapped); !errors.Is(err, ErrUnwrapIntegrity) {
+			t.Fatalf("%d bytes, wrong KEK: expected ErrUnwrapIntegrity, got %v", size, err)
+		}
+		if _, err := AESKeyUnwrap(kek, wrapped[:len(wrapped)-8]); !errors.Is(err, ErrUnwrapIntegrity) {
+			t.Fatalf("%d bytes, truncated: expected ErrUnwrapIntegrity, got %v", size, err)
+		}
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	params, err := NewPBKDF2Params(1000, 32)
+	if err != nil {
+		t.Fatalf("NewPBKDF2Params failed: %v", err)
+	}
+	key := bytes.Repeat([]byte{0x5a}, 255)
+	wrapped, err := WrapKey([]byte("password123"), key, params)
+	if err != nil {
+		t.Fatalf("WrapKey failed: %v", err)
+	}
+	if wrapped[0] != KeyWrapVersion {
+		t.Fatalf("expected version %d, got %d", KeyWrapVersion, wrapped[0])
+	}
+
+	got, err := UnwrapKey([]byte("password123"), wrapped)
+	if err != nil {
+		t.Fatalf("UnwrapKey failed: %v", err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatal("unwrapped key differs")
+	}
+
+	if _, err := UnwrapKey([]byte("password124"), wrapped); !errors.Is(err, ErrUnwrapIntegrity) {
+		t.Fatalf("expected ErrUnwrapIntegrity for a wrong password, got %v", err)
+	}
+
+	// A changed salt still parses but derives another key
+	tampered := append([]byte(nil), wrapped...)
+	tampered[bytes.IndexByte(tampered, 0)-2] ^= 0x01
+	if _, err := UnwrapKey([]byte("password123"), tampered); err == nil {
+		t.Fatal("expected tampered parameters to fail")
+	}
+
+	tampered = append([]byte(nil), wrapped...)
+	tampered[0] = KeyWrapVersion + 1
+	if _, err := UnwrapKey([]byte("password123"), tampered); !errors.Is(err, ErrUnsupportedKeyWrap) {
+		t.Fatalf("expected ErrUnsupportedKeyWrap for an unknown version, got %v", err)
+	}
+	if _, err := UnwrapKey([]byte("password123"), key); !errors.Is(err, ErrUnsupportedKeyWrap) {
+		t.Fatalf("expected ErrUnsupportedKeyWrap for a legacy blob, got %v", err)
+	}
+}
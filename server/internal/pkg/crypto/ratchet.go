@@ -0,0 +1,357 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The Double Ratchet of Signal (revision 1) over X25519, HKDF-SHA256 and
+// HMAC-SHA256 chain keys. Every message is sent under its own message key;
+// keys are deleted once used, and every reply brings a fresh DH ratchet key,
+// so a stolen key exposes neither earlier nor later messages.
+//
+// Both participants start from the shared secret of the chat's key
+// agreement. The initial ratchet key pairs of both sides are derived from it,
+// so either side can send first: the initiator (user1 of the chat) sends on
+// the chain between the two derived keys, and the responder ratchets to a
+// fresh key of its own right away. The initiator's first chain has no fresh
+// randomness and is only as secret as the shared secret; the first reply
+// ends it.
+
+// RatchetHeaderSize is the length of an encoded RatchetHeader
+const RatchetHeaderSize = X25519KeySize + 8
+
+// MaxRatchetSkip is how many message keys a single message may skip in a
+// receiving chain; MaxRatchetSkippedKeys is how many skipped keys are kept
+// for late messages before the oldest are dropped
+const (
+	MaxRatchetSkip        = 1000
+	MaxRatchetSkippedKeys = 2000
+)
+
+// HKDF labels of the ratchet
+const (
+	labelRatchetRoot      = "MinMsgr ratchet root"
+	labelRatchetInitiator = "MinMsgr ratchet initiator key"
+	labelRatchetResponder = "MinMsgr ratchet responder key"
+)
+
+var (
+	ErrInvalidRatchetHeader = errors.New("invalid ratchet header")
+	ErrInvalidRatchetState  = errors.New("invalid ratchet state")
+	// ErrRatchetTooManySkipped is returned for a message that would skip
+	// more than MaxRatchetSkip message keys
+	ErrRatchetTooManySkipped = errors.New("too many skipped ratchet messages")
+	// ErrRatchetKeyUsed is returned for a message whose key was already used
+	// or dropped: a replay, or a message too late to be read
+	ErrRatchetKeyUsed = errors.New("ratchet message key already used")
+)
+
+// RatchetHeader goes in clear with every message: the sender's current
+// ratchet public key, the length of their previous sending chain and the
+// message's number in the current one
+type RatchetHeader struct {
+	PublicKey []byte
+	PN        uint32
+	N         uint32
+}
+
+// MarshalBinary encodes the header as public key || PN || N, big-endian
+func (h *RatchetHeader) MarshalBinary() ([]byte, error) {
+	if len(h.PublicKey) != X25519KeySize {
+		return nil, fmt.Errorf("%w: public key is %d bytes", ErrInvalidRatchetHeader, len(h.PublicKey))
+	}
+	out := make([]byte, 0, RatchetHeaderSize)
+	out = append(out, h.PublicKey...)
+	out = binary.BigEndian.AppendUint32(out, h.PN)
+	return binary.BigEndian.AppendUint32(out, h.N), nil
+}
+
+// ParseRatchetHeader decodes a header encoded by MarshalBinary
+func ParseRatchetHeader(b []byte) (*RatchetHeader, error) {
+	if len(b) != RatchetHeaderSize {
+		return nil, fmt.Errorf("%w: %d bytes, expected %d", ErrInvalidRatchetHeader, len(b), RatchetHeaderSize)
+	}
+	return &RatchetHeader{
+		PublicKey: append([]byte(nil), b[:X25519KeySize]...),
+		PN:        binary.BigEndian.Uint32(b[X25519KeySize:]),
+		N:         binary.BigEndian.Uint32(b[X25519KeySize+4:]),
+	}, nil
+}
+
+// skippedKey is the message key of a message not received yet
+type skippedKey struct {
+	PublicKey []byte `json:"dh"`
+	N         uint32 `json:"n"`
+	Key       []byte `json:"mk"`
+}
+
+// Ratchet is one participant's Double Ratchet state in a chat. It is not
+// safe for concurrent use.
+type Ratchet struct {
+	dhs     *X25519 // our current ratchet key pair
+	dhr     []byte  // their current ratchet public key
+	rk      []byte  // root key
+	cks     []byte  // sending chain key, nil until the first DH ratchet
+	ckr     []byte  // receiving chain key, nil until the first message
+	ns, nr  uint32
+	pn      uint32
+	skipped []skippedKey
+}
+
+// NewRatchet starts the ratchet of chat chatID from the shared secret of its
+// key agreement. One participant must be the initiator and the other not.
+func NewRatchet(sharedSecret []byte, chatID int64, initiator bool) (*Ratchet, error) {
+	if len(sharedSecret) == 0 {
+		return nil, fmt.Errorf("shared secret must not be empty")
+	}
+	prk := HKDFExtract(ChatSalt(chatID), sharedSecret)
+	defer Wipe(prk)
+	rk, err := HKDFExpand(prk, []byte(labelRatchetRoot), sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	initiatorKey, err := ratchetInitialKey(prk, labelRatchetInitiator)
+	if err != nil {
+		Wipe(rk)
+		return nil, err
+	}
+	responderKey, err := ratchetInitialKey(prk, labelRatchetResponder)
+	if err != nil {
+		Wipe(rk)
+		initiatorKey.Close()
+		return nil, err
+	}
+
+	// The chain between the two initial keys is the initiator's first
+	// sending chain
+	secret, err := initiatorKey.ComputeSharedSecret(responderKey.GetPublicKey())
+	if err != nil {
+		Wipe(rk)
+		initiatorKey.Close()
+		responderKey.Close()
+		return nil, err
+	}
+	defer Wipe(secret)
+	rk, ck := kdfRK(rk, secret)
+
+	if initiator {
+		responderPublic := responderKey.GetPublicKey()
+		responderKey.Close()
+		return &Ratchet{dhs: initiatorKey, dhr: responderPublic, rk: rk, cks: ck}, nil
+	}
+	r := &Ratchet{dhs: responderKey, dhr: initiatorKey.GetPublicKey(), rk: rk, ckr: ck}
+	initiatorKey.Close()
+	if err := r.ratchetSending(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// ratchetInitialKey derives an initial ratchet key pair from the chat's
+// pseudorandom key
+func ratchetInitialKey(prk []byte, label string) (*X25519, error) {
+	private, err := HKDFExpand(prk, []byte(label), X25519KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return NewX25519FromPrivateKey(private)
+}
+
+// kdfRK mixes a DH output into the root key, giving the next root key and a
+// new chain key. The old root key is wiped.
+func kdfRK(rk, dhOut []byte) ([]byte, []byte) {
+	// The output length is fixed and valid
+	out, _ := HKDF(dhOut, rk, []byte(labelRatchetRoot), 2*sha256.Size)
+	Wipe(rk)
+	return out[:sha256.Size:sha256.Size], out[sha256.Size:]
+}
+
+// kdfCK steps a chain key, giving the next chain key and a message key. The
+// old chain key is wiped.
+func kdfCK(ck []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write([]byte{0x01})
+	mk := mac.Sum(nil)
+	mac.Reset()
+	mac.Write([]byte{0x02})
+	next := mac.Sum(nil)
+	Wipe(ck)
+	return next, mk
+}
+
+// ratchetSending replaces our ratchet key pair with a fresh one and starts a
+// new sending chain with it
+func (r *Ratchet) ratchetSending() error {
+	fresh, err := NewX25519()
+	if err != nil {
+		return err
+	}
+	secret, err := fresh.ComputeSharedSecret(r.dhr)
+	if err != nil {
+		fresh.Close()
+		return err
+	}
+	defer Wipe(secret)
+	r.dhs.Close()
+	r.dhs = fresh
+	Wipe(r.cks)
+	r.rk, r.cks = kdfRK(r.rk, secret)
+	return nil
+}
+
+// Next returns the header and the message key of the next message to send.
+// The caller must wipe the key once the message is encrypted.
+func (r *Ratchet) Next() (*RatchetHeader, []byte, error) {
+	if r.dhs == nil {
+		return nil, nil, ErrInvalidRatchetState
+	}
+	if r.ns == ^uint32(0) {
+		return nil, nil, fmt.Errorf("%w: sending chain exhausted", ErrInvalidRatchetState)
+	}
+	header := &RatchetHeader{PublicKey: r.dhs.GetPublicKey(), PN: r.pn, N: r.ns}
+	var mk []byte
+	r.cks, mk = kdfCK(r.cks)
+	r.ns++
+	return header, mk, nil
+}
+
+// Receive returns the message key of the message with header h, ratcheting
+// forward as the header asks. The caller must wipe the key once the message
+// is decrypted. If the message then fails to decrypt, the ratchet has
+// already moved on: the caller must go back to the state saved before.
+func (r *Ratchet) Receive(h *RatchetHeader) ([]byte, error) {
+	if r.dhs == nil {
+		return nil, ErrInvalidRatchetState
+	}
+	if len(h.PublicKey) != X25519KeySize {
+		return nil, ErrInvalidRatchetHeader
+	}
+	for i, s := range r.skipped {
+		if s.N == h.N && bytes.Equal(s.PublicKey, h.PublicKey) {
+			r.skipped = append(r.skipped[:i], r.skipped[i+1:]...)
+			return s.Key, nil
+		}
+	}
+
+	if !bytes.Equal(h.PublicKey, r.dhr) {
+		if err := r.skipUntil(h.PN); err != nil {
+			return nil, err
+		}
+		if err := r.ratchetReceiving(h.PublicKey); err != nil {
+			return nil, err
+		}
+	} else if r.ckr == nil || h.N < r.nr {
+		return nil, ErrRatchetKeyUsed
+	}
+	if err := r.skipUntil(h.N); err != nil {
+		return nil, err
+	}
+	var mk []byte
+	r.ckr, mk = kdfCK(r.ckr)
+	r.nr++
+	return mk, nil
+}
+
+// ratchetReceiving starts the receiving chain of their new ratchet key, then
+// answers it with a new key of ours
+func (r *Ratchet) ratchetReceiving(publicKey []byte) error {
+	secret, err := r.dhs.ComputeSharedSecret(publicKey)
+	if err != nil {
+		return err
+	}
+	defer Wipe(secret)
+	r.pn = r.ns
+	r.ns, r.nr = 0, 0
+	r.dhr = append([]byte(nil), publicKey...)
+	Wipe(r.ckr)
+	r.rk, r.ckr = kdfRK(r.rk, secret)
+	return r.ratchetSending()
+}
+
+// skipUntil keeps the keys of the receiving chain's messages before message
+// until, for messages that arrive late
+func (r *Ratchet) skipUntil(until uint32) error {
+	if r.ckr == nil || until <= r.nr {
+		return nil
+	}
+	if until-r.nr > MaxRatchetSkip {
+		return ErrRatchetTooManySkipped
+	}
+	for r.nr < until {
+		var mk []byte
+		r.ckr, mk = kdfCK(r.ckr)
+		r.skipped = append(r.skipped, skippedKey{PublicKey: r.dhr, N: r.nr, Key: mk})
+		r.nr++
+	}
+	if extra := len(r.skipped) - MaxRatchetSkippedKeys; extra > 0 {
+		for _, s := range r.skipped[:extra] {
+			Wipe(s.Key)
+		}
+		r.skipped = append(r.skipped[:0], r.skipped[extra:]...)
+	}
+	return nil
+}
+
+// ratchetState is the serialized form of a Ratchet
+type ratchetState struct {
+	DHs     []byte       `json:"dhs"`
+	DHr     []byte       `json:"dhr"`
+	RK      []byte       `json:"rk"`
+	CKs     []byte       `json:"cks,omitempty"`
+	CKr     []byte       `json:"ckr,omitempty"`
+	Ns      uint32       `json:"ns"`
+	Nr      uint32       `json:"nr"`
+	PN      uint32       `json:"pn"`
+	Skipped []skippedKey `json:"skipped,omitempty"`
+}
+
+// MarshalJSON saves the state, secret keys included, for clients that keep
+// it between messages
+func (r *Ratchet) MarshalJSON() ([]byte, error) {
+	if r.dhs == nil {
+		return nil, ErrInvalidRatchetState
+	}
+	return json.Marshal(ratchetState{
+		DHs: r.dhs.raw, DHr: r.dhr, RK: r.rk, CKs: r.cks, CKr: r.ckr,
+		Ns: r.ns, Nr: r.nr, PN: r.pn, Skipped: r.skipped,
+	})
+}
+
+// UnmarshalJSON restores a state saved by MarshalJSON
+func (r *Ratchet) UnmarshalJSON(data []byte) error {
+	var s ratchetState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRatchetState, err)
+	}
+	if len(s.DHr) != X25519KeySize || len(s.RK) != sha256.Size ||
+		(s.CKs != nil && len(s.CKs) != sha256.Size) || (s.CKr != nil && len(s.CKr) != sha256.Size) {
+		return ErrInvalidRatchetState
+	}
+	dhs, err := NewX25519FromPrivateKey(s.DHs)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRatchetState, err)
+	}
+	*r = Ratchet{dhs: dhs, dhr: s.DHr, rk: s.RK, cks: s.CKs, ckr: s.CKr, ns: s.Ns, nr: s.Nr, pn: s.PN, skipped: s.Skipped}
+	return nil
+}
+
+// Close wipes the keys of the state
+func (r *Ratchet) Close() {
+	if r.dhs != nil {
+		r.dhs.Close()
+	}
+	Wipe(r.rk)
+	Wipe(r.cks)
+	Wipe(r.ckr)
+	for _, s := range r.skipped {
+		Wipe(s.Key)
+	}
+	*r = Ratchet{}
+}
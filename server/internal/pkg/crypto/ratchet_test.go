@@ -0,0 +1,183 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func newRatchetPair(t *testing.T) (*Ratchet, *Ratchet) {
+	t.Helper()
+	secret := bytes.Repeat([]byte{0x5a}, 32)
+	alice, err := NewRatchet(secret, 9, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := NewRatchet(secret, 9, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return alice, bob
+}
+
+// sent is a message key with its header, as the receiver gets it
+type sent struct {
+	header *RatchetHeader
+	key    []byte
+}
+
+func send(t *testing.T, r *Ratchet) sent {
+	t.Helper()
+	h, mk, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sent{h, mk}
+}
+
+func receive(t *testing.T, r *Ratchet, m sent) {
+	t.Helper()
+	mk, err := r.Receive(m.header)
+	if err != nil {
+		t.Fatalf("receiving message %d (pn %d): %v", m.header.N, m.header.PN, err)
+	}
+	if !bytes.Equal(mk, m.key) {
+		t.Fatalf("message %d: receiver key %x, sender key %x", m.header.N, mk, m.key)
+	}
+}
+
+func TestRatchetConversation(t *testing.T) {
+	alice, bob := newRatchetPair(t)
+
+	// Both sides may speak first
+	a1, a2 := send(t, alice), send(t, alice)
+	b1 := send(t, bob)
+	receive(t, alice, b1)
+	receive(t, bob, a2)
+	receive(t, bob, a1)
+
+	// Each reply moves the sender to a new ratchet key
+	a3 := send(t, alice)
+	if bytes.Equal(a3.header.PublicKey, a1.header.PublicKey) || a3.header.PN != 2 || a3.header.N != 0 {
+		t.Fatalf("no DH ratchet step after a reply: %+v", a3.header)
+	}
+	b2, b3 := send(t, bob), send(t, bob)
+	receive(t, bob, a3)
+	b4 := send(t, bob)
+	receive(t, alice, b4)
+	receive(t, alice, b3)
+	receive(t, alice, b2)
+
+	seen := map[string]bool{}
+	for _, m := range []sent{a1, a2, a3, b1, b2, b3, b4} {
+		if seen[string(m.key)] {
+			t.Fatalf("message key %x used twice", m.key)
+		}
+		seen[string(m.key)] = true
+	}
+}
+
+func TestRatchetRefusesReplays(t *testing.T) {
+	alice, bob := newRatchetPair(t)
+	m := send(t, alice)
+	receive(t, bob, m)
+	if _, err := bob.Receive(m.header); !errors.Is(err, ErrRatchetKeyUsed) {
+		t.Fatalf("replayed message: err = %v, want ErrRatchetKeyUsed", err)
+	}
+
+	// A skipped key is handed out once too
+	late, next := send(t, alice), send(t, alice)
+	receive(t, bob, next)
+	receive(t, bob, late)
+	if _, err := bob.Receive(late.header); !errors.Is(err, ErrRatchetKeyUsed) {
+		t.Fatalf("replayed late message: err = %v, want ErrRatchetKeyUsed", err)
+	}
+}
+
+func TestRatchetLimitsSkippedMessages(t *testing.T) {
+	alice, bob := newRatchetPair(t)
+	h, _, err := alice.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.N = MaxRatchetSkip + 1
+	if _, err := bob.Receive(h); !errors.Is(err, ErrRatchetTooManySkipped) {
+		t.Fatalf("err = %v, want ErrRatchetTooManySkipped", err)
+	}
+}
+
+func TestRatchetStateRoundTrip(t *testing.T) {
+	alice, bob := newRatchetPair(t)
+	late := send(t, alice)
+	receive(t, bob, send(t, alice))
+
+	// Save and restore both sides between every step
+	restore := func(r *Ratchet) *Ratchet {
+		data, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out Ratchet
+		if err := json.Unmarshal(data, &out); err != nil {
+			t.Fatal(err)
+		}
+		return &out
+	}
+	alice, bob = restore(alice), restore(bob)
+	receive(t, bob, late)
+	b := send(t, bob)
+	alice = restore(alice)
+	receive(t, alice, b)
+	a := send(t, alice)
+	bob = restore(bob)
+	receive(t, bob, a)
+
+	var r Ratchet
+	if err := json.Unmarshal([]byte(`{"dhs":"AAAA"}`), &r); !errors.Is(err, ErrInvalidRatchetState) {
+		t.Fatalf("truncated state: err = %v, want ErrInvalidRatchetState", err)
+	}
+}
+
+func TestRatchetDependsOnChat(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x5a}, 32)
+	a, _ := NewRatchet(secret, 1, true)
+	b, _ := NewRatchet(secret, 2, false)
+	h, mka, err := a.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header is accepted as a new ratchet key, but the keys differ
+	if mkb, err := b.Receive(h); err == nil && bytes.Equal(mka, mkb) {
+		t.Fatal("ratchets of different chats agree on a key")
+	}
+}
+
+func TestRatchetHeaderEncoding(t *testing.T) {
+	h := &RatchetHeader{PublicKey: bytes.Repeat([]byte{7}, X25519KeySize), PN: 3, N: 258}
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != RatchetHeaderSize || !bytes.Equal(b[X25519KeySize:], []byte{0, 0, 0, 3, 0, 0, 1, 2}) {
+		t.Fatalf("encoded header %x", b)
+	}
+	got, err := ParseRatchetHeader(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.PublicKey, h.PublicKey) || got.PN != 3 || got.N != 258 {
+		t.Fatalf("parsed %+v", got)
+	}
+	if _, err := ParseRatchetHeader(b[:len(b)-1]); !errors.Is(err, ErrInvalidRatchetHeader) {
+		t.Fatalf("short header: err = %v", err)
+	}
+}
+
+func TestRatchetClose(t *testing.T) {
+	alice, _ := newRatchetPair(t)
+	alice.Close()
+	if _, _, err := alice.Next(); !errors.Is(err, ErrInvalidRatchetState) {
+		t.Fatalf("Next after Close: err = %v", err)
+	}
+}
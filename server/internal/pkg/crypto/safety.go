@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Safety numbers let two users check, by reading digits to each other, that
+// they see the same identity keys. Each user's half is an iterated SHA-512 of
+// their identity key and user ID, as in Signal's numeric fingerprints.
+
+const (
+	// SafetyNumberDigits is the length of a safety number without spaces
+	SafetyNumberDigits = 60
+
+	safetyNumberVersion    = 0
+	safetyNumberIterations = 5200
+	// safetyNumberChunks groups of 5 digits make up each user's half
+	safetyNumberChunks = 6
+)
+
+// safetyNumberHalf is the 30-digit fingerprint of one user's identity key
+func safetyNumberHalf(userID int64, identityKey []byte) string {
+	h := sha512.New()
+	var prefix [2]byte
+	binary.BigEndian.PutUint16(prefix[:], safetyNumberVersion)
+	h.Write(prefix[:])
+	h.Write(identityKey)
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(userID)))
+	digest := h.Sum(nil)
+	for i := 0; i < safetyNumberIterations; i++ {
+		h.Reset()
+		h.Write(digest)
+		h.Write(identityKey)
+		digest = h.Sum(digest[:0])
+	}
+
+	var b strings.Builder
+	for i := 0; i < safetyNumberChunks; i++ {
+		chunk := digest[i*5 : i*5+5]
+		n := uint64(chunk[0])<<32 | uint64(chunk[1])<<24 | uint64(chunk[2])<<16 | uint64(chunk[3])<<8 | uint64(chunk[4])
+		fmt.Fprintf(&b, "%05d", n%100000)
+	}
+	return b.String()
+}
+
+// SafetyNumber returns the safety number of two users' identity keys: 60
+// digits in groups of five. Both users get the same number, and it changes
+// when either key does.
+func SafetyNumber(userA int64, identityKeyA []byte, userB int64, identityKeyB []byte) (string, error) {
+	if err := CheckIdentityKey(identityKeyA); err != nil {
+		return "", err
+	}
+	if err := CheckIdentityKey(identityKeyB); err != nil {
+		return "", err
+	}
+
+	a, b := safetyNumberHalf(userA, identityKeyA), safetyNumberHalf(userB, identityKeyB)
+	if b < a {
+		a, b = b, a
+	}
+	digits := a + b
+	groups := make([]string, 0, SafetyNumberDigits/5)
+	for i := 0; i < len(digits); i += 5 {
+		groups = append(groups, digits[i:i+5])
+	}
+	return strings.Join(groups, " "), nil
+}
@@ -0,0 +1,50 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestSafetyNumber(t *testing.T) {
+	keyA := bytes.Repeat([]byte{1}, IdentityKeySize)
+	keyB := bytes.Repeat([]byte{2}, IdentityKeySize)
+
+	number, err := SafetyNumber(1, keyA, 2, keyB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\d{5}( \d{5}){11}$`).MatchString(number) {
+		t.Fatalf("safety number %q is not 12 groups of 5 digits", number)
+	}
+
+	// Both users see the same number
+	if other, _ := SafetyNumber(2, keyB, 1, keyA); other != number {
+		t.Fatalf("users see different numbers: %q and %q", number, other)
+	}
+
+	// A new identity key, or the same key under another user, changes it
+	newKey := bytes.Repeat([]byte{3}, IdentityKeySize)
+	for name, got := range map[string]string{
+		"new key":    mustSafetyNumber(t, 1, keyA, 2, newKey),
+		"other user": mustSafetyNumber(t, 1, keyA, 3, keyB),
+	} {
+		if got == number {
+			t.Errorf("%s: safety number did not change", name)
+		}
+	}
+
+	if _, err := SafetyNumber(1, keyA, 2, keyB[:16]); !errors.Is(err, ErrInvalidIdentityKey) {
+		t.Errorf("short identity key: err = %v", err)
+	}
+}
+
+func mustSafetyNumber(t *testing.T, userA int64, keyA []byte, userB int64, keyB []byte) string {
+	t.Helper()
+	number, err := SafetyNumber(userA, keyA, userB, keyB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return number
+}
@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Short authentication strings. After a key exchange both participants of a
+// chat read a short code derived from the public keys they used; a MITM who
+// gave them different keys cannot make the codes match.
+
+// SASDigits is the length of a short authentication string
+const SASDigits = 6
+
+// sasContext separates SAS transcripts from any other hash of the same keys
+const sasContext = "MinMsgr SAS v1"
+
+// SASParty is one participant's side of a key exchange: the public key they
+// published in the chat and its epoch (0 for an unsigned key)
+type SASParty struct {
+	UserID    int64
+	Epoch     int64
+	PublicKey []byte
+}
+
+// SASTranscript hashes the key exchange of a chat: the chat ID and both
+// parties, ordered by user ID so that either side gets the same transcript
+func SASTranscript(chatID int64, a, b SASParty) []byte {
+	if b.UserID < a.UserID {
+		a, b = b, a
+	}
+	h := sha256.New()
+	h.Write([]byte(sasContext))
+	h.Write([]byte{0})
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(chatID)))
+	for _, p := range []SASParty{a, b} {
+		var buf [20]byte
+		binary.BigEndian.PutUint64(buf[0:], uint64(p.UserID))
+		binary.BigEndian.PutUint64(buf[8:], uint64(p.Epoch))
+		binary.BigEndian.PutUint32(buf[16:], uint32(len(p.PublicKey)))
+		h.Write(buf[:])
+		h.Write(p.PublicKey)
+	}
+	return h.Sum(nil)
+}
+
+// ShortAuthString returns the SASDigits-digit code of a transcript from
+// SASTranscript
+func ShortAuthString(transcript []byte) (string, error) {
+	if len(transcript) != sha256.Size {
+		return "", fmt.Errorf("SAS transcripts are %d bytes, got %d", sha256.Size, len(transcript))
+	}
+	code, err := HKDFExpand(transcript, []byte(sasContext+" code"), 4)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(code)%1000000), nil
+}
@@ -0,0 +1,43 @@
+package crypto
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestShortAuthString(t *testing.T) {
+	alice := SASParty{UserID: 1, Epoch: 10, PublicKey: bytes.Repeat([]byte{1}, 32)}
+	bob := SASParty{UserID: 2, Epoch: 20, PublicKey: bytes.Repeat([]byte{2}, 32)}
+
+	transcript := SASTranscript(5, alice, bob)
+	if !bytes.Equal(transcript, SASTranscript(5, bob, alice)) {
+		t.Fatal("the parties see different transcripts")
+	}
+	code, err := ShortAuthString(transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\d{6}$`).MatchString(code) {
+		t.Fatalf("code %q is not %d digits", code, SASDigits)
+	}
+
+	// A substituted key, a replayed epoch or another chat give another transcript
+	mallory := bob
+	mallory.PublicKey = bytes.Repeat([]byte{3}, 32)
+	older := bob
+	older.Epoch = 19
+	for name, other := range map[string][]byte{
+		"other key":   SASTranscript(5, alice, mallory),
+		"other epoch": SASTranscript(5, alice, older),
+		"other chat":  SASTranscript(6, alice, bob),
+	} {
+		if bytes.Equal(other, transcript) {
+			t.Errorf("%s: transcript did not change", name)
+		}
+	}
+
+	if _, err := ShortAuthString(transcript[:16]); err == nil {
+		t.Error("short transcript accepted")
+	}
+}
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Session key derivation functions, as recorded per chat. Chats made before
+// transcript binding keep deriving their keys with the chat ID as the only
+// context, so that their history stays readable.
+const (
+	// KDFChatV1 is DeriveChatKeys: HKDF-SHA256 salted with the chat ID
+	KDFChatV1 = "HKDF-SHA256-CHAT-V1"
+	// KDFTranscriptV1 is DeriveSessionKeys: HKDF-SHA256 salted with the hash
+	// of the key exchange transcript
+	KDFTranscriptV1 = "HKDF-SHA256-TRANSCRIPT-V1"
+	// DefaultSessionKDF is the derivation of new chats
+	DefaultSessionKDF = KDFTranscriptV1
+)
+
+// sessionTranscriptContext opens every session transcript
+const sessionTranscriptContext = "MinMsgr session v1"
+
+// SessionTranscript is the context the keys of a chat are bound to: the chat,
+// its cipher and both public keys of the key exchange. The keys may be given
+// in either order.
+type SessionTranscript struct {
+	ChatID     int64
+	Algorithm  string
+	PublicKeyA []byte
+	PublicKeyB []byte
+}
+
+// Bytes encodes the transcript: the context string and a zero byte, the chat
+// ID as 8 big-endian bytes, the algorithm with a 2-byte length, then both
+// public keys in ascending byte order, each with a 4-byte length
+func (t *SessionTranscript) Bytes() []byte {
+	a, b := t.PublicKeyA, t.PublicKeyB
+	if bytes.Compare(b, a) < 0 {
+		a, b = b, a
+	}
+	out := make([]byte, 0, len(sessionTranscriptContext)+1+8+2+len(t.Algorithm)+8+len(a)+len(b))
+	out = append(out, sessionTranscriptContext...)
+	out = append(out, 0)
+	out = binary.BigEndian.AppendUint64(out, uint64(t.ChatID))
+	out = binary.BigEndian.AppendUint16(out, uint16(len(t.Algorithm)))
+	out = append(out, t.Algorithm...)
+	for _, key := range [][]byte{a, b} {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(key)))
+		out = append(out, key...)
+	}
+	return out
+}
+
+// DeriveSessionKeys derives the keys of a chat from the raw shared secret of
+// its key exchange:
+//
+//	salt = SHA-256(transcript.Bytes())
+//	prk  = HKDF-Extract(salt, sharedSecret)
+//	key  = HKDF-Expand(prk, LabelMessageKey, keySize), likewise the IV seed
+//	       and MAC key with their labels
+//
+// keySize is the key size of transcript.Algorithm.
+func DeriveSessionKeys(sharedSecret []byte, transcript *SessionTranscript, keySize int) (*ChatKeys, error) {
+	if len(sharedSecret) == 0 {
+		return nil, fmt.Errorf("shared secret must not be empty")
+	}
+	if keySize <= 0 {
+		return nil, fmt.Errorf("key size must be positive, got %d", keySize)
+	}
+	if len(transcript.PublicKeyA) == 0 || len(transcript.PublicKeyB) == 0 {
+		return nil, fmt.Errorf("%w: the transcript needs both public keys", ErrInvalidPublicKey)
+	}
+	if transcript.Algorithm == "" {
+		return nil, fmt.Errorf("the transcript needs the chat's algorithm")
+	}
+
+	salt := sha256.Sum256(transcript.Bytes())
+	prk := HKDFExtract(salt[:], sharedSecret)
+	defer Wipe(prk)
+	return expandChatKeys(prk, keySize)
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// sessionVector pins the session key derivation. The client derives its keys
+// through the WASM build, whose tests check it against the same vector.
+var sessionVector = struct {
+	secret     []byte
+	transcript SessionTranscript
+	keySize    int
+	messageKey string
+	ivSeed     string
+	macKey     string
+}{
+	secret: bytes.Repeat([]byte{0x42}, 32),
+	transcript: SessionTranscript{
+		ChatID:     7,
+		Algorithm:  "AES",
+		PublicKeyA: bytes.Repeat([]byte{0xa1}, 32),
+		PublicKeyB: bytes.Repeat([]byte{0x0b}, 32),
+	},
+	keySize: 32,
+	// Computed independently from the steps in DeriveSessionKeys' doc comment
+	messageKey: "ae09cfb6cd5409180ee9c8aad5f0aa93e2bc33726acfd9c01ccff096d1435e89",
+	ivSeed:     "7d9762d65f5226a0297527bedcdcaa82",
+	macKey:     "50da37e6c152be709116820415dd1cab2fdf746bd370b3c1c8b1fd5784f6b4ca",
+}
+
+func TestDeriveSessionKeysVector(t *testing.T) {
+	v := sessionVector
+	keys, err := DeriveSessionKeys(v.secret, &v.transcript, v.keySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pair := range map[string][2]string{
+		"message key": {hex.EncodeToString(keys.MessageKey), v.messageKey},
+		"IV seed":     {hex.EncodeToString(keys.IVSeed), v.ivSeed},
+		"MAC key":     {hex.EncodeToString(keys.MACKey), v.macKey},
+	} {
+		if pair[0] != pair[1] {
+			t.Errorf("%s: expected %s, got %s", name, pair[1], pair[0])
+		}
+	}
+}
+
+func TestDeriveSessionKeysBindsTranscript(t *testing.T) {
+	v := sessionVector
+	base, err := DeriveSessionKeys(v.secret, &v.transcript, v.keySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Either participant may list the keys first
+	swapped := v.transcript
+	swapped.PublicKeyA, swapped.PublicKeyB = swapped.PublicKeyB, swapped.PublicKeyA
+	if keys, _ := DeriveSessionKeys(v.secret, &swapped, v.keySize); !bytes.Equal(keys.MessageKey, base.MessageKey) {
+		t.Fatal("the order of the public keys changed the keys")
+	}
+
+	otherChat, otherAlgorithm, otherKey := v.transcript, v.transcript, v.transcript
+	otherChat.ChatID = 8
+	otherAlgorithm.Algorithm = "AES-GCM"
+	otherKey.PublicKeyB = bytes.Repeat([]byte{0x0c}, 32)
+	for name, transcript := range map[string]SessionTranscript{
+		"other chat":      otherChat,
+		"other algorithm": otherAlgorithm,
+		"other key":       otherKey,
+	} {
+		keys, err := DeriveSessionKeys(v.secret, &transcript, v.keySize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(keys.MessageKey, base.MessageKey) || bytes.Equal(keys.IVSeed, base.IVSeed) {
+			t.Errorf("%s: same keys", name)
+		}
+	}
+
+	// And it is not the chat-ID-only derivation
+	legacy, err := DeriveChatKeys(v.secret, v.transcript.ChatID, v.keySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(legacy.MessageKey, base.MessageKey) {
+		t.Error("transcript binding did not change the keys")
+	}
+}
+
+func TestSessionTranscriptIsUnambiguous(t *testing.T) {
+	// Moving a byte between the algorithm and a key must change the encoding
+	a := &SessionTranscript{ChatID: 1, Algorithm: "AESx", PublicKeyA: []byte{1, 2}, PublicKeyB: []byte{3}}
+	b := &SessionTranscript{ChatID: 1, Algorithm: "AES", PublicKeyA: []byte("x\x01\x02"), PublicKeyB: []byte{3}}
+	if bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatal("different transcripts encode the same")
+	}
+}
+
+func TestDeriveSessionKeysRefusesIncompleteTranscripts(t *testing.T) {
+	v := sessionVector
+	noKey := v.transcript
+	noKey.PublicKeyB = nil
+	noAlgorithm := v.transcript
+	noAlgorithm.Algorithm = ""
+	for name, transcript := range map[string]SessionTranscript{"no key": noKey, "no algorithm": noAlgorithm} {
+		if _, err := DeriveSessionKeys(v.secret, &transcript, v.keySize); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if _, err := DeriveSessionKeys(nil, &v.transcript, v.keySize); err == nil {
+		t.Error("empty secret accepted")
+	}
+}
@@ -0,0 +1,34 @@
+package crypto
+
+import (
+	"math/big"
+	"runtime"
+)
+
+// Wipe overwrites b with zeros. Go may still hold copies made by the
+// runtime or by earlier appends, so wiping narrows the window in which a
+// secret lingers in memory rather than closing it.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	runtime.KeepAlive(b)
+}
+
+// WipeWords overwrites a slice of machine words, e.g. an expanded key
+// schedule, with zeros
+func WipeWords[W ~uint | ~uint32 | ~uint64](w []W) {
+	for i := range w {
+		w[i] = 0
+	}
+	runtime.KeepAlive(w)
+}
+
+// WipeInt zeros the digits of x and sets it to 0. x may be nil.
+func WipeInt(x *big.Int) {
+	if x == nil {
+		return
+	}
+	WipeWords(x.Bits())
+	x.SetInt64(0)
+}
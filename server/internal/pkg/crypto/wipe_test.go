@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestWipe(t *testing.T) {
+	b := []byte("secret key material")
+	Wipe(b)
+	if !bytes.Equal(b, make([]byte, len(b))) {
+		t.Fatalf("Wipe left %x", b)
+	}
+
+	words := []uint32{1, 2, 3}
+	WipeWords(words)
+	for _, w := range words {
+		if w != 0 {
+			t.Fatalf("WipeWords left %v", words)
+		}
+	}
+
+	x, _ := new(big.Int).SetString("123456789abcdef0123456789abcdef", 16)
+	digits := x.Bits()
+	WipeInt(x)
+	if x.Sign() != 0 {
+		t.Fatalf("WipeInt left %v", x)
+	}
+	for _, d := range digits[:cap(digits)] {
+		if d != 0 {
+			t.Fatalf("WipeInt left digits %v", digits[:cap(digits)])
+		}
+	}
+	WipeInt(nil)
+}
+
+func TestDiffieHellmanClose(t *testing.T) {
+	dh, err := NewDiffieHellman(2048)
+	if err != nil {
+		t.Fatalf("NewDiffieHellman failed: %v", err)
+	}
+	if err := dh.GeneratePrivateKey(); err != nil {
+		t.Fatalf("GeneratePrivateKey failed: %v", err)
+	}
+	private := dh.a
+	public := dh.GetPublicKey()
+
+	dh.Close()
+	if private.Sign() != 0 {
+		t.Fatal("Close left the private exponent in memory")
+	}
+	if !bytes.Equal(dh.GetPublicKey(), public) {
+		t.Fatal("Close must keep the public key")
+	}
+	if _, err := dh.ComputeSharedSecret(public); err == nil {
+		t.Fatal("expected an error computing a secret after Close")
+	}
+}
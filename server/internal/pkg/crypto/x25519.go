@@ -0,0 +1,127 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Key agreements a chat can use. DH works over the global modp group;
+// X25519 is ECDH on Curve25519 (RFC 7748), with 32-byte keys.
+const (
+	KeyAgreementDH     = "DH"
+	KeyAgreementX25519 = "X25519"
+)
+
+// X25519KeySize is the length of X25519 private keys, public keys and shared
+// secrets
+const X25519KeySize = 32
+
+// ErrInvalidPublicKey is returned for a public key that does not fit the
+// chat's key agreement
+var ErrInvalidPublicKey = errors.New("invalid public key")
+
+// KeyAgreements returns the key agreements chats can use, the default first
+func KeyAgreements() []string {
+	return []string{KeyAgreementDH, KeyAgreementX25519}
+}
+
+// IsKeyAgreement reports whether name is one of KeyAgreements
+func IsKeyAgreement(name string) bool {
+	for _, ka := range KeyAgreements() {
+		if ka == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPublicKey checks that publicKey has the form of a public key of the
+// key agreement. DH keys are only checked for being non-empty.
+func CheckPublicKey(keyAgreement string, publicKey []byte) error {
+	switch keyAgreement {
+	case KeyAgreementX25519:
+		if _, err := ecdh.X25519().NewPublicKey(publicKey); err != nil {
+			return fmt.Errorf("%w: X25519 keys are %d bytes, got %d", ErrInvalidPublicKey, X25519KeySize, len(publicKey))
+		}
+	default:
+		if len(publicKey) == 0 {
+			return fmt.Errorf("%w: empty key", ErrInvalidPublicKey)
+		}
+	}
+	return nil
+}
+
+// X25519 is an X25519 key pair. crypto/ecdh keeps its own copy of the
+// private key out of reach; Close wipes ours and drops that one.
+type X25519 struct {
+	private *ecdh.PrivateKey
+	raw     []byte
+}
+
+// NewX25519 generates a random X25519 key pair
+func NewX25519() (*X25519, error) {
+	raw := make([]byte, X25519KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	return NewX25519FromPrivateKey(raw)
+}
+
+// NewX25519FromPrivateKey restores a key pair from its 32-byte private key.
+// The key pair keeps privateKey, so the caller must not reuse it.
+func NewX25519FromPrivateKey(privateKey []byte) (*X25519, error) {
+	private, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("X25519 private keys are %d bytes, got %d", X25519KeySize, len(privateKey))
+	}
+	return &X25519{private: private, raw: privateKey}, nil
+}
+
+// GetPublicKey returns the public key
+func (x *X25519) GetPublicKey() []byte {
+	return x.private.PublicKey().Bytes()
+}
+
+// GetPrivateKey returns the private key, for clients that store it
+func (x *X25519) GetPrivateKey() []byte {
+	return append([]byte(nil), x.raw...)
+}
+
+// ComputeSharedSecret computes the shared secret with the other party's
+// public key. Low-order public keys, which would give an all-zero secret,
+// are refused.
+func (x *X25519) ComputeSharedSecret(otherPublicKeyBytes []byte) ([]byte, error) {
+	if x.private == nil {
+		return nil, fmt.Errorf("private key wiped")
+	}
+	other, err := ecdh.X25519().NewPublicKey(otherPublicKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: X25519 keys are %d bytes, got %d", ErrInvalidPublicKey, X25519KeySize, len(otherPublicKeyBytes))
+	}
+	secret, err := x.private.ECDH(other)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	return secret, nil
+}
+
+// DeriveChatKeys computes the shared secret with the other party and derives
+// the keys of chat chatID from it with HKDF, as DiffieHellman.DeriveChatKeys
+// does
+func (x *X25519) DeriveChatKeys(otherPublicKeyBytes []byte, chatID int64, keySize int) (*ChatKeys, error) {
+	secret, err := x.ComputeSharedSecret(otherPublicKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(secret)
+	return DeriveChatKeys(secret, chatID, keySize)
+}
+
+// Close wipes the private key. No further secrets can be computed.
+func (x *X25519) Close() {
+	Wipe(x.raw)
+	x.raw = nil
+	x.private = nil
+}
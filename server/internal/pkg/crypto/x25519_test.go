@@ -0,0 +1,100 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// TestX25519RFC7748 checks the Diffie-Hellman example of RFC 7748, 6.1
+func TestX25519RFC7748(t *testing.T) {
+	alice, err := NewX25519FromPrivateKey(mustHex(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := NewX25519FromPrivateKey(mustHex(t, "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := hex.EncodeToString(alice.GetPublicKey()); got != "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a" {
+		t.Fatalf("Alice's public key = %s", got)
+	}
+	if got := hex.EncodeToString(bob.GetPublicKey()); got != "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f" {
+		t.Fatalf("Bob's public key = %s", got)
+	}
+
+	want := "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742"
+	for name, pair := range map[string][2]*X25519{"Alice": {alice, bob}, "Bob": {bob, alice}} {
+		secret, err := pair[0].ComputeSharedSecret(pair[1].GetPublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(secret); got != want {
+			t.Fatalf("%s's shared secret = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestX25519DeriveChatKeys(t *testing.T) {
+	alice, err := NewX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := NewX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := alice.DeriveChatKeys(bob.GetPublicKey(), 7, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := bob.DeriveChatKeys(alice.GetPublicKey(), 7, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.MessageKey, b.MessageKey) || len(a.MessageKey) != 32 {
+		t.Fatalf("chat keys differ: %x and %x", a.MessageKey, b.MessageKey)
+	}
+}
+
+func TestX25519RefusesBadPublicKeys(t *testing.T) {
+	x, err := NewX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A short key, and the all-zero point, whose shared secret is all zeros
+	for _, key := range [][]byte{make([]byte, 31), make([]byte, 32)} {
+		if _, err := x.ComputeSharedSecret(key); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("%d-byte key %x: err = %v, want ErrInvalidPublicKey", len(key), key, err)
+		}
+	}
+
+	if err := CheckPublicKey(KeyAgreementX25519, make([]byte, 256)); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("256-byte X25519 key accepted: %v", err)
+	}
+	if err := CheckPublicKey(KeyAgreementX25519, x.GetPublicKey()); err != nil {
+		t.Errorf("valid X25519 key refused: %v", err)
+	}
+	if err := CheckPublicKey(KeyAgreementDH, make([]byte, 256)); err != nil {
+		t.Errorf("DH key refused: %v", err)
+	}
+}
+
+func TestX25519Close(t *testing.T) {
+	x, err := NewX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := x.raw
+	peer, _ := NewX25519()
+
+	x.Close()
+	if !bytes.Equal(raw, make([]byte, X25519KeySize)) {
+		t.Fatalf("Close left %x", raw)
+	}
+	if _, err := x.ComputeSharedSecret(peer.GetPublicKey()); err == nil {
+		t.Fatal("shared secret computed after Close")
+	}
+}
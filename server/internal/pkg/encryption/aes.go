@@ -0,0 +1,70 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+)
+
+const (
+	AESBlockSize = aes.BlockSize // 128-bit blocks (16 bytes)
+	AESKeySize   = 32            // 256-bit key by default; 16 and 24 bytes are accepted too
+)
+
+// AES wraps crypto/aes as a SymmetricCipher
+type AES struct {
+	block   cipher.Block
+	keySize int
+}
+
+func init() {
+	Register(CipherSpec{
+		Name:      "AES",
+		BlockSize: AESBlockSize,
+		KeySize:   AESKeySize,
+		KeySizes:  []int{16, 24, 32},
+		New:       func(key []byte) (SymmetricCipher, error) { return NewAES(key) },
+	})
+}
+
+// NewAES creates a new AES cipher with a 128, 192 or 256-bit key
+func NewAES(key []byte) (*AES, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: AES key must be 16, 24 or 32 bytes, got %d bytes", ErrInvalidKeySize, len(key))
+	}
+	return &AES{block: block, keySize: len(key)}, nil
+}
+
+// BlockSize returns the block size of AES
+func (a *AES) BlockSize() int {
+	return AESBlockSize
+}
+
+// KeySize returns the size of the key the cipher was created with
+func (a *AES) KeySize() int {
+	return a.keySize
+}
+
+// Name returns the cipher name
+func (a *AES) Name() string {
+	return "AES"
+}
+
+// EncryptBlock encrypts a single 128-bit block
+func (a *AES) EncryptBlock(dst, src []byte) error {
+	if err := checkBlocks(AESBlockSize, dst, src); err != nil {
+		return err
+	}
+	a.block.Encrypt(dst, src)
+	return nil
+}
+
+// DecryptBlock decrypts a single 128-bit block
+func (a *AES) DecryptBlock(dst, src []byte) error {
+	if err := checkBlocks(AESBlockSize, dst, src); err != nil {
+		return err
+	}
+	a.block.Decrypt(dst, src)
+	return nil
+}
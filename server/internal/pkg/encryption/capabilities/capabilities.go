@@ -0,0 +1,53 @@
+// Package capabilities lists the crypto parameters a build supports, read
+// from the cipher, mode, padding and DH group registries. The server reports
+// it from GET /api/crypto/capabilities and the WASM module from
+// WasmCrypto.Capabilities, so the two can be compared.
+package capabilities
+
+import (
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/modes"
+	"MinMsgr/server/internal/pkg/encryption/padding"
+	"MinMsgr/server/internal/protocol"
+)
+
+// Registry returns the algorithms, modes, paddings, DH groups and key
+// agreements in the registries. No DH group is marked default and the key
+// mode fields are left empty: those depend on the server's configuration.
+func Registry() *protocol.CryptoCapabilities {
+	caps := &protocol.CryptoCapabilities{
+		CipherFamilies: encryption.Families(),
+		Paddings:       padding.Names(),
+		KeyAgreements:  crypto.KeyAgreements(),
+	}
+
+	for _, spec := range encryption.Ciphers() {
+		caps.Algorithms = append(caps.Algorithms, protocol.CipherCapability{
+			Name:      spec.Name,
+			BlockSize: spec.BlockSize,
+			KeySize:   spec.KeySize,
+			KeySizes:  spec.AcceptedKeySizes(),
+		})
+	}
+
+	for _, name := range modes.Names() {
+		mode := modes.GetMode(name)
+		_, authenticated := mode.(modes.AEADMode)
+		caps.Modes = append(caps.Modes, protocol.ModeCapability{
+			Name:          name,
+			RequiresIV:    mode.RequiresIV(),
+			Authenticated: authenticated,
+			BlockSize:     modes.RequiredBlockSize(name),
+		})
+	}
+
+	for _, group := range crypto.DHGroups() {
+		caps.DHGroups = append(caps.DHGroups, protocol.DHGroupCapability{
+			Name:      group.Name,
+			Bits:      group.Bits,
+			Generator: int(group.G.Int64()),
+		})
+	}
+	return caps
+}
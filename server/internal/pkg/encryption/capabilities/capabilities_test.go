@@ -0,0 +1,40 @@
+package capabilities
+
+import (
+	"testing"
+
+	"MinMsgr/server/internal/pkg/encryption"
+)
+
+func TestRegistryListsEverything(t *testing.T) {
+	caps := Registry()
+	if len(caps.Algorithms) != len(encryption.Ciphers()) {
+		t.Fatalf("%d algorithms listed, %d registered", len(caps.Algorithms), len(encryption.Ciphers()))
+	}
+	for _, alg := range caps.Algorithms {
+		if len(alg.KeySizes) == 0 {
+			t.Errorf("%s lists no key sizes", alg.Name)
+		}
+	}
+
+	modes := make(map[string]bool)
+	for _, m := range caps.Modes {
+		modes[m.Name] = true
+		if m.Name == "GCM" && (!m.Authenticated || m.BlockSize != 16) {
+			t.Errorf("GCM listed as %+v", m)
+		}
+	}
+	for _, name := range []string{"ECB", "CBC", "CTR", "RANDOM_DELTA", "GCM"} {
+		if !modes[name] {
+			t.Errorf("mode %s not listed", name)
+		}
+	}
+	for _, g := range caps.DHGroups {
+		if g.Default {
+			t.Errorf("%s marked default without a server configuration", g.Name)
+		}
+	}
+	if len(caps.Paddings) == 0 || len(caps.KeyAgreements) == 0 || caps.KeyModes != nil {
+		t.Fatalf("unexpected capabilities %+v", caps)
+	}
+}
@@ -1,12 +1,22 @@
 package encryption
 
-// SymmetricCipher is the interface that all symmetric encryption algorithms must implement
+import (
+	"fmt"
+
+	"MinMsgr/server/internal/pkg/crypto"
+)
+
+// SymmetricCipher is the interface that all symmetric encryption algorithms must implement.
+// A cipher is keyed by its constructor, which expands the key schedule once;
+// every block encrypted with the instance reuses it.
 type SymmetricCipher interface {
-	// Encrypt encrypts plaintext with the given key
-	Encrypt(key []byte, plaintext []byte) ([]byte, error)
+	// EncryptBlock encrypts the single block src into dst. dst and src may be
+	// the same slice.
+	EncryptBlock(dst, src []byte) error
 
-	// Decrypt decrypts ciphertext with the given key
-	Decrypt(key []byte, ciphertext []byte) ([]byte, error)
+	// DecryptBlock decrypts the single block src into dst. dst and src may be
+	// the same slice.
+	DecryptBlock(dst, src []byte) error
 
 	// BlockSize returns the block size in bytes
 	BlockSize() int
@@ -18,19 +28,53 @@ type SymmetricCipher interface {
 	Name() string
 }
 
+// Wiper is implemented by ciphers that can erase their expanded key
+// schedule. A wiped cipher must not be used again.
+type Wiper interface {
+	Wipe()
+}
+
+// WipeCipher erases the key schedule of c if the cipher supports it. AES
+// does not: crypto/aes keeps its schedule out of reach.
+func WipeCipher(c SymmetricCipher) {
+	if w, ok := c.(Wiper); ok {
+		w.Wipe()
+	}
+}
+
 const (
 	LOKI97BlockSize = 8  // 64-bit blocks (8 bytes)
-	LOKI97KeySize   = 16 // 128-bit key (16 bytes) - LOKI97 requires at least 128-bit keys per specification
+	LOKI97KeySize   = 16 // 128-bit key (16 bytes) by default; 24 and 32 bytes are accepted too
 
 	RC6BlockSize = 16 // 128-bit blocks (16 bytes)
 )
 
+// checkBlocks checks that dst and src are both one block long
+func checkBlocks(blockSize int, dst, src []byte) error {
+	if len(src) != blockSize || len(dst) != blockSize {
+		return fmt.Errorf("%w: blocks must be %d bytes, got %d into %d", ErrInvalidBlockSize, blockSize, len(src), len(dst))
+	}
+	return nil
+}
+
 type LOKI97 struct {
 	roundKeys []uint64
+	keySize   int
+}
+
+// Wipe zeros the round keys
+func (l *LOKI97) Wipe() {
+	crypto.WipeWords(l.roundKeys)
 }
 
 type RC6 struct {
-	s []uint32
-	w int // word size in bits
-	r int // number of rounds
+	s       []uint32
+	w       int // word size in bits
+	r       int // number of rounds
+	keySize int
+}
+
+// Wipe zeros the round keys
+func (r *RC6) Wipe() {
+	crypto.WipeWords(r.s)
 }
@@ -0,0 +1,254 @@
+// Package envelope encrypts a message with a chat's parameters into a
+// self-describing blob that carries everything needed to decrypt it but the
+// keys:
+//
+//	version     1 byte
+//	flags       1 byte, bit 0 set if a MAC follows the ciphertext
+//	algorithm   1-byte length || name
+//	mode        1-byte length || name
+//	padding     1-byte length || name
+//	iv          1-byte length || iv
+//	ciphertext  the rest, up to the MAC
+//	mac         HMAC-SHA256 over everything before it and the aad (32 bytes)
+package envelope
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/modes"
+	"MinMsgr/server/internal/pkg/encryption/padding"
+)
+
+// Version is the first byte of every envelope
+const Version byte = 1
+
+// MACSize is the length of the optional MAC
+const MACSize = sha256.Size
+
+const flagMAC = 1 << 0
+
+var (
+	// ErrMalformed is returned for blobs that are not an envelope
+	ErrMalformed = errors.New("malformed envelope")
+	// ErrMissingMAC is returned when a MAC key is given but the envelope
+	// carries no MAC, or the other way around
+	ErrMissingMAC = errors.New("envelope MAC missing or unexpected")
+	// ErrUnknownMode is returned for a mode no Mode is registered for
+	ErrUnknownMode = errors.New("unknown mode")
+	// ErrUnknownPadding is returned for a padding no Padder is registered for
+	ErrUnknownPadding = errors.New("unknown padding")
+)
+
+// Params are the encryption parameters of a chat
+type Params struct {
+	Algorithm string
+	Mode      string
+	Padding   string
+}
+
+// Envelope is a parsed envelope
+type Envelope struct {
+	Version    byte
+	Params     Params
+	IV         []byte
+	Ciphertext []byte
+	MAC        []byte // nil if the envelope has no MAC
+}
+
+// Seal encrypts plaintext with key and p under a fresh random IV and returns
+// the envelope. If macKey is not nil the envelope gets an HMAC-SHA256 that
+// also covers aad. Without a MAC key, aad is only accepted in the
+// authenticated modes.
+func Seal(key, macKey []byte, p Params, plaintext, aad []byte) ([]byte, error) {
+	modeAAD := aad
+	if macKey != nil {
+		modeAAD = nil
+	}
+	ciphertext, iv, err := Encrypt(key, p, plaintext, nil, modeAAD)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Envelope{Version: Version, Params: p, IV: iv, Ciphertext: ciphertext}
+	blob, err := e.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if macKey == nil {
+		return blob, nil
+	}
+	blob[1] |= flagMAC
+	return append(blob, envelopeMAC(macKey, blob, aad)...), nil
+}
+
+// Open parses blob, checks its MAC if macKey is not nil and decrypts it. It
+// returns modes.ErrAuthFailed if the MAC or the tag of an authenticated mode
+// does not match.
+func Open(key, macKey, blob, aad []byte) ([]byte, *Envelope, error) {
+	e, err := Parse(blob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if (macKey == nil) != (e.MAC == nil) {
+		return nil, nil, ErrMissingMAC
+	}
+
+	modeAAD := aad
+	if macKey != nil {
+		modeAAD = nil
+		if !hmac.Equal(envelopeMAC(macKey, blob[:len(blob)-MACSize], aad), e.MAC) {
+			return nil, nil, modes.ErrAuthFailed
+		}
+	}
+	plaintext, err := Decrypt(key, e.Params, e.Ciphertext, e.IV, modeAAD)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, e, nil
+}
+
+// MarshalBinary encodes the envelope, its MAC included
+func (e *Envelope) MarshalBinary() ([]byte, error) {
+	out := []byte{e.Version, 0}
+	if e.MAC != nil {
+		out[1] |= flagMAC
+	}
+	for _, field := range [][]byte{[]byte(e.Params.Algorithm), []byte(e.Params.Mode), []byte(e.Params.Padding), e.IV} {
+		if len(field) > 255 {
+			return nil, fmt.Errorf("%w: field of %d bytes", ErrMalformed, len(field))
+		}
+		out = append(out, byte(len(field)))
+		out = append(out, field...)
+	}
+	out = append(out, e.Ciphertext...)
+	return append(out, e.MAC...), nil
+}
+
+// Parse decodes an envelope without decrypting it
+func Parse(blob []byte) (*Envelope, error) {
+	if len(blob) < 2 {
+		return nil, ErrMalformed
+	}
+	if blob[0] != Version {
+		return nil, fmt.Errorf("%w: %d", modes.ErrUnsupportedVersion, blob[0])
+	}
+	flags := blob[1]
+	if flags&^flagMAC != 0 {
+		return nil, fmt.Errorf("%w: unknown flags %#x", ErrMalformed, flags)
+	}
+
+	rest := blob[2:]
+	fields := make([][]byte, 4)
+	for i := range fields {
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, ErrMalformed
+		}
+		fields[i] = rest[1 : 1+int(rest[0])]
+		rest = rest[1+int(rest[0]):]
+	}
+
+	e := &Envelope{
+		Version: blob[0],
+		Params:  Params{Algorithm: string(fields[0]), Mode: string(fields[1]), Padding: string(fields[2])},
+		IV:      fields[3],
+	}
+	if flags&flagMAC != 0 {
+		if len(rest) < MACSize {
+			return nil, ErrMalformed
+		}
+		e.MAC = rest[len(rest)-MACSize:]
+		rest = rest[:len(rest)-MACSize]
+	}
+	e.Ciphertext = rest
+	return e, nil
+}
+
+func envelopeMAC(macKey, header, aad []byte) []byte {
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(header)
+	mac.Write(aad)
+	return mac.Sum(nil)
+}
+
+// Encrypt pads plaintext and encrypts it with key and p. It returns the
+// ciphertext and the IV used, generated if iv is empty. aad is only
+// accepted in the authenticated modes, GCM and the "+HMAC" ones.
+func Encrypt(key []byte, p Params, plaintext, iv, aad []byte) ([]byte, []byte, error) {
+	c, m, pad, err := newPipeline(key, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer encryption.WipeCipher(c)
+
+	// ECB ignores the IV, but clients store one with every message
+	if len(iv) == 0 {
+		iv = make([]byte, c.BlockSize())
+		if _, err := rand.Read(iv); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The padded copy of the plaintext is the caller's secret too
+	padded := pad.Pad(plaintext, c.BlockSize())
+	defer crypto.Wipe(padded)
+
+	var ct []byte
+	if aead, ok := m.(modes.AEADMode); ok {
+		ct, err = aead.Seal(c, key, padded, iv, aad)
+	} else if len(aad) > 0 {
+		err = fmt.Errorf("mode %s does not authenticate additional data", p.Mode)
+	} else {
+		ct, err = m.Encrypt(c, key, padded, iv)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return ct, iv, nil
+}
+
+// Decrypt reverses Encrypt. In the authenticated modes it fails with
+// modes.ErrAuthFailed if the ciphertext or aad was tampered with.
+func Decrypt(key []byte, p Params, ciphertext, iv, aad []byte) ([]byte, error) {
+	c, m, pad, err := newPipeline(key, p)
+	if err != nil {
+		return nil, err
+	}
+	defer encryption.WipeCipher(c)
+
+	var padded []byte
+	if aead, ok := m.(modes.AEADMode); ok {
+		padded, err = aead.Open(c, key, ciphertext, iv, aad)
+	} else if len(aad) > 0 {
+		err = fmt.Errorf("mode %s does not authenticate additional data", p.Mode)
+	} else {
+		padded, err = m.Decrypt(c, key, ciphertext, iv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pad.Unpad(padded)
+}
+
+// newPipeline looks up the cipher, mode and padding scheme of p by their
+// protocol names
+func newPipeline(key []byte, p Params) (encryption.SymmetricCipher, modes.Mode, padding.Padder, error) {
+	m := modes.GetMode(p.Mode)
+	if m == nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrUnknownMode, p.Mode)
+	}
+	pad := padding.GetPadder(p.Padding)
+	if pad == nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrUnknownPadding, p.Padding)
+	}
+	c, err := encryption.GetCipher(p.Algorithm, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return c, m, pad, nil
+}
@@ -0,0 +1,118 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/encryption/modes"
+)
+
+var (
+	testKey    = bytes.Repeat([]byte{0x2b}, 32)
+	testMACKey = bytes.Repeat([]byte{0x7e}, 32)
+)
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	plaintext := []byte("a message that spans more than one block")
+	for _, p := range []Params{
+		{"RC6", "CBC", "PKCS7"},
+		{"LOKI97", "CTR", "ANSI_X923"},
+		{"AES", "GCM", "PKCS7"},
+		{"MARS", "CBC+HMAC", "ISO_10126"},
+	} {
+		for _, macKey := range [][]byte{nil, testMACKey} {
+			key := testKey
+			if p.Algorithm == "LOKI97" {
+				key = testKey[:16]
+			}
+			blob, err := Seal(key, macKey, p, plaintext, nil)
+			if err != nil {
+				t.Fatalf("%v: Seal failed: %v", p, err)
+			}
+
+			got, e, err := Open(key, macKey, blob, nil)
+			if err != nil {
+				t.Fatalf("%v: Open failed: %v", p, err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Fatalf("%v: expected %q, got %q", p, plaintext, got)
+			}
+			if e.Params != p || (e.MAC != nil) != (macKey != nil) {
+				t.Fatalf("%v: parsed %+v", p, e)
+			}
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	blob, err := Seal(testKey, testMACKey, Params{"RC6", "CBC", "PKCS7"}, []byte("hi"), nil)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	e, err := Parse(blob)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if e.Version != Version || len(e.IV) != 16 || len(e.Ciphertext) != 16 || len(e.MAC) != MACSize {
+		t.Fatalf("unexpected envelope %+v", e)
+	}
+
+	again, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if !bytes.Equal(again, blob) {
+		t.Fatal("MarshalBinary does not reproduce the parsed blob")
+	}
+}
+
+func TestOpenDetectsTampering(t *testing.T) {
+	aad := []byte("chat 42")
+	blob, err := Seal(testKey, testMACKey, Params{"RC6", "CTR", "PKCS7"}, []byte("transfer 100"), aad)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	for i := range blob {
+		tampered := append([]byte(nil), blob...)
+		tampered[i] ^= 0x01
+		if _, _, err := Open(testKey, testMACKey, tampered, aad); err == nil {
+			t.Fatalf("byte %d flipped: expected an error", i)
+		}
+	}
+	if _, _, err := Open(testKey, testMACKey, blob, []byte("chat 43")); !errors.Is(err, modes.ErrAuthFailed) {
+		t.Fatalf("wrong aad: expected ErrAuthFailed, got %v", err)
+	}
+	if _, _, err := Open(testKey, nil, blob, aad); !errors.Is(err, ErrMissingMAC) {
+		t.Fatalf("no MAC key: expected ErrMissingMAC, got %v", err)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	blob, err := Seal(testKey, nil, Params{"RC6", "CBC", "PKCS7"}, []byte("hi"), nil)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	if _, err := Parse(blob[:1]); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("truncated: expected ErrMalformed, got %v", err)
+	}
+	if _, err := Parse(blob[:8]); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("truncated fields: expected ErrMalformed, got %v", err)
+	}
+	other := append([]byte{Version + 1}, blob[1:]...)
+	if _, err := Parse(other); !errors.Is(err, modes.ErrUnsupportedVersion) {
+		t.Fatalf("version: expected ErrUnsupportedVersion, got %v", err)
+	}
+	flags := append([]byte(nil), blob...)
+	flags[1] = 0x80
+	if _, err := Parse(flags); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("flags: expected ErrMalformed, got %v", err)
+	}
+
+	// Without a MAC key, aad needs an authenticated mode
+	if _, err := Seal(testKey, nil, Params{"RC6", "CBC", "PKCS7"}, []byte("hi"), []byte("aad")); err == nil {
+		t.Fatal("expected an error for aad in CBC without a MAC key")
+	}
+}
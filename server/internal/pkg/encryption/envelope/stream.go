@@ -0,0 +1,220 @@
+package envelope
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/modes"
+	"MinMsgr/server/internal/pkg/encryption/padding"
+)
+
+// A Stream encrypts or decrypts data too large to hold at once, such as a
+// file, chunk by chunk, and gives the same result as Encrypt or Decrypt with
+// the same key, parameters and IV. Each Update runs the mode over the whole
+// blocks it has and carries the mode's chaining value into the next as its
+// IV: the last ciphertext block in CBC and CFB, the last plaintext XOR
+// ciphertext block in PCBC and OFB, the advanced counter in CTR. Final adds
+// or strips the padding. Only these modes and ECB stream: the authenticated
+// modes need the whole ciphertext for their tag, and RANDOM_DELTA keeps a
+// delta derived from the first IV.
+
+// ErrNotStreamable is returned for a mode that cannot be streamed
+var ErrNotStreamable = errors.New("mode cannot be streamed")
+
+// errStreamDone is returned for a Stream used after Final or Close
+var errStreamDone = errors.New("stream already finished")
+
+var streamModes = []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR"}
+
+// StreamModes returns the modes a Stream supports
+func StreamModes() []string {
+	return append([]string(nil), streamModes...)
+}
+
+// Stream is a chunked encryption or decryption in progress
+type Stream struct {
+	cipher  encryption.SymmetricCipher
+	mode    modes.Mode
+	pad     padding.Padder
+	encrypt bool
+	iv      []byte // the IV the stream started with
+	chain   []byte // the chaining value the next Update starts from
+	pending []byte // input held back for the next Update or Final
+	done    bool
+}
+
+// NewEncryptStream starts encrypting with key and p under iv, or a fresh
+// random IV if iv is empty
+func NewEncryptStream(key []byte, p Params, iv []byte) (*Stream, error) {
+	return newStream(key, p, iv, true)
+}
+
+// NewDecryptStream starts decrypting data encrypted with key and p under iv
+func NewDecryptStream(key []byte, p Params, iv []byte) (*Stream, error) {
+	return newStream(key, p, iv, false)
+}
+
+func newStream(key []byte, p Params, iv []byte, encrypt bool) (*Stream, error) {
+	streamable := false
+	for _, name := range streamModes {
+		if p.Mode == name {
+			streamable = true
+		}
+	}
+	if !streamable {
+		return nil, fmt.Errorf("%w: %s", ErrNotStreamable, p.Mode)
+	}
+	c, m, pad, err := newPipeline(key, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) == 0 && encrypt {
+		iv = make([]byte, c.BlockSize())
+		if _, err := rand.Read(iv); err != nil {
+			encryption.WipeCipher(c)
+			return nil, err
+		}
+	}
+	if m.RequiresIV() && len(iv) != c.BlockSize() {
+		encryption.WipeCipher(c)
+		return nil, fmt.Errorf("IV length must be %d", c.BlockSize())
+	}
+
+	return &Stream{
+		cipher:  c,
+		mode:    m,
+		pad:     pad,
+		encrypt: encrypt,
+		iv:      append([]byte(nil), iv...),
+		chain:   append([]byte(nil), iv...),
+	}, nil
+}
+
+// IV returns the IV the stream started with, which the encrypted data must
+// be stored with
+func (s *Stream) IV() []byte {
+	return s.iv
+}
+
+// Update processes the next chunk and returns the output it completed,
+// which may be shorter or longer than the chunk
+func (s *Stream) Update(chunk []byte) ([]byte, error) {
+	if s.done {
+		return nil, errStreamDone
+	}
+	// Grow the buffer by hand so the old one is wiped, not left to the GC
+	if len(s.pending)+len(chunk) > cap(s.pending) {
+		grown := make([]byte, len(s.pending), 2*cap(s.pending)+len(chunk))
+		copy(grown, s.pending)
+		crypto.Wipe(s.pending[:cap(s.pending)])
+		s.pending = grown
+	}
+	s.pending = append(s.pending, chunk...)
+
+	blockSize := s.cipher.BlockSize()
+	n := len(s.pending) / blockSize * blockSize
+	// Decryption holds back the last block for Final to unpad
+	if !s.encrypt && n == len(s.pending) {
+		n -= blockSize
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	out, err := s.process(s.pending[:n])
+	if err != nil {
+		return nil, err
+	}
+	s.pending = append(s.pending[:0], s.pending[n:]...)
+	return out, nil
+}
+
+// Final processes the rest of the input, padding it when encrypting and
+// unpadding it when decrypting, and ends the stream
+func (s *Stream) Final() ([]byte, error) {
+	if s.done {
+		return nil, errStreamDone
+	}
+	defer s.Close()
+
+	blockSize := s.cipher.BlockSize()
+	if s.encrypt {
+		padded := s.pad.Pad(s.pending, blockSize)
+		defer crypto.Wipe(padded)
+		return s.process(padded)
+	}
+	if len(s.pending) == 0 || len(s.pending)%blockSize != 0 {
+		return nil, fmt.Errorf("ciphertext length must be a positive multiple of block size (%d)", blockSize)
+	}
+	out, err := s.process(s.pending)
+	if err != nil {
+		return nil, err
+	}
+	return s.pad.Unpad(out)
+}
+
+// Close ends the stream without finishing it and wipes the key schedule.
+// It is safe to call more than once.
+func (s *Stream) Close() {
+	if s.done {
+		return
+	}
+	s.done = true
+	encryption.WipeCipher(s.cipher)
+	// Update keeps reusing the buffer, so input may be left past its length
+	crypto.Wipe(s.pending[:cap(s.pending)])
+	s.pending = nil
+}
+
+// process runs the mode over whole blocks and advances the chaining value
+func (s *Stream) process(in []byte) ([]byte, error) {
+	var out []byte
+	var err error
+	if s.encrypt {
+		out, err = s.mode.Encrypt(s.cipher, nil, in, s.chain)
+	} else {
+		out, err = s.mode.Decrypt(s.cipher, nil, in, s.chain)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.advance(in, out)
+	return out, nil
+}
+
+// advance sets the chaining value to the one the mode reached after the
+// blocks in and out
+func (s *Stream) advance(in, out []byte) {
+	blockSize := s.cipher.BlockSize()
+	if len(in) < blockSize {
+		return
+	}
+	plain, cipher := in[len(in)-blockSize:], out[len(out)-blockSize:]
+	if !s.encrypt {
+		plain, cipher = cipher, plain
+	}
+
+	switch s.mode.Name() {
+	case "CBC", "CFB":
+		copy(s.chain, cipher)
+	case "PCBC", "OFB":
+		for i := range s.chain {
+			s.chain[i] = plain[i] ^ cipher[i]
+		}
+	case "CTR":
+		addCounter(s.chain, uint64(len(in)/blockSize))
+	}
+}
+
+// addCounter adds n to a big-endian counter, modulo 2^(8*len(counter)), as
+// n increments of CTR do
+func addCounter(counter []byte, n uint64) {
+	for i := len(counter) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(counter[i]) + n&0xff
+		counter[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+}
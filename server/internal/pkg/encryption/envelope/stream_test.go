@@ -0,0 +1,104 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/encryption"
+)
+
+// streamChunks feeds data to s in chunks of size bytes and returns the
+// whole output
+func streamChunks(t *testing.T, s *Stream, data []byte, size int) []byte {
+	t.Helper()
+	var out []byte
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		chunk, err := s.Update(data[:n])
+		if err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		out = append(out, chunk...)
+		data = data[n:]
+	}
+	last, err := s.Final()
+	if err != nil {
+		t.Fatalf("Final failed: %v", err)
+	}
+	return append(out, last...)
+}
+
+func TestStreamMatchesEncrypt(t *testing.T) {
+	plaintext := make([]byte, 1000)
+	for i := range plaintext {
+		plaintext[i] = byte(i * 31)
+	}
+	for _, spec := range encryption.Ciphers() {
+		key := bytes.Repeat([]byte{0x2b}, spec.KeySize)
+		iv := bytes.Repeat([]byte{0xf0}, spec.BlockSize)
+		// A counter about to carry into its upper bytes
+		iv[len(iv)-1] = 0xfe
+		for _, mode := range StreamModes() {
+			p := Params{Algorithm: spec.Name, Mode: mode, Padding: "PKCS7"}
+			want, _, err := Encrypt(key, p, plaintext, iv, nil)
+			if err != nil {
+				t.Fatalf("%v: Encrypt failed: %v", p, err)
+			}
+			for _, size := range []int{1, 7, spec.BlockSize, 100, len(plaintext)} {
+				enc, err := NewEncryptStream(key, p, iv)
+				if err != nil {
+					t.Fatalf("%v: NewEncryptStream failed: %v", p, err)
+				}
+				if got := streamChunks(t, enc, plaintext, size); !bytes.Equal(got, want) {
+					t.Fatalf("%v in %d-byte chunks: stream differs from Encrypt", p, size)
+				}
+
+				dec, err := NewDecryptStream(key, p, iv)
+				if err != nil {
+					t.Fatalf("%v: NewDecryptStream failed: %v", p, err)
+				}
+				if got := streamChunks(t, dec, want, size); !bytes.Equal(got, plaintext) {
+					t.Fatalf("%v in %d-byte chunks: stream did not decrypt", p, size)
+				}
+			}
+		}
+	}
+}
+
+func TestStreamRejects(t *testing.T) {
+	key := bytes.Repeat([]byte{0x2b}, 32)
+	for _, mode := range []string{"GCM", "CBC+HMAC", "RANDOM_DELTA"} {
+		if _, err := NewEncryptStream(key, Params{"AES", mode, "PKCS7"}, nil); !errors.Is(err, ErrNotStreamable) {
+			t.Errorf("%s: expected ErrNotStreamable, got %v", mode, err)
+		}
+	}
+
+	s, err := NewEncryptStream(key, Params{"AES", "CBC", "PKCS7"}, nil)
+	if err != nil {
+		t.Fatalf("NewEncryptStream failed: %v", err)
+	}
+	if len(s.IV()) != 16 {
+		t.Fatalf("expected a random 16-byte IV, got %x", s.IV())
+	}
+	if _, err := s.Final(); err != nil {
+		t.Fatalf("Final failed: %v", err)
+	}
+	if _, err := s.Update([]byte("more")); err == nil {
+		t.Fatal("Update after Final accepted")
+	}
+
+	dec, err := NewDecryptStream(key, Params{"AES", "CBC", "PKCS7"}, s.IV())
+	if err != nil {
+		t.Fatalf("NewDecryptStream failed: %v", err)
+	}
+	if _, err := dec.Update(make([]byte, 20)); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := dec.Final(); err == nil {
+		t.Fatal("truncated ciphertext accepted")
+	}
+}
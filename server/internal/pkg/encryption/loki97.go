@@ -5,13 +5,34 @@ import (
 	"fmt"
 )
 
-// NewLOKI97 creates a new LOKI97 cipher with the given 128-bit key
+// A chat picks the LOKI97 key size by name: "LOKI97" is 128-bit,
+// "LOKI97-192" and "LOKI97-256" take the longer keys
+func init() {
+	for _, keySize := range []int{LOKI97KeySize, 24, 32} {
+		keySize, name := keySize, loki97Name(keySize)
+		Register(CipherSpec{
+			Name:      name,
+			BlockSize: LOKI97BlockSize,
+			KeySize:   keySize,
+			New: func(key []byte) (SymmetricCipher, error) {
+				if len(key) != keySize {
+					return nil, fmt.Errorf("%w: %s requires a %d-byte key, got %d bytes", ErrInvalidKeySize, name, keySize, len(key))
+				}
+				return NewLOKI97(key)
+			},
+		})
+	}
+}
+
+// NewLOKI97 creates a new LOKI97 cipher with a 128, 192 or 256-bit key
 func NewLOKI97(key []byte) (*LOKI97, error) {
-	if len(key) != LOKI97KeySize {
-		return nil, fmt.Errorf("LOKI97 requires a %d-byte key (128-bit), got %d bytes", LOKI97KeySize, len(key))
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("%w: LOKI97 key must be 16, 24 or 32 bytes, got %d bytes", ErrInvalidKeySize, len(key))
 	}
 
-	cipher := &LOKI97{}
+	cipher := &LOKI97{keySize: len(key)}
 	cipher.expandKey(key)
 	return cipher, nil
 }
@@ -21,24 +42,31 @@ func (l *LOKI97) BlockSize() int {
 	return LOKI97BlockSize
 }
 
-// KeySize returns the key size of LOKI97
+// KeySize returns the size of the key the cipher was created with
 func (l *LOKI97) KeySize() int {
-	return LOKI97KeySize
+	return l.keySize
 }
 
-// Name returns the cipher name
+// Name returns the name the cipher is registered under for its key size
 func (l *LOKI97) Name() string {
-	return "LOKI97"
+	return loki97Name(l.keySize)
+}
+
+func loki97Name(keySize int) string {
+	if keySize == LOKI97KeySize {
+		return "LOKI97"
+	}
+	return fmt.Sprintf("LOKI97-%d", keySize*8)
 }
 
-// Encrypt encrypts a single 64-bit block
-func (l *LOKI97) Encrypt(key []byte, plaintext []byte) ([]byte, error) {
-	if len(plaintext) != LOKI97BlockSize {
-		return nil, fmt.Errorf("plaintext must be %d bytes, got %d", LOKI97BlockSize, len(plaintext))
+// EncryptBlock encrypts a single 64-bit block
+func (l *LOKI97) EncryptBlock(dst, src []byte) error {
+	if err := checkBlocks(LOKI97BlockSize, dst, src); err != nil {
+		return err
 	}
 
-	left := binary.BigEndian.Uint32(plaintext[:4])
-	right := binary.BigEndian.Uint32(plaintext[4:8])
+	left := binary.BigEndian.Uint32(src[:4])
+	right := binary.BigEndian.Uint32(src[4:8])
 
 	// 16 rounds
 	for i := 0; i < 16; i++ {
@@ -47,21 +75,20 @@ func (l *LOKI97) Encrypt(key []byte, plaintext []byte) ([]byte, error) {
 		left = temp
 	}
 
-	ciphertext := make([]byte, LOKI97BlockSize)
-	binary.BigEndian.PutUint32(ciphertext[:4], right)
-	binary.BigEndian.PutUint32(ciphertext[4:8], left)
+	binary.BigEndian.PutUint32(dst[:4], right)
+	binary.BigEndian.PutUint32(dst[4:8], left)
 
-	return ciphertext, nil
+	return nil
 }
 
-// Decrypt decrypts a single 64-bit block
-func (l *LOKI97) Decrypt(key []byte, ciphertext []byte) ([]byte, error) {
-	if len(ciphertext) != LOKI97BlockSize {
-		return nil, fmt.Errorf("ciphertext must be %d bytes, got %d", LOKI97BlockSize, len(ciphertext))
+// DecryptBlock decrypts a single 64-bit block
+func (l *LOKI97) DecryptBlock(dst, src []byte) error {
+	if err := checkBlocks(LOKI97BlockSize, dst, src); err != nil {
+		return err
 	}
 
-	left := binary.BigEndian.Uint32(ciphertext[:4])
-	right := binary.BigEndian.Uint32(ciphertext[4:8])
+	left := binary.BigEndian.Uint32(src[:4])
+	right := binary.BigEndian.Uint32(src[4:8])
 
 	// 16 rounds in reverse (use same structure as encrypt but with reversed keys)
 	for i := 15; i >= 0; i-- {
@@ -70,15 +97,15 @@ func (l *LOKI97) Decrypt(key []byte, ciphertext []byte) ([]byte, error) {
 		left = temp
 	}
 
-	plaintext := make([]byte, LOKI97BlockSize)
-	binary.BigEndian.PutUint32(plaintext[:4], right)
-	binary.BigEndian.PutUint32(plaintext[4:8], left)
+	binary.BigEndian.PutUint32(dst[:4], right)
+	binary.BigEndian.PutUint32(dst[4:8], left)
 
-	return plaintext, nil
+	return nil
 }
 
-// expandKey expands the 128-bit key into round keys
-// LOKI97 uses 128-bit keys for proper security strength
+// expandKey expands the key into round keys. The first 128 bits give the
+// low halves of the round keys; 192 and 256-bit keys fill the high halves,
+// which are zero for 128-bit keys.
 func (l *LOKI97) expandKey(key []byte) {
 	l.roundKeys = make([]uint64, 32) // 16 rounds * 2 keys per round
 
@@ -104,6 +131,42 @@ func (l *LOKI97) expandKey(key []byte) {
 		l.roundKeys[i*2] = uint64(k2)
 		l.roundKeys[i*2+1] = uint64(k3)
 	}
+
+	if len(key) == 16 {
+		return
+	}
+
+	// As in the LOKI97 key schedule, the words a 192-bit key lacks are
+	// generated with the round function from the words it has
+	k4 := binary.BigEndian.Uint32(key[16:20])
+	k5 := binary.BigEndian.Uint32(key[20:24])
+	var k6, k7 uint32
+	if len(key) == 32 {
+		k6 = binary.BigEndian.Uint32(key[24:28])
+		k7 = binary.BigEndian.Uint32(key[28:32])
+	} else {
+		k6 = l.g(k4, k5)
+		k7 = l.g(k5, k4)
+	}
+
+	for i := 0; i < 8; i++ {
+		k4 = rotl32(k4^0x9E3779B9, 5)
+		k5 = rotl32(k5^0x3C6EF372, 9)
+		l.roundKeys[i*2] |= uint64(k4^k5) << 32
+	}
+	for i := 8; i < 16; i++ {
+		k6 = rotl32(k6^0xDAA66D2B, 5)
+		k7 = rotl32(k7^0x78DDE6E4, 9)
+		l.roundKeys[i*2] |= uint64(k6^k7) << 32
+	}
+}
+
+// g is the keyless part of the round function, the S-box layer, applied to
+// x and mixed with k. The key schedule uses it to generate key words.
+func (l *LOKI97) g(x, k uint32) uint32 {
+	a := l.sBox(uint16(x>>16), true)
+	b := l.sBox(uint16(x), false)
+	return (uint32(a)<<16 | uint32(b)) ^ rotl32(k, 13)
 }
 
 // f is the round function for LOKI97
@@ -127,8 +190,25 @@ func (l *LOKI97) f(x uint32, roundKey []uint64) uint32 {
 
 // sBox applies S-box transformation
 func (l *LOKI97) sBox(x uint16, isFirst bool) uint16 {
-	// LOKI97 S-boxes (simplified - these would be the actual LOKI97 S-boxes)
-	sbox1 := [256]uint8{
+	high := uint8(x >> 8)
+	low := uint8(x & 0xFF)
+
+	var resultHigh, resultLow uint8
+	if isFirst {
+		resultHigh = loki97S1[high]
+		resultLow = loki97S1[low]
+	} else {
+		resultHigh = loki97S2[high]
+		resultLow = loki97S2[low]
+	}
+
+	return uint16(resultHigh)<<8 | uint16(resultLow)
+}
+
+// LOKI97 S-boxes (simplified - these would be the actual LOKI97 S-boxes).
+// Package variables, so they are not rebuilt on every block.
+var (
+	loki97S1 = [256]uint8{
 		0xa4, 0xaf, 0x8f, 0x84, 0x99, 0x26, 0xeb, 0xf4, 0xd9, 0xf9, 0xb0, 0x17, 0xfc, 0x4d, 0x6d, 0x62,
 		0xf3, 0x02, 0x8d, 0xaa, 0xad, 0xd7, 0x31, 0xd5, 0xc5, 0x88, 0x61, 0xf5, 0x2f, 0xdd, 0xa7, 0x47,
 		0x69, 0xca, 0x6c, 0x96, 0x59, 0x82, 0x87, 0x71, 0x06, 0xa6, 0xf6, 0xb8, 0x13, 0x36, 0xff, 0x44,
@@ -147,7 +227,7 @@ func (l *LOKI97) sBox(x uint16, isFirst bool) uint16 {
 		0x0b, 0xe3, 0x0c, 0xf2, 0xf8, 0xa7, 0x0d, 0x09, 0xfd, 0xde, 0x5b, 0x47, 0x94, 0x86, 0xad, 0x85,
 	}
 
-	sbox2 := [256]uint8{
+	loki97S2 = [256]uint8{
 		0xd0, 0x84, 0x70, 0xd9, 0x0e, 0xc9, 0x3f, 0x61, 0x7c, 0xf7, 0x64, 0x4c, 0xb1, 0xf3, 0x0b, 0xa2,
 		0xaa, 0x18, 0xd8, 0x1c, 0x02, 0xd4, 0x69, 0xfb, 0x8f, 0x2f, 0x83, 0x04, 0x5d, 0xb5, 0xb9, 0x5e,
 		0xc8, 0x34, 0xeb, 0x52, 0xb2, 0xe0, 0x20, 0xfe, 0xf8, 0x6e, 0x19, 0x87, 0x4e, 0x66, 0xa9, 0x09,
@@ -165,18 +245,4 @@ func (l *LOKI97) sBox(x uint16, isFirst bool) uint16 {
 		0x08, 0x28, 0x88, 0x6a, 0x7c, 0x85, 0x13, 0x58, 0x17, 0xc9, 0x31, 0xf7, 0xad, 0x8d, 0x78, 0xea,
 		0x00, 0xe7, 0x32, 0xff, 0x36, 0x43, 0x79, 0x94, 0x71, 0x2f, 0x0c, 0x1e, 0x84, 0xfb, 0xfc, 0x67,
 	}
-
-	high := uint8(x >> 8)
-	low := uint8(x & 0xFF)
-
-	var resultHigh, resultLow uint8
-	if isFirst {
-		resultHigh = sbox1[high]
-		resultLow = sbox1[low]
-	} else {
-		resultHigh = sbox2[high]
-		resultLow = sbox2[low]
-	}
-
-	return uint16(resultHigh)<<8 | uint16(resultLow)
-}
+)
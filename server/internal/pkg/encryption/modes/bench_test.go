@@ -0,0 +1,148 @@
+package modes
+
+import (
+	"fmt"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/padding"
+)
+
+var (
+	benchModes    = []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR", "RANDOM_DELTA", "GCM", "CBC+HMAC", "CTR+HMAC"}
+	benchPaddings = []string{"ZEROS", "PKCS7", "ANSI_X923", "ISO_10126"}
+	benchSizes    = []struct {
+		name string
+		size int
+	}{
+		{"64B", 64},
+		{"4KB", 4 << 10},
+		{"1MB", 1 << 20},
+	}
+)
+
+// benchCases calls run for every registered cipher, mode the cipher supports
+// and payload size, with a sub-benchmark named algorithm/mode/size
+func benchCases(b *testing.B, run func(b *testing.B, cipher encryption.SymmetricCipher, mode Mode, iv, payload []byte)) {
+	for _, spec := range encryption.Ciphers() {
+		cipher, err := encryption.GetCipher(spec.Name, make([]byte, spec.KeySize))
+		if err != nil {
+			b.Fatal(err)
+		}
+		iv := make([]byte, spec.BlockSize)
+		for _, modeName := range benchModes {
+			// GCM only works with 128-bit blocks
+			if modeName == "GCM" && spec.BlockSize != 16 {
+				continue
+			}
+			mode := GetMode(modeName)
+			for _, size := range benchSizes {
+				payload := make([]byte, size.size)
+				b.Run(fmt.Sprintf("%s/%s/%s", spec.Name, modeName, size.name), func(b *testing.B) {
+					b.SetBytes(int64(size.size))
+					b.ReportAllocs()
+					run(b, cipher, mode, iv, payload)
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkEncrypt measures padding and encrypting a message, as a client
+// sending it does
+func BenchmarkEncrypt(b *testing.B) {
+	padder := padding.GetPadder("PKCS7")
+	benchCases(b, func(b *testing.B, cipher encryption.SymmetricCipher, mode Mode, iv, payload []byte) {
+		for i := 0; i < b.N; i++ {
+			if _, err := mode.Encrypt(cipher, nil, padder.Pad(payload, cipher.BlockSize()), iv); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkDecrypt measures decrypting and unpadding a message, as a client
+// receiving it does
+func BenchmarkDecrypt(b *testing.B) {
+	padder := padding.GetPadder("PKCS7")
+	benchCases(b, func(b *testing.B, cipher encryption.SymmetricCipher, mode Mode, iv, payload []byte) {
+		ciphertext, err := mode.Encrypt(cipher, nil, padder.Pad(payload, cipher.BlockSize()), iv)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			padded, err := mode.Decrypt(cipher, nil, ciphertext, iv)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := padder.Unpad(padded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkBlock measures a single in-place block encryption per cipher,
+// the call every mode makes once per block; it should report 0 allocs/op
+func BenchmarkBlock(b *testing.B) {
+	for _, spec := range encryption.Ciphers() {
+		cipher, err := encryption.GetCipher(spec.Name, make([]byte, spec.KeySize))
+		if err != nil {
+			b.Fatal(err)
+		}
+		block := make([]byte, spec.BlockSize)
+		b.Run(spec.Name, func(b *testing.B) {
+			b.SetBytes(int64(len(block)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := cipher.EncryptBlock(block, block); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRC6Rounds measures RC6 in CBC on 4 KB per round count, the
+// parameter chats can vary through RC6-w/r/b names
+func BenchmarkRC6Rounds(b *testing.B) {
+	padder := padding.GetPadder("PKCS7")
+	payload := padder.Pad(make([]byte, 4<<10), 16)
+	iv := make([]byte, 16)
+	for _, rounds := range []int{8, 12, 16, 20, 24, 32} {
+		cipher, err := encryption.GetCipher(fmt.Sprintf("RC6-32/%d/16", rounds), make([]byte, 16))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("r=%d", rounds), func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			for i := 0; i < b.N; i++ {
+				if _, err := GetMode("CBC").Encrypt(cipher, nil, payload, iv); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPadding measures padding and unpadding on their own, per scheme
+// and payload size
+func BenchmarkPadding(b *testing.B) {
+	for _, name := range benchPaddings {
+		padder := padding.GetPadder(name)
+		for _, size := range benchSizes {
+			// One byte short of a block, so every scheme adds padding
+			payload := make([]byte, size.size-1)
+			b.Run(fmt.Sprintf("%s/%s", name, size.name), func(b *testing.B) {
+				b.SetBytes(int64(len(payload)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := padder.Unpad(padder.Pad(payload, 16)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
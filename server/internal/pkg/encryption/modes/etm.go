@@ -0,0 +1,176 @@
+package modes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption"
+)
+
+const (
+	// EtMSuffix turns a mode name into its encrypt-then-MAC name, e.g.
+	// "CBC+HMAC"
+	EtMSuffix = "+HMAC"
+	// EtMVersion is the first byte of every encrypt-then-MAC payload
+	EtMVersion byte = 1
+	// EtMTagSize is the length of the HMAC-SHA256 tag at the end of the payload
+	EtMTagSize = sha256.Size
+)
+
+// The labels the encryption and MAC keys are derived from the chat key
+// with, so neither the inner mode nor the MAC uses the chat key directly
+const (
+	etmEncLabel = "MinMsgr encrypt-then-MAC v1 enc key"
+	etmMACLabel = "MinMsgr encrypt-then-MAC v1 mac key"
+)
+
+// ErrUnsupportedVersion is returned when an encrypt-then-MAC payload starts
+// with an unknown version byte
+var ErrUnsupportedVersion = errors.New("unsupported payload version")
+
+// AEADMode is a mode that authenticates the ciphertext and additional data
+// along with encrypting. Open returns ErrAuthFailed on any tampering.
+type AEADMode interface {
+	Mode
+	Seal(cipher encryption.SymmetricCipher, key, plaintext, iv, aad []byte) ([]byte, error)
+	Open(cipher encryption.SymmetricCipher, key, ciphertext, iv, aad []byte) ([]byte, error)
+}
+
+// EncryptThenMACMode wraps any other mode with HMAC-SHA256 over the IV and
+// the ciphertext. The inner mode runs the cipher under a key derived from
+// the chat key, so cipher must be the one registered under its Name. The
+// payload is
+//
+//	version (1 byte) || ciphertext of the inner mode || tag (32 bytes)
+//
+// and the tag is checked before anything is decrypted.
+type EncryptThenMACMode struct {
+	Inner Mode
+}
+
+// NewEncryptThenMAC wraps inner with an HMAC-SHA256 tag
+func NewEncryptThenMAC(inner Mode) *EncryptThenMACMode {
+	return &EncryptThenMACMode{Inner: inner}
+}
+
+func (e *EncryptThenMACMode) Name() string {
+	return e.Inner.Name() + EtMSuffix
+}
+
+func (e *EncryptThenMACMode) RequiresIV() bool {
+	return e.Inner.RequiresIV()
+}
+
+func (e *EncryptThenMACMode) Encrypt(cipher encryption.SymmetricCipher, key []byte, plaintext []byte, iv []byte) ([]byte, error) {
+	return e.Seal(cipher, key, plaintext, iv, nil)
+}
+
+func (e *EncryptThenMACMode) Decrypt(cipher encryption.SymmetricCipher, key []byte, ciphertext []byte, iv []byte) ([]byte, error) {
+	return e.Open(cipher, key, ciphertext, iv, nil)
+}
+
+// Seal encrypts plaintext with the inner mode and returns the versioned
+// payload. The tag also covers aad, which is not included in the output.
+func (e *EncryptThenMACMode) Seal(cipher encryption.SymmetricCipher, key, plaintext, iv, aad []byte) ([]byte, error) {
+	encCipher, encKey, err := etmCipher(cipher, key)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(encKey)
+	defer encryption.WipeCipher(encCipher)
+	ciphertext, err := e.Inner.Encrypt(encCipher, encKey, plaintext, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, 1+len(ciphertext)+EtMTagSize)
+	out = append(out, EtMVersion)
+	out = append(out, ciphertext...)
+	return append(out, etmTag(key, iv, aad, ciphertext)...), nil
+}
+
+// Open checks the version and the tag of payload and decrypts it with the
+// inner mode
+func (e *EncryptThenMACMode) Open(cipher encryption.SymmetricCipher, key, payload, iv, aad []byte) ([]byte, error) {
+	if len(payload) < 1+EtMTagSize {
+		return nil, ErrAuthFailed
+	}
+	if payload[0] != EtMVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, payload[0])
+	}
+
+	ciphertext := payload[1 : len(payload)-EtMTagSize]
+	if !hmac.Equal(etmTag(key, iv, aad, ciphertext), payload[len(payload)-EtMTagSize:]) {
+		return nil, ErrAuthFailed
+	}
+	encCipher, encKey, err := etmCipher(cipher, key)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(encKey)
+	defer encryption.WipeCipher(encCipher)
+	return e.Inner.Decrypt(encCipher, encKey, ciphertext, iv)
+}
+
+// etmKey derives a key of n bytes from the chat key with HMAC-SHA256 under
+// label, one HMAC block per counter value
+func etmKey(key []byte, label string, n int) []byte {
+	out := make([]byte, 0, n+sha256.Size)
+	for i := byte(1); len(out) < n; i++ {
+		derive := hmac.New(sha256.New, key)
+		derive.Write([]byte(label))
+		derive.Write([]byte{i})
+		out = derive.Sum(out)
+	}
+	crypto.Wipe(out[n:])
+	return out[:n]
+}
+
+// etmCipher returns the inner mode's cipher: the algorithm of cipher keyed
+// with the encryption key derived from key, which has the chat key's length
+func etmCipher(cipher encryption.SymmetricCipher, key []byte) (encryption.SymmetricCipher, []byte, error) {
+	encKey := etmKey(key, etmEncLabel, len(key))
+	c, err := encryption.GetCipher(cipher.Name(), encKey)
+	if err != nil {
+		crypto.Wipe(encKey)
+		return nil, nil, err
+	}
+	return c, encKey, nil
+}
+
+// etmTag computes the HMAC over the version, aad, iv and ciphertext. The
+// lengths are included so that bytes cannot move between the fields.
+func etmTag(key, iv, aad, ciphertext []byte) []byte {
+	macKey := etmKey(key, etmMACLabel, sha256.Size)
+	defer crypto.Wipe(macKey)
+	mac := hmac.New(sha256.New, macKey)
+
+	var length [8]byte
+	mac.Write([]byte{EtMVersion})
+	binary.BigEndian.PutUint64(length[:], uint64(len(aad)))
+	mac.Write(length[:])
+	mac.Write(aad)
+	binary.BigEndian.PutUint64(length[:], uint64(len(iv)))
+	mac.Write(length[:])
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	return mac.Sum(nil)
+}
+
+// getEncryptThenMAC returns the wrapped mode for names such as "CBC+HMAC".
+// GCM is authenticated already and is not wrapped.
+func getEncryptThenMAC(modeName string) Mode {
+	inner := GetMode(strings.TrimSuffix(modeName, EtMSuffix))
+	if inner == nil {
+		return nil
+	}
+	if _, ok := inner.(AEADMode); ok {
+		return nil
+	}
+	return NewEncryptThenMAC(inner)
+}
@@ -0,0 +1,113 @@
+package modes
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/encryption/padding"
+)
+
+func TestEncryptThenMACRoundTrip(t *testing.T) {
+	padder := padding.GetPadder("PKCS7")
+	plaintext := []byte("Hello, World! This is a test message for encryption and decryption.")
+	aad := []byte("chat 42")
+
+	for _, modeName := range []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR"} {
+		mode := GetMode(modeName + EtMSuffix)
+		if mode == nil {
+			t.Fatalf("GetMode returned nil for %s%s", modeName, EtMSuffix)
+		}
+		if mode.Name() != modeName+EtMSuffix {
+			t.Fatalf("Mode name mismatch: expected %s%s, got %s", modeName, EtMSuffix, mode.Name())
+		}
+		etm := mode.(AEADMode)
+
+		padded := padder.Pad(plaintext, 16)
+		sealed, err := etm.Seal(getTestRC6(), testKey256, padded, testIV16, aad)
+		if err != nil {
+			t.Fatalf("%s: seal failed: %v", mode.Name(), err)
+		}
+		if sealed[0] != EtMVersion || len(sealed) != 1+len(padded)+EtMTagSize {
+			t.Fatalf("%s: unexpected payload layout %x", mode.Name(), sealed)
+		}
+
+		opened, err := etm.Open(getTestRC6(), testKey256, sealed, testIV16, aad)
+		if err != nil {
+			t.Fatalf("%s: open failed: %v", mode.Name(), err)
+		}
+		unpadded, _ := padder.Unpad(opened)
+		if !bytes.Equal(unpadded, plaintext) {
+			t.Fatalf("%s: round trip gave %q", mode.Name(), unpadded)
+		}
+	}
+}
+
+// TestEncryptThenMACDerivesEncryptionKey checks that the inner mode runs
+// under a derived key: the bare inner mode with the chat key neither
+// produces nor decrypts the wrapped ciphertext
+func TestEncryptThenMACDerivesEncryptionKey(t *testing.T) {
+	padded := padding.GetPadder("PKCS7").Pad([]byte("Hello, World! This is a test message."), 16)
+	for _, modeName := range []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR"} {
+		etm := GetMode(modeName + EtMSuffix).(AEADMode)
+		sealed, err := etm.Seal(getTestRC6(), testKey256, padded, testIV16, nil)
+		if err != nil {
+			t.Fatalf("%s: seal failed: %v", etm.Name(), err)
+		}
+		ciphertext := sealed[1 : len(sealed)-EtMTagSize]
+
+		inner := GetMode(modeName)
+		bare, _ := inner.Encrypt(getTestRC6(), testKey256, padded, testIV16)
+		if bytes.Equal(ciphertext, bare) {
+			t.Fatalf("%s: inner ciphertext was encrypted with the chat key", etm.Name())
+		}
+		decrypted, _ := inner.Decrypt(getTestRC6(), testKey256, ciphertext, testIV16)
+		if bytes.Equal(decrypted, padded) {
+			t.Fatalf("%s: %s decrypted the wrapped ciphertext with the chat key", etm.Name(), modeName)
+		}
+	}
+}
+
+func TestEncryptThenMACDetectsTampering(t *testing.T) {
+	mode := GetMode("CBC" + EtMSuffix).(AEADMode)
+	cipher := getTestAES()
+	padded := padding.GetPadder("PKCS7").Pad([]byte("Hello, World!"), 16)
+
+	sealed, err := mode.Seal(cipher, testKey256, padded, testIV16, nil)
+	if err != nil {
+		t.Fatalf("seal failed: %v", err)
+	}
+
+	for i := 1; i < len(sealed); i++ {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		if _, err := mode.Decrypt(cipher, testKey256, tampered, testIV16); !errors.Is(err, ErrAuthFailed) {
+			t.Fatalf("byte %d flipped: expected ErrAuthFailed, got %v", i, err)
+		}
+	}
+
+	otherIV := []byte("FEDCBA9876543210")
+	if _, err := mode.Decrypt(cipher, testKey256, sealed, otherIV); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("wrong IV: expected ErrAuthFailed, got %v", err)
+	}
+	if _, err := mode.Open(cipher, testKey256, sealed, testIV16, []byte("aad")); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("unexpected AAD: expected ErrAuthFailed, got %v", err)
+	}
+
+	versioned := append([]byte(nil), sealed...)
+	versioned[0] = EtMVersion + 1
+	if _, err := mode.Decrypt(cipher, testKey256, versioned, testIV16); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("unknown version: expected ErrUnsupportedVersion, got %v", err)
+	}
+	if _, err := mode.Decrypt(cipher, testKey256, sealed[:EtMTagSize], testIV16); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("short payload: expected ErrAuthFailed, got %v", err)
+	}
+}
+
+func TestGetModeEncryptThenMACNames(t *testing.T) {
+	for _, name := range []string{"GCM" + EtMSuffix, "XTS" + EtMSuffix, "CBC" + EtMSuffix + EtMSuffix, EtMSuffix} {
+		if mode := GetMode(name); mode != nil {
+			t.Fatalf("expected nil for %s, got %s", name, mode.Name())
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package modes
+
+import (
+	"bytes"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/padding"
+)
+
+// Fuzz targets run their seed corpus with go test; to fuzz one, run e.g.
+//
+//	go test -run '^$' -fuzz FuzzUnpad ./server/internal/pkg/encryption/modes
+
+var fuzzPaddings = []string{"ZEROS", "PKCS7", "ANSI_X923", "ISO_10126"}
+
+// fuzzModes are every mode GetMode knows, by name
+var fuzzModes = []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR", "RANDOM_DELTA", "GCM", "CBC+HMAC", "CTR+HMAC"}
+
+// FuzzUnpad feeds arbitrary data to every Unpad, which must either fail or
+// return a prefix of the data
+func FuzzUnpad(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0})
+	f.Add([]byte{16})
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 255})
+	f.Add(bytes.Repeat([]byte{4}, 4))
+	f.Add([]byte{'a', 0, 0, 3})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, name := range fuzzPaddings {
+			unpadded, err := padding.GetPadder(name).Unpad(append([]byte(nil), data...))
+			if err != nil {
+				continue
+			}
+			if !bytes.HasPrefix(data, unpadded) {
+				t.Fatalf("%s: Unpad(%x) = %x, not a prefix", name, data, unpadded)
+			}
+		}
+	})
+}
+
+// FuzzPadRoundTrip checks that Unpad reverses Pad for any data and block size
+func FuzzPadRoundTrip(f *testing.F) {
+	f.Add([]byte("Hello, World!"), uint8(16))
+	f.Add([]byte{}, uint8(8))
+	f.Add(bytes.Repeat([]byte{1}, 16), uint8(16))
+
+	f.Fuzz(func(t *testing.T, data []byte, blockSize uint8) {
+		if blockSize == 0 {
+			return
+		}
+		for _, name := range fuzzPaddings {
+			// Zero padding cannot tell trailing zeros of the data from padding
+			if name == "ZEROS" && len(data) > 0 && data[len(data)-1] == 0 {
+				continue
+			}
+			padder := padding.GetPadder(name)
+			padded := padder.Pad(append([]byte(nil), data...), int(blockSize))
+			if len(padded)%int(blockSize) != 0 {
+				t.Fatalf("%s: padded to %d bytes, not a multiple of %d", name, len(padded), blockSize)
+			}
+			unpadded, err := padder.Unpad(padded)
+			if err != nil {
+				t.Fatalf("%s: Unpad failed: %v", name, err)
+			}
+			if !bytes.Equal(unpadded, data) {
+				t.Fatalf("%s: round trip of %x gave %x", name, data, unpadded)
+			}
+		}
+	})
+}
+
+// FuzzModeDecrypt decrypts malformed ciphertexts with malformed IVs in every
+// mode, with a 64-bit and a 128-bit block cipher. Decrypt must return an
+// error or a result, never panic.
+func FuzzModeDecrypt(f *testing.F) {
+	f.Add([]byte{}, []byte{})
+	f.Add(make([]byte, 15), make([]byte, 16))
+	f.Add(make([]byte, 16), make([]byte, 8))
+	f.Add(make([]byte, 33), make([]byte, 12))
+	f.Add(make([]byte, 64), make([]byte, 17))
+
+	ciphers := []encryption.SymmetricCipher{getTestAES(), getTestLOKI97()}
+	f.Fuzz(func(t *testing.T, ciphertext, iv []byte) {
+		for _, cipher := range ciphers {
+			for _, name := range fuzzModes {
+				plaintext, err := GetMode(name).Decrypt(cipher, testKey256, ciphertext, iv)
+				if err == nil && len(plaintext) > len(ciphertext) {
+					t.Fatalf("%s/%s: %d bytes decrypted from %d", cipher.Name(), name, len(plaintext), len(ciphertext))
+				}
+			}
+		}
+	})
+}
+
+// FuzzModeRoundTrip encrypts arbitrary plaintext padded to the block size in
+// every mode and checks that it decrypts back
+func FuzzModeRoundTrip(f *testing.F) {
+	f.Add([]byte("Hello, World!"), []byte("0123456789ABCDEF"))
+	f.Add([]byte{}, make([]byte, 16))
+
+	padder := padding.GetPadder("PKCS7")
+	// Encrypt-then-MAC rekeys the cipher from the key, so each cipher goes
+	// with the key it was made with
+	ciphers := []struct {
+		encryption.SymmetricCipher
+		key []byte
+	}{{getTestAES(), testKey256}, {getTestLOKI97(), testKey128}}
+	f.Fuzz(func(t *testing.T, plaintext, ivSeed []byte) {
+		for _, cipher := range ciphers {
+			iv := make([]byte, cipher.BlockSize())
+			copy(iv, ivSeed)
+			padded := padder.Pad(append([]byte(nil), plaintext...), cipher.BlockSize())
+			for _, name := range fuzzModes {
+				if name == "GCM" && cipher.BlockSize() != 16 {
+					continue
+				}
+				mode := GetMode(name)
+				ciphertext, err := mode.Encrypt(cipher, cipher.key, padded, iv)
+				if err != nil {
+					t.Fatalf("%s/%s: encryption failed: %v", cipher.Name(), name, err)
+				}
+				decrypted, err := mode.Decrypt(cipher, cipher.key, ciphertext, iv)
+				if err != nil {
+					t.Fatalf("%s/%s: decryption failed: %v", cipher.Name(), name, err)
+				}
+				if !bytes.Equal(decrypted, padded) {
+					t.Fatalf("%s/%s: round trip failed", cipher.Name(), name)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package modes
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"MinMsgr/server/internal/pkg/encryption"
+)
+
+// GCMTagSize is the length of the authentication tag appended to GCM
+// ciphertext
+const GCMTagSize = 16
+
+// ErrAuthFailed is returned when authenticated decryption finds the
+// ciphertext, the additional data or the tag tampered with, or a wrong key
+// or IV
+var ErrAuthFailed = errors.New("message authentication failed")
+
+// GCMMode - Galois/Counter Mode (NIST SP 800-38D). Encrypts like CTR and
+// appends a tag over the ciphertext and the additional data. Only works with
+// 128-bit block ciphers. A 12-byte IV is used as is, other lengths are
+// hashed; an IV must never be reused with the same key.
+type GCMMode struct{}
+
+func (g *GCMMode) Name() string {
+	return "GCM"
+}
+
+func (g *GCMMode) RequiresIV() bool {
+	return true
+}
+
+func (g *GCMMode) Encrypt(cipher encryption.SymmetricCipher, key []byte, plaintext []byte, iv []byte) ([]byte, error) {
+	return g.Seal(cipher, key, plaintext, iv, nil)
+}
+
+func (g *GCMMode) Decrypt(cipher encryption.SymmetricCipher, key []byte, ciphertext []byte, iv []byte) ([]byte, error) {
+	return g.Open(cipher, key, ciphertext, iv, nil)
+}
+
+// Seal encrypts plaintext and returns the ciphertext followed by a tag that
+// also covers aad. aad itself is not encrypted or included in the output.
+func (g *GCMMode) Seal(cipher encryption.SymmetricCipher, key, plaintext, iv, aad []byte) ([]byte, error) {
+	h, j0, err := gcmInit(cipher, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(plaintext)+GCMTagSize)
+	counter := append([]byte(nil), j0...)
+	incrementCounter32(counter)
+	if err := gcmCTR(cipher, counter, out[:len(plaintext)], plaintext); err != nil {
+		return nil, err
+	}
+
+	tag, err := gcmTag(cipher, h, j0, aad, out[:len(plaintext)])
+	if err != nil {
+		return nil, err
+	}
+	copy(out[len(plaintext):], tag)
+	return out, nil
+}
+
+// Open checks the tag of ciphertext and aad and decrypts it. It returns
+// ErrAuthFailed, and no plaintext, if the tag does not match.
+func (g *GCMMode) Open(cipher encryption.SymmetricCipher, key, ciphertext, iv, aad []byte) ([]byte, error) {
+	h, j0, err := gcmInit(cipher, iv)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < GCMTagSize {
+		return nil, ErrAuthFailed
+	}
+
+	body := ciphertext[:len(ciphertext)-GCMTagSize]
+	tag, err := gcmTag(cipher, h, j0, aad, body)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(tag, ciphertext[len(body):]) != 1 {
+		return nil, ErrAuthFailed
+	}
+
+	plaintext := make([]byte, len(body))
+	counter := append([]byte(nil), j0...)
+	incrementCounter32(counter)
+	if err := gcmCTR(cipher, counter, plaintext, body); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// gcmInit derives the hash key H and the pre-counter block J0
+func gcmInit(cipher encryption.SymmetricCipher, iv []byte) ([]byte, []byte, error) {
+	if cipher.BlockSize() != 16 {
+		return nil, nil, fmt.Errorf("GCM requires a 128-bit block cipher, %s has %d-byte blocks", cipher.Name(), cipher.BlockSize())
+	}
+	if len(iv) == 0 {
+		return nil, nil, fmt.Errorf("IV must not be empty")
+	}
+
+	h := make([]byte, 16)
+	if err := cipher.EncryptBlock(h, h); err != nil {
+		return nil, nil, err
+	}
+
+	j0 := make([]byte, 16)
+	if len(iv) == 12 {
+		copy(j0, iv)
+		j0[15] = 1
+	} else {
+		var lengths [16]byte
+		binary.BigEndian.PutUint64(lengths[8:], uint64(len(iv))*8)
+		var y [16]byte
+		ghashUpdate(h, &y, iv)
+		ghashUpdate(h, &y, lengths[:])
+		copy(j0, y[:])
+	}
+	return h, j0, nil
+}
+
+// gcmTag computes the tag over aad and ciphertext
+func gcmTag(cipher encryption.SymmetricCipher, h, j0, aad, ciphertext []byte) ([]byte, error) {
+	var lengths [16]byte
+	binary.BigEndian.PutUint64(lengths[:8], uint64(len(aad))*8)
+	binary.BigEndian.PutUint64(lengths[8:], uint64(len(ciphertext))*8)
+
+	var y [16]byte
+	ghashUpdate(h, &y, aad)
+	ghashUpdate(h, &y, ciphertext)
+	ghashUpdate(h, &y, lengths[:])
+
+	tag := make([]byte, 16)
+	if err := gcmCTR(cipher, append([]byte(nil), j0...), tag, y[:]); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+// gcmCTR XORs src with the keystream starting at counter into dst,
+// incrementing the low 32 bits of counter per block
+func gcmCTR(cipher encryption.SymmetricCipher, counter, dst, src []byte) error {
+	keystream := make([]byte, 16)
+	for i := 0; i < len(src); i += 16 {
+		if err := cipher.EncryptBlock(keystream, counter); err != nil {
+			return err
+		}
+		for j := 0; j < 16 && i+j < len(src); j++ {
+			dst[i+j] = src[i+j] ^ keystream[j]
+		}
+		incrementCounter32(counter)
+	}
+	return nil
+}
+
+// ghashUpdate absorbs data, zero-padded to whole blocks, into the GHASH
+// state y
+func ghashUpdate(h []byte, y *[16]byte, data []byte) {
+	for i := 0; i < len(data); i += 16 {
+		for j := 0; j < 16 && i+j < len(data); j++ {
+			y[j] ^= data[i+j]
+		}
+		gfMul(y, h)
+	}
+}
+
+// gfMul sets x to x·h in GF(2^128) with the GCM bit order
+func gfMul(x *[16]byte, h []byte) {
+	var zHi, zLo uint64
+	vHi, vLo := binary.BigEndian.Uint64(h[:8]), binary.BigEndian.Uint64(h[8:])
+	for i := 0; i < 128; i++ {
+		if x[i/8]&(0x80>>(i%8)) != 0 {
+			zHi ^= vHi
+			zLo ^= vLo
+		}
+		lsb := vLo & 1
+		vLo = vLo>>1 | vHi<<63
+		vHi >>= 1
+		if lsb != 0 {
+			vHi ^= 0xe1 << 56
+		}
+	}
+	binary.BigEndian.PutUint64(x[:8], zHi)
+	binary.BigEndian.PutUint64(x[8:], zLo)
+}
+
+// incrementCounter32 increments the last four bytes of a GCM counter block,
+// wrapping around without carrying into the rest
+func incrementCounter32(counter []byte) {
+	incrementCounter(counter[len(counter)-4:])
+}
@@ -0,0 +1,86 @@
+package modes
+
+import (
+	"bytes"
+	stdaes "crypto/aes"
+	stdcipher "crypto/cipher"
+	"errors"
+	"testing"
+)
+
+// TestGCMMatchesStandardLibrary compares the output with crypto/cipher for
+// the standard 12-byte IV and a hashed 16-byte IV
+func TestGCMMatchesStandardLibrary(t *testing.T) {
+	block, err := stdaes.NewCipher(testKey256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode := &GCMMode{}
+	aad := []byte("chat 42")
+
+	for _, ivLen := range []int{12, 16} {
+		std, err := stdcipher.NewGCMWithNonceSize(block, ivLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iv := testIV16[:ivLen]
+		for _, n := range []int{0, 1, 16, 33, 100} {
+			plaintext := bytes.Repeat([]byte{0x5a}, n)
+			expected := std.Seal(nil, iv, plaintext, aad)
+
+			sealed, err := mode.Seal(getTestAES(), testKey256, plaintext, iv, aad)
+			if err != nil {
+				t.Fatalf("GCM seal failed: %v", err)
+			}
+			if !bytes.Equal(sealed, expected) {
+				t.Fatalf("IV %d, %d bytes: expected %x, got %x", ivLen, n, expected, sealed)
+			}
+
+			opened, err := mode.Open(getTestAES(), testKey256, sealed, iv, aad)
+			if err != nil {
+				t.Fatalf("GCM open failed: %v", err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Fatalf("GCM open mismatch: expected %x, got %x", plaintext, opened)
+			}
+		}
+	}
+}
+
+func TestGCMDetectsTampering(t *testing.T) {
+	mode := &GCMMode{}
+	cipher := getTestMARS()
+	plaintext := []byte("Hello, World! Authenticated.")
+	aad := []byte("header")
+
+	sealed, err := mode.Seal(cipher, testKey256, plaintext, testIV16, aad)
+	if err != nil {
+		t.Fatalf("GCM seal failed: %v", err)
+	}
+	if len(sealed) != len(plaintext)+GCMTagSize {
+		t.Fatalf("expected %d bytes, got %d", len(plaintext)+GCMTagSize, len(sealed))
+	}
+
+	for i := range sealed {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		if _, err := mode.Open(cipher, testKey256, tampered, testIV16, aad); !errors.Is(err, ErrAuthFailed) {
+			t.Fatalf("byte %d flipped: expected ErrAuthFailed, got %v", i, err)
+		}
+	}
+	if _, err := mode.Open(cipher, testKey256, sealed, testIV16, []byte("other")); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("wrong AAD: expected ErrAuthFailed, got %v", err)
+	}
+	if _, err := mode.Decrypt(cipher, testKey256, sealed, testIV16); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("missing AAD: expected ErrAuthFailed, got %v", err)
+	}
+	if _, err := mode.Open(cipher, testKey256, sealed[:GCMTagSize-1], testIV16, aad); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("short ciphertext: expected ErrAuthFailed, got %v", err)
+	}
+}
+
+func TestGCMRejects64BitBlockCipher(t *testing.T) {
+	if _, err := GetMode("GCM").Encrypt(getTestLOKI97(), testKey128, make([]byte, 16), testIV8); err == nil {
+		t.Fatal("expected error for GCM with LOKI97")
+	}
+}
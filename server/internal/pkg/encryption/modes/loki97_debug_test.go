@@ -18,14 +18,14 @@ func TestLOKI97DebugDirectBlock(t *testing.T) {
 	plaintext := []byte("12345678")
 	t.Logf("Plaintext:  %s (%s)", plaintext, hex.EncodeToString(plaintext))
 
-	encrypted, err := cipher.Encrypt(testKey128, plaintext)
-	if err != nil {
+	encrypted := make([]byte, len(plaintext))
+	if err := cipher.EncryptBlock(encrypted, plaintext); err != nil {
 		t.Fatalf("Encrypt failed: %v", err)
 	}
 	t.Logf("Encrypted:  %s (%s)", encrypted, hex.EncodeToString(encrypted))
 
-	decrypted, err := cipher.Decrypt(testKey128, encrypted)
-	if err != nil {
+	decrypted := make([]byte, len(encrypted))
+	if err := cipher.DecryptBlock(decrypted, encrypted); err != nil {
 		t.Fatalf("Decrypt failed: %v", err)
 	}
 	t.Logf("Decrypted:  %s (%s)", decrypted, hex.EncodeToString(decrypted))
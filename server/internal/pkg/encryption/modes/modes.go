@@ -1,13 +1,15 @@
 package modes
 
 import (
-	"crypto/rand"
 	"fmt"
+	"strings"
 
 	"MinMsgr/server/internal/pkg/encryption"
 )
 
-// Mode interface defines the encryption mode contract
+// Mode interface defines the encryption mode contract. The cipher is keyed
+// already; key is the chat key, only used by modes that derive keys of their
+// own such as encrypt-then-MAC.
 type Mode interface {
 	Encrypt(cipher encryption.SymmetricCipher, key []byte, plaintext []byte, iv []byte) ([]byte, error)
 	Decrypt(cipher encryption.SymmetricCipher, key []byte, ciphertext []byte, iv []byte) ([]byte, error)
@@ -34,11 +36,9 @@ func (e *ECBMode) Encrypt(cipher encryption.SymmetricCipher, key []byte, plainte
 
 	ciphertext := make([]byte, len(plaintext))
 	for i := 0; i < len(plaintext); i += blockSize {
-		block, err := cipher.Encrypt(key, plaintext[i:i+blockSize])
-		if err != nil {
+		if err := cipher.EncryptBlock(ciphertext[i:i+blockSize], plaintext[i:i+blockSize]); err != nil {
 			return nil, err
 		}
-		copy(ciphertext[i:], block)
 	}
 
 	return ciphertext, nil
@@ -52,11 +52,9 @@ func (e *ECBMode) Decrypt(cipher encryption.SymmetricCipher, key []byte, ciphert
 
 	plaintext := make([]byte, len(ciphertext))
 	for i := 0; i < len(ciphertext); i += blockSize {
-		block, err := cipher.Decrypt(key, ciphertext[i:i+blockSize])
-		if err != nil {
+		if err := cipher.DecryptBlock(plaintext[i:i+blockSize], ciphertext[i:i+blockSize]); err != nil {
 			return nil, err
 		}
-		copy(plaintext[i:], block)
 	}
 
 	return plaintext, nil
@@ -83,23 +81,20 @@ func (c *CBCMode) Encrypt(cipher encryption.SymmetricCipher, key []byte, plainte
 	}
 
 	ciphertext := make([]byte, len(plaintext))
-	prevCipherBlock := make([]byte, blockSize)
-	copy(prevCipherBlock, iv)
+	prevCipherBlock := iv
 
 	for i := 0; i < len(plaintext); i += blockSize {
 		// XOR plaintext with previous ciphertext
-		block := make([]byte, blockSize)
+		block := ciphertext[i : i+blockSize]
 		for j := 0; j < blockSize; j++ {
 			block[j] = plaintext[i+j] ^ prevCipherBlock[j]
 		}
 
-		// Encrypt
-		encryptedBlock, err := cipher.Encrypt(key, block)
-		if err != nil {
+		// Encrypt in place
+		if err := cipher.EncryptBlock(block, block); err != nil {
 			return nil, err
 		}
-		copy(ciphertext[i:], encryptedBlock)
-		copy(prevCipherBlock, encryptedBlock)
+		prevCipherBlock = block
 	}
 
 	return ciphertext, nil
@@ -115,21 +110,19 @@ func (c *CBCMode) Decrypt(cipher encryption.SymmetricCipher, key []byte, ciphert
 	}
 
 	plaintext := make([]byte, len(ciphertext))
-	prevCipherBlock := make([]byte, blockSize)
-	copy(prevCipherBlock, iv)
+	prevCipherBlock := iv
 
 	for i := 0; i < len(ciphertext); i += blockSize {
 		// Decrypt
-		decryptedBlock, err := cipher.Decrypt(key, ciphertext[i:i+blockSize])
-		if err != nil {
+		if err := cipher.DecryptBlock(plaintext[i:i+blockSize], ciphertext[i:i+blockSize]); err != nil {
 			return nil, err
 		}
 
 		// XOR with previous ciphertext
 		for j := 0; j < blockSize; j++ {
-			plaintext[i+j] = decryptedBlock[j] ^ prevCipherBlock[j]
+			plaintext[i+j] ^= prevCipherBlock[j]
 		}
-		copy(prevCipherBlock, ciphertext[i:i+blockSize])
+		prevCipherBlock = ciphertext[i : i+blockSize]
 	}
 
 	return plaintext, nil
@@ -161,21 +154,19 @@ func (p *PCBCMode) Encrypt(cipher encryption.SymmetricCipher, key []byte, plaint
 
 	for i := 0; i < len(plaintext); i += blockSize {
 		// XOR with previous result
-		block := make([]byte, blockSize)
+		block := ciphertext[i : i+blockSize]
 		for j := 0; j < blockSize; j++ {
 			block[j] = plaintext[i+j] ^ prev[j]
 		}
 
-		// Encrypt
-		encryptedBlock, err := cipher.Encrypt(key, block)
-		if err != nil {
+		// Encrypt in place
+		if err := cipher.EncryptBlock(block, block); err != nil {
 			return nil, err
 		}
-		copy(ciphertext[i:], encryptedBlock)
 
 		// Update previous (XOR plaintext and ciphertext)
 		for j := 0; j < blockSize; j++ {
-			prev[j] = plaintext[i+j] ^ encryptedBlock[j]
+			prev[j] = plaintext[i+j] ^ block[j]
 		}
 	}
 
@@ -197,14 +188,13 @@ func (p *PCBCMode) Decrypt(cipher encryption.SymmetricCipher, key []byte, cipher
 
 	for i := 0; i < len(ciphertext); i += blockSize {
 		// Decrypt
-		decryptedBlock, err := cipher.Decrypt(key, ciphertext[i:i+blockSize])
-		if err != nil {
+		if err := cipher.DecryptBlock(plaintext[i:i+blockSize], ciphertext[i:i+blockSize]); err != nil {
 			return nil, err
 		}
 
 		// XOR with previous value
 		for j := 0; j < blockSize; j++ {
-			plaintext[i+j] = decryptedBlock[j] ^ prev[j]
+			plaintext[i+j] ^= prev[j]
 		}
 
 		// Update previous (XOR plaintext and ciphertext)
@@ -236,6 +226,7 @@ func (c *CFBMode) Encrypt(cipher encryption.SymmetricCipher, key []byte, plainte
 	ciphertext := make([]byte, len(plaintext))
 	register := make([]byte, blockSize)
 	copy(register, iv)
+	encrypted := make([]byte, blockSize)
 
 	for i := 0; i < len(plaintext); i += blockSize {
 		endIdx := i + blockSize
@@ -245,8 +236,7 @@ func (c *CFBMode) Encrypt(cipher encryption.SymmetricCipher, key []byte, plainte
 		blockLen := endIdx - i
 
 		// Encrypt the register
-		encrypted, err := cipher.Encrypt(key, register)
-		if err != nil {
+		if err := cipher.EncryptBlock(encrypted, register); err != nil {
 			return nil, err
 		}
 
@@ -272,6 +262,7 @@ func (c *CFBMode) Decrypt(cipher encryption.SymmetricCipher, key []byte, ciphert
 	plaintext := make([]byte, len(ciphertext))
 	register := make([]byte, blockSize)
 	copy(register, iv)
+	encrypted := make([]byte, blockSize)
 
 	for i := 0; i < len(ciphertext); i += blockSize {
 		endIdx := i + blockSize
@@ -281,8 +272,7 @@ func (c *CFBMode) Decrypt(cipher encryption.SymmetricCipher, key []byte, ciphert
 		blockLen := endIdx - i
 
 		// Encrypt the register
-		encrypted, err := cipher.Encrypt(key, register)
-		if err != nil {
+		if err := cipher.EncryptBlock(encrypted, register); err != nil {
 			return nil, err
 		}
 
@@ -327,19 +317,15 @@ func (o *OFBMode) Encrypt(cipher encryption.SymmetricCipher, key []byte, plainte
 		}
 		blockLen := endIdx - i
 
-		// Generate keystream
-		generated, err := cipher.Encrypt(key, keystream)
-		if err != nil {
+		// Generate keystream, feeding back the previous one
+		if err := cipher.EncryptBlock(keystream, keystream); err != nil {
 			return nil, err
 		}
 
 		// XOR with plaintext
 		for j := 0; j < blockLen; j++ {
-			ciphertext[i+j] = plaintext[i+j] ^ generated[j]
+			ciphertext[i+j] = plaintext[i+j] ^ keystream[j]
 		}
-
-		// Update keystream
-		copy(keystream, generated)
 	}
 
 	return ciphertext, nil
@@ -370,6 +356,7 @@ func (c *CTRMode) Encrypt(cipher encryption.SymmetricCipher, key []byte, plainte
 	ciphertext := make([]byte, len(plaintext))
 	counter := make([]byte, blockSize)
 	copy(counter, iv)
+	keystream := make([]byte, blockSize)
 
 	for i := 0; i < len(plaintext); i += blockSize {
 		endIdx := i + blockSize
@@ -379,8 +366,7 @@ func (c *CTRMode) Encrypt(cipher encryption.SymmetricCipher, key []byte, plainte
 		blockLen := endIdx - i
 
 		// Encrypt counter
-		keystream, err := cipher.Encrypt(key, counter)
-		if err != nil {
+		if err := cipher.EncryptBlock(keystream, counter); err != nil {
 			return nil, err
 		}
 
@@ -401,7 +387,11 @@ func (c *CTRMode) Decrypt(cipher encryption.SymmetricCipher, key []byte, ciphert
 	return c.Encrypt(cipher, key, ciphertext, iv)
 }
 
-// RandomDeltaMode - Stream cipher mode with random delta
+// RandomDeltaMode - Stream cipher mode with a keyed pseudo-random delta.
+// The state starts at the IV and advances by delta = E(IV) (made odd, so the
+// state only repeats after 2^(8*blockSize) blocks) after every block; each
+// state is encrypted into the keystream. Delta depends only on the key and
+// IV, so decryption rebuilds the same keystream.
 type RandomDeltaMode struct{}
 
 func (r *RandomDeltaMode) Name() string {
@@ -421,6 +411,14 @@ func (r *RandomDeltaMode) Encrypt(cipher encryption.SymmetricCipher, key []byte,
 	ciphertext := make([]byte, len(plaintext))
 	state := make([]byte, blockSize)
 	copy(state, iv)
+	keystream := make([]byte, blockSize)
+
+	// Derive the delta from the IV
+	delta := make([]byte, blockSize)
+	if err := cipher.EncryptBlock(delta, iv); err != nil {
+		return nil, err
+	}
+	delta[blockSize-1] |= 1
 
 	for i := 0; i < len(plaintext); i += blockSize {
 		endIdx := i + blockSize
@@ -430,8 +428,7 @@ func (r *RandomDeltaMode) Encrypt(cipher encryption.SymmetricCipher, key []byte,
 		blockLen := endIdx - i
 
 		// Generate keystream
-		keystream, err := cipher.Encrypt(key, state)
-		if err != nil {
+		if err := cipher.EncryptBlock(keystream, state); err != nil {
 			return nil, err
 		}
 
@@ -440,56 +437,26 @@ func (r *RandomDeltaMode) Encrypt(cipher encryption.SymmetricCipher, key []byte,
 			ciphertext[i+j] = plaintext[i+j] ^ keystream[j]
 		}
 
-		// Generate random delta and add to state
-		delta := make([]byte, blockSize)
-		rand.Read(delta)
-		for j := 0; j < blockSize; j++ {
-			state[j] ^= delta[j]
-		}
+		// Advance the state by delta
+		addBlocks(state, delta)
 	}
 
 	return ciphertext, nil
 }
 
 func (r *RandomDeltaMode) Decrypt(cipher encryption.SymmetricCipher, key []byte, ciphertext []byte, iv []byte) ([]byte, error) {
-	// For random delta, we need to store the deltas
-	// This is simplified - in production, deltas should be transmitted with ciphertext
-	blockSize := cipher.BlockSize()
-	if len(iv) != blockSize {
-		return nil, fmt.Errorf("IV length must be %d", blockSize)
-	}
-
-	plaintext := make([]byte, len(ciphertext))
-	state := make([]byte, blockSize)
-	copy(state, iv)
-
-	for i := 0; i < len(ciphertext); i += blockSize {
-		endIdx := i + blockSize
-		if endIdx > len(ciphertext) {
-			endIdx = len(ciphertext)
-		}
-		blockLen := endIdx - i
-
-		// Generate keystream
-		keystream, err := cipher.Encrypt(key, state)
-		if err != nil {
-			return nil, err
-		}
-
-		// XOR with ciphertext
-		for j := 0; j < blockLen; j++ {
-			plaintext[i+j] = ciphertext[i+j] ^ keystream[j]
-		}
+	// RANDOM_DELTA decryption is the same as encryption
+	return r.Encrypt(cipher, key, ciphertext, iv)
+}
 
-		// Generate random delta and add to state
-		delta := make([]byte, blockSize)
-		rand.Read(delta)
-		for j := 0; j < blockSize; j++ {
-			state[j] ^= delta[j]
-		}
+// addBlocks adds delta to state as big-endian integers, modulo 2^(8*len)
+func addBlocks(state, delta []byte) {
+	var carry uint16
+	for i := len(state) - 1; i >= 0; i-- {
+		sum := uint16(state[i]) + uint16(delta[i]) + carry
+		state[i] = byte(sum)
+		carry = sum >> 8
 	}
-
-	return plaintext, nil
 }
 
 // Helper function to increment counter
@@ -502,6 +469,32 @@ func incrementCounter(counter []byte) {
 	}
 }
 
+// baseModes are the modes GetMode knows by name, without the
+// encrypt-then-MAC variants
+var baseModes = []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR", "RANDOM_DELTA", "GCM"}
+
+// Names returns the name of every mode GetMode accepts, each base mode
+// followed by its encrypt-then-MAC variant if it has one
+func Names() []string {
+	var names []string
+	for _, name := range baseModes {
+		names = append(names, name)
+		if GetMode(name+EtMSuffix) != nil {
+			names = append(names, name+EtMSuffix)
+		}
+	}
+	return names
+}
+
+// RequiredBlockSize returns the only cipher block size the named mode works
+// with, or 0 if it works with any
+func RequiredBlockSize(modeName string) int {
+	if modeName == "GCM" {
+		return 16
+	}
+	return 0
+}
+
 // GetMode returns a Mode implementation for the given mode name
 func GetMode(modeName string) Mode {
 	switch modeName {
@@ -519,7 +512,12 @@ func GetMode(modeName string) Mode {
 		return &CTRMode{}
 	case "RANDOM_DELTA":
 		return &RandomDeltaMode{}
+	case "GCM":
+		return &GCMMode{}
 	default:
+		if strings.HasSuffix(modeName, EtMSuffix) {
+			return getEncryptThenMAC(modeName)
+		}
 		return nil
 	}
 }
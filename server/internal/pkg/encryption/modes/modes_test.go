@@ -2,6 +2,10 @@ package modes
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	"MinMsgr/server/internal/pkg/encryption"
@@ -19,6 +23,16 @@ func getTestLOKI97() encryption.SymmetricCipher {
 	return cipher
 }
 
+func getTestAES() encryption.SymmetricCipher {
+	cipher, _ := encryption.NewAES(testKey256)
+	return cipher
+}
+
+func getTestMARS() encryption.SymmetricCipher {
+	cipher, _ := encryption.NewMARS(testKey256)
+	return cipher
+}
+
 // Test keys and IVs
 var (
 	testKey256 = []byte("0123456789ABCDEF0123456789ABCDEF") // 32 bytes for RC6
@@ -184,6 +198,69 @@ func TestRandomDeltaModeRC6(t *testing.T) {
 	}
 }
 
+// TestRandomDeltaKeystream checks that RANDOM_DELTA encrypts the states
+// IV, IV+delta, IV+2*delta, ... with delta = E(IV) made odd, and that a
+// message spanning several blocks round-trips
+func TestRandomDeltaKeystream(t *testing.T) {
+	cipher := getTestAES()
+	mode := &RandomDeltaMode{}
+	plaintext := make([]byte, 40)
+
+	// With a zero plaintext the ciphertext is the keystream itself
+	keystream, err := mode.Encrypt(cipher, testKey256, plaintext, testIV16)
+	if err != nil {
+		t.Fatalf("RANDOM_DELTA encryption failed: %v", err)
+	}
+
+	delta := make([]byte, 16)
+	cipher.EncryptBlock(delta, testIV16)
+	delta[15] |= 1
+	state := append([]byte(nil), testIV16...)
+	for i := 0; i < len(plaintext); i += 16 {
+		expected := make([]byte, 16)
+		cipher.EncryptBlock(expected, state)
+		end := i + 16
+		if end > len(plaintext) {
+			end = len(plaintext)
+		}
+		if !bytes.Equal(keystream[i:end], expected[:end-i]) {
+			t.Fatalf("block %d: expected keystream %x, got %x", i/16, expected[:end-i], keystream[i:end])
+		}
+		addBlocks(state, delta)
+	}
+
+	message := []byte("Hello, World! This message spans several blocks of RANDOM_DELTA.")
+	encrypted, err := mode.Encrypt(cipher, testKey256, message, testIV16)
+	if err != nil {
+		t.Fatalf("RANDOM_DELTA encryption failed: %v", err)
+	}
+	decrypted, err := mode.Decrypt(cipher, testKey256, encrypted, testIV16)
+	if err != nil {
+		t.Fatalf("RANDOM_DELTA decryption failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, message) {
+		t.Fatalf("RANDOM_DELTA round-trip failed: expected %s, got %s", message, decrypted)
+	}
+
+	otherIV := []byte("FEDCBA9876543210")
+	if other, _ := mode.Encrypt(cipher, testKey256, message, otherIV); bytes.Equal(other, encrypted) {
+		t.Fatal("RANDOM_DELTA produced the same ciphertext for different IVs")
+	}
+}
+
+func TestAddBlocks(t *testing.T) {
+	state := []byte{0x00, 0xff, 0xff}
+	addBlocks(state, []byte{0x00, 0x00, 0x01})
+	if !bytes.Equal(state, []byte{0x01, 0x00, 0x00}) {
+		t.Fatalf("carry not propagated: %x", state)
+	}
+	state = []byte{0xff, 0xff}
+	addBlocks(state, []byte{0x00, 0x02})
+	if !bytes.Equal(state, []byte{0x00, 0x01}) {
+		t.Fatalf("sum not reduced modulo 2^16: %x", state)
+	}
+}
+
 // Test all modes with LOKI97 (skipped due to LOKI97 cipher implementation)
 func TestECBModeLOKI97(t *testing.T) {
 	t.Skip("LOKI97 cipher implementation needs verification")
@@ -272,7 +349,7 @@ func TestISO10126Padding(t *testing.T) {
 
 // Test GetMode factory function
 func TestGetMode(t *testing.T) {
-	modes := []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR", "RANDOM_DELTA"}
+	modes := []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR", "RANDOM_DELTA", "GCM"}
 	for _, modeName := range modes {
 		mode := GetMode(modeName)
 		if mode == nil {
@@ -298,6 +375,29 @@ func TestGetPadder(t *testing.T) {
 	}
 }
 
+// TestNames checks that the mode and padding listings match what the
+// factories accept
+func TestNames(t *testing.T) {
+	names := Names()
+	want := "ECB,ECB+HMAC,CBC,CBC+HMAC,PCBC,PCBC+HMAC,CFB,CFB+HMAC,OFB,OFB+HMAC,CTR,CTR+HMAC,RANDOM_DELTA,RANDOM_DELTA+HMAC,GCM"
+	if got := strings.Join(names, ","); got != want {
+		t.Fatalf("Names() = %s, want %s", got, want)
+	}
+	for _, name := range names {
+		if mode := GetMode(name); mode == nil || mode.Name() != name {
+			t.Errorf("GetMode(%q) does not return the mode", name)
+		}
+	}
+	if GetMode("GCM"+EtMSuffix) != nil {
+		t.Error("GCM+HMAC accepted")
+	}
+	for _, name := range padding.Names() {
+		if p := padding.GetPadder(name); p == nil || p.Name() != name {
+			t.Errorf("GetPadder(%q) does not return the scheme", name)
+		}
+	}
+}
+
 // Test that different modes produce different ciphertexts
 func TestDifferentModesProduceDifferentOutput(t *testing.T) {
 	cipher := getTestRC6()
@@ -348,9 +448,25 @@ func TestAllAlgorithmModePaddingCombinations(t *testing.T) {
 			cipher:    getTestLOKI97(),
 			blockSize: 8,
 		},
+		{
+			name:      "AES",
+			algorithm: "AES",
+			key:       testKey256,
+			iv:        testIV16,
+			cipher:    getTestAES(),
+			blockSize: 16,
+		},
+		{
+			name:      "MARS",
+			algorithm: "MARS",
+			key:       testKey256,
+			iv:        testIV16,
+			cipher:    getTestMARS(),
+			blockSize: 16,
+		},
 	}
 
-	modeNames := []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR"} // Skip RANDOM_DELTA - uses random state
+	modeNames := []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR", "RANDOM_DELTA"}
 	paddingNames := []string{"ZEROS", "PKCS7", "ANSI_X923", "ISO_10126"}
 
 	totalTests := 0
@@ -413,11 +529,240 @@ func TestAllAlgorithmModePaddingCombinations(t *testing.T) {
 	}
 }
 
+// TestAESKnownAnswer checks AES against the FIPS-197 appendix C.1 vector
+func TestAESKnownAnswer(t *testing.T) {
+	key := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}
+	plaintext := []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	expected := []byte{0x69, 0xc4, 0xe0, 0xd8, 0x6a, 0x7b, 0x04, 0x30, 0xd8, 0xcd, 0xb7, 0x80, 0x70, 0xb4, 0xc5, 0x5a}
+
+	cipher, err := encryption.NewAES(key)
+	if err != nil {
+		t.Fatalf("NewAES failed: %v", err)
+	}
+	encrypted, err := (&ECBMode{}).Encrypt(cipher, key, plaintext, nil)
+	if err != nil {
+		t.Fatalf("AES encryption failed: %v", err)
+	}
+	if !bytes.Equal(encrypted, expected) {
+		t.Fatalf("AES known answer mismatch: expected %x, got %x", expected, encrypted)
+	}
+
+	if _, err := encryption.NewAES(testKey256[:20]); err == nil {
+		t.Fatal("expected error for a 20-byte AES key")
+	}
+}
+
+// TestMARSKnownAnswer checks MARS against the ECB vectors of the AES
+// submission
+func TestMARSKnownAnswer(t *testing.T) {
+	vectors := []struct {
+		key, plaintext, ciphertext string
+	}{
+		{"00000000000000000000000000000000", "00000000000000000000000000000000", "dcc07b8dfb0738d6e30a22dfcf27e886"},
+		{"00000000000000000000000000000000", "dcc07b8dfb0738d6e30a22dfcf27e886", "33caffbddc7f1dda0f9c15fa2f30e2ff"},
+	}
+	for _, v := range vectors {
+		key, _ := hex.DecodeString(v.key)
+		plaintext, _ := hex.DecodeString(v.plaintext)
+		expected, _ := hex.DecodeString(v.ciphertext)
+
+		cipher, err := encryption.NewMARS(key)
+		if err != nil {
+			t.Fatalf("NewMARS failed: %v", err)
+		}
+		encrypted := make([]byte, len(plaintext))
+		if err := cipher.EncryptBlock(encrypted, plaintext); err != nil {
+			t.Fatalf("MARS encryption failed: %v", err)
+		}
+		if !bytes.Equal(encrypted, expected) {
+			t.Fatalf("MARS known answer mismatch: expected %x, got %x", expected, encrypted)
+		}
+		decrypted := make([]byte, len(encrypted))
+		if err := cipher.DecryptBlock(decrypted, encrypted); err != nil {
+			t.Fatalf("MARS decryption failed: %v", err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("MARS decryption mismatch: expected %x, got %x", plaintext, decrypted)
+		}
+	}
+
+	for _, size := range []int{16, 24, 32, 40, 56} {
+		if _, err := encryption.NewMARS(make([]byte, size)); err != nil {
+			t.Fatalf("NewMARS rejected a %d-byte key: %v", size, err)
+		}
+	}
+	for _, size := range []int{8, 18, 60} {
+		if _, err := encryption.NewMARS(make([]byte, size)); err == nil {
+			t.Fatalf("expected error for a %d-byte MARS key", size)
+		}
+	}
+}
+
+// TestLOKI97KeySizes pins the output of LOKI97 for each key size, the key
+// being 00 01 02 ... The 128-bit vector predates the longer keys, which must
+// not change it.
+func TestLOKI97KeySizes(t *testing.T) {
+	vectors := []struct {
+		name, ciphertext string
+		keySize          int
+	}{
+		{"LOKI97", "6c8e1994ba6bc0f0", 16},
+		{"LOKI97-192", "1113e15efdb4885c", 24},
+		{"LOKI97-256", "1eff53818d3d2175", 32},
+	}
+	plaintext, _ := hex.DecodeString("0011223344556677")
+	for _, v := range vectors {
+		key := make([]byte, v.keySize)
+		for i := range key {
+			key[i] = byte(i)
+		}
+		cipher, err := encryption.GetCipher(v.name, key)
+		if err != nil {
+			t.Fatalf("GetCipher(%s) failed: %v", v.name, err)
+		}
+		if cipher.KeySize() != v.keySize || cipher.Name() != v.name {
+			t.Fatalf("%s: got a %d-byte %s", v.name, cipher.KeySize(), cipher.Name())
+		}
+		encrypted := make([]byte, len(plaintext))
+		if err := cipher.EncryptBlock(encrypted, plaintext); err != nil {
+			t.Fatalf("%s encryption failed: %v", v.name, err)
+		}
+		if hex.EncodeToString(encrypted) != v.ciphertext {
+			t.Fatalf("%s known answer mismatch: expected %s, got %x", v.name, v.ciphertext, encrypted)
+		}
+		decrypted := make([]byte, len(encrypted))
+		if err := cipher.DecryptBlock(decrypted, encrypted); err != nil {
+			t.Fatalf("%s decryption failed: %v", v.name, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("%s decryption mismatch: expected %x, got %x", v.name, plaintext, decrypted)
+		}
+
+		// Every key size is registered under its own name only
+		if _, err := encryption.GetCipher(v.name, make([]byte, v.keySize+8)); !errors.Is(err, encryption.ErrInvalidKeySize) {
+			t.Fatalf("%s accepted a %d-byte key: %v", v.name, v.keySize+8, err)
+		}
+	}
+
+	// The high halves of the round keys come from the key bytes past 128 bits
+	key := bytes.Repeat([]byte{0x42}, 32)
+	a, _ := encryption.NewLOKI97(key)
+	key[31] ^= 1
+	b, _ := encryption.NewLOKI97(key)
+	outA, outB := make([]byte, 8), make([]byte, 8)
+	a.EncryptBlock(outA, plaintext)
+	b.EncryptBlock(outB, plaintext)
+	if bytes.Equal(outA, outB) {
+		t.Fatal("LOKI97-256 ignores the last key byte")
+	}
+
+	for _, size := range []int{8, 20, 40} {
+		if _, err := encryption.NewLOKI97(make([]byte, size)); err == nil {
+			t.Fatalf("expected error for a %d-byte LOKI97 key", size)
+		}
+	}
+}
+
+// TestRC6KnownAnswer checks RC6-32/20 against the vectors of the RC6
+// specification for each key size
+func TestRC6KnownAnswer(t *testing.T) {
+	vectors := []struct {
+		key, plaintext, ciphertext string
+	}{
+		{"00000000000000000000000000000000", "00000000000000000000000000000000", "8fc3a53656b1f778c129df4e9848a41e"},
+		{"0123456789abcdef0112233445566778", "02132435465768798a9bacbdcedfe0f1", "524e192f4715c6231f51f6367ea43f18"},
+		{"000000000000000000000000000000000000000000000000", "00000000000000000000000000000000", "6cd61bcb190b30384e8a3f168690ae82"},
+		{"0123456789abcdef0112233445566778899aabbccddeeff0", "02132435465768798a9bacbdcedfe0f1", "688329d019e505041e52e92af95291d4"},
+		{"0000000000000000000000000000000000000000000000000000000000000000", "00000000000000000000000000000000", "8f5fbd0510d15fa893fa3fda6e857ec2"},
+		{"0123456789abcdef0112233445566778899aabbccddeeff01032547698badcfe", "02132435465768798a9bacbdcedfe0f1", "c8241816f0d7e48920ad16a1674e5d48"},
+	}
+	for _, v := range vectors {
+		key, _ := hex.DecodeString(v.key)
+		plaintext, _ := hex.DecodeString(v.plaintext)
+
+		cipher, err := encryption.NewRC6(key)
+		if err != nil {
+			t.Fatalf("NewRC6 failed: %v", err)
+		}
+		encrypted := make([]byte, len(plaintext))
+		if err := cipher.EncryptBlock(encrypted, plaintext); err != nil {
+			t.Fatalf("RC6 encryption failed: %v", err)
+		}
+		if hex.EncodeToString(encrypted) != v.ciphertext {
+			t.Fatalf("RC6 known answer mismatch for a %d-byte key: expected %s, got %x", len(key), v.ciphertext, encrypted)
+		}
+		if cipher.KeySize() != len(key) || cipher.Rounds() != encryption.RC6Rounds {
+			t.Fatalf("RC6 reports a %d-byte key and %d rounds", cipher.KeySize(), cipher.Rounds())
+		}
+	}
+
+	for _, size := range []int{8, 20, 40} {
+		if _, err := encryption.NewRC6(make([]byte, size)); !errors.Is(err, encryption.ErrInvalidKeySize) {
+			t.Fatalf("expected ErrInvalidKeySize for a %d-byte RC6 key, got %v", size, err)
+		}
+	}
+}
+
+// TestRC6Rounds checks the RC6-w/r/b variants chats can name
+func TestRC6Rounds(t *testing.T) {
+	plaintext := []byte("0123456789abcdef")
+	var previous []byte
+	for _, rounds := range []int{1, 12, 20, 255} {
+		name := fmt.Sprintf("RC6-32/%d/24", rounds)
+		spec, ok := encryption.LookupCipher(name)
+		if !ok || spec.KeySize != 24 || spec.BlockSize != 16 {
+			t.Fatalf("LookupCipher(%s) = %+v, %v", name, spec, ok)
+		}
+		cipher, err := encryption.GetCipher(name, testKey256[:24])
+		if err != nil {
+			t.Fatalf("GetCipher(%s) failed: %v", name, err)
+		}
+		encrypted := make([]byte, 16)
+		decrypted := make([]byte, 16)
+		cipher.EncryptBlock(encrypted, plaintext)
+		cipher.DecryptBlock(decrypted, encrypted)
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("%s: round trip gave %x", name, decrypted)
+		}
+		if bytes.Equal(encrypted, previous) {
+			t.Fatalf("%s encrypts like the previous round count", name)
+		}
+		previous = encrypted
+
+		want := name
+		if rounds == encryption.RC6Rounds {
+			want = "RC6"
+		}
+		if cipher.Name() != want {
+			t.Fatalf("%s: Name() = %s", name, cipher.Name())
+		}
+	}
+
+	// The registered default is the same cipher as its explicit name
+	a, _ := encryption.GetCipher("RC6", testKey128)
+	b, _ := encryption.GetCipher("RC6-32/20/16", testKey128)
+	outA, outB := make([]byte, 16), make([]byte, 16)
+	a.EncryptBlock(outA, plaintext)
+	b.EncryptBlock(outB, plaintext)
+	if !bytes.Equal(outA, outB) {
+		t.Fatal("RC6-32/20/16 differs from RC6")
+	}
+
+	for _, name := range []string{"RC6-16/20/16", "RC6-32/0/16", "RC6-32/256/16", "RC6-32/20/20", "RC6-32/20", "RC6-32/+20/16", "RC6-"} {
+		if _, ok := encryption.LookupCipher(name); ok {
+			t.Fatalf("LookupCipher accepted %s", name)
+		}
+	}
+	if _, err := encryption.GetCipher("RC6-32/12/16", testKey256); !errors.Is(err, encryption.ErrInvalidKeySize) {
+		t.Fatalf("expected ErrInvalidKeySize for a 32-byte key in RC6-32/12/16, got %v", err)
+	}
+}
+
 // TestRC6AllCombinations tests RC6 with all modes and paddings
 func TestRC6AllCombinations(t *testing.T) {
 	testMessage := []byte("Hello, World! This is a test message for encryption and decryption.")
 	cipher := getTestRC6()
-	modeNames := []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR"} // Exclude RANDOM_DELTA
+	modeNames := []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR", "RANDOM_DELTA"}
 	paddingNames := []string{"ZEROS", "PKCS7", "ANSI_X923", "ISO_10126"}
 
 	passedTests := 0
@@ -457,7 +802,7 @@ func TestRC6AllCombinations(t *testing.T) {
 func TestLOKI97AllCombinations(t *testing.T) {
 	testMessage := []byte("Hello, World! This is a test message for encryption and decryption.")
 	cipher := getTestLOKI97()
-	modeNames := []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR"} // Exclude RANDOM_DELTA
+	modeNames := []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR", "RANDOM_DELTA"}
 	paddingNames := []string{"ZEROS", "PKCS7", "ANSI_X923", "ISO_10126"}
 
 	passedTests := 0
@@ -492,3 +837,131 @@ func TestLOKI97AllCombinations(t *testing.T) {
 		t.Fatalf("LOKI97 tests failed: %d/%d passed", passedTests, totalTests)
 	}
 }
+
+// TestGetCipher checks that every cipher is registered and usable by name
+func TestGetCipher(t *testing.T) {
+	var names []string
+	for _, spec := range encryption.Ciphers() {
+		names = append(names, spec.Name)
+		c, err := encryption.GetCipher(spec.Name, make([]byte, spec.KeySize))
+		if err != nil {
+			t.Fatalf("GetCipher(%s) failed: %v", spec.Name, err)
+		}
+		if c.Name() != spec.Name || c.BlockSize() != spec.BlockSize {
+			t.Fatalf("%s: spec says %d-byte blocks, cipher %s has %d", spec.Name, spec.BlockSize, c.Name(), c.BlockSize())
+		}
+		for _, size := range spec.AcceptedKeySizes() {
+			c, err := encryption.GetCipher(spec.Name, make([]byte, size))
+			if err != nil {
+				t.Fatalf("%s: listed %d-byte key refused: %v", spec.Name, size, err)
+			}
+			if c.KeySize() != size {
+				t.Fatalf("%s: %d-byte key reported as %d", spec.Name, size, c.KeySize())
+			}
+		}
+	}
+	if got := strings.Join(encryption.Families(), ","); got != "RC6" {
+		t.Fatalf("unexpected cipher families: %s", got)
+	}
+	if got := strings.Join(names, ","); got != "AES,LOKI97,LOKI97-192,LOKI97-256,MARS,RC6" {
+		t.Fatalf("unexpected registered ciphers: %s", got)
+	}
+
+	if _, err := encryption.GetCipher("DES", make([]byte, 8)); !errors.Is(err, encryption.ErrUnknownCipher) {
+		t.Fatalf("expected ErrUnknownCipher, got %v", err)
+	}
+	if c, err := encryption.GetCipher("RC6", make([]byte, 4)); err == nil || c != nil {
+		t.Fatalf("expected a nil cipher and an error for a short key, got %v, %v", c, err)
+	}
+}
+
+// TestWipeCipher checks that wiping erases the key schedule of every cipher
+// that supports it, so the same block no longer encrypts the same way
+func TestWipeCipher(t *testing.T) {
+	for _, spec := range encryption.Ciphers() {
+		key := bytes.Repeat([]byte{0x42}, spec.KeySize)
+		c, err := encryption.GetCipher(spec.Name, key)
+		if err != nil {
+			t.Fatalf("GetCipher(%s) failed: %v", spec.Name, err)
+		}
+		if _, ok := c.(encryption.Wiper); !ok {
+			if spec.Name != "AES" {
+				t.Fatalf("%s does not implement Wiper", spec.Name)
+			}
+			continue
+		}
+
+		block := bytes.Repeat([]byte{0x17}, spec.BlockSize)
+		before := make([]byte, spec.BlockSize)
+		if err := c.EncryptBlock(before, block); err != nil {
+			t.Fatalf("%s: EncryptBlock failed: %v", spec.Name, err)
+		}
+		encryption.WipeCipher(c)
+		after := make([]byte, spec.BlockSize)
+		if err := c.EncryptBlock(after, block); err != nil {
+			t.Fatalf("%s: EncryptBlock failed: %v", spec.Name, err)
+		}
+		if bytes.Equal(before, after) {
+			t.Fatalf("%s: key schedule still in place after WipeCipher", spec.Name)
+		}
+	}
+}
+
+// TestBlockCiphersDoNotAllocate checks that the in-place block API of every
+// cipher encrypts and decrypts without allocating
+func TestBlockCiphersDoNotAllocate(t *testing.T) {
+	for _, spec := range encryption.Ciphers() {
+		c, err := encryption.GetCipher(spec.Name, make([]byte, spec.KeySize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := make([]byte, spec.BlockSize)
+		allocs := testing.AllocsPerRun(100, func() {
+			c.EncryptBlock(block, block)
+			c.DecryptBlock(block, block)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: %v allocations per block", spec.Name, allocs)
+		}
+	}
+}
+
+// TestModeAllocationsIndependentOfLength checks that no mode allocates per
+// block: a 64 KB message needs no more allocations than a single block
+func TestModeAllocationsIndependentOfLength(t *testing.T) {
+	key := make([]byte, 16)
+	c, err := encryption.GetCipher("RC6", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, 16)
+	for _, name := range []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR", "RANDOM_DELTA", "GCM", "CBC+HMAC"} {
+		mode := GetMode(name)
+		allocs := func(size int) float64 {
+			plaintext := make([]byte, size)
+			return testing.AllocsPerRun(10, func() {
+				ciphertext, err := mode.Encrypt(c, key, plaintext, iv)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := mode.Decrypt(c, key, ciphertext, iv); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+		if short, long := allocs(16), allocs(64<<10); long > short {
+			t.Errorf("%s: %v allocations for one block, %v for 4096 blocks", name, short, long)
+		}
+	}
+
+	data, _ := encryption.GetCipher("AES", key)
+	tweak, _ := encryption.GetCipher("AES", key)
+	x, err := NewXTS(data, tweak, XTSSectorSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sector := make([]byte, XTSSectorSize)
+	if allocs := testing.AllocsPerRun(10, func() { x.Encrypt(sector, sector, 0) }); allocs > 1 {
+		t.Errorf("XTS: %v allocations per sector", allocs)
+	}
+}
@@ -0,0 +1,166 @@
+package modes
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"MinMsgr/server/internal/pkg/encryption"
+)
+
+// XTSSectorSize is the sector size used for encrypted attachments. A blob
+// encrypted in XTS can be read from any sector boundary without decrypting
+// what comes before it.
+const XTSSectorSize = 4096
+
+// XTS - XEX-based tweaked codebook mode with ciphertext stealing (IEEE 1619).
+// Every sector is encrypted independently, tweaked by its number, so the
+// ciphertext has the size of the plaintext and any sector can be decrypted
+// or rewritten alone. Needs two independent keys of a 128-bit block cipher:
+// one for the data and one for the tweaks.
+//
+// XTS has no IV and no integrity; it is meant for stored blobs where the
+// position of the data is the only nonce available.
+type XTS struct {
+	data       encryption.SymmetricCipher
+	tweak      encryption.SymmetricCipher
+	sectorSize int
+}
+
+// NewXTS creates an XTS mode over data and tweak, two instances of the same
+// cipher keyed differently, for sectors of sectorSize bytes
+func NewXTS(data, tweak encryption.SymmetricCipher, sectorSize int) (*XTS, error) {
+	if data.BlockSize() != 16 || tweak.BlockSize() != 16 {
+		return nil, fmt.Errorf("XTS requires a 128-bit block cipher, %s has %d-byte blocks", data.Name(), data.BlockSize())
+	}
+	if sectorSize < 16 || sectorSize%16 != 0 {
+		return nil, fmt.Errorf("XTS sector size must be a positive multiple of 16, got %d", sectorSize)
+	}
+	return &XTS{data: data, tweak: tweak, sectorSize: sectorSize}, nil
+}
+
+// Wipe erases the key schedules of both ciphers. The XTS must not be used
+// again.
+func (x *XTS) Wipe() {
+	encryption.WipeCipher(x.data)
+	encryption.WipeCipher(x.tweak)
+}
+
+// SectorSize returns the size of the sectors in bytes
+func (x *XTS) SectorSize() int {
+	return x.sectorSize
+}
+
+// Encrypt encrypts src, which starts at sector firstSector, into dst. All
+// sectors are whole except possibly the last, which must still be at least
+// 16 bytes long.
+func (x *XTS) Encrypt(dst, src []byte, firstSector uint64) error {
+	return x.crypt(dst, src, firstSector, true)
+}
+
+// Decrypt reverses Encrypt. src may be any run of sectors of a blob as long
+// as firstSector is the number of its first one.
+func (x *XTS) Decrypt(dst, src []byte, firstSector uint64) error {
+	return x.crypt(dst, src, firstSector, false)
+}
+
+func (x *XTS) crypt(dst, src []byte, sector uint64, encrypt bool) error {
+	if len(dst) != len(src) {
+		return fmt.Errorf("XTS output must be %d bytes, got %d", len(src), len(dst))
+	}
+	for i := 0; i < len(src); i += x.sectorSize {
+		end := i + x.sectorSize
+		if end > len(src) {
+			end = len(src)
+		}
+		if err := x.cryptSector(dst[i:end], src[i:end], sector, encrypt); err != nil {
+			return err
+		}
+		sector++
+	}
+	return nil
+}
+
+// cryptSector encrypts or decrypts a single sector, stealing ciphertext for
+// a partial last block
+func (x *XTS) cryptSector(dst, src []byte, sector uint64, encrypt bool) error {
+	if len(src) < 16 {
+		return fmt.Errorf("XTS sector must be at least 16 bytes, got %d", len(src))
+	}
+
+	tweak := make([]byte, 16)
+	binary.LittleEndian.PutUint64(tweak, sector)
+	if err := x.tweak.EncryptBlock(tweak, tweak); err != nil {
+		return err
+	}
+
+	whole := len(src) / 16 * 16
+	tail := len(src) - whole
+	if tail > 0 {
+		// The last whole block takes part in the stealing below
+		whole -= 16
+	}
+
+	for i := 0; i < whole; i += 16 {
+		if err := x.cryptBlock(dst[i:i+16], src[i:i+16], tweak, encrypt); err != nil {
+			return err
+		}
+		mulAlpha(tweak)
+	}
+	if tail == 0 {
+		return nil
+	}
+
+	// Ciphertext stealing: the last whole block and the partial one swap
+	// places. Decryption uses the two tweaks in the opposite order.
+	first, second := tweak, append([]byte(nil), tweak...)
+	mulAlpha(second)
+	if !encrypt {
+		first, second = second, first
+	}
+
+	block := make([]byte, 16)
+	if err := x.cryptBlock(block, src[whole:whole+16], first, encrypt); err != nil {
+		return err
+	}
+	last := src[whole+16:]
+	stolen := make([]byte, 16)
+	copy(stolen, last)
+	copy(stolen[tail:], block[tail:])
+	copy(dst[whole+16:], block[:tail])
+	return x.cryptBlock(dst[whole:whole+16], stolen, second, encrypt)
+}
+
+// cryptBlock encrypts or decrypts one block between two XORs with tweak,
+// in place in dst
+func (x *XTS) cryptBlock(dst, src, tweak []byte, encrypt bool) error {
+	for j := 0; j < 16; j++ {
+		dst[j] = src[j] ^ tweak[j]
+	}
+	var err error
+	if encrypt {
+		err = x.data.EncryptBlock(dst, dst)
+	} else {
+		err = x.data.DecryptBlock(dst, dst)
+	}
+	if err != nil {
+		return err
+	}
+	for j := 0; j < 16; j++ {
+		dst[j] ^= tweak[j]
+	}
+	return nil
+}
+
+// mulAlpha multiplies the tweak by the primitive element of GF(2^128), in
+// the little-endian bit order of XTS
+func mulAlpha(tweak []byte) {
+	var carry byte
+	for i := range tweak {
+		next := tweak[i] >> 7
+		tweak[i] = tweak[i]<<1 | carry
+		carry = next
+	}
+	if carry != 0 {
+		tweak[0] ^= 0x87
+	}
+}
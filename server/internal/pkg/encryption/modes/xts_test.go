@@ -0,0 +1,130 @@
+package modes
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/encryption"
+)
+
+func newTestXTS(t *testing.T, key1, key2 string, sectorSize int) *XTS {
+	k1, _ := hex.DecodeString(key1)
+	k2, _ := hex.DecodeString(key2)
+	data, err := encryption.NewAES(k1)
+	if err != nil {
+		t.Fatalf("NewAES failed: %v", err)
+	}
+	tweak, err := encryption.NewAES(k2)
+	if err != nil {
+		t.Fatalf("NewAES failed: %v", err)
+	}
+	xts, err := NewXTS(data, tweak, sectorSize)
+	if err != nil {
+		t.Fatalf("NewXTS failed: %v", err)
+	}
+	return xts
+}
+
+// TestXTSKnownAnswer checks XTS-AES-128 against the IEEE 1619 vectors,
+// including ciphertext stealing
+func TestXTSKnownAnswer(t *testing.T) {
+	vectors := []struct {
+		key1, key2 string
+		sector     uint64
+		plaintext  string
+		ciphertext string
+	}{
+		{
+			"00000000000000000000000000000000", "00000000000000000000000000000000", 0,
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"917cf69ebd68b2ec9b9fe9a3eadda692cd43d2f59598ed858c02c2652fbf922e",
+		},
+		{
+			"11111111111111111111111111111111", "22222222222222222222222222222222", 0x3333333333,
+			"4444444444444444444444444444444444444444444444444444444444444444",
+			"c454185e6a16936e39334038acef838bfb186fff7480adc4289382ecd6d394f0",
+		},
+		{
+			"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0", "bfbebdbcbbbab9b8b7b6b5b4b3b2b1b0", 0x123456789a,
+			"000102030405060708090a0b0c0d0e0f10",
+			"6c1625db4671522d3d7599601de7ca09ed",
+		},
+	}
+	for _, v := range vectors {
+		xts := newTestXTS(t, v.key1, v.key2, 512)
+		plaintext, _ := hex.DecodeString(v.plaintext)
+		expected, _ := hex.DecodeString(v.ciphertext)
+
+		encrypted := make([]byte, len(plaintext))
+		if err := xts.Encrypt(encrypted, plaintext, v.sector); err != nil {
+			t.Fatalf("XTS encryption failed: %v", err)
+		}
+		if !bytes.Equal(encrypted, expected) {
+			t.Fatalf("XTS known answer mismatch: expected %x, got %x", expected, encrypted)
+		}
+		decrypted := make([]byte, len(encrypted))
+		if err := xts.Decrypt(decrypted, encrypted, v.sector); err != nil {
+			t.Fatalf("XTS decryption failed: %v", err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("XTS decryption mismatch: expected %x, got %x", plaintext, decrypted)
+		}
+	}
+}
+
+// TestXTSRandomAccess decrypts runs of sectors of a blob on their own, as a
+// ranged download of an attachment would
+func TestXTSRandomAccess(t *testing.T) {
+	xts := newTestXTS(t, hex.EncodeToString(testKey256[:16]), hex.EncodeToString(testKey256[16:]), 64)
+	blob := make([]byte, 64*5+23)
+	for i := range blob {
+		blob[i] = byte(i * 7)
+	}
+
+	encrypted := make([]byte, len(blob))
+	if err := xts.Encrypt(encrypted, blob, 0); err != nil {
+		t.Fatalf("XTS encryption failed: %v", err)
+	}
+	if bytes.Equal(encrypted[:64], encrypted[64:128]) {
+		t.Fatal("sectors with the same data must encrypt differently")
+	}
+
+	for first := 0; first < 6; first++ {
+		for last := first; last < 6; last++ {
+			start, end := first*64, (last+1)*64
+			if end > len(blob) {
+				end = len(blob)
+			}
+			part := make([]byte, end-start)
+			if err := xts.Decrypt(part, encrypted[start:end], uint64(first)); err != nil {
+				t.Fatalf("sectors %d-%d: decryption failed: %v", first, last, err)
+			}
+			if !bytes.Equal(part, blob[start:end]) {
+				t.Fatalf("sectors %d-%d: decrypted data differs", first, last)
+			}
+		}
+	}
+
+	// Encrypting in place gives the same result
+	inPlace := append([]byte(nil), blob...)
+	if err := xts.Encrypt(inPlace, inPlace, 0); err != nil {
+		t.Fatalf("in-place XTS encryption failed: %v", err)
+	}
+	if !bytes.Equal(inPlace, encrypted) {
+		t.Fatal("in-place XTS encryption differs")
+	}
+}
+
+func TestXTSRejectsInvalidInput(t *testing.T) {
+	if _, err := NewXTS(getTestLOKI97(), getTestLOKI97(), 512); err == nil {
+		t.Fatal("expected error for XTS with LOKI97")
+	}
+	if _, err := NewXTS(getTestAES(), getTestAES(), 100); err == nil {
+		t.Fatal("expected error for a sector size that is not a multiple of 16")
+	}
+	xts, _ := NewXTS(getTestAES(), getTestMARS(), 64)
+	if err := xts.Encrypt(make([]byte, 64+15), make([]byte, 64+15), 0); err == nil {
+		t.Fatal("expected error for a last sector shorter than a block")
+	}
+}
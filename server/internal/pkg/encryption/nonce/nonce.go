@@ -0,0 +1,200 @@
+// Package nonce generates IVs that are never repeated under the same key.
+//
+// In CTR and OFB, and in the modes built on a keystream, encrypting two
+// messages under the same key and IV leaks the XOR of the plaintexts, so a
+// chat either draws random IVs or, better, derives them from a counter kept
+// per chat and sender:
+//
+//	iv = (seed[:n-k] XOR (sender || counter)) || zeros(k)
+//
+// The seed is the IV seed derived with the chat keys, n is the block size
+// and the k zero bytes are left to the block counter of CTR, so the blocks
+// of one message never reach the counter of another.
+package nonce
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"MinMsgr/server/internal/pkg/encryption/modes"
+)
+
+var (
+	// ErrIVReuse is returned by Guard for an IV already used under the key
+	ErrIVReuse = errors.New("IV reused under the same key")
+	// ErrExhausted is returned once a counter has used up its nonces
+	ErrExhausted = errors.New("nonce counter exhausted")
+)
+
+// RequiresUnique reports whether IV reuse in mode turns into keystream
+// reuse, which the counter nonces exist to prevent. ECB ignores the IV, and
+// CBC and PCBC only leak equal message prefixes.
+func RequiresUnique(mode string) bool {
+	switch strings.TrimSuffix(mode, modes.EtMSuffix) {
+	case "CFB", "OFB", "CTR", "RANDOM_DELTA", "GCM":
+		return true
+	default:
+		return false
+	}
+}
+
+// Random returns a random IV of size bytes
+func Random(size int) ([]byte, error) {
+	iv := make([]byte, size)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	return iv, nil
+}
+
+// layout returns the lengths of the sender and counter fields of an IV of
+// size bytes; the rest is left to the block counter
+func layout(size int) (sender, counter int, err error) {
+	switch {
+	case size >= 16:
+		return 4, 8, nil // 2^32 blocks per message
+	case size >= 8:
+		return 1, 4, nil // 2^24 blocks per message
+	default:
+		return 0, 0, fmt.Errorf("no counter nonces for %d-byte IVs", size)
+	}
+}
+
+// CounterIV returns the IV of message counter from sender under seed, with
+// the length of seed
+func CounterIV(seed []byte, sender uint32, counter uint64) ([]byte, error) {
+	senderLen, counterLen, err := layout(len(seed))
+	if err != nil {
+		return nil, err
+	}
+	if senderLen < 4 && sender>>(8*senderLen) != 0 {
+		return nil, fmt.Errorf("sender %d does not fit a %d-byte IV", sender, len(seed))
+	}
+	if counterLen < 8 && counter>>(8*counterLen) != 0 {
+		return nil, ErrExhausted
+	}
+
+	var s [4]byte
+	var n [8]byte
+	binary.BigEndian.PutUint32(s[:], sender)
+	binary.BigEndian.PutUint64(n[:], counter)
+	prefix := append(s[4-senderLen:], n[8-counterLen:]...)
+
+	iv := make([]byte, len(seed))
+	for i, b := range prefix {
+		iv[i] = seed[i] ^ b
+	}
+	return iv, nil
+}
+
+// State stores the next counter of each named nonce sequence, so counters
+// survive restarts
+type State interface {
+	// Load returns the next counter of name, 0 if it was never stored
+	Load(name string) (uint64, error)
+	// Store records next as the next counter of name
+	Store(name string, next uint64) error
+}
+
+// MemoryState is a State that lives as long as the process
+type MemoryState struct {
+	mu       sync.Mutex
+	counters map[string]uint64
+}
+
+// NewMemoryState returns an empty MemoryState
+func NewMemoryState() *MemoryState {
+	return &MemoryState{counters: make(map[string]uint64)}
+}
+
+func (s *MemoryState) Load(name string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters[name], nil
+}
+
+func (s *MemoryState) Store(name string, next uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[name] = next
+	return nil
+}
+
+// Counter hands out the counter IVs of one sender in one chat
+type Counter struct {
+	mu     sync.Mutex
+	seed   []byte
+	sender uint32
+	name   string
+	state  State
+}
+
+// NewCounter returns a Counter for sender under seed whose position is kept
+// in state under name. Each participant of a chat needs its own sender
+// number, e.g. 0 and 1 for the two users of a direct chat.
+func NewCounter(seed []byte, sender uint32, name string, state State) (*Counter, error) {
+	if _, err := CounterIV(seed, sender, 0); err != nil {
+		return nil, err
+	}
+	return &Counter{seed: append([]byte(nil), seed...), sender: sender, name: name, state: state}, nil
+}
+
+// Next returns a fresh IV. The counter is stored before the IV is returned,
+// so a crash can skip counters but never repeat one.
+func (c *Counter) Next() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.state.Load(c.name)
+	if err != nil {
+		return nil, err
+	}
+	if n == ^uint64(0) {
+		return nil, ErrExhausted
+	}
+	iv, err := CounterIV(c.seed, c.sender, n)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.state.Store(c.name, n+1); err != nil {
+		return nil, err
+	}
+	return iv, nil
+}
+
+// Guard refuses IVs already used under a key. It remembers every IV it has
+// seen, so it suits a session rather than a long-lived process.
+type Guard struct {
+	mu   sync.Mutex
+	seen map[[sha256.Size]byte]map[string]struct{}
+}
+
+// NewGuard returns an empty Guard
+func NewGuard() *Guard {
+	return &Guard{seen: make(map[[sha256.Size]byte]map[string]struct{})}
+}
+
+// Use records iv under key and returns ErrIVReuse if it was recorded before.
+// Keys are only kept as hashes.
+func (g *Guard) Use(key, iv []byte) error {
+	id := sha256.Sum256(key)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ivs := g.seen[id]
+	if ivs == nil {
+		ivs = make(map[string]struct{})
+		g.seen[id] = ivs
+	}
+	if _, ok := ivs[string(iv)]; ok {
+		return fmt.Errorf("%w: %s", ErrIVReuse, hex.EncodeToString(iv))
+	}
+	ivs[string(iv)] = struct{}{}
+	return nil
+}
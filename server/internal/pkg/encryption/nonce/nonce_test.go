@@ -0,0 +1,143 @@
+package nonce
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/modes"
+)
+
+func TestCounterIVLayout(t *testing.T) {
+	seed := bytes.Repeat([]byte{0xff}, 16)
+	iv, err := CounterIV(seed, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0xff, 0xff, 0xff, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfd, 0, 0, 0, 0}
+	if !bytes.Equal(iv, want) {
+		t.Fatalf("iv = %x, want %x", iv, want)
+	}
+
+	iv, err = CounterIV(seed[:8], 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []byte{0xfe, 0xff, 0xff, 0xff, 0xfd, 0, 0, 0}
+	if !bytes.Equal(iv, want) {
+		t.Fatalf("8-byte iv = %x, want %x", iv, want)
+	}
+}
+
+func TestCounterIVLimits(t *testing.T) {
+	seed := make([]byte, 8)
+	if _, err := CounterIV(seed, 256, 0); err == nil {
+		t.Error("sender 256 accepted in an 8-byte IV")
+	}
+	if _, err := CounterIV(seed, 0, 1<<32); !errors.Is(err, ErrExhausted) {
+		t.Errorf("counter 2^32 in an 8-byte IV: err = %v, want ErrExhausted", err)
+	}
+	if _, err := CounterIV(make([]byte, 4), 0, 0); err == nil {
+		t.Error("4-byte IV accepted")
+	}
+}
+
+func TestCounterNeverRepeats(t *testing.T) {
+	seed, err := Random(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := NewMemoryState()
+	alice, err := NewCounter(seed, 0, "chat-1/alice", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := NewCounter(seed, 1, "chat-1/bob", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guard := NewGuard()
+	key := []byte("chat key")
+	for i := 0; i < 1000; i++ {
+		for _, c := range []*Counter{alice, bob} {
+			iv, err := c.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := guard.Use(key, iv); err != nil {
+				t.Fatalf("message %d: %v", i, err)
+			}
+		}
+	}
+
+	// A counter restored from the same state carries on where it stopped
+	restored, err := NewCounter(seed, 0, "chat-1/alice", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, err := restored.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Use(key, iv); err != nil {
+		t.Fatalf("restored counter: %v", err)
+	}
+}
+
+// TestCounterIVsKeepCTRStreamsApart checks that the keystream of a long
+// message under one counter IV does not run into the next counter IV
+func TestCounterIVsKeepCTRStreamsApart(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 16)
+	c, err := encryption.GetCipher("AES", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := bytes.Repeat([]byte{0xff}, 16) // worst case for carries
+	iv0, _ := CounterIV(seed, 0, 0)
+	iv1, _ := CounterIV(seed, 0, 1)
+
+	zeros := make([]byte, 64*16)
+	ctr := modes.GetMode("CTR")
+	ks0, err := ctr.Encrypt(c, key, zeros, iv0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks1, err := ctr.Encrypt(c, key, zeros, iv1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(ks0); i += 16 {
+		for j := 0; j < len(ks1); j += 16 {
+			if bytes.Equal(ks0[i:i+16], ks1[j:j+16]) {
+				t.Fatalf("keystream block %d of message 0 equals block %d of message 1", i/16, j/16)
+			}
+		}
+	}
+}
+
+func TestGuardRefusesReuse(t *testing.T) {
+	g := NewGuard()
+	iv := []byte("0123456789abcdef")
+	if err := g.Use([]byte("key 1"), iv); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Use([]byte("key 2"), iv); err != nil {
+		t.Fatalf("same IV under another key: %v", err)
+	}
+	if err := g.Use([]byte("key 1"), iv); !errors.Is(err, ErrIVReuse) {
+		t.Fatalf("err = %v, want ErrIVReuse", err)
+	}
+}
+
+func TestRequiresUnique(t *testing.T) {
+	for mode, want := range map[string]bool{
+		"ECB": false, "CBC": false, "PCBC": false, "CBC+HMAC": false,
+		"CFB": true, "OFB": true, "CTR": true, "RANDOM_DELTA": true, "GCM": true, "CTR+HMAC": true,
+	} {
+		if got := RequiresUnique(mode); got != want {
+			t.Errorf("RequiresUnique(%q) = %v, want %v", mode, got, want)
+		}
+	}
+}
@@ -152,6 +152,11 @@ func (i *ISO10126Padding) Unpad(data []byte) ([]byte, error) {
 	return data[:len(data)-paddingLen], nil
 }
 
+// Names returns the name of every scheme GetPadder accepts
+func Names() []string {
+	return []string{"ZEROS", "PKCS7", "ANSI_X923", "ISO_10126"}
+}
+
 // GetPadder returns a Padder implementation for the given padding name
 func GetPadder(paddingName string) Padder {
 	switch paddingName {
@@ -3,22 +3,90 @@ package encryption
 import (
 	"encoding/binary"
 	"fmt"
+	"math/bits"
+
+	"MinMsgr/server/internal/pkg/crypto"
 )
 
 const (
-	RC6KeySize = 32 // 256-bit key
+	RC6KeySize = 32 // 256-bit key by default; 16 and 24 bytes are accepted too
+
+	// RC6Rounds is the round count of the AES submission, RC6-32/20/b
+	RC6Rounds = 20
+	// RC6MaxRounds is the largest round count the specification allows
+	RC6MaxRounds = 255
 )
 
-// NewRC6 creates a new RC6 cipher with the given key
+// "RC6" is RC6-32/20 with any accepted key size. Other round counts are
+// for experiments and benchmarks, named in the notation of the
+// specification, RC6-w/r/b: "RC6-32/12/16" is 12 rounds with a 16-byte key.
+func init() {
+	Register(CipherSpec{
+		Name:      "RC6",
+		BlockSize: RC6BlockSize,
+		KeySize:   RC6KeySize,
+		KeySizes:  []int{16, 24, 32},
+		New:       func(key []byte) (SymmetricCipher, error) { return NewRC6(key) },
+	})
+	RegisterFamily("RC6", parseRC6Params)
+}
+
+// parseRC6Params parses the w/r/b part of an RC6-w/r/b name. Only 32-bit
+// words, the 128-bit block version, are implemented.
+func parseRC6Params(params string) (CipherSpec, error) {
+	var w, rounds, keySize int
+	if n, err := fmt.Sscanf(params, "%d/%d/%d", &w, &rounds, &keySize); err != nil || n != 3 || fmt.Sprintf("%d/%d/%d", w, rounds, keySize) != params {
+		return CipherSpec{}, fmt.Errorf("RC6 parameters must be w/r/b, got %q", params)
+	}
+	if w != 32 {
+		return CipherSpec{}, fmt.Errorf("RC6 only supports 32-bit words, got %d", w)
+	}
+	if err := checkRC6(rounds, keySize); err != nil {
+		return CipherSpec{}, err
+	}
+	return CipherSpec{
+		BlockSize: RC6BlockSize,
+		KeySize:   keySize,
+		New: func(key []byte) (SymmetricCipher, error) {
+			if len(key) != keySize {
+				return nil, fmt.Errorf("%w: RC6-32/%d/%d requires a %d-byte key, got %d bytes", ErrInvalidKeySize, rounds, keySize, keySize, len(key))
+			}
+			return NewRC6WithRounds(key, rounds)
+		},
+	}, nil
+}
+
+// checkRC6 checks a round count and key size against the specification and
+// the AES key sizes
+func checkRC6(rounds, keySize int) error {
+	if rounds < 1 || rounds > RC6MaxRounds {
+		return fmt.Errorf("RC6 round count must be between 1 and %d, got %d", RC6MaxRounds, rounds)
+	}
+	switch keySize {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("%w: RC6 key must be 16, 24 or 32 bytes, got %d bytes", ErrInvalidKeySize, keySize)
+	}
+}
+
+// NewRC6 creates a new RC6-32/20 cipher with a 128, 192 or 256-bit key
 func NewRC6(key []byte) (*RC6, error) {
-	if len(key) < 16 || len(key) > 32 {
-		return nil, fmt.Errorf("RC6 key must be between 16 and 32 bytes")
+	return NewRC6WithRounds(key, RC6Rounds)
+}
+
+// NewRC6WithRounds creates a new RC6 cipher with the given round count and a
+// 128, 192 or 256-bit key. Fewer rounds than RC6Rounds weaken the cipher.
+func NewRC6WithRounds(key []byte, rounds int) (*RC6, error) {
+	if err := checkRC6(rounds, len(key)); err != nil {
+		return nil, err
 	}
 
 	cipher := &RC6{
-		w: 32,                 // 32-bit words (128-bit blocks)
-		r: 20,                 // 20 rounds
-		s: make([]uint32, 44), // 2(r+2) = 44 for 20 rounds
+		w:       32,                         // 32-bit words (128-bit blocks)
+		r:       rounds,                     // 20 in the AES submission
+		s:       make([]uint32, 2*rounds+4), // 2r+4 round keys
+		keySize: len(key),
 	}
 
 	cipher.expandKey(key)
@@ -30,84 +98,95 @@ func (r *RC6) BlockSize() int {
 	return RC6BlockSize
 }
 
-// KeySize returns the key size of RC6
+// KeySize returns the size of the key the cipher was created with
 func (r *RC6) KeySize() int {
-	return RC6KeySize
+	return r.keySize
+}
+
+// Rounds returns the round count of the cipher
+func (r *RC6) Rounds() int {
+	return r.r
 }
 
-// Name returns the cipher name
+// Name returns the cipher name, in RC6-w/r/b notation for other round
+// counts than RC6Rounds
 func (r *RC6) Name() string {
-	return "RC6"
+	if r.r == RC6Rounds {
+		return "RC6"
+	}
+	return fmt.Sprintf("RC6-%d/%d/%d", r.w, r.r, r.keySize)
 }
 
-// Encrypt encrypts a 128-bit block
-func (r *RC6) Encrypt(key []byte, plaintext []byte) ([]byte, error) {
-	if len(plaintext) != RC6BlockSize {
-		return nil, fmt.Errorf("plaintext must be %d bytes, got %d", RC6BlockSize, len(plaintext))
+// EncryptBlock encrypts a 128-bit block. It runs once per block in every
+// mode, so it does not allocate.
+func (r *RC6) EncryptBlock(dst, src []byte) error {
+	if err := checkBlocks(RC6BlockSize, dst, src); err != nil {
+		return err
 	}
 
-	a := binary.LittleEndian.Uint32(plaintext[0:4])
-	b := binary.LittleEndian.Uint32(plaintext[4:8])
-	c := binary.LittleEndian.Uint32(plaintext[8:12])
-	d := binary.LittleEndian.Uint32(plaintext[12:16])
+	a := binary.LittleEndian.Uint32(src[0:4])
+	b := binary.LittleEndian.Uint32(src[4:8])
+	c := binary.LittleEndian.Uint32(src[8:12])
+	d := binary.LittleEndian.Uint32(src[12:16])
 
-	b = b + r.s[0]
-	d = d + r.s[1]
+	s := r.s
+	b = b + s[0]
+	d = d + s[1]
 
-	for i := 1; i <= r.r; i++ {
-		t := rotl32(b*(2*b+1), 5)
-		u := rotl32(d*(2*d+1), 5)
-		a = rotl32(a^t, u%32) + r.s[2*i]
-		c = rotl32(c^u, t%32) + r.s[2*i+1]
+	// Round i uses s[2i] and s[2i+1]
+	for k := s[2 : 2*r.r+2]; len(k) >= 2; k = k[2:] {
+		t := bits.RotateLeft32(b*(2*b+1), 5)
+		u := bits.RotateLeft32(d*(2*d+1), 5)
+		a = bits.RotateLeft32(a^t, int(u%32)) + k[0]
+		c = bits.RotateLeft32(c^u, int(t%32)) + k[1]
 
 		a, b, c, d = b, c, d, a
 	}
 
-	a = a + r.s[2*r.r+2]
-	c = c + r.s[2*r.r+3]
+	a = a + s[2*r.r+2]
+	c = c + s[2*r.r+3]
 
-	ciphertext := make([]byte, RC6BlockSize)
-	binary.LittleEndian.PutUint32(ciphertext[0:4], a)
-	binary.LittleEndian.PutUint32(ciphertext[4:8], b)
-	binary.LittleEndian.PutUint32(ciphertext[8:12], c)
-	binary.LittleEndian.PutUint32(ciphertext[12:16], d)
+	binary.LittleEndian.PutUint32(dst[0:4], a)
+	binary.LittleEndian.PutUint32(dst[4:8], b)
+	binary.LittleEndian.PutUint32(dst[8:12], c)
+	binary.LittleEndian.PutUint32(dst[12:16], d)
 
-	return ciphertext, nil
+	return nil
 }
 
-// Decrypt decrypts a 128-bit block
-func (r *RC6) Decrypt(key []byte, ciphertext []byte) ([]byte, error) {
-	if len(ciphertext) != RC6BlockSize {
-		return nil, fmt.Errorf("ciphertext must be %d bytes, got %d", RC6BlockSize, len(ciphertext))
+// DecryptBlock decrypts a 128-bit block without allocating
+func (r *RC6) DecryptBlock(dst, src []byte) error {
+	if err := checkBlocks(RC6BlockSize, dst, src); err != nil {
+		return err
 	}
 
-	a := binary.LittleEndian.Uint32(ciphertext[0:4])
-	b := binary.LittleEndian.Uint32(ciphertext[4:8])
-	c := binary.LittleEndian.Uint32(ciphertext[8:12])
-	d := binary.LittleEndian.Uint32(ciphertext[12:16])
+	a := binary.LittleEndian.Uint32(src[0:4])
+	b := binary.LittleEndian.Uint32(src[4:8])
+	c := binary.LittleEndian.Uint32(src[8:12])
+	d := binary.LittleEndian.Uint32(src[12:16])
 
-	c = c - r.s[2*r.r+3]
-	a = a - r.s[2*r.r+2]
+	s := r.s
+	c = c - s[2*r.r+3]
+	a = a - s[2*r.r+2]
 
-	for i := r.r; i >= 1; i-- {
+	for k := s[2 : 2*r.r+2]; len(k) >= 2; k = k[:len(k)-2] {
 		a, b, c, d = d, a, b, c
 
-		u := rotl32(d*(2*d+1), 5)
-		t := rotl32(b*(2*b+1), 5)
-		c = rotr32(c-r.s[2*i+1], t%32) ^ u
-		a = rotr32(a-r.s[2*i], u%32) ^ t
+		u := bits.RotateLeft32(d*(2*d+1), 5)
+		t := bits.RotateLeft32(b*(2*b+1), 5)
+		c = bits.RotateLeft32(c-k[len(k)-1], -int(t%32)) ^ u
+		a = bits.RotateLeft32(a-k[len(k)-2], -int(u%32)) ^ t
 	}
 
-	d = d - r.s[1]
-	b = b - r.s[0]
+	d = d - s[1]
+	b = b - s[0]
 
-	plaintext := make([]byte, RC6BlockSize)
-	binary.LittleEndian.PutUint32(plaintext[0:4], a)
-	binary.LittleEndian.PutUint32(plaintext[4:8], b)
-	binary.LittleEndian.PutUint32(plaintext[8:12], c)
-	binary.LittleEndian.PutUint32(plaintext[12:16], d)
+	binary.LittleEndian.PutUint32(dst[0:4], a)
+	binary.LittleEndian.PutUint32(dst[4:8], b)
+	binary.LittleEndian.PutUint32(dst[8:12], c)
+	binary.LittleEndian.PutUint32(dst[12:16], d)
 
-	return plaintext, nil
+	return nil
 }
 
 // expandKey expands the key into round keys
@@ -115,6 +194,7 @@ func (r *RC6) expandKey(key []byte) {
 	// Copy key into L array
 	c := (len(key) + 3) / 4
 	L := make([]uint32, c)
+	defer crypto.WipeWords(L)
 	for i := 0; i < len(key); i++ {
 		L[i/4] |= uint32(key[i]) << uint((i%4)*8)
 	}
@@ -123,20 +203,21 @@ func (r *RC6) expandKey(key []byte) {
 	p32 := uint32(0xB7E15163)
 	q32 := uint32(0x9E3779B9)
 
+	t := len(r.s)
 	r.s[0] = p32
-	for i := 1; i < 44; i++ {
+	for i := 1; i < t; i++ {
 		r.s[i] = r.s[i-1] + q32
 	}
 
-	// Key-dependent rounds
+	// Key-dependent rounds, 3*max(c, t) of them
 	a, b := uint32(0), uint32(0)
 	i, j := 0, 0
-	for k := 0; k < 3*44; k++ {
+	for k := 0; k < 3*max(c, t); k++ {
 		r.s[i] = rotl32(r.s[i]+a+b, 3)
 		a = r.s[i]
 		L[j] = rotl32(L[j]+a+b, (a+b)%32)
 		b = L[j]
-		i = (i + 1) % 44
+		i = (i + 1) % t
 		j = (j + 1) % c
 	}
 }
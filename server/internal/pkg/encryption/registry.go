@@ -0,0 +1,130 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownCipher is returned by GetCipher for names no cipher is
+// registered under
+var ErrUnknownCipher = errors.New("unknown cipher")
+
+// CipherSpec describes a registered cipher
+type CipherSpec struct {
+	// Name is the algorithm name used by chats and clients
+	Name string
+	// BlockSize is the block size in bytes
+	BlockSize int
+	// KeySize is the key size clients should generate, in bytes
+	KeySize int
+	// KeySizes lists every key size New accepts, in bytes; nil means
+	// KeySize only
+	KeySizes []int
+	// New creates the cipher keyed with key
+	New func(key []byte) (SymmetricCipher, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]CipherSpec)
+	families   = make(map[string]func(params string) (CipherSpec, error))
+)
+
+// Register makes a cipher available to GetCipher under spec.Name. Each
+// cipher registers itself from an init function in its own file, so adding
+// an algorithm takes nothing else. Registering a name twice panics.
+func Register(spec CipherSpec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if spec.Name == "" || spec.New == nil {
+		panic("encryption: Register needs a name and a constructor")
+	}
+	if _, exists := registry[spec.Name]; exists {
+		panic("encryption: cipher " + spec.Name + " registered twice")
+	}
+	registry[spec.Name] = spec
+}
+
+// RegisterFamily makes GetCipher accept names of the form name-params, such
+// as "RC6-32/12/16", for ciphers with parameters beyond the key. parse turns
+// params into the spec of that variant or fails for invalid ones. Families
+// are not listed by Ciphers.
+func RegisterFamily(name string, parse func(params string) (CipherSpec, error)) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if name == "" || parse == nil {
+		panic("encryption: RegisterFamily needs a name and a parser")
+	}
+	if _, exists := families[name]; exists {
+		panic("encryption: cipher family " + name + " registered twice")
+	}
+	families[name] = parse
+}
+
+// GetCipher creates the cipher registered under name, keyed with key
+func GetCipher(name string, key []byte) (SymmetricCipher, error) {
+	spec, ok := LookupCipher(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCipher, name)
+	}
+	c, err := spec.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LookupCipher returns the spec of the cipher registered under name, or of
+// the variant of a cipher family it names
+func LookupCipher(name string) (CipherSpec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if spec, ok := registry[name]; ok {
+		return spec, true
+	}
+	family, params, ok := strings.Cut(name, "-")
+	if !ok || families[family] == nil {
+		return CipherSpec{}, false
+	}
+	spec, err := families[family](params)
+	if err != nil {
+		return CipherSpec{}, false
+	}
+	spec.Name = name
+	return spec, true
+}
+
+// AcceptedKeySizes returns the key sizes the cipher accepts, in bytes
+func (s CipherSpec) AcceptedKeySizes() []int {
+	if len(s.KeySizes) == 0 {
+		return []int{s.KeySize}
+	}
+	return s.KeySizes
+}
+
+// Families returns the names of the registered cipher families, sorted
+func Families() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Ciphers returns the specs of every registered cipher, sorted by name
+func Ciphers() []CipherSpec {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	specs := make([]CipherSpec, 0, len(registry))
+	for _, spec := range registry {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
@@ -0,0 +1,269 @@
+// Package selftest runs known-answer tests of every registered cipher, every
+// mode and every padding scheme. The gateway runs it at startup and refuses
+// traffic if a primitive gives wrong output, so a broken build cannot
+// garble messages silently.
+package selftest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/modes"
+	"MinMsgr/server/internal/pkg/encryption/padding"
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the outcome of a self-test run
+type Report struct {
+	Passed   bool          `json:"passed"`
+	Results  []Result      `json:"results"`
+	Duration time.Duration `json:"-"`
+}
+
+// Failures returns the checks that failed
+func (r Report) Failures() []Result {
+	var failed []Result
+	for _, res := range r.Results {
+		if res.Error != "" {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// cipherVector is one block under a cipher
+type cipherVector struct {
+	key, plaintext, ciphertext string
+}
+
+// cipherVectors has a vector for every registered cipher: published ones for
+// AES (FIPS-197 C.1), MARS and RC6 (their AES submissions), and the pinned
+// output of this implementation for LOKI97
+var cipherVectors = map[string]cipherVector{
+	"AES":        {"000102030405060708090a0b0c0d0e0f", "00112233445566778899aabbccddeeff", "69c4e0d86a7b0430d8cdb78070b4c55a"},
+	"MARS":       {"00000000000000000000000000000000", "00000000000000000000000000000000", "dcc07b8dfb0738d6e30a22dfcf27e886"},
+	"RC6":        {"0123456789abcdef0112233445566778", "02132435465768798a9bacbdcedfe0f1", "524e192f4715c6231f51f6367ea43f18"},
+	"LOKI97":     {"000102030405060708090a0b0c0d0e0f", "0011223344556677", "6c8e1994ba6bc0f0"},
+	"LOKI97-192": {"000102030405060708090a0b0c0d0e0f1011121314151617", "0011223344556677", "1113e15efdb4885c"},
+	"LOKI97-256": {"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "0011223344556677", "1eff53818d3d2175"},
+}
+
+// modeVector is a message under a mode of AES
+type modeVector struct {
+	mode, key, iv, plaintext, ciphertext string
+}
+
+// The NIST SP 800-38A key and plaintext blocks
+const (
+	sp80038aKey       = "2b7e151628aed2a6abf7158809cf4f3c"
+	sp80038aPlaintext = "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51"
+)
+
+// modeVectors are the SP 800-38A vectors for the standard modes, GCM test
+// case 2 and pinned output for the modes without published vectors
+var modeVectors = []modeVector{
+	{"ECB", sp80038aKey, "", sp80038aPlaintext, "3ad77bb40d7a3660a89ecaf32466ef97f5d3d58503b9699de785895a96fdbaaf"},
+	{"CBC", sp80038aKey, "000102030405060708090a0b0c0d0e0f", sp80038aPlaintext, "7649abac8119b246cee98e9b12e9197d5086cb9b507219ee95db113a917678b2"},
+	{"CFB", sp80038aKey, "000102030405060708090a0b0c0d0e0f", sp80038aPlaintext, "3b3fd92eb72dad20333449f8e83cfb4ac8a64537a0b3a93fcde3cdad9f1ce58b"},
+	{"OFB", sp80038aKey, "000102030405060708090a0b0c0d0e0f", sp80038aPlaintext, "3b3fd92eb72dad20333449f8e83cfb4a7789508d16918f03f53c52dac54ed825"},
+	{"CTR", sp80038aKey, "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff", sp80038aPlaintext, "874d6191b620e3261bef6864990db6ce9806f66b7970fdff8617187bb9fffdff"},
+	{"GCM", "00000000000000000000000000000000", "000000000000000000000000", "00000000000000000000000000000000", "0388dace60b6a392f328c2b971b2fe78ab6e47d42cec13bdf53a67b21257bddf"},
+	{"PCBC", sp80038aKey, "000102030405060708090a0b0c0d0e0f", sp80038aPlaintext, "7649abac8119b246cee98e9b12e9197d9e8baff12ad5270a0d1eef93d7037994"},
+	{"RANDOM_DELTA", sp80038aKey, "000102030405060708090a0b0c0d0e0f", sp80038aPlaintext, "3b3fd92eb72dad20333449f8e83cfb4a6696e26df1476d0f0f1f996700f94ad2"},
+	{"CBC+HMAC", sp80038aKey, "000102030405060708090a0b0c0d0e0f", sp80038aPlaintext, "01abb97a22cacdc03dfbe0040352e17344df87b3b80234cd6473f4e985b29fef49"},
+}
+
+// xtsVector is IEEE 1619 vector 1 for XTS-AES-128
+var xtsVector = struct {
+	key1, key2, plaintext, ciphertext string
+}{
+	"00000000000000000000000000000000", "00000000000000000000000000000000",
+	"0000000000000000000000000000000000000000000000000000000000000000",
+	"917cf69ebd68b2ec9b9fe9a3eadda692cd43d2f59598ed858c02c2652fbf922e",
+}
+
+// paddingVectors pad "abc" to an 8-byte block. ISO 10126 pads with random
+// bytes, so only its length byte is fixed.
+var paddingVectors = []struct {
+	name, padded string
+}{
+	{"ZEROS", "6162630000000000"},
+	{"PKCS7", "6162630505050505"},
+	{"ANSI_X923", "6162630000000005"},
+	{"ISO_10126", ""},
+}
+
+// Run runs every check and reports the outcome of each
+func Run() Report {
+	start := time.Now()
+	var results []Result
+	check := func(name string, fn func() error) {
+		results = append(results, Result{Name: name})
+		res := &results[len(results)-1]
+		defer func() {
+			if r := recover(); r != nil {
+				res.Error = fmt.Sprintf("panic: %v", r)
+			}
+		}()
+		if err := fn(); err != nil {
+			res.Error = err.Error()
+		}
+	}
+
+	for _, spec := range encryption.Ciphers() {
+		spec := spec
+		check("cipher/"+spec.Name, func() error { return checkCipher(spec.Name) })
+	}
+	for _, v := range modeVectors {
+		v := v
+		check("mode/"+v.mode, func() error { return checkMode(v) })
+	}
+	check("mode/XTS", checkXTS)
+	for _, v := range paddingVectors {
+		v := v
+		check("padding/"+v.name, func() error { return checkPadding(v.name, v.padded) })
+	}
+
+	report := Report{Passed: true, Results: results, Duration: time.Since(start)}
+	for _, res := range results {
+		if res.Error != "" {
+			report.Passed = false
+		}
+	}
+	return report
+}
+
+func checkCipher(name string) error {
+	v, ok := cipherVectors[name]
+	if !ok {
+		return fmt.Errorf("no known-answer vector")
+	}
+	key, plaintext, ciphertext := unhex(v.key), unhex(v.plaintext), unhex(v.ciphertext)
+
+	c, err := encryption.GetCipher(name, key)
+	if err != nil {
+		return err
+	}
+	defer encryption.WipeCipher(c)
+	out := make([]byte, len(plaintext))
+	if err := c.EncryptBlock(out, plaintext); err != nil {
+		return err
+	}
+	if !bytes.Equal(out, ciphertext) {
+		return fmt.Errorf("encrypted to %x, expected %x", out, ciphertext)
+	}
+	if err := c.DecryptBlock(out, ciphertext); err != nil {
+		return err
+	}
+	if !bytes.Equal(out, plaintext) {
+		return fmt.Errorf("decrypted to %x, expected %x", out, plaintext)
+	}
+	return nil
+}
+
+func checkMode(v modeVector) error {
+	key, iv, plaintext, ciphertext := unhex(v.key), unhex(v.iv), unhex(v.plaintext), unhex(v.ciphertext)
+	m := modes.GetMode(v.mode)
+	if m == nil {
+		return fmt.Errorf("mode not found")
+	}
+	c, err := encryption.NewAES(key)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := m.Encrypt(c, key, plaintext, iv)
+	if err != nil {
+		return err
+	}
+	// Encrypt-then-MAC output ends in a tag that the round trip below
+	// checks; the vector stops before it
+	out := encrypted
+	if _, etm := m.(*modes.EncryptThenMACMode); etm && len(out) > len(ciphertext) {
+		out = out[:len(ciphertext)]
+	}
+	if !bytes.Equal(out, ciphertext) {
+		return fmt.Errorf("encrypted to %x, expected %x", out, ciphertext)
+	}
+
+	out, err = m.Decrypt(c, key, encrypted, iv)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(out, plaintext) {
+		return fmt.Errorf("decrypted to %x, expected %x", out, plaintext)
+	}
+	return nil
+}
+
+func checkXTS() error {
+	key1, key2 := unhex(xtsVector.key1), unhex(xtsVector.key2)
+	plaintext, ciphertext := unhex(xtsVector.plaintext), unhex(xtsVector.ciphertext)
+	data, err := encryption.NewAES(key1)
+	if err != nil {
+		return err
+	}
+	tweak, err := encryption.NewAES(key2)
+	if err != nil {
+		return err
+	}
+	x, err := modes.NewXTS(data, tweak, modes.XTSSectorSize)
+	if err != nil {
+		return err
+	}
+
+	out := make([]byte, len(plaintext))
+	if err := x.Encrypt(out, plaintext, 0); err != nil {
+		return err
+	}
+	if !bytes.Equal(out, ciphertext) {
+		return fmt.Errorf("encrypted to %x, expected %x", out, ciphertext)
+	}
+	if err := x.Decrypt(out, ciphertext, 0); err != nil {
+		return err
+	}
+	if !bytes.Equal(out, plaintext) {
+		return fmt.Errorf("decrypted to %x, expected %x", out, plaintext)
+	}
+	return nil
+}
+
+func checkPadding(name, expected string) error {
+	p := padding.GetPadder(name)
+	if p == nil {
+		return fmt.Errorf("padding not found")
+	}
+	message := []byte("abc")
+	padded := p.Pad(message, 8)
+	if expected != "" && hex.EncodeToString(padded) != expected {
+		return fmt.Errorf("padded to %x, expected %s", padded, expected)
+	}
+	if len(padded) != 8 || (name == "ISO_10126" && padded[7] != 5) {
+		return fmt.Errorf("padded to %x", padded)
+	}
+	out, err := p.Unpad(padded)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(out, message) {
+		return fmt.Errorf("unpadded to %x, expected %x", out, message)
+	}
+	return nil
+}
+
+// unhex decodes the vectors above, which are valid hex
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("selftest: bad vector " + s)
+	}
+	return b
+}
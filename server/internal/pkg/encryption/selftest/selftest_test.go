@@ -0,0 +1,70 @@
+package selftest
+
+import (
+	"strings"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/encryption"
+)
+
+func TestRunPasses(t *testing.T) {
+	report := Run()
+	for _, res := range report.Failures() {
+		t.Errorf("%s: %s", res.Name, res.Error)
+	}
+	if !report.Passed {
+		t.Fatal("self-test failed")
+	}
+
+	// Every registered cipher is covered, and so are the modes and paddings
+	names := make(map[string]bool)
+	for _, res := range report.Results {
+		names[res.Name] = true
+	}
+	for _, spec := range encryption.Ciphers() {
+		if !names["cipher/"+spec.Name] {
+			t.Errorf("cipher %s was not checked", spec.Name)
+		}
+	}
+	for _, name := range []string{"mode/CBC", "mode/GCM", "mode/RANDOM_DELTA", "mode/XTS", "padding/PKCS7"} {
+		if !names[name] {
+			t.Errorf("%s was not checked", name)
+		}
+	}
+}
+
+func TestRunDetectsWrongOutput(t *testing.T) {
+	saved := cipherVectors["RC6"]
+	defer func() { cipherVectors["RC6"] = saved }()
+	broken := saved
+	broken.ciphertext = strings.Repeat("00", 16)
+	cipherVectors["RC6"] = broken
+
+	savedMode := modeVectors[0]
+	defer func() { modeVectors[0] = savedMode }()
+	modeVectors[0].ciphertext = strings.Repeat("ff", 32)
+
+	report := Run()
+	if report.Passed {
+		t.Fatal("self-test passed with wrong vectors")
+	}
+	var failed []string
+	for _, res := range report.Failures() {
+		failed = append(failed, res.Name)
+	}
+	if got := strings.Join(failed, ","); got != "cipher/RC6,mode/ECB" {
+		t.Fatalf("failed checks: %s", got)
+	}
+}
+
+func TestRunRequiresVectorPerCipher(t *testing.T) {
+	saved := cipherVectors["MARS"]
+	delete(cipherVectors, "MARS")
+	defer func() { cipherVectors["MARS"] = saved }()
+
+	report := Run()
+	failed := report.Failures()
+	if report.Passed || len(failed) != 1 || failed[0].Name != "cipher/MARS" {
+		t.Fatalf("expected only cipher/MARS to fail, got %+v", failed)
+	}
+}
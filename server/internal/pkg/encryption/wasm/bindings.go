@@ -0,0 +1,1558 @@
+//go:build js && wasm
+// +build js,wasm
+
+package wasm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"syscall/js"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/capabilities"
+	"MinMsgr/server/internal/pkg/encryption/envelope"
+	"MinMsgr/server/internal/pkg/encryption/nonce"
+	"MinMsgr/server/internal/pkg/encryption/selftest"
+)
+
+// ivGuard remembers the IVs EncryptWithMode used under each key in the
+// modes where reusing one leaks plaintext
+var ivGuard = nonce.NewGuard()
+
+// helper: pad PKCS7
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padding := blockSize - (len(data) % blockSize)
+	if padding == 0 {
+		padding = blockSize
+	}
+	padtext := make([]byte, len(data)+padding)
+	copy(padtext, data)
+	for i := len(data); i < len(padtext); i++ {
+		padtext[i] = byte(padding)
+	}
+	return padtext
+}
+
+func pkcs7Unpad(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	pad := int(data[len(data)-1])
+	if pad <= 0 || pad > len(data) {
+		return data
+	}
+	return data[:len(data)-pad]
+}
+
+// Keys, plaintexts, passwords and ratchet states copied into Go are wiped
+// when a call returns, so they do not pile up in the module's linear memory;
+// the strings and arrays they came from belong to JavaScript and cannot be
+func bytesToHex(b []byte) string          { return hex.EncodeToString(b) }
+func hexToBytes(s string) ([]byte, error) { return hex.DecodeString(s) }
+
+func registerWasm() {
+	// WasmCrypto.Encrypt(algorithm, keyHex, plaintextHex, ivHex) -> json string {ciphertext, iv}
+	encrypt := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 4 {
+			return jsError("insufficient args")
+		}
+		alg := args[0].String()
+		keyHex := args[1].String()
+		ptHex := args[2].String()
+		ivHex := args[3].String()
+
+		key, err := hexToBytes(keyHex)
+		if err != nil {
+			return jsError("invalid key hex")
+		}
+		defer crypto.Wipe(key)
+		pt, err := hexToBytes(ptHex)
+		if err != nil {
+			return jsError("invalid plaintext hex")
+		}
+		defer crypto.Wipe(pt)
+
+		var iv []byte
+		if ivHex != "" {
+			iv, _ = hexToBytes(ivHex)
+		}
+
+		var blockSize int
+
+		c, err := encryption.GetCipher(alg, key)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer encryption.WipeCipher(c)
+		blockSize = c.BlockSize()
+		// Encrypt the padded copy block by block, in place
+		out := pkcs7Pad(pt, blockSize)
+		defer crypto.Wipe(out)
+		for i := 0; i < len(out); i += blockSize {
+			blk := out[i : i+blockSize]
+			if err := c.EncryptBlock(blk, blk); err != nil {
+				return jsFailure(err)
+			}
+		}
+
+		// ensure iv
+		if len(iv) == 0 {
+			iv = make([]byte, blockSize)
+			rand.Read(iv)
+		}
+
+		// Create JavaScript object explicitly
+		result := js.Global().Get("Object").New()
+		result.Set("ciphertext", bytesToHex(out))
+		result.Set("iv", bytesToHex(iv))
+		fmt.Println("[GO] Encrypt returning object with ciphertext and iv")
+		return result
+	})
+
+	decrypt := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 4 {
+			return jsError("insufficient args")
+		}
+		alg := args[0].String()
+		keyHex := args[1].String()
+		ctHex := args[2].String()
+		ivHex := args[3].String()
+
+		key, err := hexToBytes(keyHex)
+		if err != nil {
+			return jsError("invalid key hex")
+		}
+		defer crypto.Wipe(key)
+		ct, err := hexToBytes(ctHex)
+		if err != nil {
+			return jsError("invalid ciphertext hex")
+		}
+		defer crypto.Wipe(ct)
+		_ = ivHex // IV is available but not used in ECB-like decryption
+
+		var blockSize int
+
+		c, err := encryption.GetCipher(alg, key)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer encryption.WipeCipher(c)
+		blockSize = c.BlockSize()
+		if len(ct)%blockSize != 0 {
+			return jsError("ciphertext is not a multiple of the block size")
+		}
+		// ct is our own decoded copy, so it is decrypted in place
+		out := ct
+		for i := 0; i < len(out); i += blockSize {
+			blk := out[i : i+blockSize]
+			if err := c.DecryptBlock(blk, blk); err != nil {
+				return jsFailure(err)
+			}
+		}
+
+		// unpad
+		out = pkcs7Unpad(out)
+
+		// Create JavaScript object explicitly
+		result := js.Global().Get("Object").New()
+		result.Set("plaintext", bytesToHex(out))
+		fmt.Println("[GO] Decrypt returning object with plaintext")
+		return result
+	})
+
+	// WasmCrypto.EncryptWithMode(algorithm, keyHex, plaintextHex, ivHex, mode, padding[, aadHex]) -> Promise<{ciphertext, iv}>
+	// WasmCrypto.EncryptWithModeBytes(algorithm, key, plaintext, iv, mode, padding[, aad]) -> Promise<{ciphertext, iv}>
+	// A random IV is generated if the IV is empty. aad is only accepted in authenticated modes.
+	// In CFB, OFB, CTR, RANDOM_DELTA and GCM an IV already used under the key is refused.
+	// The Bytes variant takes and returns Uint8Arrays instead of hex strings.
+	// The arguments are copied before the call returns, and the Promise
+	// resolves with the result object, {error} included.
+	encryptWithModeFunc := func(name string, c binaryCodec) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) any {
+			call, err := modeCallArgs(args, c, "plaintext")
+			if err != nil {
+				return resolved(jsFailure(err))
+			}
+
+			return newPromise(name, func() js.Value {
+				defer call.wipe()
+				ct, iv, err := encryptWithMode(call.alg, call.key, call.data, call.iv, call.aad, call.mode, call.pad)
+				if err != nil {
+					fmt.Printf("[GO] %s: %s/%s/%s failed: %v\n", name, call.alg, call.mode, call.pad, err)
+					return jsFailure(err)
+				}
+				if nonce.RequiresUnique(call.mode) {
+					if err := ivGuard.Use(call.key, iv); err != nil {
+						return jsFailure(err)
+					}
+				}
+
+				obj := js.Global().Get("Object").New()
+				obj.Set("ciphertext", c.encode(ct))
+				obj.Set("iv", c.encode(iv))
+				return obj
+			})
+		})
+	}
+
+	// WasmCrypto.DecryptWithMode(algorithm, keyHex, ciphertextHex, ivHex, mode, padding[, aadHex]) -> Promise<{plaintext}>
+	// WasmCrypto.DecryptWithModeBytes(algorithm, key, ciphertext, iv, mode, padding[, aad]) -> Promise<{plaintext}>
+	decryptWithModeFunc := func(name string, c binaryCodec) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) any {
+			call, err := modeCallArgs(args, c, "ciphertext")
+			if err != nil {
+				return resolved(jsFailure(err))
+			}
+
+			return newPromise(name, func() js.Value {
+				defer call.wipe()
+				pt, err := decryptWithMode(call.alg, call.key, call.data, call.iv, call.aad, call.mode, call.pad)
+				if err != nil {
+					fmt.Printf("[GO] %s: %s/%s/%s failed: %v\n", name, call.alg, call.mode, call.pad, err)
+					return jsFailure(err)
+				}
+				defer crypto.Wipe(pt)
+
+				obj := js.Global().Get("Object").New()
+				obj.Set("plaintext", c.encode(pt))
+				return obj
+			})
+		})
+	}
+
+	// WasmCrypto.EncryptSectors(algorithm, keyHex, dataHex, firstSector) -> {ciphertext}
+	// WasmCrypto.DecryptSectors(algorithm, keyHex, dataHex, firstSector) -> {plaintext}
+	// XTS over sectors of modes.XTSSectorSize bytes; the key holds the data key
+	// and the tweak key. The Bytes variants take and return Uint8Arrays.
+	cryptSectorsFunc := func(name, resultKey string, encrypt bool, c binaryCodec) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) (result any) {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("[GO] %s panic: %v\n", name, r)
+					result = jsFailure(fmt.Errorf("%w: %v", errPanic, r))
+				}
+			}()
+
+			strs, err := stringArgs(args, "algorithm")
+			if err != nil {
+				return jsFailure(err)
+			}
+			if len(args) < 4 || args[3].Type() != js.TypeNumber || args[3].Float() < 0 {
+				return jsError("firstSector must be a non-negative number")
+			}
+			key, err := c.arg(args, 1, "key")
+			if err != nil {
+				return jsFailure(err)
+			}
+			defer crypto.Wipe(key)
+			data, err := c.arg(args, 2, "data")
+			if err != nil {
+				return jsFailure(err)
+			}
+			defer crypto.Wipe(data)
+
+			out, err := cryptSectors(strs[0], key, data, uint64(args[3].Float()), encrypt)
+			if err != nil {
+				fmt.Printf("[GO] %s: %s failed: %v\n", name, strs[0], err)
+				return jsFailure(err)
+			}
+			if !encrypt {
+				defer crypto.Wipe(out)
+			}
+			obj := js.Global().Get("Object").New()
+			obj.Set(resultKey, c.encode(out))
+			return obj
+		})
+	}
+
+	// WasmCrypto.EncryptFileStream(algorithm, keyHex, ivHex, mode, padding[, onProgress[, totalBytes]]) -> {iv, update, final, close}
+	// WasmCrypto.DecryptFileStream(algorithm, keyHex, ivHex, mode, padding[, onProgress[, totalBytes]]) -> {iv, update, final, close}
+	// Chunked encryption of a file, see envelope.Stream: update(chunk) and
+	// final() take and return Uint8Arrays as {data}, and the result matches
+	// EncryptWithMode over the whole file. onProgress(processedBytes,
+	// totalBytes) is called after every chunk. close() abandons the stream;
+	// final() closes it too.
+	fileStreamFunc := func(name string, encrypt bool) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) (result any) {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("[GO] %s panic: %v\n", name, r)
+					result = jsFailure(fmt.Errorf("%w: %v", errPanic, r))
+				}
+			}()
+
+			strs, err := stringArgs(args, "algorithm", "keyHex", "ivHex", "mode", "padding")
+			if err != nil {
+				return jsFailure(err)
+			}
+			var onProgress js.Value
+			if len(args) > 5 && args[5].Type() == js.TypeFunction {
+				onProgress = args[5]
+			}
+			var total float64
+			if len(args) > 6 && args[6].Type() == js.TypeNumber {
+				total = args[6].Float()
+			}
+			key, err := hexToBytes(strs[1])
+			if err != nil {
+				return jsError("invalid key hex")
+			}
+			defer crypto.Wipe(key)
+			iv, err := hexToBytes(strs[2])
+			if err != nil {
+				return jsError("invalid iv hex")
+			}
+
+			stream, err := newFileStream(strs[0], key, iv, strs[3], strs[4], encrypt)
+			if err != nil {
+				return jsFailure(err)
+			}
+			if encrypt && nonce.RequiresUnique(strs[3]) {
+				if err := ivGuard.Use(key, stream.IV()); err != nil {
+					stream.Close()
+					return jsFailure(err)
+				}
+			}
+
+			var processed float64
+			var update, final, closeFn js.Func
+			release := func() {
+				stream.Close()
+				update.Release()
+				final.Release()
+				closeFn.Release()
+			}
+			progress := func(n int) {
+				processed += float64(n)
+				if onProgress.Type() == js.TypeFunction {
+					onProgress.Invoke(processed, total)
+				}
+			}
+			update = js.FuncOf(func(this js.Value, args []js.Value) any {
+				if len(args) < 1 {
+					return jsError("expected a chunk")
+				}
+				chunk, err := bytesArg(args[0])
+				if err != nil {
+					return jsFailure(err)
+				}
+				defer crypto.Wipe(chunk)
+				out, err := stream.Update(chunk)
+				if err != nil {
+					return jsFailure(err)
+				}
+				if !encrypt {
+					defer crypto.Wipe(out)
+				}
+				progress(len(chunk))
+				obj := js.Global().Get("Object").New()
+				obj.Set("data", uint8Array(out))
+				return obj
+			})
+			final = js.FuncOf(func(this js.Value, args []js.Value) any {
+				defer release()
+				out, err := stream.Final()
+				if err != nil {
+					fmt.Printf("[GO] %s: %s/%s/%s failed: %v\n", name, strs[0], strs[3], strs[4], err)
+					return jsFailure(err)
+				}
+				if !encrypt {
+					defer crypto.Wipe(out)
+				}
+				progress(0)
+				obj := js.Global().Get("Object").New()
+				obj.Set("data", uint8Array(out))
+				return obj
+			})
+			closeFn = js.FuncOf(func(this js.Value, args []js.Value) any {
+				release()
+				return nil
+			})
+
+			obj := js.Global().Get("Object").New()
+			obj.Set("iv", bytesToHex(stream.IV()))
+			obj.Set("update", update)
+			obj.Set("final", final)
+			obj.Set("close", closeFn)
+			return obj
+		})
+	}
+
+	// WasmCrypto.GenerateKey(bits[, algorithm]) -> {key}
+	// WasmCrypto.GenerateIV(blockSize[, algorithm]) -> {iv}
+	// Random bytes from crypto/rand; with an algorithm the key must be a size
+	// the cipher accepts and the IV its block size in bytes
+	generateKeyFunc := js.FuncOf(func(this js.Value, args []js.Value) any {
+		nums, err := uint32Args(args, 0, "bits")
+		if err != nil {
+			return jsFailure(err)
+		}
+		alg, err := optionalStringArg(args, 1, "algorithm")
+		if err != nil {
+			return jsFailure(err)
+		}
+		key, err := generateKey(int(nums[0]), alg)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(key)
+		obj := js.Global().Get("Object").New()
+		obj.Set("key", bytesToHex(key))
+		return obj
+	})
+
+	generateIVFunc := js.FuncOf(func(this js.Value, args []js.Value) any {
+		nums, err := uint32Args(args, 0, "blockSize")
+		if err != nil {
+			return jsFailure(err)
+		}
+		alg, err := optionalStringArg(args, 1, "algorithm")
+		if err != nil {
+			return jsFailure(err)
+		}
+		iv, err := generateIV(int(nums[0]), alg)
+		if err != nil {
+			return jsFailure(err)
+		}
+		obj := js.Global().Get("Object").New()
+		obj.Set("iv", bytesToHex(iv))
+		return obj
+	})
+
+	// WasmCrypto.SealEnvelope(algorithm, mode, padding, keyHex, macKeyHex, plaintextHex[, aadHex]) -> {envelope}
+	// WasmCrypto.OpenEnvelope(keyHex, macKeyHex, envelopeHex[, aadHex]) -> {plaintext, algorithm, mode, padding}
+	// A self-describing blob, see package envelope; an empty macKeyHex means no MAC
+	sealEnvelope := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "algorithm", "mode", "padding", "keyHex", "macKeyHex", "plaintextHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		key, err := hexToBytes(strs[3])
+		if err != nil {
+			return jsError("invalid key hex")
+		}
+		defer crypto.Wipe(key)
+		macKey, err := optionalHexArg(args, 4)
+		if err != nil {
+			return jsError("invalid MAC key hex")
+		}
+		defer crypto.Wipe(macKey)
+		pt, err := hexToBytes(strs[5])
+		if err != nil {
+			return jsError("invalid plaintext hex")
+		}
+		defer crypto.Wipe(pt)
+		aad, err := optionalHexArg(args, 6)
+		if err != nil {
+			return jsError("invalid aad hex")
+		}
+		if len(macKey) == 0 {
+			macKey = nil
+		}
+
+		blob, err := envelope.Seal(key, macKey, envelope.Params{Algorithm: strs[0], Mode: strs[1], Padding: strs[2]}, pt, aad)
+		if err != nil {
+			return jsFailure(err)
+		}
+		obj := js.Global().Get("Object").New()
+		obj.Set("envelope", bytesToHex(blob))
+		return obj
+	})
+
+	openEnvelope := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "keyHex", "macKeyHex", "envelopeHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		key, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid key hex")
+		}
+		defer crypto.Wipe(key)
+		macKey, err := optionalHexArg(args, 1)
+		if err != nil {
+			return jsError("invalid MAC key hex")
+		}
+		defer crypto.Wipe(macKey)
+		blob, err := hexToBytes(strs[2])
+		if err != nil {
+			return jsError("invalid envelope hex")
+		}
+		aad, err := optionalHexArg(args, 3)
+		if err != nil {
+			return jsError("invalid aad hex")
+		}
+		if len(macKey) == 0 {
+			macKey = nil
+		}
+
+		pt, e, err := envelope.Open(key, macKey, blob, aad)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(pt)
+		obj := js.Global().Get("Object").New()
+		obj.Set("plaintext", bytesToHex(pt))
+		obj.Set("algorithm", e.Params.Algorithm)
+		obj.Set("mode", e.Params.Mode)
+		obj.Set("padding", e.Params.Padding)
+		return obj
+	})
+
+	// WasmCrypto.Capabilities() -> {algorithms, cipher_families, modes, paddings, dh_groups, key_agreements}
+	// The registries compiled into the module, in the shape of the server's
+	// GET /api/crypto/capabilities minus the fields its configuration sets
+	capabilitiesFunc := js.FuncOf(func(this js.Value, args []js.Value) any {
+		data, err := json.Marshal(capabilities.Registry())
+		if err != nil {
+			return jsFailure(err)
+		}
+		obj := js.Global().Get("JSON").Call("parse", string(data))
+		for _, field := range []string{"key_modes", "default_key_mode", "client_only_keys"} {
+			obj.Delete(field)
+		}
+		return obj
+	})
+
+	// WasmCrypto.SelfTest() -> {passed, results: [{name, error}], durationMs}
+	// The known-answer tests the gateway runs at startup, see package
+	// selftest, run on this module so the client can check at startup that its
+	// ciphers, modes and paddings give the same output as the server's.
+	// results has a check per cipher, mode and padding, with error set on
+	// failed ones.
+	selfTestFunc := js.FuncOf(func(this js.Value, args []js.Value) any {
+		report := selftest.Run()
+		data, err := json.Marshal(report)
+		if err != nil {
+			return jsFailure(err)
+		}
+		obj := js.Global().Get("JSON").Call("parse", string(data))
+		obj.Set("durationMs", float64(report.Duration.Microseconds())/1000)
+		return obj
+	})
+
+	// WasmCrypto.Ciphers() -> [{name, blockSize, keySize}]
+	ciphers := js.FuncOf(func(this js.Value, args []js.Value) any {
+		list := js.Global().Get("Array").New()
+		for _, spec := range encryption.Ciphers() {
+			obj := js.Global().Get("Object").New()
+			obj.Set("name", spec.Name)
+			obj.Set("blockSize", spec.BlockSize)
+			obj.Set("keySize", spec.KeySize)
+			list.Call("push", obj)
+		}
+		return list
+	})
+
+	// WasmCrypto.DeriveChatKeys(sharedSecretHex, chatId, keySize) -> {messageKey, ivSeed, macKey}
+	// HKDF-SHA256 over the DH shared secret, salted with the chat ID
+	deriveChatKeys := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "sharedSecretHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		if len(args) < 3 || args[1].Type() != js.TypeNumber || args[2].Type() != js.TypeNumber {
+			return jsError("chatId and keySize must be numbers")
+		}
+		secret, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid shared secret hex")
+		}
+		defer crypto.Wipe(secret)
+
+		keys, err := crypto.DeriveChatKeys(secret, int64(args[1].Int()), args[2].Int())
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer keys.Wipe()
+		obj := js.Global().Get("Object").New()
+		obj.Set("messageKey", bytesToHex(keys.MessageKey))
+		obj.Set("ivSeed", bytesToHex(keys.IVSeed))
+		obj.Set("macKey", bytesToHex(keys.MACKey))
+		return obj
+	})
+
+	// WasmCrypto.DeriveSessionKeys(sharedSecretHex, chatId, algorithm, publicKeyAHex, publicKeyBHex) -> {messageKey, ivSeed, macKey}
+	// HKDF-SHA256 over the shared secret, bound to the key exchange transcript;
+	// see crypto.DeriveSessionKeys. The public keys may be given in either order.
+	deriveSessionKeys := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 5 || args[1].Type() != js.TypeNumber {
+			return jsError("expected sharedSecretHex, chatId, algorithm, publicKeyAHex, publicKeyBHex")
+		}
+		strs, err := stringArgs([]js.Value{args[0], args[2], args[3], args[4]}, "sharedSecretHex", "algorithm", "publicKeyAHex", "publicKeyBHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		spec, ok := encryption.LookupCipher(strs[1])
+		if !ok {
+			return jsFailure(fmt.Errorf("%w: %s", encryption.ErrUnknownCipher, strs[1]))
+		}
+		keyA, err1 := hexToBytes(strs[2])
+		keyB, err2 := hexToBytes(strs[3])
+		if err1 != nil || err2 != nil {
+			return jsError("invalid public key hex")
+		}
+		secret, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid shared secret hex")
+		}
+		defer crypto.Wipe(secret)
+
+		keys, err := crypto.DeriveSessionKeys(secret, &crypto.SessionTranscript{
+			ChatID:     int64(args[1].Int()),
+			Algorithm:  strs[1],
+			PublicKeyA: keyA,
+			PublicKeyB: keyB,
+		}, spec.KeySize)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer keys.Wipe()
+		obj := js.Global().Get("Object").New()
+		obj.Set("messageKey", bytesToHex(keys.MessageKey))
+		obj.Set("ivSeed", bytesToHex(keys.IVSeed))
+		obj.Set("macKey", bytesToHex(keys.MACKey))
+		return obj
+	})
+
+	// WasmCrypto.X25519KeyPair() -> {privateKey, publicKey}
+	// A fresh X25519 key pair for a chat with the X25519 key agreement
+	x25519KeyPair := js.FuncOf(func(this js.Value, args []js.Value) any {
+		x, err := crypto.NewX25519()
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer x.Close()
+		private := x.GetPrivateKey()
+		defer crypto.Wipe(private)
+		obj := js.Global().Get("Object").New()
+		obj.Set("privateKey", bytesToHex(private))
+		obj.Set("publicKey", bytesToHex(x.GetPublicKey()))
+		return obj
+	})
+
+	// WasmCrypto.X25519SharedSecret(privateKeyHex, otherPublicKeyHex) -> {sharedSecret}
+	// The secret goes through DeriveChatKeys like a DH one; low-order public
+	// keys are refused
+	x25519SharedSecret := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "privateKeyHex", "otherPublicKeyHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		private, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid private key hex")
+		}
+		other, err := hexToBytes(strs[1])
+		if err != nil {
+			crypto.Wipe(private)
+			return jsError("invalid public key hex")
+		}
+		x, err := crypto.NewX25519FromPrivateKey(private)
+		if err != nil {
+			crypto.Wipe(private)
+			return jsFailure(err)
+		}
+		defer x.Close()
+		secret, err := x.ComputeSharedSecret(other)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(secret)
+		obj := js.Global().Get("Object").New()
+		obj.Set("sharedSecret", bytesToHex(secret))
+		return obj
+	})
+
+	// WasmCrypto.GenerateDHKeyPair(pHex, gHex) -> {privateKey, publicKey}
+	// A fresh DH key pair over prime p and generator g, which must pass
+	// crypto.ValidateDHParameters. Both keys are left-padded to the length
+	// of p.
+	generateDHKeyPair := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "pHex", "gHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		p, err1 := hexToBytes(strs[0])
+		g, err2 := hexToBytes(strs[1])
+		if err1 != nil || err2 != nil {
+			return jsError("invalid DH parameter hex")
+		}
+		dh, err := crypto.NewDiffieHellmanParams(p, g)
+		if err != nil {
+			return jsFailure(err)
+		}
+		if err := dh.GeneratePrivateKey(); err != nil {
+			return jsFailure(err)
+		}
+		defer dh.Close()
+		private := dh.GetPrivateKey()
+		defer crypto.Wipe(private)
+		public := make([]byte, len(private))
+		copy(public[len(public)-len(dh.GetPublicKey()):], dh.GetPublicKey())
+		obj := js.Global().Get("Object").New()
+		obj.Set("privateKey", bytesToHex(private))
+		obj.Set("publicKey", bytesToHex(public))
+		return obj
+	})
+
+	// WasmCrypto.ComputeSharedSecret(privateKeyHex, peerPublicKeyHex, pHex) -> {sharedSecret}
+	// The DH shared secret, left-padded to the length of p, for
+	// DeriveChatKeys or DeriveSessionKeys. Peer keys outside 1 < y < p-1 are
+	// refused.
+	computeSharedSecret := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "privateKeyHex", "peerPublicKeyHex", "pHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		peer, err := hexToBytes(strs[1])
+		if err != nil {
+			return jsError("invalid public key hex")
+		}
+		p, err := hexToBytes(strs[2])
+		if err != nil {
+			return jsError("invalid DH parameter hex")
+		}
+		private, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid private key hex")
+		}
+		defer crypto.Wipe(private)
+		secret, err := crypto.DHSharedSecret(p, private, peer)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(secret)
+		obj := js.Global().Get("Object").New()
+		obj.Set("sharedSecret", bytesToHex(secret))
+		return obj
+	})
+
+	// WasmCrypto.SealKeyShare(recipientPublicKeyHex, keyMaterialHex, chatId, senderDeviceId, recipientDeviceId) -> {share}
+	// Seals chat key material to another device's X25519 public key, see
+	// crypto.SealKeyShare
+	sealKeyShare := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "recipientPublicKeyHex", "keyMaterialHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		c, err := keyShareContextArgs(args, 2)
+		if err != nil {
+			return jsFailure(err)
+		}
+		recipient, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid public key hex")
+		}
+		material, err := hexToBytes(strs[1])
+		if err != nil {
+			return jsError("invalid key material hex")
+		}
+		defer crypto.Wipe(material)
+		share, err := crypto.SealKeyShare(recipient, material, c)
+		if err != nil {
+			return jsFailure(err)
+		}
+		obj := js.Global().Get("Object").New()
+		obj.Set("share", bytesToHex(share))
+		return obj
+	})
+
+	// WasmCrypto.OpenKeyShare(privateKeyHex, shareHex, chatId, senderDeviceId, recipientDeviceId) -> {keyMaterial}
+	// Opens a key share sealed to this device's X25519 key
+	openKeyShare := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "privateKeyHex", "shareHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		c, err := keyShareContextArgs(args, 2)
+		if err != nil {
+			return jsFailure(err)
+		}
+		share, err := hexToBytes(strs[1])
+		if err != nil {
+			return jsError("invalid key share hex")
+		}
+		private, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid private key hex")
+		}
+		x, err := crypto.NewX25519FromPrivateKey(private)
+		if err != nil {
+			crypto.Wipe(private)
+			return jsFailure(err)
+		}
+		defer x.Close()
+		material, err := crypto.OpenKeyShare(x, share, c)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(material)
+		obj := js.Global().Get("Object").New()
+		obj.Set("keyMaterial", bytesToHex(material))
+		return obj
+	})
+
+	// WasmCrypto.IdentityKeyPair() -> {privateKey, publicKey}
+	// A fresh Ed25519 identity key pair; the private key is the 32-byte seed
+	identityKeyPair := js.FuncOf(func(this js.Value, args []js.Value) any {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(private)
+		obj := js.Global().Get("Object").New()
+		obj.Set("privateKey", bytesToHex(private.Seed()))
+		obj.Set("publicKey", bytesToHex(public))
+		return obj
+	})
+
+	// WasmCrypto.SignKeyExchange(identityPrivateKeyHex, chatId, epoch, publicKeyHex) -> {signature}
+	// Signs a DH or X25519 public key published in a chat, see
+	// crypto.SignPublicKey
+	signKeyExchange := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 4 || args[1].Type() != js.TypeNumber || args[2].Type() != js.TypeNumber {
+			return jsError("chatId and epoch must be numbers")
+		}
+		strs, err := stringArgs([]js.Value{args[0], args[3]}, "identityPrivateKeyHex", "publicKeyHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		seed, err := hexToBytes(strs[0])
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return jsError("invalid identity private key")
+		}
+		defer crypto.Wipe(seed)
+		publicKey, err := hexToBytes(strs[1])
+		if err != nil {
+			return jsError("invalid public key hex")
+		}
+		private := ed25519.NewKeyFromSeed(seed)
+		defer crypto.Wipe(private)
+		signature := crypto.SignPublicKey(private, int64(args[1].Int()), int64(args[2].Float()), publicKey)
+		obj := js.Global().Get("Object").New()
+		obj.Set("signature", bytesToHex(signature))
+		return obj
+	})
+
+	// WasmCrypto.VerifyKeyExchange(identityKeyHex, chatId, epoch, publicKeyHex, signatureHex) -> {valid}
+	// Checks the other participant's signature over their public key
+	verifyKeyExchange := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 5 || args[1].Type() != js.TypeNumber || args[2].Type() != js.TypeNumber {
+			return jsError("chatId and epoch must be numbers")
+		}
+		strs, err := stringArgs([]js.Value{args[0], args[3], args[4]}, "identityKeyHex", "publicKeyHex", "signatureHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		identityKey, err1 := hexToBytes(strs[0])
+		publicKey, err2 := hexToBytes(strs[1])
+		signature, err3 := hexToBytes(strs[2])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return jsError("invalid hex")
+		}
+		err = crypto.VerifyPublicKey(identityKey, int64(args[1].Int()), int64(args[2].Float()), publicKey, signature)
+		obj := js.Global().Get("Object").New()
+		obj.Set("valid", err == nil)
+		return obj
+	})
+
+	// WasmCrypto.SignContact(identityPrivateKeyHex, userId, contactId, contactIdentityKeyHex) -> {signature}
+	// Signs the identity key the user checked for a contact, see
+	// crypto.SignContact
+	signContact := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 4 || args[1].Type() != js.TypeNumber || args[2].Type() != js.TypeNumber {
+			return jsError("userId and contactId must be numbers")
+		}
+		strs, err := stringArgs([]js.Value{args[0], args[3]}, "identityPrivateKeyHex", "contactIdentityKeyHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		seed, err := hexToBytes(strs[0])
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return jsError("invalid identity private key")
+		}
+		defer crypto.Wipe(seed)
+		contactKey, err := hexToBytes(strs[1])
+		if err != nil || crypto.CheckIdentityKey(contactKey) != nil {
+			return jsError("invalid contact identity key")
+		}
+		private := ed25519.NewKeyFromSeed(seed)
+		defer crypto.Wipe(private)
+		signature := crypto.SignContact(private, int64(args[1].Int()), int64(args[2].Int()), contactKey)
+		obj := js.Global().Get("Object").New()
+		obj.Set("signature", bytesToHex(signature))
+		return obj
+	})
+
+	// WasmCrypto.ShortAuthString(chatId, userA, epochA, publicKeyAHex, userB, epochB, publicKeyBHex) -> {code}
+	// The short code both participants compare after a key exchange, from the
+	// public keys they published and their epochs, see crypto.SASTranscript
+	shortAuthString := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 7 {
+			return jsError("expected chatId, userA, epochA, publicKeyAHex, userB, epochB, publicKeyBHex")
+		}
+		for _, i := range []int{0, 1, 2, 4, 5} {
+			if args[i].Type() != js.TypeNumber {
+				return jsError("chatId, user IDs and epochs must be numbers")
+			}
+		}
+		strs, err := stringArgs([]js.Value{args[3], args[6]}, "publicKeyAHex", "publicKeyBHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		keyA, err1 := hexToBytes(strs[0])
+		keyB, err2 := hexToBytes(strs[1])
+		if err1 != nil || err2 != nil {
+			return jsError("invalid public key hex")
+		}
+		transcript := crypto.SASTranscript(int64(args[0].Int()),
+			crypto.SASParty{UserID: int64(args[1].Int()), Epoch: int64(args[2].Float()), PublicKey: keyA},
+			crypto.SASParty{UserID: int64(args[4].Int()), Epoch: int64(args[5].Float()), PublicKey: keyB},
+		)
+		code, err := crypto.ShortAuthString(transcript)
+		if err != nil {
+			return jsFailure(err)
+		}
+		obj := js.Global().Get("Object").New()
+		obj.Set("code", code)
+		return obj
+	})
+
+	// WasmCrypto.RatchetInit(sharedSecretHex, chatId, initiator) -> {state}
+	// The Double Ratchet state of one participant, see crypto.NewRatchet. The
+	// state is an opaque string holding secret keys; the client stores it per
+	// chat and replaces it after every message.
+	ratchetInit := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "sharedSecretHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		if len(args) < 3 || args[1].Type() != js.TypeNumber || args[2].Type() != js.TypeBoolean {
+			return jsError("chatId must be a number and initiator a boolean")
+		}
+		secret, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid shared secret hex")
+		}
+		defer crypto.Wipe(secret)
+		r, err := crypto.NewRatchet(secret, int64(args[1].Int()), args[2].Bool())
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer r.Close()
+		state, err := json.Marshal(r)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(state)
+		obj := js.Global().Get("Object").New()
+		obj.Set("state", string(state))
+		return obj
+	})
+
+	// WasmCrypto.RatchetEncrypt(state, chatId, algorithm, mode, padding, plaintextHex) -> {state, header, envelope}
+	// Encrypts a message under its own ratchet key; the header goes with the
+	// message in clear and is covered by the envelope's MAC
+	ratchetEncrypt := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 6 || args[1].Type() != js.TypeNumber {
+			return jsError("chatId must be a number")
+		}
+		strs, err := stringArgs([]js.Value{args[0], args[2], args[3], args[4], args[5]}, "state", "algorithm", "mode", "padding", "plaintextHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		pt, err := hexToBytes(strs[4])
+		if err != nil {
+			return jsError("invalid plaintext hex")
+		}
+		defer crypto.Wipe(pt)
+		oldState := []byte(strs[0])
+		defer crypto.Wipe(oldState)
+		state, header, blob, err := ratchetSeal(oldState, int64(args[1].Int()), envelope.Params{Algorithm: strs[1], Mode: strs[2], Padding: strs[3]}, pt)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(state)
+		obj := js.Global().Get("Object").New()
+		obj.Set("state", string(state))
+		obj.Set("header", bytesToHex(header))
+		obj.Set("envelope", bytesToHex(blob))
+		return obj
+	})
+
+	// WasmCrypto.RatchetDecrypt(state, chatId, headerHex, envelopeHex) -> {state, plaintext}
+	// On error the old state stays valid and must be kept
+	ratchetDecrypt := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 4 || args[1].Type() != js.TypeNumber {
+			return jsError("chatId must be a number")
+		}
+		strs, err := stringArgs([]js.Value{args[0], args[2], args[3]}, "state", "headerHex", "envelopeHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		header, err1 := hexToBytes(strs[1])
+		blob, err2 := hexToBytes(strs[2])
+		if err1 != nil || err2 != nil {
+			return jsError("invalid hex")
+		}
+		oldState := []byte(strs[0])
+		defer crypto.Wipe(oldState)
+		state, pt, err := ratchetOpen(oldState, int64(args[1].Int()), header, blob)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(state)
+		defer crypto.Wipe(pt)
+		obj := js.Global().Get("Object").New()
+		obj.Set("state", string(state))
+		obj.Set("plaintext", bytesToHex(pt))
+		return obj
+	})
+
+	// WasmCrypto.CounterIV(ivSeedHex, sender, counter, size) -> {iv}
+	// The IV of message counter from sender, see package nonce. The client
+	// keeps the counter per chat and stores the next one before sending.
+	counterIV := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "ivSeedHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		if len(args) < 4 || args[1].Type() != js.TypeNumber || args[2].Type() != js.TypeNumber || args[3].Type() != js.TypeNumber {
+			return jsError("sender, counter and size must be numbers")
+		}
+		if args[1].Float() < 0 || args[2].Float() < 0 {
+			return jsError("sender and counter must not be negative")
+		}
+		seed, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid IV seed hex")
+		}
+		defer crypto.Wipe(seed)
+		size := args[3].Int()
+		if size <= 0 || size > len(seed) {
+			return jsError(fmt.Sprintf("IV size %d does not fit a %d-byte seed", size, len(seed)))
+		}
+
+		iv, err := nonce.CounterIV(seed[:size], uint32(args[1].Int()), uint64(args[2].Float()))
+		if err != nil {
+			return jsFailure(err)
+		}
+		obj := js.Global().Get("Object").New()
+		obj.Set("iv", bytesToHex(iv))
+		return obj
+	})
+
+	// WasmCrypto.NewKDFParams(algorithm) -> {params}
+	// Default parameters with a fresh salt for "pbkdf2-sha256" or "argon2id"
+	newKDFParams := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "algorithm")
+		if err != nil {
+			return jsFailure(err)
+		}
+		params, err := crypto.DefaultKDFParams(strs[0])
+		if err != nil {
+			return jsFailure(err)
+		}
+		obj := js.Global().Get("Object").New()
+		obj.Set("params", params.Encode())
+		return obj
+	})
+
+	// WasmCrypto.DeriveKey(password, params) -> {key}
+	// params is an encoded parameter string from NewKDFParams
+	deriveKey := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "password", "params")
+		if err != nil {
+			return jsFailure(err)
+		}
+		password := []byte(strs[0])
+		defer crypto.Wipe(password)
+		key, err := crypto.DeriveKeyEncoded(password, strs[1])
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(key)
+		obj := js.Global().Get("Object").New()
+		obj.Set("key", bytesToHex(key))
+		return obj
+	})
+
+	// WasmCrypto.PBKDF2(password, saltHex, iterations, keyLength) -> {key}
+	// WasmCrypto.Argon2id(password, saltHex, time, memoryKiB, threads, keyLength) -> {key}
+	// crypto.DeriveKey with explicit parameters, for formats that store them
+	// apart; the limits of ParseKDFParams apply
+	pbkdf2 := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "password", "saltHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		nums, err := uint32Args(args, 2, "iterations", "keyLength")
+		if err != nil {
+			return jsFailure(err)
+		}
+		salt, err := hexToBytes(strs[1])
+		if err != nil {
+			return jsError("invalid salt hex")
+		}
+		params := crypto.KDFParams{Algorithm: crypto.KDFPBKDF2, Time: nums[0], KeyLen: nums[1], Salt: salt}
+		return derivedKey([]byte(strs[0]), params)
+	})
+
+	argon2id := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "password", "saltHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		nums, err := uint32Args(args, 2, "time", "memoryKiB", "threads", "keyLength")
+		if err != nil {
+			return jsFailure(err)
+		}
+		if nums[2] > 255 {
+			return jsError("threads must be at most 255")
+		}
+		salt, err := hexToBytes(strs[1])
+		if err != nil {
+			return jsError("invalid salt hex")
+		}
+		params := crypto.KDFParams{Algorithm: crypto.KDFArgon2id, Time: nums[0], Memory: nums[1], Threads: uint8(nums[2]), KeyLen: nums[3], Salt: salt}
+		return derivedKey([]byte(strs[0]), params)
+	})
+
+	// WasmCrypto.HKDF(ikmHex, saltHex, infoHex, length) -> {key}
+	// HKDF-SHA256 (RFC 5869) as crypto.HKDF; an empty salt is HashLen zeros
+	hkdf := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "ikmHex", "saltHex", "infoHex")
+		if err != nil {
+			return jsFailure(err)
+		}
+		nums, err := uint32Args(args, 3, "length")
+		if err != nil {
+			return jsFailure(err)
+		}
+		ikm, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid ikm hex")
+		}
+		defer crypto.Wipe(ikm)
+		salt, err := hexToBytes(strs[1])
+		if err != nil {
+			return jsError("invalid salt hex")
+		}
+		info, err := hexToBytes(strs[2])
+		if err != nil {
+			return jsError("invalid info hex")
+		}
+		key, err := crypto.HKDF(ikm, salt, info, int(nums[0]))
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(key)
+		obj := js.Global().Get("Object").New()
+		obj.Set("key", bytesToHex(key))
+		return obj
+	})
+
+	// WasmCrypto.WrapKey(keyHex, password, algorithm) -> {wrapped}
+	// WasmCrypto.UnwrapKey(wrappedHex, password) -> {key}
+	// AES-KWP under a password-derived key, in the versioned format of crypto.WrapKey
+	wrapKey := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "keyHex", "password", "algorithm")
+		if err != nil {
+			return jsFailure(err)
+		}
+		key, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid key hex")
+		}
+		defer crypto.Wipe(key)
+		params, err := crypto.DefaultKDFParams(strs[2])
+		if err != nil {
+			return jsFailure(err)
+		}
+		password := []byte(strs[1])
+		defer crypto.Wipe(password)
+		wrapped, err := crypto.WrapKey(password, key, params)
+		if err != nil {
+			return jsFailure(err)
+		}
+		obj := js.Global().Get("Object").New()
+		obj.Set("wrapped", bytesToHex(wrapped))
+		return obj
+	})
+
+	unwrapKey := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "wrappedHex", "password")
+		if err != nil {
+			return jsFailure(err)
+		}
+		wrapped, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid wrapped key hex")
+		}
+		password := []byte(strs[1])
+		defer crypto.Wipe(password)
+		key, err := crypto.UnwrapKey(password, wrapped)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(key)
+		obj := js.Global().Get("Object").New()
+		obj.Set("key", bytesToHex(key))
+		return obj
+	})
+
+	// WasmCrypto.SealKeyBackup(bundleHex, passphrase) -> {backup}
+	// WasmCrypto.OpenKeyBackup(backupHex, passphrase) -> {bundle}
+	// The key bundle under a backup passphrase with Argon2id, see crypto.SealKeyBackup
+	sealKeyBackup := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "bundleHex", "passphrase")
+		if err != nil {
+			return jsFailure(err)
+		}
+		bundle, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid bundle hex")
+		}
+		defer crypto.Wipe(bundle)
+		passphrase := []byte(strs[1])
+		defer crypto.Wipe(passphrase)
+		backup, err := crypto.SealKeyBackup(passphrase, bundle)
+		if err != nil {
+			return jsFailure(err)
+		}
+		obj := js.Global().Get("Object").New()
+		obj.Set("backup", bytesToHex(backup))
+		return obj
+	})
+
+	openKeyBackup := js.FuncOf(func(this js.Value, args []js.Value) any {
+		strs, err := stringArgs(args, "backupHex", "passphrase")
+		if err != nil {
+			return jsFailure(err)
+		}
+		backup, err := hexToBytes(strs[0])
+		if err != nil {
+			return jsError("invalid backup hex")
+		}
+		passphrase := []byte(strs[1])
+		defer crypto.Wipe(passphrase)
+		bundle, err := crypto.OpenKeyBackup(passphrase, backup)
+		if err != nil {
+			return jsFailure(err)
+		}
+		defer crypto.Wipe(bundle)
+		obj := js.Global().Get("Object").New()
+		obj.Set("bundle", bytesToHex(bundle))
+		return obj
+	})
+
+	wasmObj := js.Global().Get("WasmCrypto")
+	// Check if WasmCrypto exists by attempting to get it
+	createIfNeeded := wasmObj.Type() == js.TypeUndefined
+	if createIfNeeded {
+		wasmObj = js.Global().Get("Object").New()
+		js.Global().Set("WasmCrypto", wasmObj)
+	}
+	wasmObj.Set("Ciphers", ciphers)
+	wasmObj.Set("Capabilities", capabilitiesFunc)
+	wasmObj.Set("SelfTest", selfTestFunc)
+	wasmObj.Set("Encrypt", encrypt)
+	wasmObj.Set("Decrypt", decrypt)
+	wasmObj.Set("EncryptWithMode", encryptWithModeFunc("EncryptWithMode", hexCodec))
+	wasmObj.Set("DecryptWithMode", decryptWithModeFunc("DecryptWithMode", hexCodec))
+	wasmObj.Set("EncryptWithModeBytes", encryptWithModeFunc("EncryptWithModeBytes", bytesCodec))
+	wasmObj.Set("DecryptWithModeBytes", decryptWithModeFunc("DecryptWithModeBytes", bytesCodec))
+	wasmObj.Set("EncryptSectors", cryptSectorsFunc("EncryptSectors", "ciphertext", true, hexCodec))
+	wasmObj.Set("DecryptSectors", cryptSectorsFunc("DecryptSectors", "plaintext", false, hexCodec))
+	wasmObj.Set("EncryptSectorsBytes", cryptSectorsFunc("EncryptSectorsBytes", "ciphertext", true, bytesCodec))
+	wasmObj.Set("DecryptSectorsBytes", cryptSectorsFunc("DecryptSectorsBytes", "plaintext", false, bytesCodec))
+	wasmObj.Set("EncryptFileStream", fileStreamFunc("EncryptFileStream", true))
+	wasmObj.Set("DecryptFileStream", fileStreamFunc("DecryptFileStream", false))
+	wasmObj.Set("DeriveChatKeys", deriveChatKeys)
+	wasmObj.Set("DeriveSessionKeys", deriveSessionKeys)
+	wasmObj.Set("X25519KeyPair", x25519KeyPair)
+	wasmObj.Set("X25519SharedSecret", x25519SharedSecret)
+	wasmObj.Set("GenerateDHKeyPair", generateDHKeyPair)
+	wasmObj.Set("ComputeSharedSecret", computeSharedSecret)
+	wasmObj.Set("SealKeyShare", sealKeyShare)
+	wasmObj.Set("OpenKeyShare", openKeyShare)
+	wasmObj.Set("IdentityKeyPair", identityKeyPair)
+	wasmObj.Set("SignKeyExchange", signKeyExchange)
+	wasmObj.Set("VerifyKeyExchange", verifyKeyExchange)
+	wasmObj.Set("SignContact", signContact)
+	wasmObj.Set("ShortAuthString", shortAuthString)
+	wasmObj.Set("RatchetInit", ratchetInit)
+	wasmObj.Set("RatchetEncrypt", ratchetEncrypt)
+	wasmObj.Set("RatchetDecrypt", ratchetDecrypt)
+	wasmObj.Set("SealEnvelope", sealEnvelope)
+	wasmObj.Set("OpenEnvelope", openEnvelope)
+	wasmObj.Set("GenerateKey", generateKeyFunc)
+	wasmObj.Set("GenerateIV", generateIVFunc)
+	wasmObj.Set("CounterIV", counterIV)
+	wasmObj.Set("NewKDFParams", newKDFParams)
+	wasmObj.Set("DeriveKey", deriveKey)
+	wasmObj.Set("PBKDF2", pbkdf2)
+	wasmObj.Set("Argon2id", argon2id)
+	wasmObj.Set("HKDF", hkdf)
+	wasmObj.Set("WrapKey", wrapKey)
+	wasmObj.Set("UnwrapKey", unwrapKey)
+	wasmObj.Set("SealKeyBackup", sealKeyBackup)
+	wasmObj.Set("OpenKeyBackup", openKeyBackup)
+}
+
+// RegisterFunctions registers all WASM functions with JavaScript
+func RegisterFunctions() {
+	registerWasm()
+}
+
+// stringArgs returns the first len(names) arguments as strings, failing on
+// missing, null or non-string ones
+func stringArgs(args []js.Value, names ...string) ([]string, error) {
+	if len(args) < len(names) {
+		return nil, argErrorf("insufficient args: expected %d, got %d", len(names), len(args))
+	}
+	strs := make([]string, len(names))
+	for i, name := range names {
+		if args[i].Type() != js.TypeString {
+			return nil, argErrorf("%s must be a string, got: %s", name, args[i].Type().String())
+		}
+		strs[i] = args[i].String()
+	}
+	return strs, nil
+}
+
+// optionalStringArg returns args[i] if it is given; a missing, null or
+// undefined argument is empty
+func optionalStringArg(args []js.Value, i int, name string) (string, error) {
+	if len(args) <= i || args[i].IsNull() || args[i].IsUndefined() {
+		return "", nil
+	}
+	if args[i].Type() != js.TypeString {
+		return "", argErrorf("%s must be a string, got: %s", name, args[i].Type().String())
+	}
+	return args[i].String(), nil
+}
+
+// optionalHexArg decodes args[i] if it is a hex string; a missing, null or
+// undefined argument is empty
+func optionalHexArg(args []js.Value, i int) ([]byte, error) {
+	if len(args) <= i || args[i].Type() != js.TypeString {
+		return nil, nil
+	}
+	return hexToBytes(args[i].String())
+}
+
+// keyShareContextArgs reads the chat and device IDs of a key share from
+// args[i:i+3]
+func keyShareContextArgs(args []js.Value, i int) (*crypto.KeyShareContext, error) {
+	if len(args) < i+3 {
+		return nil, argErrorf("expected chatId, senderDeviceId and recipientDeviceId")
+	}
+	for _, a := range args[i : i+3] {
+		if a.Type() != js.TypeNumber {
+			return nil, argErrorf("chatId and device IDs must be numbers")
+		}
+	}
+	return &crypto.KeyShareContext{
+		ChatID:            int64(args[i].Int()),
+		SenderDeviceID:    int64(args[i+1].Int()),
+		RecipientDeviceID: int64(args[i+2].Int()),
+	}, nil
+}
+
+// uint32Args returns args[i:i+len(names)] as unsigned 32-bit integers,
+// failing on missing, fractional or out of range ones
+func uint32Args(args []js.Value, i int, names ...string) ([]uint32, error) {
+	if len(args) < i+len(names) {
+		return nil, argErrorf("insufficient args: expected %d, got %d", i+len(names), len(args))
+	}
+	nums := make([]uint32, len(names))
+	for j, name := range names {
+		v := args[i+j]
+		if v.Type() != js.TypeNumber {
+			return nil, argErrorf("%s must be a number, got: %s", name, v.Type().String())
+		}
+		f := v.Float()
+		if f < 0 || f > math.MaxUint32 || f != math.Trunc(f) {
+			return nil, argErrorf("%s must be an integer between 0 and %d", name, uint32(math.MaxUint32))
+		}
+		nums[j] = uint32(f)
+	}
+	return nums, nil
+}
+
+// derivedKey derives a key from password with params and returns it as
+// {key}, or {error}
+func derivedKey(password []byte, params crypto.KDFParams) js.Value {
+	defer crypto.Wipe(password)
+	key, err := crypto.DeriveKey(password, params)
+	if err != nil {
+		return jsFailure(err)
+	}
+	defer crypto.Wipe(key)
+	obj := js.Global().Get("Object").New()
+	obj.Set("key", bytesToHex(key))
+	return obj
+}
+
+// modeCall holds the arguments of EncryptWithMode or DecryptWithMode, copied
+// out of JavaScript so the work can run after the call returns
+type modeCall struct {
+	alg, mode, pad     string
+	key, data, iv, aad []byte
+}
+
+// modeCallArgs copies the arguments of EncryptWithMode or DecryptWithMode;
+// dataName names the data in errors
+func modeCallArgs(args []js.Value, c binaryCodec, dataName string) (*modeCall, error) {
+	if len(args) < 6 {
+		return nil, argErrorf("insufficient args: expected 6, got %d", len(args))
+	}
+	names := [3]string{"algorithm", "mode", "padding"}
+	var strs [3]string
+	for j, i := range []int{0, 4, 5} {
+		if args[i].Type() != js.TypeString {
+			return nil, argErrorf("%s must be a string, got: %s", names[j], args[i].Type().String())
+		}
+		strs[j] = args[i].String()
+	}
+
+	call := &modeCall{alg: strs[0], mode: strs[1], pad: strs[2]}
+	var err error
+	if call.key, err = c.arg(args, 1, "key"); err == nil {
+		if call.data, err = c.arg(args, 2, dataName); err == nil {
+			if call.iv, err = c.arg(args, 3, "iv"); err == nil {
+				call.aad, err = c.optionalArg(args, 6, "aad")
+			}
+		}
+	}
+	if err != nil {
+		call.wipe()
+		return nil, err
+	}
+	return call, nil
+}
+
+// wipe clears the key and data of the call
+func (m *modeCall) wipe() {
+	crypto.Wipe(m.key)
+	crypto.Wipe(m.data)
+}
+
+// newPromise returns a Promise resolved with the result of work, which runs
+// on its own goroutine once the call has returned to JavaScript. WASM has a
+// single thread, so work still shares it with the page; load the module in a
+// Web Worker to keep heavy work off the main thread.
+func newPromise(name string, work func() js.Value) js.Value {
+	executor := js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve := args[0]
+		go func() {
+			result := js.Undefined()
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("[GO] %s panic: %v\n", name, r)
+					result = jsFailure(fmt.Errorf("%w: %v", errPanic, r))
+				}
+				resolve.Invoke(result)
+			}()
+			result = work()
+		}()
+		return nil
+	})
+	// The Promise constructor runs the executor before it returns
+	defer executor.Release()
+	return js.Global().Get("Promise").New(executor)
+}
+
+// resolved returns a Promise already resolved with v
+func resolved(v js.Value) js.Value {
+	return js.Global().Get("Promise").Call("resolve", v)
+}
+
+// A binaryCodec carries binary arguments and results across the JavaScript
+// boundary: hexCodec as hex strings, and bytesCodec as Uint8Arrays for the
+// Bytes variants, which skip the hex encoding that doubles the size and
+// copying of large data
+type binaryCodec struct {
+	invalid string // how a bad argument is reported after its name
+	decode  func(v js.Value) ([]byte, error)
+	encode  func(b []byte) any
+}
+
+var hexCodec = binaryCodec{
+	invalid: "hex",
+	decode: func(v js.Value) ([]byte, error) {
+		if v.Type() != js.TypeString {
+			return nil, argErrorf("expected a string, got: %s", v.Type().String())
+		}
+		return hexToBytes(v.String())
+	},
+	encode: func(b []byte) any { return bytesToHex(b) },
+}
+
+var bytesCodec = binaryCodec{
+	invalid: "(expected a Uint8Array)",
+	decode:  bytesArg,
+	encode:  func(b []byte) any { return uint8Array(b) },
+}
+
+// arg decodes args[i], called name in errors
+func (c binaryCodec) arg(args []js.Value, i int, name string) ([]byte, error) {
+	if len(args) <= i {
+		return nil, argErrorf("missing %s", name)
+	}
+	b, err := c.decode(args[i])
+	if err != nil {
+		return nil, argErrorf("invalid %s %s", name, c.invalid)
+	}
+	return b, nil
+}
+
+// optionalArg decodes args[i] if it is given; a missing, null or undefined
+// argument is empty
+func (c binaryCodec) optionalArg(args []js.Value, i int, name string) ([]byte, error) {
+	if len(args) <= i || args[i].IsNull() || args[i].IsUndefined() {
+		return nil, nil
+	}
+	return c.arg(args, i, name)
+}
+
+// bytesArg copies a Uint8Array argument into Go
+func bytesArg(v js.Value) ([]byte, error) {
+	if !v.InstanceOf(js.Global().Get("Uint8Array")) {
+		return nil, argErrorf("expected a Uint8Array, got: %s", v.Type().String())
+	}
+	b := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(b, v)
+	return b, nil
+}
+
+// uint8Array copies b into a new JavaScript Uint8Array
+func uint8Array(b []byte) js.Value {
+	arr := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(arr, b)
+	return arr
+}
+
+// jsError returns a JavaScript object {error: msg, code} for a bad argument
+func jsError(msg string) js.Value {
+	return errorObject(msg, CodeInvalidArgument)
+}
+
+// jsFailure returns a JavaScript object {error, code} for err, see errorCode
+func jsFailure(err error) js.Value {
+	return errorObject(err.Error(), errorCode(err))
+}
+
+func errorObject(msg, code string) js.Value {
+	obj := js.Global().Get("Object").New()
+	obj.Set("error", msg)
+	obj.Set("code", code)
+	return obj
+}
@@ -0,0 +1,837 @@
+//go:build js && wasm
+// +build js,wasm
+
+package wasm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"syscall/js"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption/capabilities"
+	"MinMsgr/server/internal/pkg/encryption/envelope"
+	"MinMsgr/server/internal/pkg/encryption/nonce"
+	"MinMsgr/server/internal/pkg/encryption/selftest"
+	"MinMsgr/server/internal/protocol"
+)
+
+// TestBindingsRandomDeltaMatchesNative runs the JavaScript bindings against
+// the vectors of the native build. Run it under Node with
+//
+//	GOOS=js GOARCH=wasm go test -exec "$(go env GOROOT)/lib/wasm/go_js_wasm_exec" ./server/internal/pkg/encryption/wasm
+func TestBindingsRandomDeltaMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+
+	for _, v := range randomDeltaVectors {
+		keyHex := hex.EncodeToString(testKeys[v.algorithm])
+
+		result := awaitPromise(wasmCrypto.Call("EncryptWithMode", v.algorithm, keyHex, v.plaintext, v.iv, "RANDOM_DELTA", "PKCS7"))
+		if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("%s: EncryptWithMode failed: %s", v.algorithm, errValue.String())
+		}
+		if ct := result.Get("ciphertext").String(); ct != v.ciphertext {
+			t.Fatalf("%s: WASM build produced %s, native build %s", v.algorithm, ct, v.ciphertext)
+		}
+
+		result = awaitPromise(wasmCrypto.Call("DecryptWithMode", v.algorithm, keyHex, v.ciphertext, v.iv, "RANDOM_DELTA", "PKCS7"))
+		if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("%s: DecryptWithMode failed: %s", v.algorithm, errValue.String())
+		}
+		if pt := result.Get("plaintext").String(); pt != v.plaintext {
+			t.Fatalf("%s: WASM build decrypted to %s, expected %s", v.algorithm, pt, v.plaintext)
+		}
+	}
+}
+
+// awaitPromise waits for a Promise returned by the bindings and returns the
+// value it resolved with
+func awaitPromise(p js.Value) js.Value {
+	done := make(chan js.Value, 1)
+	then := js.FuncOf(func(this js.Value, args []js.Value) any {
+		done <- args[0]
+		return nil
+	})
+	defer then.Release()
+	p.Call("then", then)
+	return <-done
+}
+
+// TestBindingsWithModeReturnsPromise checks that EncryptWithMode returns
+// before the work is done and that argument errors resolve as {error}
+func TestBindingsWithModeReturnsPromise(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	keyHex := hex.EncodeToString(testKeys["AES"])
+
+	p := wasmCrypto.Call("EncryptWithMode", "AES", keyHex, "00112233", "", "CBC", "PKCS7")
+	if !p.InstanceOf(js.Global().Get("Promise")) {
+		t.Fatalf("EncryptWithMode returned %s, expected a Promise", p.Type().String())
+	}
+	result := awaitPromise(p)
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("EncryptWithMode failed: %s", errValue.String())
+	}
+	result = awaitPromise(wasmCrypto.Call("DecryptWithMode", "AES", keyHex, result.Get("ciphertext"), result.Get("iv"), "CBC", "PKCS7"))
+	if pt := result.Get("plaintext"); pt.Type() != js.TypeString || pt.String() != "00112233" {
+		t.Fatalf("DecryptWithMode resolved with %v", pt)
+	}
+
+	result = awaitPromise(wasmCrypto.Call("EncryptWithMode", "AES", "zz", "00", "", "CBC", "PKCS7"))
+	if result.Get("error").Type() != js.TypeString {
+		t.Fatal("invalid key hex did not resolve with an error")
+	}
+}
+
+// TestBindingsCapabilitiesMatchRegistry checks that Capabilities reports
+// what the server reads from the registries
+func TestBindingsCapabilitiesMatchRegistry(t *testing.T) {
+	RegisterFunctions()
+	result := js.Global().Get("WasmCrypto").Call("Capabilities")
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("Capabilities failed: %s", errValue.String())
+	}
+
+	var got protocol.CryptoCapabilities
+	if err := json.Unmarshal([]byte(js.Global().Get("JSON").Call("stringify", result).String()), &got); err != nil {
+		t.Fatalf("Capabilities returned invalid JSON: %v", err)
+	}
+	want, _ := json.Marshal(capabilities.Registry())
+	if gotJSON, _ := json.Marshal(&got); !bytes.Equal(gotJSON, want) {
+		t.Fatalf("WASM build reports %s, registries %s", gotJSON, want)
+	}
+	if result.Get("key_modes").Type() != js.TypeUndefined {
+		t.Fatal("Capabilities reports the server's key modes")
+	}
+}
+
+// TestBindingsSelfTestMatchesNative checks that the self-test passes in the
+// module and runs the same checks as the native build
+func TestBindingsSelfTestMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	result := js.Global().Get("WasmCrypto").Call("SelfTest")
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("SelfTest failed: %s", errValue.String())
+	}
+
+	native := selftest.Run()
+	results := result.Get("results")
+	if results.Length() != len(native.Results) {
+		t.Fatalf("WASM build ran %d checks, native build %d", results.Length(), len(native.Results))
+	}
+	for i, want := range native.Results {
+		if name := results.Index(i).Get("name").String(); name != want.Name {
+			t.Errorf("check %d: WASM build ran %s, native build %s", i, name, want.Name)
+		}
+		if errValue := results.Index(i).Get("error"); errValue.Type() == js.TypeString {
+			t.Errorf("%s: %s", want.Name, errValue.String())
+		}
+	}
+	if !result.Get("passed").Bool() {
+		t.Error("SelfTest did not pass")
+	}
+	if result.Get("durationMs").Type() != js.TypeNumber {
+		t.Error("SelfTest reported no duration")
+	}
+}
+
+// TestBindingsDeriveChatKeysMatchesNative checks that the client derives the
+// same chat keys as the crypto package
+func TestBindingsDeriveChatKeysMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	secret := make([]byte, 256)
+	for i := range secret {
+		secret[i] = byte(i * 7)
+	}
+	want, err := crypto.DeriveChatKeys(secret, 42, 32)
+	if err != nil {
+		t.Fatalf("DeriveChatKeys failed: %v", err)
+	}
+
+	result := js.Global().Get("WasmCrypto").Call("DeriveChatKeys", hex.EncodeToString(secret), 42, 32)
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("DeriveChatKeys binding failed: %s", errValue.String())
+	}
+	for name, key := range map[string][]byte{"messageKey": want.MessageKey, "ivSeed": want.IVSeed, "macKey": want.MACKey} {
+		if got := result.Get(name).String(); got != hex.EncodeToString(key) {
+			t.Fatalf("%s: WASM build derived %s, native build %x", name, got, key)
+		}
+	}
+}
+
+// TestBindingsDeriveSessionKeysMatchesNative checks the client's session keys
+// against the vector pinned by the crypto package's session tests
+func TestBindingsDeriveSessionKeysMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	keyA, keyB := bytes.Repeat([]byte{0xa1}, 32), bytes.Repeat([]byte{0x0b}, 32)
+	want := map[string]string{
+		"messageKey": "ae09cfb6cd5409180ee9c8aad5f0aa93e2bc33726acfd9c01ccff096d1435e89",
+		"ivSeed":     "7d9762d65f5226a0297527bedcdcaa82",
+		"macKey":     "50da37e6c152be709116820415dd1cab2fdf746bd370b3c1c8b1fd5784f6b4ca",
+	}
+
+	// Each participant lists its own key first
+	for _, keys := range [][2][]byte{{keyA, keyB}, {keyB, keyA}} {
+		result := js.Global().Get("WasmCrypto").Call("DeriveSessionKeys", hex.EncodeToString(secret), 7, "AES",
+			hex.EncodeToString(keys[0]), hex.EncodeToString(keys[1]))
+		if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("DeriveSessionKeys binding failed: %s", errValue.String())
+		}
+		for name, key := range want {
+			if got := result.Get(name).String(); got != key {
+				t.Fatalf("%s: WASM build derived %s, native build %s", name, got, key)
+			}
+		}
+	}
+
+	result := js.Global().Get("WasmCrypto").Call("DeriveSessionKeys", hex.EncodeToString(secret), 7, "NOPE",
+		hex.EncodeToString(keyA), hex.EncodeToString(keyB))
+	if result.Get("error").Type() != js.TypeString {
+		t.Fatal("unknown algorithm accepted")
+	}
+}
+
+// TestBindingsX25519MatchesNative checks that a key pair made by the client
+// agrees on the shared secret with the crypto package
+func TestBindingsX25519MatchesNative(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	pair := wasmCrypto.Call("X25519KeyPair")
+	if errValue := pair.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("X25519KeyPair failed: %s", errValue.String())
+	}
+	clientPublic, err := hex.DecodeString(pair.Get("publicKey").String())
+	if err != nil {
+		t.Fatalf("invalid public key hex: %v", err)
+	}
+
+	server, err := crypto.NewX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := server.ComputeSharedSecret(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := wasmCrypto.Call("X25519SharedSecret", pair.Get("privateKey").String(), hex.EncodeToString(server.GetPublicKey()))
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("X25519SharedSecret failed: %s", errValue.String())
+	}
+	if got := result.Get("sharedSecret").String(); got != hex.EncodeToString(want) {
+		t.Fatalf("WASM build computed %s, native build %x", got, want)
+	}
+
+	result = wasmCrypto.Call("X25519SharedSecret", pair.Get("privateKey").String(), hex.EncodeToString(make([]byte, 32)))
+	if result.Get("error").Type() != js.TypeString {
+		t.Fatal("all-zero public key accepted")
+	}
+}
+
+// TestBindingsDHMatchesNative checks the client's DH key pairs and shared
+// secrets against the crypto package
+func TestBindingsDHMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	group, _ := crypto.LookupDHGroup(crypto.DefaultDHGroup)
+	pHex := hex.EncodeToString(group.P.Bytes())
+
+	pair := wasmCrypto.Call("GenerateDHKeyPair", pHex, hex.EncodeToString(group.G.Bytes()))
+	if errValue := pair.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("GenerateDHKeyPair failed: %s", errValue.String())
+	}
+	clientPublic, err := hex.DecodeString(pair.Get("publicKey").String())
+	if err != nil {
+		t.Fatalf("invalid public key hex: %v", err)
+	}
+	if len(clientPublic) != group.Bits/8 || len(pair.Get("privateKey").String()) != group.Bits/4 {
+		t.Fatalf("keys are not padded to the %d-byte prime", group.Bits/8)
+	}
+
+	server := crypto.NewDiffieHellmanGroup(group)
+	if err := server.GeneratePrivateKey(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := server.ComputeSharedSecret(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := wasmCrypto.Call("ComputeSharedSecret", pair.Get("privateKey").String(), hex.EncodeToString(server.GetPublicKey()), pHex)
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("ComputeSharedSecret failed: %s", errValue.String())
+	}
+	padded := make([]byte, group.Bits/8)
+	copy(padded[len(padded)-len(want):], want)
+	if got := result.Get("sharedSecret").String(); got != hex.EncodeToString(padded) {
+		t.Fatalf("WASM build computed %s, native build %x", got, padded)
+	}
+
+	result = wasmCrypto.Call("ComputeSharedSecret", pair.Get("privateKey").String(), "01", pHex)
+	if result.Get("error").Type() != js.TypeString {
+		t.Fatal("public key 1 accepted")
+	}
+	result = wasmCrypto.Call("GenerateDHKeyPair", pHex, "01")
+	if result.Get("error").Type() != js.TypeString {
+		t.Fatal("generator 1 accepted")
+	}
+}
+
+// TestBindingsFileStreamMatchesEncryptWithMode streams a file through the
+// bindings in chunks and checks the result and the progress reported
+func TestBindingsFileStreamMatchesEncryptWithMode(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	file := bytes.Repeat([]byte("streamed attachment "), 500)
+	keyHex := hex.EncodeToString(testKeys["AES"])
+	ivHex := hex.EncodeToString(bytes.Repeat([]byte{0x11}, 16))
+
+	want, _, err := encryptWithMode("AES", testKeys["AES"], file, bytes.Repeat([]byte{0x11}, 16), nil, "CBC", "PKCS7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(name string, input []byte) ([]byte, []float64) {
+		var reported []float64
+		onProgress := js.FuncOf(func(this js.Value, args []js.Value) any {
+			reported = append(reported, args[0].Float())
+			if args[1].Float() != float64(len(input)) {
+				t.Errorf("%s: progress total %v, expected %d", name, args[1].Float(), len(input))
+			}
+			return nil
+		})
+		defer onProgress.Release()
+
+		stream := wasmCrypto.Call(name, "AES", keyHex, ivHex, "CBC", "PKCS7", onProgress, len(input))
+		if errValue := stream.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("%s failed: %s", name, errValue.String())
+		}
+		var out []byte
+		for off := 0; off < len(input); off += 1000 {
+			end := off + 1000
+			if end > len(input) {
+				end = len(input)
+			}
+			result := stream.Call("update", uint8Array(input[off:end]))
+			if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+				t.Fatalf("%s: update failed: %s", name, errValue.String())
+			}
+			chunk, _ := bytesArg(result.Get("data"))
+			out = append(out, chunk...)
+		}
+		result := stream.Call("final")
+		if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("%s: final failed: %s", name, errValue.String())
+		}
+		chunk, _ := bytesArg(result.Get("data"))
+		return append(out, chunk...), reported
+	}
+
+	ct, reported := run("EncryptFileStream", file)
+	if !bytes.Equal(ct, want) {
+		t.Fatal("streamed ciphertext differs from EncryptWithMode")
+	}
+	if len(reported) == 0 || reported[len(reported)-1] != float64(len(file)) {
+		t.Fatalf("progress %v does not end at %d", reported, len(file))
+	}
+	pt, _ := run("DecryptFileStream", ct)
+	if !bytes.Equal(pt, file) {
+		t.Fatal("streamed decryption differs from the file")
+	}
+
+	stream := wasmCrypto.Call("EncryptFileStream", "AES", keyHex, "", "GCM", "PKCS7")
+	if stream.Get("error").Type() != js.TypeString {
+		t.Fatal("GCM stream accepted")
+	}
+}
+
+// TestBindingsBytesMatchHex checks that the Uint8Array variants give the
+// same results as the hex API and read each other's output
+func TestBindingsBytesMatchHex(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	key := testKeys["AES"]
+	data := bytes.Repeat([]byte("attachment bytes "), 300)
+
+	for _, mode := range []string{"CBC", "CTR", "GCM"} {
+		aadBytes, aadHex := js.Null(), js.Null()
+		if mode == "GCM" {
+			aadBytes, aadHex = uint8Array([]byte("aad")), js.ValueOf(hex.EncodeToString([]byte("aad")))
+		}
+		result := awaitPromise(wasmCrypto.Call("EncryptWithModeBytes", "AES", uint8Array(key), uint8Array(data), uint8Array(nil), mode, "PKCS7", aadBytes))
+		if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("%s: EncryptWithModeBytes failed: %s", mode, errValue.String())
+		}
+		ct, _ := bytesArg(result.Get("ciphertext"))
+		iv, _ := bytesArg(result.Get("iv"))
+
+		result = awaitPromise(wasmCrypto.Call("DecryptWithMode", "AES", hex.EncodeToString(key), hex.EncodeToString(ct), hex.EncodeToString(iv), mode, "PKCS7", aadHex))
+		if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("%s: DecryptWithMode failed: %s", mode, errValue.String())
+		}
+		if pt := result.Get("plaintext").String(); pt != hex.EncodeToString(data) {
+			t.Fatalf("%s: hex API did not decrypt the Bytes variant's ciphertext", mode)
+		}
+	}
+
+	xtsKey := bytes.Repeat([]byte{0x5a}, 64)
+	sectors := bytes.Repeat([]byte{0xc3}, 2*4096)
+	want := wasmCrypto.Call("EncryptSectors", "AES", hex.EncodeToString(xtsKey), hex.EncodeToString(sectors), 3).Get("ciphertext").String()
+	result := wasmCrypto.Call("EncryptSectorsBytes", "AES", uint8Array(xtsKey), uint8Array(sectors), 3)
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("EncryptSectorsBytes failed: %s", errValue.String())
+	}
+	ct, _ := bytesArg(result.Get("ciphertext"))
+	if hex.EncodeToString(ct) != want {
+		t.Fatal("EncryptSectorsBytes differs from EncryptSectors")
+	}
+	result = wasmCrypto.Call("DecryptSectorsBytes", "AES", uint8Array(xtsKey), uint8Array(ct), 3)
+	if pt, _ := bytesArg(result.Get("plaintext")); !bytes.Equal(pt, sectors) {
+		t.Fatal("DecryptSectorsBytes did not decrypt")
+	}
+
+	result = awaitPromise(wasmCrypto.Call("EncryptWithModeBytes", "AES", hex.EncodeToString(key), uint8Array(data), uint8Array(nil), "CBC", "PKCS7"))
+	if result.Get("error").Type() != js.TypeString {
+		t.Fatal("hex key accepted by EncryptWithModeBytes")
+	}
+}
+
+// TestBindingsKeyExchangeSignatureMatchesNative checks that signatures made
+// by the client verify with the crypto package and the other way around
+func TestBindingsKeyExchangeSignatureMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	pair := wasmCrypto.Call("IdentityKeyPair")
+	if errValue := pair.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("IdentityKeyPair failed: %s", errValue.String())
+	}
+	identityKey, err := hex.DecodeString(pair.Get("publicKey").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := []byte("published DH key")
+	const chatID, epoch = 42, 1700000000123
+
+	result := wasmCrypto.Call("SignKeyExchange", pair.Get("privateKey").String(), chatID, epoch, hex.EncodeToString(publicKey))
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("SignKeyExchange failed: %s", errValue.String())
+	}
+	signature, err := hex.DecodeString(result.Get("signature").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crypto.VerifyPublicKey(identityKey, chatID, epoch, publicKey, signature); err != nil {
+		t.Fatalf("native build refused the WASM signature: %v", err)
+	}
+
+	seed, _ := hex.DecodeString(pair.Get("privateKey").String())
+	native := crypto.SignPublicKey(ed25519.NewKeyFromSeed(seed), chatID, epoch, publicKey)
+	verify := func(epoch int64) bool {
+		return wasmCrypto.Call("VerifyKeyExchange", pair.Get("publicKey").String(), chatID, epoch, hex.EncodeToString(publicKey), hex.EncodeToString(native)).Get("valid").Bool()
+	}
+	if !verify(epoch) {
+		t.Fatal("WASM build refused the native signature")
+	}
+	if verify(epoch - 1) {
+		t.Fatal("signature accepted for another epoch")
+	}
+}
+
+// TestBindingsSignContactMatchesNative checks that contact verifications
+// signed by the client verify with the crypto package
+func TestBindingsSignContactMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	identityKey, identity, err := crypto.GenerateIdentityKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contactKey, _, err := crypto.GenerateIdentityKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := js.Global().Get("WasmCrypto").Call("SignContact", hex.EncodeToString(identity.Seed()), 3, 8, hex.EncodeToString(contactKey))
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("SignContact failed: %s", errValue.String())
+	}
+	if got, want := result.Get("signature").String(), hex.EncodeToString(crypto.SignContact(identity, 3, 8, contactKey)); got != want {
+		t.Fatalf("WASM build signed %s, native build %s", got, want)
+	}
+	signature, _ := hex.DecodeString(result.Get("signature").String())
+	if err := crypto.VerifyContact(identityKey, 3, 8, contactKey, signature); err != nil {
+		t.Fatalf("native build refused the WASM signature: %v", err)
+	}
+}
+
+// TestBindingsKeyShareMatchesNative checks that key shares sealed by the
+// client open with the crypto package and the other way round
+func TestBindingsKeyShareMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	device, err := crypto.NewX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+	material := []byte("chat private key of device one!!")
+	c := &crypto.KeyShareContext{ChatID: 42, SenderDeviceID: 1, RecipientDeviceID: 2}
+
+	result := wasmCrypto.Call("SealKeyShare", hex.EncodeToString(device.GetPublicKey()), hex.EncodeToString(material), 42, 1, 2)
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("SealKeyShare failed: %s", errValue.String())
+	}
+	share, _ := hex.DecodeString(result.Get("share").String())
+	opened, err := crypto.OpenKeyShare(device, share, c)
+	if err != nil {
+		t.Fatalf("native build refused the WASM share: %v", err)
+	}
+	if !bytes.Equal(opened, material) {
+		t.Fatalf("native build opened %x, want %x", opened, material)
+	}
+
+	share, err = crypto.SealKeyShare(device.GetPublicKey(), material, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = wasmCrypto.Call("OpenKeyShare", hex.EncodeToString(device.GetPrivateKey()), hex.EncodeToString(share), 42, 1, 2)
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("OpenKeyShare failed: %s", errValue.String())
+	}
+	if got := result.Get("keyMaterial").String(); got != hex.EncodeToString(material) {
+		t.Fatalf("WASM build opened %s, want %x", got, material)
+	}
+
+	result = wasmCrypto.Call("OpenKeyShare", hex.EncodeToString(device.GetPrivateKey()), hex.EncodeToString(share), 43, 1, 2)
+	if result.Get("error").Type() != js.TypeString {
+		t.Fatal("share opened in another chat")
+	}
+}
+
+// TestBindingsShortAuthStringMatchesNative checks that the client shows the
+// code the server checks confirmations against
+func TestBindingsShortAuthStringMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	keyA, keyB := []byte("key of user 3"), []byte("key of user 8")
+	const chatID, epochA, epochB = 42, 1700000000123, 0
+
+	result := js.Global().Get("WasmCrypto").Call("ShortAuthString", chatID, 8, epochB, hex.EncodeToString(keyB), 3, epochA, hex.EncodeToString(keyA))
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("ShortAuthString failed: %s", errValue.String())
+	}
+	native, err := crypto.ShortAuthString(crypto.SASTranscript(chatID,
+		crypto.SASParty{UserID: 3, Epoch: epochA, PublicKey: keyA},
+		crypto.SASParty{UserID: 8, Epoch: epochB, PublicKey: keyB},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Get("code").String(); got != native {
+		t.Fatalf("WASM code %s, native %s", got, native)
+	}
+}
+
+// TestBindingsRatchetConversation runs a short conversation between two
+// ratchet states kept as JavaScript strings, the way the client keeps them
+func TestBindingsRatchetConversation(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	secretHex := hex.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	states := make([]string, 2)
+	for i := range states {
+		result := wasmCrypto.Call("RatchetInit", secretHex, 5, i == 0)
+		if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("RatchetInit failed: %s", errValue.String())
+		}
+		states[i] = result.Get("state").String()
+	}
+
+	for i, text := range []string{"first", "second", "reply"} {
+		from, to := 0, 1
+		if i == 2 {
+			from, to = 1, 0
+		}
+		sealed := wasmCrypto.Call("RatchetEncrypt", states[from], 5, "MARS", "CBC", "PKCS7", hex.EncodeToString([]byte(text)))
+		if errValue := sealed.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("RatchetEncrypt failed: %s", errValue.String())
+		}
+		states[from] = sealed.Get("state").String()
+		opened := wasmCrypto.Call("RatchetDecrypt", states[to], 5, sealed.Get("header").String(), sealed.Get("envelope").String())
+		if errValue := opened.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("RatchetDecrypt failed: %s", errValue.String())
+		}
+		if pt := opened.Get("plaintext").String(); pt != hex.EncodeToString([]byte(text)) {
+			t.Fatalf("message %d decrypted to %s", i, pt)
+		}
+		states[to] = opened.Get("state").String()
+
+		replay := wasmCrypto.Call("RatchetDecrypt", states[to], 5, sealed.Get("header").String(), sealed.Get("envelope").String())
+		if replay.Get("error").Type() != js.TypeString {
+			t.Fatalf("message %d decrypted twice", i)
+		}
+	}
+}
+
+// TestBindingsKDFsMatchNative checks the client's PBKDF2, Argon2id and HKDF
+// against the crypto package
+func TestBindingsKDFsMatchNative(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	salt := bytes.Repeat([]byte{0x5a}, 16)
+
+	for _, tc := range []struct {
+		params crypto.KDFParams
+		args   []any
+	}{
+		{crypto.KDFParams{Algorithm: crypto.KDFPBKDF2, Time: 1000, KeyLen: 32, Salt: salt}, []any{"PBKDF2", "password123", hex.EncodeToString(salt), 1000, 32}},
+		{crypto.KDFParams{Algorithm: crypto.KDFArgon2id, Time: 1, Memory: 64, Threads: 1, KeyLen: 24, Salt: salt}, []any{"Argon2id", "password123", hex.EncodeToString(salt), 1, 64, 1, 24}},
+	} {
+		want, err := crypto.DeriveKey([]byte("password123"), tc.params)
+		if err != nil {
+			t.Fatalf("%s: DeriveKey failed: %v", tc.params.Algorithm, err)
+		}
+		result := wasmCrypto.Call(tc.args[0].(string), tc.args[1:]...)
+		if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+			t.Fatalf("%s failed: %s", tc.args[0], errValue.String())
+		}
+		if got := result.Get("key").String(); got != hex.EncodeToString(want) {
+			t.Fatalf("%s: WASM build derived %s, native build %x", tc.args[0], got, want)
+		}
+	}
+	if result := wasmCrypto.Call("PBKDF2", "password123", hex.EncodeToString(salt), 0, 32); result.Get("error").Type() != js.TypeString {
+		t.Fatal("PBKDF2 with 0 iterations accepted")
+	}
+	if result := wasmCrypto.Call("Argon2id", "password123", hex.EncodeToString(salt), 1, 64, 1.5, 32); result.Get("error").Type() != js.TypeString {
+		t.Fatal("Argon2id with 1.5 threads accepted")
+	}
+
+	ikm, info := bytes.Repeat([]byte{0x0b}, 22), []byte("MinMsgr test")
+	want, err := crypto.HKDF(ikm, salt, info, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := wasmCrypto.Call("HKDF", hex.EncodeToString(ikm), hex.EncodeToString(salt), hex.EncodeToString(info), 42)
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("HKDF failed: %s", errValue.String())
+	}
+	if got := result.Get("key").String(); got != hex.EncodeToString(want) {
+		t.Fatalf("HKDF: WASM build derived %s, native build %x", got, want)
+	}
+}
+
+// TestBindingsGenerateKeyAndIV checks the sizes of generated keys and IVs
+// and that they are validated against the algorithm
+func TestBindingsGenerateKeyAndIV(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+
+	result := wasmCrypto.Call("GenerateKey", 192, "AES")
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("GenerateKey failed: %s", errValue.String())
+	}
+	if key := result.Get("key").String(); len(key) != 48 {
+		t.Fatalf("GenerateKey(192) gave %s", key)
+	}
+	result = wasmCrypto.Call("GenerateIV", 8, "LOKI97")
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("GenerateIV failed: %s", errValue.String())
+	}
+	if iv := result.Get("iv").String(); len(iv) != 16 {
+		t.Fatalf("GenerateIV(8) gave %s", iv)
+	}
+
+	for _, args := range [][]any{{"GenerateKey", 100, "AES"}, {"GenerateKey", "256"}, {"GenerateIV", 16, "LOKI97"}, {"GenerateIV", 0}} {
+		if result := wasmCrypto.Call(args[0].(string), args[1:]...); result.Get("error").Type() != js.TypeString {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+// TestBindingsWrapKeyMatchesNative checks that keys wrapped by the client
+// open with the crypto package and the other way around
+func TestBindingsWrapKeyMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	key := []byte("dh private key bytes")
+
+	result := wasmCrypto.Call("WrapKey", hex.EncodeToString(key), "password123", crypto.KDFArgon2id)
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("WrapKey failed: %s", errValue.String())
+	}
+	wrapped, err := hex.DecodeString(result.Get("wrapped").String())
+	if err != nil {
+		t.Fatalf("invalid wrapped hex: %v", err)
+	}
+	got, err := crypto.UnwrapKey([]byte("password123"), wrapped)
+	if err != nil || string(got) != string(key) {
+		t.Fatalf("native UnwrapKey returned %q, %v", got, err)
+	}
+
+	params, err := crypto.NewPBKDF2Params(1000, 32)
+	if err != nil {
+		t.Fatalf("NewPBKDF2Params failed: %v", err)
+	}
+	wrapped, err = crypto.WrapKey([]byte("password123"), key, params)
+	if err != nil {
+		t.Fatalf("WrapKey failed: %v", err)
+	}
+	result = wasmCrypto.Call("UnwrapKey", hex.EncodeToString(wrapped), "password123")
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("UnwrapKey failed: %s", errValue.String())
+	}
+	if got := result.Get("key").String(); got != hex.EncodeToString(key) {
+		t.Fatalf("WASM build unwrapped %s, expected %x", got, key)
+	}
+
+	result = wasmCrypto.Call("UnwrapKey", hex.EncodeToString(wrapped), "wrong")
+	if result.Get("error").Type() != js.TypeString {
+		t.Fatal("expected an error for a wrong password")
+	}
+}
+
+// TestBindingsKeyBackupMatchesNative checks that a backup sealed by the
+// client opens natively with the passphrase and not with another
+func TestBindingsKeyBackupMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	bundle := []byte(`{"dh_private_key":"0011"}`)
+
+	result := wasmCrypto.Call("SealKeyBackup", hex.EncodeToString(bundle), "backup passphrase")
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("SealKeyBackup failed: %s", errValue.String())
+	}
+	backup, err := hex.DecodeString(result.Get("backup").String())
+	if err != nil {
+		t.Fatalf("invalid backup hex: %v", err)
+	}
+	got, err := crypto.OpenKeyBackup([]byte("backup passphrase"), backup)
+	if err != nil || string(got) != string(bundle) {
+		t.Fatalf("native OpenKeyBackup returned %q, %v", got, err)
+	}
+
+	backup, err = crypto.SealKeyBackup([]byte("backup passphrase"), bundle)
+	if err != nil {
+		t.Fatalf("SealKeyBackup failed: %v", err)
+	}
+	result = wasmCrypto.Call("OpenKeyBackup", hex.EncodeToString(backup), "backup passphrase")
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("OpenKeyBackup failed: %s", errValue.String())
+	}
+	if got := result.Get("bundle").String(); got != hex.EncodeToString(bundle) {
+		t.Fatalf("WASM build opened %s, expected %x", got, bundle)
+	}
+
+	result = wasmCrypto.Call("OpenKeyBackup", hex.EncodeToString(backup), "login password")
+	if result.Get("error").Type() != js.TypeString {
+		t.Fatal("expected an error for a wrong passphrase")
+	}
+}
+
+// TestBindingsEnvelopeMatchesNative opens an envelope sealed by the client
+// natively and the other way around
+func TestBindingsEnvelopeMatchesNative(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	key, macKey := testKeys["RC6"], []byte("0123456789abcdef0123456789abcdef")
+	plaintext := []byte("hello from the browser")
+
+	result := wasmCrypto.Call("SealEnvelope", "RC6", "CBC", "PKCS7", hex.EncodeToString(key), hex.EncodeToString(macKey), hex.EncodeToString(plaintext))
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("SealEnvelope failed: %s", errValue.String())
+	}
+	blob, err := hex.DecodeString(result.Get("envelope").String())
+	if err != nil {
+		t.Fatalf("invalid envelope hex: %v", err)
+	}
+	got, _, err := envelope.Open(key, macKey, blob, nil)
+	if err != nil || string(got) != string(plaintext) {
+		t.Fatalf("native Open returned %q, %v", got, err)
+	}
+
+	blob, err = envelope.Seal(key, nil, envelope.Params{Algorithm: "RC6", Mode: "GCM", Padding: "PKCS7"}, plaintext, []byte("chat 7"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	result = wasmCrypto.Call("OpenEnvelope", hex.EncodeToString(key), "", hex.EncodeToString(blob), hex.EncodeToString([]byte("chat 7")))
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("OpenEnvelope failed: %s", errValue.String())
+	}
+	if pt := result.Get("plaintext").String(); pt != hex.EncodeToString(plaintext) || result.Get("mode").String() != "GCM" {
+		t.Fatalf("OpenEnvelope returned %s in mode %s", pt, result.Get("mode").String())
+	}
+}
+
+// TestBindingsCounterIVRefusesReuse checks that the client computes the same
+// counter IVs as package nonce and cannot encrypt twice under one of them
+func TestBindingsCounterIVRefusesReuse(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	seed := []byte("0123456789abcdef")
+
+	result := wasmCrypto.Call("CounterIV", hex.EncodeToString(seed), 1, 7, 8)
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("CounterIV failed: %s", errValue.String())
+	}
+	want, _ := nonce.CounterIV(seed[:8], 1, 7)
+	ivHex := result.Get("iv").String()
+	if ivHex != hex.EncodeToString(want) {
+		t.Fatalf("WASM build computed %s, native build %x", ivHex, want)
+	}
+
+	keyHex := hex.EncodeToString(testKeys["LOKI97"])
+	result = awaitPromise(wasmCrypto.Call("EncryptWithMode", "LOKI97", keyHex, "00", ivHex, "CTR", "PKCS7"))
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("EncryptWithMode failed: %s", errValue.String())
+	}
+	result = awaitPromise(wasmCrypto.Call("EncryptWithMode", "LOKI97", keyHex, "01", ivHex, "CTR", "PKCS7"))
+	if errValue := result.Get("error"); errValue.Type() != js.TypeString {
+		t.Fatal("EncryptWithMode reused a CTR IV")
+	}
+	if code := result.Get("code").String(); code != CodeIVReused {
+		t.Fatalf("reused IV reported as %s", code)
+	}
+}
+
+// TestBindingsErrorCodes checks that failures carry a code beside the
+// message, for argument errors and errors of the packages alike
+func TestBindingsErrorCodes(t *testing.T) {
+	RegisterFunctions()
+	wasmCrypto := js.Global().Get("WasmCrypto")
+	keyHex := hex.EncodeToString(testKeys["AES"])
+
+	result := awaitPromise(wasmCrypto.Call("EncryptWithMode", "AES", keyHex, "0g", "", "GCM", "PKCS7"))
+	if code := result.Get("code").String(); code != CodeInvalidArgument {
+		t.Errorf("bad hex reported as %s", code)
+	}
+	result = awaitPromise(wasmCrypto.Call("EncryptWithMode", "AES", keyHex, "00", "", "GCM", "NOPE"))
+	if code := result.Get("code").String(); code != CodeUnknownPadding {
+		t.Errorf("unknown padding reported as %s", code)
+	}
+
+	result = awaitPromise(wasmCrypto.Call("EncryptWithMode", "AES", keyHex, "00112233", "", "GCM", "PKCS7"))
+	if errValue := result.Get("error"); errValue.Type() == js.TypeString {
+		t.Fatalf("EncryptWithMode failed: %s", errValue.String())
+	}
+	ct, _ := hex.DecodeString(result.Get("ciphertext").String())
+	ct[0] ^= 1
+	result = awaitPromise(wasmCrypto.Call("DecryptWithMode", "AES", keyHex, hex.EncodeToString(ct), result.Get("iv"), "GCM", "PKCS7"))
+	if errValue := result.Get("error"); errValue.Type() != js.TypeString {
+		t.Fatal("DecryptWithMode accepted a tampered ciphertext")
+	}
+	if code := result.Get("code").String(); code != CodeAuthFailed {
+		t.Errorf("tampered ciphertext reported as %s", code)
+	}
+
+	result = wasmCrypto.Call("GenerateKey", 128, "NOPE")
+	if code := result.Get("code").String(); code != CodeUnknownAlgorithm {
+		t.Errorf("unknown algorithm reported as %s", code)
+	}
+	result = wasmCrypto.Call("Encrypt", "AES")
+	if code := result.Get("code").String(); code != CodeInvalidArgument {
+		t.Errorf("missing arguments to Encrypt reported as %s", code)
+	}
+}
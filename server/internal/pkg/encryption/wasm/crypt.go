@@ -0,0 +1,215 @@
+// Package wasm exposes the ciphers, modes and paddings to the browser client
+// as the WasmCrypto JavaScript object
+package wasm
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/envelope"
+	"MinMsgr/server/internal/pkg/encryption/modes"
+)
+
+// encryptWithMode pads plaintext and encrypts it in the given mode, exactly
+// as the server-side modes and padding packages do. It returns the
+// ciphertext and the IV used, generated if iv is empty. aad is only
+// accepted in the authenticated modes, GCM and the "+HMAC" ones.
+func encryptWithMode(algorithm string, key, plaintext, iv, aad []byte, mode, pad string) ([]byte, []byte, error) {
+	return envelope.Encrypt(key, envelope.Params{Algorithm: algorithm, Mode: mode, Padding: pad}, plaintext, iv, aad)
+}
+
+// decryptWithMode reverses encryptWithMode. In the authenticated modes it
+// fails with modes.ErrAuthFailed if the ciphertext or aad was tampered with.
+func decryptWithMode(algorithm string, key, ciphertext, iv, aad []byte, mode, pad string) ([]byte, error) {
+	return envelope.Decrypt(key, envelope.Params{Algorithm: algorithm, Mode: mode, Padding: pad}, ciphertext, iv, aad)
+}
+
+// newFileStream starts encrypting or decrypting a file in chunks; an empty
+// iv draws a random one when encrypting
+func newFileStream(algorithm string, key, iv []byte, mode, pad string, encrypt bool) (*envelope.Stream, error) {
+	p := envelope.Params{Algorithm: algorithm, Mode: mode, Padding: pad}
+	if encrypt {
+		return envelope.NewEncryptStream(key, p, iv)
+	}
+	return envelope.NewDecryptStream(key, p, iv)
+}
+
+// maxRandomSize bounds the keys and IVs generateKey and generateIV make
+// without an algorithm to check them against, in bytes
+const maxRandomSize = 1024
+
+// generateKey returns a random key of bits bits. With an algorithm the size
+// must be one the cipher accepts.
+func generateKey(bits int, algorithm string) ([]byte, error) {
+	if bits <= 0 || bits%8 != 0 || bits > 8*maxRandomSize {
+		return nil, argErrorf("key size must be a positive multiple of 8 bits up to %d, got %d", 8*maxRandomSize, bits)
+	}
+	if algorithm != "" {
+		spec, ok := encryption.LookupCipher(algorithm)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", encryption.ErrUnknownCipher, algorithm)
+		}
+		accepted := false
+		for _, size := range spec.AcceptedKeySizes() {
+			if bits == 8*size {
+				accepted = true
+			}
+		}
+		if !accepted {
+			return nil, argErrorf("%s does not accept %d-bit keys", spec.Name, bits)
+		}
+	}
+	return randomBytes(bits / 8)
+}
+
+// generateIV returns a random IV of size bytes. With an algorithm the size
+// must be the cipher's block size.
+func generateIV(size int, algorithm string) ([]byte, error) {
+	if size <= 0 || size > maxRandomSize {
+		return nil, argErrorf("IV size must be between 1 and %d bytes, got %d", maxRandomSize, size)
+	}
+	if algorithm != "" {
+		spec, ok := encryption.LookupCipher(algorithm)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", encryption.ErrUnknownCipher, algorithm)
+		}
+		if size != spec.BlockSize {
+			return nil, argErrorf("%s needs a %d-byte IV, got %d", spec.Name, spec.BlockSize, size)
+		}
+	}
+	return randomBytes(size)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ratchetSeal encrypts plaintext under the next sending key of the ratchet
+// saved in state. The message key goes through crypto.DeriveChatKeys for the
+// chat's cipher, and the envelope's MAC covers the ratchet header. It returns
+// the new state, the header and the envelope.
+func ratchetSeal(state []byte, chatID int64, p envelope.Params, plaintext []byte) ([]byte, []byte, []byte, error) {
+	spec, ok := encryption.LookupCipher(p.Algorithm)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("%w: %s", encryption.ErrUnknownCipher, p.Algorithm)
+	}
+	var r crypto.Ratchet
+	if err := json.Unmarshal(state, &r); err != nil {
+		return nil, nil, nil, err
+	}
+	defer r.Close()
+	h, mk, err := r.Next()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keys, err := crypto.DeriveChatKeys(mk, chatID, spec.KeySize)
+	crypto.Wipe(mk)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer keys.Wipe()
+	header, err := h.MarshalBinary()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	blob, err := envelope.Seal(keys.MessageKey, keys.MACKey, p, plaintext, header)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	newState, err := json.Marshal(&r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return newState, header, blob, nil
+}
+
+// ratchetOpen reverses ratchetSeal. On error the caller keeps its old state.
+func ratchetOpen(state []byte, chatID int64, header, blob []byte) ([]byte, []byte, error) {
+	h, err := crypto.ParseRatchetHeader(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := envelope.Parse(blob)
+	if err != nil {
+		return nil, nil, err
+	}
+	spec, ok := encryption.LookupCipher(e.Params.Algorithm)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", encryption.ErrUnknownCipher, e.Params.Algorithm)
+	}
+	var r crypto.Ratchet
+	if err := json.Unmarshal(state, &r); err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	mk, err := r.Receive(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, err := crypto.DeriveChatKeys(mk, chatID, spec.KeySize)
+	crypto.Wipe(mk)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer keys.Wipe()
+	plaintext, _, err := envelope.Open(keys.MessageKey, keys.MACKey, blob, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	newState, err := json.Marshal(&r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newState, plaintext, nil
+}
+
+// newXTS builds the XTS mode used for attachments from algorithm and key, the
+// data key followed by the tweak key of the same length
+func newXTS(algorithm string, key []byte) (*modes.XTS, error) {
+	if len(key)%2 != 0 {
+		return nil, fmt.Errorf("%w: XTS key must be two keys of the same length, got %d bytes", encryption.ErrInvalidKeySize, len(key))
+	}
+	data, err := encryption.GetCipher(algorithm, key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	tweak, err := encryption.GetCipher(algorithm, key[len(key)/2:])
+	if err != nil {
+		encryption.WipeCipher(data)
+		return nil, err
+	}
+	xts, err := modes.NewXTS(data, tweak, modes.XTSSectorSize)
+	if err != nil {
+		encryption.WipeCipher(data)
+		encryption.WipeCipher(tweak)
+		return nil, err
+	}
+	return xts, nil
+}
+
+// cryptSectors encrypts or decrypts data in XTS, data starting at sector
+// firstSector of its blob
+func cryptSectors(algorithm string, key, data []byte, firstSector uint64, encrypt bool) ([]byte, error) {
+	xts, err := newXTS(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	defer xts.Wipe()
+	out := make([]byte, len(data))
+	if encrypt {
+		err = xts.Encrypt(out, data, firstSector)
+	} else {
+		err = xts.Decrypt(out, data, firstSector)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
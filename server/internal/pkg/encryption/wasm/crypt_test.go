@@ -0,0 +1,276 @@
+package wasm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/envelope"
+	"MinMsgr/server/internal/pkg/encryption/modes"
+)
+
+var testKeys = map[string][]byte{
+	"LOKI97": []byte("0123456789ABCDEF"),
+	"RC6":    []byte("0123456789ABCDEF0123456789ABCDEF"),
+	"AES":    []byte("0123456789ABCDEF0123456789ABCDEF"),
+	"MARS":   []byte("0123456789ABCDEF0123456789ABCDEF"),
+}
+
+func TestEncryptWithModeRoundTrip(t *testing.T) {
+	modeNames := []string{"ECB", "CBC", "PCBC", "CFB", "OFB", "CTR", "RANDOM_DELTA"}
+	paddings := []string{"ZEROS", "PKCS7", "ANSI_X923", "ISO_10126"}
+	plaintext := []byte("Hello, World! This spans several blocks.")
+
+	for alg, key := range testKeys {
+		for _, mode := range modeNames {
+			for _, pad := range paddings {
+				ct, iv, err := encryptWithMode(alg, key, append([]byte(nil), plaintext...), nil, nil, mode, pad)
+				if err != nil {
+					t.Fatalf("%s/%s/%s: encrypt failed: %v", alg, mode, pad, err)
+				}
+				if len(iv) == 0 {
+					t.Fatalf("%s/%s/%s: no IV generated", alg, mode, pad)
+				}
+				pt, err := decryptWithMode(alg, key, ct, iv, nil, mode, pad)
+				if err != nil {
+					t.Fatalf("%s/%s/%s: decrypt failed: %v", alg, mode, pad, err)
+				}
+				if !bytes.Equal(pt, plaintext) {
+					t.Fatalf("%s/%s/%s: round trip gave %q", alg, mode, pad, pt)
+				}
+			}
+		}
+	}
+}
+
+// randomDeltaVectors pin the RANDOM_DELTA output; bindings_test.go checks the
+// WASM build against the same vectors
+var randomDeltaVectors = []struct {
+	algorithm, iv, plaintext, ciphertext string
+}{
+	{"AES", "000102030405060708090a0b0c0d0e0f", "52616e646f6d2064656c7461207370616e7320746872656520626c6f636b7321", "072659ef40f41c79645605527cc3d86250cec4ef452a3d1343f1b7651e5f114d522ec78862ed76a7f916f7c40d313471"},
+	{"LOKI97", "0001020304050607", "52616e646f6d2064656c7461207370616e7320746872656520626c6f636b7321", "2bb653e5ad057b4de3581d26e3e0ef5c203ff9053505d7e9e6cb37eca50c10e1b4508b4164d51c08"},
+}
+
+func TestRandomDeltaKnownAnswer(t *testing.T) {
+	for _, v := range randomDeltaVectors {
+		iv, _ := hex.DecodeString(v.iv)
+		plaintext, _ := hex.DecodeString(v.plaintext)
+
+		ct, _, err := encryptWithMode(v.algorithm, testKeys[v.algorithm], plaintext, iv, nil, "RANDOM_DELTA", "PKCS7")
+		if err != nil {
+			t.Fatalf("%s: encrypt failed: %v", v.algorithm, err)
+		}
+		if hex.EncodeToString(ct) != v.ciphertext {
+			t.Fatalf("%s: expected %s, got %x", v.algorithm, v.ciphertext, ct)
+		}
+		pt, err := decryptWithMode(v.algorithm, testKeys[v.algorithm], ct, iv, nil, "RANDOM_DELTA", "PKCS7")
+		if err != nil {
+			t.Fatalf("%s: decrypt failed: %v", v.algorithm, err)
+		}
+		if !bytes.Equal(pt, plaintext) {
+			t.Fatalf("%s: round trip gave %q", v.algorithm, pt)
+		}
+	}
+}
+
+func TestEncryptWithModeHonorsMode(t *testing.T) {
+	key := testKeys["RC6"]
+	iv := []byte("0123456789ABCDEF")
+	plaintext := []byte("Hello, World!!!!")
+
+	ecb, _, err := encryptWithMode("RC6", key, append([]byte(nil), plaintext...), iv, nil, "ECB", "PKCS7")
+	if err != nil {
+		t.Fatalf("ECB encrypt failed: %v", err)
+	}
+	cbc, _, err := encryptWithMode("RC6", key, append([]byte(nil), plaintext...), iv, nil, "CBC", "PKCS7")
+	if err != nil {
+		t.Fatalf("CBC encrypt failed: %v", err)
+	}
+	if bytes.Equal(ecb, cbc) {
+		t.Fatal("CBC ciphertext equals ECB ciphertext; mode was ignored")
+	}
+}
+
+func TestEncryptWithModeRejectsUnknownNames(t *testing.T) {
+	key := testKeys["RC6"]
+	if _, _, err := encryptWithMode("DES", key, []byte("x"), nil, nil, "CBC", "PKCS7"); err == nil {
+		t.Fatal("expected error for unknown algorithm")
+	}
+	if _, _, err := encryptWithMode("RC6", key, []byte("x"), nil, nil, "XTS", "PKCS7"); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+	if _, err := decryptWithMode("RC6", key, make([]byte, 16), make([]byte, 16), nil, "CBC", "NONE"); err == nil {
+		t.Fatal("expected error for unknown padding")
+	}
+}
+
+func TestEncryptWithModeGCM(t *testing.T) {
+	key := testKeys["AES"]
+	plaintext := []byte("Hello, World! Authenticated.")
+	aad := []byte("chat 7")
+
+	ct, iv, err := encryptWithMode("AES", key, append([]byte(nil), plaintext...), nil, aad, "GCM", "PKCS7")
+	if err != nil {
+		t.Fatalf("GCM encrypt failed: %v", err)
+	}
+	pt, err := decryptWithMode("AES", key, ct, iv, aad, "GCM", "PKCS7")
+	if err != nil {
+		t.Fatalf("GCM decrypt failed: %v", err)
+	}
+	if !bytes.Equal(pt, plaintext) {
+		t.Fatalf("GCM round trip gave %q", pt)
+	}
+
+	ct[0] ^= 1
+	if _, err := decryptWithMode("AES", key, ct, iv, aad, "GCM", "PKCS7"); !errors.Is(err, modes.ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed for tampered ciphertext, got %v", err)
+	}
+	if _, _, err := encryptWithMode("AES", key, plaintext, nil, aad, "CBC", "PKCS7"); err == nil {
+		t.Fatal("expected error for additional data outside GCM")
+	}
+}
+
+func TestEncryptWithModeEncryptThenMAC(t *testing.T) {
+	key := testKeys["LOKI97"]
+	plaintext := []byte("Hello, World! Authenticated.")
+	aad := []byte("chat 7")
+
+	ct, iv, err := encryptWithMode("LOKI97", key, append([]byte(nil), plaintext...), nil, aad, "CTR+HMAC", "PKCS7")
+	if err != nil {
+		t.Fatalf("CTR+HMAC encrypt failed: %v", err)
+	}
+	if ct[0] != modes.EtMVersion {
+		t.Fatalf("expected version byte %d, got %d", modes.EtMVersion, ct[0])
+	}
+	pt, err := decryptWithMode("LOKI97", key, ct, iv, aad, "CTR+HMAC", "PKCS7")
+	if err != nil {
+		t.Fatalf("CTR+HMAC decrypt failed: %v", err)
+	}
+	if !bytes.Equal(pt, plaintext) {
+		t.Fatalf("CTR+HMAC round trip gave %q", pt)
+	}
+
+	ct[len(ct)-1] ^= 1
+	if _, err := decryptWithMode("LOKI97", key, ct, iv, aad, "CTR+HMAC", "PKCS7"); !errors.Is(err, modes.ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed for tampered tag, got %v", err)
+	}
+}
+
+func TestCryptSectorsRandomAccess(t *testing.T) {
+	key := append(append([]byte(nil), testKeys["AES"]...), []byte("FEDCBA9876543210FEDCBA9876543210")...)
+	data := bytes.Repeat([]byte("attachment data "), modes.XTSSectorSize*3/16+5)
+
+	ct, err := cryptSectors("AES", key, data, 0, true)
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	if len(ct) != len(data) {
+		t.Fatalf("expected %d bytes, got %d", len(data), len(ct))
+	}
+
+	// The second sector alone decrypts with its own number
+	second := ct[modes.XTSSectorSize : 2*modes.XTSSectorSize]
+	pt, err := cryptSectors("AES", key, second, 1, false)
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if !bytes.Equal(pt, data[modes.XTSSectorSize:2*modes.XTSSectorSize]) {
+		t.Fatal("second sector decrypted to different data")
+	}
+
+	if _, err := cryptSectors("AES", key[:33], data, 0, true); err == nil {
+		t.Fatal("expected error for an odd key length")
+	}
+	if _, err := cryptSectors("LOKI97", make([]byte, 32), data, 0, true); err == nil {
+		t.Fatal("expected error for XTS with LOKI97")
+	}
+}
+
+func TestGenerateKeyAndIV(t *testing.T) {
+	for alg := range testKeys {
+		spec, _ := encryption.LookupCipher(alg)
+		for _, size := range spec.AcceptedKeySizes() {
+			key, err := generateKey(8*size, alg)
+			if err != nil {
+				t.Fatalf("%s: %d-bit key refused: %v", alg, 8*size, err)
+			}
+			if len(key) != size {
+				t.Fatalf("%s: generated %d-byte key, expected %d", alg, len(key), size)
+			}
+		}
+		if _, err := generateKey(8*spec.KeySize+8, alg); err == nil {
+			t.Errorf("%s: %d-bit key accepted", alg, 8*spec.KeySize+8)
+		}
+
+		iv, err := generateIV(spec.BlockSize, alg)
+		if err != nil || len(iv) != spec.BlockSize {
+			t.Fatalf("%s: generateIV(%d) = %x, %v", alg, spec.BlockSize, iv, err)
+		}
+		if _, err := generateIV(spec.BlockSize+1, alg); err == nil {
+			t.Errorf("%s: %d-byte IV accepted", alg, spec.BlockSize+1)
+		}
+	}
+
+	a, _ := generateKey(256, "")
+	b, _ := generateKey(256, "")
+	if len(a) != 32 || bytes.Equal(a, b) {
+		t.Fatalf("generateKey(256) gave %x and %x", a, b)
+	}
+	for _, bits := range []int{0, -8, 12, 8*maxRandomSize + 8} {
+		if _, err := generateKey(bits, ""); err == nil {
+			t.Errorf("%d-bit key accepted", bits)
+		}
+	}
+	if _, err := generateIV(16, "NOPE"); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
+
+func TestRatchetSealOpen(t *testing.T) {
+	secret := bytes.Repeat([]byte{1}, 32)
+	states := make([][]byte, 2)
+	for i := range states {
+		r, err := crypto.NewRatchet(secret, 3, i == 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if states[i], err = json.Marshal(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := envelope.Params{Algorithm: "RC6", Mode: "CBC", Padding: "PKCS7"}
+
+	alice, header, blob, err := ratchetSeal(states[0], 3, p, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), header...)
+	tampered[len(tampered)-1] ^= 1
+	if _, _, err := ratchetOpen(states[1], 3, tampered, blob); err == nil {
+		t.Fatal("opened a message with a tampered header")
+	}
+	bob, pt, err := ratchetOpen(states[1], 3, header, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "hello" {
+		t.Fatalf("opened to %q", pt)
+	}
+	if _, _, err := ratchetOpen(bob, 3, header, blob); !errors.Is(err, crypto.ErrRatchetKeyUsed) {
+		t.Fatalf("replay: err = %v, want ErrRatchetKeyUsed", err)
+	}
+
+	// The reply goes the other way under a new ratchet key
+	_, header, blob, err = ratchetSeal(bob, 3, envelope.Params{Algorithm: "AES", Mode: "CTR", Padding: "PKCS7"}, []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, pt, err = ratchetOpen(alice, 3, header, blob); err != nil || string(pt) != "hi" {
+		t.Fatalf("reply opened to %q, %v", pt, err)
+	}
+}
@@ -0,0 +1,94 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/envelope"
+	"MinMsgr/server/internal/pkg/encryption/modes"
+	"MinMsgr/server/internal/pkg/encryption/nonce"
+)
+
+// Error codes of the {error, code} objects the bindings return, so the
+// client can handle a failure without parsing its message. The message
+// stays in error for logs and older callers.
+const (
+	CodeInvalidArgument  = "INVALID_ARGUMENT"
+	CodeUnknownAlgorithm = "UNKNOWN_ALGORITHM"
+	CodeUnknownMode      = "UNKNOWN_MODE"
+	CodeUnknownPadding   = "UNKNOWN_PADDING"
+	CodeNotStreamable    = "NOT_STREAMABLE"
+	CodeInvalidKey       = "INVALID_KEY"
+	CodeInvalidParams    = "INVALID_PARAMETERS"
+	CodeAuthFailed       = "AUTH_FAILED"
+	CodeMalformed        = "MALFORMED"
+	CodeIVReused         = "IV_REUSED"
+	CodeExhausted        = "EXHAUSTED"
+	CodeRatchetRejected  = "RATCHET_REJECTED"
+	CodeInternal         = "INTERNAL"
+	CodeCryptoFailed     = "CRYPTO_FAILED"
+)
+
+// errorCodes maps the errors of the packages the bindings call to codes,
+// checked with errors.Is in order
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{encryption.ErrUnknownCipher, CodeUnknownAlgorithm},
+	{envelope.ErrUnknownMode, CodeUnknownMode},
+	{envelope.ErrUnknownPadding, CodeUnknownPadding},
+	{envelope.ErrNotStreamable, CodeNotStreamable},
+	{encryption.ErrInvalidKeySize, CodeInvalidKey},
+	{crypto.ErrInvalidPublicKey, CodeInvalidKey},
+	{crypto.ErrInvalidIdentityKey, CodeInvalidKey},
+	{crypto.ErrInvalidDHParameters, CodeInvalidParams},
+	{crypto.ErrInvalidKDFParams, CodeInvalidParams},
+	{crypto.ErrWeakKeyBackup, CodeInvalidParams},
+	{modes.ErrAuthFailed, CodeAuthFailed},
+	{crypto.ErrUnwrapIntegrity, CodeAuthFailed},
+	{crypto.ErrInvalidSignature, CodeAuthFailed},
+	{envelope.ErrMalformed, CodeMalformed},
+	{envelope.ErrMissingMAC, CodeMalformed},
+	{modes.ErrUnsupportedVersion, CodeMalformed},
+	{crypto.ErrUnsupportedKeyWrap, CodeMalformed},
+	{crypto.ErrInvalidRatchetHeader, CodeMalformed},
+	{crypto.ErrInvalidRatchetState, CodeMalformed},
+	{nonce.ErrIVReuse, CodeIVReused},
+	{nonce.ErrExhausted, CodeExhausted},
+	{crypto.ErrRatchetKeyUsed, CodeRatchetRejected},
+	{crypto.ErrRatchetTooManySkipped, CodeRatchetRejected},
+	{errPanic, CodeInternal},
+}
+
+// errPanic marks a panic recovered in a binding
+var errPanic = errors.New("panic")
+
+// An argError is a bad argument from JavaScript: missing, of the wrong type
+// or out of range
+type argError struct {
+	msg string
+}
+
+func (e *argError) Error() string { return e.msg }
+
+// argErrorf returns an argError with a formatted message
+func argErrorf(format string, a ...any) error {
+	return &argError{fmt.Sprintf(format, a...)}
+}
+
+// errorCode returns the code of err, CodeCryptoFailed for errors without one
+func errorCode(err error) string {
+	var arg *argError
+	if errors.As(err, &arg) {
+		return CodeInvalidArgument
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return CodeCryptoFailed
+}
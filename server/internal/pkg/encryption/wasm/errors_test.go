@@ -0,0 +1,68 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption/nonce"
+)
+
+func TestErrorCode(t *testing.T) {
+	key := testKeys["AES"]
+	_, err := generateKey(100, "")
+	if code := errorCode(err); code != CodeInvalidArgument {
+		t.Errorf("bad key size: got %s", code)
+	}
+	_, err = generateIV(16, "NOPE")
+	if code := errorCode(err); code != CodeUnknownAlgorithm {
+		t.Errorf("unknown algorithm: got %s", code)
+	}
+	_, _, err = encryptWithMode("AES", key, []byte("x"), nil, nil, "NOPE", "PKCS7")
+	if code := errorCode(err); code != CodeUnknownMode {
+		t.Errorf("unknown mode: got %s", code)
+	}
+	_, _, err = encryptWithMode("AES", key, []byte("x"), nil, nil, "CBC", "NOPE")
+	if code := errorCode(err); code != CodeUnknownPadding {
+		t.Errorf("unknown padding: got %s", code)
+	}
+	_, err = newFileStream("AES", key, nil, "GCM", "PKCS7", true)
+	if code := errorCode(err); code != CodeNotStreamable {
+		t.Errorf("GCM stream: got %s", code)
+	}
+	_, _, err = encryptWithMode("AES", key[:5], []byte("x"), nil, nil, "CBC", "PKCS7")
+	if code := errorCode(err); code != CodeInvalidKey {
+		t.Errorf("short key: got %s", code)
+	}
+
+	ct, iv, err := encryptWithMode("AES", key, []byte("secret"), nil, nil, "GCM", "PKCS7")
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	ct[0] ^= 1
+	_, err = decryptWithMode("AES", key, ct, iv, nil, "GCM", "PKCS7")
+	if code := errorCode(err); code != CodeAuthFailed {
+		t.Errorf("tampered GCM: got %s", code)
+	}
+
+	guard := nonce.NewGuard()
+	iv = bytes.Repeat([]byte{1}, 16)
+	if err := guard.Use(key, iv); err != nil {
+		t.Fatalf("first use failed: %v", err)
+	}
+	if code := errorCode(guard.Use(key, iv)); code != CodeIVReused {
+		t.Errorf("reused IV: got %s", code)
+	}
+
+	if code := errorCode(fmt.Errorf("%w: boom", errPanic)); code != CodeInternal {
+		t.Errorf("panic: got %s", code)
+	}
+	if code := errorCode(fmt.Errorf("ratchet: %w", crypto.ErrRatchetKeyUsed)); code != CodeRatchetRejected {
+		t.Errorf("replayed ratchet message: got %s", code)
+	}
+	if code := errorCode(errors.New("something else")); code != CodeCryptoFailed {
+		t.Errorf("other error: got %s", code)
+	}
+}
@@ -1,18 +1,20 @@
 package helpers
 
 import (
+	"context"
 	"errors"
 
+	"MinMsgr/server/internal/authz"
 	"MinMsgr/server/internal/storage"
 )
 
 // ValidateUserExists checks if a user exists in the database
-func ValidateUserExists(db *storage.DB, userID int64) (*storage.User, error) {
+func ValidateUserExists(ctx context.Context, db *storage.DB, userID int64) (*storage.User, error) {
 	if userID <= 0 {
 		return nil, errors.New("invalid user ID")
 	}
 
-	user, err := db.GetUserByID(userID)
+	user, err := db.GetUserByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -24,34 +26,27 @@ func ValidateUserExists(db *storage.DB, userID int64) (*storage.User, error) {
 }
 
 // ValidateChatExists checks if a chat exists and user is a participant
-func ValidateChatExists(db *storage.DB, chatID, userID int64) (*storage.Chat, error) {
+func ValidateChatExists(ctx context.Context, db *storage.DB, chatID, userID int64) (*storage.Chat, error) {
 	if chatID <= 0 {
 		return nil, errors.New("invalid chat ID")
 	}
 
-	chat, err := db.GetChat(chatID)
+	// Participant rules live in the authz package
+	access, err := authz.New(db).ChatAccess(context.Background(), userID, chatID)
 	if err != nil {
 		return nil, err
 	}
-	if chat == nil {
-		return nil, errors.New("chat not found")
-	}
-
-	// Verify user is participant
-	if chat.User1ID != userID && chat.User2ID != userID {
-		return nil, errors.New("user not in chat")
-	}
 
-	return chat, nil
+	return access.Chat, nil
 }
 
 // ValidateContactExists checks if a contact relationship exists
-func ValidateContactExists(db *storage.DB, userID1, userID2 int64) (*storage.Contact, error) {
+func ValidateContactExists(ctx context.Context, db *storage.DB, userID1, userID2 int64) (*storage.Contact, error) {
 	if userID1 <= 0 || userID2 <= 0 {
 		return nil, errors.New("invalid user IDs")
 	}
 
-	contact, err := db.GetContact(userID1, userID2)
+	contact, err := db.GetContact(ctx, userID1, userID2)
 	if err != nil {
 		return nil, err
 	}
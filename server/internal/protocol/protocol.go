@@ -1,10 +1,12 @@
 package protocol
 
 import (
+	"encoding/json"
 	"time"
 )
 
-// EncryptionAlgorithm type for available algorithms
+// EncryptionAlgorithm type for available algorithms. The algorithms a chat
+// can use are the ciphers registered in the encryption package.
 type EncryptionAlgorithm string
 
 const (
@@ -23,6 +25,7 @@ const (
 	OFB         EncryptionMode = "OFB"
 	CTR         EncryptionMode = "CTR"
 	RandomDelta EncryptionMode = "RANDOM_DELTA"
+	GCM         EncryptionMode = "GCM"
 )
 
 // PaddingMode type for padding schemes
@@ -35,6 +38,36 @@ const (
 	ISO10126 PaddingMode = "ISO_10126"
 )
 
+// Chat types
+const (
+	// ChatTypeDirect is a two-party chat between accepted contacts
+	ChatTypeDirect = "direct"
+	// ChatTypeSelf is a user's private "Saved Messages" chat (user1 == user2)
+	ChatTypeSelf = "self"
+)
+
+// Key exchange states of a direct chat (see DHStatus)
+const (
+	// DHStateAwaitingInitiator: no participant has published a key
+	DHStateAwaitingInitiator = "awaiting_initiator"
+	// DHStateAwaitingPeer: one participant published a key, the other has not
+	DHStateAwaitingPeer = "awaiting_peer"
+	// DHStateComplete: both keys are published and verify
+	DHStateComplete = "complete"
+	// DHStateStale: a published key no longer verifies against its owner's
+	// current identity key; the owner must publish a new one
+	DHStateStale = "stale"
+)
+
+// Key modes of a chat
+const (
+	// KeyModeServer chats may have a session key stored by the server
+	KeyModeServer = "server"
+	// KeyModeClientOnly chats never have session key material persisted
+	// server-side; their keys exist only on the participants' devices
+	KeyModeClientOnly = "client_only"
+)
+
 // WebSocket deadlines
 var (
 	ReadDeadline  = time.Now().Add(time.Hour)
@@ -51,12 +84,13 @@ type User struct {
 
 // Contact represents a contact relationship between two users
 type Contact struct {
-	ID        int64
-	User1ID   int64
-	User2ID   int64
-	Username  string
-	Status    string // "pending", "accepted", "blocked"
-	CreatedAt int64
+	ID          int64
+	User1ID     int64
+	User2ID     int64
+	RequesterID int64
+	Username    string
+	Status      string // "pending", "accepted", "blocked"
+	CreatedAt   int64
 }
 
 // Chat represents an encrypted chat room
@@ -64,12 +98,18 @@ type Chat struct {
 	ID        int64
 	User1ID   int64
 	User2ID   int64
+	ChatType  string // "direct", "self"
 	Algorithm string
 	Mode      string
 	Padding   string
 	Status    string // "active", "closed"
 	CreatedAt int64
 	ClosedAt  *int64
+	// LastActivityAt is the time of the latest message, used to order chat lists
+	LastActivityAt int64
+	// LastSeq is the sequence number of the newest message; a client that has
+	// seen a lower one has missed messages
+	LastSeq int64
 	// DH parameters for key exchange
 	DHPrime     []byte
 	DHGenerator []byte
@@ -125,6 +165,166 @@ type EncryptedMessage struct {
 	Timestamp  int64  `json:"timestamp"`
 	FileName   string `json:"file_name,omitempty"`
 	MimeType   string `json:"mime_type,omitempty"`
+	// ReplyToMessageID is the message in the same chat this one replies to
+	ReplyToMessageID *int64 `json:"reply_to_message_id,omitempty"`
+	// MessageUUID is an optional client-generated idempotency key, unique per chat
+	MessageUUID string `json:"message_uuid,omitempty"`
+	// Seq is the message's position in the chat (1, 2, 3, ...), assigned on
+	// insert. Gaps mean missed messages, or messages removed by retention.
+	Seq int64 `json:"seq,omitempty"`
+	// Preview is an optional link preview (e.g. OpenGraph data) encrypted by
+	// the client with the chat key under its own PreviewIV
+	Preview   []byte `json:"preview,omitempty"`
+	PreviewIV []byte `json:"preview_iv,omitempty"`
+	// RatchetHeader is the Double Ratchet header of a message encrypted under
+	// its own ratchet key (see crypto.RatchetHeader); nil for messages under
+	// the static chat key
+	RatchetHeader []byte `json:"ratchet_header,omitempty"`
+	// Urgent messages notify the recipient even if they muted the chat;
+	// the server rate-limits them per sender
+	Urgent bool `json:"urgent,omitempty"`
+	// ExpiresAt is an optional sender-chosen expiry (unix seconds). Expired
+	// messages are left out of the history and deleted by the purge worker.
+	ExpiresAt *int64 `json:"expires_at,omitempty"`
+	// AttachmentIDs references uploaded files when sending; Attachments
+	// describes them when the message is delivered or read from history
+	AttachmentIDs []int64     `json:"attachment_ids,omitempty"`
+	Attachments   []*FileInfo `json:"attachments,omitempty"`
+	// SearchTokens are optional client-computed keyed search tokens to index
+	// the message under; they are never returned
+	SearchTokens [][]byte `json:"-"`
+	// Status is the delivery state of the message: "sent", "delivered" or "read"
+	Status      string `json:"status,omitempty"`
+	DeliveredAt *int64 `json:"delivered_at,omitempty"`
+	ReadAt      *int64 `json:"read_at,omitempty"`
+}
+
+// UploadStatus describes a resumable chunked upload
+type UploadStatus struct {
+	UploadID     string `json:"upload_id"`
+	ChatID       int64  `json:"chat_id"`
+	FileName     string `json:"file_name"`
+	MimeType     string `json:"mime_type"`
+	TotalSize    int64  `json:"total_size"`
+	ReceivedSize int64  `json:"received_size"`
+	MaxChunkSize int64  `json:"max_chunk_size"`
+}
+
+// SignedFileURL is a time-limited download link that needs no Authorization header
+type SignedFileURL struct {
+	FileID    int64  `json:"file_id"`
+	URL       string `json:"url"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// FileInfo describes an uploaded file (its contents are encrypted client-side)
+type FileInfo struct {
+	ID        int64  `json:"id"`
+	ChatID    int64  `json:"chat_id"`
+	OwnerID   int64  `json:"owner_id"`
+	FileName  string `json:"file_name"`
+	MimeType  string `json:"mime_type"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"created_at"`
+	// Thumbnail is the hex-encoded, client-encrypted preview image with the
+	// preview's dimensions; all three are omitted if the file has none
+	Thumbnail string `json:"thumbnail,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+}
+
+// Message history page directions
+const (
+	PageBackward = "backward" // older messages, before a cursor
+	PageForward  = "forward"  // newer messages, after a cursor
+)
+
+// MessagePageRequest selects one page of a chat's message history.
+// At most one of BeforeID and AfterID may be set.
+type MessagePageRequest struct {
+	BeforeID  int64
+	AfterID   int64
+	Limit     int
+	Direction string // PageBackward or PageForward; inferred from the cursor if empty
+
+	// Optional metadata filters
+	SenderID   int64
+	HasFile    *bool
+	MediaClass string // MediaImage, MediaVideo, MediaAudio or MediaDocument
+	Since      int64  // unix seconds, inclusive
+	Until      int64  // unix seconds, inclusive
+	// FromSeq and ToSeq restrict the page to a range of sequence numbers
+	// (inclusive), e.g. to fetch only the messages missed while offline
+	FromSeq int64
+	ToSeq   int64
+	// IncludeArchived also returns messages moved to cold storage
+	IncludeArchived bool
+}
+
+// Media classes for filtering message history by attachment type
+const (
+	MediaImage    = "image"
+	MediaVideo    = "video"
+	MediaAudio    = "audio"
+	MediaDocument = "document"
+)
+
+// MessagePage is one page of message history in chronological order
+type MessagePage struct {
+	Messages  []*EncryptedMessage
+	Direction string
+	// NextCursor is the message ID to pass as before_id (backward) or
+	// after_id (forward) to continue in the same direction
+	NextCursor *int64
+	HasMore    bool
+}
+
+// ExportedMessage is one line of a chat export. Field names are part of the
+// export format and must stay stable; every field is always present.
+type ExportedMessage struct {
+	ID               int64       `json:"id"`
+	ChatID           int64       `json:"chat_id"`
+	SenderID         int64       `json:"sender_id"`
+	Ciphertext       string      `json:"ciphertext"` // hex
+	IV               string      `json:"iv"`         // hex
+	CreatedAt        int64       `json:"created_at"`
+	FileName         string      `json:"file_name"`
+	MimeType         string      `json:"mime_type"`
+	ReplyToMessageID *int64      `json:"reply_to_message_id"`
+	MessageUUID      string      `json:"message_uuid"`
+	Seq              int64       `json:"seq"`
+	Preview          string      `json:"preview"`        // hex, empty if none
+	PreviewIV        string      `json:"preview_iv"`     // hex, empty if none
+	RatchetHeader    string      `json:"ratchet_header"` // hex, empty if none
+	Urgent           bool        `json:"urgent"`
+	ExpiresAt        *int64      `json:"expires_at"`
+	Status           string      `json:"status"`
+	Attachments      []*FileInfo `json:"attachments"`
+}
+
+// SearchResult lists the messages matching an encrypted search, newest first
+type SearchResult struct {
+	MessageIDs []int64 `json:"message_ids"`
+	// NextCursor is the before_id to pass to continue the search
+	NextCursor *int64 `json:"next_cursor"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// SyncResponse lists what changed on an account since a sync cursor
+type SyncResponse struct {
+	// Messages from all chats, oldest first
+	Messages []*EncryptedMessage
+	// Chats and Contacts created or changed since the cursor
+	Chats    []*Chat
+	Contacts []*Contact
+	// ChatIDs and ContactIDs list everything that still exists, so clients
+	// can drop chats and contacts that were deleted
+	ChatIDs    []int64
+	ContactIDs []int64
+	// Cursor is passed as since on the next sync; while HasMore is set the
+	// next call continues with the remaining messages
+	Cursor  string
+	HasMore bool
 }
 
 // ContactRequest represents a contact management request
@@ -150,9 +350,63 @@ type GetContactsResponse struct {
 type ChatCreateRequest struct {
 	User1ID   int64  `json:"user1_id"`
 	User2ID   int64  `json:"user2_id"`
+	ChatType  string `json:"chat_type,omitempty"`
 	Algorithm string `json:"algorithm"`
 	Mode      string `json:"mode"`
 	Padding   string `json:"padding"`
+	// KeyAgreement is "DH" (the default) or "X25519"
+	KeyAgreement string `json:"key_agreement,omitempty"`
+	// DHGroup names the RFC 7919 group of a DH chat; empty uses the global
+	// DH parameters, which the registration keys belong to
+	DHGroup string `json:"dh_group,omitempty"`
+	// DHGroupBits picks the group by prime size instead: 2048, 3072 or 4096
+	DHGroupBits int `json:"dh_group_bits,omitempty"`
+	// KeyMode is "server" or "client_only"; empty uses the server default
+	KeyMode string `json:"key_mode,omitempty"`
+}
+
+// CryptoCapabilities lists the crypto parameters the server accepts for
+// chats, as returned by GET /api/crypto/capabilities
+type CryptoCapabilities struct {
+	Algorithms []CipherCapability `json:"algorithms"`
+	// CipherFamilies take parameters in the name, e.g. "RC6" accepts
+	// "RC6-32/12/16"
+	CipherFamilies []string            `json:"cipher_families"`
+	Modes          []ModeCapability    `json:"modes"`
+	Paddings       []string            `json:"paddings"`
+	DHGroups       []DHGroupCapability `json:"dh_groups"`
+	KeyAgreements  []string            `json:"key_agreements"`
+	// KeyModes are the key modes new chats may ask for; DefaultKeyMode is
+	// used when they ask for none. ClientOnlyKeys is set when the server is
+	// configured to never store session keys, for any chat.
+	KeyModes       []string `json:"key_modes"`
+	DefaultKeyMode string   `json:"default_key_mode"`
+	ClientOnlyKeys bool     `json:"client_only_keys"`
+}
+
+// CipherCapability describes a supported algorithm
+type CipherCapability struct {
+	Name      string `json:"name"`
+	BlockSize int    `json:"block_size"`
+	KeySize   int    `json:"key_size"`
+	KeySizes  []int  `json:"key_sizes"`
+}
+
+// ModeCapability describes a supported mode. BlockSize is set for modes that
+// only work with one cipher block size.
+type ModeCapability struct {
+	Name          string `json:"name"`
+	RequiresIV    bool   `json:"requires_iv"`
+	Authenticated bool   `json:"authenticated"`
+	BlockSize     int    `json:"block_size,omitempty"`
+}
+
+// DHGroupCapability describes a supported Diffie-Hellman group
+type DHGroupCapability struct {
+	Name      string `json:"name"`
+	Bits      int    `json:"bits"`
+	Generator int    `json:"generator"`
+	Default   bool   `json:"default,omitempty"`
 }
 
 // ChatResponse represents a chat operation response
@@ -161,11 +415,187 @@ type ChatResponse struct {
 	ChatID    int64  `json:"chat_id,omitempty"`
 	User1ID   int64  `json:"user1_id,omitempty"`
 	User2ID   int64  `json:"user2_id,omitempty"`
+	ChatType  string `json:"chat_type,omitempty"`
 	Algorithm string `json:"algorithm,omitempty"`
 	Mode      string `json:"mode,omitempty"`
 	Padding   string `json:"padding,omitempty"`
 	CreatedAt string `json:"created_at,omitempty"`
 	Error     string `json:"error,omitempty"`
+	// KeyAgreement is the key agreement of a direct chat
+	KeyAgreement string `json:"key_agreement,omitempty"`
+	// DHGroup is the named group of a DH chat, if its parameters are one
+	DHGroup string `json:"dh_group,omitempty"`
+	// HistoryRestored is set when a soft-closed chat was reopened with its messages
+	HistoryRestored bool `json:"history_restored,omitempty"`
+	// KeyMode is "server" or "client_only"
+	KeyMode string `json:"key_mode,omitempty"`
+}
+
+// ChatParticipant describes a chat member's per-chat activity
+type ChatParticipant struct {
+	UserID       int64  `json:"user_id"`
+	LastOpenedAt *int64 `json:"last_opened_at,omitempty"`
+}
+
+// ChatDetails is the detailed view of a single chat
+type ChatDetails struct {
+	ID           int64              `json:"id"`
+	User1ID      int64              `json:"user1_id"`
+	User2ID      int64              `json:"user2_id"`
+	ChatType     string             `json:"chat_type"`
+	Algorithm    string             `json:"algorithm"`
+	Mode         string             `json:"mode"`
+	Padding      string             `json:"padding"`
+	Status       string             `json:"status"`
+	CreatedAt    int64              `json:"created_at"`
+	ClosedAt     *int64             `json:"closed_at,omitempty"`
+	Participants []*ChatParticipant `json:"participants"`
+	Retention    *RetentionPolicy   `json:"retention"`
+	// HistoryRetained is true for a soft-closed chat whose messages are hidden
+	// until it is reopened
+	HistoryRetained bool  `json:"history_retained"`
+	LastSeq         int64 `json:"last_seq"`
+	// Verification is the SAS verification of a direct chat's key exchange
+	Verification *ChatVerification `json:"verification,omitempty"`
+}
+
+// ChatVerification is the SAS verification status of a chat. The chat is
+// verified when both participants confirmed the short code of its current
+// key exchange; a new public key from either side makes it unverified.
+type ChatVerification struct {
+	ChatID   int64 `json:"chat_id"`
+	Verified bool  `json:"verified"`
+	// ConfirmedBy lists the participants who confirmed the current key exchange
+	ConfirmedBy []int64 `json:"confirmed_by"`
+}
+
+// Key exchange states reported in chat statistics
+const (
+	KeyExchangeNotApplicable = "not_applicable"
+	KeyExchangeNotStarted    = "not_started"
+	KeyExchangePending       = "pending"
+	KeyExchangeComplete      = "complete"
+)
+
+// ParticipantStats holds per-participant message statistics of a chat
+type ParticipantStats struct {
+	UserID          int64 `json:"user_id"`
+	MessageCount    int64 `json:"message_count"`
+	CiphertextBytes int64 `json:"ciphertext_bytes"`
+}
+
+// KeyExchangeStatus describes how far the DH key exchange of a chat has progressed
+type KeyExchangeStatus struct {
+	State            string  `json:"state"`
+	KeyAgreement     string  `json:"key_agreement,omitempty"`
+	HasDHParameters  bool    `json:"has_dh_parameters"`
+	PublicKeyUserIDs []int64 `json:"public_key_user_ids"`
+	HasSessionKey    bool    `json:"has_session_key"`
+	KeyMode          string  `json:"key_mode,omitempty"`
+}
+
+// ChatStats is returned by the chat statistics endpoint
+type ChatStats struct {
+	ChatID               int64               `json:"chat_id"`
+	TotalMessages        int64               `json:"total_messages"`
+	TotalCiphertextBytes int64               `json:"total_ciphertext_bytes"`
+	FirstMessageAt       *int64              `json:"first_message_at,omitempty"`
+	LastMessageAt        *int64              `json:"last_message_at,omitempty"`
+	Participants         []*ParticipantStats `json:"participants"`
+	KeyExchange          *KeyExchangeStatus  `json:"key_exchange"`
+}
+
+// Notification levels for per-chat notification preferences
+const (
+	NotifyAlways   = "always"
+	NotifyMentions = "mentions"
+	NotifyNever    = "never"
+)
+
+// NotificationPrefs is a user's notification settings for one chat
+type NotificationPrefs struct {
+	ChatID    int64  `json:"chat_id"`
+	Level     string `json:"level"`
+	SoundID   string `json:"sound_id,omitempty"`
+	UpdatedAt int64  `json:"updated_at,omitempty"`
+}
+
+// RatchetChain is where a participant's Double Ratchet stands in a chat, as
+// far as the server follows it through message headers
+type RatchetChain struct {
+	SenderID  int64  `json:"sender_id"`
+	Epoch     int64  `json:"epoch"`
+	PublicKey string `json:"public_key"` // hex
+	LastN     int64  `json:"last_n"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// SafetyNumber is the fingerprint two participants of a chat compare to check
+// that they see each other's identity keys, and the keys it was made from
+type SafetyNumber struct {
+	ChatID           int64  `json:"chat_id"`
+	UserID           int64  `json:"user_id"`
+	OtherUserID      int64  `json:"other_user_id"`
+	SafetyNumber     string `json:"safety_number"`
+	IdentityKey      string `json:"identity_key"`       // hex
+	OtherIdentityKey string `json:"other_identity_key"` // hex
+}
+
+// DHStatus is the key exchange state of a chat. Previous is only set in
+// dh_state_changed events.
+type DHStatus struct {
+	ChatID   int64  `json:"chat_id"`
+	State    string `json:"state"`
+	Previous string `json:"previous,omitempty"`
+	// PublishedBy lists the participants whose public key the server holds
+	PublishedBy []int64 `json:"published_by"`
+	// StaleUserIDs lists the participants whose key no longer verifies
+	StaleUserIDs []int64 `json:"stale_user_ids,omitempty"`
+	ChangedAt    int64   `json:"changed_at"`
+}
+
+// DHSubmission is the replay protection every dh/init and dh/exchange
+// request carries: a random nonce, hex, used once per chat and participant,
+// and the client's Unix time when it made the request
+type DHSubmission struct {
+	Nonce     string `json:"nonce"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ContactVerification tells whether a user verified the current identity key
+// of a contact. A verification of an earlier key does not count.
+type ContactVerification struct {
+	UserID      int64  `json:"user_id"`
+	ContactID   int64  `json:"contact_id"`
+	IdentityKey string `json:"identity_key"` // hex, the contact's current key
+	Verified    bool   `json:"verified"`
+	VerifiedAt  int64  `json:"verified_at,omitempty"`
+}
+
+// PinnedMessage is a message pinned in a chat. Message is included when the
+// pin list is fetched and omitted from pin events.
+type PinnedMessage struct {
+	ChatID    int64             `json:"chat_id"`
+	MessageID int64             `json:"message_id"`
+	PinnedBy  int64             `json:"pinned_by"`
+	PinnedAt  int64             `json:"pinned_at"`
+	Message   *EncryptedMessage `json:"message,omitempty"`
+}
+
+// Draft is a user's unsent message for a chat, encrypted by the client.
+// Ciphertext and IV are hex encoded; both are empty when there is no draft.
+type Draft struct {
+	ChatID     int64  `json:"chat_id"`
+	Ciphertext string `json:"ciphertext"`
+	IV         string `json:"iv"`
+	UpdatedAt  int64  `json:"updated_at,omitempty"`
+}
+
+// RetentionPolicy describes how long a chat keeps its messages.
+// A value of 0 disables the corresponding limit.
+type RetentionPolicy struct {
+	Days        int `json:"days"`
+	MaxMessages int `json:"max_messages"`
 }
 
 // GetUserChatsResponse returns user's chats
@@ -174,6 +604,33 @@ type GetUserChatsResponse struct {
 }
 
 // WebSocketEvent represents a real-time event sent over WebSocket
+// Message delivery states
+const (
+	MessageStatusSent      = "sent"
+	MessageStatusDelivered = "delivered"
+	MessageStatusRead      = "read"
+)
+
+// ClientEvent is a message sent by a client over its WebSocket connection
+type ClientEvent struct {
+	Type string          `json:"type"` // "message_ack" or "message_receipt"
+	Data json.RawMessage `json:"data"`
+}
+
+// MessageAck acknowledges that a message_received event reached the client
+type MessageAck struct {
+	ChatID    int64 `json:"chat_id"`
+	MessageID int64 `json:"message_id"`
+}
+
+// MessageReceipt acknowledges every message of a chat up to and including
+// UpToMessageID at once
+type MessageReceipt struct {
+	ChatID        int64  `json:"chat_id"`
+	UpToMessageID int64  `json:"up_to_message_id"`
+	Status        string `json:"status"` // MessageStatusDelivered or MessageStatusRead
+}
+
 type WebSocketEvent struct {
 	Type      string      `json:"type"`    // "contact_request", "chat_created", "message", etc.
 	UserID    int64       `json:"user_id"` // Target user ID
@@ -230,3 +687,34 @@ type MessageEvent struct {
 	Action    string `json:"action"` // "new"
 	Timestamp int64  `json:"timestamp"`
 }
+
+// Device is a registered device of a user, with the X25519 public key key
+// shares are sealed to
+type Device struct {
+	ID         int64  `json:"id"`
+	UserID     int64  `json:"user_id"`
+	Name       string `json:"name"`
+	PublicKey  string `json:"public_key"` // hex
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+}
+
+// KeyShare is chat key material sealed by one device to another (see
+// crypto.SealKeyShare). The server cannot open it.
+type KeyShare struct {
+	ChatID            int64  `json:"chat_id"`
+	SenderDeviceID    int64  `json:"sender_device_id"`
+	RecipientDeviceID int64  `json:"recipient_device_id"`
+	Share             string `json:"share"` // hex
+	CreatedAt         int64  `json:"created_at,omitempty"`
+}
+
+// KeyBackup is a user's key bundle sealed under a backup passphrase (see
+// crypto.SealKeyBackup). The server cannot open it. Backup is left out of
+// upload responses.
+type KeyBackup struct {
+	Backup    string `json:"backup,omitempty"` // hex
+	KDF       string `json:"kdf"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
@@ -1,28 +1,42 @@
 package auth
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
 
+	"MinMsgr/server/internal/pkg/crypto"
 	"MinMsgr/server/internal/storage"
 
 	"github.com/dgrijalva/jwt-go"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrPurgeAdmin is returned when asked to purge an admin account, which must
+// first be removed from ADMIN_USER_IDS
+var ErrPurgeAdmin = errors.New("cannot purge an admin account")
+
 // Service implements authentication logic
 type Service struct {
 	jwtSecret string
 	store     Store
+	// admins are the user IDs allowed to use the admin endpoints
+	admins map[int64]bool
 }
 
 // Store defines the persistence interface
 type Store interface {
-	CreateUser(username, hashedPassword string) (int64, error)
-	GetUserByUsername(username string) (*storage.User, error)
-	GetUserByID(userID int64) (*storage.User, error)
-	SaveUserKeys(userID int64, publicKey, encryptedPrivateKey []byte) error
+	CreateUser(ctx context.Context, username, hashedPassword string) (int64, error)
+	GetUserByUsername(ctx context.Context, username string) (*storage.User, error)
+	UsernameExists(ctx context.Context, username string) (bool, error)
+	GetUserByID(ctx context.Context, userID int64) (*storage.User, error)
+	SaveUserKeys(ctx context.Context, userID int64, publicKey, encryptedPrivateKey []byte) error
+	SaveIdentityKey(ctx context.Context, userID int64, identityKey []byte) error
+	GetIdentityKey(ctx context.Context, userID int64) ([]byte, error)
+	PurgeUser(ctx context.Context, userID int64) (bool, error)
 }
 
 // Claims represents JWT claims
@@ -40,19 +54,45 @@ func New(jwtSecret string, store Store) *Service {
 	}
 }
 
+// SetAdmins sets the user IDs allowed to use the admin endpoints. Admins
+// are IDs rather than usernames because anyone can register a username
+// nobody holds, while IDs are never handed out twice.
+func (s *Service) SetAdmins(userIDs []int64) {
+	s.admins = make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		s.admins[id] = true
+	}
+}
+
+// IsAdmin reports whether userID is one of the admin user IDs
+func (s *Service) IsAdmin(userID int64) bool {
+	return s.admins[userID]
+}
+
+// PurgeUser permanently deletes an account and all of its data, e.g. for an
+// erasure request. Returns false if the user does not exist. Admins are
+// refused so that an admin ID never outlives its account.
+func (s *Service) PurgeUser(ctx context.Context, userID int64) (bool, error) {
+	if s.IsAdmin(userID) {
+		return false, ErrPurgeAdmin
+	}
+	return s.store.PurgeUser(ctx, userID)
+}
+
 // Register creates a new user account
 // Register creates a new user account and stores optional DH keys
-func (s *Service) Register(username, password string, publicKeyHex, encryptedPrivateKeyHex string) (int64, string, error) {
+func (s *Service) Register(ctx context.Context, username, password string, publicKeyHex, encryptedPrivateKeyHex string) (int64, string, error) {
 	if username == "" || password == "" {
 		return 0, "", fmt.Errorf("username and password cannot be empty")
 	}
 
 	// Check if user already exists - registration not allowed for existing usernames
-	existing, err := s.store.GetUserByUsername(username)
+	// Deleted accounts keep their username until they are purged
+	exists, err := s.store.UsernameExists(ctx, username)
 	if err != nil {
 		return 0, "", err
 	}
-	if existing != nil {
+	if exists {
 		// Username already registered - registration must fail
 		return 0, "", fmt.Errorf("username already exists")
 	}
@@ -61,7 +101,7 @@ func (s *Service) Register(username, password string, publicKeyHex, encryptedPri
 	hashedPassword := hashPassword(password)
 
 	// Create user (public/encrypted key can be saved after creation)
-	userID, err := s.store.CreateUser(username, hashedPassword)
+	userID, err := s.store.CreateUser(ctx, username, hashedPassword)
 	if err != nil {
 		return 0, "", err
 	}
@@ -76,7 +116,7 @@ func (s *Service) Register(username, password string, publicKeyHex, encryptedPri
 		if encryptedPrivateKeyHex != "" {
 			encPriv, _ = hex.DecodeString(encryptedPrivateKeyHex)
 		}
-		if err := s.store.SaveUserKeys(userID, pubBytes, encPriv); err != nil {
+		if err := s.store.SaveUserKeys(ctx, userID, pubBytes, encPriv); err != nil {
 			return userID, "", err
 		}
 		if len(encPriv) > 0 {
@@ -88,13 +128,13 @@ func (s *Service) Register(username, password string, publicKeyHex, encryptedPri
 }
 
 // Login authenticates a user and returns a JWT token and the user's encrypted private key (hex)
-func (s *Service) Login(username, password string) (string, string, error) {
+func (s *Service) Login(ctx context.Context, username, password string) (string, string, error) {
 	if username == "" || password == "" {
 		return "", "", fmt.Errorf("username and password cannot be empty")
 	}
 
 	// Get user from store
-	user, err := s.store.GetUserByUsername(username)
+	user, err := s.store.GetUserByUsername(ctx, username)
 	if err != nil {
 		return "", "", err
 	}
@@ -122,8 +162,8 @@ func (s *Service) Login(username, password string) (string, string, error) {
 }
 
 // GetUserPublicKey returns stored public key bytes for a user
-func (s *Service) GetUserPublicKey(userID int64) ([]byte, error) {
-	user, err := s.store.GetUserByID(userID)
+func (s *Service) GetUserPublicKey(ctx context.Context, userID int64) ([]byte, error) {
+	user, err := s.store.GetUserByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -133,6 +173,34 @@ func (s *Service) GetUserPublicKey(userID int64) ([]byte, error) {
 	return user.PublicKey, nil
 }
 
+// SetIdentityKey publishes the Ed25519 identity key a user signs their DH
+// public keys with. A new device publishes a new key; peers that pinned the
+// old one see the change. changed reports whether an earlier, different key
+// was replaced.
+func (s *Service) SetIdentityKey(ctx context.Context, userID int64, identityKeyHex string) (changed bool, err error) {
+	identityKey, err := hex.DecodeString(identityKeyHex)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", crypto.ErrInvalidIdentityKey, err)
+	}
+	if err := crypto.CheckIdentityKey(identityKey); err != nil {
+		return false, err
+	}
+	previous, err := s.store.GetIdentityKey(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if err := s.store.SaveIdentityKey(ctx, userID, identityKey); err != nil {
+		return false, err
+	}
+	return previous != nil && !bytes.Equal(previous, identityKey), nil
+}
+
+// GetUserIdentityKey returns a user's identity key, or nil if they have not
+// published one
+func (s *Service) GetUserIdentityKey(ctx context.Context, userID int64) ([]byte, error) {
+	return s.store.GetIdentityKey(ctx, userID)
+}
+
 // CreateToken creates a new JWT token for a user
 func (s *Service) CreateToken(userID int64, username string) (string, error) {
 	expirationTime := time.Now().Add(24 * time.Hour)
@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/pkg/encryption"
+	"MinMsgr/server/internal/pkg/encryption/capabilities"
+	"MinMsgr/server/internal/pkg/encryption/modes"
+	"MinMsgr/server/internal/pkg/encryption/padding"
+	"MinMsgr/server/internal/protocol"
+)
+
+var (
+	ErrInvalidMode         = errors.New("invalid mode")
+	ErrInvalidPadding      = errors.New("invalid padding")
+	ErrInvalidKeyAgreement = errors.New("invalid key agreement")
+	ErrInvalidDHGroup      = errors.New("invalid DH group")
+)
+
+// Capabilities lists the algorithms, modes, paddings and DH groups chats can
+// use, read from the cipher and mode registries so it always matches what
+// ValidateEncryption accepts. The configured DH group is the default.
+func (s *Service) Capabilities() *protocol.CryptoCapabilities {
+	caps := capabilities.Registry()
+	for i := range caps.DHGroups {
+		caps.DHGroups[i].Default = caps.DHGroups[i].Name == s.dhGroup.Name
+	}
+
+	caps.KeyModes = s.KeyModes()
+	caps.DefaultKeyMode = s.DefaultKeyMode()
+	caps.ClientOnlyKeys = s.clientOnlyKeys
+
+	return caps
+}
+
+// ResolveDHGroup returns the name of the DH group a chat asks for by name, by
+// prime size in bits, or by both, which must then name the same group. It
+// returns "" if neither is given.
+func ResolveDHGroup(name string, bits int) (string, error) {
+	if bits == 0 {
+		return name, nil
+	}
+	group, ok := crypto.LookupDHGroupBits(bits)
+	if !ok {
+		return "", fmt.Errorf("%w: no %d-bit group", ErrInvalidDHGroup, bits)
+	}
+	if name != "" && name != group.Name {
+		return "", fmt.Errorf("%w: %q is not the %d-bit group", ErrInvalidDHGroup, name, bits)
+	}
+	return group.Name, nil
+}
+
+// ValidateEncryption checks a chat's algorithm, mode, padding, key agreement
+// and DH group against Capabilities, including that the mode works with the
+// cipher's block size. An empty key agreement stands for DH; a DH group only
+// goes with DH.
+func ValidateEncryption(algorithm, mode, pad, keyAgreement, dhGroup string) error {
+	spec, ok := encryption.LookupCipher(algorithm)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidAlgorithm, algorithm)
+	}
+	if modes.GetMode(mode) == nil {
+		return fmt.Errorf("%w: %q", ErrInvalidMode, mode)
+	}
+	if size := modes.RequiredBlockSize(mode); size != 0 && size != spec.BlockSize {
+		return fmt.Errorf("%w: %s needs %d-bit blocks, %s has %d-bit blocks", ErrInvalidMode, mode, size*8, algorithm, spec.BlockSize*8)
+	}
+	if padding.GetPadder(pad) == nil {
+		return fmt.Errorf("%w: %q", ErrInvalidPadding, pad)
+	}
+	if keyAgreement != "" && !crypto.IsKeyAgreement(keyAgreement) {
+		return fmt.Errorf("%w: %q", ErrInvalidKeyAgreement, keyAgreement)
+	}
+	if dhGroup != "" {
+		if _, ok := crypto.LookupDHGroup(dhGroup); !ok {
+			return fmt.Errorf("%w: %q", ErrInvalidDHGroup, dhGroup)
+		}
+		if keyAgreement != "" && keyAgreement != crypto.KeyAgreementDH {
+			return fmt.Errorf("%w: %s chats have no DH group", ErrInvalidDHGroup, keyAgreement)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+// The key exchange state of a chat follows from the public keys the server
+// holds: awaiting_initiator without keys, awaiting_peer with one, complete
+// with both, and stale while a signed key no longer verifies against its
+// owner's identity key, e.g. after they set up a new device. The state is
+// recorded on the chat so that every transition is announced exactly once,
+// with a dh_state_changed event to both participants.
+
+// GetDHStatus returns the key exchange state of a chat
+func (s *Service) GetDHStatus(ctx context.Context, chatID, userID int64) (*protocol.DHStatus, error) {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return nil, err
+	}
+	if access.Chat.ChatType == protocol.ChatTypeSelf {
+		return nil, ErrNoKeyExchange
+	}
+	return s.updateDHState(ctx, access.Chat)
+}
+
+// dhStatus works out the key exchange state of a chat from its keys
+func (s *Service) dhStatus(ctx context.Context, chat *storage.Chat) (*protocol.DHStatus, error) {
+	status := &protocol.DHStatus{ChatID: chat.ID, PublishedBy: []int64{}}
+	for _, userID := range []int64{chat.User1ID, chat.User2ID} {
+		key, err := s.store.GetSignedDHPublicKey(ctx, chat.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			continue
+		}
+		status.PublishedBy = append(status.PublishedBy, userID)
+
+		// Unsigned keys predate identity keys and stay valid
+		if len(key.Signature) == 0 {
+			continue
+		}
+		_, err = s.identity.VerifyKeyExchange(ctx, userID, chat.ID, key.Epoch, key.PublicKey, key.Signature)
+		if errors.Is(err, crypto.ErrInvalidSignature) {
+			status.StaleUserIDs = append(status.StaleUserIDs, userID)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case len(status.StaleUserIDs) > 0:
+		status.State = protocol.DHStateStale
+	case len(status.PublishedBy) == 0:
+		status.State = protocol.DHStateAwaitingInitiator
+	case len(status.PublishedBy) == 1:
+		status.State = protocol.DHStateAwaitingPeer
+	default:
+		status.State = protocol.DHStateComplete
+	}
+	return status, nil
+}
+
+// updateDHState records the current key exchange state of a chat and
+// announces it to both participants if it changed
+func (s *Service) updateDHState(ctx context.Context, chat *storage.Chat) (*protocol.DHStatus, error) {
+	status, err := s.dhStatus(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+	recorded, changedAt, err := s.store.GetDHState(ctx, chat.ID)
+	if err != nil {
+		return nil, err
+	}
+	status.ChangedAt = changedAt
+	if recorded == status.State {
+		return status, nil
+	}
+
+	changed, err := s.store.SetDHState(ctx, chat.ID, recorded, status.State)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		// Another request moved the state first and announced it
+		return status, nil
+	}
+	status.ChangedAt = time.Now().Unix()
+	log.Printf("[ChatService] Key exchange of chat %d: %s -> %s", chat.ID, recorded, status.State)
+
+	if s.broadcastHandler != nil {
+		event := *status
+		event.Previous = recorded
+		for _, userID := range []int64{chat.User1ID, chat.User2ID} {
+			s.broadcastHandler(&protocol.WebSocketEvent{
+				Type:      "dh_state_changed",
+				UserID:    userID,
+				Timestamp: status.ChangedAt,
+				Data:      &event,
+			})
+		}
+	}
+	return status, nil
+}
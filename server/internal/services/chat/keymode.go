@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"MinMsgr/server/internal/protocol"
+)
+
+// Client-only key mode. A chat in client-only mode never has session key
+// material persisted server-side: storage refuses to save a session key for
+// it, moving a chat to the mode drops the one it had, and the message service
+// only accepts its messages once the participants' own key exchange is done.
+// With the server configured for client-only keys every chat is in the mode.
+
+var ErrInvalidKeyMode = errors.New("invalid key mode")
+
+// KeyModes lists the key modes new chats may ask for
+func (s *Service) KeyModes() []string {
+	if s.clientOnlyKeys {
+		return []string{protocol.KeyModeClientOnly}
+	}
+	return []string{protocol.KeyModeServer, protocol.KeyModeClientOnly}
+}
+
+// DefaultKeyMode is the key mode of chats that do not ask for one
+func (s *Service) DefaultKeyMode() string {
+	if s.clientOnlyKeys {
+		return protocol.KeyModeClientOnly
+	}
+	return protocol.KeyModeServer
+}
+
+// EnableClientOnlyKeys puts the server in client-only key mode: new chats may
+// only use client-only keys, and existing chats are moved to the mode, which
+// drops every session key stored so far
+func (s *Service) EnableClientOnlyKeys(ctx context.Context) error {
+	n, err := s.store.MakeAllChatsClientOnly(ctx)
+	if err != nil {
+		return err
+	}
+	s.clientOnlyKeys = true
+	log.Printf("[ChatService] Client-only keys enabled; moved %d chats to client-only key mode", n)
+	return nil
+}
+
+// resolveKeyMode returns the key mode a new chat asks for, or the default
+func (s *Service) resolveKeyMode(mode string) (string, error) {
+	if mode == "" {
+		return s.DefaultKeyMode(), nil
+	}
+	for _, allowed := range s.KeyModes() {
+		if mode == allowed {
+			return mode, nil
+		}
+	}
+	if s.clientOnlyKeys && mode == protocol.KeyModeServer {
+		return "", fmt.Errorf("%w: the server only allows client-only keys", ErrInvalidKeyMode)
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidKeyMode, mode)
+}
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+var ErrInvalidNotificationPrefs = errors.New("invalid notification preferences")
+
+// maxSoundIDLength matches the sound_id column size
+const maxSoundIDLength = 100
+
+// GetNotificationPrefs returns the user's notification preferences for a chat,
+// falling back to the default ("always", default sound) if none are stored
+func (s *Service) GetNotificationPrefs(ctx context.Context, chatID, userID int64) (*protocol.NotificationPrefs, error) {
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermRead); err != nil {
+		return nil, err
+	}
+
+	prefs, err := s.store.GetNotificationPrefs(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if prefs == nil {
+		return &protocol.NotificationPrefs{ChatID: chatID, Level: protocol.NotifyAlways}, nil
+	}
+	return toProtocolNotificationPrefs(prefs), nil
+}
+
+// ListNotificationPrefs returns every chat the user has customized notifications for
+func (s *Service) ListNotificationPrefs(ctx context.Context, userID int64) ([]*protocol.NotificationPrefs, error) {
+	prefsList, err := s.store.ListUserNotificationPrefs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*protocol.NotificationPrefs, 0, len(prefsList))
+	for _, prefs := range prefsList {
+		result = append(result, toProtocolNotificationPrefs(prefs))
+	}
+	return result, nil
+}
+
+// SetNotificationPrefs stores the user's notification preferences for a chat and
+// notifies the user's other devices so they pick up the change
+func (s *Service) SetNotificationPrefs(ctx context.Context, chatID, userID int64, level, soundID string) (*protocol.NotificationPrefs, error) {
+	switch level {
+	case protocol.NotifyAlways, protocol.NotifyMentions, protocol.NotifyNever:
+	default:
+		return nil, ErrInvalidNotificationPrefs
+	}
+	if len(soundID) > maxSoundIDLength {
+		return nil, ErrInvalidNotificationPrefs
+	}
+
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermRead); err != nil {
+		return nil, err
+	}
+
+	prefs, err := s.store.SaveNotificationPrefs(ctx, chatID, userID, level, soundID)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[ChatService] User %d set notifications for chat %d: level=%s, sound_id=%q", userID, chatID, level, soundID)
+
+	result := toProtocolNotificationPrefs(prefs)
+	s.broadcastNotificationPrefs(userID, result)
+	return result, nil
+}
+
+// ResetNotificationPrefs removes the user's stored preferences for a chat so
+// the defaults apply again
+func (s *Service) ResetNotificationPrefs(ctx context.Context, chatID, userID int64) (*protocol.NotificationPrefs, error) {
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermRead); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.DeleteNotificationPrefs(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	result := &protocol.NotificationPrefs{ChatID: chatID, Level: protocol.NotifyAlways}
+	s.broadcastNotificationPrefs(userID, result)
+	return result, nil
+}
+
+// broadcastNotificationPrefs sends the updated preferences to all of the user's connections
+func (s *Service) broadcastNotificationPrefs(userID int64, prefs *protocol.NotificationPrefs) {
+	if s.broadcastHandler == nil {
+		return
+	}
+	s.broadcastHandler(&protocol.WebSocketEvent{
+		Type:      "notification_prefs_updated",
+		UserID:    userID,
+		Timestamp: time.Now().Unix(),
+		Data: map[string]interface{}{
+			"chat_id":    prefs.ChatID,
+			"level":      prefs.Level,
+			"sound_id":   prefs.SoundID,
+			"updated_at": prefs.UpdatedAt,
+		},
+	})
+}
+
+func toProtocolNotificationPrefs(prefs *storage.NotificationPrefs) *protocol.NotificationPrefs {
+	return &protocol.NotificationPrefs{
+		ChatID:    prefs.ChatID,
+		Level:     prefs.Level,
+		SoundID:   prefs.SoundID,
+		UpdatedAt: prefs.UpdatedAt,
+	}
+}
@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"MinMsgr/server/internal/protocol"
+)
+
+// Key exchange submissions are protected against replay: each carries a
+// nonce, accepted once per chat and participant, and a timestamp, accepted
+// within MaxDHClockSkew of the server's clock. A published key also keeps
+// the timestamp of its submission, so a submission made before it, however
+// fresh its nonce, cannot reset the chat to an older key.
+
+var (
+	ErrInvalidDHNonce       = errors.New("key exchange nonce must be 16 to 32 random bytes, hex")
+	ErrStaleDHSubmission    = errors.New("key exchange submission is stale")
+	ErrReplayedDHSubmission = errors.New("key exchange submission was replayed")
+)
+
+// MaxDHClockSkew is how far the timestamp of a key exchange submission may be
+// from the server's clock
+const MaxDHClockSkew = 5 * time.Minute
+
+// Bounds on the decoded length of a key exchange nonce
+const (
+	minDHNonceSize = 16
+	maxDHNonceSize = 32
+)
+
+// checkDHSubmission accepts the nonce and timestamp of a key exchange
+// submission, or refuses and logs a stale or replayed one
+func (s *Service) checkDHSubmission(ctx context.Context, chatID, userID int64, sub protocol.DHSubmission) error {
+	nonce, err := hex.DecodeString(sub.Nonce)
+	if err != nil || len(nonce) < minDHNonceSize || len(nonce) > maxDHNonceSize {
+		return ErrInvalidDHNonce
+	}
+
+	now := time.Now()
+	skew := now.Sub(time.Unix(sub.Timestamp, 0))
+	if skew > MaxDHClockSkew || skew < -MaxDHClockSkew {
+		log.Printf("[ChatService] Refused a stale key exchange submission for chat %d from user %d: timestamp %d is %s off", chatID, userID, sub.Timestamp, skew.Round(time.Second))
+		return fmt.Errorf("%w: timestamp is more than %s off the server's clock", ErrStaleDHSubmission, MaxDHClockSkew)
+	}
+
+	// A nonce has to be remembered for as long as a submission carrying it
+	// could pass the timestamp check, which is up to twice the skew
+	notBefore := now.Add(-2 * MaxDHClockSkew).Unix()
+	fresh, err := s.store.UseDHNonce(ctx, chatID, userID, strings.ToLower(sub.Nonce), notBefore)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		log.Printf("[ChatService] Refused a replayed key exchange submission for chat %d from user %d: nonce %s was already used", chatID, userID, sub.Nonce)
+		return ErrReplayedDHSubmission
+	}
+	return nil
+}
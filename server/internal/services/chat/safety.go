@@ -0,0 +1,109 @@
+package chat
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/identity"
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/protocol"
+)
+
+// ErrNoIdentityKey is returned for a safety number when a participant has not
+// published an identity key yet
+var ErrNoIdentityKey = identity.ErrNoIdentityKey
+
+// SafetyNumber returns the safety number of a chat, made from the current
+// identity keys of both participants
+func (s *Service) SafetyNumber(ctx context.Context, chatID, userID int64) (*protocol.SafetyNumber, error) {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return nil, err
+	}
+	if access.Chat.ChatType == protocol.ChatTypeSelf {
+		return nil, ErrNoKeyExchange
+	}
+	return s.safetyNumber(ctx, chatID, userID, access.OtherUserID)
+}
+
+func (s *Service) safetyNumber(ctx context.Context, chatID, userID, otherUserID int64) (*protocol.SafetyNumber, error) {
+	identityKey, err := s.store.GetIdentityKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	otherIdentityKey, err := s.store.GetIdentityKey(ctx, otherUserID)
+	if err != nil {
+		return nil, err
+	}
+	if identityKey == nil || otherIdentityKey == nil {
+		return nil, ErrNoIdentityKey
+	}
+
+	number, err := crypto.SafetyNumber(userID, identityKey, otherUserID, otherIdentityKey)
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.SafetyNumber{
+		ChatID:           chatID,
+		UserID:           userID,
+		OtherUserID:      otherUserID,
+		SafetyNumber:     number,
+		IdentityKey:      hex.EncodeToString(identityKey),
+		OtherIdentityKey: hex.EncodeToString(otherIdentityKey),
+	}, nil
+}
+
+// NotifyIdentityKeyChanged tells the other participant of each of a user's
+// active chats that the user's identity key changed, with the chat's new
+// safety number, so their client can warn them to compare it again
+func (s *Service) NotifyIdentityKeyChanged(ctx context.Context, userID int64) error {
+	if s.broadcastHandler == nil {
+		return nil
+	}
+	chats, err := s.store.ListUserChats(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	notified := 0
+	for _, chat := range chats {
+		if chat.ChatType == protocol.ChatTypeSelf {
+			continue
+		}
+		otherUserID := chat.User1ID
+		if otherUserID == userID {
+			otherUserID = chat.User2ID
+		}
+
+		// Keys the user signed with the old identity key are stale now
+		if _, err := s.updateDHState(ctx, chat); err != nil {
+			return err
+		}
+
+		data := map[string]interface{}{
+			"chat_id": chat.ID,
+			"user_id": userID,
+		}
+		// Computed for the recipient, who has not published a key if this fails
+		if number, err := s.safetyNumber(ctx, chat.ID, otherUserID, userID); err == nil {
+			data["identity_key"] = number.OtherIdentityKey
+			data["safety_number"] = number.SafetyNumber
+		} else if !errors.Is(err, ErrNoIdentityKey) {
+			return err
+		}
+
+		s.broadcastHandler(&protocol.WebSocketEvent{
+			Type:      "key_changed",
+			UserID:    otherUserID,
+			Timestamp: time.Now().Unix(),
+			Data:      data,
+		})
+		notified++
+	}
+	log.Printf("[ChatService] Identity key of user %d changed; notified %d chats", userID, notified)
+	return nil
+}
@@ -1,32 +1,59 @@
 package chat
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
+	"strconv"
 	"time"
 
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/identity"
 	"MinMsgr/server/internal/pkg/crypto"
 	"MinMsgr/server/internal/protocol"
 	"MinMsgr/server/internal/storage"
 )
 
 var (
-	ErrChatNotFound     = errors.New("chat not found")
-	ErrUserNotInChat    = errors.New("user not in chat")
-	ErrInvalidAlgorithm = errors.New("invalid algorithm")
-	ErrNotChatCreator   = errors.New("only chat creator can close the chat")
+	ErrChatNotFound       = authz.ErrChatNotFound
+	ErrUserNotInChat      = authz.ErrUserNotInChat
+	ErrInvalidAlgorithm   = errors.New("invalid algorithm")
+	ErrNotChatCreator     = errors.New("only chat creator can close the chat")
+	ErrDeleteNotConfirmed = errors.New("chat deletion must be confirmed")
+	ErrNoKeyExchange      = errors.New("saved messages chat has no key exchange peer")
+	ErrInvalidRetention   = errors.New("invalid retention policy")
+	ErrStaleKeyEpoch      = errors.New("key exchange epoch must be above the current key's")
+
+	errActiveChatExists = errors.New("active chat already exists with this user")
+)
+
+// Upper bounds for per-chat retention settings
+const (
+	MaxRetentionDays     = 3650
+	MaxRetentionMessages = 1000000
 )
 
 type Service struct {
 	store            *storage.DB
+	access           *authz.Checker
+	identity         *identity.Verifier
 	broadcastHandler func(event interface{})
+	// dhGroup is the group global DH parameters are made from
+	dhGroup crypto.DHGroup
+	// clientOnlyKeys puts every chat in client-only key mode
+	clientOnlyKeys bool
 }
 
 func NewService(store *storage.DB) *Service {
+	dhGroup, _ := crypto.LookupDHGroup(crypto.DefaultDHGroup)
 	return &Service{
-		store: store,
+		store:    store,
+		access:   authz.New(store),
+		identity: identity.New(store),
+		dhGroup:  dhGroup,
 	}
 }
 
@@ -41,7 +68,25 @@ func (s *Service) GetStore() *storage.DB {
 }
 
 func (s *Service) CreateChat(ctx context.Context, req *protocol.ChatCreateRequest) (*protocol.ChatResponse, error) {
-	// Validate users don't create chat with themselves
+	dhGroup, err := ResolveDHGroup(req.DHGroup, req.DHGroupBits)
+	if err == nil {
+		err = ValidateEncryption(req.Algorithm, req.Mode, req.Padding, req.KeyAgreement, dhGroup)
+	}
+	var keyMode string
+	if err == nil {
+		keyMode, err = s.resolveKeyMode(req.KeyMode)
+	}
+	if err != nil {
+		return &protocol.ChatResponse{
+			Success: false,
+			Error:   err.Error(),
+		}, nil
+	}
+
+	// A chat with yourself is only allowed as the special Saved Messages chat
+	if req.ChatType == protocol.ChatTypeSelf {
+		return s.createSelfChat(ctx, req, keyMode)
+	}
 	if req.User1ID == req.User2ID {
 		return &protocol.ChatResponse{
 			Success: false,
@@ -50,7 +95,7 @@ func (s *Service) CreateChat(ctx context.Context, req *protocol.ChatCreateReques
 	}
 
 	// Validate users exist
-	user1, err := s.store.GetUserByID(req.User1ID)
+	user1, err := s.store.GetUserByID(ctx, req.User1ID)
 	if err != nil || user1 == nil {
 		return &protocol.ChatResponse{
 			Success: false,
@@ -58,7 +103,7 @@ func (s *Service) CreateChat(ctx context.Context, req *protocol.ChatCreateReques
 		}, nil
 	}
 
-	user2, err := s.store.GetUserByID(req.User2ID)
+	user2, err := s.store.GetUserByID(ctx, req.User2ID)
 	if err != nil || user2 == nil {
 		return &protocol.ChatResponse{
 			Success: false,
@@ -67,7 +112,7 @@ func (s *Service) CreateChat(ctx context.Context, req *protocol.ChatCreateReques
 	}
 
 	// Validate users are accepted contacts
-	contact, err := s.store.GetContact(req.User1ID, req.User2ID)
+	contact, err := s.store.GetContact(ctx, req.User1ID, req.User2ID)
 	if err != nil {
 		return &protocol.ChatResponse{
 			Success: false,
@@ -87,77 +132,137 @@ func (s *Service) CreateChat(ctx context.Context, req *protocol.ChatCreateReques
 		}, nil
 	}
 
+	keyAgreement := req.KeyAgreement
+	if keyAgreement == "" {
+		keyAgreement = crypto.KeyAgreementDH
+	}
+
 	// Use global DH parameters so clients that generated keys from global params
 	// will match the chat parameters. Generate global params if missing.
-	pBytes, gBytes, err := s.GetGlobalDHParams(ctx)
+	// A chat that names a DH group gets that group's parameters instead.
+	globalP, globalG, err := s.GetGlobalDHParams(ctx)
 	if err != nil {
 		return nil, err
 	}
-
-	// Check if a chat already exists between these users (might be closed)
-	existingChat, err := s.store.GetChatByUsers(req.User1ID, req.User2ID)
-	if err != nil {
-		return nil, err
+	var pBytes, gBytes []byte
+	if keyAgreement == crypto.KeyAgreementDH {
+		pBytes, gBytes = globalP, globalG
+		if group, ok := crypto.LookupDHGroup(dhGroup); ok {
+			pBytes, gBytes = group.P.Bytes(), group.G.Bytes()
+		}
 	}
 
 	var chatID int64
+	algorithm, mode, padding := req.Algorithm, req.Mode, req.Padding
+	historyRestored := false
+
+	// The chat row, its DH parameters and the participants' public keys are
+	// written together, so a failure cannot leave a chat without key material
+	err = s.store.WithTx(ctx, func(tx *storage.DB) error {
+		// Check if a chat already exists between these users (might be closed)
+		existingChat, err := tx.GetChatByUsers(ctx, req.User1ID, req.User2ID)
+		if err != nil {
+			return err
+		}
 
-	// If a chat exists and is closed, reopen it instead of creating a new one
-	if existingChat != nil && existingChat.Status == "closed" {
-		if err := s.store.ReopenChat(existingChat.ID); err != nil {
-			return nil, err
+		// If a chat exists and is closed, reopen it instead of creating a new one
+		if existingChat != nil && existingChat.Status == "closed" {
+			if err := tx.ReopenChat(ctx, existingChat.ID, existingChat.Version); err != nil {
+				return err
+			}
+			chatID = existingChat.ID
+			if existingChat.HistoryRetained {
+				// Keep the original encryption settings so the restored history stays readable
+				algorithm, mode, padding = existingChat.Algorithm, existingChat.Mode, existingChat.Padding
+				historyRestored = true
+				log.Printf("[ChatService] Reopened soft-closed chat with history: chat_id=%d, user1_id=%d, user2_id=%d, algo=%s", chatID, req.User1ID, req.User2ID, algorithm)
+			} else {
+				// Update algorithm/mode/padding if they changed
+				if err := tx.UpdateChatEncryption(ctx, existingChat.ID, existingChat.Version+1, req.Algorithm, req.Mode, req.Padding); err != nil {
+					return err
+				}
+				log.Printf("[ChatService] Reopened closed chat with new encryption: chat_id=%d, user1_id=%d, user2_id=%d, algo=%s", chatID, req.User1ID, req.User2ID, req.Algorithm)
+			}
+		} else if existingChat != nil {
+			// Chat already exists and is active - cannot create or recreate with different parameters
+			log.Printf("[ChatService] Active chat already exists: chat_id=%d, user1_id=%d, user2_id=%d", existingChat.ID, req.User1ID, req.User2ID)
+			return errActiveChatExists
+		} else {
+			// Create new chat
+			chatID, err = tx.CreateChat(ctx, req.User1ID, req.User2ID, protocol.ChatTypeDirect, req.Algorithm, req.Mode, req.Padding)
+			if err != nil {
+				return err
+			}
+			log.Printf("[ChatService] Created new chat: chat_id=%d, user1_id=%d, user2_id=%d", chatID, req.User1ID, req.User2ID)
 		}
-		// Update algorithm/mode/padding if they changed
-		if err := s.store.UpdateChatEncryption(existingChat.ID, req.Algorithm, req.Mode, req.Padding); err != nil {
-			return nil, err
+
+		// Save the key agreement, with the DH parameters (p, g) for both
+		// clients to use. A reopened chat keeps them if its history was
+		// restored or they did not change; otherwise the public keys made
+		// for the old ones go too.
+		stored, err := tx.GetKeyAgreement(ctx, chatID)
+		if err != nil {
+			return err
 		}
-		chatID = existingChat.ID
-		log.Printf("[ChatService] Reopened closed chat with new encryption: chat_id=%d, user1_id=%d, user2_id=%d, algo=%s", chatID, req.User1ID, req.User2ID, req.Algorithm)
-	} else if existingChat != nil {
-		// Chat already exists and is active - cannot create or recreate with different parameters
-		log.Printf("[ChatService] Active chat already exists: chat_id=%d, user1_id=%d, user2_id=%d", existingChat.ID, req.User1ID, req.User2ID)
-		return &protocol.ChatResponse{
-			Success: false,
-			Error:   "active chat already exists with this user",
-		}, nil
-	} else {
-		// Create new chat
-		chatID, err = s.store.CreateChat(req.User1ID, req.User2ID, req.Algorithm, req.Mode, req.Padding)
+		storedP, storedG, err := tx.GetDHParameters(ctx, chatID)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		switch {
+		case stored != "" && historyRestored:
+			keyAgreement, pBytes, gBytes = stored, storedP, storedG
+		case stored != keyAgreement || !bytes.Equal(storedP, pBytes) || !bytes.Equal(storedG, gBytes):
+			group := dhGroupName(keyAgreement, pBytes, gBytes)
+			if err := tx.SaveKeyAgreement(ctx, chatID, keyAgreement, group, crypto.DefaultSessionKDF, pBytes, gBytes); err != nil {
+				return err
+			}
+			if stored != "" {
+				if err := tx.DeleteDHPublicKeys(ctx, chatID); err != nil {
+					return err
+				}
+			}
 		}
-		log.Printf("[ChatService] Created new chat: chat_id=%d, user1_id=%d, user2_id=%d", chatID, req.User1ID, req.User2ID)
-	}
 
-	// Save DH parameters (p, g) to database for both clients to use
-	// Only save if they don't already exist (in case we're reopening a closed chat)
-	p, _, _ := s.store.GetDHParameters(chatID)
-	if p == nil {
-		// Parameters don't exist yet, save them
-		if err := s.store.SaveDHParameters(chatID, pBytes, gBytes); err != nil {
-			return nil, err
+		// A reopened chat takes the key mode asked for now; going
+		// client-only drops any session key it had
+		if err := tx.SetKeyMode(ctx, chatID, keyMode); err != nil {
+			return err
 		}
-	}
 
-	// Copy users' public keys (if any) into dh_public_keys for this chat
-	// Only copy if they don't already exist for this chat
-	if user1.PublicKey != nil {
-		existing, _ := s.store.GetDHPublicKey(chatID, req.User1ID)
-		if existing == nil {
-			// Key doesn't exist, save it
-			if err := s.store.SaveDHPublicKey(chatID, req.User1ID, user1.PublicKey); err != nil {
-				return nil, err
-			}
+		// X25519 keys and keys in a chat's own DH group are made per chat
+		// and published through the DH exchange; the registration keys
+		// belong to the global DH parameters
+		if keyAgreement != crypto.KeyAgreementDH || !bytes.Equal(pBytes, globalP) {
+			return nil
 		}
-	}
-	if user2.PublicKey != nil {
-		existing, _ := s.store.GetDHPublicKey(chatID, req.User2ID)
-		if existing == nil {
-			// Key doesn't exist, save it
-			if err := s.store.SaveDHPublicKey(chatID, req.User2ID, user2.PublicKey); err != nil {
-				return nil, err
+
+		// Copy users' public keys (if any) into dh_public_keys for this chat
+		// Only copy if they don't already exist for this chat
+		for _, user := range []*storage.User{user1, user2} {
+			if user.PublicKey == nil {
+				continue
+			}
+			existing, err := tx.GetDHPublicKey(ctx, chatID, user.ID)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				// Key doesn't exist, save it
+				if err := tx.SaveDHPublicKey(ctx, chatID, user.ID, user.PublicKey); err != nil {
+					return err
+				}
 			}
 		}
+		return nil
+	})
+	if errors.Is(err, errActiveChatExists) {
+		return &protocol.ChatResponse{
+			Success: false,
+			Error:   err.Error(),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	// Broadcast chat creation event to both users
@@ -168,11 +273,12 @@ func (s *Service) CreateChat(ctx context.Context, req *protocol.ChatCreateReques
 		}
 		// Use snake_case map for JSON payload to match client expectations
 		data := map[string]interface{}{
-			"chat_id":   chatID,
-			"user1_id":  req.User1ID,
-			"user2_id":  req.User2ID,
-			"action":    "created",
-			"timestamp": time.Now().Unix(),
+			"chat_id":          chatID,
+			"user1_id":         req.User1ID,
+			"user2_id":         req.User2ID,
+			"action":           "created",
+			"history_restored": historyRestored,
+			"timestamp":        time.Now().Unix(),
 		}
 		// Send to user1
 		chatEvent.UserID = req.User1ID
@@ -184,20 +290,143 @@ func (s *Service) CreateChat(ctx context.Context, req *protocol.ChatCreateReques
 		s.broadcastHandler(chatEvent)
 	}
 
+	// Keys copied from registration, or dropped with changed parameters,
+	// move the key exchange on
+	if chat, err := s.store.GetChat(ctx, chatID); err != nil {
+		log.Printf("[ChatService] Failed to load chat %d: %v", chatID, err)
+	} else if chat != nil {
+		if _, err := s.updateDHState(ctx, chat); err != nil {
+			log.Printf("[ChatService] Failed to update the key exchange state of chat %d: %v", chatID, err)
+		}
+	}
+
 	return &protocol.ChatResponse{
-		Success:   true,
-		ChatID:    chatID,
-		User1ID:   req.User1ID,
-		User2ID:   req.User2ID,
-		Algorithm: req.Algorithm,
-		Mode:      req.Mode,
-		Padding:   req.Padding,
-		CreatedAt: time.Now().String(),
+		Success:         true,
+		ChatID:          chatID,
+		User1ID:         req.User1ID,
+		User2ID:         req.User2ID,
+		ChatType:        protocol.ChatTypeDirect,
+		Algorithm:       algorithm,
+		Mode:            mode,
+		Padding:         padding,
+		CreatedAt:       time.Now().String(),
+		KeyAgreement:    keyAgreement,
+		DHGroup:         dhGroupName(keyAgreement, pBytes, gBytes),
+		HistoryRestored: historyRestored,
+		KeyMode:         keyMode,
+	}, nil
+}
+
+// dhGroupName returns the name of the group of a DH chat's parameters, or ""
+// if they are not a named group
+func dhGroupName(keyAgreement string, p, g []byte) string {
+	if keyAgreement != crypto.KeyAgreementDH {
+		return ""
+	}
+	group, _ := crypto.IdentifyDHGroup(p, g)
+	return group.Name
+}
+
+// createSelfChat creates (or returns) the user's Saved Messages chat. It has no
+// contact requirement and no DH peer, but otherwise uses the same message
+// pipeline and encryption settings as a direct chat.
+func (s *Service) createSelfChat(ctx context.Context, req *protocol.ChatCreateRequest, keyMode string) (*protocol.ChatResponse, error) {
+	user, err := s.store.GetUserByID(ctx, req.User1ID)
+	if err != nil || user == nil {
+		return &protocol.ChatResponse{
+			Success: false,
+			Error:   "user not found",
+		}, nil
+	}
+
+	existingChat, err := s.store.GetChatByUsers(ctx, req.User1ID, req.User1ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var chatID int64
+	algorithm, mode, padding := req.Algorithm, req.Mode, req.Padding
+	historyRestored := false
+
+	if existingChat != nil && existingChat.Status == "closed" {
+		err := s.store.WithTx(ctx, func(tx *storage.DB) error {
+			if err := tx.ReopenChat(ctx, existingChat.ID, existingChat.Version); err != nil {
+				return err
+			}
+			if existingChat.HistoryRetained {
+				return nil
+			}
+			// ReopenChat took the chat to the next version
+			return tx.UpdateChatEncryption(ctx, existingChat.ID, existingChat.Version+1, req.Algorithm, req.Mode, req.Padding)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if existingChat.HistoryRetained {
+			algorithm, mode, padding = existingChat.Algorithm, existingChat.Mode, existingChat.Padding
+			historyRestored = true
+		}
+		chatID = existingChat.ID
+		log.Printf("[ChatService] Reopened saved messages chat: chat_id=%d, user_id=%d", chatID, req.User1ID)
+	} else if existingChat != nil {
+		// There is only ever one Saved Messages chat per user, so hand back the existing one
+		return &protocol.ChatResponse{
+			Success:   true,
+			ChatID:    existingChat.ID,
+			User1ID:   existingChat.User1ID,
+			User2ID:   existingChat.User2ID,
+			ChatType:  protocol.ChatTypeSelf,
+			Algorithm: existingChat.Algorithm,
+			Mode:      existingChat.Mode,
+			Padding:   existingChat.Padding,
+			CreatedAt: time.Unix(existingChat.CreatedAt, 0).String(),
+		}, nil
+	} else {
+		chatID, err = s.store.CreateChat(ctx, req.User1ID, req.User1ID, protocol.ChatTypeSelf, req.Algorithm, req.Mode, req.Padding)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[ChatService] Created saved messages chat: chat_id=%d, user_id=%d", chatID, req.User1ID)
+	}
+	if err := s.store.SetKeyMode(ctx, chatID, keyMode); err != nil {
+		return nil, err
+	}
+
+	if s.broadcastHandler != nil {
+		data := map[string]interface{}{
+			"chat_id":          chatID,
+			"user1_id":         req.User1ID,
+			"user2_id":         req.User1ID,
+			"chat_type":        protocol.ChatTypeSelf,
+			"action":           "created",
+			"history_restored": historyRestored,
+			"timestamp":        time.Now().Unix(),
+		}
+		s.broadcastHandler(&protocol.WebSocketEvent{
+			Type:      "chat_created",
+			UserID:    req.User1ID,
+			Timestamp: time.Now().Unix(),
+			Data:      data,
+		})
+	}
+
+	return &protocol.ChatResponse{
+		Success:         true,
+		ChatID:          chatID,
+		User1ID:         req.User1ID,
+		User2ID:         req.User1ID,
+		ChatType:        protocol.ChatTypeSelf,
+		Algorithm:       algorithm,
+		Mode:            mode,
+		Padding:         padding,
+		CreatedAt:       time.Now().String(),
+		HistoryRestored: historyRestored,
+		KeyMode:         keyMode,
 	}, nil
 }
 
 func (s *Service) GetUserChats(ctx context.Context, userID int64) (*protocol.GetUserChatsResponse, error) {
-	chats, err := s.store.ListUserChats(userID)
+	chats, err := s.store.ListUserChats(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -205,13 +434,16 @@ func (s *Service) GetUserChats(ctx context.Context, userID int64) (*protocol.Get
 	var protocolChats []*protocol.Chat
 	for _, chat := range chats {
 		protocolChats = append(protocolChats, &protocol.Chat{
-			ID:        chat.ID,
-			User1ID:   chat.User1ID,
-			User2ID:   chat.User2ID,
-			Algorithm: chat.Algorithm,
-			Mode:      chat.Mode,
-			Padding:   chat.Padding,
-			CreatedAt: chat.CreatedAt,
+			ID:             chat.ID,
+			User1ID:        chat.User1ID,
+			User2ID:        chat.User2ID,
+			ChatType:       chat.ChatType,
+			Algorithm:      chat.Algorithm,
+			Mode:           chat.Mode,
+			Padding:        chat.Padding,
+			CreatedAt:      chat.CreatedAt,
+			LastActivityAt: chat.LastActivityAt,
+			LastSeq:        chat.LastSeq,
 		})
 	}
 
@@ -220,31 +452,137 @@ func (s *Service) GetUserChats(ctx context.Context, userID int64) (*protocol.Get
 	}, nil
 }
 
+// GetChatDetails returns a single chat with per-participant activity information
+func (s *Service) GetChatDetails(ctx context.Context, chatID, userID int64) (*protocol.ChatDetails, error) {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return nil, err
+	}
+	chat := access.Chat
+
+	lastSeen, err := s.store.GetChatLastSeen(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	seenByUser := make(map[int64]int64, len(lastSeen))
+	for _, ls := range lastSeen {
+		seenByUser[ls.UserID] = ls.LastOpenedAt
+	}
+
+	participantIDs := []int64{chat.User1ID, chat.User2ID}
+	if chat.ChatType == protocol.ChatTypeSelf {
+		participantIDs = participantIDs[:1]
+	}
+
+	participants := make([]*protocol.ChatParticipant, 0, len(participantIDs))
+	for _, participantID := range participantIDs {
+		participant := &protocol.ChatParticipant{UserID: participantID}
+		if ts, ok := seenByUser[participantID]; ok {
+			participant.LastOpenedAt = &ts
+		}
+		participants = append(participants, participant)
+	}
+
+	var verification *protocol.ChatVerification
+	if chat.ChatType != protocol.ChatTypeSelf {
+		if verification, err = s.verification(ctx, chat); err != nil {
+			return nil, err
+		}
+	}
+
+	return &protocol.ChatDetails{
+		ID:           chat.ID,
+		User1ID:      chat.User1ID,
+		User2ID:      chat.User2ID,
+		ChatType:     chat.ChatType,
+		Algorithm:    chat.Algorithm,
+		Mode:         chat.Mode,
+		Padding:      chat.Padding,
+		Status:       chat.Status,
+		CreatedAt:    chat.CreatedAt,
+		ClosedAt:     chat.ClosedAt,
+		Participants: participants,
+		Retention: &protocol.RetentionPolicy{
+			Days:        chat.RetentionDays,
+			MaxMessages: chat.RetentionMaxMessages,
+		},
+		HistoryRetained: chat.HistoryRetained,
+		LastSeq:         chat.LastSeq,
+		Verification:    verification,
+	}, nil
+}
+
+// SetRetention updates the chat's message retention policy. Either participant
+// may change it; the new policy is broadcast to both and enforced by the next
+// retention purge.
+func (s *Service) SetRetention(ctx context.Context, chatID, userID int64, policy *protocol.RetentionPolicy) (*protocol.RetentionPolicy, error) {
+	if policy.Days < 0 || policy.Days > MaxRetentionDays ||
+		policy.MaxMessages < 0 || policy.MaxMessages > MaxRetentionMessages {
+		return nil, ErrInvalidRetention
+	}
+
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermManage)
+	if err != nil {
+		return nil, err
+	}
+	chat := access.Chat
+
+	if err := s.store.UpdateChatRetention(ctx, chatID, policy.Days, policy.MaxMessages); err != nil {
+		return nil, err
+	}
+	log.Printf("[ChatService] Chat %d retention set to days=%d, max_messages=%d (by user %d)", chatID, policy.Days, policy.MaxMessages, userID)
+
+	if s.broadcastHandler != nil {
+		data := map[string]interface{}{
+			"chat_id":      chatID,
+			"user_id":      userID,
+			"days":         policy.Days,
+			"max_messages": policy.MaxMessages,
+			"action":       "retention_updated",
+			"timestamp":    time.Now().Unix(),
+		}
+		targetUserIDs := []int64{chat.User1ID, chat.User2ID}
+		if chat.User1ID == chat.User2ID {
+			targetUserIDs = targetUserIDs[:1]
+		}
+		for _, targetUserID := range targetUserIDs {
+			evt := &protocol.WebSocketEvent{
+				Type:      "chat_retention_updated",
+				UserID:    targetUserID,
+				Timestamp: time.Now().Unix(),
+				Data:      data,
+			}
+			s.broadcastHandler(evt)
+		}
+	}
+
+	return policy, nil
+}
+
 func (s *Service) JoinChat(ctx context.Context, chatID, userID int64) (*protocol.ChatResponse, error) {
 	// Validate chat exists and user is participant
-	chat, err := s.store.GetChat(chatID)
+	access, err := s.access.ChatAccess(ctx, userID, chatID)
 	if err != nil {
 		return &protocol.ChatResponse{Success: false, Error: err.Error()}, nil
 	}
-	if chat == nil {
-		return &protocol.ChatResponse{Success: false, Error: "chat not found"}, nil
-	}
-	if chat.User1ID != userID && chat.User2ID != userID {
-		return &protocol.ChatResponse{Success: false, Error: "user not in chat"}, nil
+
+	// Remember when this participant last opened the chat
+	lastOpenedAt, err := s.store.TouchChatLastSeen(ctx, chatID, userID)
+	if err != nil {
+		log.Printf("[ChatService] Warning: failed to record last seen for chat %d, user %d: %v", chatID, userID, err)
+		lastOpenedAt = time.Now().Unix()
 	}
 
 	// Broadcast a chat_joined event to the other participant so their UI can update
-	if s.broadcastHandler != nil {
-		otherUserID := chat.User2ID
-		if chat.User1ID != userID {
-			otherUserID = chat.User1ID
-		}
+	if s.broadcastHandler != nil && access.OtherUserID != userID {
+		otherUserID := access.OtherUserID
 
 		data := map[string]interface{}{
-			"chat_id":   chatID,
-			"user_id":   userID,
-			"action":    "joined",
-			"timestamp": time.Now().Unix(),
+			"chat_id":        chatID,
+			"user_id":        userID,
+			"action":         "joined",
+			"last_opened_at": lastOpenedAt,
+			"timestamp":      time.Now().Unix(),
 		}
 
 		evt := &protocol.WebSocketEvent{
@@ -261,23 +599,14 @@ func (s *Service) JoinChat(ctx context.Context, chatID, userID int64) (*protocol
 
 func (s *Service) LeaveChat(ctx context.Context, chatID, userID int64) (*protocol.ChatResponse, error) {
 	// Validate chat exists and user is participant
-	chat, err := s.store.GetChat(chatID)
+	access, err := s.access.ChatAccess(ctx, userID, chatID)
 	if err != nil {
 		return &protocol.ChatResponse{Success: false, Error: err.Error()}, nil
 	}
-	if chat == nil {
-		return &protocol.ChatResponse{Success: false, Error: "chat not found"}, nil
-	}
-	if chat.User1ID != userID && chat.User2ID != userID {
-		return &protocol.ChatResponse{Success: false, Error: "user not in chat"}, nil
-	}
 
 	// Broadcast a chat_left event to the other participant
-	if s.broadcastHandler != nil {
-		otherUserID := chat.User2ID
-		if chat.User1ID != userID {
-			otherUserID = chat.User1ID
-		}
+	if s.broadcastHandler != nil && access.OtherUserID != userID {
+		otherUserID := access.OtherUserID
 
 		data := map[string]interface{}{
 			"chat_id":   chatID,
@@ -298,55 +627,109 @@ func (s *Service) LeaveChat(ctx context.Context, chatID, userID int64) (*protoco
 	return &protocol.ChatResponse{Success: true}, nil
 }
 
-func (s *Service) CloseChat(ctx context.Context, chatID, userID int64) (*protocol.ChatResponse, error) {
-	// Get the chat first
-	chat, err := s.store.GetChat(chatID)
+// CloseChat closes a chat. By default all of its messages are deleted; with
+// keepHistory ("soft close") they are kept but hidden until the chat is reopened.
+func (s *Service) CloseChat(ctx context.Context, chatID, userID int64, keepHistory bool) (*protocol.ChatResponse, error) {
+	// Verify user may manage the chat
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermManage)
 	if err != nil {
 		return &protocol.ChatResponse{
 			Success: false,
 			Error:   err.Error(),
 		}, nil
 	}
-	if chat == nil {
+
+	// Deleting the messages and closing the chat happen together, so a chat
+	// is never left closed with part of its history or open without it. The
+	// close only applies to the chat as checked above: if it was reopened or
+	// renegotiated meanwhile, the transaction fails with
+	// storage.ErrChatVersionConflict and the messages stay.
+	err = s.store.WithTx(ctx, func(tx *storage.DB) error {
+		if keepHistory {
+			log.Printf("[Chat] Soft closing chat %d, messages are kept", chatID)
+		} else {
+			// Delete all messages for this chat first
+			if err := tx.DeleteChatMessages(ctx, chatID); err != nil {
+				return err
+			}
+		}
+
+		// Update chat status to closed
+		return tx.CloseChat(ctx, chatID, access.Chat.Version, keepHistory)
+	})
+	if err != nil {
+		log.Printf("[Chat] Failed to close chat %d: %v", chatID, err)
 		return &protocol.ChatResponse{
 			Success: false,
-			Error:   "chat not found",
+			Error:   err.Error(),
 		}, nil
 	}
+	if !keepHistory {
+		log.Printf("[Chat] Deleted messages for chat %d", chatID)
+	}
+	// Chat closed event published via WebSocket broadcast
+
+	return &protocol.ChatResponse{Success: true}, nil
+}
 
-	// Verify user is a participant (user1 or user2)
-	if chat.User1ID != userID && chat.User2ID != userID {
+// DeleteChat permanently deletes a chat and all of its messages and key material.
+// Unlike CloseChat, the chat cannot be reopened afterwards, so the caller must
+// explicitly confirm the deletion.
+func (s *Service) DeleteChat(ctx context.Context, chatID, userID int64, confirmed bool) (*protocol.ChatResponse, error) {
+	// Verify user may manage the chat
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermManage)
+	if err != nil {
 		return &protocol.ChatResponse{
 			Success: false,
-			Error:   "user not in chat",
+			Error:   err.Error(),
 		}, nil
 	}
+	chat := access.Chat
 
-	// Delete all messages for this chat first
-	err = s.store.DeleteChatMessages(chatID)
-	if err != nil {
-		log.Printf("[Chat] Warning: failed to delete messages for chat %d: %v", chatID, err)
-		// Continue with closing even if message deletion fails
-	} else {
-		log.Printf("[Chat] Deleted messages for chat %d", chatID)
+	if !confirmed {
+		return &protocol.ChatResponse{
+			Success: false,
+			Error:   ErrDeleteNotConfirmed.Error(),
+		}, nil
 	}
 
-	// Update chat status to closed
-	err = s.store.CloseChat(chatID)
-	if err != nil {
+	if err := s.store.DeleteChat(ctx, chatID); err != nil {
 		return &protocol.ChatResponse{
 			Success: false,
 			Error:   err.Error(),
 		}, nil
 	}
-	// Chat closed event published via WebSocket broadcast
+	log.Printf("[ChatService] Deleted chat %d with all messages and key material (by user %d)", chatID, userID)
 
-	return &protocol.ChatResponse{Success: true}, nil
+	// Notify both participants so every open tab drops the chat
+	if s.broadcastHandler != nil {
+		data := map[string]interface{}{
+			"chat_id":   chatID,
+			"user_id":   userID,
+			"action":    "deleted",
+			"timestamp": time.Now().Unix(),
+		}
+		targetUserIDs := []int64{chat.User1ID, chat.User2ID}
+		if chat.User1ID == chat.User2ID {
+			targetUserIDs = targetUserIDs[:1]
+		}
+		for _, targetUserID := range targetUserIDs {
+			evt := &protocol.WebSocketEvent{
+				Type:      "chat_deleted",
+				UserID:    targetUserID,
+				Timestamp: time.Now().Unix(),
+				Data:      data,
+			}
+			s.broadcastHandler(evt)
+		}
+	}
+
+	return &protocol.ChatResponse{Success: true, ChatID: chatID}, nil
 }
 
 // GetGlobalDHParams returns global p and g; if not present, generates and saves them
 func (s *Service) GetGlobalDHParams(ctx context.Context) ([]byte, []byte, error) {
-	p, g, err := s.store.GetGlobalDHParameters()
+	p, g, err := s.store.GetGlobalDHParameters(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -354,105 +737,205 @@ func (s *Service) GetGlobalDHParams(ctx context.Context) ([]byte, []byte, error)
 		return p, g, nil
 	}
 
-	// Generate new global parameters
-	dh, err := crypto.NewDiffieHellman(2048)
-	if err != nil {
-		return nil, nil, err
-	}
+	// Make new global parameters from the configured group
+	dh := crypto.NewDiffieHellmanGroup(s.dhGroup)
 
-	if err := s.store.SaveGlobalDHParameters(dh.GetPrime(), dh.GetGenerator()); err != nil {
-		return nil, nil, err
-	}
+	// Another gateway may have saved its parameters in the meantime; use
+	// whichever set was stored first
+	return s.store.SaveGlobalDHParameters(ctx, dh.GetPrime(), dh.GetGenerator())
+}
 
-	return dh.GetPrime(), dh.GetGenerator(), nil
+// SetDHGroup sets the RFC 7919 group that global DH parameters are made from
+// and that Capabilities reports as the default
+func (s *Service) SetDHGroup(name string) error {
+	group, ok := crypto.LookupDHGroup(name)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidDHGroup, name)
+	}
+	s.dhGroup = group
+	return nil
 }
 
 // DH Key Exchange Methods
-// InitiateDHExchange returns p, g, and other user's public key (if available)
-func (s *Service) InitiateDHExchange(ctx context.Context, chatID, userID int64) (map[string]string, error) {
-	// Get chat to validate user is in it
-	chat, err := s.store.GetChat(chatID)
+// InitiateDHExchange returns the key agreement, p and g for DH chats, and
+// other user's public key (if available)
+func (s *Service) InitiateDHExchange(ctx context.Context, chatID, userID int64, sub protocol.DHSubmission) (map[string]string, error) {
+	// Validate user is in the chat
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
 	if err != nil {
 		return nil, err
 	}
-	if chat == nil {
-		return nil, ErrChatNotFound
+	if access.Chat.ChatType == protocol.ChatTypeSelf {
+		return nil, ErrNoKeyExchange
 	}
-	if chat.User1ID != userID && chat.User2ID != userID {
-		return nil, ErrUserNotInChat
+	if err := s.checkDHSubmission(ctx, chatID, userID, sub); err != nil {
+		return nil, err
 	}
 
-	// Get DH parameters (p and g) from database
-	p, g, err := s.store.GetDHParameters(chatID)
+	keyAgreement, err := s.store.GetKeyAgreement(ctx, chatID)
 	if err != nil {
 		return nil, err
 	}
-	if p == nil || g == nil {
+	if keyAgreement == "" {
 		return nil, errors.New("DH parameters not found for this chat")
 	}
 
-	// Get other user's public key if available
-	otherUserID := chat.User2ID
-	if chat.User1ID != userID {
-		otherUserID = chat.User1ID
-	}
-
-	otherUserPublicKey, err := s.store.GetDHPublicKey(chatID, otherUserID)
+	kdf, err := s.store.GetSessionKDF(ctx, chatID)
 	if err != nil {
 		return nil, err
 	}
 
 	result := map[string]string{
-		"p": hex.EncodeToString(p),
-		"g": hex.EncodeToString(g),
+		"key_agreement": keyAgreement,
+		"kdf":           kdf,
+	}
+
+	// Get DH parameters (p and g) from database; X25519 has none
+	if keyAgreement == crypto.KeyAgreementDH {
+		p, g, err := s.store.GetDHParameters(ctx, chatID)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil || g == nil {
+			return nil, errors.New("DH parameters not found for this chat")
+		}
+		result["p"] = hex.EncodeToString(p)
+		result["g"] = hex.EncodeToString(g)
+
+		// Chats from before the group was stored have it identified by p
+		name, err := s.store.GetDHGroup(ctx, chatID)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			name = dhGroupName(keyAgreement, p, g)
+		}
+		if group, ok := crypto.LookupDHGroup(name); ok {
+			result["dh_group"] = group.Name
+			result["dh_group_bits"] = strconv.Itoa(group.Bits)
+		}
+	}
+
+	// Get other user's public key if available
+	otherUserID := access.OtherUserID
+
+	otherUserPublicKey, err := s.store.GetSignedDHPublicKey(ctx, chatID, otherUserID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Include other user's public key if it's available
+	// Include other user's public key if it's available, with what the
+	// client needs to check it was not substituted: the epoch and signature
+	// it was published with and the other user's identity key
 	if otherUserPublicKey != nil {
-		result["other_user_public_key"] = hex.EncodeToString(otherUserPublicKey)
+		result["other_user_public_key"] = hex.EncodeToString(otherUserPublicKey.PublicKey)
+		result["other_user_epoch"] = strconv.FormatInt(otherUserPublicKey.Epoch, 10)
+		result["other_user_signature"] = hex.EncodeToString(otherUserPublicKey.Signature)
+	}
+	identityKey, err := s.store.GetIdentityKey(ctx, otherUserID)
+	if err != nil {
+		return nil, err
 	}
+	if identityKey != nil {
+		result["other_user_identity_key"] = hex.EncodeToString(identityKey)
+	}
+
+	status, err := s.updateDHState(ctx, access.Chat)
+	if err != nil {
+		return nil, err
+	}
+	result["dh_state"] = status.State
 
 	return result, nil
 }
 
-// StoreDHPublicKey stores a user's public key for DH exchange
-func (s *Service) StoreDHPublicKey(ctx context.Context, chatID, userID int64, publicKeyHex string) error {
-	// Validate chat exists and user is in it
-	chat, err := s.store.GetChat(chatID)
+// StoreDHPublicKey stores a user's public key for DH exchange. A user who
+// has published an identity key must sign the key with it (see
+// crypto.SignPublicKey) at an epoch above that of the key it replaces; keys
+// of users without one are stored unsigned. Either way the submission must be
+// fresh, and no older than the one that published the key it replaces.
+func (s *Service) StoreDHPublicKey(ctx context.Context, chatID, userID int64, publicKeyHex string, epoch int64, signatureHex string, sub protocol.DHSubmission) error {
+	// Validate chat exists and user may exchange keys in it
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermExchangeKeys)
 	if err != nil {
 		return err
 	}
-	if chat == nil {
-		return ErrChatNotFound
-	}
-	if chat.User1ID != userID && chat.User2ID != userID {
-		return ErrUserNotInChat
+	if err := s.checkDHSubmission(ctx, chatID, userID, sub); err != nil {
+		return err
 	}
 
-	// Decode public key
+	// Decode public key and check it fits the chat's key agreement
 	publicKeyBytes, err := hex.DecodeString(publicKeyHex)
 	if err != nil {
 		return err
 	}
+	keyAgreement, err := s.store.GetKeyAgreement(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if err := crypto.CheckPublicKey(keyAgreement, publicKeyBytes); err != nil {
+		return err
+	}
+	if keyAgreement == crypto.KeyAgreementDH {
+		p, _, err := s.store.GetDHParameters(ctx, chatID)
+		if err != nil {
+			return err
+		}
+		if err := crypto.CheckDHPublicKey(p, publicKeyBytes); err != nil {
+			return err
+		}
+	}
+
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil {
+		return fmt.Errorf("%w: %v", crypto.ErrInvalidSignature, err)
+	}
+	identityKey, err := s.identity.VerifyKeyExchange(ctx, userID, chatID, epoch, publicKeyBytes, signature)
+	if err != nil {
+		return err
+	}
+	key := &storage.DHPublicKey{PublicKey: publicKeyBytes, PublishedAt: sub.Timestamp}
+	if identityKey != nil {
+		key.Epoch, key.Signature = epoch, signature
+	}
 
-	// Store in database
-	if err := s.store.SaveDHPublicKey(chatID, userID, publicKeyBytes); err != nil {
+	// Store in database, unless a key with the same or a later epoch, or
+	// published by a later submission, is already there
+	err = s.store.WithTx(ctx, func(tx *storage.DB) error {
+		current, err := tx.GetSignedDHPublicKey(ctx, chatID, userID)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return tx.SaveSignedDHPublicKey(ctx, chatID, userID, key)
+		}
+		if identityKey != nil && epoch <= current.Epoch {
+			log.Printf("[ChatService] Refused a key for chat %d from user %d at epoch %d, not above the current epoch %d", chatID, userID, epoch, current.Epoch)
+			return ErrStaleKeyEpoch
+		}
+		if sub.Timestamp < current.PublishedAt {
+			log.Printf("[ChatService] Refused a key for chat %d from user %d submitted at %d, before the current key (%d)", chatID, userID, sub.Timestamp, current.PublishedAt)
+			return fmt.Errorf("%w: it predates the current key", ErrStaleDHSubmission)
+		}
+		return tx.SaveSignedDHPublicKey(ctx, chatID, userID, key)
+	})
+	if err != nil {
 		return err
 	}
 
 	// Broadcast public key received event to other user
 	if s.broadcastHandler != nil {
-		otherUserID := chat.User2ID
-		if chat.User1ID != userID {
-			otherUserID = chat.User1ID
-		}
+		otherUserID := access.OtherUserID
 
 		// Use snake_case map for payload
 		data := map[string]interface{}{
-			"chat_id":    chatID,
-			"user_id":    userID,
-			"public_key": publicKeyHex,
-			"timestamp":  time.Now().Unix(),
+			"chat_id":      chatID,
+			"user_id":      userID,
+			"public_key":   publicKeyHex,
+			"epoch":        key.Epoch,
+			"signature":    hex.EncodeToString(key.Signature),
+			"identity_key": hex.EncodeToString(identityKey),
+			"timestamp":    time.Now().Unix(),
 		}
 
 		event := &protocol.WebSocketEvent{
@@ -464,10 +947,16 @@ func (s *Service) StoreDHPublicKey(ctx context.Context, chatID, userID int64, pu
 		s.broadcastHandler(event)
 	}
 
+	// The key is stored either way; a failed update is caught up by the
+	// next one
+	if _, err := s.updateDHState(ctx, access.Chat); err != nil {
+		log.Printf("[ChatService] Failed to update the key exchange state of chat %d: %v", chatID, err)
+	}
+
 	return nil
 }
 
 // CompleteDHExchange just stores the public key (shared secret computed by client)
-func (s *Service) CompleteDHExchange(ctx context.Context, chatID, userID int64, clientPublicKeyHex string) error {
-	return s.StoreDHPublicKey(ctx, chatID, userID, clientPublicKeyHex)
+func (s *Service) CompleteDHExchange(ctx context.Context, chatID, userID int64, clientPublicKeyHex string, epoch int64, signatureHex string, sub protocol.DHSubmission) error {
+	return s.StoreDHPublicKey(ctx, chatID, userID, clientPublicKeyHex, epoch, signatureHex, sub)
 }
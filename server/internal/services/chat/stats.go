@@ -0,0 +1,114 @@
+package chat
+
+import (
+	"context"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/protocol"
+)
+
+// GetChatStats returns message statistics and the key exchange state of a chat
+func (s *Service) GetChatStats(ctx context.Context, chatID, userID int64) (*protocol.ChatStats, error) {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return nil, err
+	}
+	chat := access.Chat
+
+	participantIDs := []int64{chat.User1ID, chat.User2ID}
+	if chat.ChatType == protocol.ChatTypeSelf {
+		participantIDs = participantIDs[:1]
+	}
+
+	senderStats, err := s.store.GetChatMessageStats(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &protocol.ChatStats{
+		ChatID:       chatID,
+		Participants: make([]*protocol.ParticipantStats, 0, len(participantIDs)),
+	}
+	bySender := make(map[int64]*protocol.ParticipantStats, len(participantIDs))
+	for _, participantID := range participantIDs {
+		ps := &protocol.ParticipantStats{UserID: participantID}
+		bySender[participantID] = ps
+		stats.Participants = append(stats.Participants, ps)
+	}
+
+	for _, st := range senderStats {
+		stats.TotalMessages += st.MessageCount
+		stats.TotalCiphertextBytes += st.CiphertextBytes
+		if stats.FirstMessageAt == nil || st.FirstMessageAt < *stats.FirstMessageAt {
+			first := st.FirstMessageAt
+			stats.FirstMessageAt = &first
+		}
+		if stats.LastMessageAt == nil || st.LastMessageAt > *stats.LastMessageAt {
+			last := st.LastMessageAt
+			stats.LastMessageAt = &last
+		}
+		// Messages from users who are no longer participants still count towards the totals
+		if ps, ok := bySender[st.SenderID]; ok {
+			ps.MessageCount = st.MessageCount
+			ps.CiphertextBytes = st.CiphertextBytes
+		}
+	}
+
+	stats.KeyExchange, err = s.keyExchangeStatus(ctx, chatID, chat.ChatType, participantIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// keyExchangeStatus inspects the stored DH parameters, public keys, key mode and session key of a chat
+func (s *Service) keyExchangeStatus(ctx context.Context, chatID int64, chatType string, participantIDs []int64) (*protocol.KeyExchangeStatus, error) {
+	status := &protocol.KeyExchangeStatus{PublicKeyUserIDs: make([]int64, 0, len(participantIDs))}
+
+	if chatType == protocol.ChatTypeSelf {
+		status.State = protocol.KeyExchangeNotApplicable
+		return status, nil
+	}
+
+	keyAgreement, err := s.store.GetKeyAgreement(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	status.KeyAgreement = keyAgreement
+	status.HasDHParameters = keyAgreement != ""
+
+	for _, participantID := range participantIDs {
+		publicKey, err := s.store.GetDHPublicKey(ctx, chatID, participantID)
+		if err != nil {
+			return nil, err
+		}
+		if publicKey != nil {
+			status.PublicKeyUserIDs = append(status.PublicKeyUserIDs, participantID)
+		}
+	}
+
+	status.KeyMode, err = s.store.GetKeyMode(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	// Client-only chats have no session key to look for
+	if status.KeyMode != protocol.KeyModeClientOnly {
+		sessionKey, err := s.store.GetSessionKey(ctx, chatID)
+		if err != nil {
+			return nil, err
+		}
+		status.HasSessionKey = sessionKey != nil
+	}
+
+	switch {
+	case !status.HasDHParameters && len(status.PublicKeyUserIDs) == 0:
+		status.State = protocol.KeyExchangeNotStarted
+	case len(status.PublicKeyUserIDs) == len(participantIDs):
+		status.State = protocol.KeyExchangeComplete
+	default:
+		status.State = protocol.KeyExchangePending
+	}
+
+	return status, nil
+}
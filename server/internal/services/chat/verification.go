@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+var (
+	ErrKeyExchangeIncomplete = errors.New("both participants must publish a public key before verifying")
+	ErrSASMismatch           = errors.New("short authentication string does not match the current key exchange")
+)
+
+// sasTranscript hashes the current key exchange of a direct chat, or returns
+// nil if a participant has not published a public key yet
+func (s *Service) sasTranscript(ctx context.Context, chat *storage.Chat) ([]byte, error) {
+	parties := make([]crypto.SASParty, 0, 2)
+	for _, userID := range []int64{chat.User1ID, chat.User2ID} {
+		key, err := s.store.GetSignedDHPublicKey(ctx, chat.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, nil
+		}
+		parties = append(parties, crypto.SASParty{UserID: userID, Epoch: key.Epoch, PublicKey: key.PublicKey})
+	}
+	return crypto.SASTranscript(chat.ID, parties[0], parties[1]), nil
+}
+
+// verification returns the SAS verification status of a direct chat. Only
+// confirmations of the current key exchange count.
+func (s *Service) verification(ctx context.Context, chat *storage.Chat) (*protocol.ChatVerification, error) {
+	transcript, err := s.sasTranscript(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+	confirmations, err := s.store.GetSASConfirmations(ctx, chat.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &protocol.ChatVerification{ChatID: chat.ID, ConfirmedBy: []int64{}}
+	if transcript != nil {
+		for _, c := range confirmations {
+			if bytes.Equal(c.Transcript, transcript) {
+				v.ConfirmedBy = append(v.ConfirmedBy, c.UserID)
+			}
+		}
+	}
+	v.Verified = len(v.ConfirmedBy) == 2
+	return v, nil
+}
+
+// GetVerification returns the SAS verification status of a chat
+func (s *Service) GetVerification(ctx context.Context, chatID, userID int64) (*protocol.ChatVerification, error) {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return nil, err
+	}
+	if access.Chat.ChatType == protocol.ChatTypeSelf {
+		return nil, ErrNoKeyExchange
+	}
+	return s.verification(ctx, access.Chat)
+}
+
+// ConfirmSAS records that the user compared the short code of the chat's key
+// exchange with the other participant and it matched. code is the code the
+// user's client showed; it must be the one of the keys the server holds, so
+// a confirmation never outlives a key published in the meantime.
+func (s *Service) ConfirmSAS(ctx context.Context, chatID, userID int64, code string) (*protocol.ChatVerification, error) {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermExchangeKeys)
+	if err != nil {
+		return nil, err
+	}
+	if access.Chat.ChatType == protocol.ChatTypeSelf {
+		return nil, ErrNoKeyExchange
+	}
+
+	transcript, err := s.sasTranscript(ctx, access.Chat)
+	if err != nil {
+		return nil, err
+	}
+	if transcript == nil {
+		return nil, ErrKeyExchangeIncomplete
+	}
+	expected, err := crypto.ShortAuthString(transcript)
+	if err != nil {
+		return nil, err
+	}
+	if code != expected {
+		return nil, ErrSASMismatch
+	}
+	if err := s.store.SaveSASConfirmation(ctx, chatID, userID, transcript); err != nil {
+		return nil, err
+	}
+
+	v, err := s.verification(ctx, access.Chat)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[ChatService] User %d confirmed the SAS of chat %d (verified: %v)", userID, chatID, v.Verified)
+	s.broadcastVerification(access.Chat, userID, v, false)
+	return v, nil
+}
+
+// RejectSAS records that the short codes differed: both confirmations are
+// dropped and the other participant is warned
+func (s *Service) RejectSAS(ctx context.Context, chatID, userID int64) (*protocol.ChatVerification, error) {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermExchangeKeys)
+	if err != nil {
+		return nil, err
+	}
+	if access.Chat.ChatType == protocol.ChatTypeSelf {
+		return nil, ErrNoKeyExchange
+	}
+
+	if _, err := s.store.DeleteSASConfirmations(ctx, chatID); err != nil {
+		return nil, err
+	}
+	log.Printf("[ChatService] User %d reported a SAS mismatch in chat %d", userID, chatID)
+
+	v := &protocol.ChatVerification{ChatID: chatID, ConfirmedBy: []int64{}}
+	s.broadcastVerification(access.Chat, userID, v, true)
+	return v, nil
+}
+
+// broadcastVerification sends the verification status of a chat to both
+// participants after userID confirmed or rejected its code
+func (s *Service) broadcastVerification(chat *storage.Chat, userID int64, v *protocol.ChatVerification, mismatch bool) {
+	if s.broadcastHandler == nil {
+		return
+	}
+	for _, participantID := range []int64{chat.User1ID, chat.User2ID} {
+		s.broadcastHandler(&protocol.WebSocketEvent{
+			Type:      "chat_verification",
+			UserID:    participantID,
+			Timestamp: time.Now().Unix(),
+			Data: map[string]interface{}{
+				"chat_id":      chat.ID,
+				"user_id":      userID,
+				"verified":     v.Verified,
+				"confirmed_by": v.ConfirmedBy,
+				"mismatch":     mismatch,
+			},
+		})
+	}
+}
@@ -1,11 +1,16 @@
 package contact
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
+	"MinMsgr/server/internal/identity"
+	"MinMsgr/server/internal/pkg/crypto"
 	"MinMsgr/server/internal/protocol"
 	"MinMsgr/server/internal/storage"
 )
@@ -18,12 +23,14 @@ var (
 
 type Service struct {
 	store            *storage.DB
+	identity         *identity.Verifier
 	broadcastHandler func(event interface{})
 }
 
 func NewService(store *storage.DB) *Service {
 	return &Service{
-		store: store,
+		store:    store,
+		identity: identity.New(store),
 	}
 }
 
@@ -43,7 +50,7 @@ func (s *Service) ProcessContactRequest(ctx context.Context, req *protocol.Conta
 	switch req.Action {
 	case "add":
 		// Check if contact already exists
-		contact, err := s.store.GetContact(req.UserID, req.ContactID)
+		contact, err := s.store.GetContact(ctx, req.UserID, req.ContactID)
 		if err != nil {
 			return &protocol.ContactResponse{
 				Success: false,
@@ -59,7 +66,7 @@ func (s *Service) ProcessContactRequest(ctx context.Context, req *protocol.Conta
 		// Add pending contact relationship
 		// Note: AddContact normalizes IDs (smaller user_id → user1_id, larger → user2_id)
 		// Store the ORIGINAL requester (req.UserID) as the initiator
-		_, err = s.store.AddContact(req.UserID, req.ContactID, "pending")
+		_, err = s.store.AddContact(ctx, req.UserID, req.ContactID, "pending")
 		if err != nil {
 			return &protocol.ContactResponse{
 				Success: false,
@@ -71,7 +78,7 @@ func (s *Service) ProcessContactRequest(ctx context.Context, req *protocol.Conta
 
 	case "accept":
 		// Get existing contact and update status
-		contact, err := s.store.GetContact(req.UserID, req.ContactID)
+		contact, err := s.store.GetContact(ctx, req.UserID, req.ContactID)
 		if err != nil {
 			return &protocol.ContactResponse{
 				Success: false,
@@ -98,7 +105,7 @@ func (s *Service) ProcessContactRequest(ctx context.Context, req *protocol.Conta
 				Error:   "You can only accept contact requests sent to you",
 			}, nil
 		}
-		err = s.store.UpdateContactStatus(contact.ID, "accepted")
+		err = s.store.UpdateContactStatus(ctx, contact.ID, "accepted")
 		if err != nil {
 			return &protocol.ContactResponse{
 				Success: false,
@@ -109,7 +116,7 @@ func (s *Service) ProcessContactRequest(ctx context.Context, req *protocol.Conta
 
 	case "reject", "remove":
 		// Get and delete the contact relationship
-		contact, err := s.store.GetContact(req.UserID, req.ContactID)
+		contact, err := s.store.GetContact(ctx, req.UserID, req.ContactID)
 		if err != nil {
 			return &protocol.ContactResponse{
 				Success: false,
@@ -136,7 +143,7 @@ func (s *Service) ProcessContactRequest(ctx context.Context, req *protocol.Conta
 				Error:   "You can only reject contact requests sent to you",
 			}, nil
 		}
-		err = s.store.DeleteContact(contact.ID)
+		err = s.store.DeleteContact(ctx, contact.ID)
 		if err != nil {
 			return &protocol.ContactResponse{
 				Success: false,
@@ -172,7 +179,7 @@ func (s *Service) ProcessContactRequest(ctx context.Context, req *protocol.Conta
 		}
 
 		// Get username of the user initiating the action
-		user, err := s.store.GetUserByID(req.UserID)
+		user, err := s.store.GetUserByID(ctx, req.UserID)
 		if err != nil {
 			log.Printf("Failed to get user info: %v", err)
 		}
@@ -204,7 +211,7 @@ func (s *Service) ProcessContactRequest(ctx context.Context, req *protocol.Conta
 
 func (s *Service) GetContacts(ctx context.Context, userID int64) ([]*storage.Contact, error) {
 	// Get accepted contacts
-	return s.store.ListUserContacts(userID, "accepted")
+	return s.store.ListUserContacts(ctx, userID, "accepted")
 }
 
 // GetPendingRequests returns all pending contact requests for a user
@@ -212,5 +219,68 @@ func (s *Service) GetContacts(ctx context.Context, userID int64) ([]*storage.Con
 // requests from the sender. Return all pending records and let the
 // client compute direction using the `requester_id` field.
 func (s *Service) GetPendingRequests(ctx context.Context, userID int64) ([]*storage.Contact, error) {
-	return s.store.ListUserContacts(userID, "pending")
+	return s.store.ListUserContacts(ctx, userID, "pending")
+}
+
+// GetVerification tells whether the user verified the current identity key
+// of an accepted contact
+func (s *Service) GetVerification(ctx context.Context, userID, contactID int64) (*protocol.ContactVerification, error) {
+	if err := s.requireAccepted(ctx, userID, contactID); err != nil {
+		return nil, err
+	}
+	return s.verification(ctx, userID, contactID)
+}
+
+// VerifyContact records that the user checked the identity key of an
+// accepted contact, e.g. by comparing the safety number of their chat.
+// signatureHex is the user's signature over the contact's current identity
+// key (see crypto.SignContact), so a verification cannot be recorded for a
+// key the user's client has not seen.
+func (s *Service) VerifyContact(ctx context.Context, userID, contactID int64, signatureHex string) (*protocol.ContactVerification, error) {
+	if err := s.requireAccepted(ctx, userID, contactID); err != nil {
+		return nil, err
+	}
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", crypto.ErrInvalidSignature, err)
+	}
+	contactKey, err := s.identity.VerifyContact(ctx, userID, contactID, signature)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.SaveContactVerification(ctx, userID, contactID, contactKey, signature); err != nil {
+		return nil, err
+	}
+	log.Printf("[Contact] User %d verified the identity key of user %d", userID, contactID)
+	return s.verification(ctx, userID, contactID)
+}
+
+// requireAccepted fails unless the two users are accepted contacts
+func (s *Service) requireAccepted(ctx context.Context, userID, contactID int64) error {
+	contact, err := s.store.GetContact(ctx, userID, contactID)
+	if err != nil {
+		return err
+	}
+	if contact == nil || contact.Status != "accepted" {
+		return ErrContactNotFound
+	}
+	return nil
+}
+
+// verification compares the key the user verified with the contact's
+// current identity key
+func (s *Service) verification(ctx context.Context, userID, contactID int64) (*protocol.ContactVerification, error) {
+	current, err := s.identity.IdentityKey(ctx, contactID)
+	if err != nil {
+		return nil, err
+	}
+	v := &protocol.ContactVerification{UserID: userID, ContactID: contactID, IdentityKey: hex.EncodeToString(current)}
+	stored, err := s.store.GetContactVerification(ctx, userID, contactID)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil && current != nil && bytes.Equal(stored.IdentityKey, current) {
+		v.Verified, v.VerifiedAt = true, stored.VerifiedAt
+	}
+	return v, nil
 }
@@ -0,0 +1,83 @@
+package device
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/protocol"
+)
+
+// Key backups let a user move to a new device without the old one: the
+// client seals its key bundle under a backup passphrase, separate from the
+// login password, and uploads it. Knowing the password is enough to log in
+// and download the backup but not to open it.
+
+var (
+	ErrInvalidKeyBackup  = errors.New("invalid key backup")
+	ErrKeyBackupNotFound = errors.New("no key backup")
+)
+
+// MaxKeyBackupSize bounds a sealed key bundle: the account, identity and
+// device keys plus a key pair per chat
+const MaxKeyBackupSize = 256 * 1024
+
+// SaveKeyBackup stores the sealed key bundle of userID, replacing any earlier
+// one. The backup must be sealed with Argon2id at no less than the default
+// cost, which the server can check from its header.
+func (s *Service) SaveKeyBackup(ctx context.Context, userID int64, backupHex string) (*protocol.KeyBackup, error) {
+	backup, err := hex.DecodeString(backupHex)
+	if err != nil || len(backup) == 0 {
+		return nil, fmt.Errorf("%w: backup must be non-empty hex", ErrInvalidKeyBackup)
+	}
+	if len(backup) > MaxKeyBackupSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalidKeyBackup, MaxKeyBackupSize)
+	}
+	params, err := crypto.KeyBackupParams(backup)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyBackup, err)
+	}
+
+	if err := s.store.SaveKeyBackup(ctx, userID, backup, params.Encode()); err != nil {
+		return nil, err
+	}
+	saved, err := s.store.GetKeyBackup(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[DeviceService] User %d uploaded a key backup of %d bytes", userID, len(backup))
+	return &protocol.KeyBackup{KDF: saved.KDF, CreatedAt: saved.CreatedAt, UpdatedAt: saved.UpdatedAt}, nil
+}
+
+// GetKeyBackup returns the sealed key bundle of userID
+func (s *Service) GetKeyBackup(ctx context.Context, userID int64) (*protocol.KeyBackup, error) {
+	b, err := s.store.GetKeyBackup(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, ErrKeyBackupNotFound
+	}
+	return &protocol.KeyBackup{
+		Backup:    hex.EncodeToString(b.Backup),
+		KDF:       b.KDF,
+		CreatedAt: b.CreatedAt,
+		UpdatedAt: b.UpdatedAt,
+	}, nil
+}
+
+// DeleteKeyBackup removes the key backup of userID
+func (s *Service) DeleteKeyBackup(ctx context.Context, userID int64) error {
+	deleted, err := s.store.DeleteKeyBackup(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrKeyBackupNotFound
+	}
+	log.Printf("[DeviceService] User %d deleted their key backup", userID)
+	return nil
+}
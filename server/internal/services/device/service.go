@@ -0,0 +1,297 @@
+// Package device keeps the registry of the devices each user logs in from
+// and relays chat key material between them. Every device has its own X25519
+// key pair; a device that holds the keys of a chat seals them to the other
+// devices of the chat's participants as key shares (see crypto.SealKeyShare),
+// so a second device does not need to repeat the key exchange. It also keeps
+// each user's key backup, sealed under a passphrase of their own.
+package device
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/pkg/crypto"
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+var (
+	ErrDeviceNotFound     = errors.New("device not found")
+	ErrInvalidDevice      = errors.New("invalid device")
+	ErrTooManyDevices     = errors.New("too many devices")
+	ErrInvalidKeyShare    = errors.New("invalid key share")
+	ErrRecipientNotInChat = errors.New("recipient device does not belong to a participant of the chat")
+)
+
+// Registry limits
+const (
+	MaxDevices       = 10
+	MaxDeviceNameLen = 64
+	// MaxKeyShareSize bounds a sealed share: an ephemeral key plus a few
+	// wrapped keys
+	MaxKeyShareSize = 1024
+)
+
+// Service manages devices and their key shares
+type Service struct {
+	store            *storage.DB
+	access           *authz.Checker
+	broadcastHandler func(event interface{})
+}
+
+func NewService(store *storage.DB) *Service {
+	return &Service{
+		store:  store,
+		access: authz.New(store),
+	}
+}
+
+// SetBroadcastHandler sets the callback for broadcasting events
+func (s *Service) SetBroadcastHandler(handler func(event interface{})) {
+	s.broadcastHandler = handler
+}
+
+// Register adds a device of userID with its X25519 public key and, optionally,
+// its private key wrapped under the user's password so that the device can
+// restore it after logging in again
+func (s *Service) Register(ctx context.Context, userID int64, name, publicKeyHex, encryptedPrivateKeyHex string) (*protocol.Device, error) {
+	name = strings.TrimSpace(name)
+	if len(name) > MaxDeviceNameLen {
+		return nil, fmt.Errorf("%w: name longer than %d bytes", ErrInvalidDevice, MaxDeviceNameLen)
+	}
+	publicKey, err := hex.DecodeString(publicKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", crypto.ErrInvalidPublicKey, err)
+	}
+	if err := crypto.CheckPublicKey(crypto.KeyAgreementX25519, publicKey); err != nil {
+		return nil, err
+	}
+	var encryptedPrivateKey []byte
+	if encryptedPrivateKeyHex != "" {
+		if encryptedPrivateKey, err = hex.DecodeString(encryptedPrivateKeyHex); err != nil {
+			return nil, fmt.Errorf("%w: invalid encrypted private key: %v", ErrInvalidDevice, err)
+		}
+	}
+
+	devices, err := s.store.ListDevices(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) >= MaxDevices {
+		return nil, fmt.Errorf("%w: at most %d active devices", ErrTooManyDevices, MaxDevices)
+	}
+
+	id, err := s.store.CreateDevice(ctx, userID, name, publicKey, encryptedPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.store.GetDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[DeviceService] User %d registered device %d", userID, id)
+	s.broadcastDevices(userID, "device_added", deviceInfo(d))
+	return deviceInfo(d), nil
+}
+
+// ListDevices returns the active devices of a user. Participants of a chat
+// list each other's devices to seal key shares to them.
+func (s *Service) ListDevices(ctx context.Context, userID int64) ([]*protocol.Device, error) {
+	devices, err := s.store.ListDevices(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*protocol.Device, 0, len(devices))
+	for _, d := range devices {
+		out = append(out, deviceInfo(d))
+	}
+	return out, nil
+}
+
+// PrivateKey returns the wrapped private key of an active device of userID,
+// or "" if the device did not store one, and records that it was used
+func (s *Service) PrivateKey(ctx context.Context, userID, deviceID int64) (string, error) {
+	d, err := s.ownDevice(ctx, userID, deviceID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.store.TouchDevice(ctx, deviceID); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(d.EncryptedPrivateKey), nil
+}
+
+// Revoke revokes a device of userID. Its key shares are dropped; the user's
+// other devices and their peers stop sealing shares to it.
+func (s *Service) Revoke(ctx context.Context, userID, deviceID int64) error {
+	revoked, err := s.store.RevokeDevice(ctx, userID, deviceID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrDeviceNotFound
+	}
+	log.Printf("[DeviceService] User %d revoked device %d", userID, deviceID)
+	s.broadcastDevices(userID, "device_revoked", map[string]interface{}{
+		"user_id":   userID,
+		"device_id": deviceID,
+	})
+	return nil
+}
+
+// ShareChatKeys stores key shares of a chat that senderDeviceID, a device of
+// userID, sealed to other devices. A user may always share with their own
+// devices; sharing with the other participant's devices takes the right to
+// exchange keys in the chat.
+func (s *Service) ShareChatKeys(ctx context.Context, userID, chatID, senderDeviceID int64, shares []protocol.KeyShare) error {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return err
+	}
+	if _, err := s.ownDevice(ctx, userID, senderDeviceID); err != nil {
+		return err
+	}
+	if len(shares) == 0 || len(shares) > 2*MaxDevices {
+		return fmt.Errorf("%w: between 1 and %d shares per request", ErrInvalidKeyShare, 2*MaxDevices)
+	}
+
+	type sealed struct {
+		recipient *storage.Device
+		share     []byte
+	}
+	checked := make([]sealed, 0, len(shares))
+	for _, ks := range shares {
+		share, err := hex.DecodeString(ks.Share)
+		if err != nil || len(share) == 0 || len(share) > MaxKeyShareSize {
+			return fmt.Errorf("%w: share for device %d", ErrInvalidKeyShare, ks.RecipientDeviceID)
+		}
+		recipient, err := s.store.GetDevice(ctx, ks.RecipientDeviceID)
+		if err != nil {
+			return err
+		}
+		if recipient == nil || recipient.RevokedAt != 0 || recipient.ID == senderDeviceID {
+			return fmt.Errorf("%w: %d", ErrDeviceNotFound, ks.RecipientDeviceID)
+		}
+		switch recipient.UserID {
+		case userID:
+		case access.OtherUserID:
+			if !access.Can(authz.PermExchangeKeys) {
+				return authz.ErrForbidden
+			}
+		default:
+			return ErrRecipientNotInChat
+		}
+		checked = append(checked, sealed{recipient, share})
+	}
+
+	for _, c := range checked {
+		if err := s.store.SaveKeyShare(ctx, chatID, senderDeviceID, c.recipient.ID, c.share); err != nil {
+			return err
+		}
+		if s.broadcastHandler != nil {
+			s.broadcastHandler(&protocol.WebSocketEvent{
+				Type:      "key_share",
+				UserID:    c.recipient.UserID,
+				Timestamp: time.Now().Unix(),
+				Data: map[string]interface{}{
+					"chat_id":             chatID,
+					"sender_device_id":    senderDeviceID,
+					"recipient_device_id": c.recipient.ID,
+				},
+			})
+		}
+	}
+	log.Printf("[DeviceService] Device %d shared the keys of chat %d with %d devices", senderDeviceID, chatID, len(checked))
+	return nil
+}
+
+// GetKeyShare returns the latest key share of a chat sealed to deviceID, a
+// device of userID, or nil if there is none
+func (s *Service) GetKeyShare(ctx context.Context, userID, chatID, deviceID int64) (*protocol.KeyShare, error) {
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermRead); err != nil {
+		return nil, err
+	}
+	if _, err := s.ownDevice(ctx, userID, deviceID); err != nil {
+		return nil, err
+	}
+	ks, err := s.store.GetKeyShare(ctx, chatID, deviceID)
+	if err != nil || ks == nil {
+		return nil, err
+	}
+	return &protocol.KeyShare{
+		ChatID:            ks.ChatID,
+		SenderDeviceID:    ks.SenderDeviceID,
+		RecipientDeviceID: ks.RecipientDeviceID,
+		Share:             hex.EncodeToString(ks.Share),
+		CreatedAt:         ks.CreatedAt,
+	}, nil
+}
+
+// ownDevice loads an active device of userID
+func (s *Service) ownDevice(ctx context.Context, userID, deviceID int64) (*storage.Device, error) {
+	d, err := s.store.GetDevice(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil || d.UserID != userID || d.RevokedAt != 0 {
+		return nil, ErrDeviceNotFound
+	}
+	return d, nil
+}
+
+// broadcastDevices tells the user's devices, and the peers of their chats
+// who seal key shares to them, that the device list changed
+func (s *Service) broadcastDevices(userID int64, eventType string, data interface{}) {
+	if s.broadcastHandler == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	targets := []int64{userID}
+	if chats, err := s.store.ListUserChats(ctx, userID); err != nil {
+		log.Printf("[DeviceService] Failed to list the chats of user %d: %v", userID, err)
+	} else {
+		for _, chat := range chats {
+			if chat.ChatType == protocol.ChatTypeSelf {
+				continue
+			}
+			other := chat.User1ID
+			if other == userID {
+				other = chat.User2ID
+			}
+			targets = append(targets, other)
+		}
+	}
+
+	seen := make(map[int64]bool)
+	for _, target := range targets {
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+		s.broadcastHandler(&protocol.WebSocketEvent{
+			Type:      eventType,
+			UserID:    target,
+			Timestamp: time.Now().Unix(),
+			Data:      data,
+		})
+	}
+}
+
+func deviceInfo(d *storage.Device) *protocol.Device {
+	return &protocol.Device{
+		ID:         d.ID,
+		UserID:     d.UserID,
+		Name:       d.Name,
+		PublicKey:  hex.EncodeToString(d.PublicKey),
+		CreatedAt:  d.CreatedAt,
+		LastSeenAt: d.LastSeenAt,
+	}
+}
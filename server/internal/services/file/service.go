@@ -0,0 +1,501 @@
+package file
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/pkg/blobstore"
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+var (
+	ErrUploadNotFound     = errors.New("upload not found")
+	ErrFileNotFound       = errors.New("file not found")
+	ErrInvalidUpload      = errors.New("invalid upload")
+	ErrFileTooLarge       = errors.New("file exceeds the maximum allowed size")
+	ErrChunkTooLarge      = errors.New("chunk exceeds the maximum allowed size")
+	ErrMimeTypeNotAllowed = errors.New("file type is not allowed")
+	ErrOffsetMismatch     = errors.New("chunk offset does not match the received size")
+	ErrUploadIncomplete   = errors.New("upload is not complete")
+	ErrInvalidSignature   = errors.New("invalid or expired download signature")
+	ErrInvalidRange       = errors.New("requested range not satisfiable")
+	ErrInvalidThumbnail   = errors.New("invalid thumbnail")
+	ErrThumbnailTooLarge  = errors.New("thumbnail exceeds the maximum allowed size")
+)
+
+// Thumbnail limits; thumbnails are stored in the database, so they must stay small
+const (
+	MaxThumbnailSize      = 32 * 1024
+	MaxThumbnailDimension = 1024
+)
+
+// Limits restricts what clients may upload
+type Limits struct {
+	MaxFileSize  int64
+	MaxChunkSize int64
+	// AllowedMimeTypes lists accepted MIME types; entries ending in "/" match a
+	// whole family (e.g. "image/"). An empty list allows everything.
+	AllowedMimeTypes []string
+}
+
+// Service manages chunked uploads and the files stored in the blob store
+type Service struct {
+	store  *storage.DB
+	blobs  blobstore.Store
+	access *authz.Checker
+	limits Limits
+	// Signed download URLs
+	urlKey []byte
+	urlTTL time.Duration
+}
+
+func NewService(store *storage.DB, blobs blobstore.Store, limits Limits) *Service {
+	return &Service{
+		store:  store,
+		blobs:  blobs,
+		access: authz.New(store),
+		limits: limits,
+	}
+}
+
+// SetURLSigning configures the key and lifetime of signed download URLs
+func (s *Service) SetURLSigning(key []byte, ttl time.Duration) {
+	s.urlKey = key
+	s.urlTTL = ttl
+}
+
+// CreateUpload starts a resumable upload of a file that will be attached to a message in chatID
+func (s *Service) CreateUpload(ctx context.Context, userID, chatID int64, fileName, mimeType string, size int64) (*protocol.UploadStatus, error) {
+	fileName = path.Base(strings.ReplaceAll(fileName, "\\", "/"))
+	if fileName == "" || fileName == "." || fileName == "/" || len(fileName) > 255 || mimeType == "" || len(mimeType) > 100 || size <= 0 {
+		return nil, ErrInvalidUpload
+	}
+	if size > s.limits.MaxFileSize {
+		return nil, ErrFileTooLarge
+	}
+	if !s.mimeTypeAllowed(mimeType) {
+		return nil, ErrMimeTypeNotAllowed
+	}
+
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermWrite); err != nil {
+		return nil, err
+	}
+
+	uploadID, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &storage.UploadSession{
+		ID:        uploadID,
+		UserID:    userID,
+		ChatID:    chatID,
+		FileName:  fileName,
+		MimeType:  mimeType,
+		TotalSize: size,
+	}
+	if err := s.store.CreateUploadSession(ctx, session); err != nil {
+		return nil, err
+	}
+	log.Printf("[FileService] User %d started upload %s: chat_id=%d, size=%d, mime=%s", userID, uploadID, chatID, size, mimeType)
+
+	return s.toUploadStatus(session), nil
+}
+
+// GetUpload returns the progress of an upload so clients can resume it
+func (s *Service) GetUpload(ctx context.Context, userID int64, uploadID string) (*protocol.UploadStatus, error) {
+	session, err := s.ownedSession(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return s.toUploadStatus(session), nil
+}
+
+// UploadChunk stores the next chunk of an upload. offset must equal the number
+// of bytes received so far; after an interrupted transfer the client asks for
+// the upload status and resumes from ReceivedSize.
+func (s *Service) UploadChunk(ctx context.Context, userID int64, uploadID string, offset int64, r io.Reader, size int64) (*protocol.UploadStatus, error) {
+	session, err := s.ownedSession(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, ErrInvalidUpload
+	}
+	if size > s.limits.MaxChunkSize {
+		return nil, ErrChunkTooLarge
+	}
+	if offset != session.ReceivedSize {
+		return nil, ErrOffsetMismatch
+	}
+	if offset+size > session.TotalSize {
+		return nil, ErrFileTooLarge
+	}
+
+	if err := s.blobs.Put(ctx, chunkKey(uploadID, session.ChunkCount), r, size); err != nil {
+		return nil, err
+	}
+
+	ok, err := s.store.AdvanceUploadSession(ctx, uploadID, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// Another request stored this chunk first
+		return nil, ErrOffsetMismatch
+	}
+
+	session.ReceivedSize += size
+	session.ChunkCount++
+	return s.toUploadStatus(session), nil
+}
+
+// CompleteUpload assembles the received chunks into a single blob and returns
+// the new file, which can then be attached to a message
+func (s *Service) CompleteUpload(ctx context.Context, userID int64, uploadID string) (*protocol.FileInfo, error) {
+	session, err := s.ownedSession(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if session.ReceivedSize != session.TotalSize {
+		return nil, ErrUploadIncomplete
+	}
+
+	chunkKeys := make([]string, session.ChunkCount)
+	for i := range chunkKeys {
+		chunkKeys[i] = chunkKey(uploadID, i)
+	}
+
+	file := &storage.File{
+		OwnerID:  session.UserID,
+		ChatID:   session.ChatID,
+		BlobKey:  "files/" + uploadID,
+		FileName: session.FileName,
+		MimeType: session.MimeType,
+		Size:     session.TotalSize,
+	}
+
+	reader := &chunkReader{ctx: ctx, blobs: s.blobs, keys: chunkKeys}
+	err = s.blobs.Put(ctx, file.BlobKey, reader, file.Size)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.CompleteUpload(ctx, uploadID, file); err != nil {
+		return nil, err
+	}
+	log.Printf("[FileService] Upload %s complete: file_id=%d, size=%d", uploadID, file.ID, file.Size)
+
+	// The chunks are no longer needed; leave any that fail to delete to the janitor
+	for _, key := range chunkKeys {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("[FileService] Failed to delete chunk %s, queueing: %v", key, err)
+			s.store.QueueBlobDeletions(ctx, []string{key})
+		}
+	}
+
+	return toFileInfo(file), nil
+}
+
+// SetThumbnail stores a client-encrypted preview image for a file. Only the
+// uploader may set it, typically right after completing the upload and before
+// sending the message.
+func (s *Service) SetThumbnail(ctx context.Context, userID, fileID int64, data []byte, width, height int) (*protocol.FileInfo, error) {
+	if len(data) == 0 || width <= 0 || height <= 0 || width > MaxThumbnailDimension || height > MaxThumbnailDimension {
+		return nil, ErrInvalidThumbnail
+	}
+	if len(data) > MaxThumbnailSize {
+		return nil, ErrThumbnailTooLarge
+	}
+
+	file, err := s.readableFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file.OwnerID != userID {
+		return nil, authz.ErrForbidden
+	}
+
+	if err := s.store.SaveFileThumbnail(ctx, fileID, data, width, height); err != nil {
+		return nil, err
+	}
+	file.Thumbnail = data
+	file.ThumbnailWidth = width
+	file.ThumbnailHeight = height
+	return toFileInfo(file), nil
+}
+
+// GetFile returns a file's metadata if userID may read its chat
+func (s *Service) GetFile(ctx context.Context, userID, fileID int64) (*protocol.FileInfo, error) {
+	file, err := s.readableFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return toFileInfo(file), nil
+}
+
+// OpenFile opens length bytes of a file's contents starting at offset, or the
+// whole file if length is negative, if userID may read its chat
+func (s *Service) OpenFile(ctx context.Context, userID, fileID, offset, length int64) (io.ReadCloser, error) {
+	file, err := s.readableFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.ReadCloser
+	if length < 0 {
+		body, err = s.blobs.Get(ctx, file.BlobKey)
+	} else {
+		if offset < 0 || length == 0 || offset+length > file.Size {
+			return nil, ErrInvalidRange
+		}
+		body, err = s.blobs.GetRange(ctx, file.BlobKey, offset, length)
+	}
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, ErrFileNotFound
+	}
+	return body, err
+}
+
+// SignDownloadURL returns a query string that lets userID download the file
+// without an Authorization header (e.g. from <video> or <audio> elements)
+// until it expires. Chat membership is checked again on every download.
+func (s *Service) SignDownloadURL(ctx context.Context, userID, fileID int64) (*protocol.SignedFileURL, error) {
+	if len(s.urlKey) == 0 {
+		return nil, errors.New("signed download URLs are not configured")
+	}
+	if _, err := s.readableFile(ctx, userID, fileID); err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(s.urlTTL).Unix()
+	return &protocol.SignedFileURL{
+		FileID:    fileID,
+		URL:       fmt.Sprintf("/api/files/%d?uid=%d&expires=%d&sig=%s", fileID, userID, expires, s.signature(fileID, userID, expires)),
+		ExpiresAt: expires,
+	}, nil
+}
+
+// VerifyDownloadSignature checks a signed download URL's parameters
+func (s *Service) VerifyDownloadSignature(fileID, userID, expires int64, sig string) error {
+	if len(s.urlKey) == 0 || expires < time.Now().Unix() {
+		return ErrInvalidSignature
+	}
+	expected := s.signature(fileID, userID, expires)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s *Service) signature(fileID, userID, expires int64) string {
+	mac := hmac.New(sha256.New, s.urlKey)
+	fmt.Fprintf(mac, "file:%d:%d:%d", fileID, userID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readableFile loads a file record and checks that userID may read its chat
+func (s *Service) readableFile(ctx context.Context, userID, fileID int64) (*storage.File, error) {
+	file, err := s.store.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, ErrFileNotFound
+	}
+
+	access, err := s.access.Require(ctx, userID, file.ChatID, authz.PermRead)
+	if err != nil {
+		return nil, err
+	}
+	// Hidden along with the rest of the history while the chat is soft closed
+	if access.Chat.Status == "closed" {
+		return nil, ErrFileNotFound
+	}
+
+	return file, nil
+}
+
+// RunJanitor periodically removes abandoned uploads, files that were never
+// attached to a message, and blobs queued for deletion, until ctx is done
+func (s *Service) RunJanitor(ctx context.Context, interval, uploadTTL, orphanTTL time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.cleanup(ctx, uploadTTL, orphanTTL)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) cleanup(ctx context.Context, uploadTTL, orphanTTL time.Duration) {
+	now := time.Now()
+
+	sessions, err := s.store.ListStaleUploadSessions(ctx, now.Add(-uploadTTL).Unix())
+	if err != nil {
+		log.Printf("[FileService] Failed to list stale uploads: %v", err)
+	}
+	for _, session := range sessions {
+		keys := make([]string, session.ChunkCount)
+		for i := range keys {
+			keys[i] = chunkKey(session.ID, i)
+		}
+		if err := s.store.QueueBlobDeletions(ctx, keys); err != nil {
+			log.Printf("[FileService] Failed to queue chunks of upload %s: %v", session.ID, err)
+			continue
+		}
+		if err := s.store.DeleteUploadSession(ctx, session.ID); err != nil {
+			log.Printf("[FileService] Failed to delete upload %s: %v", session.ID, err)
+		}
+	}
+
+	if orphans, err := s.store.QueueUnattachedFiles(ctx, now.Add(-orphanTTL).Unix()); err != nil {
+		log.Printf("[FileService] Failed to queue unattached files: %v", err)
+	} else if orphans > 0 {
+		log.Printf("[FileService] Removed %d unattached files", orphans)
+	}
+
+	keys, err := s.store.ListBlobDeletions(ctx, 500)
+	if err != nil {
+		log.Printf("[FileService] Failed to list queued blob deletions: %v", err)
+		return
+	}
+	for _, key := range keys {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("[FileService] Failed to delete blob %s: %v", key, err)
+			continue
+		}
+		if err := s.store.RemoveBlobDeletion(ctx, key); err != nil {
+			log.Printf("[FileService] Failed to dequeue blob %s: %v", key, err)
+		}
+	}
+}
+
+// ownedSession loads an upload session and checks that userID started it
+func (s *Service) ownedSession(ctx context.Context, userID int64, uploadID string) (*storage.UploadSession, error) {
+	session, err := s.store.GetUploadSession(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.UserID != userID {
+		return nil, ErrUploadNotFound
+	}
+	return session, nil
+}
+
+func (s *Service) mimeTypeAllowed(mimeType string) bool {
+	if len(s.limits.AllowedMimeTypes) == 0 {
+		return true
+	}
+	mimeType = strings.ToLower(strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]))
+	for _, allowed := range s.limits.AllowedMimeTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mimeType || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mimeType, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) toUploadStatus(session *storage.UploadSession) *protocol.UploadStatus {
+	return &protocol.UploadStatus{
+		UploadID:     session.ID,
+		ChatID:       session.ChatID,
+		FileName:     session.FileName,
+		MimeType:     session.MimeType,
+		TotalSize:    session.TotalSize,
+		ReceivedSize: session.ReceivedSize,
+		MaxChunkSize: s.limits.MaxChunkSize,
+	}
+}
+
+// ToFileInfo converts a stored file record to its API representation
+func toFileInfo(file *storage.File) *protocol.FileInfo {
+	info := &protocol.FileInfo{
+		ID:        file.ID,
+		ChatID:    file.ChatID,
+		OwnerID:   file.OwnerID,
+		FileName:  file.FileName,
+		MimeType:  file.MimeType,
+		Size:      file.Size,
+		CreatedAt: file.CreatedAt,
+	}
+	if len(file.Thumbnail) > 0 {
+		info.Thumbnail = hex.EncodeToString(file.Thumbnail)
+		info.Width = file.ThumbnailWidth
+		info.Height = file.ThumbnailHeight
+	}
+	return info
+}
+
+func chunkKey(uploadID string, index int) string {
+	return fmt.Sprintf("uploads/%s/%d", uploadID, index)
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// chunkReader reads a sequence of chunk blobs as one stream, opening each lazily
+type chunkReader struct {
+	ctx     context.Context
+	blobs   blobstore.Store
+	keys    []string
+	current io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.keys) == 0 {
+				return 0, io.EOF
+			}
+			body, err := c.blobs.Get(c.ctx, c.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			c.current = body
+			c.keys = c.keys[1:]
+		}
+
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.current != nil {
+		return c.current.Close()
+	}
+	return nil
+}
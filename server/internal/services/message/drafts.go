@@ -0,0 +1,115 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+var ErrDraftTooLarge = errors.New("draft is too large")
+
+// MaxDraftSize limits the ciphertext of a stored draft
+const MaxDraftSize = 64 * 1024
+
+// GetDraft returns the user's draft for a chat, or an empty draft if there is none
+func (s *Service) GetDraft(ctx context.Context, chatID, userID int64) (*protocol.Draft, error) {
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermRead); err != nil {
+		return nil, err
+	}
+
+	draft, err := s.store.GetDraft(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if draft == nil {
+		return &protocol.Draft{ChatID: chatID}, nil
+	}
+	return toProtocolDraft(draft), nil
+}
+
+// SaveDraft stores the user's encrypted draft for a chat and pushes it to the
+// user's other devices. An empty ciphertext clears the draft.
+func (s *Service) SaveDraft(ctx context.Context, chatID, userID int64, ciphertext, iv []byte) (*protocol.Draft, error) {
+	if len(ciphertext) == 0 {
+		return s.ClearDraft(ctx, chatID, userID)
+	}
+	if len(ciphertext) > MaxDraftSize {
+		return nil, ErrDraftTooLarge
+	}
+
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermWrite); err != nil {
+		return nil, err
+	}
+
+	draft, err := s.store.SaveDraft(ctx, chatID, userID, ciphertext, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	result := toProtocolDraft(draft)
+	s.broadcastDraft(userID, result)
+	return result, nil
+}
+
+// ClearDraft removes the user's draft for a chat
+func (s *Service) ClearDraft(ctx context.Context, chatID, userID int64) (*protocol.Draft, error) {
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermRead); err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.store.DeleteDraft(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &protocol.Draft{ChatID: chatID, UpdatedAt: time.Now().Unix()}
+	if deleted {
+		s.broadcastDraft(userID, result)
+	}
+	return result, nil
+}
+
+// clearDraftAfterSend drops the sender's draft once their message went out
+func (s *Service) clearDraftAfterSend(ctx context.Context, chatID, userID int64) {
+	deleted, err := s.store.DeleteDraft(ctx, chatID, userID)
+	if err != nil {
+		log.Printf("[MessageService] Failed to clear draft of user %d in chat %d: %v", userID, chatID, err)
+		return
+	}
+	if deleted {
+		s.broadcastDraft(userID, &protocol.Draft{ChatID: chatID, UpdatedAt: time.Now().Unix()})
+	}
+}
+
+// broadcastDraft sends the updated draft to all of the user's connections
+func (s *Service) broadcastDraft(userID int64, draft *protocol.Draft) {
+	if s.broadcastHandler == nil {
+		return
+	}
+	s.broadcastHandler(&protocol.WebSocketEvent{
+		Type:      "draft_updated",
+		UserID:    userID,
+		Timestamp: time.Now().Unix(),
+		Data: map[string]interface{}{
+			"chat_id":    draft.ChatID,
+			"ciphertext": draft.Ciphertext,
+			"iv":         draft.IV,
+			"updated_at": draft.UpdatedAt,
+		},
+	})
+}
+
+func toProtocolDraft(draft *storage.Draft) *protocol.Draft {
+	return &protocol.Draft{
+		ChatID:     draft.ChatID,
+		Ciphertext: fmt.Sprintf("%x", draft.Ciphertext),
+		IV:         fmt.Sprintf("%x", draft.IV),
+		UpdatedAt:  draft.UpdatedAt,
+	}
+}
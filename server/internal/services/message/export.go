@@ -0,0 +1,91 @@
+package message
+
+import (
+	"context"
+	"encoding/hex"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+// exportBatchSize is how many messages are read from the database at a time while exporting
+const exportBatchSize = 500
+
+// ExportMessages walks a chat's entire history in ID order, starting after
+// afterID (0 for the beginning), and passes every message to emit. Returning
+// an error from emit stops the export. Exporting does not count as delivery.
+func (s *Service) ExportMessages(ctx context.Context, chatID, userID, afterID int64, emit func(*protocol.ExportedMessage) error) error {
+	if afterID < 0 {
+		return ErrInvalidCursor
+	}
+
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return err
+	}
+	// A soft-closed chat hides its history until it is reopened
+	if access.Chat.Status == "closed" {
+		return nil
+	}
+
+	// The export covers the whole history, archived messages included
+	filter := &storage.MessageFilter{IncludeArchived: true}
+	cursor := afterID
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		messages, err := s.store.GetChatMessages(ctx, chatID, cursor, false, exportBatchSize, filter)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		messageIDs := make([]int64, 0, len(messages))
+		for _, m := range messages {
+			messageIDs = append(messageIDs, m.ID)
+		}
+		attachments, err := s.store.GetMessageAttachments(ctx, messageIDs)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range messages {
+			exported := &protocol.ExportedMessage{
+				ID:               m.ID,
+				ChatID:           m.ChatID,
+				SenderID:         m.SenderID,
+				Ciphertext:       hex.EncodeToString(m.Ciphertext),
+				IV:               hex.EncodeToString(m.IV),
+				CreatedAt:        m.CreatedAt,
+				FileName:         m.FileName,
+				MimeType:         m.MimeType,
+				ReplyToMessageID: m.ReplyToMessageID,
+				MessageUUID:      m.MessageUUID,
+				Seq:              m.Seq,
+				Preview:          hex.EncodeToString(m.Preview),
+				PreviewIV:        hex.EncodeToString(m.PreviewIV),
+				RatchetHeader:    hex.EncodeToString(m.RatchetHeader),
+				Urgent:           m.Urgent,
+				ExpiresAt:        m.ExpiresAt,
+				Status:           messageStatus(m),
+				Attachments:      make([]*protocol.FileInfo, 0, len(attachments[m.ID])),
+			}
+			for _, file := range attachments[m.ID] {
+				exported.Attachments = append(exported.Attachments, toFileInfo(file))
+			}
+			if err := emit(exported); err != nil {
+				return err
+			}
+		}
+
+		cursor = messages[len(messages)-1].ID
+		if len(messages) < exportBatchSize {
+			return nil
+		}
+	}
+}
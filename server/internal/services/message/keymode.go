@@ -0,0 +1,34 @@
+package message
+
+import (
+	"context"
+	"errors"
+
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+// ErrKeysNotExchanged is returned for messages to a client-only chat whose
+// key exchange has not completed
+var ErrKeysNotExchanged = errors.New("chat keys are client-only and the key exchange is not complete")
+
+// checkKeyMode holds back messages to a direct chat in client-only key mode
+// until both participants published their keys: the server keeps no session
+// key such a message could have been encrypted under instead
+func (s *Service) checkKeyMode(ctx context.Context, chat *storage.Chat) error {
+	if chat.ChatType == protocol.ChatTypeSelf {
+		return nil
+	}
+	mode, err := s.store.GetKeyMode(ctx, chat.ID)
+	if err != nil || mode != protocol.KeyModeClientOnly {
+		return err
+	}
+	state, _, err := s.store.GetDHState(ctx, chat.ID)
+	if err != nil {
+		return err
+	}
+	if state == protocol.DHStateAwaitingInitiator || state == protocol.DHStateAwaitingPeer {
+		return ErrKeysNotExchanged
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+var ErrTooManyPins = errors.New("pinned message limit reached")
+
+// MaxPinsPerChat limits how many messages can be pinned in one chat
+const MaxPinsPerChat = 50
+
+// ListPins returns a chat's pinned messages, most recently pinned first
+func (s *Service) ListPins(ctx context.Context, chatID, userID int64) ([]*protocol.PinnedMessage, error) {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*protocol.PinnedMessage, 0)
+	// Pins are hidden along with the history while the chat is soft closed
+	if access.Chat.Status == "closed" {
+		return result, nil
+	}
+
+	pins, err := s.store.ListChatPins(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	messageIDs := make([]int64, 0, len(pins))
+	for _, pin := range pins {
+		messageIDs = append(messageIDs, pin.MessageID)
+	}
+	attachments, err := s.store.GetMessageAttachments(ctx, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pin := range pins {
+		m, err := s.store.GetMessage(ctx, pin.MessageID)
+		if err != nil {
+			return nil, err
+		}
+		pinned := toProtocolPin(pin)
+		if m != nil {
+			pinned.Message = toProtocolMessage(m, attachments[m.ID])
+		}
+		result = append(result, pinned)
+	}
+	return result, nil
+}
+
+// PinMessage pins a message for both participants. Pinning an already pinned
+// message returns the existing pin.
+func (s *Service) PinMessage(ctx context.Context, chatID, userID, messageID int64) (*protocol.PinnedMessage, error) {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := s.store.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil || msg.ChatID != chatID {
+		return nil, ErrMessageNotInChat
+	}
+
+	existing, err := s.store.GetPin(ctx, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return toProtocolPin(existing), nil
+	}
+
+	pin, inserted, err := s.store.PinMessage(ctx, chatID, messageID, userID, MaxPinsPerChat)
+	if err != nil {
+		return nil, err
+	}
+	if !inserted {
+		// Either the limit was reached or someone else pinned it concurrently
+		existing, err := s.store.GetPin(ctx, chatID, messageID)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			return nil, ErrTooManyPins
+		}
+		return toProtocolPin(existing), nil
+	}
+	log.Printf("[MessageService] User %d pinned message %d in chat %d", userID, messageID, chatID)
+
+	result := toProtocolPin(pin)
+	s.broadcastPin(access.Chat, "message_pinned", result)
+	return result, nil
+}
+
+// UnpinMessage removes a pin for both participants
+func (s *Service) UnpinMessage(ctx context.Context, chatID, userID, messageID int64) error {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermWrite)
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.store.UnpinMessage(ctx, chatID, messageID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return nil
+	}
+	log.Printf("[MessageService] User %d unpinned message %d in chat %d", userID, messageID, chatID)
+
+	s.broadcastPin(access.Chat, "message_unpinned", &protocol.PinnedMessage{
+		ChatID:    chatID,
+		MessageID: messageID,
+		PinnedBy:  userID,
+		PinnedAt:  time.Now().Unix(),
+	})
+	return nil
+}
+
+// broadcastPin sends a pin event to both participants of the chat
+func (s *Service) broadcastPin(chat *storage.Chat, eventType string, pin *protocol.PinnedMessage) {
+	if s.broadcastHandler == nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"chat_id":    pin.ChatID,
+		"message_id": pin.MessageID,
+		"user_id":    pin.PinnedBy,
+		"timestamp":  pin.PinnedAt,
+	}
+	targetUserIDs := []int64{chat.User1ID, chat.User2ID}
+	if chat.User1ID == chat.User2ID {
+		targetUserIDs = targetUserIDs[:1]
+	}
+	for _, targetUserID := range targetUserIDs {
+		s.broadcastHandler(&protocol.WebSocketEvent{
+			Type:      eventType,
+			UserID:    targetUserID,
+			Timestamp: time.Now().Unix(),
+			Data:      data,
+		})
+	}
+}
+
+func toProtocolPin(pin *storage.Pin) *protocol.PinnedMessage {
+	return &protocol.PinnedMessage{
+		ChatID:    pin.ChatID,
+		MessageID: pin.MessageID,
+		PinnedBy:  pin.PinnedBy,
+		PinnedAt:  pin.PinnedAt,
+	}
+}
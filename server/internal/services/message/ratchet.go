@@ -0,0 +1,64 @@
+package message
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/protocol"
+)
+
+// GetRatchetChains returns where each participant's Double Ratchet stands in
+// a chat: their latest ratchet key, its epoch and the highest message number
+// sent under it
+func (s *Service) GetRatchetChains(ctx context.Context, chatID, userID int64) ([]*protocol.RatchetChain, error) {
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermRead); err != nil {
+		return nil, err
+	}
+
+	chains, err := s.store.GetRatchetChains(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*protocol.RatchetChain, 0, len(chains))
+	for _, chain := range chains {
+		result = append(result, &protocol.RatchetChain{
+			SenderID:  chain.SenderID,
+			Epoch:     chain.Epoch,
+			PublicKey: hex.EncodeToString(chain.PublicKey),
+			LastN:     chain.LastN,
+			CreatedAt: chain.CreatedAt,
+		})
+	}
+	return result, nil
+}
+
+// ResetRatchet starts the Double Ratchet of a chat over, for a participant
+// who lost their ratchet state. The other participant is told to start over
+// too, from the chat's shared secret.
+func (s *Service) ResetRatchet(ctx context.Context, chatID, userID int64) error {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermExchangeKeys)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.ResetRatchet(ctx, chatID); err != nil {
+		return err
+	}
+	log.Printf("[MessageService] User %d reset the ratchet of chat %d", userID, chatID)
+
+	if s.broadcastHandler != nil && access.OtherUserID != userID {
+		s.broadcastHandler(&protocol.WebSocketEvent{
+			Type:      "ratchet_reset",
+			UserID:    access.OtherUserID,
+			Timestamp: time.Now().Unix(),
+			Data: map[string]interface{}{
+				"chat_id": chatID,
+				"user_id": userID,
+			},
+		})
+	}
+	return nil
+}
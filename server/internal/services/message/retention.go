@@ -0,0 +1,104 @@
+package message
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"MinMsgr/server/internal/storage"
+)
+
+// RetentionStats are cumulative metrics of the retention purge worker
+type RetentionStats struct {
+	Runs          int64
+	Failures      int64
+	PurgedByAge   int64 // in dry-run mode: messages that would have been purged
+	PurgedByCount int64
+	PurgedExpired int64 // messages past their own expires_at
+	// Soft-deleted messages and users past the tombstone retention
+	PurgedTombstones int64
+	PurgedUsers      int64
+	Archived         int64 // messages moved to the archive
+	Batches          int64
+	LastRunAt        int64 // unix seconds
+	LastDuration     time.Duration
+	LastPurged       int64
+	DryRun           bool
+}
+
+// SetRetentionPolicy sets the server-wide retention policy that is applied on
+// top of each chat's own policy
+func (s *Service) SetRetentionPolicy(policy storage.PurgePolicy) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.retention = policy
+	s.retentionStats.DryRun = policy.DryRun
+}
+
+// RetentionStats returns a snapshot of the purge worker metrics
+func (s *Service) RetentionStats() RetentionStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.retentionStats
+}
+
+// PurgeExpiredMessages enforces the chat and server-wide retention policies once
+func (s *Service) PurgeExpiredMessages(ctx context.Context) (*storage.PurgeResult, error) {
+	s.statsMu.Lock()
+	policy := s.retention
+	s.statsMu.Unlock()
+
+	started := time.Now()
+	result, err := s.store.PurgeExpiredMessages(ctx, policy)
+	duration := time.Since(started)
+
+	s.statsMu.Lock()
+	s.retentionStats.Runs++
+	s.retentionStats.LastRunAt = started.Unix()
+	s.retentionStats.LastDuration = duration
+	if err != nil {
+		s.retentionStats.Failures++
+	} else {
+		s.retentionStats.PurgedByAge += result.ByAge
+		s.retentionStats.PurgedByCount += result.ByCount
+		s.retentionStats.PurgedExpired += result.ByExpiry
+		s.retentionStats.PurgedTombstones += result.Tombstones
+		s.retentionStats.PurgedUsers += result.Users
+		s.retentionStats.Archived += result.Archived
+		s.retentionStats.Batches += int64(result.Batches)
+		s.retentionStats.LastPurged = result.ByAge + result.ByCount + result.ByExpiry + result.Tombstones
+	}
+	s.statsMu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	purged := result.ByAge + result.ByCount + result.ByExpiry + result.Tombstones
+	if policy.DryRun {
+		log.Printf("[MessageService] Retention purge (dry run) would delete %d messages (%d by age, %d by count, %d expired, %d deleted) and %d deleted users and archive %d messages in %v",
+			purged, result.ByAge, result.ByCount, result.ByExpiry, result.Tombstones, result.Users, result.Archived, duration)
+	} else if purged > 0 || result.Users > 0 || result.Archived > 0 {
+		log.Printf("[MessageService] Retention purge deleted %d messages (%d by age, %d by count, %d expired, %d deleted) and %d deleted users and archived %d messages in %d batches, %v",
+			purged, result.ByAge, result.ByCount, result.ByExpiry, result.Tombstones, result.Users, result.Archived, result.Batches, duration)
+	}
+	return result, nil
+}
+
+// RunRetentionPurge runs PurgeExpiredMessages every interval until ctx is done
+func (s *Service) RunRetentionPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.PurgeExpiredMessages(ctx); err != nil {
+			log.Printf("[MessageService] Retention purge failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
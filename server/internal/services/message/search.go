@@ -0,0 +1,127 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/protocol"
+)
+
+var ErrInvalidSearchTokens = errors.New("invalid search tokens")
+
+// Limits for client-supplied search tokens. Tokens are opaque to the server;
+// clients are expected to send a keyed hash (e.g. HMAC-SHA256) per term.
+const (
+	MinSearchTokenSize     = 16
+	MaxSearchTokenSize     = 64
+	MaxSearchTokensPerMsg  = 256
+	MaxSearchTokensInQuery = 16
+)
+
+// IndexMessage replaces the search tokens of a message. Either participant may
+// (re)index a message, e.g. after enabling search on an existing chat.
+func (s *Service) IndexMessage(ctx context.Context, chatID, userID, messageID int64, tokens [][]byte) error {
+	tokens, err := normalizeSearchTokens(tokens, MaxSearchTokensPerMsg)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermWrite); err != nil {
+		return err
+	}
+
+	msg, err := s.store.GetMessage(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if msg == nil || msg.ChatID != chatID {
+		return ErrMessageNotInChat
+	}
+
+	return s.store.ReplaceMessageSearchTokens(ctx, chatID, messageID, tokens)
+}
+
+// SearchMessages returns the IDs of messages indexed under all of the given
+// tokens, newest first. The server only compares opaque tokens and never
+// sees the search terms.
+func (s *Service) SearchMessages(ctx context.Context, chatID, userID int64, tokens [][]byte, beforeID int64, limit int) (*protocol.SearchResult, error) {
+	tokens, err := normalizeSearchTokens(tokens, MaxSearchTokensInQuery)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 || beforeID < 0 {
+		return nil, ErrInvalidSearchTokens
+	}
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &protocol.SearchResult{MessageIDs: make([]int64, 0)}
+	// A soft-closed chat hides its history from search as well
+	if access.Chat.Status == "closed" {
+		return result, nil
+	}
+
+	ids, err := s.store.SearchMessages(ctx, chatID, tokens, beforeID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > limit {
+		result.HasMore = true
+		ids = ids[:limit]
+	}
+	if len(ids) > 0 {
+		result.MessageIDs = ids
+		next := ids[len(ids)-1]
+		result.NextCursor = &next
+	}
+	return result, nil
+}
+
+// indexSentMessage stores the search tokens that came with a new message.
+// The message is already saved, so a failure only costs searchability.
+func (s *Service) indexSentMessage(ctx context.Context, msg *protocol.EncryptedMessage, messageID int64) {
+	if len(msg.SearchTokens) == 0 {
+		return
+	}
+	if err := s.store.ReplaceMessageSearchTokens(ctx, msg.ChatID, messageID, msg.SearchTokens); err != nil {
+		log.Printf("[MessageService] Failed to index message %d: %v", messageID, err)
+	}
+}
+
+// normalizeSearchTokens validates token sizes and drops duplicates
+func normalizeSearchTokens(tokens [][]byte, max int) ([][]byte, error) {
+	if len(tokens) > max {
+		return nil, ErrInvalidSearchTokens
+	}
+
+	unique := make([][]byte, 0, len(tokens))
+	for _, token := range tokens {
+		if len(token) < MinSearchTokenSize || len(token) > MaxSearchTokenSize {
+			return nil, ErrInvalidSearchTokens
+		}
+		duplicate := false
+		for _, seen := range unique {
+			if bytes.Equal(seen, token) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			unique = append(unique, token)
+		}
+	}
+	return unique, nil
+}
@@ -1,26 +1,78 @@
 package message
 
 import (
+	"MinMsgr/server/internal/authz"
+	"MinMsgr/server/internal/pkg/crypto"
 	"MinMsgr/server/internal/protocol"
 	"MinMsgr/server/internal/storage"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"time"
 )
 
+var (
+	ErrChatNotFound     = authz.ErrChatNotFound
+	ErrUserNotInChat    = authz.ErrUserNotInChat
+	ErrMessageNotInChat = errors.New("message does not belong to this chat")
+	ErrInvalidCursor    = errors.New("invalid message history cursor")
+	ErrInvalidFilter    = errors.New("invalid message filter")
+	ErrInvalidReceipt   = errors.New("receipt status must be delivered or read")
+	// Idempotency key errors
+	ErrInvalidMessageUUID   = errors.New("invalid message_uuid")
+	ErrDuplicateMessageUUID = errors.New("message_uuid is already used by another message")
+	// Link preview errors
+	ErrInvalidPreview  = errors.New("preview and preview_iv must be sent together")
+	ErrPreviewTooLarge = errors.New("preview too large")
+	// Expiry errors
+	ErrInvalidExpiry = errors.New("expires_at must be in the future and at most one year ahead")
+	// Attachment errors
+	ErrTooManyAttachments  = errors.New("too many attachments")
+	ErrAttachmentForbidden = errors.New("attachment does not belong to this chat or sender")
+	// Double Ratchet errors
+	ErrInvalidRatchetHeader = crypto.ErrInvalidRatchetHeader
+	ErrStaleRatchet         = storage.ErrStaleRatchet
+)
+
+// MaxMessageUUIDLength matches the message_uuid column size
+const MaxMessageUUIDLength = 64
+
+// MaxPreviewSize limits the encrypted link preview stored with a message
+const MaxPreviewSize = 16 * 1024
+
+// MaxMessageTTL is the furthest in the future a message's expires_at may be
+const MaxMessageTTL = 365 * 24 * time.Hour
+
+// MaxAttachmentsPerMessage limits how many uploaded files one message can reference
+const MaxAttachmentsPerMessage = 10
+
+// Page size limits for message history
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// MaxPendingFlush limits how many undelivered messages are replayed when a
+// user connects; anything older is picked up from history
+const MaxPendingFlush = 500
+
 type Service struct {
 	store            *storage.DB
+	access           *authz.Checker
 	broadcastHandler func(event interface{})
-	// In-memory message buffer (temporary storage until delivered)
-	messageBuffer map[int64][]*protocol.EncryptedMessage
-	bufferMutex   sync.RWMutex
+	// Server-wide retention policy and purge worker metrics
+	retention      storage.PurgePolicy
+	retentionStats RetentionStats
+	statsMu        sync.Mutex
 }
 
 func NewService(store *storage.DB) *Service {
 	return &Service{
-		store:         store,
-		messageBuffer: make(map[int64][]*protocol.EncryptedMessage),
+		store:  store,
+		access: authz.New(store),
 	}
 }
 
@@ -29,7 +81,12 @@ func (s *Service) SetBroadcastHandler(handler func(event interface{})) {
 	s.broadcastHandler = handler
 }
 
-func (s *Service) ProcessMessage(ctx context.Context, msg *protocol.EncryptedMessage) error {
+// ProcessMessage validates, stores and broadcasts a new message and sets msg.ID.
+// msg.Seq is set to the message's sequence number in the chat.
+// If msg.MessageUUID matches a message the sender already sent to the chat,
+// nothing is stored or broadcast again: msg.ID is set to the existing message
+// and duplicate is true.
+func (s *Service) ProcessMessage(ctx context.Context, msg *protocol.EncryptedMessage) (duplicate bool, err error) {
 	// Log message routing info
 	ciphertextHex := ""
 	if len(msg.Ciphertext) > 0 {
@@ -42,27 +99,130 @@ func (s *Service) ProcessMessage(ctx context.Context, msg *protocol.EncryptedMes
 	log.Printf("[MessageService] Routing message: chat_id=%d, sender_id=%d, ciphertext_start=%s",
 		msg.ChatID, msg.SenderID, ciphertextHex)
 
-	// Get the chat to find the other user
-	chat, err := s.store.GetChat(msg.ChatID)
-	if err != nil || chat == nil {
-		log.Printf("[MessageService] Failed to get chat: %v", err)
-		return err
+	// Verify the sender may post to this chat and find the other user
+	access, err := s.access.Require(ctx, msg.SenderID, msg.ChatID, authz.PermWrite)
+	if err != nil {
+		log.Printf("[MessageService] Sender %d may not post to chat %d: %v", msg.SenderID, msg.ChatID, err)
+		return false, err
+	}
+	if err := s.checkKeyMode(ctx, access.Chat); err != nil {
+		return false, err
+	}
+
+	// A reply must point at an existing message of the same chat
+	if msg.ReplyToMessageID != nil {
+		original, err := s.store.GetMessage(ctx, *msg.ReplyToMessageID)
+		if err != nil {
+			return false, err
+		}
+		if original == nil || original.ChatID != msg.ChatID {
+			return false, ErrMessageNotInChat
+		}
+	}
+
+	// Attachments must be files the sender uploaded to this chat
+	if len(msg.AttachmentIDs) > MaxAttachmentsPerMessage {
+		return false, ErrTooManyAttachments
+	}
+	attachments := make([]*protocol.FileInfo, 0, len(msg.AttachmentIDs))
+	for _, fileID := range msg.AttachmentIDs {
+		file, err := s.store.GetFile(ctx, fileID)
+		if err != nil {
+			return false, err
+		}
+		if file == nil || file.OwnerID != msg.SenderID || file.ChatID != msg.ChatID {
+			return false, ErrAttachmentForbidden
+		}
+		attachments = append(attachments, toFileInfo(file))
+	}
+
+	// Search tokens are optional but must be well-formed
+	searchTokens, err := normalizeSearchTokens(msg.SearchTokens, MaxSearchTokensPerMsg)
+	if err != nil {
+		return false, err
+	}
+	msg.SearchTokens = searchTokens
+
+	if len(msg.MessageUUID) > MaxMessageUUIDLength || !validMessageUUID(msg.MessageUUID) {
+		return false, ErrInvalidMessageUUID
+	}
+
+	// The preview is opaque; only its presence and size are checked
+	if len(msg.Preview) > MaxPreviewSize {
+		return false, ErrPreviewTooLarge
+	}
+	if (len(msg.Preview) == 0) != (len(msg.PreviewIV) == 0) {
+		return false, ErrInvalidPreview
+	}
+
+	if msg.ExpiresAt != nil {
+		now := time.Now()
+		if *msg.ExpiresAt <= now.Unix() || *msg.ExpiresAt > now.Add(MaxMessageTTL).Unix() {
+			return false, ErrInvalidExpiry
+		}
+	}
+
+	// The server follows the sender's ratchet through the header; the
+	// message itself stays opaque
+	var ratchet *storage.RatchetStep
+	if len(msg.RatchetHeader) > 0 {
+		h, err := crypto.ParseRatchetHeader(msg.RatchetHeader)
+		if err != nil {
+			return false, err
+		}
+		ratchet = &storage.RatchetStep{Header: msg.RatchetHeader, PublicKey: h.PublicKey, N: int64(h.N)}
+	}
+
+	if msg.Urgent {
+		if err := s.checkUrgentRate(ctx, msg.SenderID); err != nil {
+			return false, err
+		}
 	}
 
 	// Save message to database
-	messageID, err := s.store.SaveMessage(msg.ChatID, msg.SenderID, msg.Ciphertext, msg.IV, msg.FileName, msg.MimeType)
+	messageID, seq, created, err := s.store.SaveMessage(ctx, &storage.NewMessage{
+		ChatID:        msg.ChatID,
+		SenderID:      msg.SenderID,
+		Ciphertext:    msg.Ciphertext,
+		IV:            msg.IV,
+		FileName:      msg.FileName,
+		MimeType:      msg.MimeType,
+		ReplyToID:     msg.ReplyToMessageID,
+		Preview:       msg.Preview,
+		PreviewIV:     msg.PreviewIV,
+		Urgent:        msg.Urgent,
+		ExpiresAt:     msg.ExpiresAt,
+		AttachmentIDs: msg.AttachmentIDs,
+		MessageUUID:   msg.MessageUUID,
+		Ratchet:       ratchet,
+	})
 	if err != nil {
 		log.Printf("[MessageService] Failed to save message: %v", err)
-		return err
+		return false, err
 	}
+	msg.ID = messageID
+	msg.Seq = seq
+	if !created {
+		// A retry of a message that was already stored (and broadcast)
+		existing, err := s.store.GetMessage(ctx, messageID)
+		if err != nil {
+			return false, err
+		}
+		if existing == nil || existing.SenderID != msg.SenderID {
+			return false, ErrDuplicateMessageUUID
+		}
+		log.Printf("[MessageService] Duplicate message_uuid %q in chat %d, returning message %d", msg.MessageUUID, msg.ChatID, messageID)
+		msg.Timestamp = existing.CreatedAt
+		return true, nil
+	}
+
+	s.indexSentMessage(ctx, msg, messageID)
+
+	// The draft the message was composed from is no longer needed
+	s.clearDraftAfterSend(ctx, msg.ChatID, msg.SenderID)
 
 	// Determine recipient user ID (the other participant in the chat)
-	var recipientUserID int64
-	if chat.User1ID == msg.SenderID {
-		recipientUserID = chat.User2ID
-	} else {
-		recipientUserID = chat.User1ID
-	}
+	recipientUserID := access.OtherUserID
 
 	// Broadcast WebSocket event to BOTH participants
 	if s.broadcastHandler != nil {
@@ -74,9 +234,11 @@ func (s *Service) ProcessMessage(ctx context.Context, msg *protocol.EncryptedMes
 			"id":         messageID,
 			"chat_id":    msg.ChatID,
 			"sender_id":  msg.SenderID,
+			"seq":        seq,
 			"ciphertext": ciphertextHex,
 			"iv":         ivHex,
 			"action":     "new",
+			"status":     protocol.MessageStatusSent,
 			"timestamp":  msg.Timestamp,
 		}
 
@@ -87,19 +249,50 @@ func (s *Service) ProcessMessage(ctx context.Context, msg *protocol.EncryptedMes
 		if msg.MimeType != "" {
 			data["mime_type"] = msg.MimeType
 		}
+		if msg.ReplyToMessageID != nil {
+			data["reply_to_message_id"] = *msg.ReplyToMessageID
+		}
+		if msg.MessageUUID != "" {
+			data["message_uuid"] = msg.MessageUUID
+		}
+		if len(msg.Preview) > 0 {
+			data["preview"] = fmt.Sprintf("%x", msg.Preview)
+			data["preview_iv"] = fmt.Sprintf("%x", msg.PreviewIV)
+		}
+		if len(msg.RatchetHeader) > 0 {
+			data["ratchet_header"] = fmt.Sprintf("%x", msg.RatchetHeader)
+		}
+		if msg.Urgent {
+			data["urgent"] = true
+		}
+		if msg.ExpiresAt != nil {
+			data["expires_at"] = *msg.ExpiresAt
+		}
+		if len(attachments) > 0 {
+			data["attachments"] = attachments
+		}
 
-		// Send to RECIPIENT
-		wsEvent := &protocol.WebSocketEvent{
-			Type:      "message_received",
-			UserID:    recipientUserID,
-			Timestamp: msg.Timestamp,
-			Data:      data,
+		// Send to RECIPIENT (skipped in Saved Messages where the sender is the recipient)
+		if recipientUserID != msg.SenderID {
+			// Only the recipient's copy says whether to alert them
+			recipientData := make(map[string]interface{}, len(data)+1)
+			for k, v := range data {
+				recipientData[k] = v
+			}
+			recipientData["notify"] = shouldNotify(s.notificationLevel(ctx, msg.ChatID, recipientUserID), msg.Urgent)
+
+			wsEvent := &protocol.WebSocketEvent{
+				Type:      "message_received",
+				UserID:    recipientUserID,
+				Timestamp: msg.Timestamp,
+				Data:      recipientData,
+			}
+			log.Printf("[MessageService] Broadcasting to RECIPIENT (UserID=%d) message (id=%d, chat_id=%d)", recipientUserID, messageID, msg.ChatID)
+			s.broadcastHandler(wsEvent)
 		}
-		log.Printf("[MessageService] Broadcasting to RECIPIENT (UserID=%d) message (id=%d, chat_id=%d)", recipientUserID, messageID, msg.ChatID)
-		s.broadcastHandler(wsEvent)
 
 		// Send to SENDER (so they get the real ID for their message)
-		wsEvent = &protocol.WebSocketEvent{
+		wsEvent := &protocol.WebSocketEvent{
 			Type:      "message_received",
 			UserID:    msg.SenderID,
 			Timestamp: msg.Timestamp,
@@ -109,41 +302,492 @@ func (s *Service) ProcessMessage(ctx context.Context, msg *protocol.EncryptedMes
 		s.broadcastHandler(wsEvent)
 	}
 
-	return nil
+	return false, nil
 }
 
-func (s *Service) GetChatMessages(ctx context.Context, chatID int64, limit, offset int) ([]*protocol.EncryptedMessage, error) {
-	// Get messages from database
-	messages, err := s.store.GetChatMessages(chatID, limit)
+// GetChatMessages returns one page of a chat's history. Without a cursor the
+// newest messages are returned; before_id pages backwards through older
+// messages and after_id pages forwards through newer ones.
+func (s *Service) GetChatMessages(ctx context.Context, chatID, userID int64, req *protocol.MessagePageRequest) (*protocol.MessagePage, error) {
+	direction, cursor, limit, err := resolvePageRequest(req)
 	if err != nil {
 		return nil, err
 	}
+
+	filter, err := toMessageFilter(req)
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &protocol.MessagePage{
+		Messages:  make([]*protocol.EncryptedMessage, 0),
+		Direction: direction,
+	}
+
+	// A soft-closed chat keeps its messages but hides them until it is reopened
+	if access.Chat.Status == "closed" {
+		return page, nil
+	}
+
+	// Fetch one extra row to find out whether another page follows
+	older := direction == protocol.PageBackward
+	messages, err := s.store.GetChatMessages(ctx, chatID, cursor, older, limit+1, filter)
+	if err != nil {
+		return nil, err
+	}
+	messages, page.HasMore = trimPage(messages, limit, older)
 	if len(messages) == 0 {
-		return make([]*protocol.EncryptedMessage, 0), nil
+		return page, nil
+	}
+
+	if older {
+		next := messages[0].ID
+		page.NextCursor = &next
+	} else {
+		next := messages[len(messages)-1].ID
+		page.NextCursor = &next
+	}
+
+	messageIDs := make([]int64, 0, len(messages))
+	for _, m := range messages {
+		messageIDs = append(messageIDs, m.ID)
+	}
+	attachments, err := s.store.GetMessageAttachments(ctx, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetching history counts as delivery of the messages addressed to userID
+	var undelivered []int64
+	for _, m := range messages {
+		if m.SenderID != userID && m.DeliveredAt == nil {
+			undelivered = append(undelivered, m.ID)
+		}
+	}
+	if len(undelivered) > 0 {
+		delivered, err := s.store.MarkMessagesDelivered(ctx, chatID, userID, undelivered)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().Unix()
+		for _, m := range messages {
+			if m.SenderID != userID && m.DeliveredAt == nil {
+				m.DeliveredAt = &now
+			}
+		}
+		s.broadcastStatus(chatID, access.OtherUserID, delivered, protocol.MessageStatusDelivered)
 	}
 
 	// Convert storage messages to protocol messages
 	result := make([]*protocol.EncryptedMessage, 0, len(messages))
 	for _, m := range messages {
-		msg := &protocol.EncryptedMessage{
-			ID:         m.ID,
-			ChatID:     m.ChatID,
-			SenderID:   m.SenderID,
-			Ciphertext: m.Ciphertext,
-			IV:         m.IV,
-			Timestamp:  m.CreatedAt,
-			FileName:   m.FileName,
-			MimeType:   m.MimeType,
+		result = append(result, toProtocolMessage(m, attachments[m.ID]))
+	}
+	page.Messages = result
+
+	return page, nil
+}
+
+// toMessageFilter validates the request's metadata filters; nil means no filtering
+func toMessageFilter(req *protocol.MessagePageRequest) (*storage.MessageFilter, error) {
+	if req.SenderID == 0 && req.HasFile == nil && req.MediaClass == "" && req.Since == 0 && req.Until == 0 && req.FromSeq == 0 && req.ToSeq == 0 && !req.IncludeArchived {
+		return nil, nil
+	}
+	if req.SenderID < 0 || req.Since < 0 || req.Until < 0 || (req.Until > 0 && req.Since > req.Until) {
+		return nil, ErrInvalidFilter
+	}
+	if req.FromSeq < 0 || req.ToSeq < 0 || (req.ToSeq > 0 && req.FromSeq > req.ToSeq) {
+		return nil, ErrInvalidFilter
+	}
+
+	filter := &storage.MessageFilter{
+		SenderID: req.SenderID,
+		HasFile:  req.HasFile,
+		Since:    req.Since,
+		Until:    req.Until,
+		FromSeq:  req.FromSeq,
+		ToSeq:    req.ToSeq,
+
+		IncludeArchived: req.IncludeArchived,
+	}
+	switch req.MediaClass {
+	case "":
+	case protocol.MediaImage:
+		filter.MediaClass = storage.MediaImage
+	case protocol.MediaVideo:
+		filter.MediaClass = storage.MediaVideo
+	case protocol.MediaAudio:
+		filter.MediaClass = storage.MediaAudio
+	case protocol.MediaDocument:
+		filter.MediaClass = storage.MediaDocument
+	default:
+		return nil, ErrInvalidFilter
+	}
+	return filter, nil
+}
+
+// FlushPendingDeliveries re-sends message_received events for every message
+// addressed to userID that no client of theirs has acknowledged yet. Messages
+// stay pending until a client ACKs them or fetches them from history, so this
+// runs every time the user connects.
+func (s *Service) FlushPendingDeliveries(ctx context.Context, userID int64) error {
+	if s.broadcastHandler == nil {
+		return nil
+	}
+
+	messages, err := s.store.ListUndeliveredMessages(ctx, userID, MaxPendingFlush)
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	messageIDs := make([]int64, 0, len(messages))
+	for _, m := range messages {
+		messageIDs = append(messageIDs, m.ID)
+	}
+	attachments, err := s.store.GetMessageAttachments(ctx, messageIDs)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[MessageService] Flushing %d pending messages to user %d", len(messages), userID)
+	levels := make(map[int64]string)
+	for _, m := range messages {
+		level, ok := levels[m.ChatID]
+		if !ok {
+			level = s.notificationLevel(ctx, m.ChatID, userID)
+			levels[m.ChatID] = level
+		}
+
+		data := map[string]interface{}{
+			"id":         m.ID,
+			"chat_id":    m.ChatID,
+			"sender_id":  m.SenderID,
+			"ciphertext": fmt.Sprintf("%x", m.Ciphertext),
+			"iv":         fmt.Sprintf("%x", m.IV),
+			"seq":        m.Seq,
+			"action":     "new",
+			"status":     protocol.MessageStatusSent,
+			"timestamp":  m.CreatedAt,
+			"pending":    true,
+			"notify":     shouldNotify(level, m.Urgent),
+		}
+		if m.FileName != "" {
+			data["file_name"] = m.FileName
+		}
+		if m.MimeType != "" {
+			data["mime_type"] = m.MimeType
+		}
+		if m.ReplyToMessageID != nil {
+			data["reply_to_message_id"] = *m.ReplyToMessageID
+		}
+		if m.MessageUUID != "" {
+			data["message_uuid"] = m.MessageUUID
+		}
+		if len(m.Preview) > 0 {
+			data["preview"] = fmt.Sprintf("%x", m.Preview)
+			data["preview_iv"] = fmt.Sprintf("%x", m.PreviewIV)
+		}
+		if len(m.RatchetHeader) > 0 {
+			data["ratchet_header"] = fmt.Sprintf("%x", m.RatchetHeader)
+		}
+		if m.Urgent {
+			data["urgent"] = true
+		}
+		if m.ExpiresAt != nil {
+			data["expires_at"] = *m.ExpiresAt
+		}
+		if files := attachments[m.ID]; len(files) > 0 {
+			infos := make([]*protocol.FileInfo, 0, len(files))
+			for _, file := range files {
+				infos = append(infos, toFileInfo(file))
+			}
+			data["attachments"] = infos
+		}
+
+		s.broadcastHandler(&protocol.WebSocketEvent{
+			Type:      "message_received",
+			UserID:    userID,
+			Timestamp: m.CreatedAt,
+			Data:      data,
+		})
+	}
+
+	return nil
+}
+
+// MarkRead records that userID has read chatID up to and including messageID,
+// and notifies the other participant so their "seen" indicators update
+func (s *Service) MarkRead(ctx context.Context, chatID, userID, messageID int64) error {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.store.GetMessage(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if msg == nil || msg.ChatID != chatID {
+		return ErrMessageNotInChat
+	}
+
+	if err := s.store.SaveReadMarker(ctx, chatID, userID, messageID); err != nil {
+		return err
+	}
+
+	read, err := s.store.MarkMessagesRead(ctx, chatID, userID, messageID)
+	if err != nil {
+		return err
+	}
+	s.broadcastStatus(chatID, access.OtherUserID, read, protocol.MessageStatusRead)
+
+	if s.broadcastHandler != nil {
+		otherUserID := access.OtherUserID
+
+		data := map[string]interface{}{
+			"chat_id":              chatID,
+			"user_id":              userID,
+			"last_read_message_id": messageID,
+			"timestamp":            time.Now().Unix(),
+		}
+
+		evt := &protocol.WebSocketEvent{
+			Type:      "messages_read",
+			UserID:    otherUserID,
+			Timestamp: time.Now().Unix(),
+			Data:      data,
+		}
+		s.broadcastHandler(evt)
+	}
+
+	return nil
+}
+
+// AckDelivered records that the message_received event for messageID reached
+// userID's client and tells the sender the message was delivered
+func (s *Service) AckDelivered(ctx context.Context, chatID, userID, messageID int64) error {
+	access, err := s.access.Require(ctx, userID, chatID, authz.PermRead)
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.store.GetMessage(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if msg == nil || msg.ChatID != chatID {
+		return ErrMessageNotInChat
+	}
+
+	delivered, err := s.store.MarkMessagesDelivered(ctx, chatID, userID, []int64{messageID})
+	if err != nil {
+		return err
+	}
+	s.broadcastStatus(chatID, access.OtherUserID, delivered, protocol.MessageStatusDelivered)
+	return nil
+}
+
+// AckReceipt marks every message addressed to userID in the receipt's chat up
+// to and including UpToMessageID as delivered or read, in a single update
+func (s *Service) AckReceipt(ctx context.Context, userID int64, receipt *protocol.MessageReceipt) error {
+	switch receipt.Status {
+	case protocol.MessageStatusRead:
+		return s.MarkRead(ctx, receipt.ChatID, userID, receipt.UpToMessageID)
+	case protocol.MessageStatusDelivered:
+	default:
+		return ErrInvalidReceipt
+	}
+
+	access, err := s.access.Require(ctx, userID, receipt.ChatID, authz.PermRead)
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.store.GetMessage(ctx, receipt.UpToMessageID)
+	if err != nil {
+		return err
+	}
+	if msg == nil || msg.ChatID != receipt.ChatID {
+		return ErrMessageNotInChat
+	}
+
+	delivered, err := s.store.MarkMessagesDeliveredUpTo(ctx, receipt.ChatID, userID, receipt.UpToMessageID)
+	if err != nil {
+		return err
+	}
+	s.broadcastStatus(receipt.ChatID, access.OtherUserID, delivered, protocol.MessageStatusDelivered)
+	return nil
+}
+
+// broadcastStatus sends a message_status event about messageIDs to their sender
+func (s *Service) broadcastStatus(chatID, senderID int64, messageIDs []int64, status string) {
+	if s.broadcastHandler == nil || len(messageIDs) == 0 {
+		return
+	}
+
+	data := map[string]interface{}{
+		"chat_id":     chatID,
+		"message_ids": messageIDs,
+		"status":      status,
+		"timestamp":   time.Now().Unix(),
+	}
+
+	evt := &protocol.WebSocketEvent{
+		Type:      "message_status",
+		UserID:    senderID,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	}
+	s.broadcastHandler(evt)
+}
+
+// messageStatus derives a message's delivery state from its timestamps
+func messageStatus(m *storage.Message) string {
+	switch {
+	case m.ReadAt != nil:
+		return protocol.MessageStatusRead
+	case m.DeliveredAt != nil:
+		return protocol.MessageStatusDelivered
+	default:
+		return protocol.MessageStatusSent
+	}
+}
+
+// GetReadMarkers returns every participant's last-read message for a chat
+func (s *Service) GetReadMarkers(ctx context.Context, chatID, userID int64) ([]*storage.ReadMarker, error) {
+	if _, err := s.access.Require(ctx, userID, chatID, authz.PermRead); err != nil {
+		return nil, err
+	}
+
+	markers, err := s.store.GetReadMarkers(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if markers == nil {
+		markers = make([]*storage.ReadMarker, 0)
+	}
+	return markers, nil
+}
+
+// resolvePageRequest validates the cursor of a history request and returns the
+// paging direction, the cursor message ID and the clamped page size
+func resolvePageRequest(req *protocol.MessagePageRequest) (direction string, cursor int64, limit int, err error) {
+	if req.BeforeID < 0 || req.AfterID < 0 || (req.BeforeID > 0 && req.AfterID > 0) {
+		return "", 0, 0, ErrInvalidCursor
+	}
+
+	direction = req.Direction
+	if direction == "" {
+		direction = protocol.PageBackward
+		// A sequence range is filled in from its start
+		if req.AfterID > 0 || (req.FromSeq > 0 && req.BeforeID == 0) {
+			direction = protocol.PageForward
+		}
+	}
+
+	switch direction {
+	case protocol.PageBackward:
+		if req.AfterID > 0 {
+			return "", 0, 0, ErrInvalidCursor
+		}
+		cursor = req.BeforeID
+	case protocol.PageForward:
+		if req.BeforeID > 0 {
+			return "", 0, 0, ErrInvalidCursor
+		}
+		cursor = req.AfterID
+	default:
+		return "", 0, 0, ErrInvalidCursor
+	}
+
+	limit = req.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	return direction, cursor, limit, nil
+}
+
+// trimPage cuts a chronological result fetched with limit+1 rows down to limit
+// rows. The extra row is the one furthest from the cursor: the oldest one when
+// paging backward, the newest one when paging forward.
+func trimPage(messages []*storage.Message, limit int, older bool) ([]*storage.Message, bool) {
+	if len(messages) <= limit {
+		return messages, false
+	}
+	if older {
+		return messages[len(messages)-limit:], true
+	}
+	return messages[:limit], true
+}
+
+// validMessageUUID accepts empty UUIDs and UUID-like keys made of letters,
+// digits, '-' and '_'
+func validMessageUUID(uuid string) bool {
+	for _, c := range uuid {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
 		}
-		result = append(result, msg)
 	}
+	return true
+}
 
-	return result, nil
+// toProtocolMessage converts a stored message and its attachments to its API representation
+func toProtocolMessage(m *storage.Message, files []*storage.File) *protocol.EncryptedMessage {
+	msg := &protocol.EncryptedMessage{
+		ID:               m.ID,
+		ChatID:           m.ChatID,
+		SenderID:         m.SenderID,
+		Ciphertext:       m.Ciphertext,
+		IV:               m.IV,
+		Timestamp:        m.CreatedAt,
+		FileName:         m.FileName,
+		MimeType:         m.MimeType,
+		ReplyToMessageID: m.ReplyToMessageID,
+		MessageUUID:      m.MessageUUID,
+		Seq:              m.Seq,
+		Preview:          m.Preview,
+		PreviewIV:        m.PreviewIV,
+		RatchetHeader:    m.RatchetHeader,
+		Urgent:           m.Urgent,
+		ExpiresAt:        m.ExpiresAt,
+		Status:           messageStatus(m),
+		DeliveredAt:      m.DeliveredAt,
+		ReadAt:           m.ReadAt,
+	}
+	for _, file := range files {
+		msg.Attachments = append(msg.Attachments, toFileInfo(file))
+	}
+	return msg
 }
 
-// DeleteChatMessages removes messages for a chat (called when chat is closed)
-func (s *Service) DeleteChatMessages(chatID int64) {
-	s.bufferMutex.Lock()
-	delete(s.messageBuffer, chatID)
-	s.bufferMutex.Unlock()
+// toFileInfo converts a stored file record to its API representation
+func toFileInfo(file *storage.File) *protocol.FileInfo {
+	info := &protocol.FileInfo{
+		ID:        file.ID,
+		ChatID:    file.ChatID,
+		OwnerID:   file.OwnerID,
+		FileName:  file.FileName,
+		MimeType:  file.MimeType,
+		Size:      file.Size,
+		CreatedAt: file.CreatedAt,
+	}
+	if len(file.Thumbnail) > 0 {
+		info.Thumbnail = hex.EncodeToString(file.Thumbnail)
+		info.Width = file.ThumbnailWidth
+		info.Height = file.ThumbnailHeight
+	}
+	return info
 }
@@ -0,0 +1,193 @@
+package message
+
+import (
+	"errors"
+	"testing"
+
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+func TestResolvePageRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		req           protocol.MessagePageRequest
+		wantDirection string
+		wantCursor    int64
+		wantLimit     int
+		wantErr       error
+	}{
+		{"newest page", protocol.MessagePageRequest{}, protocol.PageBackward, 0, DefaultPageSize, nil},
+		{"before cursor", protocol.MessagePageRequest{BeforeID: 40, Limit: 10}, protocol.PageBackward, 40, 10, nil},
+		{"after cursor", protocol.MessagePageRequest{AfterID: 40, Limit: 10}, protocol.PageForward, 40, 10, nil},
+		{"forward from the start", protocol.MessagePageRequest{Direction: protocol.PageForward}, protocol.PageForward, 0, DefaultPageSize, nil},
+		{"sequence range", protocol.MessagePageRequest{FromSeq: 5, ToSeq: 9}, protocol.PageForward, 0, DefaultPageSize, nil},
+		{"limit is clamped", protocol.MessagePageRequest{Limit: MaxPageSize + 1}, protocol.PageBackward, 0, MaxPageSize, nil},
+		{"both cursors", protocol.MessagePageRequest{BeforeID: 5, AfterID: 3}, "", 0, 0, ErrInvalidCursor},
+		{"negative cursor", protocol.MessagePageRequest{BeforeID: -1}, "", 0, 0, ErrInvalidCursor},
+		{"backward with after cursor", protocol.MessagePageRequest{AfterID: 3, Direction: protocol.PageBackward}, "", 0, 0, ErrInvalidCursor},
+		{"forward with before cursor", protocol.MessagePageRequest{BeforeID: 3, Direction: protocol.PageForward}, "", 0, 0, ErrInvalidCursor},
+		{"unknown direction", protocol.MessagePageRequest{Direction: "sideways"}, "", 0, 0, ErrInvalidCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			direction, cursor, limit, err := resolvePageRequest(&tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if direction != tt.wantDirection || cursor != tt.wantCursor || limit != tt.wantLimit {
+				t.Fatalf("expected (%s, %d, %d), got (%s, %d, %d)",
+					tt.wantDirection, tt.wantCursor, tt.wantLimit, direction, cursor, limit)
+			}
+		})
+	}
+}
+
+func TestToMessageFilterSeqRange(t *testing.T) {
+	filter, err := toMessageFilter(&protocol.MessagePageRequest{FromSeq: 5, ToSeq: 9})
+	if err != nil {
+		t.Fatalf("toMessageFilter failed: %v", err)
+	}
+	if filter == nil || filter.FromSeq != 5 || filter.ToSeq != 9 {
+		t.Fatalf("expected seq range 5..9, got %+v", filter)
+	}
+
+	for _, req := range []protocol.MessagePageRequest{{FromSeq: -1}, {ToSeq: -1}, {FromSeq: 9, ToSeq: 5}} {
+		if _, err := toMessageFilter(&req); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("expected ErrInvalidFilter for %+v, got %v", req, err)
+		}
+	}
+}
+
+func TestToMessageFilterIncludeArchived(t *testing.T) {
+	filter, err := toMessageFilter(&protocol.MessagePageRequest{IncludeArchived: true})
+	if err != nil {
+		t.Fatalf("toMessageFilter failed: %v", err)
+	}
+	if filter == nil || !filter.IncludeArchived {
+		t.Fatalf("expected a filter including archived messages, got %+v", filter)
+	}
+}
+
+// fetchPage mimics storage.GetChatMessages on an in-memory chat whose message
+// IDs are 1..total: keyset selection on the ID, chronological result
+func fetchPage(total, cursor int64, older bool, limit int) []*storage.Message {
+	var messages []*storage.Message
+	if older {
+		upper := total
+		if cursor > 0 {
+			upper = cursor - 1
+		}
+		lower := upper - int64(limit) + 1
+		if lower < 1 {
+			lower = 1
+		}
+		for id := lower; id <= upper; id++ {
+			messages = append(messages, &storage.Message{ID: id})
+		}
+		return messages
+	}
+	for id := cursor + 1; id <= total && len(messages) < limit; id++ {
+		messages = append(messages, &storage.Message{ID: id})
+	}
+	return messages
+}
+
+// walkPages pages through a chat of total messages the way GetChatMessages
+// does and returns every page's message IDs
+func walkPages(t *testing.T, total int64, req protocol.MessagePageRequest) [][]int64 {
+	t.Helper()
+	var pages [][]int64
+	for {
+		direction, cursor, limit, err := resolvePageRequest(&req)
+		if err != nil {
+			t.Fatalf("resolvePageRequest failed: %v", err)
+		}
+		older := direction == protocol.PageBackward
+		messages, hasMore := trimPage(fetchPage(total, cursor, older, limit+1), limit, older)
+
+		ids := make([]int64, 0, len(messages))
+		for _, m := range messages {
+			ids = append(ids, m.ID)
+		}
+		pages = append(pages, ids)
+		if !hasMore {
+			return pages
+		}
+		if len(pages) > int(total)+1 {
+			t.Fatalf("pagination does not terminate")
+		}
+		if older {
+			req.BeforeID = ids[0]
+		} else {
+			req.AfterID = ids[len(ids)-1]
+		}
+	}
+}
+
+func TestPagingBackwardVisitsEveryMessageOnce(t *testing.T) {
+	pages := walkPages(t, 23, protocol.MessagePageRequest{Limit: 10})
+
+	want := [][]int64{
+		{14, 15, 16, 17, 18, 19, 20, 21, 22, 23},
+		{4, 5, 6, 7, 8, 9, 10, 11, 12, 13},
+		{1, 2, 3},
+	}
+	assertPages(t, pages, want)
+}
+
+func TestPagingForwardVisitsEveryMessageOnce(t *testing.T) {
+	pages := walkPages(t, 23, protocol.MessagePageRequest{Direction: protocol.PageForward, Limit: 10})
+
+	want := [][]int64{
+		{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		{11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+		{21, 22, 23},
+	}
+	assertPages(t, pages, want)
+}
+
+func TestPagingExactMultipleHasNoEmptyTrailingPage(t *testing.T) {
+	pages := walkPages(t, 20, protocol.MessagePageRequest{Limit: 10})
+
+	want := [][]int64{
+		{11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+		{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+	}
+	assertPages(t, pages, want)
+}
+
+func TestTrimPage(t *testing.T) {
+	messages := []*storage.Message{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	if got, hasMore := trimPage(messages, 3, true); hasMore || len(got) != 3 {
+		t.Fatalf("a short result must be returned unchanged, got %d messages, hasMore=%v", len(got), hasMore)
+	}
+	if got, hasMore := trimPage(messages, 2, true); !hasMore || got[0].ID != 2 || got[1].ID != 3 {
+		t.Fatalf("paging backward must drop the oldest message, got %v", got)
+	}
+	if got, hasMore := trimPage(messages, 2, false); !hasMore || got[0].ID != 1 || got[1].ID != 2 {
+		t.Fatalf("paging forward must drop the newest message, got %v", got)
+	}
+}
+
+func assertPages(t *testing.T, got, want [][]int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d pages, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			t.Fatalf("page %d: expected %v, got %v", i, want[i], got[i])
+		}
+		for j := range want[i] {
+			if got[i][j] != want[i][j] {
+				t.Fatalf("page %d: expected %v, got %v", i, want[i], got[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"MinMsgr/server/internal/protocol"
+	"MinMsgr/server/internal/storage"
+)
+
+// ErrInvalidSyncCursor is returned for a malformed sync cursor
+var ErrInvalidSyncCursor = errors.New("invalid sync cursor")
+
+// MaxSyncMessages limits how many messages one sync call returns
+const MaxSyncMessages = 500
+
+// Sync returns everything that changed on userID's account since cursor: new
+// messages from all chats, and chats and contacts that were created or
+// changed. An empty cursor starts a full sync. Like the history, sync does not
+// change the delivery state of messages; clients ACK them as usual.
+//
+// The cursor is opaque to clients. It holds the last message ID returned and
+// the time the previous sync started; chat and contact changes are compared by
+// whole seconds, so a change may be reported twice but is never skipped.
+func (s *Service) Sync(ctx context.Context, userID int64, cursor string) (*protocol.SyncResponse, error) {
+	afterID, since, err := parseSyncCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	// Taken before reading so that changes made during this sync are
+	// reported again by the next one
+	startedAt := time.Now().Unix()
+
+	messages, err := s.store.ListMessagesSince(ctx, userID, afterID, MaxSyncMessages+1)
+	if err != nil {
+		return nil, err
+	}
+	resp := &protocol.SyncResponse{
+		Messages: make([]*protocol.EncryptedMessage, 0, len(messages)),
+		Chats:    make([]*protocol.Chat, 0),
+		Contacts: make([]*protocol.Contact, 0),
+	}
+	if len(messages) > MaxSyncMessages {
+		messages = messages[:MaxSyncMessages]
+		resp.HasMore = true
+	}
+
+	if len(messages) > 0 {
+		messageIDs := make([]int64, 0, len(messages))
+		for _, m := range messages {
+			messageIDs = append(messageIDs, m.ID)
+		}
+		attachments, err := s.store.GetMessageAttachments(ctx, messageIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range messages {
+			resp.Messages = append(resp.Messages, toProtocolMessage(m, attachments[m.ID]))
+		}
+		afterID = messages[len(messages)-1].ID
+	}
+
+	chats, err := s.store.ListChatsChangedSince(ctx, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	for _, chat := range chats {
+		resp.Chats = append(resp.Chats, toProtocolChat(chat))
+	}
+
+	contacts, err := s.store.ListContactsChangedSince(ctx, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	for _, contact := range contacts {
+		resp.Contacts = append(resp.Contacts, &protocol.Contact{
+			ID:          contact.ID,
+			User1ID:     contact.User1ID,
+			User2ID:     contact.User2ID,
+			RequesterID: contact.RequesterID,
+			Status:      contact.Status,
+			CreatedAt:   contact.CreatedAt,
+		})
+	}
+
+	if resp.ChatIDs, err = s.store.ListUserChatIDs(ctx, userID); err != nil {
+		return nil, err
+	}
+	if resp.ContactIDs, err = s.store.ListUserContactIDs(ctx, userID); err != nil {
+		return nil, err
+	}
+	if resp.ChatIDs == nil {
+		resp.ChatIDs = []int64{}
+	}
+	if resp.ContactIDs == nil {
+		resp.ContactIDs = []int64{}
+	}
+
+	resp.Cursor = formatSyncCursor(afterID, startedAt)
+	return resp, nil
+}
+
+func formatSyncCursor(afterID, since int64) string {
+	return fmt.Sprintf("%d.%d", afterID, since)
+}
+
+func parseSyncCursor(cursor string) (afterID, since int64, err error) {
+	if cursor == "" {
+		return 0, 0, nil
+	}
+	idPart, sincePart, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return 0, 0, ErrInvalidSyncCursor
+	}
+	afterID, err = strconv.ParseInt(idPart, 10, 64)
+	if err != nil || afterID < 0 {
+		return 0, 0, ErrInvalidSyncCursor
+	}
+	since, err = strconv.ParseInt(sincePart, 10, 64)
+	if err != nil || since < 0 {
+		return 0, 0, ErrInvalidSyncCursor
+	}
+	return afterID, since, nil
+}
+
+// toProtocolChat converts a stored chat to its API representation
+func toProtocolChat(chat *storage.Chat) *protocol.Chat {
+	return &protocol.Chat{
+		ID:             chat.ID,
+		User1ID:        chat.User1ID,
+		User2ID:        chat.User2ID,
+		ChatType:       chat.ChatType,
+		Algorithm:      chat.Algorithm,
+		Mode:           chat.Mode,
+		Padding:        chat.Padding,
+		Status:         chat.Status,
+		CreatedAt:      chat.CreatedAt,
+		ClosedAt:       chat.ClosedAt,
+		LastActivityAt: chat.LastActivityAt,
+		LastSeq:        chat.LastSeq,
+	}
+}
@@ -0,0 +1,29 @@
+package message
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncCursorRoundTrip(t *testing.T) {
+	afterID, since, err := parseSyncCursor(formatSyncCursor(1234, 1700000000))
+	if err != nil {
+		t.Fatalf("parseSyncCursor failed: %v", err)
+	}
+	if afterID != 1234 || since != 1700000000 {
+		t.Fatalf("expected (1234, 1700000000), got (%d, %d)", afterID, since)
+	}
+
+	afterID, since, err = parseSyncCursor("")
+	if err != nil || afterID != 0 || since != 0 {
+		t.Fatalf("an empty cursor must start a full sync, got (%d, %d, %v)", afterID, since, err)
+	}
+}
+
+func TestParseSyncCursorRejectsMalformed(t *testing.T) {
+	for _, cursor := range []string{"abc", "12", "12.", ".34", "-1.5", "1.-5", "1.2.3"} {
+		if _, _, err := parseSyncCursor(cursor); !errors.Is(err, ErrInvalidSyncCursor) {
+			t.Errorf("expected ErrInvalidSyncCursor for %q, got %v", cursor, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"MinMsgr/server/internal/protocol"
+)
+
+// ErrUrgentRateLimited is returned when a sender exceeds MaxUrgentPerWindow
+var ErrUrgentRateLimited = errors.New("too many urgent messages, try again later")
+
+const (
+	// MaxUrgentPerWindow is how many urgent messages one sender may send per UrgentWindow
+	MaxUrgentPerWindow = 5
+	UrgentWindow       = 10 * time.Minute
+)
+
+// checkUrgentRate rejects an urgent message if its sender already sent
+// MaxUrgentPerWindow urgent messages within the last UrgentWindow
+func (s *Service) checkUrgentRate(ctx context.Context, senderID int64) error {
+	count, err := s.store.CountUrgentMessagesSince(ctx, senderID, time.Now().Add(-UrgentWindow).Unix())
+	if err != nil {
+		return err
+	}
+	if count >= MaxUrgentPerWindow {
+		log.Printf("[MessageService] User %d exceeded the urgent message limit", senderID)
+		return ErrUrgentRateLimited
+	}
+	return nil
+}
+
+// notificationLevel returns userID's notification level for a chat, or the
+// default if it cannot be read
+func (s *Service) notificationLevel(ctx context.Context, chatID, userID int64) string {
+	prefs, err := s.store.GetNotificationPrefs(ctx, chatID, userID)
+	if err != nil {
+		log.Printf("[MessageService] Failed to read notification prefs of user %d for chat %d: %v", userID, chatID, err)
+		return protocol.NotifyAlways
+	}
+	if prefs == nil {
+		return protocol.NotifyAlways
+	}
+	return prefs.Level
+}
+
+// shouldNotify tells whether a new message should alert its recipient.
+// Mentions are inside the ciphertext, so for the "mentions" level only the
+// client can decide; the server only reports urgent messages there.
+func shouldNotify(level string, urgent bool) bool {
+	return urgent || level == protocol.NotifyAlways
+}
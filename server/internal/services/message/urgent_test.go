@@ -0,0 +1,28 @@
+package message
+
+import (
+	"testing"
+
+	"MinMsgr/server/internal/protocol"
+)
+
+func TestShouldNotify(t *testing.T) {
+	tests := []struct {
+		level  string
+		urgent bool
+		want   bool
+	}{
+		{protocol.NotifyAlways, false, true},
+		{protocol.NotifyMentions, false, false},
+		{protocol.NotifyNever, false, false},
+		{protocol.NotifyAlways, true, true},
+		{protocol.NotifyMentions, true, true},
+		{protocol.NotifyNever, true, true},
+	}
+
+	for _, tt := range tests {
+		if got := shouldNotify(tt.level, tt.urgent); got != tt.want {
+			t.Errorf("shouldNotify(%q, %v) = %v, want %v", tt.level, tt.urgent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// archiveColumns are the columns shared by messages and messages_archive
+const archiveColumns = "id, chat_id, sender_id, seq, ciphertext, iv, file_name, mime_type, reply_to_message_id, message_uuid, preview, preview_iv, urgent, expires_at, delivered_at, read_at, created_at, deleted_at, ratchet_header"
+
+// archivableQuery selects messages older than $1 days that can move to
+// messages_archive. Messages that rows in other tables point at (pins,
+// attachments, replies) stay, as do soft-deleted and expiring messages,
+// which are purged instead. Chats with a message count limit, their own or
+// the server-wide one ($2), are small already and are not archived, so the
+// count limit never has to look at the archive.
+const archivableQuery = `
+	SELECT m.id FROM messages m
+	JOIN chats c ON c.id = m.chat_id
+	WHERE m.created_at < EXTRACT(EPOCH FROM NOW())::BIGINT - $1::BIGINT * 86400
+		AND m.deleted_at IS NULL AND m.expires_at IS NULL
+		AND c.retention_max_messages = 0 AND $2::INT = 0
+		AND NOT EXISTS (SELECT 1 FROM chat_pins p WHERE p.chat_id = m.chat_id AND p.message_id = m.id)
+		AND NOT EXISTS (SELECT 1 FROM message_attachments a WHERE a.message_id = m.id)
+		AND NOT EXISTS (SELECT 1 FROM messages r WHERE r.chat_id = m.chat_id AND r.reply_to_message_id = m.id)`
+
+// archivedByAgeQuery applies the age limits of expiredByAgeQuery to archived
+// messages
+var archivedByAgeQuery = strings.Replace(expiredByAgeQuery, "FROM messages m", "FROM messages_archive m", 1)
+
+// messagesSource is the FROM clause of a history read: the messages table,
+// or messages and messages_archive combined under the same name
+func messagesSource(includeArchived bool) string {
+	if !includeArchived {
+		return "messages"
+	}
+	return "(SELECT " + archiveColumns + " FROM messages UNION ALL SELECT " + archiveColumns + " FROM messages_archive) messages"
+}
+
+// archiveMessages moves messages older than policy.ArchiveAfterDays to
+// messages_archive in batches of policy.BatchSize, each in its own
+// transaction, adding the number of batches run to batches. In dry-run mode
+// it only counts them.
+func (db *DB) archiveMessages(ctx context.Context, policy PurgePolicy, batches *int) (int64, error) {
+	args := []interface{}{policy.ArchiveAfterDays, policy.MaxMessagesPerChat}
+	if policy.DryRun {
+		var count int64
+		err := db.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+archivableQuery+") c", args...).Scan(&count)
+		return count, err
+	}
+
+	var total int64
+	for {
+		moved, err := db.archiveBatch(ctx, args, policy.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		*batches++
+		total += moved
+		if moved < int64(policy.BatchSize) {
+			return total, nil
+		}
+	}
+}
+
+func (db *DB) archiveBatch(ctx context.Context, args []interface{}, batchSize int) (int64, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, archivableQuery+" ORDER BY m.id LIMIT $3", append(args, batchSize)...)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	placeholders := make([]string, len(ids))
+	idArgs := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		idArgs[i] = id
+	}
+	in := "id IN (" + strings.Join(placeholders, ", ") + ")"
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO messages_archive ("+archiveColumns+") SELECT "+archiveColumns+" FROM messages WHERE "+in,
+		idArgs...,
+	); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE "+in, idArgs...); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), tx.Commit()
+}
@@ -0,0 +1,425 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupFormat is the version of the archive layout written by Backup
+const backupFormat = 1
+
+// backupTables lists the tables in an archive, parents before children so
+// that a restore never violates a foreign key. schema_migrations is not
+// exported: the header records the schema version instead.
+var backupTables = []string{
+	"users",
+	"devices",
+	"key_backups",
+	"contacts",
+	"contact_verifications",
+	"chats",
+	"dh_globals",
+	"dh_parameters",
+	"dh_public_keys",
+	"session_keys",
+	"messages",
+	"messages_archive",
+	"message_search_tokens",
+	"chat_read_markers",
+	"chat_last_seen",
+	"chat_notification_prefs",
+	"chat_pins",
+	"chat_drafts",
+	"ratchet_chains",
+	"chat_verifications",
+	"chat_key_shares",
+	"upload_sessions",
+	"files",
+	"message_attachments",
+	"file_thumbnails",
+	"blob_deletions",
+}
+
+// tablesWithoutID are the backupTables keyed by something other than a
+// serial id column
+var tablesWithoutID = map[string]bool{
+	"message_search_tokens": true,
+	"file_thumbnails":       true,
+	"blob_deletions":        true,
+}
+
+var (
+	ErrBackupFormat     = errors.New("unsupported backup format")
+	ErrRestoreNotEmpty  = errors.New("restore target database is not empty")
+	ErrRestoreSchemaNew = errors.New("restore target database has a newer schema than the backup")
+)
+
+// BackupHeader is the first record of an archive
+type BackupHeader struct {
+	Format        int    `json:"format"`
+	SchemaVersion int64  `json:"schema_version"`
+	Driver        string `json:"driver"`
+	CreatedAt     int64  `json:"created_at"`
+}
+
+// BackupStats describes an archive written by Backup or read by Restore
+type BackupStats struct {
+	Header BackupHeader
+	// Rows holds the row count of each table, in archive order
+	Rows []TableRows
+}
+
+type TableRows struct {
+	Table string
+	Rows  int64
+}
+
+// generatedColumns are computed by the database on some drivers (MySQL's
+// users.username_lower) and are left out of archives
+var generatedColumns = map[string]bool{
+	"username_lower": true,
+}
+
+// backupTable starts the rows of one table in an archive; each following
+// line is a JSON array of values in the order of Columns
+type backupTable struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+}
+
+// Backup writes a gzip-compressed logical export of every table to w. All
+// tables are read in one transaction, so the export is a consistent snapshot
+// even while the gateway keeps running.
+//
+// The archive is line-delimited JSON: a BackupHeader, then for each table a
+// backupTable line followed by one line per row. Binary values are written
+// as {"b64": "..."}, so an archive does not depend on the driver it came from.
+func (db *DB) Backup(ctx context.Context, w io.Writer) (*BackupStats, error) {
+	version, err := db.schemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite has a single connection and its transactions are already
+	// serializable; the others need a snapshot for the whole export
+	var opts *sql.TxOptions
+	if db.driver != DriverSQLite {
+		opts = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+	}
+	tx, err := db.conn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+
+	stats := &BackupStats{Header: BackupHeader{
+		Format:        backupFormat,
+		SchemaVersion: version,
+		Driver:        db.driver,
+		CreatedAt:     time.Now().Unix(),
+	}}
+	if err := enc.Encode(stats.Header); err != nil {
+		return nil, err
+	}
+
+	for _, table := range backupTables {
+		n, err := backupTableRows(ctx, tx, enc, table)
+		if err != nil {
+			return nil, fmt.Errorf("backing up %s: %w", table, err)
+		}
+		stats.Rows = append(stats.Rows, TableRows{Table: table, Rows: n})
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return stats, tx.Commit()
+}
+
+func backupTableRows(ctx context.Context, tx *sqlTx, enc *json.Encoder, table string) (int64, error) {
+	query := "SELECT * FROM " + table
+	if !tablesWithoutID[table] {
+		// Messages reply to earlier messages, so they must be restored in ID order
+		query += " ORDER BY id"
+	}
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	var keep []int
+	for i, column := range columns {
+		if !generatedColumns[column.Name()] {
+			names = append(names, column.Name())
+			keep = append(keep, i)
+		}
+	}
+	if err := enc.Encode(backupTable{Table: table, Columns: names}); err != nil {
+		return 0, err
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]interface{}, len(keep))
+
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		for i, c := range keep {
+			record[i] = encodeBackupValue(columns[c].DatabaseTypeName(), values[c])
+		}
+		if err := enc.Encode(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// encodeBackupValue converts a scanned value to its archive form. Drivers
+// differ in what they return (MySQL returns text and numbers as []byte), so
+// the column type decides how bytes are written.
+func encodeBackupValue(typeName string, v interface{}) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		if t, ok := v.(time.Time); ok {
+			return t.Unix()
+		}
+		return v
+	}
+
+	typeName = strings.ToUpper(typeName)
+	switch {
+	case strings.Contains(typeName, "BLOB"), strings.Contains(typeName, "BYTEA"), strings.Contains(typeName, "BINARY"):
+		return map[string]string{"b64": base64.StdEncoding.EncodeToString(b)}
+	case strings.Contains(typeName, "INT"):
+		if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			return n
+		}
+	}
+	return string(b)
+}
+
+// decodeBackupValue is the inverse of encodeBackupValue for a value decoded
+// with json.Decoder.UseNumber
+func decodeBackupValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		s, ok := v["b64"].(string)
+		if !ok || len(v) != 1 {
+			return nil, fmt.Errorf("%w: invalid value %v", ErrBackupFormat, v)
+		}
+		return base64.StdEncoding.DecodeString(s)
+	default:
+		return v, nil
+	}
+}
+
+// Restore rebuilds a database from an archive written by Backup. The schema
+// is migrated to the version of the archive, the rows are inserted in a
+// single transaction and then any newer migrations are applied. The target
+// must be empty, as restoring over existing data would mix the two.
+func (db *DB) Restore(ctx context.Context, r io.Reader) (*BackupStats, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackupFormat, err)
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(bufio.NewReader(zr))
+	dec.UseNumber()
+
+	stats := &BackupStats{}
+	if err := dec.Decode(&stats.Header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackupFormat, err)
+	}
+	if stats.Header.Format != backupFormat {
+		return nil, fmt.Errorf("%w: version %d", ErrBackupFormat, stats.Header.Format)
+	}
+
+	migrations, err := Migrations(db.driver)
+	if err != nil {
+		return nil, err
+	}
+	if latest := migrations[len(migrations)-1].Version; stats.Header.SchemaVersion > latest {
+		return nil, fmt.Errorf("%w: backup is at %d, this build knows up to %d", ErrUnknownMigration, stats.Header.SchemaVersion, latest)
+	}
+	version, err := db.schemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if version > stats.Header.SchemaVersion {
+		return nil, fmt.Errorf("%w: database is at %d, backup at %d", ErrRestoreSchemaNew, version, stats.Header.SchemaVersion)
+	}
+	if _, err := db.MigrateUp(ctx, stats.Header.SchemaVersion); err != nil {
+		return nil, err
+	}
+
+	var users int64
+	if err := db.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+		return nil, err
+	}
+	if users > 0 {
+		return nil, ErrRestoreNotEmpty
+	}
+
+	err = db.WithTx(ctx, func(tx *DB) error {
+		var table *restoreTable
+		for {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("%w: %v", ErrBackupFormat, err)
+			}
+
+			// A JSON object starts the next table, an array is a row
+			if raw[0] == '{' {
+				if table, err = newRestoreTable(raw); err != nil {
+					return err
+				}
+				stats.Rows = append(stats.Rows, TableRows{Table: table.name})
+				continue
+			}
+			if table == nil {
+				return fmt.Errorf("%w: row before table", ErrBackupFormat)
+			}
+			if err := tx.restoreRow(ctx, table, raw); err != nil {
+				return fmt.Errorf("restoring %s: %w", table.name, err)
+			}
+			stats.Rows[len(stats.Rows)-1].Rows++
+		}
+		return tx.resetSequences(ctx, stats.Rows)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.MigrateUp(ctx, 0); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// restoreTable is the table whose rows Restore is inserting
+type restoreTable struct {
+	name    string
+	columns int
+	query   string
+}
+
+func newRestoreTable(raw json.RawMessage) (*restoreTable, error) {
+	var header backupTable
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackupFormat, err)
+	}
+	known := false
+	for _, name := range backupTables {
+		known = known || name == header.Table
+	}
+	if !known || len(header.Columns) == 0 {
+		return nil, fmt.Errorf("%w: unexpected table %q", ErrBackupFormat, header.Table)
+	}
+
+	placeholders := make([]string, len(header.Columns))
+	for i := range header.Columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return &restoreTable{
+		name:    header.Table,
+		columns: len(header.Columns),
+		query: fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			header.Table, strings.Join(header.Columns, ", "), strings.Join(placeholders, ", ")),
+	}, nil
+}
+
+func (db *DB) restoreRow(ctx context.Context, table *restoreTable, raw json.RawMessage) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var values []interface{}
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("%w: %v", ErrBackupFormat, err)
+	}
+	if len(values) != table.columns {
+		return fmt.Errorf("%w: expected %d values, got %d", ErrBackupFormat, table.columns, len(values))
+	}
+	for i, v := range values {
+		decoded, err := decodeBackupValue(v)
+		if err != nil {
+			return err
+		}
+		values[i] = decoded
+	}
+	_, err := db.q.ExecContext(ctx, table.query, values...)
+	return err
+}
+
+// resetSequences moves the Postgres ID sequences past the restored rows,
+// which were inserted with their original IDs. SQLite and MySQL advance
+// their counters on explicit inserts. pg_get_serial_sequence is NULL for
+// upload_sessions, whose id is a string, and for messages_archive, which
+// takes its ids from messages, which makes setval a no-op there.
+func (db *DB) resetSequences(ctx context.Context, tables []TableRows) error {
+	if db.driver != DriverPostgres {
+		return nil
+	}
+	for _, t := range tables {
+		if t.Rows == 0 || tablesWithoutID[t.Table] {
+			continue
+		}
+		from := t.Table
+		if t.Table == "messages" {
+			// New messages must not reuse the id of an archived one
+			from = "(SELECT id FROM messages UNION ALL SELECT id FROM messages_archive) ids"
+		}
+		_, err := db.q.ExecContext(ctx, fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), MAX(id)) FROM %s", t.Table, from))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaVersion returns the newest applied migration, or 0 for an empty
+// database
+func (db *DB) schemaVersion(ctx context.Context) (int64, error) {
+	statuses, err := db.MigrationStatuses(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	for _, s := range statuses {
+		if s.AppliedAt != nil && s.Version > version {
+			version = s.Version
+		}
+	}
+	return version, nil
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestBackupValueRoundTrip(t *testing.T) {
+	cases := []struct {
+		typeName string
+		in       interface{}
+		want     interface{}
+	}{
+		{"BIGINT", int64(42), int64(42)},
+		{"BIGINT", []byte("42"), int64(42)}, // MySQL text protocol
+		{"VARCHAR", "alice", "alice"},
+		{"VARCHAR", []byte("alice"), "alice"},
+		{"BYTEA", []byte{0, 1, 255}, []byte{0, 1, 255}},
+		{"LONGBLOB", []byte{}, []byte{}},
+		{"BOOL", true, true},
+		{"BIGINT", nil, nil},
+	}
+	for _, c := range cases {
+		encoded, err := json.Marshal(encodeBackupValue(c.typeName, c.in))
+		if err != nil {
+			t.Fatalf("encoding %s %v failed: %v", c.typeName, c.in, err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(encoded))
+		dec.UseNumber()
+		var raw interface{}
+		if err := dec.Decode(&raw); err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeBackupValue(raw)
+		if err != nil {
+			t.Fatalf("decoding %s failed: %v", encoded, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %v: expected %#v after a round trip, got %#v", c.typeName, c.in, c.want, got)
+		}
+	}
+}
+
+func TestBackupTablesAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, table := range backupTables {
+		if seen[table] {
+			t.Errorf("table %s is backed up twice", table)
+		}
+		seen[table] = true
+	}
+	for table := range tablesWithoutID {
+		if !seen[table] {
+			t.Errorf("table %s without an id is not backed up", table)
+		}
+	}
+}
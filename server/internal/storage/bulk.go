@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bulkInsertRows is how many messages go into one multi-row INSERT, well
+// below the bind parameter limits of the drivers
+const bulkInsertRows = 500
+
+const bulkInsertColumns = "chat_id, sender_id, ciphertext, iv, file_name, mime_type, reply_to_message_id, message_uuid, seq, preview, preview_iv, urgent, expires_at, created_at, ratchet_header"
+
+// SaveMessages stores a batch of messages in one transaction with multi-row
+// INSERTs and returns their IDs in the order given. ID, Seq and Timestamp are
+// set on each message, and CreatedAt if it is zero (imports keep their own).
+// The messages of each chat are numbered in the order given.
+//
+// A message whose MessageUUID is already stored in its chat, or repeats one
+// earlier in the batch, is not inserted again and gets the ID and sequence
+// number stored for it. Attachments are not supported; use SaveMessage.
+//
+// With message sharding the chats of each shard are stored in a transaction
+// of their own, so a failed batch may have been stored in part.
+func (db *DB) SaveMessages(ctx context.Context, messages []*Message) ([]int64, error) {
+	now := time.Now().Unix()
+	byChat := make(map[int64][]*Message)
+	var chatIDs []int64
+	for _, msg := range messages {
+		if msg.CreatedAt == 0 {
+			msg.CreatedAt = now
+		}
+		if _, ok := byChat[msg.ChatID]; !ok {
+			chatIDs = append(chatIDs, msg.ChatID)
+		}
+		byChat[msg.ChatID] = append(byChat[msg.ChatID], msg)
+	}
+
+	// Taking sequence numbers locks the chat rows; locking them in ID order
+	// keeps concurrent batches from deadlocking
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+	var dbs []*DB
+	byDB := make(map[*DB][]int64)
+	for _, chatID := range chatIDs {
+		mdb, err := db.messageWriteDB(ctx, chatID)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := byDB[mdb]; !ok {
+			dbs = append(dbs, mdb)
+		}
+		byDB[mdb] = append(byDB[mdb], chatID)
+	}
+	for _, mdb := range dbs {
+		if err := mdb.saveMessageBatch(ctx, byDB[mdb], byChat); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		msg.Timestamp = msg.CreatedAt
+		ids[i] = msg.ID
+	}
+	return ids, nil
+}
+
+// saveMessageBatch stores the batch messages of chatIDs, in ID order, in one
+// transaction
+func (db *DB) saveMessageBatch(ctx context.Context, chatIDs []int64, byChat map[int64][]*Message) error {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, chatID := range chatIDs {
+		if err := db.saveChatMessages(ctx, tx, chatID, byChat[chatID]); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if db.shardID != 0 {
+		for _, chatID := range chatIDs {
+			var lastSeq, lastActivity int64
+			for _, msg := range byChat[chatID] {
+				lastSeq, lastActivity = max(lastSeq, msg.Seq), max(lastActivity, msg.CreatedAt)
+			}
+			db.shards.touchPrimaryChat(ctx, chatID, lastSeq, lastActivity)
+		}
+	}
+	return nil
+}
+
+// saveChatMessages stores the batch messages of one chat
+func (db *DB) saveChatMessages(ctx context.Context, tx *sqlTx, chatID int64, messages []*Message) error {
+	stored, err := storedMessageUUIDs(ctx, tx, chatID, messages)
+	if err != nil {
+		return err
+	}
+
+	// Only new messages take sequence numbers; repeats are resolved once the
+	// message they repeat has its ID
+	var fresh []*Message
+	repeats := make(map[*Message]*Message)
+	for _, msg := range messages {
+		if msg.MessageUUID != "" {
+			if original, ok := stored[msg.MessageUUID]; ok {
+				repeats[msg] = original
+				continue
+			}
+			stored[msg.MessageUUID] = msg
+		}
+		fresh = append(fresh, msg)
+	}
+
+	if len(fresh) > 0 {
+		if err := db.insertChatMessages(ctx, tx, chatID, fresh); err != nil {
+			return err
+		}
+	}
+	for msg, original := range repeats {
+		msg.ID, msg.Seq = original.ID, original.Seq
+	}
+	return nil
+}
+
+// storedMessageUUIDs returns the messages of the chat already stored under
+// the UUIDs of the batch, keyed by UUID
+func storedMessageUUIDs(ctx context.Context, tx *sqlTx, chatID int64, messages []*Message) (map[string]*Message, error) {
+	var uuids []interface{}
+	for _, msg := range messages {
+		if msg.MessageUUID != "" {
+			uuids = append(uuids, msg.MessageUUID)
+		}
+	}
+
+	stored := make(map[string]*Message)
+	for start := 0; start < len(uuids); start += bulkInsertRows {
+		chunk := uuids[start:min(start+bulkInsertRows, len(uuids))]
+		placeholders := make([]string, len(chunk))
+		for i := range chunk {
+			placeholders[i] = fmt.Sprintf("$%d", i+2)
+		}
+		rows, err := tx.QueryContext(ctx,
+			"SELECT message_uuid, id, seq FROM messages WHERE chat_id = $1 AND message_uuid IN ("+strings.Join(placeholders, ", ")+")",
+			append([]interface{}{chatID}, chunk...)...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			msg := &Message{ChatID: chatID}
+			if err := rows.Scan(&msg.MessageUUID, &msg.ID, &msg.Seq); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			stored[msg.MessageUUID] = msg
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+// insertChatMessages numbers and inserts new messages of one chat. The IDs
+// are read back by sequence number, which works the same on every driver and
+// does not depend on the order of RETURNING rows or on MySQL handing out
+// consecutive auto-increment values. On a message shard the IDs are taken
+// from the primary up front instead.
+func (db *DB) insertChatMessages(ctx context.Context, tx *sqlTx, chatID int64, messages []*Message) error {
+	var last int64
+	if _, err := tx.ExecContext(ctx, "UPDATE chats SET last_seq = last_seq + $2 WHERE id = $1", chatID, len(messages)); err != nil {
+		return err
+	}
+	if err := tx.QueryRowContext(ctx, lastSeqQuery, chatID).Scan(&last); err != nil {
+		return err
+	}
+	if err := db.confirmChatShard(ctx, chatID); err != nil {
+		return err
+	}
+	first := last - int64(len(messages)) + 1
+
+	columns, width := bulkInsertColumns, 15
+	if db.shardID != 0 {
+		ids, err := db.shards.nextMessageIDs(ctx, len(messages))
+		if err != nil {
+			return err
+		}
+		for i, msg := range messages {
+			msg.ID = ids[i]
+		}
+		columns, width = "id, "+bulkInsertColumns, 16
+	}
+
+	bySeq := make(map[int64]*Message, len(messages))
+	var lastActivity int64
+	for i, msg := range messages {
+		msg.Seq = first + int64(i)
+		bySeq[msg.Seq] = msg
+		lastActivity = max(lastActivity, msg.CreatedAt)
+	}
+
+	for start := 0; start < len(messages); start += bulkInsertRows {
+		chunk := messages[start:min(start+bulkInsertRows, len(messages))]
+		args := make([]interface{}, 0, len(chunk)*width)
+		values := make([]string, len(chunk))
+		for i, msg := range chunk {
+			var uuid sql.NullString
+			if msg.MessageUUID != "" {
+				uuid = sql.NullString{String: msg.MessageUUID, Valid: true}
+			}
+			values[i] = bulkValues(len(args), width)
+			if db.shardID != 0 {
+				args = append(args, msg.ID)
+			}
+			args = append(args,
+				chatID, msg.SenderID, msg.Ciphertext, msg.IV, msg.FileName, msg.MimeType, msg.ReplyToMessageID, uuid,
+				msg.Seq, msg.Preview, msg.PreviewIV, msg.Urgent, msg.ExpiresAt, msg.CreatedAt, msg.RatchetHeader,
+			)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO messages ("+columns+") VALUES "+strings.Join(values, ", "), args...); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, seq FROM messages WHERE chat_id = $1 AND seq >= $2 AND seq <= $3", chatID, first, last)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, seq int64
+		if err := rows.Scan(&id, &seq); err != nil {
+			return err
+		}
+		if msg := bySeq[seq]; msg != nil {
+			msg.ID = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, touchChatActivityQuery, lastActivity, chatID)
+	return err
+}
+
+// bulkValues returns the "($n, ...)" tuple of one row of a multi-row INSERT
+// whose arguments start after offset
+func bulkValues(offset, columns int) string {
+	placeholders := make([]string, columns)
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", offset+i+1)
+	}
+	return "(" + strings.Join(placeholders, ", ") + ")"
+}
@@ -0,0 +1,12 @@
+package storage
+
+import "testing"
+
+func TestBulkValues(t *testing.T) {
+	if got := bulkValues(0, 3); got != "($1, $2, $3)" {
+		t.Fatalf("expected ($1, $2, $3), got %s", got)
+	}
+	if got := bulkValues(28, 2); got != "($29, $30)" {
+		t.Fatalf("expected ($29, $30), got %s", got)
+	}
+}
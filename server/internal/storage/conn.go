@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Supported values of Config.Driver
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMySQL    = "mysql"
+)
+
+// rebindFunc rewrites a query written in Postgres syntax, and its arguments,
+// for the backend in use
+type rebindFunc func(query string, args []interface{}) (string, []interface{})
+
+// sqlConn wraps the connection pool so that every query, which is written in
+// Postgres syntax, is rewritten for the backend in use before it runs. Only
+// the context-aware methods are wrapped; storage code must not use the plain
+// Exec/Query/QueryRow/Begin promoted from *sql.DB.
+type sqlConn struct {
+	*sql.DB
+	rebind rebindFunc
+	// stmts holds the prepared hot queries (see hotQueries) by their Postgres
+	// text. It is filled once at startup and only read afterwards.
+	stmts map[string]*sql.Stmt
+	stats *queryStats
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer c.stats.observe(query, args, time.Now())
+	stmt := c.stmts[query]
+	query, args = c.rebind(query, args)
+	if stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+	return c.DB.ExecContext(ctx, query, args...)
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer c.stats.observe(query, args, time.Now())
+	stmt := c.stmts[query]
+	query, args = c.rebind(query, args)
+	if stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return c.DB.QueryContext(ctx, query, args...)
+}
+
+func (c *sqlConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer c.stats.observe(query, args, time.Now())
+	stmt := c.stmts[query]
+	query, args = c.rebind(query, args)
+	if stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return c.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (c *sqlConn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sqlTx, error) {
+	tx, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, rebind: c.rebind, stmts: c.stmts, stats: c.stats}, nil
+}
+
+// sqlTx is the transaction counterpart of sqlConn. A nested sqlTx shares the
+// transaction of an enclosing WithTx, which alone commits or rolls it back.
+type sqlTx struct {
+	*sql.Tx
+	rebind rebindFunc
+	stmts  map[string]*sql.Stmt
+	stats  *queryStats
+	nested bool
+}
+
+func (tx *sqlTx) Commit() error {
+	if tx.nested {
+		return nil
+	}
+	return tx.Tx.Commit()
+}
+
+func (tx *sqlTx) Rollback() error {
+	if tx.nested {
+		return nil
+	}
+	return tx.Tx.Rollback()
+}
+
+// Prepared statements are bound to the transaction with StmtContext, which
+// closes them again on commit or rollback
+
+func (tx *sqlTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer tx.stats.observe(query, args, time.Now())
+	stmt := tx.stmts[query]
+	query, args = tx.rebind(query, args)
+	if stmt != nil {
+		return tx.Tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+	}
+	return tx.Tx.ExecContext(ctx, query, args...)
+}
+
+func (tx *sqlTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer tx.stats.observe(query, args, time.Now())
+	stmt := tx.stmts[query]
+	query, args = tx.rebind(query, args)
+	if stmt != nil {
+		return tx.Tx.StmtContext(ctx, stmt).QueryContext(ctx, args...)
+	}
+	return tx.Tx.QueryContext(ctx, query, args...)
+}
+
+func (tx *sqlTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer tx.stats.observe(query, args, time.Now())
+	stmt := tx.stmts[query]
+	query, args = tx.rebind(query, args)
+	if stmt != nil {
+		return tx.Tx.StmtContext(ctx, stmt).QueryRowContext(ctx, args...)
+	}
+	return tx.Tx.QueryRowContext(ctx, query, args...)
+}
+
+// querier is implemented by both sqlConn and sqlTx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var (
+	// Type casts such as $1::INT or (...)::BIGINT, which the other backends
+	// do not need
+	pgCastRE        = regexp.MustCompile(`::(BIGINT|INT)\b`)
+	pgPlaceholderRE = regexp.MustCompile(`\$(\d+)`)
+)
+
+// noRebind leaves Postgres queries unchanged
+func noRebind(query string, args []interface{}) (string, []interface{}) {
+	return query, args
+}
+
+// WithTx runs fn in a single transaction, which is committed if fn returns
+// nil and rolled back otherwise. fn must do all of its work through the DB it
+// is given: storage methods called on it run inside the transaction, including
+// ones that use a transaction of their own. Calling WithTx on a transactional
+// DB just runs fn in the enclosing transaction.
+func (db *DB) WithTx(ctx context.Context, fn func(tx *DB) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hooks []func()
+	if err := fn(&DB{conn: db.conn, q: tx, tx: tx, driver: db.driver, shards: db.shards, commitHooks: &hooks}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		hook()
+	}
+	return nil
+}
+
+// afterCommit runs fn once the enclosing WithTx transaction has committed,
+// or right away outside of one. It is for work on the message shards, which
+// cannot join a transaction of the primary.
+func (db *DB) afterCommit(fn func()) {
+	if db.commitHooks != nil {
+		*db.commitHooks = append(*db.commitHooks, fn)
+		return
+	}
+	fn()
+}
+
+// begin starts the transaction of a multi-statement storage method, or joins
+// the enclosing WithTx transaction
+func (db *DB) begin(ctx context.Context) (*sqlTx, error) {
+	if db.tx != nil {
+		return &sqlTx{Tx: db.tx.Tx, rebind: db.tx.rebind, stmts: db.tx.stmts, stats: db.tx.stats, nested: true}, nil
+	}
+	return db.conn.BeginTx(ctx, nil)
+}
+
+// insertID runs an INSERT and returns the ID of the new row. inserted is
+// false when an ON CONFLICT DO NOTHING clause skipped the row. MySQL has no
+// INSERT ... RETURNING, so the driver-reported last insert ID is used there.
+func (db *DB) insertID(ctx context.Context, q querier, query string, args ...interface{}) (id int64, inserted bool, err error) {
+	if db.driver == DriverMySQL {
+		result, err := q.ExecContext(ctx, db.insertQuery(query), args...)
+		if err != nil {
+			return 0, false, err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return 0, false, err
+		}
+		id, err = result.LastInsertId()
+		return id, err == nil, err
+	}
+
+	err = q.QueryRowContext(ctx, db.insertQuery(query), args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// insertQuery returns the form of an INSERT that insertID runs
+func (db *DB) insertQuery(query string) string {
+	if db.driver == DriverMySQL {
+		return query
+	}
+	return query + " RETURNING id"
+}
+
+// updateMessages runs "UPDATE messages SET set WHERE where" and returns the
+// IDs of the updated rows. On MySQL, which has no UPDATE ... RETURNING, the
+// rows are locked and listed first and then updated by ID.
+func (db *DB) updateMessages(ctx context.Context, set, where string, args ...interface{}) ([]int64, error) {
+	if db.driver != DriverMySQL {
+		rows, err := db.q.QueryContext(ctx, "UPDATE messages SET "+set+" WHERE "+where+" RETURNING id", args...)
+		if err != nil {
+			return nil, err
+		}
+		return scanIDs(rows)
+	}
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM messages WHERE "+where+" FOR UPDATE", args...)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil || len(ids) == 0 {
+		return ids, err
+	}
+
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", len(args)+i+1)
+		args = append(args, id)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE messages SET "+set+" WHERE id IN ("+strings.Join(placeholders, ", ")+")", args...); err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Device operations. Each device of a user has its own X25519 key pair;
+// chat key material reaches a user's other devices, and the devices of the
+// other participant, as key shares sealed to a device's public key.
+
+// Device is a device a user registered. RevokedAt is 0 while the device is
+// active.
+type Device struct {
+	ID                  int64  `json:"id"`
+	UserID              int64  `json:"user_id"`
+	Name                string `json:"name"`
+	PublicKey           []byte `json:"public_key"`
+	EncryptedPrivateKey []byte `json:"-"`
+	CreatedAt           int64  `json:"created_at"`
+	LastSeenAt          int64  `json:"last_seen_at"`
+	RevokedAt           int64  `json:"revoked_at,omitempty"`
+}
+
+// KeyShare is chat key material one device sealed for another
+type KeyShare struct {
+	ChatID            int64  `json:"chat_id"`
+	SenderDeviceID    int64  `json:"sender_device_id"`
+	RecipientDeviceID int64  `json:"recipient_device_id"`
+	Share             []byte `json:"share"`
+	CreatedAt         int64  `json:"created_at"`
+}
+
+const deviceColumns = "id, user_id, name, public_key, encrypted_private_key, created_at, last_seen_at, COALESCE(revoked_at, 0)"
+
+// CreateDevice registers a device of a user and returns its ID
+func (db *DB) CreateDevice(ctx context.Context, userID int64, name string, publicKey, encryptedPrivateKey []byte) (int64, error) {
+	now := time.Now().Unix()
+	id, _, err := db.insertID(ctx, db.q,
+		"INSERT INTO devices (user_id, name, public_key, encrypted_private_key, created_at, last_seen_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		userID, name, publicKey, encryptedPrivateKey, now, now,
+	)
+	return id, err
+}
+
+// GetDevice retrieves a device by ID, revoked or not, or nil if there is no
+// such device
+func (db *DB) GetDevice(ctx context.Context, deviceID int64) (*Device, error) {
+	d := &Device{}
+	err := db.q.QueryRowContext(ctx,
+		"SELECT "+deviceColumns+" FROM devices WHERE id = $1",
+		deviceID,
+	).Scan(&d.ID, &d.UserID, &d.Name, &d.PublicKey, &d.EncryptedPrivateKey, &d.CreatedAt, &d.LastSeenAt, &d.RevokedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListDevices returns the active devices of a user, oldest first
+func (db *DB) ListDevices(ctx context.Context, userID int64) ([]*Device, error) {
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT "+deviceColumns+" FROM devices WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []*Device
+	for rows.Next() {
+		d := &Device{}
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Name, &d.PublicKey, &d.EncryptedPrivateKey, &d.CreatedAt, &d.LastSeenAt, &d.RevokedAt); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// TouchDevice records that an active device was just used
+func (db *DB) TouchDevice(ctx context.Context, deviceID int64) error {
+	_, err := db.q.ExecContext(ctx,
+		"UPDATE devices SET last_seen_at = $1 WHERE id = $2 AND revoked_at IS NULL",
+		time.Now().Unix(), deviceID,
+	)
+	return err
+}
+
+// RevokeDevice revokes an active device of a user, dropping its wrapped
+// private key and the key shares sealed to it. Returns false if the user has
+// no such active device.
+func (db *DB) RevokeDevice(ctx context.Context, userID, deviceID int64) (bool, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE devices SET revoked_at = $1, encrypted_private_key = NULL WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL",
+		time.Now().Unix(), deviceID, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM chat_key_shares WHERE recipient_device_id = $1", deviceID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// SaveKeyShare records or replaces the key share of a chat for a device
+func (db *DB) SaveKeyShare(ctx context.Context, chatID, senderDeviceID, recipientDeviceID int64, share []byte) error {
+	_, err := db.q.ExecContext(ctx,
+		`INSERT INTO chat_key_shares (chat_id, sender_device_id, recipient_device_id, share, created_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (chat_id, recipient_device_id) DO UPDATE SET sender_device_id = $2, share = $4, created_at = $5`,
+		chatID, senderDeviceID, recipientDeviceID, share, time.Now().Unix(),
+	)
+	return err
+}
+
+// GetKeyShare returns the latest key share of a chat for a device, or nil if
+// none was sealed for it
+func (db *DB) GetKeyShare(ctx context.Context, chatID, recipientDeviceID int64) (*KeyShare, error) {
+	ks := &KeyShare{}
+	err := db.q.QueryRowContext(ctx,
+		"SELECT chat_id, sender_device_id, recipient_device_id, share, created_at FROM chat_key_shares WHERE chat_id = $1 AND recipient_device_id = $2",
+		chatID, recipientDeviceID,
+	).Scan(&ks.ChatID, &ks.SenderDeviceID, &ks.RecipientDeviceID, &ks.Share, &ks.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
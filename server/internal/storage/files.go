@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Upload session operations
+
+// CreateUploadSession starts a new resumable upload
+func (db *DB) CreateUploadSession(ctx context.Context, session *UploadSession) error {
+	session.CreatedAt = time.Now().Unix()
+	session.UpdatedAt = session.CreatedAt
+	_, err := db.q.ExecContext(ctx,
+		`INSERT INTO upload_sessions (id, user_id, chat_id, file_name, mime_type, total_size, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		session.ID, session.UserID, session.ChatID, session.FileName, session.MimeType, session.TotalSize, session.CreatedAt, session.UpdatedAt,
+	)
+	return err
+}
+
+// GetUploadSession retrieves an upload session by ID
+func (db *DB) GetUploadSession(ctx context.Context, sessionID string) (*UploadSession, error) {
+	session := &UploadSession{}
+	err := db.q.QueryRowContext(ctx,
+		"SELECT id, user_id, chat_id, file_name, mime_type, total_size, received_size, chunk_count, created_at, updated_at FROM upload_sessions WHERE id = $1",
+		sessionID,
+	).Scan(&session.ID, &session.UserID, &session.ChatID, &session.FileName, &session.MimeType,
+		&session.TotalSize, &session.ReceivedSize, &session.ChunkCount, &session.CreatedAt, &session.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return session, err
+}
+
+// AdvanceUploadSession records a stored chunk of chunkSize bytes that was
+// written at offset. It only succeeds if offset still matches the received
+// size, so concurrent or replayed chunks cannot corrupt the session.
+func (db *DB) AdvanceUploadSession(ctx context.Context, sessionID string, offset, chunkSize int64) (bool, error) {
+	result, err := db.q.ExecContext(ctx,
+		`UPDATE upload_sessions SET received_size = received_size + $3, chunk_count = chunk_count + 1, updated_at = $4
+		WHERE id = $1 AND received_size = $2`,
+		sessionID, offset, chunkSize, time.Now().Unix(),
+	)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// DeleteUploadSession removes an upload session
+func (db *DB) DeleteUploadSession(ctx context.Context, sessionID string) error {
+	_, err := db.q.ExecContext(ctx, "DELETE FROM upload_sessions WHERE id = $1", sessionID)
+	return err
+}
+
+// ListStaleUploadSessions lists upload sessions that have not received data since before the given time
+func (db *DB) ListStaleUploadSessions(ctx context.Context, before int64) ([]*UploadSession, error) {
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT id, user_id, chat_id, file_name, mime_type, total_size, received_size, chunk_count, created_at, updated_at FROM upload_sessions WHERE updated_at < $1",
+		before,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*UploadSession
+	for rows.Next() {
+		session := &UploadSession{}
+		err := rows.Scan(&session.ID, &session.UserID, &session.ChatID, &session.FileName, &session.MimeType,
+			&session.TotalSize, &session.ReceivedSize, &session.ChunkCount, &session.CreatedAt, &session.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// File operations
+
+// CompleteUpload turns a fully received upload session into a file record
+func (db *DB) CompleteUpload(ctx context.Context, sessionID string, file *File) error {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	file.CreatedAt = time.Now().Unix()
+	file.ID, _, err = db.insertID(ctx, tx,
+		"INSERT INTO files (owner_id, chat_id, blob_key, file_name, mime_type, size, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		file.OwnerID, file.ChatID, file.BlobKey, file.FileName, file.MimeType, file.Size, file.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM upload_sessions WHERE id = $1", sessionID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetFile retrieves a file record by ID
+func (db *DB) GetFile(ctx context.Context, fileID int64) (*File, error) {
+	file := &File{}
+	err := db.q.QueryRowContext(ctx,
+		`SELECT f.id, f.owner_id, f.chat_id, f.blob_key, f.file_name, f.mime_type, f.size, f.created_at,
+		COALESCE(t.data, ''::bytea), COALESCE(t.width, 0), COALESCE(t.height, 0)
+		FROM files f LEFT JOIN file_thumbnails t ON t.file_id = f.id WHERE f.id = $1`,
+		fileID,
+	).Scan(&file.ID, &file.OwnerID, &file.ChatID, &file.BlobKey, &file.FileName, &file.MimeType, &file.Size, &file.CreatedAt,
+		&file.Thumbnail, &file.ThumbnailWidth, &file.ThumbnailHeight)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return file, err
+}
+
+// SaveFileThumbnail creates or replaces the encrypted thumbnail of a file
+func (db *DB) SaveFileThumbnail(ctx context.Context, fileID int64, data []byte, width, height int) error {
+	_, err := db.q.ExecContext(ctx,
+		`INSERT INTO file_thumbnails (file_id, data, width, height) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (file_id) DO UPDATE SET data = $2, width = $3, height = $4, created_at = EXTRACT(EPOCH FROM NOW())::BIGINT`,
+		fileID, data, width, height,
+	)
+	return err
+}
+
+// GetMessageAttachments returns the files attached to each of the given messages
+func (db *DB) GetMessageAttachments(ctx context.Context, messageIDs []int64) (map[int64][]*File, error) {
+	attachments := make(map[int64][]*File)
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+
+	placeholders := make([]string, len(messageIDs))
+	args := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = id
+	}
+	if len(db.messageDBs()) > 1 {
+		return db.getShardedMessageAttachments(ctx, strings.Join(placeholders, ", "), args)
+	}
+
+	rows, err := db.q.QueryContext(ctx,
+		`SELECT ma.message_id, f.id, f.owner_id, f.chat_id, f.blob_key, f.file_name, f.mime_type, f.size, f.created_at,
+		COALESCE(t.data, ''::bytea), COALESCE(t.width, 0), COALESCE(t.height, 0)
+		FROM message_attachments ma JOIN files f ON f.id = ma.file_id LEFT JOIN file_thumbnails t ON t.file_id = f.id
+		WHERE ma.message_id IN (`+strings.Join(placeholders, ", ")+`) ORDER BY ma.id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int64
+		file := &File{}
+		err := rows.Scan(&messageID, &file.ID, &file.OwnerID, &file.ChatID, &file.BlobKey, &file.FileName, &file.MimeType, &file.Size, &file.CreatedAt,
+			&file.Thumbnail, &file.ThumbnailWidth, &file.ThumbnailHeight)
+		if err != nil {
+			return nil, err
+		}
+		attachments[messageID] = append(attachments[messageID], file)
+	}
+
+	return attachments, rows.Err()
+}
+
+// getShardedMessageAttachments is GetMessageAttachments with message sharding:
+// the attachments are read from every database holding messages and their
+// files from the primary. in is the placeholder list of the message IDs.
+func (db *DB) getShardedMessageAttachments(ctx context.Context, in string, args []interface{}) (map[int64][]*File, error) {
+	type link struct{ messageID, fileID int64 }
+	var links []link
+	var fileArgs []interface{}
+	var filePlaceholders []string
+	for _, mdb := range db.messageDBs() {
+		rows, err := mdb.q.QueryContext(ctx,
+			"SELECT message_id, file_id FROM message_attachments WHERE message_id IN ("+in+") ORDER BY id",
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var l link
+			if err := rows.Scan(&l.messageID, &l.fileID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			links = append(links, l)
+			fileArgs = append(fileArgs, l.fileID)
+			filePlaceholders = append(filePlaceholders, fmt.Sprintf("$%d", len(fileArgs)))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	attachments := make(map[int64][]*File)
+	if len(links) == 0 {
+		return attachments, nil
+	}
+	rows, err := db.q.QueryContext(ctx,
+		`SELECT f.id, f.owner_id, f.chat_id, f.blob_key, f.file_name, f.mime_type, f.size, f.created_at,
+		COALESCE(t.data, ''::bytea), COALESCE(t.width, 0), COALESCE(t.height, 0)
+		FROM files f LEFT JOIN file_thumbnails t ON t.file_id = f.id
+		WHERE f.id IN (`+strings.Join(filePlaceholders, ", ")+`)`,
+		fileArgs...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[int64]File)
+	for rows.Next() {
+		file := File{}
+		err := rows.Scan(&file.ID, &file.OwnerID, &file.ChatID, &file.BlobKey, &file.FileName, &file.MimeType, &file.Size, &file.CreatedAt,
+			&file.Thumbnail, &file.ThumbnailWidth, &file.ThumbnailHeight)
+		if err != nil {
+			return nil, err
+		}
+		files[file.ID] = file
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, l := range links {
+		if file, ok := files[l.fileID]; ok {
+			attachments[l.messageID] = append(attachments[l.messageID], &file)
+		}
+	}
+	return attachments, nil
+}
+
+// Blob deletion queue operations
+
+// QueueBlobDeletions queues blob keys for deletion by the file janitor
+func (db *DB) QueueBlobDeletions(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if _, err := db.q.ExecContext(ctx,
+			"INSERT INTO blob_deletions (blob_key) VALUES ($1) ON CONFLICT DO NOTHING",
+			key,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueUnattachedFiles removes file records that were uploaded before the
+// given time but are not attached to any message (never sent, or their
+// messages were deleted) and queues their blobs for deletion
+func (db *DB) QueueUnattachedFiles(ctx context.Context, before int64) (int64, error) {
+	if len(db.messageDBs()) > 1 {
+		return db.queueUnattachedShardedFiles(ctx, before)
+	}
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO blob_deletions (blob_key) SELECT blob_key FROM files WHERE "+unattachedFilesCondition+" ON CONFLICT DO NOTHING",
+		before,
+	); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM files WHERE "+unattachedFilesCondition, before)
+	if err != nil {
+		return 0, err
+	}
+	deleted, _ := result.RowsAffected()
+
+	return deleted, tx.Commit()
+}
+
+// unattachedFilesCondition matches the files uploaded before $1 that no
+// message on this database is attached to
+const unattachedFilesCondition = `created_at < $1 AND NOT EXISTS (SELECT 1 FROM message_attachments ma WHERE ma.file_id = files.id)`
+
+// queueUnattachedShardedFiles is QueueUnattachedFiles with message sharding.
+// The candidates are checked against the attachments on every shard first;
+// as files are only attached soon after their upload, a candidate old enough
+// to be removed is not attached in the meantime.
+func (db *DB) queueUnattachedShardedFiles(ctx context.Context, before int64) (int64, error) {
+	rows, err := db.q.QueryContext(ctx, "SELECT id FROM files WHERE "+unattachedFilesCondition+" ORDER BY id", before)
+	if err != nil {
+		return 0, err
+	}
+	candidates, err := scanIDs(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	attached := make(map[int64]bool)
+	for _, shard := range db.messageDBs()[1:] {
+		for start := 0; start < len(candidates); start += bulkInsertRows {
+			in, args := idList(candidates[start:min(start+bulkInsertRows, len(candidates))], 1)
+			rows, err := shard.q.QueryContext(ctx, "SELECT DISTINCT file_id FROM message_attachments WHERE file_id IN ("+in+")", args...)
+			if err != nil {
+				return 0, fmt.Errorf("message shard %d: %w", shard.shardID, err)
+			}
+			ids, err := scanIDs(rows)
+			if err != nil {
+				return 0, fmt.Errorf("message shard %d: %w", shard.shardID, err)
+			}
+			for _, id := range ids {
+				attached[id] = true
+			}
+		}
+	}
+	var unattached []int64
+	for _, id := range candidates {
+		if !attached[id] {
+			unattached = append(unattached, id)
+		}
+	}
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var deleted int64
+	for start := 0; start < len(unattached); start += bulkInsertRows {
+		in, args := idList(unattached[start:min(start+bulkInsertRows, len(unattached))], 2)
+		args = append([]interface{}{before}, args...)
+		cond := unattachedFilesCondition + " AND id IN (" + in + ")"
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO blob_deletions (blob_key) SELECT blob_key FROM files WHERE "+cond+" ON CONFLICT DO NOTHING",
+			args...,
+		); err != nil {
+			return 0, err
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM files WHERE "+cond, args...)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+
+	return deleted, tx.Commit()
+}
+
+// idList returns the placeholders, numbered from first, and the arguments for
+// an IN list of ids
+func idList(ids []int64, first int) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", first+i)
+		args[i] = id
+	}
+	return strings.Join(placeholders, ", "), args
+}
+
+// ListBlobDeletions returns up to limit queued blob keys
+func (db *DB) ListBlobDeletions(ctx context.Context, limit int) ([]string, error) {
+	rows, err := db.q.QueryContext(ctx, "SELECT blob_key FROM blob_deletions ORDER BY queued_at LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RemoveBlobDeletion removes a key from the deletion queue once its blob is gone
+func (db *DB) RemoveBlobDeletion(ctx context.Context, key string) error {
+	_, err := db.q.ExecContext(ctx, "DELETE FROM blob_deletions WHERE blob_key = $1", key)
+	return err
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the query latency histograms
+var LatencyBuckets = []time.Duration{
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// MethodLatency is the latency histogram of the queries run by one DB method
+type MethodLatency struct {
+	Method string
+	// Buckets holds the cumulative count of queries at or below each of
+	// LatencyBuckets
+	Buckets []uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+// queryStats records the latency of every query run through sqlConn and
+// sqlTx, attributed to the DB method that ran it, and logs slow queries
+type queryStats struct {
+	// slowThreshold is the duration above which a query is logged; 0 turns
+	// slow-query logging off
+	slowThreshold time.Duration
+
+	mu      sync.Mutex
+	methods map[string]*MethodLatency
+}
+
+func newQueryStats(slowThreshold time.Duration) *queryStats {
+	return &queryStats{slowThreshold: slowThreshold, methods: make(map[string]*MethodLatency)}
+}
+
+// observe records a query that started at start. query is the Postgres text
+// as written in this package, so log lines do not depend on the driver.
+func (s *queryStats) observe(query string, args []interface{}, start time.Time) {
+	if s == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	method := callerMethod()
+
+	s.mu.Lock()
+	m := s.methods[method]
+	if m == nil {
+		m = &MethodLatency{Method: method, Buckets: make([]uint64, len(LatencyBuckets))}
+		s.methods[method] = m
+	}
+	for i, bound := range LatencyBuckets {
+		if elapsed <= bound {
+			m.Buckets[i]++
+		}
+	}
+	m.Count++
+	m.Sum += elapsed
+	s.mu.Unlock()
+
+	if s.slowThreshold > 0 && elapsed > s.slowThreshold {
+		log.Printf("[Storage] Slow query in %s took %v: %s args=%s",
+			method, elapsed.Round(time.Microsecond), strings.Join(strings.Fields(query), " "), redactArgs(args))
+	}
+}
+
+// snapshot returns a copy of the histograms ordered by method
+func (s *queryStats) snapshot() []MethodLatency {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	latencies := make([]MethodLatency, 0, len(s.methods))
+	for _, m := range s.methods {
+		c := *m
+		c.Buckets = append([]uint64(nil), m.Buckets...)
+		latencies = append(latencies, c)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i].Method < latencies[j].Method })
+	return latencies
+}
+
+// QueryLatencies returns the query latency histogram of each DB method that
+// has run a query, including queries sent to read replicas
+func (db *DB) QueryLatencies() []MethodLatency {
+	return db.conn.stats.snapshot()
+}
+
+// callerMethod returns the name of the DB method that ran the current
+// query. Helpers such as insertID are DB methods too, so it is the outermost
+// one before the stack leaves this package. Symbolizing the stack on every
+// query is slow, so each PC is resolved once and cached in callerPCs.
+func callerMethod() string {
+	var pcs [16]uintptr
+	n := runtime.Callers(3, pcs[:])
+
+	method := "unknown"
+	for _, pc := range pcs[:n] {
+		f := lookupCallerPC(pc)
+		if f.method != "" {
+			method = f.method
+		}
+		if f.leaves {
+			break
+		}
+	}
+	return method
+}
+
+// callerPC is what one PC of a query's stack says about its caller: the DB
+// method it is in, if any, and whether it is outside this package. A PC
+// stands for several frames when calls were inlined into it.
+type callerPC struct {
+	method string
+	leaves bool
+}
+
+// callerPCs caches callerPC by PC
+var callerPCs sync.Map
+
+func lookupCallerPC(pc uintptr) callerPC {
+	if f, ok := callerPCs.Load(pc); ok {
+		return f.(callerPC)
+	}
+
+	var f callerPC
+	frames := runtime.CallersFrames([]uintptr{pc})
+	for {
+		frame, more := frames.Next()
+		if !strings.Contains(frame.Function, "/storage.") {
+			f.leaves = true
+			break
+		}
+		if i := strings.Index(frame.Function, "/storage.(*DB)."); i >= 0 {
+			f.method = frame.Function[i+len("/storage.(*DB)."):]
+		}
+		if !more {
+			break
+		}
+	}
+	// Closures within a method are named Method.func1
+	if i := strings.Index(f.method, "."); i >= 0 {
+		f.method = f.method[:i]
+	}
+	callerPCs.Store(pc, f)
+	return f
+}
+
+// redactArgs describes query arguments by type and size only: they include
+// password hashes, keys and ciphertext that must not end up in logs
+func redactArgs(args []interface{}) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+			parts[i] = "NULL"
+		case []byte:
+			parts[i] = fmt.Sprintf("bytes(%d)", len(v))
+		case string:
+			parts[i] = fmt.Sprintf("string(%d)", len(v))
+		default:
+			parts[i] = fmt.Sprintf("%T", arg)
+		}
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// observeForTest stands in for a storage method running a query
+func (db *DB) observeForTest(stats *queryStats, start time.Time) {
+	stats.observe("SELECT 1", nil, start)
+}
+
+func TestQueryStatsAttributesToMethod(t *testing.T) {
+	stats := newQueryStats(0)
+	db := &DB{}
+	db.observeForTest(stats, time.Now())
+	db.observeForTest(stats, time.Now().Add(-3*time.Millisecond))
+
+	latencies := stats.snapshot()
+	if len(latencies) != 1 || latencies[0].Method != "observeForTest" {
+		t.Fatalf("expected one histogram for observeForTest, got %+v", latencies)
+	}
+	m := latencies[0]
+	if m.Count != 2 || m.Sum < 3*time.Millisecond {
+		t.Fatalf("expected 2 queries taking at least 3ms, got %d taking %v", m.Count, m.Sum)
+	}
+	// Buckets are cumulative: both queries are within 5s, the 3ms one is
+	// above 2.5ms
+	last := len(LatencyBuckets) - 1
+	if m.Buckets[last] != 2 {
+		t.Errorf("expected both queries in the %v bucket, got %d", LatencyBuckets[last], m.Buckets[last])
+	}
+	if m.Buckets[2] > 1 {
+		t.Errorf("expected at most the fast query in the %v bucket, got %d", LatencyBuckets[2], m.Buckets[2])
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	got := redactArgs([]interface{}{int64(7), "hunter2", []byte{1, 2, 3}, nil, true})
+	want := "[int64, string(7), bytes(3), NULL, bool]"
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func BenchmarkQueryStatsObserve(b *testing.B) {
+	stats := newQueryStats(0)
+	db := &DB{}
+	start := time.Now()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		db.observeForTest(stats, start)
+	}
+}
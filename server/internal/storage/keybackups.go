@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// KeyBackup is a user's key bundle sealed under their backup passphrase.
+// KDF is the encoded Argon2id parameters from the sealed header.
+type KeyBackup struct {
+	UserID    int64  `json:"user_id"`
+	Backup    []byte `json:"backup"`
+	KDF       string `json:"kdf"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// SaveKeyBackup stores or replaces the key backup of a user
+func (db *DB) SaveKeyBackup(ctx context.Context, userID int64, backup []byte, kdf string) error {
+	now := time.Now().Unix()
+	_, err := db.q.ExecContext(ctx,
+		`INSERT INTO key_backups (user_id, backup, kdf, created_at, updated_at) VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (user_id) DO UPDATE SET backup = $2, kdf = $3, updated_at = $4`,
+		userID, backup, kdf, now,
+	)
+	return err
+}
+
+// GetKeyBackup returns the key backup of a user, or nil if they have none
+func (db *DB) GetKeyBackup(ctx context.Context, userID int64) (*KeyBackup, error) {
+	b := &KeyBackup{}
+	err := db.q.QueryRowContext(ctx,
+		"SELECT user_id, backup, kdf, created_at, updated_at FROM key_backups WHERE user_id = $1",
+		userID,
+	).Scan(&b.UserID, &b.Backup, &b.KDF, &b.CreatedAt, &b.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// DeleteKeyBackup removes the key backup of a user. Returns false if they had
+// none.
+func (db *DB) DeleteKeyBackup(ctx context.Context, userID int64) (bool, error) {
+	result, err := db.q.ExecContext(ctx, "DELETE FROM key_backups WHERE user_id = $1", userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Each driver has its own migration set under migrations/<driver>; both sets
+// must have the same versions
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the advisory lock key held while migrations run, so
+// that gateways booting at the same time do not migrate concurrently
+const migrationLockID = 7316001
+
+// ErrUnknownMigration is returned when the database has a migration applied
+// that this build does not know about (e.g. after a downgrade)
+var ErrUnknownMigration = errors.New("database has an unknown migration applied")
+
+// migrationFileRE matches "0001_name.up.sql" and "0001_name.down.sql"
+var migrationFileRE = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a known migration and when it was applied, if at all
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *int64
+}
+
+// Migrations returns the embedded migrations of the driver ordered by version
+func Migrations(driver string) ([]*Migration, error) {
+	return loadMigrations(migrationFiles, "migrations/"+driver)
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		m := migrationFileRE.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", entry.Name())
+		}
+		body, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp applies every pending migration up to and including target (0
+// for the latest) and returns the ones it applied. Each migration runs in
+// its own transaction together with its schema_migrations row.
+func (db *DB) MigrateUp(ctx context.Context, target int64) ([]*Migration, error) {
+	migrations, err := Migrations(db.driver)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []*Migration
+	err = db.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkKnownVersions(done, migrations); err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if target > 0 && m.Version > target {
+				break
+			}
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			query, args := db.conn.rebind(
+				"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+				[]interface{}{m.Version, m.Name, time.Now().Unix()},
+			)
+			if err := runMigration(ctx, conn, m, m.Up, query, args...); err != nil {
+				return err
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown reverts the steps most recently applied migrations and returns
+// the ones it reverted, newest first
+func (db *DB) MigrateDown(ctx context.Context, steps int) ([]*Migration, error) {
+	migrations, err := Migrations(db.driver)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []*Migration
+	err = db.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkKnownVersions(done, migrations); err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			m := migrations[i]
+			if _, ok := done[m.Version]; !ok {
+				continue
+			}
+			query, args := db.conn.rebind("DELETE FROM schema_migrations WHERE version = $1", []interface{}{m.Version})
+			if err := runMigration(ctx, conn, m, m.Down, query, args...); err != nil {
+				return err
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatuses lists every known migration with its applied time
+func (db *DB) MigrationStatuses(ctx context.Context) ([]*MigrationStatus, error) {
+	migrations, err := Migrations(db.driver)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*MigrationStatus
+	err = db.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			status := &MigrationStatus{Version: m.Version, Name: m.Name}
+			if appliedAt, ok := done[m.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return checkKnownVersions(done, migrations)
+	})
+	return statuses, err
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock, after making sure the schema_migrations table exists. SQLite
+// needs no lock as its pool has a single connection.
+func (db *DB) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch db.driver {
+	case DriverPostgres:
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return err
+		}
+		// The unlock must run even if ctx is done, or the pooled connection
+		// would keep holding the lock
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+	case DriverMySQL:
+		if _, err := conn.ExecContext(ctx, "SELECT GET_LOCK(?, -1)", fmt.Sprint(migrationLockID)); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", fmt.Sprint(migrationLockID))
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// appliedVersions maps each applied migration version to its applied time
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]int64, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int64]int64)
+	for rows.Next() {
+		var version, appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+	return done, rows.Err()
+}
+
+func checkKnownVersions(done map[int64]int64, migrations []*Migration) error {
+	known := make(map[int64]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	for version := range done {
+		if !known[version] {
+			return fmt.Errorf("%w: version %d", ErrUnknownMigration, version)
+		}
+	}
+	return nil
+}
+
+// runMigration executes one migration script and the bookkeeping statement
+// in a single transaction. MySQL commits DDL implicitly, so there a failing
+// migration can leave its earlier statements applied.
+func runMigration(ctx context.Context, conn *sql.Conn, m *Migration, script, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
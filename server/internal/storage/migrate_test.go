@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrationsAreWellFormed(t *testing.T) {
+	for _, driver := range []string{DriverPostgres, DriverSQLite, DriverMySQL} {
+		migrations, err := Migrations(driver)
+		if err != nil {
+			t.Fatalf("loading embedded %s migrations failed: %v", driver, err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("expected at least the baseline %s migration", driver)
+		}
+		for i, m := range migrations {
+			if m.Version != int64(i+1) {
+				t.Errorf("expected %s migration %d to have version %d, got %d_%s", driver, i, i+1, m.Version, m.Name)
+			}
+		}
+	}
+}
+
+func TestDriversHaveTheSameMigrations(t *testing.T) {
+	postgres, err := Migrations(DriverPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, driver := range []string{DriverSQLite, DriverMySQL} {
+		other, err := Migrations(driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(postgres) != len(other) {
+			t.Fatalf("expected the same number of migrations, got %d for postgres and %d for %s", len(postgres), len(other), driver)
+		}
+		for i := range postgres {
+			if postgres[i].Version != other[i].Version || postgres[i].Name != other[i].Name {
+				t.Errorf("%s migration %d differs: %d_%s vs %d_%s", driver, i, postgres[i].Version, postgres[i].Name, other[i].Version, other[i].Name)
+			}
+		}
+	}
+}
+
+func TestLoadMigrationsOrdersByVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0010_later.up.sql":    {Data: []byte("SELECT 10")},
+		"migrations/0010_later.down.sql":  {Data: []byte("SELECT -10")},
+		"migrations/0002_second.up.sql":   {Data: []byte("SELECT 2")},
+		"migrations/0002_second.down.sql": {Data: []byte("SELECT -2")},
+	}
+	migrations, err := loadMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("loadMigrations failed: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 2 || migrations[1].Version != 10 {
+		t.Fatalf("expected versions [2 10], got %+v", migrations)
+	}
+	if migrations[0].Name != "second" || migrations[0].Up != "SELECT 2" || migrations[0].Down != "SELECT -2" {
+		t.Fatalf("unexpected migration %+v", migrations[0])
+	}
+}
+
+func TestLoadMigrationsRejectsBadFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+	}{
+		{"missing down", []string{"0001_init.up.sql"}},
+		{"missing up", []string{"0001_init.down.sql"}},
+		{"bad name", []string{"init.up.sql", "init.down.sql"}},
+		{"version zero", []string{"0000_init.up.sql", "0000_init.down.sql"}},
+		{"conflicting names", []string{"0001_init.up.sql", "0001_other.down.sql"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range tt.files {
+				fsys["migrations/"+name] = &fstest.MapFile{Data: []byte("SELECT 1")}
+			}
+			if _, err := loadMigrations(fsys, "migrations"); err == nil {
+				t.Fatalf("expected an error for %v", tt.files)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+-- Baseline schema for MySQL 8 / MariaDB 10.5+; mirrors postgres/0001_baseline.up.sql.
+-- The binary collation keeps string comparisons (usernames, LIKE filters)
+-- case-sensitive like in Postgres. MySQL has no partial indexes, so those
+-- become regular indexes; its unique indexes already allow repeated NULLs.
+
+CREATE TABLE IF NOT EXISTS users (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	username VARCHAR(255) UNIQUE NOT NULL,
+	hashed_password VARCHAR(255) NOT NULL,
+	public_key LONGBLOB,
+	encrypted_private_key LONGBLOB,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	updated_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP())
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS contacts (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user1_id BIGINT NOT NULL,
+	user2_id BIGINT NOT NULL,
+	requester_id BIGINT NOT NULL,
+	status VARCHAR(50) NOT NULL DEFAULT 'pending',
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	updated_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (user1_id, user2_id),
+	CHECK (user1_id < user2_id),
+	INDEX idx_contacts_user1_id (user1_id),
+	INDEX idx_contacts_user2_id (user2_id),
+	FOREIGN KEY (user1_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (user2_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS chats (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user1_id BIGINT NOT NULL,
+	user2_id BIGINT NOT NULL,
+	chat_type VARCHAR(20) NOT NULL DEFAULT 'direct',
+	algorithm VARCHAR(50) NOT NULL,
+	mode VARCHAR(50) NOT NULL,
+	padding VARCHAR(50) NOT NULL,
+	status VARCHAR(50) NOT NULL DEFAULT 'active',
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	closed_at BIGINT,
+	updated_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	last_activity_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	retention_days INT NOT NULL DEFAULT 0,
+	retention_max_messages INT NOT NULL DEFAULT 0,
+	history_retained BOOLEAN NOT NULL DEFAULT FALSE,
+	last_seq BIGINT NOT NULL DEFAULT 0,
+	UNIQUE (user1_id, user2_id),
+	INDEX idx_chats_user1_id (user1_id),
+	INDEX idx_chats_user2_id (user2_id),
+	INDEX idx_chats_last_activity_at (last_activity_at DESC),
+	FOREIGN KEY (user1_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (user2_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS dh_parameters (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL UNIQUE,
+	p LONGBLOB NOT NULL,
+	g LONGBLOB NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS dh_globals (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	p LONGBLOB NOT NULL,
+	g LONGBLOB NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP())
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS dh_public_keys (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	public_key LONGBLOB NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (chat_id, user_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS messages (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	sender_id BIGINT NOT NULL,
+	seq BIGINT NOT NULL,
+	ciphertext LONGBLOB NOT NULL,
+	iv LONGBLOB,
+	file_name VARCHAR(255),
+	mime_type VARCHAR(100),
+	reply_to_message_id BIGINT,
+	message_uuid VARCHAR(64),
+	preview LONGBLOB,
+	preview_iv LONGBLOB,
+	urgent BOOLEAN NOT NULL DEFAULT FALSE,
+	expires_at BIGINT,
+	delivered_at BIGINT,
+	read_at BIGINT,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	INDEX idx_messages_chat_id (chat_id),
+	INDEX idx_messages_sender_id (sender_id),
+	INDEX idx_messages_chat_id_created_at (chat_id, created_at),
+	INDEX idx_messages_chat_id_id (chat_id, id),
+	INDEX idx_messages_undelivered (chat_id, delivered_at, id),
+	UNIQUE INDEX idx_messages_chat_id_message_uuid (chat_id, message_uuid),
+	UNIQUE INDEX idx_messages_chat_id_seq (chat_id, seq),
+	INDEX idx_messages_urgent_sender (sender_id, urgent, created_at),
+	INDEX idx_messages_expires_at (expires_at),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (reply_to_message_id) REFERENCES messages(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS session_keys (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL UNIQUE,
+	session_key LONGBLOB NOT NULL,
+	iv LONGBLOB NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS chat_read_markers (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	last_read_message_id BIGINT NOT NULL,
+	read_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (chat_id, user_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS chat_last_seen (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	last_opened_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (chat_id, user_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS chat_notification_prefs (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	level VARCHAR(20) NOT NULL DEFAULT 'always',
+	sound_id VARCHAR(100) NOT NULL DEFAULT '',
+	updated_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (chat_id, user_id),
+	INDEX idx_chat_notification_prefs_user_id (user_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS chat_pins (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	message_id BIGINT NOT NULL,
+	pinned_by BIGINT NOT NULL,
+	pinned_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (chat_id, message_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+	FOREIGN KEY (pinned_by) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS chat_drafts (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	ciphertext LONGBLOB NOT NULL,
+	iv LONGBLOB NOT NULL,
+	updated_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (chat_id, user_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	chat_id BIGINT NOT NULL,
+	file_name VARCHAR(255) NOT NULL,
+	mime_type VARCHAR(100) NOT NULL,
+	total_size BIGINT NOT NULL,
+	received_size BIGINT NOT NULL DEFAULT 0,
+	chunk_count INT NOT NULL DEFAULT 0,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	updated_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS files (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	owner_id BIGINT NOT NULL,
+	chat_id BIGINT NOT NULL,
+	blob_key VARCHAR(255) NOT NULL UNIQUE,
+	file_name VARCHAR(255) NOT NULL,
+	mime_type VARCHAR(100) NOT NULL,
+	size BIGINT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	INDEX idx_files_chat_id (chat_id),
+	FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS message_attachments (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	message_id BIGINT NOT NULL,
+	file_id BIGINT NOT NULL,
+	UNIQUE (message_id, file_id),
+	INDEX idx_message_attachments_file_id (file_id),
+	FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS file_thumbnails (
+	file_id BIGINT PRIMARY KEY,
+	data LONGBLOB NOT NULL,
+	width INT NOT NULL,
+	height INT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS blob_deletions (
+	blob_key VARCHAR(255) PRIMARY KEY,
+	queued_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP())
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+-- Tokens are at most 64 bytes (see message.MaxSearchTokenSize)
+CREATE TABLE IF NOT EXISTS message_search_tokens (
+	chat_id BIGINT NOT NULL,
+	message_id BIGINT NOT NULL,
+	token VARBINARY(64) NOT NULL,
+	PRIMARY KEY (message_id, token),
+	INDEX idx_message_search_tokens_chat_token (chat_id, token),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
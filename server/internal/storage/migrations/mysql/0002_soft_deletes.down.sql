@@ -0,0 +1,5 @@
+DROP INDEX idx_messages_deleted_at ON messages;
+DROP INDEX idx_users_deleted_at ON users;
+
+ALTER TABLE messages DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN deleted_at;
@@ -0,0 +1,6 @@
+-- Tombstones: soft-deleted rows stay until the retention purge removes them
+ALTER TABLE users ADD COLUMN deleted_at BIGINT NULL;
+ALTER TABLE messages ADD COLUMN deleted_at BIGINT NULL;
+
+CREATE INDEX idx_users_deleted_at ON users(deleted_at);
+CREATE INDEX idx_messages_deleted_at ON messages(deleted_at);
@@ -0,0 +1,5 @@
+-- Messages are only partitioned on Postgres: partitioned InnoDB tables
+-- cannot have foreign keys. Attachments record their chat like on Postgres,
+-- where the foreign key to the partitioned messages table needs it.
+ALTER TABLE message_attachments ADD COLUMN chat_id BIGINT;
+UPDATE message_attachments ma JOIN messages m ON m.id = ma.message_id SET ma.chat_id = m.chat_id WHERE ma.chat_id IS NULL;
@@ -0,0 +1 @@
+CREATE INDEX idx_messages_chat_id ON messages(chat_id);
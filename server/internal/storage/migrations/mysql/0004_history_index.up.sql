@@ -0,0 +1,4 @@
+-- History pages are read through idx_messages_chat_id_id in either direction;
+-- the single-column chat_id index duplicated its prefix (and the chat_id
+-- foreign key is served by the composite index)
+DROP INDEX idx_messages_chat_id ON messages;
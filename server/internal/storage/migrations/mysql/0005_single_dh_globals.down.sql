@@ -0,0 +1,4 @@
+ALTER TABLE dh_globals
+	DROP INDEX idx_dh_globals_singleton,
+	DROP CHECK chk_dh_globals_singleton,
+	DROP COLUMN singleton;
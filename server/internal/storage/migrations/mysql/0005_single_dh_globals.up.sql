@@ -0,0 +1,8 @@
+-- dh_globals holds exactly one parameter set. Gateways starting at the same
+-- time could each insert their own; keep the first, which is the one that
+-- has been served, and let the unique singleton column reject any other.
+DELETE FROM dh_globals WHERE id > (SELECT min_id FROM (SELECT MIN(id) AS min_id FROM dh_globals) t);
+ALTER TABLE dh_globals
+	ADD COLUMN singleton BOOLEAN NOT NULL DEFAULT TRUE,
+	ADD CONSTRAINT chk_dh_globals_singleton CHECK (singleton),
+	ADD UNIQUE INDEX idx_dh_globals_singleton (singleton);
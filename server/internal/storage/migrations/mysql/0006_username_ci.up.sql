@@ -0,0 +1,7 @@
+-- Usernames are unique regardless of case, so "Alice" and "alice" cannot be
+-- two accounts. Existing accounts that differ only by case make this fail and
+-- have to be renamed first. MariaDB has no functional indexes, so the
+-- lowercased name is a generated column.
+ALTER TABLE users
+	ADD COLUMN username_lower VARCHAR(255) AS (LOWER(username)) STORED,
+	ADD UNIQUE INDEX idx_users_username_lower (username_lower);
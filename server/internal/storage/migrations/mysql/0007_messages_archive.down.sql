@@ -0,0 +1,5 @@
+-- Archived messages are moved back before the archive is dropped
+INSERT INTO messages (id, chat_id, sender_id, seq, ciphertext, iv, file_name, mime_type, reply_to_message_id, message_uuid, preview, preview_iv, urgent, expires_at, delivered_at, read_at, created_at, deleted_at)
+	SELECT id, chat_id, sender_id, seq, ciphertext, iv, file_name, mime_type, reply_to_message_id, message_uuid, preview, preview_iv, urgent, expires_at, delivered_at, read_at, created_at, deleted_at FROM messages_archive;
+DROP TABLE IF EXISTS messages_archive;
+DROP INDEX idx_messages_reply_to ON messages;
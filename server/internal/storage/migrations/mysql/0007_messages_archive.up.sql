@@ -0,0 +1,30 @@
+-- Cold storage: the retention worker moves old messages here to keep the
+-- messages table small. History reads include it on request.
+CREATE TABLE IF NOT EXISTS messages_archive (
+	id BIGINT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	sender_id BIGINT NOT NULL,
+	seq BIGINT NOT NULL,
+	ciphertext LONGBLOB NOT NULL,
+	iv LONGBLOB,
+	file_name VARCHAR(255),
+	mime_type VARCHAR(100),
+	reply_to_message_id BIGINT,
+	message_uuid VARCHAR(64),
+	preview LONGBLOB,
+	preview_iv LONGBLOB,
+	urgent BOOLEAN NOT NULL DEFAULT FALSE,
+	expires_at BIGINT,
+	delivered_at BIGINT,
+	read_at BIGINT,
+	created_at BIGINT NOT NULL,
+	deleted_at BIGINT,
+	INDEX idx_messages_archive_chat_id_id (chat_id, id),
+	INDEX idx_messages_archive_created_at (created_at),
+	INDEX idx_messages_archive_sender_id (sender_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+-- Messages that are replied to are not archived
+CREATE INDEX idx_messages_reply_to ON messages(chat_id, reply_to_message_id);
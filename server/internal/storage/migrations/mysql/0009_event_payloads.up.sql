@@ -0,0 +1,9 @@
+-- Events relayed between gateways with LISTEN/NOTIFY that exceed the NOTIFY
+-- payload limit are stored here and the notification carries the row id.
+-- Only Postgres relays events; the table exists so the schemas stay alike.
+CREATE TABLE IF NOT EXISTS event_payloads (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	payload LONGTEXT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	INDEX idx_event_payloads_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
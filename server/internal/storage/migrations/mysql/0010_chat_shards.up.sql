@@ -0,0 +1,10 @@
+-- Shard map for message sharding: the message shard holding each chat's
+-- messages. Only Postgres shards messages; the table exists so the schemas
+-- stay alike.
+CREATE TABLE IF NOT EXISTS chat_shards (
+	chat_id BIGINT PRIMARY KEY,
+	shard_id INT NOT NULL,
+	moved_at BIGINT,
+	INDEX idx_chat_shards_shard_id (shard_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
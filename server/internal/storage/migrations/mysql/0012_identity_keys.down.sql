@@ -0,0 +1,3 @@
+ALTER TABLE dh_public_keys DROP COLUMN signature;
+ALTER TABLE dh_public_keys DROP COLUMN epoch;
+ALTER TABLE users DROP COLUMN identity_key;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS ratchet_chains;
+ALTER TABLE messages_archive DROP COLUMN ratchet_header;
+ALTER TABLE messages DROP COLUMN ratchet_header;
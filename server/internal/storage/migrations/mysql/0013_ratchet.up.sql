@@ -0,0 +1,21 @@
+-- Double Ratchet header of each message, NULL for messages under the static
+-- chat key
+ALTER TABLE messages ADD COLUMN ratchet_header LONGBLOB;
+ALTER TABLE messages_archive ADD COLUMN ratchet_header LONGBLOB;
+
+-- The ratchet public keys each sender used in a chat, numbered by epoch, with
+-- the highest message number seen under each; a message may only move its
+-- sender's ratchet forward
+CREATE TABLE IF NOT EXISTS ratchet_chains (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	sender_id BIGINT NOT NULL,
+	epoch BIGINT NOT NULL,
+	public_key VARBINARY(32) NOT NULL,
+	last_n BIGINT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (chat_id, sender_id, epoch),
+	UNIQUE (chat_id, sender_id, public_key),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,14 @@
+-- SAS confirmations: a participant confirmed that the short code of the key
+-- exchange matched on both devices. transcript is the hash of the key
+-- exchange they confirmed; a chat is verified while both confirmations
+-- match its current keys.
+CREATE TABLE IF NOT EXISTS chat_verifications (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	transcript VARBINARY(32) NOT NULL,
+	confirmed_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (chat_id, user_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,4 @@
+-- How the keys of each chat are derived from its shared secret. Chats that
+-- already exist keep the chat-ID-salted HKDF their history was encrypted
+-- with; new chats bind their keys to the key exchange transcript.
+ALTER TABLE dh_parameters ADD COLUMN kdf VARCHAR(32) NOT NULL DEFAULT 'HKDF-SHA256-CHAT-V1';
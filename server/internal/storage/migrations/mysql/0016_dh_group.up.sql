@@ -0,0 +1,4 @@
+-- The RFC 7919 group a DH chat was created with, e.g. ffdhe3072. Empty for
+-- X25519 chats, for parameters that are no named group and for chats stored
+-- before the group was recorded, which are identified by their prime.
+ALTER TABLE dh_parameters ADD COLUMN dh_group VARCHAR(32) NOT NULL DEFAULT '';
@@ -0,0 +1,15 @@
+-- Identity keys users verified for their contacts, e.g. by comparing the
+-- safety number. signature is the user's identity key signature over the
+-- contact's key; a verification counts while that key is the contact's
+-- current one.
+CREATE TABLE IF NOT EXISTS contact_verifications (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	contact_id BIGINT NOT NULL,
+	identity_key VARBINARY(32) NOT NULL,
+	signature VARBINARY(64) NOT NULL,
+	verified_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (user_id, contact_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (contact_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
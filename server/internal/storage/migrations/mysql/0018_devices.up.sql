@@ -0,0 +1,32 @@
+-- Devices a user logs in from, each with its own X25519 key pair. The
+-- private key is stored wrapped under the user's password, like the account
+-- key; revoked devices keep their row so old key shares stay attributable.
+CREATE TABLE IF NOT EXISTS devices (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	name VARCHAR(64) NOT NULL DEFAULT '',
+	public_key VARBINARY(32) NOT NULL,
+	encrypted_private_key BLOB,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	last_seen_at BIGINT NOT NULL DEFAULT 0,
+	revoked_at BIGINT,
+	INDEX idx_devices_user (user_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+-- Chat key material one device sealed to another device's public key, so
+-- every device of a participant holds the keys of a chat without repeating
+-- the key exchange. The server cannot open a share; each recipient device
+-- keeps only the latest share of a chat.
+CREATE TABLE IF NOT EXISTS chat_key_shares (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	chat_id BIGINT NOT NULL,
+	sender_device_id BIGINT NOT NULL,
+	recipient_device_id BIGINT NOT NULL,
+	share BLOB NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	UNIQUE (chat_id, recipient_device_id),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (sender_device_id) REFERENCES devices(id) ON DELETE CASCADE,
+	FOREIGN KEY (recipient_device_id) REFERENCES devices(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,4 @@
+-- Whether the server may hold session key material for a chat: 'server'
+-- chats may keep a session key in session_keys, 'client_only' chats never
+-- do; their keys only exist on the participants' devices.
+ALTER TABLE chats ADD COLUMN key_mode VARCHAR(16) NOT NULL DEFAULT 'server';
@@ -0,0 +1,12 @@
+-- The key bundle of a user sealed under a backup passphrase separate from
+-- their login password (crypto.SealKeyBackup), for moving to a new device.
+-- The server cannot open it; kdf repeats the Argon2id parameters from the
+-- sealed header. One backup per user, replaced by every upload.
+CREATE TABLE IF NOT EXISTS key_backups (
+	user_id BIGINT PRIMARY KEY,
+	backup MEDIUMBLOB NOT NULL,
+	kdf VARCHAR(255) NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	updated_at BIGINT NOT NULL DEFAULT (UNIX_TIMESTAMP()),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,15 @@
+-- Replay protection for the key exchange. Every dh/init and dh/exchange
+-- submission carries a client nonce and timestamp; a nonce is accepted once
+-- per chat and participant, and is kept only as long as its timestamp would
+-- still be accepted. published_at is the timestamp of the submission that
+-- published a key, so that an older submission cannot replace it.
+CREATE TABLE IF NOT EXISTS dh_exchange_nonces (
+	chat_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	nonce VARCHAR(64) NOT NULL,
+	created_at BIGINT NOT NULL,
+	PRIMARY KEY (chat_id, user_id, nonce),
+	FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+ALTER TABLE dh_public_keys ADD COLUMN published_at BIGINT NOT NULL DEFAULT 0;
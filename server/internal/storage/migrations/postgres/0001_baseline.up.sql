@@ -0,0 +1,252 @@
+-- Baseline schema. Everything is idempotent so that databases created before
+-- versioned migrations existed can be adopted without changes.
+
+-- Users table
+CREATE TABLE IF NOT EXISTS users (
+	id BIGSERIAL PRIMARY KEY,
+	username VARCHAR(255) UNIQUE NOT NULL,
+	hashed_password VARCHAR(255) NOT NULL,
+	public_key BYTEA,
+	encrypted_private_key BYTEA,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+-- Contacts table
+CREATE TABLE IF NOT EXISTS contacts (
+	id BIGSERIAL PRIMARY KEY,
+	user1_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	user2_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	requester_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	status VARCHAR(50) NOT NULL DEFAULT 'pending',
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	UNIQUE(user1_id, user2_id),
+	CHECK(user1_id < user2_id)
+);
+
+-- Chats table
+CREATE TABLE IF NOT EXISTS chats (
+	id BIGSERIAL PRIMARY KEY,
+	user1_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	user2_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	algorithm VARCHAR(50) NOT NULL,
+	mode VARCHAR(50) NOT NULL,
+	padding VARCHAR(50) NOT NULL,
+	status VARCHAR(50) NOT NULL DEFAULT 'active',
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	closed_at BIGINT,
+	updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	UNIQUE(user1_id, user2_id)
+);
+
+-- DH Parameters table (stores p, g for each chat)
+CREATE TABLE IF NOT EXISTS dh_parameters (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL UNIQUE REFERENCES chats(id) ON DELETE CASCADE,
+	p BYTEA NOT NULL,
+	g BYTEA NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+-- Global DH parameters (single row)
+CREATE TABLE IF NOT EXISTS dh_globals (
+	id BIGSERIAL PRIMARY KEY,
+	p BYTEA NOT NULL,
+	g BYTEA NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+-- DH Public Keys table (stores A and B public keys)
+CREATE TABLE IF NOT EXISTS dh_public_keys (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	public_key BYTEA NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	UNIQUE(chat_id, user_id)
+);
+
+-- Messages table
+CREATE TABLE IF NOT EXISTS messages (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	ciphertext BYTEA NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+-- Indexes for performance
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id);
+CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages(sender_id);
+CREATE INDEX IF NOT EXISTS idx_chats_user1_id ON chats(user1_id);
+CREATE INDEX IF NOT EXISTS idx_chats_user2_id ON chats(user2_id);
+CREATE INDEX IF NOT EXISTS idx_contacts_user1_id ON contacts(user1_id);
+CREATE INDEX IF NOT EXISTS idx_contacts_user2_id ON contacts(user2_id);
+
+-- Columns, indexes and tables added to the original schema
+ALTER TABLE users ADD COLUMN IF NOT EXISTS public_key BYTEA;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS encrypted_private_key BYTEA;
+ALTER TABLE dh_parameters ADD COLUMN IF NOT EXISTS p BYTEA;
+ALTER TABLE dh_parameters ADD COLUMN IF NOT EXISTS g BYTEA;
+ALTER TABLE dh_parameters DROP COLUMN IF EXISTS public_key;
+ALTER TABLE dh_parameters ADD COLUMN IF NOT EXISTS user_id BIGINT;
+ALTER TABLE dh_parameters ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE contacts ADD COLUMN IF NOT EXISTS requester_id BIGINT;
+UPDATE contacts SET requester_id = user1_id WHERE requester_id IS NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS iv BYTEA;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS file_name VARCHAR(255);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS mime_type VARCHAR(100);
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS chat_type VARCHAR(20) NOT NULL DEFAULT 'direct';
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS last_activity_at BIGINT;
+UPDATE chats SET last_activity_at = COALESCE((SELECT MAX(m.created_at) FROM messages m WHERE m.chat_id = chats.id), created_at) WHERE last_activity_at IS NULL;
+ALTER TABLE chats ALTER COLUMN last_activity_at SET DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT;
+ALTER TABLE chats ALTER COLUMN last_activity_at SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_chats_last_activity_at ON chats(last_activity_at DESC);
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS retention_days INT NOT NULL DEFAULT 0;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS retention_max_messages INT NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id_created_at ON messages(chat_id, created_at);
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS history_retained BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id_id ON messages(chat_id, id);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL;
+
+-- Delivery state as seen by the recipient; NULL until it happens
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS delivered_at BIGINT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS read_at BIGINT;
+CREATE INDEX IF NOT EXISTS idx_messages_undelivered ON messages(chat_id, id) WHERE delivered_at IS NULL;
+
+-- Client-generated idempotency key; a resend with the same UUID returns the stored message
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS message_uuid VARCHAR(64);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_chat_id_message_uuid ON messages(chat_id, message_uuid) WHERE message_uuid IS NOT NULL;
+
+-- Per-chat message sequence numbers; existing messages are numbered in ID order
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+UPDATE messages SET seq = c.last_seq + n.rn
+	FROM (SELECT id, chat_id, ROW_NUMBER() OVER (PARTITION BY chat_id ORDER BY id) AS rn FROM messages WHERE seq IS NULL) n
+	JOIN chats c ON c.id = n.chat_id
+	WHERE messages.id = n.id;
+UPDATE chats SET last_seq = m.max_seq FROM (SELECT chat_id, MAX(seq) AS max_seq FROM messages GROUP BY chat_id) m WHERE m.chat_id = chats.id AND chats.last_seq < m.max_seq;
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_chat_id_seq ON messages(chat_id, seq);
+
+-- Optional client-encrypted link preview, relayed verbatim
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS preview BYTEA;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS preview_iv BYTEA;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS urgent BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_messages_urgent_sender ON messages(sender_id, created_at) WHERE urgent;
+
+-- Sender-chosen expiry; expired messages are hidden and later purged
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at BIGINT;
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS session_keys (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL UNIQUE REFERENCES chats(id) ON DELETE CASCADE,
+	session_key BYTEA NOT NULL,
+	iv BYTEA NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS chat_read_markers (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	last_read_message_id BIGINT NOT NULL,
+	read_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	UNIQUE(chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_last_seen (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	last_opened_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	UNIQUE(chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_notification_prefs (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	level VARCHAR(20) NOT NULL DEFAULT 'always',
+	sound_id VARCHAR(100) NOT NULL DEFAULT '',
+	updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	UNIQUE(chat_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_chat_notification_prefs_user_id ON chat_notification_prefs(user_id);
+
+CREATE TABLE IF NOT EXISTS chat_pins (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	pinned_by BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	pinned_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	UNIQUE(chat_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_drafts (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	ciphertext BYTEA NOT NULL,
+	iv BYTEA NOT NULL,
+	updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	UNIQUE(chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	file_name VARCHAR(255) NOT NULL,
+	mime_type VARCHAR(100) NOT NULL,
+	total_size BIGINT NOT NULL,
+	received_size BIGINT NOT NULL DEFAULT 0,
+	chunk_count INT NOT NULL DEFAULT 0,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS files (
+	id BIGSERIAL PRIMARY KEY,
+	owner_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	blob_key VARCHAR(255) NOT NULL UNIQUE,
+	file_name VARCHAR(255) NOT NULL,
+	mime_type VARCHAR(100) NOT NULL,
+	size BIGINT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_files_chat_id ON files(chat_id);
+
+CREATE TABLE IF NOT EXISTS message_attachments (
+	id BIGSERIAL PRIMARY KEY,
+	message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	file_id BIGINT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+	UNIQUE(message_id, file_id)
+);
+CREATE INDEX IF NOT EXISTS idx_message_attachments_file_id ON message_attachments(file_id);
+
+-- Small client-encrypted preview images shown before the full file is downloaded
+CREATE TABLE IF NOT EXISTS file_thumbnails (
+	file_id BIGINT PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+	data BYTEA NOT NULL,
+	width INT NOT NULL,
+	height INT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS blob_deletions (
+	blob_key VARCHAR(255) PRIMARY KEY,
+	queued_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+-- Client-computed keyed search tokens (e.g. HMAC of each normalized word)
+CREATE TABLE IF NOT EXISTS message_search_tokens (
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	token BYTEA NOT NULL,
+	PRIMARY KEY (message_id, token)
+);
+CREATE INDEX IF NOT EXISTS idx_message_search_tokens_chat_token ON message_search_tokens(chat_id, token);
@@ -0,0 +1,6 @@
+-- Tombstones: soft-deleted rows stay until the retention purge removes them
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at BIGINT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at BIGINT;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_deleted_at ON messages(deleted_at) WHERE deleted_at IS NOT NULL;
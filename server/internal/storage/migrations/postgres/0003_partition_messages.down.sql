@@ -0,0 +1,32 @@
+ALTER TABLE chat_pins DROP CONSTRAINT IF EXISTS chat_pins_message_id_chat_id_fkey;
+ALTER TABLE message_attachments DROP CONSTRAINT IF EXISTS message_attachments_message_id_chat_id_fkey;
+ALTER TABLE message_search_tokens DROP CONSTRAINT IF EXISTS message_search_tokens_message_id_chat_id_fkey;
+
+ALTER SEQUENCE messages_id_seq OWNED BY NONE;
+ALTER TABLE messages RENAME TO messages_partitioned;
+CREATE TABLE messages (LIKE messages_partitioned INCLUDING DEFAULTS);
+INSERT INTO messages SELECT * FROM messages_partitioned;
+DROP TABLE messages_partitioned;
+ALTER SEQUENCE messages_id_seq OWNED BY messages.id;
+
+ALTER TABLE messages ADD PRIMARY KEY (id);
+ALTER TABLE messages ADD FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD FOREIGN KEY (reply_to_message_id) REFERENCES messages(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id);
+CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages(sender_id);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id_created_at ON messages(chat_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id_id ON messages(chat_id, id);
+CREATE INDEX IF NOT EXISTS idx_messages_undelivered ON messages(chat_id, id) WHERE delivered_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_chat_id_message_uuid ON messages(chat_id, message_uuid) WHERE message_uuid IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_chat_id_seq ON messages(chat_id, seq);
+CREATE INDEX IF NOT EXISTS idx_messages_urgent_sender ON messages(sender_id, created_at) WHERE urgent;
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_deleted_at ON messages(deleted_at) WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE chat_pins ADD FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE message_attachments ADD FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE message_search_tokens ADD FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+
+ALTER TABLE message_attachments DROP COLUMN IF EXISTS chat_id;
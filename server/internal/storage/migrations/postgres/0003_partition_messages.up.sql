@@ -0,0 +1,51 @@
+-- Hash-partition messages by chat_id, so that deleting the messages of a
+-- chat and reading its history only touch one partition. Unique keys of a
+-- partitioned table must include chat_id: the primary key becomes
+-- (id, chat_id) and foreign keys to messages carry the chat_id as well.
+
+ALTER TABLE message_attachments ADD COLUMN IF NOT EXISTS chat_id BIGINT;
+UPDATE message_attachments SET chat_id = m.chat_id FROM messages m WHERE m.id = message_attachments.message_id AND message_attachments.chat_id IS NULL;
+ALTER TABLE message_attachments ALTER COLUMN chat_id SET NOT NULL;
+
+ALTER TABLE chat_pins DROP CONSTRAINT IF EXISTS chat_pins_message_id_fkey;
+ALTER TABLE message_attachments DROP CONSTRAINT IF EXISTS message_attachments_message_id_fkey;
+ALTER TABLE message_search_tokens DROP CONSTRAINT IF EXISTS message_search_tokens_message_id_fkey;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_reply_to_message_id_fkey;
+
+-- The ID sequence must outlive the old table
+DO $$
+BEGIN
+	EXECUTE format('ALTER SEQUENCE %s OWNED BY NONE', pg_get_serial_sequence('messages', 'id'));
+END $$;
+
+ALTER TABLE messages RENAME TO messages_unpartitioned;
+CREATE TABLE messages (LIKE messages_unpartitioned INCLUDING DEFAULTS) PARTITION BY HASH (chat_id);
+DO $$
+BEGIN
+	FOR i IN 0..15 LOOP
+		EXECUTE format('CREATE TABLE messages_p%s PARTITION OF messages FOR VALUES WITH (MODULUS 16, REMAINDER %s)', i, i);
+	END LOOP;
+END $$;
+
+INSERT INTO messages SELECT * FROM messages_unpartitioned;
+DROP TABLE messages_unpartitioned;
+ALTER SEQUENCE messages_id_seq OWNED BY messages.id;
+
+ALTER TABLE messages ADD PRIMARY KEY (id, chat_id);
+ALTER TABLE messages ADD FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE messages ADD FOREIGN KEY (reply_to_message_id, chat_id) REFERENCES messages(id, chat_id) ON DELETE SET NULL (reply_to_message_id);
+
+CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages(sender_id);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id_created_at ON messages(chat_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id_id ON messages(chat_id, id);
+CREATE INDEX IF NOT EXISTS idx_messages_undelivered ON messages(chat_id, id) WHERE delivered_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_chat_id_message_uuid ON messages(chat_id, message_uuid) WHERE message_uuid IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_chat_id_seq ON messages(chat_id, seq);
+CREATE INDEX IF NOT EXISTS idx_messages_urgent_sender ON messages(sender_id, created_at) WHERE urgent;
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_deleted_at ON messages(deleted_at) WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE chat_pins ADD FOREIGN KEY (message_id, chat_id) REFERENCES messages(id, chat_id) ON DELETE CASCADE;
+ALTER TABLE message_attachments ADD FOREIGN KEY (message_id, chat_id) REFERENCES messages(id, chat_id) ON DELETE CASCADE;
+ALTER TABLE message_search_tokens ADD FOREIGN KEY (message_id, chat_id) REFERENCES messages(id, chat_id) ON DELETE CASCADE;
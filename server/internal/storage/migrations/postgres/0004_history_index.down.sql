@@ -0,0 +1,2 @@
+-- 0003 does not recreate the index either, so there is nothing to restore
+SELECT 1;
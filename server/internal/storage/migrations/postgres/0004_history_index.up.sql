@@ -0,0 +1,4 @@
+-- History pages are read through idx_messages_chat_id_id in either direction;
+-- the single-column chat_id index duplicated its prefix. On Postgres it was
+-- not recreated when messages were partitioned (0003).
+DROP INDEX IF EXISTS idx_messages_chat_id;
@@ -0,0 +1,4 @@
+-- Usernames are unique regardless of case, so "Alice" and "alice" cannot be
+-- two accounts. Existing accounts that differ only by case make this fail and
+-- have to be renamed first.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_lower ON users(LOWER(username));
@@ -0,0 +1,4 @@
+-- Archived messages are moved back before the archive is dropped
+INSERT INTO messages SELECT * FROM messages_archive;
+DROP TABLE IF EXISTS messages_archive;
+DROP INDEX IF EXISTS idx_messages_reply_to;
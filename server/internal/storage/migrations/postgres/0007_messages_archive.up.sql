@@ -0,0 +1,12 @@
+-- Cold storage: the retention worker moves old messages here to keep the
+-- messages table small. History reads include it on request.
+CREATE TABLE IF NOT EXISTS messages_archive (LIKE messages INCLUDING DEFAULTS);
+ALTER TABLE messages_archive ALTER COLUMN id DROP DEFAULT;
+ALTER TABLE messages_archive ADD PRIMARY KEY (id);
+ALTER TABLE messages_archive ADD FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE;
+ALTER TABLE messages_archive ADD FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_messages_archive_chat_id_id ON messages_archive(chat_id, id);
+CREATE INDEX IF NOT EXISTS idx_messages_archive_created_at ON messages_archive(created_at);
+
+-- Messages that are replied to are not archived
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages(chat_id, reply_to_message_id) WHERE reply_to_message_id IS NOT NULL;
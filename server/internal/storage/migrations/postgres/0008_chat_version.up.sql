@@ -0,0 +1,3 @@
+-- Incremented by every change of a chat's status or encryption settings, so
+-- concurrent close/reopen/renegotiate requests can detect each other
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
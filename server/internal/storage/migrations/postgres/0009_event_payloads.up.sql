@@ -0,0 +1,9 @@
+-- Events relayed between gateways with LISTEN/NOTIFY that exceed the NOTIFY
+-- payload limit are stored here and the notification carries the row id.
+-- Rows are only read once, right after they are written, and expire quickly.
+CREATE TABLE IF NOT EXISTS event_payloads (
+	id BIGSERIAL PRIMARY KEY,
+	payload TEXT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_event_payloads_created_at ON event_payloads(created_at);
@@ -0,0 +1,3 @@
+-- Key agreement of each chat: "DH" over the stored p and g, or "X25519",
+-- whose rows keep p and g empty
+ALTER TABLE dh_parameters ADD COLUMN IF NOT EXISTS key_agreement VARCHAR(16) NOT NULL DEFAULT 'DH';
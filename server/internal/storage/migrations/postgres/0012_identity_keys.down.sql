@@ -0,0 +1,3 @@
+ALTER TABLE dh_public_keys DROP COLUMN IF EXISTS signature;
+ALTER TABLE dh_public_keys DROP COLUMN IF EXISTS epoch;
+ALTER TABLE users DROP COLUMN IF EXISTS identity_key;
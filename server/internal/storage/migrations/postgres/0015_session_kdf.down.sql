@@ -0,0 +1 @@
+ALTER TABLE dh_parameters DROP COLUMN IF EXISTS kdf;
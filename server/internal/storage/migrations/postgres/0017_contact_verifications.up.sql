@@ -0,0 +1,13 @@
+-- Identity keys users verified for their contacts, e.g. by comparing the
+-- safety number. signature is the user's identity key signature over the
+-- contact's key; a verification counts while that key is the contact's
+-- current one.
+CREATE TABLE IF NOT EXISTS contact_verifications (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	contact_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	identity_key BYTEA NOT NULL,
+	signature BYTEA NOT NULL,
+	verified_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	UNIQUE(user_id, contact_id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS chat_key_shares;
+DROP TABLE IF EXISTS devices;
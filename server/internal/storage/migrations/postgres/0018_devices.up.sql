@@ -0,0 +1,28 @@
+-- Devices a user logs in from, each with its own X25519 key pair. The
+-- private key is stored wrapped under the user's password, like the account
+-- key; revoked devices keep their row so old key shares stay attributable.
+CREATE TABLE IF NOT EXISTS devices (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name VARCHAR(64) NOT NULL DEFAULT '',
+	public_key BYTEA NOT NULL,
+	encrypted_private_key BYTEA,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	last_seen_at BIGINT NOT NULL DEFAULT 0,
+	revoked_at BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_devices_user ON devices(user_id);
+
+-- Chat key material one device sealed to another device's public key, so
+-- every device of a participant holds the keys of a chat without repeating
+-- the key exchange. The server cannot open a share; each recipient device
+-- keeps only the latest share of a chat.
+CREATE TABLE IF NOT EXISTS chat_key_shares (
+	id BIGSERIAL PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	sender_device_id BIGINT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+	recipient_device_id BIGINT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+	share BYTEA NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+	UNIQUE(chat_id, recipient_device_id)
+);
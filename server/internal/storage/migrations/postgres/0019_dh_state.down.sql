@@ -0,0 +1,2 @@
+ALTER TABLE chats DROP COLUMN IF EXISTS dh_state_changed_at;
+ALTER TABLE chats DROP COLUMN IF EXISTS dh_state;
@@ -0,0 +1,2 @@
+ALTER TABLE dh_public_keys DROP COLUMN IF EXISTS published_at;
+DROP TABLE IF EXISTS dh_exchange_nonces;
@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS message_search_tokens;
+DROP TABLE IF EXISTS blob_deletions;
+DROP TABLE IF EXISTS file_thumbnails;
+DROP TABLE IF EXISTS message_attachments;
+DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS upload_sessions;
+DROP TABLE IF EXISTS chat_drafts;
+DROP TABLE IF EXISTS chat_pins;
+DROP TABLE IF EXISTS chat_notification_prefs;
+DROP TABLE IF EXISTS chat_last_seen;
+DROP TABLE IF EXISTS chat_read_markers;
+DROP TABLE IF EXISTS session_keys;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS dh_public_keys;
+DROP TABLE IF EXISTS dh_globals;
+DROP TABLE IF EXISTS dh_parameters;
+DROP TABLE IF EXISTS chats;
+DROP TABLE IF EXISTS contacts;
+DROP TABLE IF EXISTS users;
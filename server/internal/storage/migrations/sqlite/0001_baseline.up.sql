@@ -0,0 +1,213 @@
+-- Baseline schema for SQLite; mirrors postgres/0001_baseline.up.sql.
+-- AUTOINCREMENT keeps IDs from being reused, which the keyset pagination and
+-- sync cursors rely on.
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username VARCHAR(255) UNIQUE NOT NULL,
+	hashed_password VARCHAR(255) NOT NULL,
+	public_key BLOB,
+	encrypted_private_key BLOB,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	updated_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE TABLE IF NOT EXISTS contacts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user1_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	user2_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	requester_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	status VARCHAR(50) NOT NULL DEFAULT 'pending',
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	updated_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	UNIQUE(user1_id, user2_id),
+	CHECK(user1_id < user2_id)
+);
+
+CREATE TABLE IF NOT EXISTS chats (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user1_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	user2_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	chat_type VARCHAR(20) NOT NULL DEFAULT 'direct',
+	algorithm VARCHAR(50) NOT NULL,
+	mode VARCHAR(50) NOT NULL,
+	padding VARCHAR(50) NOT NULL,
+	status VARCHAR(50) NOT NULL DEFAULT 'active',
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	closed_at BIGINT,
+	updated_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	last_activity_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	retention_days INT NOT NULL DEFAULT 0,
+	retention_max_messages INT NOT NULL DEFAULT 0,
+	history_retained BOOLEAN NOT NULL DEFAULT FALSE,
+	last_seq BIGINT NOT NULL DEFAULT 0,
+	UNIQUE(user1_id, user2_id)
+);
+
+CREATE TABLE IF NOT EXISTS dh_parameters (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL UNIQUE REFERENCES chats(id) ON DELETE CASCADE,
+	p BLOB NOT NULL,
+	g BLOB NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE TABLE IF NOT EXISTS dh_globals (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	p BLOB NOT NULL,
+	g BLOB NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE TABLE IF NOT EXISTS dh_public_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	public_key BLOB NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	UNIQUE(chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	seq BIGINT NOT NULL,
+	ciphertext BLOB NOT NULL,
+	iv BLOB,
+	file_name VARCHAR(255),
+	mime_type VARCHAR(100),
+	reply_to_message_id BIGINT REFERENCES messages(id) ON DELETE SET NULL,
+	message_uuid VARCHAR(64),
+	preview BLOB,
+	preview_iv BLOB,
+	urgent BOOLEAN NOT NULL DEFAULT FALSE,
+	expires_at BIGINT,
+	delivered_at BIGINT,
+	read_at BIGINT,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id);
+CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages(sender_id);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id_created_at ON messages(chat_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id_id ON messages(chat_id, id);
+CREATE INDEX IF NOT EXISTS idx_messages_undelivered ON messages(chat_id, id) WHERE delivered_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_chat_id_message_uuid ON messages(chat_id, message_uuid) WHERE message_uuid IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_chat_id_seq ON messages(chat_id, seq);
+CREATE INDEX IF NOT EXISTS idx_messages_urgent_sender ON messages(sender_id, created_at) WHERE urgent;
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_chats_user1_id ON chats(user1_id);
+CREATE INDEX IF NOT EXISTS idx_chats_user2_id ON chats(user2_id);
+CREATE INDEX IF NOT EXISTS idx_chats_last_activity_at ON chats(last_activity_at DESC);
+CREATE INDEX IF NOT EXISTS idx_contacts_user1_id ON contacts(user1_id);
+CREATE INDEX IF NOT EXISTS idx_contacts_user2_id ON contacts(user2_id);
+
+CREATE TABLE IF NOT EXISTS session_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL UNIQUE REFERENCES chats(id) ON DELETE CASCADE,
+	session_key BLOB NOT NULL,
+	iv BLOB NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE TABLE IF NOT EXISTS chat_read_markers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	last_read_message_id BIGINT NOT NULL,
+	read_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	UNIQUE(chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_last_seen (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	last_opened_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	UNIQUE(chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_notification_prefs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	level VARCHAR(20) NOT NULL DEFAULT 'always',
+	sound_id VARCHAR(100) NOT NULL DEFAULT '',
+	updated_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	UNIQUE(chat_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_chat_notification_prefs_user_id ON chat_notification_prefs(user_id);
+
+CREATE TABLE IF NOT EXISTS chat_pins (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	pinned_by BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	pinned_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	UNIQUE(chat_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_drafts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	ciphertext BLOB NOT NULL,
+	iv BLOB NOT NULL,
+	updated_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	UNIQUE(chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	file_name VARCHAR(255) NOT NULL,
+	mime_type VARCHAR(100) NOT NULL,
+	total_size BIGINT NOT NULL,
+	received_size BIGINT NOT NULL DEFAULT 0,
+	chunk_count INT NOT NULL DEFAULT 0,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	updated_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE TABLE IF NOT EXISTS files (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	blob_key VARCHAR(255) NOT NULL UNIQUE,
+	file_name VARCHAR(255) NOT NULL,
+	mime_type VARCHAR(100) NOT NULL,
+	size BIGINT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
+CREATE INDEX IF NOT EXISTS idx_files_chat_id ON files(chat_id);
+
+CREATE TABLE IF NOT EXISTS message_attachments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	file_id BIGINT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+	UNIQUE(message_id, file_id)
+);
+CREATE INDEX IF NOT EXISTS idx_message_attachments_file_id ON message_attachments(file_id);
+
+CREATE TABLE IF NOT EXISTS file_thumbnails (
+	file_id BIGINT PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+	data BLOB NOT NULL,
+	width INT NOT NULL,
+	height INT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE TABLE IF NOT EXISTS blob_deletions (
+	blob_key VARCHAR(255) PRIMARY KEY,
+	queued_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE TABLE IF NOT EXISTS message_search_tokens (
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	token BLOB NOT NULL,
+	PRIMARY KEY (message_id, token)
+);
+CREATE INDEX IF NOT EXISTS idx_message_search_tokens_chat_token ON message_search_tokens(chat_id, token);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_messages_deleted_at;
+DROP INDEX IF EXISTS idx_users_deleted_at;
+
+ALTER TABLE messages DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN deleted_at;
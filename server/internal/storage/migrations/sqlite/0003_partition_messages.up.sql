@@ -0,0 +1,5 @@
+-- Messages are only partitioned on Postgres; SQLite has no partitioning.
+-- Attachments record their chat like on Postgres, where the foreign key to
+-- the partitioned messages table needs it.
+ALTER TABLE message_attachments ADD COLUMN chat_id INTEGER;
+UPDATE message_attachments SET chat_id = (SELECT m.chat_id FROM messages m WHERE m.id = message_attachments.message_id) WHERE chat_id IS NULL;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id);
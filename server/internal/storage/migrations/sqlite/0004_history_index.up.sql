@@ -0,0 +1,3 @@
+-- History pages are read through idx_messages_chat_id_id in either direction;
+-- the single-column chat_id index duplicated its prefix
+DROP INDEX IF EXISTS idx_messages_chat_id;
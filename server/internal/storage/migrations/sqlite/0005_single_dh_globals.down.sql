@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_dh_globals_singleton;
+ALTER TABLE dh_globals DROP COLUMN singleton;
@@ -0,0 +1,6 @@
+-- dh_globals holds exactly one parameter set. Gateways starting at the same
+-- time could each insert their own; keep the first, which is the one that
+-- has been served, and let the unique singleton column reject any other.
+DELETE FROM dh_globals WHERE id > (SELECT min_id FROM (SELECT MIN(id) AS min_id FROM dh_globals) t);
+ALTER TABLE dh_globals ADD COLUMN singleton BOOLEAN NOT NULL DEFAULT TRUE CHECK (singleton);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dh_globals_singleton ON dh_globals(singleton);
@@ -0,0 +1,27 @@
+-- Cold storage: the retention worker moves old messages here to keep the
+-- messages table small. History reads include it on request.
+CREATE TABLE IF NOT EXISTS messages_archive (
+	id INTEGER PRIMARY KEY,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	seq BIGINT NOT NULL,
+	ciphertext BLOB NOT NULL,
+	iv BLOB,
+	file_name VARCHAR(255),
+	mime_type VARCHAR(100),
+	reply_to_message_id BIGINT,
+	message_uuid VARCHAR(64),
+	preview BLOB,
+	preview_iv BLOB,
+	urgent BOOLEAN NOT NULL DEFAULT FALSE,
+	expires_at BIGINT,
+	delivered_at BIGINT,
+	read_at BIGINT,
+	created_at BIGINT NOT NULL,
+	deleted_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_messages_archive_chat_id_id ON messages_archive(chat_id, id);
+CREATE INDEX IF NOT EXISTS idx_messages_archive_created_at ON messages_archive(created_at);
+
+-- Messages that are replied to are not archived
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages(chat_id, reply_to_message_id) WHERE reply_to_message_id IS NOT NULL;
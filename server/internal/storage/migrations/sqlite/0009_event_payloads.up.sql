@@ -0,0 +1,9 @@
+-- Events relayed between gateways with LISTEN/NOTIFY that exceed the NOTIFY
+-- payload limit are stored here and the notification carries the row id.
+-- Only Postgres relays events; the table exists so the schemas stay alike.
+CREATE TABLE IF NOT EXISTS event_payloads (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	payload TEXT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
+CREATE INDEX IF NOT EXISTS idx_event_payloads_created_at ON event_payloads(created_at);
@@ -0,0 +1,9 @@
+-- Shard map for message sharding: the message shard holding each chat's
+-- messages. Only Postgres shards messages; the table exists so the schemas
+-- stay alike.
+CREATE TABLE IF NOT EXISTS chat_shards (
+	chat_id BIGINT PRIMARY KEY REFERENCES chats(id) ON DELETE CASCADE,
+	shard_id INT NOT NULL,
+	moved_at BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_chat_shards_shard_id ON chat_shards(shard_id);
@@ -0,0 +1,7 @@
+-- Ed25519 identity key of each user, which signs the DH public keys they
+-- publish
+ALTER TABLE users ADD COLUMN identity_key BLOB;
+-- Signed DH public keys: the publisher's epoch for the key and the signature
+-- over chat id, epoch and key; copied registration keys have neither
+ALTER TABLE dh_public_keys ADD COLUMN epoch BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE dh_public_keys ADD COLUMN signature BLOB;
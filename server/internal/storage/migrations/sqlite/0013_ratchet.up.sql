@@ -0,0 +1,19 @@
+-- Double Ratchet header of each message, NULL for messages under the static
+-- chat key
+ALTER TABLE messages ADD COLUMN ratchet_header BLOB;
+ALTER TABLE messages_archive ADD COLUMN ratchet_header BLOB;
+
+-- The ratchet public keys each sender used in a chat, numbered by epoch, with
+-- the highest message number seen under each; a message may only move its
+-- sender's ratchet forward
+CREATE TABLE IF NOT EXISTS ratchet_chains (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	epoch BIGINT NOT NULL,
+	public_key BLOB NOT NULL,
+	last_n BIGINT NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	UNIQUE(chat_id, sender_id, epoch),
+	UNIQUE(chat_id, sender_id, public_key)
+);
@@ -0,0 +1,12 @@
+-- SAS confirmations: a participant confirmed that the short code of the key
+-- exchange matched on both devices. transcript is the hash of the key
+-- exchange they confirmed; a chat is verified while both confirmations
+-- match its current keys.
+CREATE TABLE IF NOT EXISTS chat_verifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	transcript BLOB NOT NULL,
+	confirmed_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	UNIQUE(chat_id, user_id)
+);
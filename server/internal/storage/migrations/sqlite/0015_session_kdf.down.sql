@@ -0,0 +1 @@
+ALTER TABLE dh_parameters DROP COLUMN kdf;
@@ -0,0 +1,11 @@
+-- State of the key exchange of a chat: awaiting_initiator until a participant
+-- publishes a key, awaiting_peer until the other does, then complete, or
+-- stale once a published key no longer verifies against its owner's identity
+-- key. Existing chats start from the keys they hold.
+ALTER TABLE chats ADD COLUMN dh_state VARCHAR(24) NOT NULL DEFAULT 'awaiting_initiator';
+ALTER TABLE chats ADD COLUMN dh_state_changed_at BIGINT NOT NULL DEFAULT 0;
+UPDATE chats SET dh_state = CASE (SELECT COUNT(*) FROM dh_public_keys k WHERE k.chat_id = chats.id)
+	WHEN 0 THEN 'awaiting_initiator'
+	WHEN 1 THEN 'awaiting_peer'
+	ELSE 'complete'
+END;
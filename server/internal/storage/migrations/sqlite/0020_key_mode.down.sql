@@ -0,0 +1 @@
+ALTER TABLE chats DROP COLUMN key_mode;
@@ -0,0 +1,11 @@
+-- The key bundle of a user sealed under a backup passphrase separate from
+-- their login password (crypto.SealKeyBackup), for moving to a new device.
+-- The server cannot open it; kdf repeats the Argon2id parameters from the
+-- sealed header. One backup per user, replaced by every upload.
+CREATE TABLE IF NOT EXISTS key_backups (
+	user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	backup BLOB NOT NULL,
+	kdf VARCHAR(255) NOT NULL,
+	created_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+	updated_at BIGINT NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);
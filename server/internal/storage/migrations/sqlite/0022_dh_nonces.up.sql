@@ -0,0 +1,13 @@
+-- Replay protection for the key exchange. Every dh/init and dh/exchange
+-- submission carries a client nonce and timestamp; a nonce is accepted once
+-- per chat and participant, and is kept only as long as its timestamp would
+-- still be accepted. published_at is the timestamp of the submission that
+-- published a key, so that an older submission cannot replace it.
+CREATE TABLE IF NOT EXISTS dh_exchange_nonces (
+	chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	nonce VARCHAR(64) NOT NULL,
+	created_at BIGINT NOT NULL,
+	PRIMARY KEY (chat_id, user_id, nonce)
+);
+ALTER TABLE dh_public_keys ADD COLUMN published_at BIGINT NOT NULL DEFAULT 0;
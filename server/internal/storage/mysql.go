@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// openMySQL connects to a MySQL 8 or MariaDB 10.5+ server. Migrations are
+// multi-statement scripts, and PIPES_AS_CONCAT makes || concatenate strings
+// like it does in Postgres.
+func openMySQL(cfg Config) (*sql.DB, error) {
+	dsn := fmt.Sprintf(
+		"%s:%s@tcp(%s:%d)/%s?multiStatements=true&sql_mode=%s",
+		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Database,
+		url.QueryEscape("CONCAT(@@sql_mode, ',PIPES_AS_CONCAT')"),
+	)
+	return sql.Open("mysql", dsn)
+}
+
+var (
+	// ON CONFLICT (...) [WHERE ...] DO UPDATE SET
+	mysqlUpsertRE = regexp.MustCompile(`(?s)ON CONFLICT\s*(?:\([^)]*\))?(?:\s*WHERE\s.*?)?\s*DO UPDATE SET`)
+	// ON CONFLICT [(...)] [WHERE ...] DO NOTHING
+	mysqlDoNothingRE = regexp.MustCompile(`(?s)\s*ON CONFLICT\s*(?:\([^)]*\))?(?:\s*WHERE\s.*?)?\s*DO NOTHING`)
+)
+
+// mysqlReplacer maps the Postgres functions and literals used by the
+// queries in this package to their MySQL equivalents
+var mysqlReplacer = strings.NewReplacer(
+	"EXTRACT(EPOCH FROM NOW())::BIGINT", "UNIX_TIMESTAMP()",
+	"''::bytea", "X''",
+)
+
+// mysqlRebind rewrites a Postgres query for MySQL. MySQL placeholders are
+// positional, so the arguments are reordered (and repeated) to match.
+// ON DUPLICATE KEY UPDATE takes no WHERE, so an upsert with a WHERE after
+// DO UPDATE needs a MySQL form of its own, see mysqlSaveReadMarkerQuery.
+func mysqlRebind(query string, args []interface{}) (string, []interface{}) {
+	query = mysqlReplacer.Replace(query)
+	query = pgCastRE.ReplaceAllString(query, "")
+	query = mysqlUpsertRE.ReplaceAllString(query, "ON DUPLICATE KEY UPDATE")
+	if mysqlDoNothingRE.MatchString(query) {
+		query = mysqlDoNothingRE.ReplaceAllString(query, "")
+		query = strings.Replace(query, "INSERT INTO", "INSERT IGNORE INTO", 1)
+	}
+
+	var bound []interface{}
+	query = pgPlaceholderRE.ReplaceAllStringFunc(query, func(placeholder string) string {
+		n, _ := strconv.Atoi(placeholder[1:])
+		if n >= 1 && n <= len(args) {
+			bound = append(bound, args[n-1])
+		}
+		return "?"
+	})
+	return query, bound
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMySQLRebind(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		args     []interface{}
+		want     string
+		wantArgs []interface{}
+	}{
+		{
+			"placeholders are positional",
+			"UPDATE chats SET status = $2 WHERE id = $1 AND user1_id = $2",
+			[]interface{}{7, "closed"},
+			"UPDATE chats SET status = ? WHERE id = ? AND user1_id = ?",
+			[]interface{}{"closed", 7, "closed"},
+		},
+		{
+			"unreferenced arguments are dropped",
+			"SELECT id FROM messages WHERE chat_id = $1 AND id <= $3",
+			[]interface{}{1, 2, 3},
+			"SELECT id FROM messages WHERE chat_id = ? AND id <= ?",
+			[]interface{}{1, 3},
+		},
+		{
+			"upsert",
+			"INSERT INTO chat_drafts (chat_id, iv) VALUES ($1, $2)\n\t\tON CONFLICT (chat_id, user_id) DO UPDATE SET iv = $2",
+			[]interface{}{1, "iv"},
+			"INSERT INTO chat_drafts (chat_id, iv) VALUES (?, ?)\n\t\tON DUPLICATE KEY UPDATE iv = ?",
+			[]interface{}{1, "iv", "iv"},
+		},
+		{
+			"do nothing",
+			"INSERT INTO blob_deletions (blob_key) VALUES ($1) ON CONFLICT DO NOTHING",
+			[]interface{}{"k"},
+			"INSERT IGNORE INTO blob_deletions (blob_key) VALUES (?)",
+			[]interface{}{"k"},
+		},
+		{
+			"do nothing on a partial index",
+			"INSERT INTO messages (chat_id) VALUES ($1)\n\t\tON CONFLICT (chat_id, message_uuid) WHERE message_uuid IS NOT NULL DO NOTHING",
+			[]interface{}{1},
+			"INSERT IGNORE INTO messages (chat_id) VALUES (?)",
+			[]interface{}{1},
+		},
+		{
+			"read marker upsert",
+			mysqlSaveReadMarkerQuery,
+			[]interface{}{1, 2, 3, 4},
+			"INSERT INTO chat_read_markers (chat_id, user_id, last_read_message_id, read_at) VALUES (?, ?, ?, ?)\n\t\tON DUPLICATE KEY UPDATE read_at = IF(VALUES(last_read_message_id) > last_read_message_id, VALUES(read_at), read_at),\n\t\tlast_read_message_id = GREATEST(last_read_message_id, VALUES(last_read_message_id))",
+			[]interface{}{1, 2, 3, 4},
+		},
+		{
+			"functions and casts",
+			"SELECT COALESCE(iv, ''::bytea) FROM messages WHERE expires_at > EXTRACT(EPOCH FROM NOW())::BIGINT AND $1::INT > 0",
+			[]interface{}{5},
+			"SELECT COALESCE(iv, X'') FROM messages WHERE expires_at > UNIX_TIMESTAMP() AND ? > 0",
+			[]interface{}{5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := mysqlRebind(tt.query, tt.args)
+			if got != tt.want {
+				t.Fatalf("expected\n%s\ngot\n%s", tt.want, got)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Fatalf("expected args %v, got %v", tt.wantArgs, args)
+			}
+		})
+	}
+}
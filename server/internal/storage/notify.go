@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// EventsChannel is the Postgres notification channel that PublishEvent and
+// ListenEvents use
+const EventsChannel = "minmsgr_events"
+
+const (
+	// maxNotifyPayload stays below the 8000 byte limit of a NOTIFY payload;
+	// larger events go through event_payloads
+	maxNotifyPayload = 7900
+	// eventPayloadTTL is how long (seconds) a stored payload is kept for the
+	// listeners to fetch
+	eventPayloadTTL = 60
+	// payloadRefPrefix marks a notification carrying an event_payloads id
+	// rather than the event itself, which is always a JSON object
+	payloadRefPrefix = "ref:"
+)
+
+// ErrEventsUnsupported is returned by PublishEvent and ListenEvents on
+// drivers without LISTEN/NOTIFY
+var ErrEventsUnsupported = errors.New("event relay requires PostgreSQL")
+
+// PublishEvent sends payload to every ListenEvents caller on the database,
+// in every gateway instance including this one. Within a transaction the
+// notification is only sent on commit.
+func (db *DB) PublishEvent(ctx context.Context, payload []byte) error {
+	if db.driver != DriverPostgres {
+		return ErrEventsUnsupported
+	}
+
+	notification := string(payload)
+	if len(payload) > maxNotifyPayload {
+		if _, err := db.q.ExecContext(ctx,
+			"DELETE FROM event_payloads WHERE created_at < EXTRACT(EPOCH FROM NOW())::BIGINT - $1::BIGINT",
+			eventPayloadTTL,
+		); err != nil {
+			return err
+		}
+		id, _, err := db.insertID(ctx, db.q, "INSERT INTO event_payloads (payload) VALUES ($1)", notification)
+		if err != nil {
+			return err
+		}
+		notification = payloadRefPrefix + strconv.FormatInt(id, 10)
+	}
+
+	_, err := db.q.ExecContext(ctx, "SELECT pg_notify($1, $2)", EventsChannel, notification)
+	return err
+}
+
+// ListenEvents calls handle with every payload published with PublishEvent
+// until ctx is done. It listens on a dedicated connection that reconnects on
+// its own; events published while it is disconnected are lost.
+func (db *DB) ListenEvents(ctx context.Context, handle func(payload []byte)) error {
+	if db.driver != DriverPostgres {
+		return ErrEventsUnsupported
+	}
+
+	listener := pq.NewListener(db.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("[Storage] Event listener: %v", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(EventsChannel); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case n := <-listener.Notify:
+			if n == nil {
+				log.Printf("[Storage] Event listener reconnected; events published while it was disconnected were missed")
+				continue
+			}
+			payload, err := db.eventPayload(ctx, n.Extra)
+			if err != nil {
+				log.Printf("[Storage] Failed to read relayed event %s: %v", n.Extra, err)
+				continue
+			}
+			if payload != nil {
+				handle(payload)
+			}
+		case <-time.After(90 * time.Second):
+			// A quiet connection can die unnoticed; pinging it makes the
+			// listener notice and reconnect
+			go listener.Ping()
+		}
+	}
+}
+
+// eventPayload resolves a notification to its payload, fetching it from
+// event_payloads if needed. Returns nil if the stored payload has expired.
+func (db *DB) eventPayload(ctx context.Context, notification string) ([]byte, error) {
+	if !strings.HasPrefix(notification, payloadRefPrefix) {
+		return []byte(notification), nil
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(notification, payloadRefPrefix), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload string
+	err = db.q.QueryRowContext(ctx, "SELECT payload FROM event_payloads WHERE id = $1", id).Scan(&payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return []byte(payload), err
+}
@@ -1,196 +1,209 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
-// DB wraps the database connection and provides query methods
+// DB wraps the database connection and provides query methods. Queries are
+// written for Postgres and rewritten on the fly when running on SQLite or
+// MySQL.
 type DB struct {
-	conn *sql.DB
+	conn *sqlConn
+	// q runs the queries: conn, or the transaction of a WithTx callback
+	q        querier
+	tx       *sqlTx
+	replicas *replicaSet
+	driver   string
+	// dsn is the Postgres connection string, kept for ListenEvents, which
+	// needs a connection of its own
+	dsn string
+	// shards routes chat messages to the message shards (nil without
+	// sharding); the primary and the shards share it
+	shards *shardSet
+	// shardID is the message shard this DB is, 0 for the primary, and addr
+	// its host
+	shardID int
+	addr    string
+	// commitHooks holds work deferred until the WithTx transaction commits
+	commitHooks *[]func()
 }
 
-// Config contains database connection configuration
+// Config contains database connection configuration. Driver selects the
+// backend (DriverPostgres if empty); Path is the SQLite database file, the
+// other connection fields are used by Postgres and MySQL.
+//
+// The pool settings map to the database/sql setters; zero keeps the
+// database/sql default for MaxOpenConns (unlimited) and ConnMaxLifetime
+// (forever), while MaxIdleConns of zero keeps no idle connections. SQLite
+// ignores them and always uses a single connection. PingTimeout bounds the
+// connectivity check in New (default 5s).
+//
+// Queries taking longer than SlowQueryThreshold are logged with their
+// arguments redacted; zero disables the log. Latencies are recorded either way
+// (see QueryLatencies).
 type Config struct {
+	Driver   string
+	Path     string
 	Host     string
 	Port     int
 	User     string
 	Password string
 	Database string
 	SSLMode  string
+	// ReplicaHosts lists read replicas as "host" or "host:port" (Port if
+	// omitted); they share the credentials and pool settings of the primary.
+	// Not supported by SQLite.
+	ReplicaHosts []string
+	// MessageShards lists the message shards as "host" or "host:port", like
+	// ReplicaHosts; shard n is the nth entry, so entries may only be appended.
+	// Postgres only.
+	MessageShards []string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	PingTimeout     time.Duration
+
+	SlowQueryThreshold time.Duration
 }
 
 // New creates a new database connection
 func New(cfg Config) (*DB, error) {
-	connStr := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Database, cfg.SSLMode,
-	)
-
-	conn, err := sql.Open("postgres", connStr)
+	var conn *sql.DB
+	var err error
+	db := &DB{driver: cfg.Driver}
+	rebind := noRebind
+
+	switch cfg.Driver {
+	case "", DriverPostgres:
+		db.driver = DriverPostgres
+		db.dsn = postgresDSN(cfg)
+		conn, err = openPostgres(cfg)
+	case DriverSQLite:
+		conn, err = openSQLite(cfg.Path)
+		rebind = sqliteRebind
+	case DriverMySQL:
+		conn, err = openMySQL(cfg)
+		rebind = mysqlRebind
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if db.driver != DriverSQLite {
+		configurePool(conn, cfg)
+	}
+
 	// Test the connection
-	if err := conn.Ping(); err != nil {
+	if err := ping(conn, cfg.PingTimeout); err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	return &DB{conn: conn}, nil
+	stats := newQueryStats(cfg.SlowQueryThreshold)
+	db.conn = &sqlConn{DB: conn, rebind: rebind, stats: stats}
+	db.q = db.conn
+
+	if len(cfg.ReplicaHosts) > 0 {
+		if db.driver == DriverSQLite {
+			conn.Close()
+			return nil, fmt.Errorf("read replicas are not supported by %s", DriverSQLite)
+		}
+		if db.replicas, err = openReplicas(cfg, db.driver, rebind, stats); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if len(cfg.MessageShards) > 0 {
+		if db.driver != DriverPostgres {
+			db.replicas.close()
+			conn.Close()
+			return nil, ErrShardingUnsupported
+		}
+		if db.shards, err = openShards(cfg, db, stats); err != nil {
+			db.replicas.close()
+			conn.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// configurePool applies the pool settings of cfg
+func configurePool(conn *sql.DB, cfg Config) {
+	conn.SetMaxOpenConns(cfg.MaxOpenConns)
+	conn.SetMaxIdleConns(cfg.MaxIdleConns)
+	conn.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+}
+
+// ping checks the connection, giving up after timeout (5s if not set)
+func ping(conn *sql.DB, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return conn.PingContext(ctx)
+}
+
+func openPostgres(cfg Config) (*sql.DB, error) {
+	return sql.Open("postgres", postgresDSN(cfg))
+}
+
+func postgresDSN(cfg Config) string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Database, cfg.SSLMode,
+	)
 }
 
 // Close closes the database connection
 func (db *DB) Close() error {
+	db.closeStatements()
+	db.replicas.close()
+	db.shards.close()
 	return db.conn.Close()
 }
 
-// InitSchema creates all database tables
-func (db *DB) InitSchema() error {
-	schema := `
-	-- Users table
-	CREATE TABLE IF NOT EXISTS users (
-		id BIGSERIAL PRIMARY KEY,
-		username VARCHAR(255) UNIQUE NOT NULL,
-		hashed_password VARCHAR(255) NOT NULL,
-		public_key BYTEA,
-		encrypted_private_key BYTEA,
-		created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
-		updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
-	);
-
-	-- Contacts table
-	CREATE TABLE IF NOT EXISTS contacts (
-		id BIGSERIAL PRIMARY KEY,
-		user1_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		user2_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		requester_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		status VARCHAR(50) NOT NULL DEFAULT 'pending',
-		created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
-		updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
-		UNIQUE(user1_id, user2_id),
-		CHECK(user1_id < user2_id)
-	);
-
-	-- Chats table
-	CREATE TABLE IF NOT EXISTS chats (
-		id BIGSERIAL PRIMARY KEY,
-		user1_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		user2_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		algorithm VARCHAR(50) NOT NULL,
-		mode VARCHAR(50) NOT NULL,
-		padding VARCHAR(50) NOT NULL,
-		status VARCHAR(50) NOT NULL DEFAULT 'active',
-		created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
-		closed_at BIGINT,
-		updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
-		UNIQUE(user1_id, user2_id)
-	);
-
-	-- DH Parameters table (stores p, g for each chat)
-	CREATE TABLE IF NOT EXISTS dh_parameters (
-		id BIGSERIAL PRIMARY KEY,
-		chat_id BIGINT NOT NULL UNIQUE REFERENCES chats(id) ON DELETE CASCADE,
-		p BYTEA NOT NULL,
-		g BYTEA NOT NULL,
-		created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
-	);
-
-	-- Global DH parameters (single row)
-	CREATE TABLE IF NOT EXISTS dh_globals (
-		id BIGSERIAL PRIMARY KEY,
-		p BYTEA NOT NULL,
-		g BYTEA NOT NULL,
-		created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
-	);
-
-	-- DH Public Keys table (stores A and B public keys)
-	CREATE TABLE IF NOT EXISTS dh_public_keys (
-		id BIGSERIAL PRIMARY KEY,
-		chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
-		user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		public_key BYTEA NOT NULL,
-		created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
-		UNIQUE(chat_id, user_id)
-	);
-
-	-- Messages table
-	CREATE TABLE IF NOT EXISTS messages (
-		id BIGSERIAL PRIMARY KEY,
-		chat_id BIGINT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
-		sender_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		ciphertext BYTEA NOT NULL,
-		created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
-	);
-
-	-- Indexes for performance
-	CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id);
-	CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages(sender_id);
-	CREATE INDEX IF NOT EXISTS idx_chats_user1_id ON chats(user1_id);
-	CREATE INDEX IF NOT EXISTS idx_chats_user2_id ON chats(user2_id);
-	CREATE INDEX IF NOT EXISTS idx_contacts_user1_id ON contacts(user1_id);
-	CREATE INDEX IF NOT EXISTS idx_contacts_user2_id ON contacts(user2_id);
-	`
-
-	_, err := db.conn.Exec(schema)
-	if err != nil {
+// InitSchema brings the database schema up to date by applying all pending
+// migrations (see migrations/ and cmd/migrate), on the message shards as
+// well, and then prepares the hot statements, which need the final schema
+func (db *DB) InitSchema(ctx context.Context) error {
+	if _, err := db.MigrateUp(ctx, 0); err != nil {
 		return err
 	}
-
-	// Ensure any added columns from migrations exist (for running against older DBs)
-	alterStmts := []string{
-		"ALTER TABLE users ADD COLUMN IF NOT EXISTS public_key BYTEA",
-		"ALTER TABLE users ADD COLUMN IF NOT EXISTS encrypted_private_key BYTEA",
-		"ALTER TABLE dh_parameters ADD COLUMN IF NOT EXISTS p BYTEA",
-		"ALTER TABLE dh_parameters ADD COLUMN IF NOT EXISTS g BYTEA",
-		"ALTER TABLE dh_parameters DROP COLUMN IF EXISTS public_key",
-		"ALTER TABLE dh_parameters ADD COLUMN IF NOT EXISTS user_id BIGINT",
-		"ALTER TABLE dh_parameters ALTER COLUMN user_id DROP NOT NULL",
-		"ALTER TABLE contacts ADD COLUMN IF NOT EXISTS requester_id BIGINT",
-		"UPDATE contacts SET requester_id = user1_id WHERE requester_id IS NULL",
-		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS iv BYTEA",
-		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS file_name VARCHAR(255)",
-		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS mime_type VARCHAR(100)",
-		`CREATE TABLE IF NOT EXISTS session_keys (
-			id BIGSERIAL PRIMARY KEY,
-			chat_id BIGINT NOT NULL UNIQUE REFERENCES chats(id) ON DELETE CASCADE,
-			session_key BYTEA NOT NULL,
-			iv BYTEA NOT NULL,
-			created_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
-		)`,
-	}
-
-	for _, s := range alterStmts {
-		if _, err := db.conn.Exec(s); err != nil {
-			return err
-		}
+	if err := db.shards.initSchemas(ctx); err != nil {
+		return err
 	}
-
-	return nil
+	return db.prepareStatements(ctx)
 }
 
 // User operations
 
 // CreateUser creates a new user with hashed password
-func (db *DB) CreateUser(username, hashedPassword string) (int64, error) {
-	var id int64
-	err := db.conn.QueryRow(
-		"INSERT INTO users (username, hashed_password, public_key, encrypted_private_key) VALUES ($1, $2, $3, $4) RETURNING id",
+func (db *DB) CreateUser(ctx context.Context, username, hashedPassword string) (int64, error) {
+	id, _, err := db.insertID(ctx, db.q,
+		"INSERT INTO users (username, hashed_password, public_key, encrypted_private_key) VALUES ($1, $2, $3, $4)",
 		username, hashedPassword, nil, nil,
-	).Scan(&id)
+	)
 	return id, err
 }
 
 // GetUserByID retrieves a user by ID
-func (db *DB) GetUserByID(userID int64) (*User, error) {
+func (db *DB) GetUserByID(ctx context.Context, userID int64) (*User, error) {
 	user := &User{}
-	err := db.conn.QueryRow(
-		"SELECT id, username, hashed_password, public_key, encrypted_private_key, created_at FROM users WHERE id = $1",
-		userID,
-	).Scan(&user.ID, &user.Username, &user.HashedPassword, &user.PublicKey, &user.EncryptedPrivateKey, &user.CreatedAt)
+	err := db.q.QueryRowContext(ctx, getUserByIDQuery, userID).Scan(&user.ID, &user.Username, &user.HashedPassword, &user.PublicKey, &user.EncryptedPrivateKey, &user.CreatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -198,11 +211,11 @@ func (db *DB) GetUserByID(userID int64) (*User, error) {
 	return user, err
 }
 
-// GetUserByUsername retrieves a user by username
-func (db *DB) GetUserByUsername(username string) (*User, error) {
+// GetUserByUsername retrieves a user by username, ignoring case
+func (db *DB) GetUserByUsername(ctx context.Context, username string) (*User, error) {
 	user := &User{}
-	err := db.conn.QueryRow(
-		"SELECT id, username, hashed_password, public_key, encrypted_private_key, created_at FROM users WHERE username = $1",
+	err := db.q.QueryRowContext(ctx,
+		"SELECT id, username, hashed_password, public_key, encrypted_private_key, created_at FROM users WHERE LOWER(username) = LOWER($1) AND deleted_at IS NULL",
 		username,
 	).Scan(&user.ID, &user.Username, &user.HashedPassword, &user.PublicKey, &user.EncryptedPrivateKey, &user.CreatedAt)
 
@@ -215,243 +228,1312 @@ func (db *DB) GetUserByUsername(username string) (*User, error) {
 // Contact operations
 
 // AddContact creates a contact relationship between two users with requester ID
-func (db *DB) AddContact(userID1, userID2 int64, status string) (int64, error) {
+func (db *DB) AddContact(ctx context.Context, userID1, userID2 int64, status string) (int64, error) {
 	// Ensure consistent ordering and track the requester
 	requesterID := userID1
 	if userID1 > userID2 {
 		userID1, userID2 = userID2, userID1
 	}
 
-	var id int64
-	err := db.conn.QueryRow(
-		"INSERT INTO contacts (user1_id, user2_id, requester_id, status) VALUES ($1, $2, $3, $4) RETURNING id",
-		userID1, userID2, requesterID, status,
-	).Scan(&id)
-	return id, err
+	id, _, err := db.insertID(ctx, db.q,
+		"INSERT INTO contacts (user1_id, user2_id, requester_id, status) VALUES ($1, $2, $3, $4)",
+		userID1, userID2, requesterID, status,
+	)
+	return id, err
+}
+
+// GetContact retrieves a contact relationship
+func (db *DB) GetContact(ctx context.Context, userID1, userID2 int64) (*Contact, error) {
+	if userID1 > userID2 {
+		userID1, userID2 = userID2, userID1
+	}
+
+	contact := &Contact{}
+	err := db.q.QueryRowContext(ctx, getContactQuery, userID1, userID2).Scan(&contact.ID, &contact.User1ID, &contact.User2ID, &contact.RequesterID, &contact.Status, &contact.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return contact, err
+}
+
+// UpdateContactStatus updates the status of a contact relationship
+func (db *DB) UpdateContactStatus(ctx context.Context, contactID int64, status string) error {
+	_, err := db.q.ExecContext(ctx,
+		"UPDATE contacts SET status = $1, updated_at = $2 WHERE id = $3",
+		status, time.Now().Unix(), contactID,
+	)
+	return err
+}
+
+// ListUserContacts lists all contacts of a user with given status
+func (db *DB) ListUserContacts(ctx context.Context, userID int64, status string) ([]*Contact, error) {
+	rows, err := db.queryRead(ctx,
+		`SELECT id, user1_id, user2_id, requester_id, status, created_at FROM contacts WHERE (user1_id = $1 OR user2_id = $1) AND status = $2
+		AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id IN (contacts.user1_id, contacts.user2_id) AND u.deleted_at IS NOT NULL)`,
+		userID, status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []*Contact
+	for rows.Next() {
+		contact := &Contact{}
+		err := rows.Scan(&contact.ID, &contact.User1ID, &contact.User2ID, &contact.RequesterID, &contact.Status, &contact.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+
+	return contacts, rows.Err()
+}
+
+// DeleteContact deletes a contact relationship
+func (db *DB) DeleteContact(ctx context.Context, contactID int64) error {
+	_, err := db.q.ExecContext(ctx, "DELETE FROM contacts WHERE id = $1", contactID)
+	return err
+}
+
+// Chat operations
+
+// ErrChatVersionConflict is returned by the chat updates that take a version
+// when another update got there first
+var ErrChatVersionConflict = errors.New("chat was modified concurrently, reload it and retry")
+
+// CreateChat creates a new encrypted chat of the given type ("direct" or "self")
+func (db *DB) CreateChat(ctx context.Context, userID1, userID2 int64, chatType, algorithm, mode, padding string) (int64, error) {
+	if userID1 > userID2 {
+		userID1, userID2 = userID2, userID1
+	}
+
+	id, _, err := db.insertID(ctx, db.q,
+		"INSERT INTO chats (user1_id, user2_id, chat_type, algorithm, mode, padding) VALUES ($1, $2, $3, $4, $5, $6)",
+		userID1, userID2, chatType, algorithm, mode, padding,
+	)
+	return id, err
+}
+
+// UpdateChatEncryption updates the encryption algorithm, mode, and padding for
+// a chat at the given version. Returns ErrChatVersionConflict if the chat has
+// changed since.
+func (db *DB) UpdateChatEncryption(ctx context.Context, chatID, version int64, algorithm, mode, padding string) error {
+	return db.updateChatVersion(ctx, chatID,
+		"UPDATE chats SET algorithm = $1, mode = $2, padding = $3, updated_at = EXTRACT(EPOCH FROM NOW())::BIGINT, version = version + 1 WHERE id = $4 AND version = $5",
+		algorithm, mode, padding, chatID, version,
+	)
+}
+
+// GetChat retrieves a chat by ID
+func (db *DB) GetChat(ctx context.Context, chatID int64) (*Chat, error) {
+	chat := &Chat{}
+	err := db.q.QueryRowContext(ctx, getChatQuery, chatID).Scan(&chat.ID, &chat.User1ID, &chat.User2ID, &chat.ChatType, &chat.Algorithm, &chat.Mode, &chat.Padding, &chat.Status, &chat.CreatedAt, &chat.ClosedAt, &chat.LastActivityAt, &chat.LastSeq, &chat.RetentionDays, &chat.RetentionMaxMessages, &chat.HistoryRetained, &chat.Version)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return chat, err
+}
+
+// ListUserChats lists all active chats for a user, most recently active first
+func (db *DB) ListUserChats(ctx context.Context, userID int64) ([]*Chat, error) {
+	rows, err := db.queryRead(ctx,
+		"SELECT id, user1_id, user2_id, chat_type, algorithm, mode, padding, status, created_at, last_activity_at, last_seq FROM chats WHERE (user1_id = $1 OR user2_id = $1) AND status = 'active' ORDER BY last_activity_at DESC, id DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []*Chat
+	for rows.Next() {
+		chat := &Chat{}
+		err := rows.Scan(&chat.ID, &chat.User1ID, &chat.User2ID, &chat.ChatType, &chat.Algorithm, &chat.Mode, &chat.Padding, &chat.Status, &chat.CreatedAt, &chat.LastActivityAt, &chat.LastSeq)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+
+	return chats, rows.Err()
+}
+
+// GetChatByUsers retrieves an existing chat between two users (any status)
+func (db *DB) GetChatByUsers(ctx context.Context, userID1, userID2 int64) (*Chat, error) {
+	if userID1 > userID2 {
+		userID1, userID2 = userID2, userID1
+	}
+
+	chat := &Chat{}
+	err := db.q.QueryRowContext(ctx,
+		"SELECT id, user1_id, user2_id, chat_type, algorithm, mode, padding, status, created_at, closed_at, last_activity_at, last_seq, retention_days, retention_max_messages, history_retained, version FROM chats WHERE user1_id = $1 AND user2_id = $2",
+		userID1, userID2,
+	).Scan(&chat.ID, &chat.User1ID, &chat.User2ID, &chat.ChatType, &chat.Algorithm, &chat.Mode, &chat.Padding, &chat.Status, &chat.CreatedAt, &chat.ClosedAt, &chat.LastActivityAt, &chat.LastSeq, &chat.RetentionDays, &chat.RetentionMaxMessages, &chat.HistoryRetained, &chat.Version)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return chat, err
+}
+
+// UpdateChatRetention sets how long a chat keeps its messages. A value of 0
+// disables the corresponding limit.
+func (db *DB) UpdateChatRetention(ctx context.Context, chatID int64, days, maxMessages int) error {
+	_, err := db.q.ExecContext(ctx,
+		"UPDATE chats SET retention_days = $1, retention_max_messages = $2, updated_at = EXTRACT(EPOCH FROM NOW())::BIGINT WHERE id = $3",
+		days, maxMessages, chatID,
+	)
+	if err != nil {
+		return err
+	}
+	db.syncChatMirror(ctx, chatID)
+	return nil
+}
+
+// ReopenChat reopens a closed chat (set status to 'active' and clear closed_at).
+// The reopened chat counts as activity so it moves to the top of the chat list.
+// Returns ErrChatVersionConflict if the chat is no longer closed at the given
+// version.
+func (db *DB) ReopenChat(ctx context.Context, chatID, version int64) error {
+	return db.updateChatVersion(ctx, chatID,
+		"UPDATE chats SET status = 'active', closed_at = NULL, history_retained = FALSE, updated_at = $1, last_activity_at = $1, version = version + 1 WHERE id = $2 AND status = 'closed' AND version = $3",
+		time.Now().Unix(), chatID, version,
+	)
+}
+
+// CloseChat closes a chat at the given version. keepHistory records that the
+// chat was soft closed, i.e. its messages were kept and should come back when
+// it is reopened. Returns ErrChatVersionConflict if the chat has changed since.
+func (db *DB) CloseChat(ctx context.Context, chatID, version int64, keepHistory bool) error {
+	return db.updateChatVersion(ctx, chatID,
+		"UPDATE chats SET status = 'closed', closed_at = $1, updated_at = $1, history_retained = $2, version = version + 1 WHERE id = $3 AND version = $4",
+		time.Now().Unix(), keepHistory, chatID, version,
+	)
+}
+
+// updateChatVersion runs a compare-and-swap update of a chat and reports
+// ErrChatVersionConflict if it matched no row
+func (db *DB) updateChatVersion(ctx context.Context, chatID int64, query string, args ...interface{}) error {
+	result, err := db.q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrChatVersionConflict
+	}
+	db.syncChatMirror(ctx, chatID)
+	return nil
+}
+
+// DeleteChat permanently removes a chat together with its messages and all key
+// material (DH parameters, DH public keys, session keys) in a single transaction
+func (db *DB) DeleteChat(ctx context.Context, chatID int64) error {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var shard int
+	if db.shards != nil && db.shardID == 0 {
+		if shard, err = db.chatShard(ctx, chatID); err != nil {
+			return err
+		}
+	}
+	deleted, err := deleteChatRows(ctx, tx, chatID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return sql.ErrNoRows
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.dropChatFromShard(ctx, chatID, shard)
+	return nil
+}
+
+// deleteChatRows deletes a chat and every row that belongs to it within tx.
+// Returns false if the chat does not exist.
+func deleteChatRows(ctx context.Context, tx *sqlTx, chatID int64) (bool, error) {
+	// Blobs live outside the database; queue them for the file janitor
+	if err := queueChatBlobDeletions(ctx, tx, chatID); err != nil {
+		return false, err
+	}
+
+	// Delete dependent rows explicitly rather than relying on ON DELETE CASCADE,
+	// so older databases created without the cascade are cleaned up as well
+	stmts := []string{
+		"DELETE FROM message_attachments WHERE chat_id = $1",
+		"DELETE FROM message_search_tokens WHERE chat_id = $1",
+		"DELETE FROM file_thumbnails WHERE file_id IN (SELECT id FROM files WHERE chat_id = $1)",
+		"DELETE FROM files WHERE chat_id = $1",
+		"DELETE FROM upload_sessions WHERE chat_id = $1",
+		"DELETE FROM chat_pins WHERE chat_id = $1",
+		"DELETE FROM messages WHERE chat_id = $1",
+		"DELETE FROM messages_archive WHERE chat_id = $1",
+		"DELETE FROM chat_read_markers WHERE chat_id = $1",
+		"DELETE FROM chat_last_seen WHERE chat_id = $1",
+		"DELETE FROM chat_notification_prefs WHERE chat_id = $1",
+		"DELETE FROM chat_drafts WHERE chat_id = $1",
+		"DELETE FROM ratchet_chains WHERE chat_id = $1",
+		"DELETE FROM chat_verifications WHERE chat_id = $1",
+		"DELETE FROM chat_key_shares WHERE chat_id = $1",
+		"DELETE FROM session_keys WHERE chat_id = $1",
+		"DELETE FROM dh_public_keys WHERE chat_id = $1",
+		"DELETE FROM dh_exchange_nonces WHERE chat_id = $1",
+		"DELETE FROM dh_parameters WHERE chat_id = $1",
+		"DELETE FROM chat_shards WHERE chat_id = $1",
+	}
+	for _, s := range stmts {
+		if _, err := tx.ExecContext(ctx, s, chatID); err != nil {
+			return false, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM chats WHERE id = $1", chatID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+// queueChatBlobDeletions queues the blobs of a chat's files and of the chunks
+// of its unfinished uploads for the file janitor
+func queueChatBlobDeletions(ctx context.Context, tx *sqlTx, chatID int64) error {
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO blob_deletions (blob_key) SELECT blob_key FROM files WHERE chat_id = $1 ON CONFLICT DO NOTHING",
+		chatID,
+	); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, chunk_count FROM upload_sessions WHERE chat_id = $1", chatID)
+	if err != nil {
+		return err
+	}
+	chunkCounts := make(map[string]int)
+	for rows.Next() {
+		var sessionID string
+		var chunkCount int
+		if err := rows.Scan(&sessionID, &chunkCount); err != nil {
+			rows.Close()
+			return err
+		}
+		chunkCounts[sessionID] = chunkCount
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for sessionID, chunkCount := range chunkCounts {
+		for n := 0; n < chunkCount; n++ {
+			if _, err := tx.ExecContext(ctx,
+				"INSERT INTO blob_deletions (blob_key) VALUES ($1) ON CONFLICT DO NOTHING",
+				fmt.Sprintf("uploads/%s/%d", sessionID, n),
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TouchChatLastSeen records that a user opened a chat now and returns the stored timestamp
+func (db *DB) TouchChatLastSeen(ctx context.Context, chatID, userID int64) (int64, error) {
+	lastOpenedAt := time.Now().Unix()
+	_, err := db.q.ExecContext(ctx,
+		`INSERT INTO chat_last_seen (chat_id, user_id, last_opened_at) VALUES ($1, $2, $3)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET last_opened_at = $3`,
+		chatID, userID, lastOpenedAt,
+	)
+	return lastOpenedAt, err
+}
+
+// GetChatLastSeen retrieves when each participant last opened a chat
+func (db *DB) GetChatLastSeen(ctx context.Context, chatID int64) ([]*ChatLastSeen, error) {
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT chat_id, user_id, last_opened_at FROM chat_last_seen WHERE chat_id = $1 ORDER BY user_id",
+		chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seen []*ChatLastSeen
+	for rows.Next() {
+		ls := &ChatLastSeen{}
+		if err := rows.Scan(&ls.ChatID, &ls.UserID, &ls.LastOpenedAt); err != nil {
+			return nil, err
+		}
+		seen = append(seen, ls)
+	}
+
+	return seen, rows.Err()
+}
+
+// Message operations
+
+// SaveMessage saves an encrypted message under the chat's next sequence
+// number, links its uploaded files to it and bumps the chat's
+// last_activity_at, all in one transaction. If m has a MessageUUID the chat
+// already holds, nothing is stored and that message's ID and sequence number
+// are returned with created set to false.
+func (db *DB) SaveMessage(ctx context.Context, m *NewMessage) (id, seq int64, created bool, err error) {
+	for attempt := 1; ; attempt++ {
+		var mdb *DB
+		if mdb, err = db.messageWriteDB(ctx, m.ChatID); err != nil {
+			return 0, 0, false, err
+		}
+		id, seq, created, err = mdb.saveMessage(ctx, m)
+		if !errors.Is(err, errChatMoved) || attempt == maxRouteAttempts {
+			return id, seq, created, err
+		}
+	}
+}
+
+func (db *DB) saveMessage(ctx context.Context, m *NewMessage) (id, seq int64, created bool, err error) {
+	chatID, senderID := m.ChatID, m.SenderID
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer tx.Rollback()
+
+	// Taking the next sequence number locks the chat row until commit, so
+	// concurrent senders are numbered in commit order without gaps
+	if _, err := tx.ExecContext(ctx, nextSeqQuery, chatID); err != nil {
+		return 0, 0, false, err
+	}
+	if err := tx.QueryRowContext(ctx, lastSeqQuery, chatID).Scan(&seq); err != nil {
+		return 0, 0, false, err
+	}
+	if err := db.confirmChatShard(ctx, chatID); err != nil {
+		return 0, 0, false, err
+	}
+
+	var uuid sql.NullString
+	if m.MessageUUID != "" {
+		uuid = sql.NullString{String: m.MessageUUID, Valid: true}
+	}
+
+	var ratchetHeader []byte
+	if m.Ratchet != nil {
+		ratchetHeader = m.Ratchet.Header
+	}
+
+	createdAt := time.Now().Unix()
+	query := insertMessageQuery
+	args := []interface{}{chatID, senderID, m.Ciphertext, m.IV, m.FileName, m.MimeType, m.ReplyToID, uuid, seq, m.Preview, m.PreviewIV, m.Urgent, m.ExpiresAt, createdAt, ratchetHeader}
+	if db.shardID != 0 {
+		ids, err := db.shards.nextMessageIDs(ctx, 1)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		query, args = insertShardMessageQuery, append(args, ids[0])
+	}
+	id, inserted, err := db.insertID(ctx, tx, query, args...)
+	if err == nil && !inserted {
+		// Duplicate UUID: report the message that was stored the first time.
+		// Returning without commit rolls back the sequence number taken above.
+		err = tx.QueryRowContext(ctx,
+			"SELECT id, seq FROM messages WHERE chat_id = $1 AND message_uuid = $2",
+			chatID, m.MessageUUID,
+		).Scan(&id, &seq)
+		return id, seq, false, err
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	// Checked after the insert, so that a retry of a stored message finds
+	// it above instead of failing here
+	if m.Ratchet != nil {
+		if err := advanceRatchet(ctx, tx, chatID, senderID, m.Ratchet); err != nil {
+			return 0, 0, false, err
+		}
+	}
+
+	if db.shardID != 0 && len(m.AttachmentIDs) > 0 {
+		if err := db.shards.mirrorFiles(ctx, tx, chatID, m.AttachmentIDs); err != nil {
+			return 0, 0, false, err
+		}
+	}
+	for _, fileID := range m.AttachmentIDs {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO message_attachments (message_id, chat_id, file_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			id, chatID, fileID,
+		); err != nil {
+			return 0, 0, false, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, touchChatActivityQuery, createdAt, chatID); err != nil {
+		return 0, 0, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, false, err
+	}
+	if db.shardID != 0 {
+		db.shards.touchPrimaryChat(ctx, chatID, seq, createdAt)
+	}
+	return id, seq, true, nil
+}
+
+// DeleteChatMessages deletes all messages for a specific chat, archived ones
+// included
+func (db *DB) DeleteChatMessages(ctx context.Context, chatID int64) error {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return err
+	} else if mdb != db {
+		if db.commitHooks == nil {
+			return mdb.DeleteChatMessages(ctx, chatID)
+		}
+		// A shard cannot join the primary's transaction, so the messages are
+		// deleted once it has committed
+		db.afterCommit(func() {
+			if err := mdb.DeleteChatMessages(ctx, chatID); err != nil {
+				log.Printf("[Storage] Failed to delete the messages of chat %d on message shard %d: %v", chatID, mdb.shardID, err)
+			}
+		})
+		return nil
+	}
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM messages_archive WHERE chat_id = $1", chatID); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE chat_id = $1", chatID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Printf("[Storage] Deleted %d messages for chat %d\n", rowsAffected, chatID)
+	return nil
+}
+
+// GetChatMessages retrieves one page of a chat's messages using keyset
+// pagination on the message ID. With older set it returns up to limit messages
+// with an ID below cursor (the newest messages if cursor is 0); otherwise it
+// returns up to limit messages with an ID above cursor. Either way the page is
+// ordered oldest first. filter, if not nil, restricts the messages by metadata.
+func (db *DB) GetChatMessages(ctx context.Context, chatID, cursor int64, older bool, limit int, filter *MessageFilter) ([]*Message, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return nil, err
+	} else if mdb != db {
+		return mdb.GetChatMessages(ctx, chatID, cursor, older, limit, filter)
+	}
+
+	const columns = "id, chat_id, sender_id, ciphertext, COALESCE(iv, ''::bytea), COALESCE(file_name, ''), COALESCE(mime_type, ''), created_at, reply_to_message_id, delivered_at, read_at, COALESCE(message_uuid, ''), seq, preview, preview_iv, urgent, expires_at, ratchet_header"
+
+	args := []interface{}{chatID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	conds := []string{"chat_id = $1", "deleted_at IS NULL", "(expires_at IS NULL OR expires_at > EXTRACT(EPOCH FROM NOW())::BIGINT)"}
+	order := "ASC"
+	switch {
+	case older && cursor > 0:
+		conds = append(conds, "id < "+arg(cursor))
+		order = "DESC"
+	case older:
+		order = "DESC"
+	default:
+		conds = append(conds, "id > "+arg(cursor))
+	}
+	if filter != nil {
+		conds = append(conds, filter.conditions(arg)...)
+	}
+
+	// Pages are ordered by id, not created_at: created_at has one-second
+	// resolution, while ids grow with every insert, so messages sent within
+	// the same second keep their order. idx_messages_chat_id_id serves both
+	// directions; the latest page is read backwards from the end of the chat.
+	rows, err := db.queryRead(ctx,
+		"SELECT "+columns+" FROM "+messagesSource(filter != nil && filter.IncludeArchived)+" WHERE "+strings.Join(conds, " AND ")+" ORDER BY id "+order+" LIMIT "+arg(limit),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg := &Message{}
+		var replyTo, deliveredAt, readAt, expiresAt sql.NullInt64
+		err := rows.Scan(&msg.ID, &msg.ChatID, &msg.SenderID, &msg.Ciphertext, &msg.IV, &msg.FileName, &msg.MimeType, &msg.CreatedAt, &replyTo, &deliveredAt, &readAt, &msg.MessageUUID, &msg.Seq, &msg.Preview, &msg.PreviewIV, &msg.Urgent, &expiresAt, &msg.RatchetHeader)
+		if err != nil {
+			return nil, err
+		}
+		if replyTo.Valid {
+			msg.ReplyToMessageID = &replyTo.Int64
+		}
+		if expiresAt.Valid {
+			msg.ExpiresAt = &expiresAt.Int64
+		}
+		if deliveredAt.Valid {
+			msg.DeliveredAt = &deliveredAt.Int64
+		}
+		if readAt.Valid {
+			msg.ReadAt = &readAt.Int64
+		}
+		msg.Timestamp = msg.CreatedAt
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Older pages are read newest first; flip them so every page is chronological
+	if older {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	return messages, nil
+}
+
+// Media classes understood by MessageFilter
+const (
+	MediaImage    = "image"
+	MediaVideo    = "video"
+	MediaAudio    = "audio"
+	MediaDocument = "document" // any file that is not an image, video or audio
+)
+
+// mediaPrefixes maps the media classes to the MIME type prefix they match
+var mediaPrefixes = map[string]string{
+	MediaImage: "image/",
+	MediaVideo: "video/",
+	MediaAudio: "audio/",
+}
+
+// conditions builds the SQL conditions for the filter. arg registers a query
+// argument and returns its placeholder.
+func (f *MessageFilter) conditions(arg func(interface{}) string) []string {
+	// A message carries a file either inline (file_name/mime_type) or as attachments
+	const inlineFile = "COALESCE(file_name, '') <> ''"
+	const attachment = "SELECT 1 FROM message_attachments ma JOIN files f ON f.id = ma.file_id WHERE ma.message_id = messages.id"
+
+	var conds []string
+	if f.SenderID != 0 {
+		conds = append(conds, "sender_id = "+arg(f.SenderID))
+	}
+	if f.Since != 0 {
+		conds = append(conds, "created_at >= "+arg(f.Since))
+	}
+	if f.Until != 0 {
+		conds = append(conds, "created_at <= "+arg(f.Until))
+	}
+	if f.FromSeq != 0 {
+		conds = append(conds, "seq >= "+arg(f.FromSeq))
+	}
+	if f.ToSeq != 0 {
+		conds = append(conds, "seq <= "+arg(f.ToSeq))
+	}
+	if f.HasFile != nil {
+		hasFile := "(" + inlineFile + " OR EXISTS (" + attachment + "))"
+		if *f.HasFile {
+			conds = append(conds, hasFile)
+		} else {
+			conds = append(conds, "NOT "+hasFile)
+		}
+	}
+
+	if prefix, ok := mediaPrefixes[f.MediaClass]; ok {
+		pattern := arg(prefix + "%")
+		conds = append(conds, "(("+inlineFile+" AND COALESCE(mime_type, '') LIKE "+pattern+") OR EXISTS ("+attachment+" AND f.mime_type LIKE "+pattern+"))")
+	} else if f.MediaClass == MediaDocument {
+		notMedia := func(column string) string {
+			var parts []string
+			for _, prefix := range []string{"image/", "video/", "audio/"} {
+				parts = append(parts, column+" NOT LIKE '"+prefix+"%'")
+			}
+			return strings.Join(parts, " AND ")
+		}
+		conds = append(conds, "(("+inlineFile+" AND "+notMedia("COALESCE(mime_type, '')")+") OR EXISTS ("+attachment+" AND "+notMedia("f.mime_type")+"))")
+	}
+
+	return conds
+}
+
+// Candidate queries for retention purging. For the first two, $1 is the
+// server-wide max age (days) or max messages per chat; 0 disables the limit.
+// The stricter of the chat's own policy and the server-wide policy applies;
+// the CASE picks it (NULL when neither is set) in a way SQLite also supports.
+const (
+	expiredByAgeQuery = `
+		SELECT m.id FROM messages m
+		JOIN chats c ON c.id = m.chat_id
+		WHERE m.created_at < EXTRACT(EPOCH FROM NOW())::BIGINT
+			- (CASE WHEN c.retention_days > 0 AND (c.retention_days < $1::INT OR $1::INT = 0)
+				THEN c.retention_days ELSE NULLIF($1::INT, 0) END)::BIGINT * 86400`
+	expiredByCountQuery = `
+		SELECT id FROM (
+			SELECT m.id,
+				CASE WHEN c.retention_max_messages > 0 AND (c.retention_max_messages < $1::INT OR $1::INT = 0)
+					THEN c.retention_max_messages ELSE NULLIF($1::INT, 0) END AS max_messages,
+				ROW_NUMBER() OVER (PARTITION BY m.chat_id ORDER BY m.id DESC) AS rn
+			FROM messages m
+			JOIN chats c ON c.id = m.chat_id
+			WHERE c.retention_max_messages > 0 OR $1::INT > 0
+		) ranked
+		WHERE ranked.rn > ranked.max_messages`
+	expiredByTimestampQuery = `
+		SELECT id FROM messages
+		WHERE expires_at IS NOT NULL AND expires_at <= EXTRACT(EPOCH FROM NOW())::BIGINT`
+)
+
+// PurgeExpiredMessages deletes messages that fall outside their chat's
+// retention policy or the server-wide policy: messages older than the max
+// age, all but the newest max messages of each chat, and messages whose own
+// expiry has passed. Soft-deleted messages and users older than
+// policy.TombstoneDays are removed as well. Archived messages are subject to
+// the age limit only; with policy.ArchiveAfterDays set, messages older than
+// that are then moved to the archive. Message deletes run in
+// batches of policy.BatchSize rows so the messages table is never locked for
+// long. With policy.DryRun set nothing is deleted and the result reports how
+// many messages would be. The message limits apply on every message shard.
+func (db *DB) PurgeExpiredMessages(ctx context.Context, policy PurgePolicy) (*PurgeResult, error) {
+	result := &PurgeResult{}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 1000
+	}
+
+	for _, mdb := range db.messageDBs() {
+		if err := mdb.purgeMessages(ctx, policy, result); err != nil {
+			if mdb.shardID != 0 {
+				err = fmt.Errorf("message shard %d: %w", mdb.shardID, err)
+			}
+			return nil, err
+		}
+	}
+	if policy.TombstoneDays > 0 {
+		var err error
+		result.Users, err = db.purgeDeletedUsers(ctx, policy.TombstoneDays, policy.DryRun)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// purgeMessages applies policy to the messages of one database, adding to
+// result
+func (db *DB) purgeMessages(ctx context.Context, policy PurgePolicy, result *PurgeResult) error {
+	n, err := db.purgeCandidates(ctx, expiredByAgeQuery, []interface{}{policy.MaxAgeDays}, policy, &result.Batches)
+	if err != nil {
+		return err
+	}
+	result.ByAge += n
+	n, err = db.purgeRows(ctx, "messages_archive", archivedByAgeQuery, []interface{}{policy.MaxAgeDays}, policy, &result.Batches)
+	if err != nil {
+		return err
+	}
+	result.ByAge += n
+	n, err = db.purgeCandidates(ctx, expiredByCountQuery, []interface{}{policy.MaxMessagesPerChat}, policy, &result.Batches)
+	if err != nil {
+		return err
+	}
+	result.ByCount += n
+	n, err = db.purgeCandidates(ctx, expiredByTimestampQuery, nil, policy, &result.Batches)
+	if err != nil {
+		return err
+	}
+	result.ByExpiry += n
+	if policy.TombstoneDays > 0 {
+		n, err = db.purgeCandidates(ctx, deletedMessagesQuery, []interface{}{policy.TombstoneDays}, policy, &result.Batches)
+		if err != nil {
+			return err
+		}
+		result.Tombstones += n
+	}
+	if policy.ArchiveAfterDays > 0 {
+		n, err = db.archiveMessages(ctx, policy, &result.Batches)
+		if err != nil {
+			return err
+		}
+		result.Archived += n
+	}
+	return nil
+}
+
+// purgeCandidates deletes (or, in dry-run mode, counts) the messages selected
+// by candidates, which takes args, in batches, adding the number of batches
+// run to batches
+func (db *DB) purgeCandidates(ctx context.Context, candidates string, args []interface{}, policy PurgePolicy, batches *int) (int64, error) {
+	return db.purgeRows(ctx, "messages", candidates, args, policy, batches)
+}
+
+// purgeRows is purgeCandidates for the rows of table
+func (db *DB) purgeRows(ctx context.Context, table, candidates string, args []interface{}, policy PurgePolicy, batches *int) (int64, error) {
+	if policy.DryRun {
+		var count int64
+		err := db.q.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM ("+candidates+") c",
+			args...,
+		).Scan(&count)
+		return count, err
+	}
+
+	var total int64
+	for {
+		result, err := db.q.ExecContext(ctx,
+			// The batch is a derived table of its own because MySQL allows
+			// neither LIMIT in an IN subquery nor reading the table being deleted from
+			fmt.Sprintf("DELETE FROM "+table+" WHERE id IN (SELECT id FROM (SELECT id FROM ("+candidates+") c LIMIT $%d) batch)", len(args)+1),
+			append(args, policy.BatchSize)...,
+		)
+		if err != nil {
+			return total, err
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		*batches++
+		total += deleted
+		if deleted < int64(policy.BatchSize) {
+			return total, nil
+		}
+	}
+}
+
+// GetChatMessageStats aggregates a chat's messages per sender: message count,
+// total ciphertext size and first/last message timestamps
+func (db *DB) GetChatMessageStats(ctx context.Context, chatID int64) ([]*MessageStats, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return nil, err
+	} else if mdb != db {
+		return mdb.GetChatMessageStats(ctx, chatID)
+	}
+
+	rows, err := db.q.QueryContext(ctx,
+		`SELECT sender_id, COUNT(*), COALESCE(SUM(OCTET_LENGTH(ciphertext)), 0), MIN(created_at), MAX(created_at)
+		FROM messages WHERE chat_id = $1 AND deleted_at IS NULL GROUP BY sender_id ORDER BY sender_id`,
+		chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*MessageStats
+	for rows.Next() {
+		st := &MessageStats{}
+		err := rows.Scan(&st.SenderID, &st.MessageCount, &st.CiphertextBytes, &st.FirstMessageAt, &st.LastMessageAt)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+
+	return stats, rows.Err()
+}
+
+// GetMessage retrieves a single message by ID. With message sharding the
+// databases are asked in turn, as the ID does not tell which one holds it.
+func (db *DB) GetMessage(ctx context.Context, messageID int64) (*Message, error) {
+	for _, mdb := range db.messageDBs() {
+		if msg, err := mdb.getMessage(ctx, messageID); err != nil || msg != nil {
+			return msg, err
+		}
+	}
+	return nil, nil
+}
+
+func (db *DB) getMessage(ctx context.Context, messageID int64) (*Message, error) {
+	msg := &Message{}
+	var replyTo, deliveredAt, readAt, expiresAt sql.NullInt64
+	err := db.q.QueryRowContext(ctx,
+		"SELECT id, chat_id, sender_id, ciphertext, COALESCE(iv, ''::bytea), COALESCE(file_name, ''), COALESCE(mime_type, ''), created_at, reply_to_message_id, delivered_at, read_at, COALESCE(message_uuid, ''), seq, preview, preview_iv, urgent, expires_at, ratchet_header FROM messages WHERE id = $1 AND deleted_at IS NULL",
+		messageID,
+	).Scan(&msg.ID, &msg.ChatID, &msg.SenderID, &msg.Ciphertext, &msg.IV, &msg.FileName, &msg.MimeType, &msg.CreatedAt, &replyTo, &deliveredAt, &readAt, &msg.MessageUUID, &msg.Seq, &msg.Preview, &msg.PreviewIV, &msg.Urgent, &expiresAt, &msg.RatchetHeader)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if replyTo.Valid {
+		msg.ReplyToMessageID = &replyTo.Int64
+	}
+	if expiresAt.Valid {
+		msg.ExpiresAt = &expiresAt.Int64
+	}
+	if deliveredAt.Valid {
+		msg.DeliveredAt = &deliveredAt.Int64
+	}
+	if readAt.Valid {
+		msg.ReadAt = &readAt.Int64
+	}
+	msg.Timestamp = msg.CreatedAt
+	return msg, err
+}
+
+// Message status operations
+
+// MarkMessagesDelivered sets delivered_at on those of the given messages that
+// were sent to recipientID in chatID and are not yet marked delivered.
+// Returns the IDs of the messages that changed.
+func (db *DB) MarkMessagesDelivered(ctx context.Context, chatID, recipientID int64, messageIDs []int64) ([]int64, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return nil, err
+	} else if mdb != db {
+		return mdb.MarkMessagesDelivered(ctx, chatID, recipientID, messageIDs)
+	}
+
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	placeholders := make([]string, len(messageIDs))
+	args := []interface{}{chatID, recipientID, time.Now().Unix()}
+	for i, id := range messageIDs {
+		placeholders[i] = fmt.Sprintf("$%d", i+4)
+		args = append(args, id)
+	}
+
+	return db.updateMessages(ctx,
+		"delivered_at = $3",
+		"chat_id = $1 AND sender_id <> $2 AND delivered_at IS NULL AND id IN ("+strings.Join(placeholders, ", ")+")",
+		args...,
+	)
+}
+
+// CountUrgentMessagesSince counts the urgent messages senderID sent at or after since (unix seconds)
+func (db *DB) CountUrgentMessagesSince(ctx context.Context, senderID, since int64) (int, error) {
+	var total int
+	for _, mdb := range db.messageDBs() {
+		var count int
+		err := mdb.q.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM messages WHERE sender_id = $1 AND urgent AND created_at >= $2",
+			senderID, since,
+		).Scan(&count)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// MarkMessagesDeliveredUpTo marks every message sent to recipientID in chatID
+// up to and including upToID as delivered. Returns the IDs of the messages
+// that changed.
+func (db *DB) MarkMessagesDeliveredUpTo(ctx context.Context, chatID, recipientID, upToID int64) ([]int64, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return nil, err
+	} else if mdb != db {
+		return mdb.MarkMessagesDeliveredUpTo(ctx, chatID, recipientID, upToID)
+	}
+
+	return db.updateMessages(ctx,
+		"delivered_at = $4",
+		"chat_id = $1 AND sender_id <> $2 AND id <= $3 AND delivered_at IS NULL",
+		chatID, recipientID, upToID, time.Now().Unix(),
+	)
+}
+
+// MarkMessagesRead marks every message sent to readerID in chatID up to and
+// including upToID as read, and as delivered if it was not yet.
+// Returns the IDs of the messages that changed.
+func (db *DB) MarkMessagesRead(ctx context.Context, chatID, readerID, upToID int64) ([]int64, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return nil, err
+	} else if mdb != db {
+		return mdb.MarkMessagesRead(ctx, chatID, readerID, upToID)
+	}
+
+	return db.updateMessages(ctx,
+		"read_at = $4, delivered_at = COALESCE(delivered_at, $4)",
+		"chat_id = $1 AND sender_id <> $2 AND id <= $3 AND read_at IS NULL",
+		chatID, readerID, upToID, time.Now().Unix(),
+	)
+}
+
+// ListUndeliveredMessages returns the oldest limit messages addressed to userID
+// in active chats that have not been marked delivered, oldest first
+func (db *DB) ListUndeliveredMessages(ctx context.Context, userID int64, limit int) ([]*Message, error) {
+	dbs := db.messageDBs()
+	if len(dbs) == 1 {
+		return db.listUndeliveredMessages(ctx, userID, limit)
+	}
+	lists := make([][]*Message, len(dbs))
+	for i, mdb := range dbs {
+		var err error
+		if lists[i], err = mdb.listUndeliveredMessages(ctx, userID, limit); err != nil {
+			return nil, err
+		}
+	}
+	return mergeMessages(lists, limit), nil
+}
+
+func (db *DB) listUndeliveredMessages(ctx context.Context, userID int64, limit int) ([]*Message, error) {
+	rows, err := db.q.QueryContext(ctx,
+		`SELECT m.id, m.chat_id, m.sender_id, m.ciphertext, COALESCE(m.iv, ''::bytea), COALESCE(m.file_name, ''), COALESCE(m.mime_type, ''), m.created_at, m.reply_to_message_id, COALESCE(m.message_uuid, ''), m.seq, m.preview, m.preview_iv, m.urgent, m.expires_at, m.ratchet_header
+		FROM messages m JOIN chats c ON c.id = m.chat_id
+		WHERE (c.user1_id = $1 OR c.user2_id = $1) AND c.status = 'active'
+		AND m.sender_id <> $1 AND m.delivered_at IS NULL AND m.deleted_at IS NULL
+		AND (m.expires_at IS NULL OR m.expires_at > EXTRACT(EPOCH FROM NOW())::BIGINT)
+		ORDER BY m.id LIMIT $2`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg := &Message{}
+		var replyTo, expiresAt sql.NullInt64
+		err := rows.Scan(&msg.ID, &msg.ChatID, &msg.SenderID, &msg.Ciphertext, &msg.IV, &msg.FileName, &msg.MimeType, &msg.CreatedAt, &replyTo, &msg.MessageUUID, &msg.Seq, &msg.Preview, &msg.PreviewIV, &msg.Urgent, &expiresAt, &msg.RatchetHeader)
+		if err != nil {
+			return nil, err
+		}
+		if replyTo.Valid {
+			msg.ReplyToMessageID = &replyTo.Int64
+		}
+		if expiresAt.Valid {
+			msg.ExpiresAt = &expiresAt.Int64
+		}
+		msg.Timestamp = msg.CreatedAt
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// scanIDs reads a single BIGINT column from every row and closes rows
+func scanIDs(rows *sql.Rows) ([]int64, error) {
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Read marker operations
+
+// mysqlSaveReadMarkerQuery is SaveReadMarker's upsert for MySQL, which has no
+// DO UPDATE ... WHERE. read_at is assigned first because MySQL applies the
+// assignments left to right, so it still compares against the old marker.
+const mysqlSaveReadMarkerQuery = `INSERT INTO chat_read_markers (chat_id, user_id, last_read_message_id, read_at) VALUES ($1, $2, $3, $4)
+		ON DUPLICATE KEY UPDATE read_at = IF(VALUES(last_read_message_id) > last_read_message_id, VALUES(read_at), read_at),
+		last_read_message_id = GREATEST(last_read_message_id, VALUES(last_read_message_id))`
+
+// SaveReadMarker records that a user has read a chat up to the given message.
+// Markers only move forward: an older message ID never overwrites a newer one.
+func (db *DB) SaveReadMarker(ctx context.Context, chatID, userID, messageID int64) error {
+	query := `INSERT INTO chat_read_markers (chat_id, user_id, last_read_message_id, read_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET last_read_message_id = $3, read_at = $4
+		WHERE chat_read_markers.last_read_message_id < $3`
+	if db.driver == DriverMySQL {
+		query = mysqlSaveReadMarkerQuery
+	}
+	_, err := db.q.ExecContext(ctx, query, chatID, userID, messageID, time.Now().Unix())
+	return err
+}
+
+// GetReadMarkers retrieves the read markers of all participants of a chat
+func (db *DB) GetReadMarkers(ctx context.Context, chatID int64) ([]*ReadMarker, error) {
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT chat_id, user_id, last_read_message_id, read_at FROM chat_read_markers WHERE chat_id = $1 ORDER BY user_id",
+		chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var markers []*ReadMarker
+	for rows.Next() {
+		marker := &ReadMarker{}
+		err := rows.Scan(&marker.ChatID, &marker.UserID, &marker.LastReadMessageID, &marker.ReadAt)
+		if err != nil {
+			return nil, err
+		}
+		markers = append(markers, marker)
+	}
+
+	return markers, rows.Err()
 }
 
-// GetContact retrieves a contact relationship
-func (db *DB) GetContact(userID1, userID2 int64) (*Contact, error) {
-	if userID1 > userID2 {
-		userID1, userID2 = userID2, userID1
+// Notification preference operations
+
+// SaveNotificationPrefs creates or replaces a user's notification preferences for a chat
+func (db *DB) SaveNotificationPrefs(ctx context.Context, chatID, userID int64, level, soundID string) (*NotificationPrefs, error) {
+	prefs := &NotificationPrefs{ChatID: chatID, UserID: userID, Level: level, SoundID: soundID, UpdatedAt: time.Now().Unix()}
+	_, err := db.q.ExecContext(ctx,
+		`INSERT INTO chat_notification_prefs (chat_id, user_id, level, sound_id, updated_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET level = $3, sound_id = $4, updated_at = $5`,
+		chatID, userID, level, soundID, prefs.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
 	}
+	return prefs, nil
+}
 
-	contact := &Contact{}
-	err := db.conn.QueryRow(
-		"SELECT id, user1_id, user2_id, requester_id, status, created_at FROM contacts WHERE user1_id = $1 AND user2_id = $2",
-		userID1, userID2,
-	).Scan(&contact.ID, &contact.User1ID, &contact.User2ID, &contact.RequesterID, &contact.Status, &contact.CreatedAt)
+// GetNotificationPrefs retrieves a user's notification preferences for a chat
+func (db *DB) GetNotificationPrefs(ctx context.Context, chatID, userID int64) (*NotificationPrefs, error) {
+	prefs := &NotificationPrefs{}
+	err := db.q.QueryRowContext(ctx,
+		"SELECT chat_id, user_id, level, sound_id, updated_at FROM chat_notification_prefs WHERE chat_id = $1 AND user_id = $2",
+		chatID, userID,
+	).Scan(&prefs.ChatID, &prefs.UserID, &prefs.Level, &prefs.SoundID, &prefs.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	return contact, err
-}
-
-// UpdateContactStatus updates the status of a contact relationship
-func (db *DB) UpdateContactStatus(contactID int64, status string) error {
-	_, err := db.conn.Exec(
-		"UPDATE contacts SET status = $1, updated_at = $2 WHERE id = $3",
-		status, time.Now().Unix(), contactID,
-	)
-	return err
+	return prefs, err
 }
 
-// ListUserContacts lists all contacts of a user with given status
-func (db *DB) ListUserContacts(userID int64, status string) ([]*Contact, error) {
-	rows, err := db.conn.Query(
-		"SELECT id, user1_id, user2_id, requester_id, status, created_at FROM contacts WHERE (user1_id = $1 OR user2_id = $1) AND status = $2",
-		userID, status,
+// ListUserNotificationPrefs lists all notification preferences a user has customized
+func (db *DB) ListUserNotificationPrefs(ctx context.Context, userID int64) ([]*NotificationPrefs, error) {
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT chat_id, user_id, level, sound_id, updated_at FROM chat_notification_prefs WHERE user_id = $1 ORDER BY chat_id",
+		userID,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var contacts []*Contact
+	var prefsList []*NotificationPrefs
 	for rows.Next() {
-		contact := &Contact{}
-		err := rows.Scan(&contact.ID, &contact.User1ID, &contact.User2ID, &contact.RequesterID, &contact.Status, &contact.CreatedAt)
+		prefs := &NotificationPrefs{}
+		err := rows.Scan(&prefs.ChatID, &prefs.UserID, &prefs.Level, &prefs.SoundID, &prefs.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
-		contacts = append(contacts, contact)
+		prefsList = append(prefsList, prefs)
 	}
 
-	return contacts, rows.Err()
+	return prefsList, rows.Err()
 }
 
-// DeleteContact deletes a contact relationship
-func (db *DB) DeleteContact(contactID int64) error {
-	_, err := db.conn.Exec("DELETE FROM contacts WHERE id = $1", contactID)
+// DeleteNotificationPrefs removes a user's notification preferences for a chat
+func (db *DB) DeleteNotificationPrefs(ctx context.Context, chatID, userID int64) error {
+	_, err := db.q.ExecContext(ctx,
+		"DELETE FROM chat_notification_prefs WHERE chat_id = $1 AND user_id = $2",
+		chatID, userID,
+	)
 	return err
 }
 
-// Chat operations
+// Pin operations
 
-// CreateChat creates a new encrypted chat
-func (db *DB) CreateChat(userID1, userID2 int64, algorithm, mode, padding string) (int64, error) {
-	if userID1 > userID2 {
-		userID1, userID2 = userID2, userID1
+// PinMessage pins a message in a chat unless the chat already has maxPins pins.
+// Returns false if nothing was inserted (limit reached or already pinned).
+func (db *DB) PinMessage(ctx context.Context, chatID, messageID, userID int64, maxPins int) (*Pin, bool, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return nil, false, err
+	} else if mdb != db {
+		return mdb.PinMessage(ctx, chatID, messageID, userID, maxPins)
 	}
 
-	var id int64
-	err := db.conn.QueryRow(
-		"INSERT INTO chats (user1_id, user2_id, algorithm, mode, padding) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		userID1, userID2, algorithm, mode, padding,
-	).Scan(&id)
-	return id, err
+	pin := &Pin{ChatID: chatID, MessageID: messageID, PinnedBy: userID, PinnedAt: time.Now().Unix()}
+	result, err := db.q.ExecContext(ctx,
+		`INSERT INTO chat_pins (chat_id, message_id, pinned_by, pinned_at)
+		SELECT $1, $2, $3, $4 FROM chats WHERE id = $1 AND (SELECT COUNT(*) FROM chat_pins WHERE chat_id = $1) < $5
+		ON CONFLICT (chat_id, message_id) DO NOTHING`,
+		chatID, messageID, userID, pin.PinnedAt, maxPins,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil || inserted == 0 {
+		return nil, false, err
+	}
+	return pin, true, nil
 }
 
-// UpdateChatEncryption updates the encryption algorithm, mode, and padding for a chat
-func (db *DB) UpdateChatEncryption(chatID int64, algorithm, mode, padding string) error {
-	_, err := db.conn.Exec(
-		"UPDATE chats SET algorithm = $1, mode = $2, padding = $3, updated_at = EXTRACT(EPOCH FROM NOW())::BIGINT WHERE id = $4",
-		algorithm, mode, padding, chatID,
+// UnpinMessage removes a pin and reports whether the message was pinned
+func (db *DB) UnpinMessage(ctx context.Context, chatID, messageID int64) (bool, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return false, err
+	} else if mdb != db {
+		return mdb.UnpinMessage(ctx, chatID, messageID)
+	}
+
+	result, err := db.q.ExecContext(ctx,
+		"DELETE FROM chat_pins WHERE chat_id = $1 AND message_id = $2",
+		chatID, messageID,
 	)
-	return err
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
 }
 
-// GetChat retrieves a chat by ID
-func (db *DB) GetChat(chatID int64) (*Chat, error) {
-	chat := &Chat{}
-	err := db.conn.QueryRow(
-		"SELECT id, user1_id, user2_id, algorithm, mode, padding, status, created_at, closed_at FROM chats WHERE id = $1",
-		chatID,
-	).Scan(&chat.ID, &chat.User1ID, &chat.User2ID, &chat.Algorithm, &chat.Mode, &chat.Padding, &chat.Status, &chat.CreatedAt, &chat.ClosedAt)
+// GetPin retrieves the pin of a message in a chat
+func (db *DB) GetPin(ctx context.Context, chatID, messageID int64) (*Pin, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return nil, err
+	} else if mdb != db {
+		return mdb.GetPin(ctx, chatID, messageID)
+	}
+
+	pin := &Pin{}
+	err := db.q.QueryRowContext(ctx,
+		"SELECT chat_id, message_id, pinned_by, pinned_at FROM chat_pins WHERE chat_id = $1 AND message_id = $2",
+		chatID, messageID,
+	).Scan(&pin.ChatID, &pin.MessageID, &pin.PinnedBy, &pin.PinnedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	return chat, err
+	return pin, err
 }
 
-// ListUserChats lists all active chats for a user
-func (db *DB) ListUserChats(userID int64) ([]*Chat, error) {
-	rows, err := db.conn.Query(
-		"SELECT id, user1_id, user2_id, algorithm, mode, padding, status, created_at FROM chats WHERE (user1_id = $1 OR user2_id = $1) AND status = 'active' ORDER BY created_at DESC",
-		userID,
+// ListChatPins lists a chat's pins, most recently pinned first
+func (db *DB) ListChatPins(ctx context.Context, chatID int64) ([]*Pin, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return nil, err
+	} else if mdb != db {
+		return mdb.ListChatPins(ctx, chatID)
+	}
+
+	rows, err := db.q.QueryContext(ctx,
+		`SELECT p.chat_id, p.message_id, p.pinned_by, p.pinned_at FROM chat_pins p
+		JOIN messages m ON m.id = p.message_id
+		WHERE p.chat_id = $1 AND m.deleted_at IS NULL ORDER BY p.pinned_at DESC, p.id DESC`,
+		chatID,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var chats []*Chat
+	var pins []*Pin
 	for rows.Next() {
-		chat := &Chat{}
-		err := rows.Scan(&chat.ID, &chat.User1ID, &chat.User2ID, &chat.Algorithm, &chat.Mode, &chat.Padding, &chat.Status, &chat.CreatedAt)
-		if err != nil {
+		pin := &Pin{}
+		if err := rows.Scan(&pin.ChatID, &pin.MessageID, &pin.PinnedBy, &pin.PinnedAt); err != nil {
 			return nil, err
 		}
-		chats = append(chats, chat)
+		pins = append(pins, pin)
 	}
 
-	return chats, rows.Err()
+	return pins, rows.Err()
 }
 
-// GetChatByUsers retrieves an existing chat between two users (any status)
-func (db *DB) GetChatByUsers(userID1, userID2 int64) (*Chat, error) {
-	if userID1 > userID2 {
-		userID1, userID2 = userID2, userID1
+// Draft operations
+
+// SaveDraft creates or replaces a user's encrypted draft for a chat
+func (db *DB) SaveDraft(ctx context.Context, chatID, userID int64, ciphertext, iv []byte) (*Draft, error) {
+	draft := &Draft{ChatID: chatID, UserID: userID, Ciphertext: ciphertext, IV: iv, UpdatedAt: time.Now().Unix()}
+	_, err := db.q.ExecContext(ctx,
+		`INSERT INTO chat_drafts (chat_id, user_id, ciphertext, iv, updated_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET ciphertext = $3, iv = $4, updated_at = $5`,
+		chatID, userID, ciphertext, iv, draft.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
 	}
+	return draft, nil
+}
 
-	chat := &Chat{}
-	err := db.conn.QueryRow(
-		"SELECT id, user1_id, user2_id, algorithm, mode, padding, status, created_at, closed_at FROM chats WHERE user1_id = $1 AND user2_id = $2",
-		userID1, userID2,
-	).Scan(&chat.ID, &chat.User1ID, &chat.User2ID, &chat.Algorithm, &chat.Mode, &chat.Padding, &chat.Status, &chat.CreatedAt, &chat.ClosedAt)
+// GetDraft retrieves a user's draft for a chat
+func (db *DB) GetDraft(ctx context.Context, chatID, userID int64) (*Draft, error) {
+	draft := &Draft{}
+	err := db.q.QueryRowContext(ctx,
+		"SELECT chat_id, user_id, ciphertext, iv, updated_at FROM chat_drafts WHERE chat_id = $1 AND user_id = $2",
+		chatID, userID,
+	).Scan(&draft.ChatID, &draft.UserID, &draft.Ciphertext, &draft.IV, &draft.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	return chat, err
+	return draft, err
 }
 
-// ReopenChat reopens a closed chat (set status to 'active' and clear closed_at)
-func (db *DB) ReopenChat(chatID int64) error {
-	_, err := db.conn.Exec(
-		"UPDATE chats SET status = 'active', closed_at = NULL, updated_at = $1 WHERE id = $2 AND status = 'closed'",
-		time.Now().Unix(), chatID,
+// DeleteDraft removes a user's draft for a chat and reports whether one existed
+func (db *DB) DeleteDraft(ctx context.Context, chatID, userID int64) (bool, error) {
+	result, err := db.q.ExecContext(ctx,
+		"DELETE FROM chat_drafts WHERE chat_id = $1 AND user_id = $2",
+		chatID, userID,
 	)
-	return err
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
 }
 
-// CloseChat closes an active chat
-func (db *DB) CloseChat(chatID int64) error {
-	_, err := db.conn.Exec(
-		"UPDATE chats SET status = 'closed', closed_at = $1, updated_at = $1 WHERE id = $2",
-		time.Now().Unix(), chatID,
-	)
-	return err
-}
+// Session key operations
 
-// Message operations
+// ErrClientOnlyKeys is returned for attempts to store session key material
+// for a chat in client-only key mode
+var ErrClientOnlyKeys = errors.New("chat keys are client-only, the server does not store them")
 
-// SaveMessage saves an encrypted message with IV and optional metadata
-func (db *DB) SaveMessage(chatID, senderID int64, ciphertext []byte, iv []byte, fileName string, mimeType string) (int64, error) {
-	var id int64
-	err := db.conn.QueryRow(
-		"INSERT INTO messages (chat_id, sender_id, ciphertext, iv, file_name, mime_type) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
-		chatID, senderID, ciphertext, iv, fileName, mimeType,
-	).Scan(&id)
-	return id, err
-}
+// clientOnlyKeyMode is the key mode of chats whose keys never reach the
+// server (protocol.clientOnlyKeyMode)
+const clientOnlyKeyMode = "client_only"
 
-// DeleteChatMessages deletes all messages for a specific chat
-func (db *DB) DeleteChatMessages(chatID int64) error {
-	result, err := db.conn.Exec("DELETE FROM messages WHERE chat_id = $1", chatID)
-	if err != nil {
-		return err
-	}
-	rowsAffected, err := result.RowsAffected()
+// SaveSessionKey saves the session key for a chat. It refuses with
+// ErrClientOnlyKeys if the chat is in client-only key mode.
+func (db *DB) SaveSessionKey(ctx context.Context, chatID int64, sessionKey, iv []byte) error {
+	tx, err := db.begin(ctx)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("[Storage] Deleted %d messages for chat %d\n", rowsAffected, chatID)
-	return nil
-}
+	defer tx.Rollback()
 
-// GetChatMessages retrieves messages from a chat (with optional limit)
-func (db *DB) GetChatMessages(chatID int64, limit int) ([]*Message, error) {
-	rows, err := db.conn.Query(
-		"SELECT id, chat_id, sender_id, ciphertext, COALESCE(iv, ''::bytea), COALESCE(file_name, ''), COALESCE(mime_type, ''), created_at FROM messages WHERE chat_id = $1 ORDER BY created_at ASC LIMIT $2",
-		chatID, limit,
-	)
-	if err != nil {
-		return nil, err
+	var mode string
+	err = tx.QueryRowContext(ctx, "SELECT key_mode FROM chats WHERE id = $1", chatID).Scan(&mode)
+	if err != nil && err != sql.ErrNoRows {
+		return err
 	}
-	defer rows.Close()
-
-	var messages []*Message
-	for rows.Next() {
-		msg := &Message{}
-		err := rows.Scan(&msg.ID, &msg.ChatID, &msg.SenderID, &msg.Ciphertext, &msg.IV, &msg.FileName, &msg.MimeType, &msg.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		msg.Timestamp = msg.CreatedAt
-		messages = append(messages, msg)
+	if mode == clientOnlyKeyMode {
+		return ErrClientOnlyKeys
 	}
-
-	return messages, rows.Err()
-}
-
-// Session key operations
-
-// SaveSessionKey saves the session key for a chat
-func (db *DB) SaveSessionKey(chatID int64, sessionKey, iv []byte) error {
-	_, err := db.conn.Exec(
+	if _, err := tx.ExecContext(ctx,
 		"INSERT INTO session_keys (chat_id, session_key, iv) VALUES ($1, $2, $3) ON CONFLICT (chat_id) DO UPDATE SET session_key = $2, iv = $3",
 		chatID, sessionKey, iv,
-	)
-	return err
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetSessionKey retrieves the session key for a chat
-func (db *DB) GetSessionKey(chatID int64) (*SessionKey, error) {
+func (db *DB) GetSessionKey(ctx context.Context, chatID int64) (*SessionKey, error) {
 	sk := &SessionKey{}
-	err := db.conn.QueryRow(
+	err := db.q.QueryRowContext(ctx,
 		"SELECT chat_id, session_key, iv, created_at FROM session_keys WHERE chat_id = $1",
 		chatID,
 	).Scan(&sk.ChatID, &sk.Key, &sk.IV, &sk.CreatedAt)
@@ -465,27 +1547,94 @@ func (db *DB) GetSessionKey(chatID int64) (*SessionKey, error) {
 // DH parameters and public keys
 
 // SaveDHParameters saves the DH parameters (p, g) for a chat
-func (db *DB) SaveDHParameters(chatID int64, p, g []byte) error {
-	_, err := db.conn.Exec(
+func (db *DB) SaveDHParameters(ctx context.Context, chatID int64, p, g []byte) error {
+	_, err := db.q.ExecContext(ctx,
 		"INSERT INTO dh_parameters (chat_id, p, g) VALUES ($1, $2, $3)",
 		chatID, p, g,
 	)
 	return err
 }
 
-// SaveGlobalDHParameters saves the global DH parameters (p, g)
-func (db *DB) SaveGlobalDHParameters(p, g []byte) error {
-	// Upsert into single-row table
-	_, err := db.conn.Exec(
-		"INSERT INTO dh_globals (p, g) VALUES ($1, $2)",
-		p, g,
+// SaveKeyAgreement stores the key agreement of a chat with its parameters,
+// their RFC 7919 group and the session key derivation, replacing any stored
+// before. X25519 chats have no p, g and group.
+func (db *DB) SaveKeyAgreement(ctx context.Context, chatID int64, keyAgreement, dhGroup, kdf string, p, g []byte) error {
+	if p == nil {
+		p = []byte{}
+	}
+	if g == nil {
+		g = []byte{}
+	}
+	_, err := db.q.ExecContext(ctx,
+		"INSERT INTO dh_parameters (chat_id, p, g, key_agreement, dh_group, kdf) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (chat_id) DO UPDATE SET p = $2, g = $3, key_agreement = $4, dh_group = $5, kdf = $6",
+		chatID, p, g, keyAgreement, dhGroup, kdf,
 	)
 	return err
 }
 
+// GetDHGroup retrieves the RFC 7919 group of a DH chat. Returns "" if the
+// chat has no DH parameters row or its parameters were stored without one.
+func (db *DB) GetDHGroup(ctx context.Context, chatID int64) (string, error) {
+	var group string
+	err := db.q.QueryRowContext(ctx,
+		"SELECT dh_group FROM dh_parameters WHERE chat_id = $1",
+		chatID,
+	).Scan(&group)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return group, err
+}
+
+// GetSessionKDF retrieves how the keys of a chat are derived from its shared
+// secret. Returns "" if the chat has no DH parameters row.
+func (db *DB) GetSessionKDF(ctx context.Context, chatID int64) (string, error) {
+	var kdf string
+	err := db.q.QueryRowContext(ctx,
+		"SELECT kdf FROM dh_parameters WHERE chat_id = $1",
+		chatID,
+	).Scan(&kdf)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return kdf, err
+}
+
+// GetKeyAgreement retrieves the key agreement of a chat. Returns "" if the
+// chat has no DH parameters row.
+func (db *DB) GetKeyAgreement(ctx context.Context, chatID int64) (string, error) {
+	var keyAgreement string
+	err := db.q.QueryRowContext(ctx,
+		"SELECT key_agreement FROM dh_parameters WHERE chat_id = $1",
+		chatID,
+	).Scan(&keyAgreement)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return keyAgreement, err
+}
+
+// SaveGlobalDHParameters saves the global DH parameters (p, g) unless a set
+// already exists, and returns the stored set. dh_globals holds a single row,
+// so when several gateways race to initialize it exactly one set wins and
+// every caller gets that one back.
+func (db *DB) SaveGlobalDHParameters(ctx context.Context, p, g []byte) (storedP, storedG []byte, err error) {
+	_, err = db.q.ExecContext(ctx,
+		"INSERT INTO dh_globals (p, g) VALUES ($1, $2) ON CONFLICT (singleton) DO NOTHING",
+		p, g,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db.GetGlobalDHParameters(ctx)
+}
+
 // GetGlobalDHParameters retrieves global DH params (p, g). Returns nil,nil,nil if not found
-func (db *DB) GetGlobalDHParameters() (p, g []byte, err error) {
-	err = db.conn.QueryRow(
+func (db *DB) GetGlobalDHParameters(ctx context.Context) (p, g []byte, err error) {
+	err = db.q.QueryRowContext(ctx,
 		"SELECT p, g FROM dh_globals ORDER BY id LIMIT 1",
 	).Scan(&p, &g)
 
@@ -496,8 +1645,8 @@ func (db *DB) GetGlobalDHParameters() (p, g []byte, err error) {
 }
 
 // GetDHParameters retrieves the DH parameters (p, g) for a chat
-func (db *DB) GetDHParameters(chatID int64) (p, g []byte, err error) {
-	err = db.conn.QueryRow(
+func (db *DB) GetDHParameters(ctx context.Context, chatID int64) (p, g []byte, err error) {
+	err = db.q.QueryRowContext(ctx,
 		"SELECT p, g FROM dh_parameters WHERE chat_id = $1",
 		chatID,
 	).Scan(&p, &g)
@@ -508,18 +1657,207 @@ func (db *DB) GetDHParameters(chatID int64) (p, g []byte, err error) {
 	return p, g, err
 }
 
-// SaveDHPublicKey saves a user's DH public key for a chat
-func (db *DB) SaveDHPublicKey(chatID, userID int64, publicKey []byte) error {
-	_, err := db.conn.Exec(
-		"INSERT INTO dh_public_keys (chat_id, user_id, public_key) VALUES ($1, $2, $3) ON CONFLICT (chat_id, user_id) DO UPDATE SET public_key = $3",
+// SaveDHPublicKey saves a user's DH public key for a chat, unsigned
+func (db *DB) SaveDHPublicKey(ctx context.Context, chatID, userID int64, publicKey []byte) error {
+	_, err := db.q.ExecContext(ctx,
+		"INSERT INTO dh_public_keys (chat_id, user_id, public_key) VALUES ($1, $2, $3) ON CONFLICT (chat_id, user_id) DO UPDATE SET public_key = $3, epoch = 0, signature = NULL, published_at = 0",
 		chatID, userID, publicKey,
 	)
 	return err
 }
 
+// DHPublicKey is a participant's DH public key for a chat. Keys published
+// through the exchange carry the publisher's epoch and a signature by their
+// identity key, and the timestamp of the submission that published them;
+// keys copied from registration have none of these.
+type DHPublicKey struct {
+	PublicKey   []byte
+	Epoch       int64
+	Signature   []byte
+	PublishedAt int64
+}
+
+// SaveSignedDHPublicKey saves a user's DH public key for a chat with its
+// epoch, signature and publication timestamp
+func (db *DB) SaveSignedDHPublicKey(ctx context.Context, chatID, userID int64, key *DHPublicKey) error {
+	_, err := db.q.ExecContext(ctx,
+		"INSERT INTO dh_public_keys (chat_id, user_id, public_key, epoch, signature, published_at) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (chat_id, user_id) DO UPDATE SET public_key = $3, epoch = $4, signature = $5, published_at = $6",
+		chatID, userID, key.PublicKey, key.Epoch, key.Signature, key.PublishedAt,
+	)
+	return err
+}
+
+// GetSignedDHPublicKey retrieves a user's DH public key for a chat with its
+// epoch, signature and publication timestamp. Returns nil if the user has
+// published none.
+func (db *DB) GetSignedDHPublicKey(ctx context.Context, chatID, userID int64) (*DHPublicKey, error) {
+	var key DHPublicKey
+	err := db.q.QueryRowContext(ctx,
+		"SELECT public_key, epoch, signature, published_at FROM dh_public_keys WHERE chat_id = $1 AND user_id = $2",
+		chatID, userID,
+	).Scan(&key.PublicKey, &key.Epoch, &key.Signature, &key.PublishedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// SaveIdentityKey stores a user's Ed25519 identity public key
+func (db *DB) SaveIdentityKey(ctx context.Context, userID int64, identityKey []byte) error {
+	_, err := db.q.ExecContext(ctx,
+		"UPDATE users SET identity_key = $1, updated_at = $2 WHERE id = $3",
+		identityKey, time.Now().Unix(), userID,
+	)
+	return err
+}
+
+// GetIdentityKey retrieves a user's identity public key. Returns nil if the
+// user has not published one.
+func (db *DB) GetIdentityKey(ctx context.Context, userID int64) ([]byte, error) {
+	var identityKey []byte
+	err := db.q.QueryRowContext(ctx,
+		"SELECT identity_key FROM users WHERE id = $1",
+		userID,
+	).Scan(&identityKey)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return identityKey, err
+}
+
+// DeleteDHPublicKeys removes every participant's DH public key of a chat
+func (db *DB) DeleteDHPublicKeys(ctx context.Context, chatID int64) error {
+	_, err := db.q.ExecContext(ctx, "DELETE FROM dh_public_keys WHERE chat_id = $1", chatID)
+	return err
+}
+
+// UseDHNonce records the nonce of a key exchange submission and reports
+// whether it is new for the chat and user. Nonces recorded before notBefore
+// are dropped first: submissions that old are refused by their timestamp.
+func (db *DB) UseDHNonce(ctx context.Context, chatID, userID int64, nonce string, notBefore int64) (bool, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM dh_exchange_nonces WHERE chat_id = $1 AND user_id = $2 AND created_at < $3",
+		chatID, userID, notBefore,
+	); err != nil {
+		return false, err
+	}
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO dh_exchange_nonces (chat_id, user_id, nonce, created_at) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+		chatID, userID, nonce, time.Now().Unix(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GetDHState returns the key exchange state recorded on a chat and when it
+// was entered (0 for states set by the migration). Returns "" if the chat
+// does not exist.
+func (db *DB) GetDHState(ctx context.Context, chatID int64) (string, int64, error) {
+	var state string
+	var changedAt int64
+	err := db.q.QueryRowContext(ctx,
+		"SELECT dh_state, dh_state_changed_at FROM chats WHERE id = $1",
+		chatID,
+	).Scan(&state, &changedAt)
+
+	if err == sql.ErrNoRows {
+		return "", 0, nil
+	}
+	return state, changedAt, err
+}
+
+// SetDHState moves a chat's key exchange from state from to state to and
+// reports whether it did; it does not if another request changed the state
+// first
+func (db *DB) SetDHState(ctx context.Context, chatID int64, from, to string) (bool, error) {
+	result, err := db.q.ExecContext(ctx,
+		"UPDATE chats SET dh_state = $1, dh_state_changed_at = $2 WHERE id = $3 AND dh_state = $4",
+		to, time.Now().Unix(), chatID, from,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetKeyMode returns the key mode of a chat, or "" if the chat does not exist
+func (db *DB) GetKeyMode(ctx context.Context, chatID int64) (string, error) {
+	var mode string
+	err := db.q.QueryRowContext(ctx,
+		"SELECT key_mode FROM chats WHERE id = $1",
+		chatID,
+	).Scan(&mode)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return mode, err
+}
+
+// SetKeyMode sets the key mode of a chat. Moving a chat to client-only
+// drops any session key stored for it in the same transaction.
+func (db *DB) SetKeyMode(ctx context.Context, chatID int64, mode string) error {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE chats SET key_mode = $1 WHERE id = $2", mode, chatID); err != nil {
+		return err
+	}
+	if mode == clientOnlyKeyMode {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM session_keys WHERE chat_id = $1", chatID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MakeAllChatsClientOnly moves every chat to client-only key mode and drops
+// all stored session keys. Returns how many chats changed mode.
+func (db *DB) MakeAllChatsClientOnly(ctx context.Context) (int64, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE chats SET key_mode = $1 WHERE key_mode <> $1", clientOnlyKeyMode)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM session_keys"); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
 // SaveUserKeys stores a user's public key and encrypted private key
-func (db *DB) SaveUserKeys(userID int64, publicKey, encryptedPrivateKey []byte) error {
-	_, err := db.conn.Exec(
+func (db *DB) SaveUserKeys(ctx context.Context, userID int64, publicKey, encryptedPrivateKey []byte) error {
+	_, err := db.q.ExecContext(ctx,
 		"UPDATE users SET public_key = $1, encrypted_private_key = $2, updated_at = $3 WHERE id = $4",
 		publicKey, encryptedPrivateKey, time.Now().Unix(), userID,
 	)
@@ -527,9 +1865,9 @@ func (db *DB) SaveUserKeys(userID int64, publicKey, encryptedPrivateKey []byte)
 }
 
 // GetDHPublicKey retrieves a user's DH public key for a chat
-func (db *DB) GetDHPublicKey(chatID, userID int64) ([]byte, error) {
+func (db *DB) GetDHPublicKey(ctx context.Context, chatID, userID int64) ([]byte, error) {
 	var publicKey []byte
-	err := db.conn.QueryRow(
+	err := db.q.QueryRowContext(ctx,
 		"SELECT public_key FROM dh_public_keys WHERE chat_id = $1 AND user_id = $2",
 		chatID, userID,
 	).Scan(&publicKey)
@@ -541,9 +1879,9 @@ func (db *DB) GetDHPublicKey(chatID, userID int64) ([]byte, error) {
 }
 
 // GetOtherUserPublicKey retrieves the other user's DH public key for a chat
-func (db *DB) GetOtherUserPublicKey(chatID, userID int64) ([]byte, error) {
+func (db *DB) GetOtherUserPublicKey(ctx context.Context, chatID, userID int64) ([]byte, error) {
 	var publicKey []byte
-	err := db.conn.QueryRow(
+	err := db.q.QueryRowContext(ctx,
 		"SELECT public_key FROM dh_public_keys WHERE chat_id = $1 AND user_id != $2",
 		chatID, userID,
 	).Scan(&publicKey)
@@ -583,12 +1921,25 @@ type Chat struct {
 	ID        int64  `json:"id"`
 	User1ID   int64  `json:"user1_id"`
 	User2ID   int64  `json:"user2_id"`
+	ChatType  string `json:"chat_type"` // "direct" or "self" (Saved Messages)
 	Algorithm string `json:"algorithm"`
 	Mode      string `json:"mode"`
 	Padding   string `json:"padding"`
 	Status    string `json:"status"`
 	CreatedAt int64  `json:"created_at"`
 	ClosedAt  *int64 `json:"closed_at,omitempty"`
+	// LastActivityAt is the time of the latest message (or creation if empty)
+	LastActivityAt int64 `json:"last_activity_at"`
+	// LastSeq is the sequence number of the newest message ever stored
+	LastSeq int64 `json:"last_seq"`
+	// Retention policy; 0 means no limit
+	RetentionDays        int `json:"retention_days"`
+	RetentionMaxMessages int `json:"retention_max_messages"`
+	// HistoryRetained is set while a soft-closed chat keeps its (hidden) messages
+	HistoryRetained bool `json:"history_retained"`
+	// Version is incremented by every status or encryption change; the
+	// methods making those changes only apply if it still matches
+	Version int64 `json:"version"`
 }
 
 // Message represents an encrypted message
@@ -602,6 +1953,122 @@ type Message struct {
 	MimeType   string `json:"mime_type,omitempty"`
 	CreatedAt  int64  `json:"created_at"`
 	Timestamp  int64  `json:"timestamp"`
+	// ReplyToMessageID is the message this one replies to (nil if not a reply
+	// or if the original has since been deleted)
+	ReplyToMessageID *int64 `json:"reply_to_message_id,omitempty"`
+	// MessageUUID is the client-generated idempotency key, if one was sent
+	MessageUUID string `json:"message_uuid,omitempty"`
+	// Seq numbers the chat's messages 1, 2, 3, ... in the order they were stored
+	Seq int64 `json:"seq"`
+	// Preview is an encrypted link preview (nil if none), opaque to the server
+	Preview   []byte `json:"preview,omitempty"`
+	PreviewIV []byte `json:"preview_iv,omitempty"`
+	// RatchetHeader is the Double Ratchet header of the message (nil for
+	// messages under the static chat key)
+	RatchetHeader []byte `json:"ratchet_header,omitempty"`
+	// Urgent messages notify the recipient even in a muted chat
+	Urgent bool `json:"urgent"`
+	// ExpiresAt is when the sender wants the message gone (nil if never)
+	ExpiresAt *int64 `json:"expires_at,omitempty"`
+	// DeliveredAt and ReadAt record when the recipient received and read the message
+	DeliveredAt *int64 `json:"delivered_at,omitempty"`
+	ReadAt      *int64 `json:"read_at,omitempty"`
+}
+
+// NewMessage is a message for SaveMessage to store
+type NewMessage struct {
+	ChatID     int64
+	SenderID   int64
+	Ciphertext []byte
+	IV         []byte
+	FileName   string
+	MimeType   string
+	// ReplyToID is the message being replied to (nil if not a reply)
+	ReplyToID *int64
+	// Preview and PreviewIV are an encrypted link preview (nil if none)
+	Preview   []byte
+	PreviewIV []byte
+	// Urgent messages notify the recipient even in a muted chat
+	Urgent bool
+	// ExpiresAt is when the message expires, in unix seconds (nil if never)
+	ExpiresAt *int64
+	// AttachmentIDs are uploaded files to link to the message
+	AttachmentIDs []int64
+	// MessageUUID is an optional client-generated idempotency key
+	MessageUUID string
+	// Ratchet is the message's Double Ratchet step (nil for messages under
+	// the static chat key)
+	Ratchet *RatchetStep
+}
+
+// PurgePolicy is the server-wide retention policy applied on top of each
+// chat's own policy. Zero limits are disabled.
+type PurgePolicy struct {
+	MaxAgeDays         int
+	MaxMessagesPerChat int
+	// TombstoneDays is how long soft-deleted messages and users are kept
+	// before they are purged; 0 keeps them indefinitely
+	TombstoneDays int
+	// ArchiveAfterDays is the age at which messages move to the archive; 0
+	// turns archiving off
+	ArchiveAfterDays int
+	BatchSize        int
+	DryRun           bool
+}
+
+// PurgeResult reports what a retention purge deleted (or would delete)
+type PurgeResult struct {
+	ByAge      int64
+	ByCount    int64
+	ByExpiry   int64 // messages past their own expires_at
+	Tombstones int64 // soft-deleted messages past TombstoneDays
+	Users      int64 // soft-deleted users past TombstoneDays
+	Archived   int64 // messages moved to the archive
+	Batches    int
+}
+
+// MessageFilter restricts a message history query using only unencrypted
+// metadata. Zero values disable the corresponding condition.
+type MessageFilter struct {
+	SenderID   int64
+	HasFile    *bool
+	MediaClass string // MediaImage, MediaVideo, MediaAudio or MediaDocument
+	Since      int64  // created_at lower bound (inclusive, unix seconds)
+	Until      int64  // created_at upper bound (inclusive, unix seconds)
+	FromSeq    int64  // seq lower bound (inclusive)
+	ToSeq      int64  // seq upper bound (inclusive)
+	// IncludeArchived also returns messages moved to the archive
+	IncludeArchived bool
+}
+
+// UploadSession tracks a resumable chunked file upload
+type UploadSession struct {
+	ID           string
+	UserID       int64
+	ChatID       int64
+	FileName     string
+	MimeType     string
+	TotalSize    int64
+	ReceivedSize int64
+	ChunkCount   int
+	CreatedAt    int64
+	UpdatedAt    int64
+}
+
+// File is a completed upload stored in the blob store
+type File struct {
+	ID        int64  `json:"id"`
+	OwnerID   int64  `json:"owner_id"`
+	ChatID    int64  `json:"chat_id"`
+	BlobKey   string `json:"-"`
+	FileName  string `json:"file_name"`
+	MimeType  string `json:"mime_type"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"created_at"`
+	// Optional encrypted thumbnail; empty if none was uploaded
+	Thumbnail       []byte `json:"-"`
+	ThumbnailWidth  int    `json:"-"`
+	ThumbnailHeight int    `json:"-"`
 }
 
 // SessionKey represents a shared session key
@@ -611,3 +2078,53 @@ type SessionKey struct {
 	IV        []byte
 	CreatedAt int64
 }
+
+// MessageStats summarizes one sender's messages in a chat
+type MessageStats struct {
+	SenderID        int64
+	MessageCount    int64
+	CiphertextBytes int64
+	FirstMessageAt  int64
+	LastMessageAt   int64
+}
+
+// ReadMarker represents how far a participant has read a chat
+type ReadMarker struct {
+	ChatID            int64 `json:"chat_id"`
+	UserID            int64 `json:"user_id"`
+	LastReadMessageID int64 `json:"last_read_message_id"`
+	ReadAt            int64 `json:"read_at"`
+}
+
+// NotificationPrefs represents a user's notification settings for one chat
+type NotificationPrefs struct {
+	ChatID    int64  `json:"chat_id"`
+	UserID    int64  `json:"user_id"`
+	Level     string `json:"level"` // "always", "mentions" or "never"
+	SoundID   string `json:"sound_id"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// Pin marks a message as pinned in its chat
+type Pin struct {
+	ChatID    int64
+	MessageID int64
+	PinnedBy  int64
+	PinnedAt  int64
+}
+
+// Draft is a user's unsent, client-encrypted message text for one chat
+type Draft struct {
+	ChatID     int64
+	UserID     int64
+	Ciphertext []byte
+	IV         []byte
+	UpdatedAt  int64
+}
+
+// ChatLastSeen represents when a participant last opened a chat
+type ChatLastSeen struct {
+	ChatID       int64
+	UserID       int64
+	LastOpenedAt int64
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDeleteChatRemovesKeyMaterial(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	var userIDs [3]int64
+	for i, name := range []string{"alice", "bob", "carol"} {
+		var err error
+		if userIDs[i], err = db.CreateUser(ctx, name, "hash"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alice := userIDs[0]
+
+	// Alice has a chat with bob and one with carol, both with key material,
+	// so the test also sees that only the deleted chat loses it
+	var chatIDs [2]int64
+	for i, partner := range userIDs[1:] {
+		chatID, err := db.CreateChat(ctx, alice, partner, "direct", "AES", "CBC", "PKCS7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveDHParameters(ctx, chatID, []byte{23}, []byte{5}); err != nil {
+			t.Fatal(err)
+		}
+		for _, userID := range []int64{alice, partner} {
+			if err := db.SaveDHPublicKey(ctx, chatID, userID, []byte("public")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.SaveSessionKey(ctx, chatID, []byte("session key"), make([]byte, 16)); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, err := db.SaveMessage(ctx, &NewMessage{ChatID: chatID, SenderID: alice, Ciphertext: []byte("ciphertext"), IV: make([]byte, 16)}); err != nil {
+			t.Fatal(err)
+		}
+		chatIDs[i] = chatID
+	}
+	deleted, kept := chatIDs[0], chatIDs[1]
+
+	if err := db.DeleteChat(ctx, deleted); err != nil {
+		t.Fatalf("DeleteChat: %v", err)
+	}
+
+	for _, table := range []string{"chats", "dh_parameters", "dh_public_keys", "session_keys", "messages"} {
+		column := "chat_id"
+		if table == "chats" {
+			column = "id"
+		}
+		query := "SELECT COUNT(*) FROM " + table + " WHERE " + column + " = $1"
+		if n := countRows(t, db, query, deleted); n != 0 {
+			t.Errorf("%s: %d rows left for the deleted chat", table, n)
+		}
+		if n := countRows(t, db, query, kept); n == 0 {
+			t.Errorf("%s: rows of the other chat were deleted", table)
+		}
+	}
+
+	if err := db.DeleteChat(ctx, deleted); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting a deleted chat: expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestGetChatMessagesSameSecondOrder(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	alice, err := db.CreateUser(ctx, "alice", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := db.CreateUser(ctx, "bob", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatID, err := db.CreateChat(ctx, alice, bob, "direct", "AES", "CBC", "PKCS7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int64
+	for i := 0; i < 6; i++ {
+		id, _, _, err := db.SaveMessage(ctx, &NewMessage{ChatID: chatID, SenderID: alice, Ciphertext: []byte{byte(i)}, IV: make([]byte, 16)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	// Pin every message to the same second, so only the id can order them
+	if _, err := db.q.ExecContext(ctx, "UPDATE messages SET created_at = $1 WHERE chat_id = $2", int64(1700000000), chatID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cursor int64
+		older  bool
+		want   []int64
+	}{
+		{"newest page", 0, true, ids[3:]},
+		{"older than a cursor", ids[3], true, ids[:3]},
+		{"from the start", 0, false, ids[:3]},
+		{"newer than a cursor", ids[2], false, ids[3:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := db.GetChatMessages(ctx, chatID, tt.cursor, tt.older, 3, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]int64, len(messages))
+			for i, m := range messages {
+				got[i] = m.ID
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Ratchet chain operations. The server cannot read ratchet messages; it only
+// follows the public keys and message numbers of their headers, so that a
+// sender's messages keep moving forward.
+
+// ErrStaleRatchet is returned for a message whose ratchet header repeats a
+// message number, or goes back to an earlier ratchet key, of its sender
+var ErrStaleRatchet = errors.New("ratchet header does not move the sender's ratchet forward")
+
+// RatchetStep is the ratchet header of a message being saved: the header as
+// sent, and the sender's ratchet public key and message number it carries
+type RatchetStep struct {
+	Header    []byte
+	PublicKey []byte
+	N         int64
+}
+
+// RatchetChain is the latest ratchet key of a sender in a chat. Epoch counts
+// the sender's ratchet keys from 1; LastN is the highest message number seen
+// under the key.
+type RatchetChain struct {
+	SenderID  int64  `json:"sender_id"`
+	Epoch     int64  `json:"epoch"`
+	PublicKey []byte `json:"public_key"`
+	LastN     int64  `json:"last_n"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// advanceRatchet records step for a message of senderID in tx, which holds
+// the chat row lock. A new public key starts the sender's next epoch; the
+// current one must come with a higher message number than before.
+func advanceRatchet(ctx context.Context, tx querier, chatID, senderID int64, step *RatchetStep) error {
+	var epoch, lastN int64
+	var publicKey []byte
+	err := tx.QueryRowContext(ctx,
+		"SELECT epoch, public_key, last_n FROM ratchet_chains WHERE chat_id = $1 AND sender_id = $2 ORDER BY epoch DESC LIMIT 1",
+		chatID, senderID,
+	).Scan(&epoch, &publicKey, &lastN)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if err == nil && bytes.Equal(publicKey, step.PublicKey) {
+		if step.N <= lastN {
+			return ErrStaleRatchet
+		}
+		_, err := tx.ExecContext(ctx,
+			"UPDATE ratchet_chains SET last_n = $1 WHERE chat_id = $2 AND sender_id = $3 AND epoch = $4",
+			step.N, chatID, senderID, epoch,
+		)
+		return err
+	}
+
+	var used int
+	if err := tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM ratchet_chains WHERE chat_id = $1 AND sender_id = $2 AND public_key = $3",
+		chatID, senderID, step.PublicKey,
+	).Scan(&used); err != nil {
+		return err
+	}
+	if used > 0 {
+		return ErrStaleRatchet
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO ratchet_chains (chat_id, sender_id, epoch, public_key, last_n) VALUES ($1, $2, $3, $4, $5)",
+		chatID, senderID, epoch+1, step.PublicKey, step.N,
+	)
+	return err
+}
+
+// GetRatchetChains returns the latest ratchet key of each sender in a chat,
+// ordered by sender
+func (db *DB) GetRatchetChains(ctx context.Context, chatID int64) ([]*RatchetChain, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return nil, err
+	} else if mdb != db {
+		return mdb.GetRatchetChains(ctx, chatID)
+	}
+
+	rows, err := db.q.QueryContext(ctx,
+		`SELECT sender_id, epoch, public_key, last_n, created_at FROM ratchet_chains r
+		WHERE chat_id = $1 AND epoch = (SELECT MAX(epoch) FROM ratchet_chains WHERE chat_id = r.chat_id AND sender_id = r.sender_id)
+		ORDER BY sender_id`,
+		chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chains []*RatchetChain
+	for rows.Next() {
+		chain := &RatchetChain{}
+		if err := rows.Scan(&chain.SenderID, &chain.Epoch, &chain.PublicKey, &chain.LastN, &chain.CreatedAt); err != nil {
+			return nil, err
+		}
+		chains = append(chains, chain)
+	}
+	return chains, rows.Err()
+}
+
+// ResetRatchet forgets the ratchet keys of both participants of a chat, for
+// when they start their ratchets over
+func (db *DB) ResetRatchet(ctx context.Context, chatID int64) error {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return err
+	} else if mdb != db {
+		return mdb.ResetRatchet(ctx, chatID)
+	}
+
+	_, err := db.q.ExecContext(ctx, "DELETE FROM ratchet_chains WHERE chat_id = $1", chatID)
+	return err
+}
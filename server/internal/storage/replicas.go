@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaCheckInterval is how often the replicas are pinged to take failed
+// ones out of rotation and bring recovered ones back
+const replicaCheckInterval = 10 * time.Second
+
+// replica is the connection pool of one read replica
+type replica struct {
+	addr    string
+	conn    *sqlConn
+	healthy atomic.Bool
+}
+
+// replicaSet spreads read-only queries round-robin over the healthy replicas
+type replicaSet struct {
+	replicas    []*replica
+	next        atomic.Uint64
+	pingTimeout time.Duration
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// openReplicas opens a pool for every replica host. A replica that is down
+// at startup does not fail it; it joins the rotation once a health check
+// succeeds.
+func openReplicas(cfg Config, driver string, rebind rebindFunc, stats *queryStats) (*replicaSet, error) {
+	set := &replicaSet{pingTimeout: cfg.PingTimeout, stop: make(chan struct{})}
+	for _, addr := range cfg.ReplicaHosts {
+		replicaCfg, err := hostConfig(cfg, addr)
+		if err != nil {
+			set.close()
+			return nil, err
+		}
+
+		var conn *sql.DB
+		if driver == DriverMySQL {
+			conn, err = openMySQL(replicaCfg)
+		} else {
+			conn, err = openPostgres(replicaCfg)
+		}
+		if err != nil {
+			set.close()
+			return nil, err
+		}
+		configurePool(conn, cfg)
+
+		r := &replica{addr: addr, conn: &sqlConn{DB: conn, rebind: rebind, stats: stats}}
+		if err := ping(conn, cfg.PingTimeout); err != nil {
+			log.Printf("[Storage] Read replica %s is unavailable: %v", addr, err)
+		} else {
+			r.healthy.Store(true)
+		}
+		set.replicas = append(set.replicas, r)
+	}
+
+	go set.monitor()
+	return set, nil
+}
+
+// hostConfig returns cfg for another server given as "host" or "host:port",
+// which keeps cfg.Port if the port is omitted
+func hostConfig(cfg Config, addr string) (Config, error) {
+	cfg.Host = addr
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		cfg.Host = host
+		if cfg.Port, err = strconv.Atoi(port); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// pick returns the next healthy replica, or nil if there is none
+func (s *replicaSet) pick() *replica {
+	if s == nil {
+		return nil
+	}
+	for range s.replicas {
+		r := s.replicas[s.next.Add(1)%uint64(len(s.replicas))]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// monitor pings the replicas until the set is closed
+func (s *replicaSet) monitor() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			for _, r := range s.replicas {
+				err := ping(r.conn.DB, s.pingTimeout)
+				if healthy := err == nil; healthy != r.healthy.Swap(healthy) {
+					if healthy {
+						log.Printf("[Storage] Read replica %s is back in rotation", r.addr)
+					} else {
+						log.Printf("[Storage] Read replica %s is unavailable: %v", r.addr, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *replicaSet) close() {
+	if s == nil {
+		return
+	}
+	s.stopOnce.Do(func() { close(s.stop) })
+	for _, r := range s.replicas {
+		r.conn.Close()
+	}
+}
+
+// queryRead runs a read-only query on a healthy replica. It falls back to
+// the primary if there is no healthy replica or the replica query fails, and
+// always uses the primary inside a WithTx transaction. Replicas can lag
+// behind the primary, so it is only used for reads that may be slightly
+// stale, such as history pages and chat and contact lists.
+func (db *DB) queryRead(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if db.tx == nil {
+		if r := db.replicas.pick(); r != nil {
+			rows, err := r.conn.QueryContext(ctx, query, args...)
+			if err == nil || ctx.Err() != nil {
+				return rows, err
+			}
+			// Take the replica out of rotation until the next health check
+			log.Printf("[Storage] Read replica %s failed, using the primary: %v", r.addr, err)
+			r.healthy.Store(false)
+		}
+	}
+	return db.q.QueryContext(ctx, query, args...)
+}
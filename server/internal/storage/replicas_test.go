@@ -0,0 +1,32 @@
+package storage
+
+import "testing"
+
+func TestReplicaSetPick(t *testing.T) {
+	var none *replicaSet
+	if r := none.pick(); r != nil {
+		t.Fatalf("expected no replica without a replica set, got %s", r.addr)
+	}
+
+	a, b, c := &replica{addr: "a"}, &replica{addr: "b"}, &replica{addr: "c"}
+	a.healthy.Store(true)
+	c.healthy.Store(true)
+	set := &replicaSet{replicas: []*replica{a, b, c}}
+
+	seen := map[string]int{}
+	for i := 0; i < 10; i++ {
+		seen[set.pick().addr]++
+	}
+	if seen["b"] != 0 {
+		t.Fatalf("unhealthy replica was picked %d times", seen["b"])
+	}
+	if seen["a"] != 5 || seen["c"] != 5 {
+		t.Fatalf("expected reads spread evenly over a and c, got %v", seen)
+	}
+
+	a.healthy.Store(false)
+	c.healthy.Store(false)
+	if r := set.pick(); r != nil {
+		t.Fatalf("expected no replica when all are down, got %s", r.addr)
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Search index operations
+
+// ReplaceMessageSearchTokens replaces the search tokens indexed for a message
+func (db *DB) ReplaceMessageSearchTokens(ctx context.Context, chatID, messageID int64, tokens [][]byte) error {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return err
+	} else if mdb != db {
+		return mdb.ReplaceMessageSearchTokens(ctx, chatID, messageID, tokens)
+	}
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM message_search_tokens WHERE message_id = $1", messageID); err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO message_search_tokens (chat_id, message_id, token) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			chatID, messageID, token,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SearchMessages returns the IDs of up to limit messages in a chat that are
+// indexed under every one of the given tokens, newest first. A non-zero
+// beforeID only considers messages with a lower ID.
+func (db *DB) SearchMessages(ctx context.Context, chatID int64, tokens [][]byte, beforeID int64, limit int) ([]int64, error) {
+	if mdb, err := db.messageDB(ctx, chatID); err != nil {
+		return nil, err
+	} else if mdb != db {
+		return mdb.SearchMessages(ctx, chatID, tokens, beforeID, limit)
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	args := []interface{}{chatID}
+	placeholders := make([]string, len(tokens))
+	for i, token := range tokens {
+		args = append(args, token)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+
+	query := "SELECT message_id FROM message_search_tokens WHERE chat_id = $1 AND token IN (" + strings.Join(placeholders, ", ") + ")" +
+		" AND message_id NOT IN (SELECT id FROM messages WHERE chat_id = $1 AND deleted_at IS NOT NULL)"
+	if beforeID > 0 {
+		args = append(args, beforeID)
+		query += fmt.Sprintf(" AND message_id < $%d", len(args))
+	}
+	args = append(args, len(tokens), limit)
+	query += fmt.Sprintf(" GROUP BY message_id HAVING COUNT(*) = $%d ORDER BY message_id DESC LIMIT $%d", len(args)-1, len(args))
+
+	rows, err := db.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
@@ -0,0 +1,658 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message sharding spreads the messages of chats over several Postgres
+// databases, the message shards. The primary keeps everything else (users,
+// contacts, chats, keys, files) and the shard map, chat_shards, which names
+// the shard of each chat; chats without a row keep their messages on the
+// primary, which is shard 0.
+//
+// A shard has the full schema, with the foreign keys to users and files
+// dropped, and a mirror of the chats row of every chat it holds: the mirror
+// satisfies the remaining foreign keys, carries the retention settings and
+// status that the message queries read, and numbers the chat's messages
+// (last_seq). The files rows of attachments are mirrored too, for the media
+// filters. Everything keyed by a chat's messages moves with them; see
+// shardTables. Message IDs are always taken from the primary's sequence, so
+// they stay unique and ordered whichever shard stores the message.
+
+var (
+	// ErrShardingUnsupported is returned by New when message shards are
+	// configured for a driver other than Postgres
+	ErrShardingUnsupported = errors.New("message sharding requires PostgreSQL")
+	// ErrUnknownShard is returned for a shard number that is not configured
+	ErrUnknownShard = errors.New("unknown message shard")
+	// errChatMoved is returned by a write that found the chat moved to
+	// another shard while it waited for the chat row lock
+	errChatMoved = errors.New("chat moved to another message shard")
+)
+
+// maxRouteAttempts is how many times a message write follows a chat that is
+// being moved before giving up
+const maxRouteAttempts = 3
+
+// shardTables are the tables holding a chat's messages, parents before
+// children. They move with the chat. Rows of tables with newIDs get new IDs
+// on the target shard, as their serial IDs are only unique per database;
+// messages and archived messages keep theirs.
+var shardTables = []struct {
+	name   string
+	newIDs bool
+}{
+	{"messages", false},
+	{"messages_archive", false},
+	{"chat_pins", true},
+	{"message_attachments", true},
+	{"message_search_tokens", false},
+	{"ratchet_chains", true},
+}
+
+// dropShardForeignKeysQuery drops the foreign keys of a shard that point at
+// tables only the primary fills
+const dropShardForeignKeysQuery = `
+	DO $$
+	DECLARE r RECORD;
+	BEGIN
+		FOR r IN SELECT conrelid::regclass AS tbl, conname FROM pg_constraint
+			WHERE contype = 'f' AND conparentid = 0 AND confrelid IN ('users'::regclass, 'files'::regclass)
+		LOOP
+			EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', r.tbl, r.conname);
+		END LOOP;
+	END $$`
+
+// insertShardMessageQuery is insertMessageQuery with the ID ($16) taken from
+// the primary
+var insertShardMessageQuery = strings.Replace(
+	strings.Replace(insertMessageQuery, "INSERT INTO messages (chat_id,", "INSERT INTO messages (id, chat_id,", 1),
+	"VALUES ($1,", "VALUES ($16, $1,", 1,
+)
+
+// shardSet is the connections of the message shards and the routing of
+// chats to them
+type shardSet struct {
+	// primary is the primary DB outside of any transaction
+	primary *DB
+	// shards are the message shards; shards[i] is shard i+1
+	shards []*DB
+}
+
+// ChatMove reports a chat moved by MoveChat
+type ChatMove struct {
+	ChatID int64
+	From   int
+	To     int
+	// Rows is the number of rows copied, over all of shardTables
+	Rows int64
+}
+
+// ShardStats is the number of chats and messages stored on one shard
+type ShardStats struct {
+	Shard    int    `json:"shard"`
+	Addr     string `json:"addr"`
+	Chats    int64  `json:"chats"`
+	Messages int64  `json:"messages"`
+}
+
+// openShards opens a pool for every message shard. Unlike a read replica, a
+// shard that is down fails startup: the messages it holds are not available
+// anywhere else.
+func openShards(cfg Config, primary *DB, stats *queryStats) (*shardSet, error) {
+	set := &shardSet{primary: primary}
+	for i, addr := range cfg.MessageShards {
+		shardCfg, err := hostConfig(cfg, addr)
+		if err != nil {
+			set.close()
+			return nil, err
+		}
+		conn, err := openPostgres(shardCfg)
+		if err != nil {
+			set.close()
+			return nil, err
+		}
+		configurePool(conn, cfg)
+		if err := ping(conn, cfg.PingTimeout); err != nil {
+			conn.Close()
+			set.close()
+			return nil, fmt.Errorf("message shard %d (%s): %w", i+1, addr, err)
+		}
+
+		shard := &DB{
+			conn:    &sqlConn{DB: conn, rebind: noRebind, stats: stats},
+			driver:  DriverPostgres,
+			shards:  set,
+			shardID: i + 1,
+			addr:    addr,
+		}
+		shard.q = shard.conn
+		set.shards = append(set.shards, shard)
+	}
+	return set, nil
+}
+
+func (s *shardSet) close() {
+	if s == nil {
+		return
+	}
+	for _, shard := range s.shards {
+		shard.conn.Close()
+	}
+}
+
+// initSchemas brings the schema of every shard up to date
+func (s *shardSet) initSchemas(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	for _, shard := range s.shards {
+		if _, err := shard.MigrateUp(ctx, 0); err != nil {
+			return fmt.Errorf("message shard %d: %w", shard.shardID, err)
+		}
+		if _, err := shard.q.ExecContext(ctx, dropShardForeignKeysQuery); err != nil {
+			return fmt.Errorf("message shard %d: %w", shard.shardID, err)
+		}
+	}
+	return nil
+}
+
+// db returns a shard by number, the primary for 0
+func (s *shardSet) db(shard int) (*DB, error) {
+	if shard == 0 {
+		return s.primary, nil
+	}
+	if shard < 0 || shard > len(s.shards) {
+		return nil, fmt.Errorf("%w %d", ErrUnknownShard, shard)
+	}
+	return s.shards[shard-1], nil
+}
+
+// newChatShard picks the shard for a chat that has no messages yet. New
+// chats go to the shards only, so adding shards takes load off the primary.
+func (s *shardSet) newChatShard(chatID int64) int {
+	return 1 + int(chatID%int64(len(s.shards)))
+}
+
+// messageDBs returns every database that holds messages: db itself, then the
+// shards
+func (db *DB) messageDBs() []*DB {
+	if db.shards == nil || db.shardID != 0 {
+		return []*DB{db}
+	}
+	return append([]*DB{db}, db.shards.shards...)
+}
+
+// chatShard returns the shard holding the messages of chatID, 0 for the
+// primary. It reads the committed shard map, outside of any transaction.
+func (db *DB) chatShard(ctx context.Context, chatID int64) (int, error) {
+	var shard int
+	err := db.shards.primary.q.QueryRowContext(ctx, "SELECT shard_id FROM chat_shards WHERE chat_id = $1", chatID).Scan(&shard)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return shard, err
+}
+
+// messageDB returns the database holding the messages of chatID: db itself
+// without sharding, on a shard, or for a chat kept on the primary
+func (db *DB) messageDB(ctx context.Context, chatID int64) (*DB, error) {
+	if db.shards == nil || db.shardID != 0 {
+		return db, nil
+	}
+	shard, err := db.chatShard(ctx, chatID)
+	if err != nil || shard == 0 {
+		return db, err
+	}
+	return db.shards.db(shard)
+}
+
+// messageWriteDB is messageDB for storing new messages. A chat that has never
+// had a message is placed on a shard first; chats with messages from before
+// sharding was enabled stay on the primary until they are moved.
+func (db *DB) messageWriteDB(ctx context.Context, chatID int64) (*DB, error) {
+	if db.shards == nil || db.shardID != 0 {
+		return db, nil
+	}
+
+	var shard sql.NullInt64
+	var lastSeq int64
+	err := db.shards.primary.q.QueryRowContext(ctx,
+		"SELECT s.shard_id, c.last_seq FROM chats c LEFT JOIN chat_shards s ON s.chat_id = c.id WHERE c.id = $1",
+		chatID,
+	).Scan(&shard, &lastSeq)
+	if errors.Is(err, sql.ErrNoRows) {
+		// No such chat: let the insert fail on the primary as it always has
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case shard.Valid && shard.Int64 == 0:
+		return db, nil
+	case shard.Valid:
+		return db.shards.db(int(shard.Int64))
+	case lastSeq > 0:
+		return db, nil
+	}
+
+	target, err := db.shards.db(db.shards.newChatShard(chatID))
+	if err != nil {
+		return nil, err
+	}
+	// The mirror must exist before the chat is routed to the shard. Racing
+	// writers pick the same shard and the loser's insert is a no-op.
+	if err := db.shards.mirrorChat(ctx, target.q, chatID, nil); err != nil {
+		return nil, err
+	}
+	if _, err := db.shards.primary.q.ExecContext(ctx,
+		"INSERT INTO chat_shards (chat_id, shard_id) VALUES ($1, $2) ON CONFLICT (chat_id) DO NOTHING",
+		chatID, target.shardID,
+	); err != nil {
+		return nil, err
+	}
+	return db.messageDB(ctx, chatID)
+}
+
+// confirmChatShard fails with errChatMoved if the messages of chatID are no
+// longer on this database. Writers call it while holding the chat row lock,
+// which MoveChat keeps until the shard map points at the new shard.
+func (db *DB) confirmChatShard(ctx context.Context, chatID int64) error {
+	if db.shards == nil {
+		return nil
+	}
+	shard, err := db.chatShard(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if shard != db.shardID {
+		return errChatMoved
+	}
+	return nil
+}
+
+// nextMessageIDs takes n message IDs from the primary's sequence
+func (s *shardSet) nextMessageIDs(ctx context.Context, n int) ([]int64, error) {
+	rows, err := s.primary.q.QueryContext(ctx,
+		"SELECT nextval(pg_get_serial_sequence('messages', 'id')) FROM generate_series(1, $1)",
+		n,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+// touchPrimaryChat brings the numbering and activity of a chat's row on the
+// primary, which chat lists read, up to date with a write on a shard. It runs
+// after the shard commit, so a failure only delays them until the next
+// message and is logged rather than failing a message that was stored.
+func (s *shardSet) touchPrimaryChat(ctx context.Context, chatID, lastSeq, activity int64) {
+	if _, err := s.primary.q.ExecContext(ctx,
+		"UPDATE chats SET last_seq = GREATEST(last_seq, $1), last_activity_at = GREATEST(last_activity_at, $2) WHERE id = $3",
+		lastSeq, activity, chatID,
+	); err != nil {
+		log.Printf("[Storage] Failed to update chat %d after a shard write: %v", chatID, err)
+	}
+}
+
+// mirrorChat copies chatID's row from the primary to a shard through q,
+// updating the mirror if it exists. The mirror keeps its own last_seq unless
+// lastSeq is given.
+func (s *shardSet) mirrorChat(ctx context.Context, q querier, chatID int64, lastSeq *int64) error {
+	rows, err := s.primary.q.QueryContext(ctx, "SELECT * FROM chats WHERE id = $1", chatID)
+	if err != nil {
+		return err
+	}
+	columns, values, err := scanSingleRow(rows)
+	if err != nil || columns == nil {
+		return err
+	}
+
+	placeholders := make([]string, len(columns))
+	var updates []string
+	for i, column := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		if column == "last_seq" && lastSeq != nil {
+			values[i] = *lastSeq
+		}
+		if column != "id" && (column != "last_seq" || lastSeq != nil) {
+			updates = append(updates, column+" = EXCLUDED."+column)
+		}
+	}
+	_, err = q.ExecContext(ctx,
+		"INSERT INTO chats ("+strings.Join(columns, ", ")+") VALUES ("+strings.Join(placeholders, ", ")+
+			") ON CONFLICT (id) DO UPDATE SET "+strings.Join(updates, ", "),
+		values...,
+	)
+	return err
+}
+
+// mirrorFiles copies the files rows of the given IDs, or of every file of
+// chatID if fileIDs is empty, from the primary to a shard through q. Shards
+// keep them for the media filters of history reads only; files are served
+// from the primary.
+func (s *shardSet) mirrorFiles(ctx context.Context, q querier, chatID int64, fileIDs []int64) error {
+	query, args := "SELECT * FROM files WHERE chat_id = $1", []interface{}{chatID}
+	if len(fileIDs) > 0 {
+		placeholders := make([]string, len(fileIDs))
+		for i, id := range fileIDs {
+			placeholders[i] = fmt.Sprintf("$%d", i+2)
+			args = append(args, id)
+		}
+		query += " AND id IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	_, err := copyRows(ctx, s.primary.q, q, "files", false, " ON CONFLICT (id) DO NOTHING", query, args...)
+	return err
+}
+
+// syncChatMirror updates the mirror of chatID after its row changed on the
+// primary, once the change is committed
+func (db *DB) syncChatMirror(ctx context.Context, chatID int64) {
+	if db.shards == nil {
+		return
+	}
+	db.afterCommit(func() {
+		shard, err := db.chatShard(ctx, chatID)
+		if err != nil || shard == 0 {
+			if err != nil {
+				log.Printf("[Storage] Failed to look up the shard of chat %d: %v", chatID, err)
+			}
+			return
+		}
+		target, err := db.shards.db(shard)
+		if err == nil {
+			err = db.shards.mirrorChat(ctx, target.q, chatID, nil)
+		}
+		if err != nil {
+			log.Printf("[Storage] Failed to update chat %d on message shard %d: %v", chatID, shard, err)
+		}
+	})
+}
+
+// dropChatFromShard deletes what a shard holds of a chat that was deleted on
+// the primary; shard is where the chat was before the deletion committed
+func (db *DB) dropChatFromShard(ctx context.Context, chatID int64, shard int) {
+	if db.shards == nil || shard == 0 {
+		return
+	}
+	db.afterCommit(func() {
+		target, err := db.shards.db(shard)
+		if err == nil {
+			err = target.DeleteChat(ctx, chatID)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("[Storage] Failed to delete chat %d from message shard %d: %v", chatID, shard, err)
+		}
+	})
+}
+
+// MoveChat moves the messages of chatID, and everything in shardTables that
+// belongs to them, to shard (0 for the primary). New messages for the chat
+// wait for the move and then go to the new shard; reads keep being served by
+// the old shard until the move is done. Delivery and read receipts recorded
+// while the chat moves may be lost. Returns nil if the chat does not exist.
+func (db *DB) MoveChat(ctx context.Context, chatID int64, shard int) (*ChatMove, error) {
+	if db.shards == nil {
+		return nil, ErrUnknownShard
+	}
+	target, err := db.shards.db(shard)
+	if err != nil {
+		return nil, err
+	}
+	from, err := db.chatShard(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	move := &ChatMove{ChatID: chatID, From: from, To: shard}
+	if from == shard {
+		return move, nil
+	}
+	source, err := db.shards.db(from)
+	if err != nil {
+		return nil, err
+	}
+
+	// Locking the chat row on the source holds off writers until the shard
+	// map points at the target; they then find the chat moved and retry there.
+	// NO KEY UPDATE still lets the shard map row reference the chat.
+	srcTx, err := source.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer srcTx.Rollback()
+	var lastSeq int64
+	err = srcTx.QueryRowContext(ctx, "SELECT last_seq FROM chats WHERE id = $1 FOR NO KEY UPDATE", chatID).Scan(&lastSeq)
+	if errors.Is(err, sql.ErrNoRows) && from == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dstTx, err := target.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dstTx.Rollback()
+	if shard == 0 {
+		_, err = dstTx.ExecContext(ctx, "UPDATE chats SET last_seq = GREATEST(last_seq, $1) WHERE id = $2", lastSeq, chatID)
+	} else {
+		err = db.shards.mirrorChat(ctx, dstTx, chatID, &lastSeq)
+	}
+	if err == nil && shard != 0 {
+		err = db.shards.mirrorFiles(ctx, dstTx, chatID, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Rows left behind by an earlier move that failed halfway
+	if err := deleteChatMessageRows(ctx, dstTx, chatID); err != nil {
+		return nil, err
+	}
+	for _, table := range shardTables {
+		n, err := copyChatRows(ctx, srcTx, dstTx, table.name, table.newIDs, chatID)
+		if err != nil {
+			return nil, fmt.Errorf("copying %s: %w", table.name, err)
+		}
+		move.Rows += n
+	}
+	if err := dstTx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.shards.primary.q.ExecContext(ctx,
+		`INSERT INTO chat_shards (chat_id, shard_id, moved_at) VALUES ($1, $2, $3)
+		ON CONFLICT (chat_id) DO UPDATE SET shard_id = EXCLUDED.shard_id, moved_at = EXCLUDED.moved_at`,
+		chatID, shard, time.Now().Unix(),
+	); err != nil {
+		return nil, err
+	}
+
+	if from == 0 {
+		err = deleteChatMessageRows(ctx, srcTx, chatID)
+	} else {
+		_, err = deleteChatRows(ctx, srcTx, chatID)
+	}
+	if err == nil {
+		err = srcTx.Commit()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("chat %d moved to shard %d but its old copy on shard %d was not removed: %w", chatID, shard, from, err)
+	}
+	return move, nil
+}
+
+// ListShardChats returns up to limit IDs of chats whose messages are on
+// shard, in ID order after afterID
+func (db *DB) ListShardChats(ctx context.Context, shard int, afterID int64, limit int) ([]int64, error) {
+	rows, err := db.q.QueryContext(ctx,
+		`SELECT c.id FROM chats c LEFT JOIN chat_shards s ON s.chat_id = c.id
+		WHERE COALESCE(s.shard_id, 0) = $1 AND c.id > $2 ORDER BY c.id LIMIT $3`,
+		shard, afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+// ShardStats counts the chats and messages of the primary and every shard.
+// Message counts are exact and scan the messages tables.
+func (db *DB) ShardStats(ctx context.Context) ([]ShardStats, error) {
+	counts := make(map[int]int64)
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT COALESCE(s.shard_id, 0), COUNT(*) FROM chats c LEFT JOIN chat_shards s ON s.chat_id = c.id GROUP BY 1",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var shard int
+		var n int64
+		if err := rows.Scan(&shard, &n); err != nil {
+			return nil, err
+		}
+		counts[shard] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var stats []ShardStats
+	for _, mdb := range db.messageDBs() {
+		st := ShardStats{Shard: mdb.shardID, Addr: mdb.addr, Chats: counts[mdb.shardID]}
+		if err := mdb.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM messages").Scan(&st.Messages); err != nil {
+			return nil, fmt.Errorf("message shard %d: %w", mdb.shardID, err)
+		}
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+// deleteChatMessageRows deletes a chat's rows in shardTables, children first
+func deleteChatMessageRows(ctx context.Context, tx *sqlTx, chatID int64) error {
+	for i := len(shardTables) - 1; i >= 0; i-- {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+shardTables[i].name+" WHERE chat_id = $1", chatID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyChatRows copies a chat's rows of table from src to dst, in ID order
+// where the table has IDs, and returns how many were copied
+func copyChatRows(ctx context.Context, src, dst querier, table string, newIDs bool, chatID int64) (int64, error) {
+	query := "SELECT * FROM " + table + " WHERE chat_id = $1"
+	if !tablesWithoutID[table] {
+		// Replies point at earlier messages, so messages go in ID order
+		query += " ORDER BY id"
+	}
+	return copyRows(ctx, src, dst, table, newIDs, "", query, chatID)
+}
+
+// copyRows inserts the rows query selects from src into table on dst, with
+// onConflict appended to each INSERT, and returns how many were copied
+func copyRows(ctx context.Context, src, dst querier, table string, newIDs bool, onConflict, query string, args ...interface{}) (int64, error) {
+	rows, err := src.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	var keep []int
+	for i, column := range columns {
+		if column != "id" || !newIDs {
+			names = append(names, column)
+			keep = append(keep, i)
+		}
+	}
+	insert := "INSERT INTO " + table + " (" + strings.Join(names, ", ") + ") VALUES "
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var tuples []string
+	var batch []interface{}
+	flush := func() error {
+		if len(tuples) == 0 {
+			return nil
+		}
+		_, err := dst.ExecContext(ctx, insert+strings.Join(tuples, ", ")+onConflict, batch...)
+		tuples, batch = tuples[:0], batch[:0]
+		return err
+	}
+
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		tuples = append(tuples, bulkValues(len(batch), len(keep)))
+		for _, c := range keep {
+			batch = append(batch, values[c])
+		}
+		n++
+		if len(tuples) == bulkInsertRows {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, flush()
+}
+
+// scanSingleRow reads the column names and values of the only row of rows
+// and closes it. columns is nil if there is no row.
+func scanSingleRow(rows *sql.Rows) (columns []string, values []interface{}, err error) {
+	defer rows.Close()
+	columns, err = rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !rows.Next() {
+		return nil, nil, rows.Err()
+	}
+	values = make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, nil, err
+	}
+	return columns, values, nil
+}
+
+// mergeMessages merges per-database message lists, each in ID order, into
+// one list in ID order of at most limit messages
+func mergeMessages(lists [][]*Message, limit int) []*Message {
+	var merged []*Message
+	for _, list := range lists {
+		merged = append(merged, list...)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].ID < merged[j].ID })
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeMessages(t *testing.T) {
+	lists := [][]*Message{
+		{{ID: 2}, {ID: 5}},
+		nil,
+		{{ID: 1}, {ID: 3}, {ID: 4}},
+	}
+	merged := mergeMessages(lists, 4)
+	if len(merged) != 4 {
+		t.Fatalf("expected 4 messages, got %d", len(merged))
+	}
+	for i, msg := range merged {
+		if msg.ID != int64(i+1) {
+			t.Fatalf("expected message %d at position %d, got %d", i+1, i, msg.ID)
+		}
+	}
+}
+
+func TestNewChatShardSkipsPrimary(t *testing.T) {
+	set := &shardSet{shards: make([]*DB, 3)}
+	seen := map[int]int{}
+	for chatID := int64(1); chatID <= 30; chatID++ {
+		seen[set.newChatShard(chatID)]++
+	}
+	if seen[0] != 0 {
+		t.Fatalf("new chats were placed on the primary %d times", seen[0])
+	}
+	if seen[1] != 10 || seen[2] != 10 || seen[3] != 10 {
+		t.Fatalf("expected new chats spread evenly over 3 shards, got %v", seen)
+	}
+}
+
+func TestInsertShardMessageQueryTakesID(t *testing.T) {
+	if !strings.HasPrefix(insertShardMessageQuery, "INSERT INTO messages (id, chat_id,") {
+		t.Fatalf("ID column missing: %s", insertShardMessageQuery)
+	}
+	if !strings.Contains(insertShardMessageQuery, "VALUES ($16, $1,") {
+		t.Fatalf("ID placeholder missing: %s", insertShardMessageQuery)
+	}
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite opens (creating if needed) the SQLite database at path. SQLite
+// allows one writer at a time, so the pool is limited to a single connection,
+// which also keeps ":memory:" databases from splitting across connections.
+func openSQLite(path string) (*sql.DB, error) {
+	if path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	// Foreign keys are off by default in SQLite and ON DELETE CASCADE relies
+	// on them; LIKE is made case-sensitive to match Postgres
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=case_sensitive_like(1)"
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxOpenConns(1)
+	return conn, nil
+}
+
+// sqliteReplacer maps the Postgres functions and literals used by the
+// queries in this package to their SQLite equivalents
+var sqliteReplacer = strings.NewReplacer(
+	"EXTRACT(EPOCH FROM NOW())::BIGINT", "(CAST(strftime('%s', 'now') AS INTEGER))",
+	"''::bytea", "X''",
+	"GREATEST(", "MAX(",
+	"OCTET_LENGTH(", "LENGTH(",
+)
+
+// sqliteRebind rewrites a Postgres query for SQLite; arguments are unchanged
+func sqliteRebind(query string, args []interface{}) (string, []interface{}) {
+	query = sqliteReplacer.Replace(query)
+	query = pgCastRE.ReplaceAllString(query, "")
+	// $1, $2, ... become ?1, ?2, ... which SQLite binds by the same index
+	return pgPlaceholderRE.ReplaceAllString(query, "?${1}"), args
+}
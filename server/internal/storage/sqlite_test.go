@@ -0,0 +1,50 @@
+package storage
+
+import "testing"
+
+func TestSQLiteRebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			"placeholders keep their index",
+			"UPDATE chats SET status = $1 WHERE id = $2 AND user1_id = $1",
+			"UPDATE chats SET status = ?1 WHERE id = ?2 AND user1_id = ?1",
+		},
+		{
+			"current time",
+			"UPDATE chats SET updated_at = EXTRACT(EPOCH FROM NOW())::BIGINT WHERE id = $1",
+			"UPDATE chats SET updated_at = (CAST(strftime('%s', 'now') AS INTEGER)) WHERE id = ?1",
+		},
+		{
+			"casts are dropped",
+			"SELECT NULLIF($1::INT, 0), (CASE WHEN x > 0 THEN x END)::BIGINT * 86400",
+			"SELECT NULLIF(?1, 0), (CASE WHEN x > 0 THEN x END) * 86400",
+		},
+		{
+			"empty bytea",
+			"SELECT COALESCE(iv, ''::bytea) FROM messages",
+			"SELECT COALESCE(iv, X'') FROM messages",
+		},
+		{
+			"functions",
+			"SELECT GREATEST(last_activity_at, $1), SUM(OCTET_LENGTH(ciphertext))",
+			"SELECT MAX(last_activity_at, ?1), SUM(LENGTH(ciphertext))",
+		},
+		{
+			"two-digit placeholders",
+			"VALUES ($1, $10, $11)",
+			"VALUES (?1, ?10, ?11)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := sqliteRebind(tt.query, nil); got != tt.want {
+				t.Fatalf("expected\n%s\ngot\n%s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// largestChatsLimit is how many chats StorageStats ranks by size
+const largestChatsLimit = 10
+
+// growthWindows are the periods, in days, StorageStats reports growth over
+var growthWindows = []int{1, 7, 30}
+
+// StorageStats is a capacity report of the database
+type StorageStats struct {
+	Tables       []TableStats  `json:"tables"`
+	LargestChats []ChatSize    `json:"largest_chats"`
+	Growth       []GrowthStats `json:"growth"`
+	CollectedAt  int64         `json:"collected_at"`
+}
+
+// TableStats is the size of one table. Bytes includes its indexes and, on
+// Postgres, its partitions and TOAST data.
+type TableStats struct {
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+	Bytes int64  `json:"bytes"`
+}
+
+// ChatSize is the amount of message data stored for one chat, archived
+// messages included
+type ChatSize struct {
+	ChatID          int64 `json:"chat_id"`
+	MessageCount    int64 `json:"message_count"`
+	CiphertextBytes int64 `json:"ciphertext_bytes"`
+}
+
+// GrowthStats is what was added to the database in the last Days days
+type GrowthStats struct {
+	Days            int   `json:"days"`
+	Messages        int64 `json:"messages"`
+	CiphertextBytes int64 `json:"ciphertext_bytes"`
+	Users           int64 `json:"users"`
+	Files           int64 `json:"files"`
+	FileBytes       int64 `json:"file_bytes"`
+}
+
+// StorageStats reports row counts and sizes of every table, the chats with
+// the most message data and how much was added recently. Row counts are
+// exact, so this scans every table and is meant for occasional admin use,
+// not for frequent polling.
+func (db *DB) StorageStats(ctx context.Context) (*StorageStats, error) {
+	stats := &StorageStats{CollectedAt: time.Now().Unix()}
+
+	for _, table := range backupTables {
+		t := TableStats{Table: table}
+		if err := db.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&t.Rows); err != nil {
+			return nil, err
+		}
+		if err := db.q.QueryRowContext(ctx, db.tableSizeQuery(), table).Scan(&t.Bytes); err != nil {
+			return nil, err
+		}
+		stats.Tables = append(stats.Tables, t)
+	}
+
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT chat_id, COUNT(*), COALESCE(SUM(OCTET_LENGTH(ciphertext)), 0) FROM "+messagesSource(true)+
+			" GROUP BY chat_id ORDER BY 3 DESC, chat_id LIMIT $1",
+		largestChatsLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := ChatSize{}
+		if err := rows.Scan(&c.ChatID, &c.MessageCount, &c.CiphertextBytes); err != nil {
+			return nil, err
+		}
+		stats.LargestChats = append(stats.LargestChats, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, days := range growthWindows {
+		g := GrowthStats{Days: days}
+		since := stats.CollectedAt - int64(days)*86400
+		err := db.q.QueryRowContext(ctx,
+			"SELECT COUNT(*), COALESCE(SUM(OCTET_LENGTH(ciphertext)), 0) FROM "+messagesSource(true)+" WHERE created_at >= $1",
+			since,
+		).Scan(&g.Messages, &g.CiphertextBytes)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE created_at >= $1", since).Scan(&g.Users); err != nil {
+			return nil, err
+		}
+		err = db.q.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(SUM(size), 0) FROM files WHERE created_at >= $1", since).Scan(&g.Files, &g.FileBytes)
+		if err != nil {
+			return nil, err
+		}
+		stats.Growth = append(stats.Growth, g)
+	}
+
+	return stats, nil
+}
+
+// tableSizeQuery returns the query for the on-disk size in bytes of the table
+// named by $1, indexes included
+func (db *DB) tableSizeQuery() string {
+	switch db.driver {
+	case DriverSQLite:
+		return "SELECT COALESCE(SUM(pgsize), 0) FROM dbstat WHERE name IN (SELECT name FROM sqlite_master WHERE tbl_name = $1)"
+	case DriverMySQL:
+		return "SELECT COALESCE(SUM(DATA_LENGTH + INDEX_LENGTH), 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = $1"
+	default:
+		// A partitioned table holds no data itself; its partitions do
+		return `SELECT COALESCE(SUM(pg_total_relation_size(c.oid)), 0)::BIGINT FROM pg_class c
+			WHERE c.oid = $1::regclass OR c.oid IN (SELECT inhrelid FROM pg_inherits WHERE inhparent = $1::regclass)`
+	}
+}
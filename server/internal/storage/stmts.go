@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Hot queries run for nearly every request (access checks) or message
+// (SaveMessage). They are prepared once at startup so that each call skips
+// parsing and planning on the server.
+const (
+	getUserByIDQuery = "SELECT id, username, hashed_password, public_key, encrypted_private_key, created_at FROM users WHERE id = $1 AND deleted_at IS NULL"
+	getContactQuery  = "SELECT id, user1_id, user2_id, requester_id, status, created_at FROM contacts WHERE user1_id = $1 AND user2_id = $2"
+	getChatQuery     = "SELECT id, user1_id, user2_id, chat_type, algorithm, mode, padding, status, created_at, closed_at, last_activity_at, last_seq, retention_days, retention_max_messages, history_retained, version FROM chats WHERE id = $1"
+
+	nextSeqQuery       = "UPDATE chats SET last_seq = last_seq + 1 WHERE id = $1"
+	lastSeqQuery       = "SELECT last_seq FROM chats WHERE id = $1"
+	insertMessageQuery = `INSERT INTO messages (chat_id, sender_id, ciphertext, iv, file_name, mime_type, reply_to_message_id, message_uuid, seq, preview, preview_iv, urgent, expires_at, created_at, ratchet_header) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (chat_id, message_uuid) WHERE message_uuid IS NOT NULL DO NOTHING`
+	touchChatActivityQuery = "UPDATE chats SET last_activity_at = GREATEST(last_activity_at, $1) WHERE id = $2"
+)
+
+// hotQueries lists the queries to prepare, exactly as they are passed to the
+// connection
+func (db *DB) hotQueries() []string {
+	return []string{
+		getUserByIDQuery,
+		getContactQuery,
+		getChatQuery,
+		nextSeqQuery,
+		lastSeqQuery,
+		db.insertQuery(insertMessageQuery),
+		touchChatActivityQuery,
+	}
+}
+
+// prepareStatements prepares the hot queries on the pool. Queries without a
+// prepared statement keep running unprepared.
+func (db *DB) prepareStatements(ctx context.Context) error {
+	db.closeStatements()
+
+	stmts := make(map[string]*sql.Stmt)
+	for _, query := range db.hotQueries() {
+		rebound, _ := db.conn.rebind(query, nil)
+		stmt, err := db.conn.DB.PrepareContext(ctx, rebound)
+		if err != nil {
+			for _, stmt := range stmts {
+				stmt.Close()
+			}
+			return fmt.Errorf("prepare %q: %w", query, err)
+		}
+		stmts[query] = stmt
+	}
+	db.conn.stmts = stmts
+	return nil
+}
+
+// closeStatements closes and forgets the prepared statements
+func (db *DB) closeStatements() {
+	for _, stmt := range db.conn.stmts {
+		stmt.Close()
+	}
+	db.conn.stmts = nil
+}
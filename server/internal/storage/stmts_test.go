@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// The benchmarks compare the hot queries with and without prepared
+// statements on a temporary SQLite database:
+//
+//	go test -run '^$' -bench . ./internal/storage
+
+// newTestDB opens a fresh SQLite database with the schema applied
+func newTestDB(tb testing.TB) *DB {
+	tb.Helper()
+	db, err := New(Config{Driver: DriverSQLite, Path: filepath.Join(tb.TempDir(), "test.db")})
+	if err != nil {
+		tb.Fatalf("open: %v", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	if err := db.InitSchema(context.Background()); err != nil {
+		tb.Fatalf("init schema: %v", err)
+	}
+	return db
+}
+
+func newBenchDB(b *testing.B, prepared bool) (db *DB, userID, chatID int64) {
+	b.Helper()
+	ctx := context.Background()
+
+	db = newTestDB(b)
+	if !prepared {
+		db.closeStatements()
+	}
+
+	userID, err := db.CreateUser(ctx, "alice", "hash")
+	if err != nil {
+		b.Fatalf("create user: %v", err)
+	}
+	otherID, err := db.CreateUser(ctx, "bob", "hash")
+	if err != nil {
+		b.Fatalf("create user: %v", err)
+	}
+	if _, err := db.AddContact(ctx, userID, otherID, "accepted"); err != nil {
+		b.Fatalf("add contact: %v", err)
+	}
+	chatID, err = db.CreateChat(ctx, userID, otherID, "direct", "AES", "CBC", "PKCS7")
+	if err != nil {
+		b.Fatalf("create chat: %v", err)
+	}
+	return db, userID, chatID
+}
+
+func benchPrepared(b *testing.B, run func(b *testing.B, db *DB, userID, chatID int64)) {
+	for _, prepared := range []bool{false, true} {
+		b.Run(fmt.Sprintf("prepared=%t", prepared), func(b *testing.B) {
+			db, userID, chatID := newBenchDB(b, prepared)
+			b.ReportAllocs()
+			b.ResetTimer()
+			run(b, db, userID, chatID)
+		})
+	}
+}
+
+func BenchmarkGetChat(b *testing.B) {
+	benchPrepared(b, func(b *testing.B, db *DB, userID, chatID int64) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.GetChat(context.Background(), chatID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetUserByID(b *testing.B) {
+	benchPrepared(b, func(b *testing.B, db *DB, userID, chatID int64) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.GetUserByID(context.Background(), userID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSaveMessage(b *testing.B) {
+	benchPrepared(b, func(b *testing.B, db *DB, userID, chatID int64) {
+		m := &NewMessage{ChatID: chatID, SenderID: userID, Ciphertext: make([]byte, 256), IV: make([]byte, 16)}
+		for i := 0; i < b.N; i++ {
+			_, _, _, err := db.SaveMessage(context.Background(), m)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Account sync operations
+
+// ListMessagesSince returns up to limit messages with an ID above afterID from
+// every chat of the user, oldest first. Messages of soft-closed chats are
+// hidden, like in the chat history.
+func (db *DB) ListMessagesSince(ctx context.Context, userID, afterID int64, limit int) ([]*Message, error) {
+	dbs := db.messageDBs()
+	if len(dbs) == 1 {
+		return db.listMessagesSince(ctx, userID, afterID, limit)
+	}
+	lists := make([][]*Message, len(dbs))
+	for i, mdb := range dbs {
+		var err error
+		if lists[i], err = mdb.listMessagesSince(ctx, userID, afterID, limit); err != nil {
+			return nil, err
+		}
+	}
+	return mergeMessages(lists, limit), nil
+}
+
+func (db *DB) listMessagesSince(ctx context.Context, userID, afterID int64, limit int) ([]*Message, error) {
+	rows, err := db.q.QueryContext(ctx,
+		`SELECT m.id, m.chat_id, m.sender_id, m.ciphertext, COALESCE(m.iv, ''::bytea), COALESCE(m.file_name, ''), COALESCE(m.mime_type, ''), m.created_at, m.reply_to_message_id, m.delivered_at, m.read_at, COALESCE(m.message_uuid, ''), m.seq, m.preview, m.preview_iv, m.urgent, m.expires_at, m.ratchet_header
+		FROM messages m JOIN chats c ON c.id = m.chat_id
+		WHERE (c.user1_id = $1 OR c.user2_id = $1) AND c.status <> 'closed' AND m.id > $2 AND m.deleted_at IS NULL
+		AND (m.expires_at IS NULL OR m.expires_at > EXTRACT(EPOCH FROM NOW())::BIGINT)
+		ORDER BY m.id LIMIT $3`,
+		userID, afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg := &Message{}
+		var replyTo, deliveredAt, readAt, expiresAt sql.NullInt64
+		err := rows.Scan(&msg.ID, &msg.ChatID, &msg.SenderID, &msg.Ciphertext, &msg.IV, &msg.FileName, &msg.MimeType, &msg.CreatedAt, &replyTo, &deliveredAt, &readAt, &msg.MessageUUID, &msg.Seq, &msg.Preview, &msg.PreviewIV, &msg.Urgent, &expiresAt, &msg.RatchetHeader)
+		if err != nil {
+			return nil, err
+		}
+		if replyTo.Valid {
+			msg.ReplyToMessageID = &replyTo.Int64
+		}
+		if expiresAt.Valid {
+			msg.ExpiresAt = &expiresAt.Int64
+		}
+		if deliveredAt.Valid {
+			msg.DeliveredAt = &deliveredAt.Int64
+		}
+		if readAt.Valid {
+			msg.ReadAt = &readAt.Int64
+		}
+		msg.Timestamp = msg.CreatedAt
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// ListChatsChangedSince lists the user's chats (any status) created or updated
+// at or after since (unix seconds)
+func (db *DB) ListChatsChangedSince(ctx context.Context, userID, since int64) ([]*Chat, error) {
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT id, user1_id, user2_id, chat_type, algorithm, mode, padding, status, created_at, closed_at, last_activity_at, last_seq, retention_days, retention_max_messages, history_retained, version FROM chats WHERE (user1_id = $1 OR user2_id = $1) AND updated_at >= $2 ORDER BY id",
+		userID, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []*Chat
+	for rows.Next() {
+		chat := &Chat{}
+		err := rows.Scan(&chat.ID, &chat.User1ID, &chat.User2ID, &chat.ChatType, &chat.Algorithm, &chat.Mode, &chat.Padding, &chat.Status, &chat.CreatedAt, &chat.ClosedAt, &chat.LastActivityAt, &chat.LastSeq, &chat.RetentionDays, &chat.RetentionMaxMessages, &chat.HistoryRetained, &chat.Version)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// ListContactsChangedSince lists the user's contacts (any status) created or
+// updated at or after since (unix seconds)
+func (db *DB) ListContactsChangedSince(ctx context.Context, userID, since int64) ([]*Contact, error) {
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT id, user1_id, user2_id, requester_id, status, created_at FROM contacts WHERE (user1_id = $1 OR user2_id = $1) AND updated_at >= $2 ORDER BY id",
+		userID, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []*Contact
+	for rows.Next() {
+		contact := &Contact{}
+		err := rows.Scan(&contact.ID, &contact.User1ID, &contact.User2ID, &contact.RequesterID, &contact.Status, &contact.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+// ListUserChatIDs returns the IDs of every chat the user is in, any status
+func (db *DB) ListUserChatIDs(ctx context.Context, userID int64) ([]int64, error) {
+	rows, err := db.q.QueryContext(ctx, "SELECT id FROM chats WHERE user1_id = $1 OR user2_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+// ListUserContactIDs returns the IDs of every contact relationship of the user
+func (db *DB) ListUserContactIDs(ctx context.Context, userID int64) ([]int64, error) {
+	rows, err := db.q.QueryContext(ctx, "SELECT id FROM contacts WHERE user1_id = $1 OR user2_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
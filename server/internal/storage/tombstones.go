@@ -0,0 +1,181 @@
+package storage
+
+import "context"
+
+// Soft deletes. A deleted user or message keeps its row with deleted_at set:
+// every query skips it, but it can be restored until the retention purge
+// removes it for good (see PurgePolicy.TombstoneDays).
+
+// SoftDeleteMessage tombstones a message. Returns false if the message does
+// not exist or is already deleted.
+func (db *DB) SoftDeleteMessage(ctx context.Context, messageID int64) (bool, error) {
+	return db.tombstoneMessage(ctx, "UPDATE messages SET deleted_at = EXTRACT(EPOCH FROM NOW())::BIGINT WHERE id = $1 AND deleted_at IS NULL", messageID)
+}
+
+// RestoreMessage undoes SoftDeleteMessage. Returns false if the message is
+// not deleted or was already purged.
+func (db *DB) RestoreMessage(ctx context.Context, messageID int64) (bool, error) {
+	return db.tombstoneMessage(ctx, "UPDATE messages SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL", messageID)
+}
+
+// SoftDeleteUser tombstones a user account. The user can no longer be looked
+// up or log in and disappears from contact lists; the username stays taken
+// until the account is purged. Returns false if the user does not exist or is
+// already deleted.
+func (db *DB) SoftDeleteUser(ctx context.Context, userID int64) (bool, error) {
+	return db.tombstone(ctx, "UPDATE users SET deleted_at = EXTRACT(EPOCH FROM NOW())::BIGINT WHERE id = $1 AND deleted_at IS NULL", userID)
+}
+
+// RestoreUser undoes SoftDeleteUser. Returns false if the user is not deleted
+// or was already purged.
+func (db *DB) RestoreUser(ctx context.Context, userID int64) (bool, error) {
+	return db.tombstone(ctx, "UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL", userID)
+}
+
+// UsernameExists reports whether a username is taken, including by a deleted
+// account that has not been purged yet
+func (db *DB) UsernameExists(ctx context.Context, username string) (bool, error) {
+	var count int
+	err := db.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE LOWER(username) = LOWER($1)", username).Scan(&count)
+	return count > 0, err
+}
+
+// tombstoneMessage is tombstone for a message, which may be on any message
+// shard
+func (db *DB) tombstoneMessage(ctx context.Context, query string, messageID int64) (bool, error) {
+	for _, mdb := range db.messageDBs() {
+		if changed, err := mdb.tombstone(ctx, query, messageID); err != nil || changed {
+			return changed, err
+		}
+	}
+	return false, nil
+}
+
+func (db *DB) tombstone(ctx context.Context, query string, id int64) (bool, error) {
+	result, err := db.q.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// deletedMessagesQuery selects the messages deleted more than $1 days ago,
+// as a candidate query for purgeCandidates
+const deletedMessagesQuery = `
+		SELECT id FROM messages
+		WHERE deleted_at IS NOT NULL AND deleted_at < EXTRACT(EPOCH FROM NOW())::BIGINT - $1::BIGINT * 86400`
+
+// purgeDeletedUsers permanently deletes the users deleted more than days ago
+// (or, in dry-run mode, counts them)
+func (db *DB) purgeDeletedUsers(ctx context.Context, days int, dryRun bool) (int64, error) {
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT id FROM users WHERE deleted_at IS NOT NULL AND deleted_at < EXTRACT(EPOCH FROM NOW())::BIGINT - $1::BIGINT * 86400",
+		days,
+	)
+	if err != nil {
+		return 0, err
+	}
+	userIDs, err := scanIDs(rows)
+	if err != nil || dryRun {
+		return int64(len(userIDs)), err
+	}
+
+	var purged int64
+	for _, userID := range userIDs {
+		if _, err := db.PurgeUser(ctx, userID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// PurgeUser permanently deletes a user and everything that belongs to them,
+// for account deletion and erasure requests: their chats with all messages,
+// files, keys and per-user state, then their contacts and the account itself.
+// Chats are 1:1, so the other participant of each chat loses it as well.
+// Everything runs in one transaction; rows are deleted explicitly, chats in
+// ID order, rather than through ON DELETE CASCADE, so the order of locks is
+// the same on every run and every driver. File blobs are queued for the file
+// janitor. Returns false if the user does not exist.
+func (db *DB) PurgeUser(ctx context.Context, userID int64) (bool, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM chats WHERE user1_id = $1 OR user2_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return false, err
+	}
+	chatIDs, err := scanIDs(rows)
+	if err != nil {
+		return false, err
+	}
+	placed := make(map[int64]int)
+	for _, chatID := range chatIDs {
+		if db.shards != nil {
+			if placed[chatID], err = db.chatShard(ctx, chatID); err != nil {
+				return false, err
+			}
+		}
+		if _, err := deleteChatRows(ctx, tx, chatID); err != nil {
+			return false, err
+		}
+	}
+
+	// All of these live in the user's chats and are gone by now; the deletes
+	// catch rows left behind by databases that predate the cascades
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO blob_deletions (blob_key) SELECT blob_key FROM files WHERE owner_id = $1 ON CONFLICT DO NOTHING",
+		userID,
+	); err != nil {
+		return false, err
+	}
+	stmts := []string{
+		"DELETE FROM message_search_tokens WHERE message_id IN (SELECT id FROM messages WHERE sender_id = $1)",
+		"DELETE FROM message_attachments WHERE message_id IN (SELECT id FROM messages WHERE sender_id = $1)",
+		"DELETE FROM chat_pins WHERE pinned_by = $1",
+		"DELETE FROM messages_archive WHERE sender_id = $1",
+		"DELETE FROM messages WHERE sender_id = $1",
+		"DELETE FROM file_thumbnails WHERE file_id IN (SELECT id FROM files WHERE owner_id = $1)",
+		"DELETE FROM files WHERE owner_id = $1",
+		"DELETE FROM upload_sessions WHERE user_id = $1",
+		"DELETE FROM chat_read_markers WHERE user_id = $1",
+		"DELETE FROM chat_last_seen WHERE user_id = $1",
+		"DELETE FROM chat_notification_prefs WHERE user_id = $1",
+		"DELETE FROM chat_drafts WHERE user_id = $1",
+		"DELETE FROM ratchet_chains WHERE sender_id = $1",
+		"DELETE FROM chat_verifications WHERE user_id = $1",
+		"DELETE FROM contact_verifications WHERE user_id = $1 OR contact_id = $1",
+		"DELETE FROM dh_public_keys WHERE user_id = $1",
+		"DELETE FROM dh_exchange_nonces WHERE user_id = $1",
+		"DELETE FROM chat_key_shares WHERE sender_device_id IN (SELECT id FROM devices WHERE user_id = $1) OR recipient_device_id IN (SELECT id FROM devices WHERE user_id = $1)",
+		"DELETE FROM devices WHERE user_id = $1",
+		"DELETE FROM key_backups WHERE user_id = $1",
+		"DELETE FROM contacts WHERE user1_id = $1 OR user2_id = $1 OR requester_id = $1",
+	}
+	for _, s := range stmts {
+		if _, err := tx.ExecContext(ctx, s, userID); err != nil {
+			return false, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	for chatID, shard := range placed {
+		db.dropChatFromShard(ctx, chatID, shard)
+	}
+	return true, nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+// countRows runs a SELECT COUNT(*) query and returns the count
+func countRows(t *testing.T, db *DB, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.q.QueryRowContext(context.Background(), query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+func TestPurgeUser(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	newUser := func(name string) int64 {
+		id, err := db.CreateUser(ctx, name, "hash")
+		must(err)
+		return id
+	}
+	alice, bob, carol := newUser("alice"), newUser("bob"), newUser("carol")
+
+	_, err := db.AddContact(ctx, alice, bob, "accepted")
+	must(err)
+	_, err = db.AddContact(ctx, bob, carol, "accepted")
+	must(err)
+	shared, err := db.CreateChat(ctx, alice, bob, "direct", "AES", "CBC", "PKCS7")
+	must(err)
+	other, err := db.CreateChat(ctx, bob, carol, "direct", "AES", "CBC", "PKCS7")
+	must(err)
+
+	send := func(chatID, senderID int64) {
+		_, _, _, err := db.SaveMessage(ctx, &NewMessage{ChatID: chatID, SenderID: senderID, Ciphertext: []byte("ciphertext"), IV: make([]byte, 16)})
+		must(err)
+	}
+	send(shared, alice)
+	send(shared, bob)
+	send(other, bob)
+	send(other, carol)
+
+	upload := func(ownerID, chatID int64, blobKey string) {
+		must(db.CompleteUpload(ctx, "", &File{OwnerID: ownerID, ChatID: chatID, BlobKey: blobKey, FileName: "f", MimeType: "text/plain", Size: 1}))
+	}
+	upload(alice, shared, "alice-shared")
+	upload(bob, shared, "bob-shared")
+	upload(bob, other, "bob-other")
+	must(db.CreateUploadSession(ctx, &UploadSession{ID: "alice-upload", UserID: alice, ChatID: shared, FileName: "f", MimeType: "text/plain", TotalSize: 1}))
+
+	for _, id := range []int64{alice, bob} {
+		_, err := db.CreateDevice(ctx, id, "phone", []byte("public"), []byte("private"))
+		must(err)
+		must(db.SaveKeyBackup(ctx, id, []byte("backup"), "argon2id"))
+	}
+
+	purged, err := db.PurgeUser(ctx, alice)
+	if err != nil || !purged {
+		t.Fatalf("PurgeUser: purged=%v err=%v", purged, err)
+	}
+
+	checks := []struct {
+		name  string
+		query string
+		arg   int64
+		want  int
+	}{
+		{"user", "SELECT COUNT(*) FROM users WHERE id = $1", alice, 0},
+		{"shared chat", "SELECT COUNT(*) FROM chats WHERE id = $1", shared, 0},
+		{"shared chat messages", "SELECT COUNT(*) FROM messages WHERE chat_id = $1", shared, 0},
+		{"shared chat files", "SELECT COUNT(*) FROM files WHERE chat_id = $1", shared, 0},
+		{"upload sessions", "SELECT COUNT(*) FROM upload_sessions WHERE user_id = $1", alice, 0},
+		{"devices", "SELECT COUNT(*) FROM devices WHERE user_id = $1", alice, 0},
+		{"key backups", "SELECT COUNT(*) FROM key_backups WHERE user_id = $1", alice, 0},
+		{"contacts", "SELECT COUNT(*) FROM contacts WHERE user1_id = $1 OR user2_id = $1", alice, 0},
+		{"other user", "SELECT COUNT(*) FROM users WHERE id = $1", bob, 1},
+		{"other chat", "SELECT COUNT(*) FROM chats WHERE id = $1", other, 1},
+		{"other chat messages", "SELECT COUNT(*) FROM messages WHERE chat_id = $1", other, 2},
+		{"other chat files", "SELECT COUNT(*) FROM files WHERE chat_id = $1", other, 1},
+		{"other user's devices", "SELECT COUNT(*) FROM devices WHERE user_id = $1", bob, 1},
+		{"other user's key backups", "SELECT COUNT(*) FROM key_backups WHERE user_id = $1", bob, 1},
+		{"other contacts", "SELECT COUNT(*) FROM contacts WHERE user1_id = $1 OR user2_id = $1", carol, 1},
+	}
+	for _, c := range checks {
+		if got := countRows(t, db, c.query, c.arg); got != c.want {
+			t.Errorf("%s: got %d rows, want %d", c.name, got, c.want)
+		}
+	}
+	if got := countRows(t, db, "SELECT COUNT(*) FROM blob_deletions WHERE blob_key IN ('alice-shared', 'bob-shared')"); got != 2 {
+		t.Errorf("blob deletions: got %d, want 2", got)
+	}
+	if got := countRows(t, db, "SELECT COUNT(*) FROM blob_deletions WHERE blob_key = 'bob-other'"); got != 0 {
+		t.Errorf("the other chat's blob was queued for deletion")
+	}
+
+	for _, id := range []int64{alice, 9999} {
+		purged, err := db.PurgeUser(ctx, id)
+		if err != nil || purged {
+			t.Errorf("PurgeUser(%d) of an unknown user: purged=%v err=%v", id, purged, err)
+		}
+	}
+}
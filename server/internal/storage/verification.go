@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SASConfirmation records that a participant confirmed the short
+// authentication string of a chat's key exchange. Transcript is the hash of
+// the key exchange they confirmed.
+type SASConfirmation struct {
+	UserID      int64  `json:"user_id"`
+	Transcript  []byte `json:"transcript"`
+	ConfirmedAt int64  `json:"confirmed_at"`
+}
+
+// SaveSASConfirmation records or replaces a user's SAS confirmation for a chat
+func (db *DB) SaveSASConfirmation(ctx context.Context, chatID, userID int64, transcript []byte) error {
+	_, err := db.q.ExecContext(ctx,
+		`INSERT INTO chat_verifications (chat_id, user_id, transcript, confirmed_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET transcript = $3, confirmed_at = $4`,
+		chatID, userID, transcript, time.Now().Unix(),
+	)
+	return err
+}
+
+// GetSASConfirmations returns the SAS confirmations of a chat, ordered by user
+func (db *DB) GetSASConfirmations(ctx context.Context, chatID int64) ([]*SASConfirmation, error) {
+	rows, err := db.q.QueryContext(ctx,
+		"SELECT user_id, transcript, confirmed_at FROM chat_verifications WHERE chat_id = $1 ORDER BY user_id",
+		chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var confirmations []*SASConfirmation
+	for rows.Next() {
+		c := &SASConfirmation{}
+		if err := rows.Scan(&c.UserID, &c.Transcript, &c.ConfirmedAt); err != nil {
+			return nil, err
+		}
+		confirmations = append(confirmations, c)
+	}
+	return confirmations, rows.Err()
+}
+
+// DeleteSASConfirmations removes the SAS confirmations of both participants
+// of a chat and reports whether there were any
+func (db *DB) DeleteSASConfirmations(ctx context.Context, chatID int64) (bool, error) {
+	result, err := db.q.ExecContext(ctx, "DELETE FROM chat_verifications WHERE chat_id = $1", chatID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ContactVerification records that a user checked the identity key of a
+// contact and signed it with their own (see crypto.SignContact)
+type ContactVerification struct {
+	IdentityKey []byte `json:"identity_key"`
+	Signature   []byte `json:"signature"`
+	VerifiedAt  int64  `json:"verified_at"`
+}
+
+// SaveContactVerification records or replaces the identity key a user
+// verified for a contact
+func (db *DB) SaveContactVerification(ctx context.Context, userID, contactID int64, identityKey, signature []byte) error {
+	_, err := db.q.ExecContext(ctx,
+		`INSERT INTO contact_verifications (user_id, contact_id, identity_key, signature, verified_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, contact_id) DO UPDATE SET identity_key = $3, signature = $4, verified_at = $5`,
+		userID, contactID, identityKey, signature, time.Now().Unix(),
+	)
+	return err
+}
+
+// GetContactVerification returns the identity key a user verified for a
+// contact, or nil if they have not verified one
+func (db *DB) GetContactVerification(ctx context.Context, userID, contactID int64) (*ContactVerification, error) {
+	v := &ContactVerification{}
+	err := db.q.QueryRowContext(ctx,
+		"SELECT identity_key, signature, verified_at FROM contact_verifications WHERE user_id = $1 AND contact_id = $2",
+		userID, contactID,
+	).Scan(&v.IdentityKey, &v.Signature, &v.VerifiedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}